@@ -0,0 +1,159 @@
+package chainhook
+
+import (
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// ChainHook is an interface which provides access to a blockchain for the
+// wallit.  uspv (with bloom filters, full blocks or compact filters),
+// fullnode, electrum and powless conform to it, and New() picks one for a
+// coin's host string.
+
+/*  The model here is that the wallit has lots of state on disk about
+what it's seen.  The ChainHook is ephemeral, and has some state in RAM but
+shouldn't have to keep track of too much; or at least what it keeps track of
+is internal and not exported out to the wallit (eg a fullnode keeps track
+of a lot, and SPV node, somewhat less, and a block explorer shim basically nothing)
+*/
+
+type ChainHook interface {
+
+	// Start turns on the ChainHook.  Later on, pass more parameters here.
+	// Also gets the txChan where txs come in from the ChainHook to the wallit.
+	// The TxChannel should never give txs that the wallit doesn't care about.  In the
+	// case of bloom filters, false positives should be handled and stopped at the
+	// ChainHook layer; wallit should not have to handle ingesting irrelevant txs.
+	// You get back an error and 2 channels: one for txs with height attached, and
+	// one with block heights.  Subject to change; maybe this is redundant.
+
+	// Note that for reorgs, the height chan just sends a lower height than you
+	// already have, and that means "reorg back!"
+	Start(height int32, host, path string, params *coinparam.Params) (
+		chan lnutil.TxAndHeight, chan int32, error)
+
+	// The Register functions send information to the ChainHook about what txs to
+	// return.  Txs matching either the addresses or outpoints will be returned
+	// via the TxChannel
+
+	// RegisterAddress tells the ChainHook about an address of interest.
+	// Give it an array; Currently needs to be 20 bytes.  Only p2pkh / p2wpkh
+	// are supported.
+	// Later could add a separate function for script hashes (20/32)
+	RegisterAddress(address [20]byte) error
+
+	// RegisterTaprootKey tells the ChainHook about a taproot output key of
+	// interest.  Outputs with that 32 byte witness v1 program get returned.
+	RegisterTaprootKey(outKey [32]byte) error
+
+	// RegisterOutPoint tells the ChainHook about an outpoint of interest.
+	RegisterOutPoint(wire.OutPoint) error
+
+	// Rescan goes back over the chain from fromHeight, sending up txs that
+	// match what's registered now, for addresses that got registered after
+	// their txs went by.  Heights come back over the height chan again,
+	// starting below the tip, so they're not a reorg during a rescan.
+	Rescan(fromHeight int32) error
+
+	// RescanScripts is a Rescan for txs with just these scripts, like the
+	// addresses of imported keys, up to toHeight.  Hooks that can look for
+	// them without going over whole blocks, like with compact filters, do;
+	// others just Rescan.
+	RescanScripts(fromHeight, toHeight int32, scripts [][]byte) error
+
+	// BlockHash gives the hash of the block at a height in the chain the
+	// hook is following.
+	BlockHash(height int32) (*chainhash.Hash, error)
+
+	// SetHeight sets the height ChainHook needs to look above.
+	// Returns a channel which tells the wallit what height the ChainHook has
+	// sync'd up to.  This chan should push int32s *after* the TxAndHeights
+	// have come in; so getting a "54" here means block 54 is done and fully parsed.
+	// Removed, put into Start().
+	//	SetHeight(startHeight int32) chan int32
+
+	// PushTx sends a tx out to the network via the ChainHook.
+	// Note that this does NOT register anything in the tx, so by just using this,
+	// nothing will come back about confirmation.  It WILL come back with errors
+	// though, so this takes some time.
+	PushTx(tx *wire.MsgTx) error
+
+	// RebroadcastTx sends out a tx that was pushed before and still isn't
+	// confirmed, through peers other than the usual one if the hook can get
+	// to some, in case it got dropped.
+	RebroadcastTx(tx *wire.MsgTx) error
+
+	// Request all incoming blocks over this channel.  If RawBlocks isn't called,
+	// then the undelying hook package doesn't need to get full blocks.
+	// Currently you always call it with uspv...
+	RawBlocks() chan *wire.MsgBlock
+	// TODO -- reorgs.  Oh and doublespends and stuff.
+}
+
+/*
+type ChainHook interface {
+
+	Start() chan lnutil.TxAndHeight
+	RegisterAddress(address [20]byte) error
+	RegisterOutPoint(wire.OutPoint) error
+	SetHeight(startHeight int32) chan int32
+	PushTx(tx *wire.MsgTx) error
+	RawBlocks() chan *wire.MsgBlock
+}
+*/
+
+// The rest are things a ChainHook can do if it wants; callers check with a
+// type assertion.
+
+// ScriptHook is a ChainHook that wants the scripts of outpoints, like uspv
+// with compact filters, which finds spends by the script spent.
+// NeedScripts says if it does; without its script, an outpoint's spend
+// can only be found by getting every block.
+type ScriptHook interface {
+	RegisterOutPointScript(op wire.OutPoint, pkScript []byte) error
+	NeedScripts() bool
+}
+
+// UnwatchHook is a ChainHook that can stop looking for an outpoint's spend.
+type UnwatchHook interface {
+	UnregisterOutPoint(op wire.OutPoint) error
+}
+
+// FeeEstimator is a ChainHook that can say what fee rate, in sat per byte,
+// gets a tx confirmed within confTarget blocks.  The wallit uses it when
+// there's no fee rpc set.
+type FeeEstimator interface {
+	EstimateFee(confTarget uint32) (int64, error)
+}
+
+// MempoolHook is a ChainHook that can see txs before they confirm, every
+// one and not just the ones matching what's registered, so the watchtower
+// can catch a bad tx as soon as it's out.  The chan gets the txs; nothing
+// comes over it if the hook can't see them after all, like a fullnode
+// hook without zmq.  Call it before Start.
+type MempoolHook interface {
+	MempoolTxs() chan *wire.MsgTx
+}
+
+// TipHook is a ChainHook that knows how high the chain goes, past where
+// it's synced to, so we can tell how far behind we are.
+type TipHook interface {
+	TipHeight() int32
+}
+
+// RegtestHook is a ChainHook that can drive a regtest chain, so tests and
+// demos can make blocks and coins through lit.  Only on regtest coins.
+type RegtestHook interface {
+	// GenerateBlocks mines n blocks, paying the miner.
+	GenerateBlocks(n uint32) ([]chainhash.Hash, error)
+
+	// SendRawFromMiner has the miner's wallet make a tx paying amt to adr,
+	// sign it, and push it out.
+	SendRawFromMiner(adr string, amt int64) (chainhash.Hash, error)
+
+	// SetMockTime sets the miner's clock, in unix time; 0 goes back to the
+	// real time.
+	SetMockTime(t int64) error
+}
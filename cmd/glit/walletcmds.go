@@ -62,8 +62,20 @@ func (lu *litUiClient) Address() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	response := reply.WitAddresses[len(reply.WitAddresses)-1]
-	return response, nil
+	// give the newest address that hasn't been paid, so it's not reused
+	for i := len(reply.WitAddresses) - 1; i >= 0; i-- {
+		if i >= len(reply.Used) || !reply.Used[i] {
+			return reply.WitAddresses[i], nil
+		}
+	}
+	// all paid; make a new one
+	args.NumToMake = 1
+	reply = new(litrpc.AddressReply)
+	err = lu.rpccon.Call("LitRPC.Address", args, reply)
+	if err != nil {
+		return "", err
+	}
+	return reply.WitAddresses[0], nil
 }
 
 // Send sends coins somewhere
@@ -78,17 +78,75 @@ func (lc *litAfClient) NewAutoCompleter() readline.AutoCompleter {
 	var completer = readline.NewPrefixCompleter(
 		readline.PcItem("help",
 			readline.PcItem("say"),
+			readline.PcItem("chat"),
 			readline.PcItem("ls"),
 			readline.PcItem("con"),
 			readline.PcItem("lis"),
+			readline.PcItem("seeds"),
+			readline.PcItem("addcontact"),
+			readline.PcItem("rmcontact"),
+			readline.PcItem("contacts"),
 			readline.PcItem("adr"),
 			readline.PcItem("send"),
+			readline.PcItem("sendmany"),
+			readline.PcItem("bumpfee"),
+			readline.PcItem("cpfp"),
+			readline.PcItem("lock"),
+			readline.PcItem("unlock"),
+			readline.PcItem("locks"),
+			readline.PcItem("broadcasts"),
+			readline.PcItem("checkdb"),
+			readline.PcItem("debug"),
+			readline.PcItem("loglevel"),
+			readline.PcItem("plugins"),
+			readline.PcItem("plugin"),
+			readline.PcItem("alias"),
+			readline.PcItem("pair"),
+			readline.PcItem("remote"),
+			readline.PcItem("custom"),
+			readline.PcItem("customallow"),
+			readline.PcItem("unspent"),
+			readline.PcItem("label"),
+			readline.PcItem("labels"),
+			readline.PcItem("rescan"),
+			readline.PcItem("sync"),
+			readline.PcItem("subscribe"),
+			readline.PcItem("events"),
+			readline.PcItem("unsubscribe"),
+			readline.PcItem("generate"),
+			readline.PcItem("minersend"),
+			readline.PcItem("mocktime"),
+			readline.PcItem("importxpub"),
+			readline.PcItem("xpubs"),
+			readline.PcItem("psbt"),
+			readline.PcItem("xpubsend"),
+			readline.PcItem("pushpsbt"),
+			readline.PcItem("newaccount"),
+			readline.PcItem("accounts"),
+			readline.PcItem("coinselect"),
 			readline.PcItem("fan"),
 			readline.PcItem("sweep"),
+			readline.PcItem("sweepall"),
+			readline.PcItem("consolidate"),
 			readline.PcItem("fund"),
 			readline.PcItem("push"),
+			readline.PcItem("trace"),
+			readline.PcItem("swapoffer"),
+			readline.PcItem("swapaccept"),
+			readline.PcItem("swapdecline"),
+			readline.PcItem("swaps"),
+			readline.PcItem("payreq"),
+			readline.PcItem("payreqs"),
+			readline.PcItem("payreqpay"),
+			readline.PcItem("payreqdecline"),
+			readline.PcItem("loopin"),
+			readline.PcItem("loopout"),
+			readline.PcItem("subswapaccept"),
+			readline.PcItem("subswapdecline"),
+			readline.PcItem("subswaps"),
 			readline.PcItem("close"),
 			readline.PcItem("break"),
+			readline.PcItem("audit"),
 			readline.PcItem("stop"),
 			readline.PcItem("exit"),
 		),
@@ -102,10 +160,28 @@ func (lc *litAfClient) NewAutoCompleter() readline.AutoCompleter {
 		readline.PcItem("send"),
 		readline.PcItem("fan"),
 		readline.PcItem("sweep"),
+		readline.PcItem("sweepall"),
 		readline.PcItem("fund",
 			readline.PcItemDynamic(lc.completePeers)),
 		readline.PcItem("push",
 			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("swapoffer",
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("swapaccept"),
+		readline.PcItem("swapdecline"),
+		readline.PcItem("swaps"),
+		readline.PcItem("payreq",
+			readline.PcItemDynamic(lc.completePeers)),
+		readline.PcItem("payreqs"),
+		readline.PcItem("payreqpay"),
+		readline.PcItem("payreqdecline"),
+		readline.PcItem("loopin",
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("loopout",
+			readline.PcItemDynamic(lc.completeChannelIdx)),
+		readline.PcItem("subswapaccept"),
+		readline.PcItem("subswapdecline"),
+		readline.PcItem("subswaps"),
 		readline.PcItem("close",
 			readline.PcItemDynamic(lc.completeChannelIdx)),
 		readline.PcItem("break",
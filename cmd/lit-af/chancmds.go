@@ -4,6 +4,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/litrpc"
@@ -12,12 +14,15 @@ import (
 
 var fundCommand = &Command{
 	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("fund"),
-		lnutil.ReqColor("peer", "coinType", "capacity", "initialSend"), lnutil.OptColor("data")),
-	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		lnutil.ReqColor("peer", "coinType", "capacity", "initialSend"),
+		lnutil.OptColor("data", "change=address|type|@account")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n",
 		"Establish and fund a new lightning channel with the given peer.",
+		"The peer can be a contact name from the address book.",
 		"The capacity is the amount of satoshi we insert into the channel,",
 		"and initialSend is the amount we initially hand over to the other party.",
 		"data is an optional field that can contain 32 bytes of hex to send as part of the channel fund",
+		"change= sends the funding tx's change somewhere else, as in send.",
 	),
 	ShortDescription: "Establish and fund a new lightning channel with the given peer.\n",
 }
@@ -31,6 +36,32 @@ var watchCommand = &Command{
 	ShortDescription: "Send channel watch data to watcher.\n",
 }
 
+var unwatchCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("unwatch"),
+		lnutil.ReqColor("channel idx", "watchPeerIdx")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Stop sending channel data to a watcher.",
+		"Other watchers of the channel keep getting it."),
+	ShortDescription: "Stop sending channel watch data to watcher.\n",
+}
+
+var autowatchCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("autowatch"),
+		lnutil.ReqColor("channel idx", "on|off|default")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Set whether the channel's states are sent to watchers automatically.",
+		"default does what the node does: only if it was started with --autowatch."),
+	ShortDescription: "Set whether a channel is sent to watchers automatically.\n",
+}
+
+var towersCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.White("towers")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Show the watchers your channels are sent to, and for each channel",
+		"how many states the watcher hasn't acked, or hasn't been sent yet."),
+	ShortDescription: "Show what each watcher has of your channels.\n",
+}
+
 var pushCommand = &Command{
 	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.White("push"), lnutil.ReqColor("channel idx", "amount"), lnutil.OptColor("times"), lnutil.OptColor("data")),
 	Description: fmt.Sprintf("%s\n%s\n%s\n",
@@ -40,6 +71,15 @@ var pushCommand = &Command{
 	ShortDescription: "Push the given amount (in satoshis) to the other party on the given channel.\n",
 }
 
+var traceCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("trace"), lnutil.OptColor("trace id")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show when a push got through each stage of the state update, and how long",
+		"each took; push shows the trace id.  Without one, show the last 10 pushes.",
+		"sigsent to sigreceived is the peer and network; saved follows a db write."),
+	ShortDescription: "Show how long pushes took, stage by stage.\n",
+}
+
 var closeCommand = &Command{
 	Format: fmt.Sprintf("%s%s\n", lnutil.White("close"), lnutil.ReqColor("channel idx")),
 	Description: fmt.Sprintf("%s\n%s\n%s%s\n",
@@ -86,6 +126,77 @@ func (lc *litAfClient) History(textArgs []string) error {
 	return nil
 }
 
+var auditCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("audit"),
+		lnutil.OptColor("from"), lnutil.OptColor("max")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show the audit log: channel opens and closes, pushes, rpc connections",
+		"and key dumps, from sequence number from on (the start by default).",
+		"\"audit verify\" checks the log's MAC chain."),
+	ShortDescription: "Show or verify the audit log.\n",
+}
+
+func (lc *litAfClient) Audit(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, auditCommand.Format)
+		fmt.Fprintf(color.Output, auditCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) > 0 && textArgs[0] == "verify" {
+		reply := new(litrpc.VerifyAuditReply)
+		err := lc.Call("LitRPC.VerifyAudit", new(litrpc.NoArgs), reply)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(color.Output, "audit log good, %s events\n", lnutil.White(reply.Events))
+		return nil
+	}
+
+	args := new(litrpc.AuditEventsArgs)
+	reply := new(litrpc.AuditEventsReply)
+	if len(textArgs) > 0 {
+		from, err := strconv.ParseUint(textArgs[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		args.From = from
+	}
+	if len(textArgs) > 1 {
+		max, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.Max = max
+	}
+
+	err := lc.Call("LitRPC.AuditEvents", args, reply)
+	if err != nil {
+		return err
+	}
+	for _, e := range reply.Events {
+		fmt.Fprintf(color.Output, "%6d %s %s", e.Seq,
+			time.Unix(e.Time, 0).Format("2006-01-02 15:04:05"), lnutil.White(e.Type))
+		if e.Peer != 0 {
+			fmt.Fprintf(color.Output, " peer %d", e.Peer)
+		}
+		if e.ChanIdx != 0 {
+			fmt.Fprintf(color.Output, " chan %d", e.ChanIdx)
+		}
+		if e.Amt != 0 {
+			fmt.Fprintf(color.Output, " %s", lnutil.SatoshiColor(e.Amt))
+		}
+		if e.Txid != "" {
+			fmt.Fprintf(color.Output, " txid %s", e.Txid)
+		}
+		if e.Detail != "" {
+			fmt.Fprintf(color.Output, " %s", e.Detail)
+		}
+		fmt.Fprintf(color.Output, "\n")
+	}
+	return nil
+}
+
 func CheckHelpCommand(command *Command, textArgs []string, expectedLength int) error {
 	if len(textArgs) > 0 && textArgs[0] == "-h" {
 		fmt.Fprintf(color.Output, command.Format)
@@ -106,9 +217,10 @@ func (lc *litAfClient) FundChannel(textArgs []string) error {
 	args := new(litrpc.FundArgs)
 	reply := new(litrpc.StatusReply)
 
+	// not a number means a contact
 	peer, err := strconv.Atoi(textArgs[0])
 	if err != nil {
-		return err
+		args.Contact = textArgs[0]
 	}
 	coinType, err := strconv.Atoi(textArgs[1])
 	if err != nil {
@@ -124,6 +236,17 @@ func (lc *litAfClient) FundChannel(textArgs []string) error {
 		return err
 	}
 
+	// change can go anywhere after the required args
+	var dataArgs []string
+	for _, a := range textArgs[4:] {
+		if strings.HasPrefix(a, "change=") {
+			changeArg(a, &args.ChangeAddress, &args.ChangeType, &args.ChangeAccount)
+		} else {
+			dataArgs = append(dataArgs, a)
+		}
+	}
+	textArgs = append(textArgs[:4], dataArgs...)
+
 	if len(textArgs) > 4 {
 		data, err := hex.DecodeString(textArgs[4])
 		if err != nil {
@@ -246,13 +369,52 @@ func (lc *litAfClient) Push(textArgs []string) error {
 		if err != nil {
 			return err
 		}
-		fmt.Fprintf(color.Output, "Pushed %s at state %s\n", lnutil.SatoshiColor(int64(amt)), lnutil.White(reply.StateIndex))
+		fmt.Fprintf(color.Output, "Pushed %s at state %s, trace %d\n", lnutil.SatoshiColor(int64(amt)), lnutil.White(reply.StateIndex), reply.TraceID)
 		times--
 	}
 
 	return nil
 }
 
+func (lc *litAfClient) Trace(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, traceCommand.Format)
+		fmt.Fprintf(color.Output, traceCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.TracePaymentArgs)
+	reply := new(litrpc.TracePaymentReply)
+	if len(textArgs) > 0 {
+		id, err := strconv.ParseUint(textArgs[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		args.TraceID = id
+	}
+
+	err := lc.Call("LitRPC.TracePayment", args, reply)
+	if err != nil {
+		return err
+	}
+	for _, pt := range reply.Traces {
+		way := "received"
+		if pt.Sent {
+			way = "sent"
+		}
+		fmt.Fprintf(color.Output, "trace %s: %s %s on chan %d, peer %d\n",
+			lnutil.White(pt.ID), way, lnutil.SatoshiColor(pt.Amt), pt.ChanIdx, pt.Peer)
+		for _, st := range pt.Stages {
+			fmt.Fprintf(color.Output, "\t%-12s +%s %s\n", st.Stage, st.Since, st.Detail)
+		}
+		if len(pt.Stages) != 0 {
+			total := time.Duration(pt.Stages[len(pt.Stages)-1].At - pt.Start)
+			fmt.Fprintf(color.Output, "\ttotal %s\n", total)
+		}
+	}
+	return nil
+}
+
 func (lc *litAfClient) Dump(textArgs []string) error {
 	pReply := new(litrpc.DumpReply)
 	pArgs := new(litrpc.NoArgs)
@@ -317,3 +479,104 @@ func (lc *litAfClient) Watch(textArgs []string) error {
 
 	return nil
 }
+
+func (lc *litAfClient) AutoWatch(textArgs []string) error {
+	err := CheckHelpCommand(autowatchCommand, textArgs, 2)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.AutoWatchArgs)
+	reply := new(litrpc.StatusReply)
+
+	cIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+
+	args.ChanIdx = uint32(cIdx)
+	args.Policy = textArgs[1]
+
+	err = lc.Call("LitRPC.AutoWatch", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+
+	return nil
+}
+
+func (lc *litAfClient) Towers(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, towersCommand.Format)
+		fmt.Fprintf(color.Output, towersCommand.Description)
+		return nil
+	}
+
+	args := new(litrpc.NoArgs)
+	reply := new(litrpc.TowerStatusReply)
+
+	err := lc.Call("LitRPC.TowerStatus", args, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Towers) == 0 {
+		fmt.Fprintf(color.Output, "no channels sent to watchers\n")
+		return nil
+	}
+
+	for _, t := range reply.Towers {
+		online := lnutil.Red("offline")
+		if t.Connected {
+			online = lnutil.Green("online")
+		}
+		last := "never"
+		if t.LastContact != 0 {
+			last = time.Unix(t.LastContact, 0).Format(time.RFC3339)
+		}
+		fmt.Fprintf(color.Output, "%s %d %s, last contact %s\n",
+			lnutil.Header("Tower"), t.PeerIdx, online, last)
+		for _, c := range t.Channels {
+			fmt.Fprintf(color.Output,
+				"\t%s %d state %d sent %d acked %d unacked %d backlog %d\n",
+				lnutil.White("Channel"), c.ChanIdx, c.StateIdx, c.Sent, c.Acked,
+				c.Unacked, c.Backlog)
+		}
+	}
+
+	return nil
+}
+
+func (lc *litAfClient) Unwatch(textArgs []string) error {
+	err := CheckHelpCommand(unwatchCommand, textArgs, 2)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.WatchArgs)
+	reply := new(litrpc.StatusReply)
+
+	cIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+
+	peer, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+
+	args.ChanIdx = uint32(cIdx)
+	args.SendToPeer = uint32(peer)
+
+	err = lc.Call("LitRPC.Unwatch", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+
+	return nil
+}
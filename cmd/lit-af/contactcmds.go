@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var addcontactCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("addcontact"),
+		lnutil.ReqColor("name", "address"), lnutil.OptColor("address")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Put a name in the address book for an on-chain address, a lit address",
+		"(ln1...[@host:port]), or one of each.  The name then works in place of",
+		"the address in send, con and fund.  Adding a name again replaces it."),
+	ShortDescription: "Add a contact to the address book.\n",
+}
+
+var rmcontactCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("rmcontact"), lnutil.ReqColor("name")),
+	Description:      "Take a contact out of the address book.\n",
+	ShortDescription: "Remove a contact from the address book.\n",
+}
+
+var contactsCommand = &Command{
+	Format:           fmt.Sprintf("%s\n", lnutil.White("contacts")),
+	Description:      "Show the address book.\n",
+	ShortDescription: "Show the address book.\n",
+}
+
+func (lc *litAfClient) AddContact(textArgs []string) error {
+	err := CheckHelpCommand(addcontactCommand, textArgs, 2)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.AddContactArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.Name = textArgs[0]
+	for _, a := range textArgs[1:] {
+		if strings.HasPrefix(a, "ln1") {
+			args.LitAdr = a
+		} else {
+			args.Address = a
+		}
+	}
+
+	err = lc.Call("LitRPC.AddContact", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) RmContact(textArgs []string) error {
+	err := CheckHelpCommand(rmcontactCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ContactArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.Name = textArgs[0]
+
+	err = lc.Call("LitRPC.RemoveContact", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) Contacts(textArgs []string) error {
+	err := CheckHelpCommand(contactsCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	reply := new(litrpc.ContactsReply)
+
+	err = lc.Call("LitRPC.ListContacts", nil, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Contacts) == 0 {
+		fmt.Fprintf(color.Output, "no contacts\n")
+	}
+	for _, c := range reply.Contacts {
+		fmt.Fprintf(color.Output, "%s", lnutil.White(c.Name))
+		if c.Address != "" {
+			fmt.Fprintf(color.Output, " %s", lnutil.Address(c.Address))
+		}
+		if c.LitAdr != "" {
+			fmt.Fprintf(color.Output, " %s", c.LitAdr)
+		}
+		fmt.Fprintf(color.Output, "\n")
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/litrpc"
 	"github.com/mit-dci/lit/lnutil"
@@ -84,7 +85,7 @@ var contractCommand = &Command{
 	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract"),
 		lnutil.ReqColor("subcommand"), lnutil.OptColor("parameters...")),
 	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n"+
-		"%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
+		"%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n%s\n",
 		"Command for managing contracts. Subcommand can be one of:",
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("new"),
@@ -125,6 +126,9 @@ var contractCommand = &Command{
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("settle"),
 			"Settles the contract"),
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("refund"),
+			"Refunds the contract if the oracle didn't publish"),
 		fmt.Sprintf("%-20s %s",
 			lnutil.White("ls"),
 			"Shows a list of known contracts"),
@@ -300,20 +304,23 @@ var declineContractCommand = &Command{
 	ShortDescription: "Declines a contract offered to you\n",
 }
 var acceptContractCommand = &Command{
-	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract accept"),
-		lnutil.ReqColor("cid")),
-	Description: fmt.Sprintf("%s\n%s\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract accept"),
+		lnutil.ReqColor("cid"), lnutil.OptColor("norefund")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
 		"Accepts a contract offered to you",
 		fmt.Sprintf("%-10s %s",
 			lnutil.White("cid"),
 			"The ID of the contract to accept"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("norefund"),
+			"Fund it even if the peer doesn't sign a refund"),
 	),
 	ShortDescription: "Accepts a contract offered to you\n",
 }
 var offerContractCommand = &Command{
-	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract offer"),
-		lnutil.ReqColor("cid", "peer")),
-	Description: fmt.Sprintf("%s\n%s\n%s\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("dlc contract offer"),
+		lnutil.ReqColor("cid", "peer"), lnutil.OptColor("norefund")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
 		"Offers a contract to one of your peers",
 		fmt.Sprintf("%-10s %s",
 			lnutil.White("cid"),
@@ -321,6 +328,9 @@ var offerContractCommand = &Command{
 		fmt.Sprintf("%-10s %s",
 			lnutil.White("cointype"),
 			"The ID of the peer to offer the contract to"),
+		fmt.Sprintf("%-10s %s",
+			lnutil.White("norefund"),
+			"Fund it even if the peer doesn't sign a refund"),
 	),
 	ShortDescription: "Offers a contract to one of your peers\n",
 }
@@ -342,6 +352,19 @@ var settleContractCommand = &Command{
 	ShortDescription: "Settles the contract\n",
 }
 
+var refundContractCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("dlc contract refund"),
+		lnutil.ReqColor("cid")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Pays both sides their funding back, for when the oracle didn't publish.",
+		"Works from a week after the settlement time.",
+		fmt.Sprintf("%-20s %s",
+			lnutil.White("cid"),
+			"The ID of the contract"),
+	),
+	ShortDescription: "Refunds the contract\n",
+}
+
 func (lc *litAfClient) Dlc(textArgs []string) error {
 	if len(textArgs) > 0 && textArgs[0] == "-h" {
 		fmt.Fprintf(color.Output, dlcCommand.Format)
@@ -518,6 +541,10 @@ func (lc *litAfClient) DlcContract(textArgs []string) error {
 	if cmd == "settle" {
 		return lc.DlcSettleContract(textArgs)
 	}
+
+	if cmd == "refund" {
+		return lc.DlcRefundContract(textArgs)
+	}
 	return fmt.Errorf(contractCommand.Format)
 }
 
@@ -857,6 +884,7 @@ func (lc *litAfClient) DlcOfferContract(textArgs []string) error {
 
 	args.CIdx = cIdx
 	args.PeerIdx = uint32(peerIdx)
+	args.AllowNoRefund = len(textArgs) > 2 && textArgs[2] == "norefund"
 
 	err = lc.Call("LitRPC.OfferContract", args, reply)
 	if err != nil {
@@ -909,6 +937,7 @@ func (lc *litAfClient) DlcAcceptContract(textArgs []string) error {
 	}
 
 	args.CIdx = cIdx
+	args.AllowNoRefund = len(textArgs) > 1 && textArgs[1] == "norefund"
 
 	err = lc.Call("LitRPC.AcceptContract", args, reply)
 	if err != nil {
@@ -959,6 +988,33 @@ func (lc *litAfClient) DlcSettleContract(textArgs []string) error {
 	return nil
 }
 
+func (lc *litAfClient) DlcRefundContract(textArgs []string) error {
+	err := CheckHelpCommand(refundContractCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.RefundContractArgs)
+	reply := new(litrpc.RefundContractReply)
+
+	cIdx, err := strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	args.CIdx = cIdx
+
+	err = lc.Call("LitRPC.RefundContract", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "Refund sent, txid %s\n",
+		chainhash.Hash(reply.RefundTxHash).String())
+
+	return nil
+}
+
 func PrintContract(c *lnutil.DlcContract) {
 	fmt.Fprintf(color.Output, "%-30s : %d\n", lnutil.White("Index"), c.Idx)
 	fmt.Fprintf(color.Output, "%-30s : [%x...%x...%x]\n",
@@ -970,6 +1026,9 @@ func PrintContract(c *lnutil.DlcContract) {
 	fmt.Fprintf(color.Output, "%-30s : %s\n",
 		lnutil.White("Settlement time"),
 		time.Unix(int64(c.OracleTimestamp), 0).UTC().Format(time.UnixDate))
+	fmt.Fprintf(color.Output, "%-30s : %s\n",
+		lnutil.White("Refundable from"),
+		time.Unix(int64(c.RefundLockTime()), 0).UTC().Format(time.UnixDate))
 	fmt.Fprintf(color.Output, "%-30s : %d\n",
 		lnutil.White("Funded by us"), c.OurFundingAmount)
 	fmt.Fprintf(color.Output, "%-30s : %d\n",
@@ -990,6 +1049,8 @@ func PrintContract(c *lnutil.DlcContract) {
 		status = "Active"
 	case lnutil.ContractStatusClosed:
 		status = "Closed"
+	case lnutil.ContractStatusRefunded:
+		status = "Refunded"
 	case lnutil.ContractStatusOfferedByMe:
 		status = "Sent offer, awaiting reply"
 	case lnutil.ContractStatusOfferedToMe:
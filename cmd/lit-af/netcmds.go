@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/litrpc"
@@ -16,6 +20,15 @@ var sayCommand = &Command{
 	ShortDescription: "Send a message to a peer.\n",
 }
 
+var chatCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s\n", lnutil.White("chat"), lnutil.ReqColor("peer"),
+		lnutil.OptColor("limit"), lnutil.OptColor("before")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Show the chat with a peer, the last 50 messages or limit of them.",
+		"To see further back, give before as the first shown's id."),
+	ShortDescription: "Show chat history with a peer.\n",
+}
+
 var lisCommand = &Command{
 	Format:           fmt.Sprintf("%s%s\n", lnutil.White("lis"), lnutil.OptColor("port")),
 	Description:      fmt.Sprintf("Start listening for incoming connections. The port number, if omitted, defaults to 2448.\n"),
@@ -24,13 +37,104 @@ var lisCommand = &Command{
 
 var conCommand = &Command{
 	Format: fmt.Sprintf("%s <%s>@<%s>[:<%s>]\n", lnutil.White("con"), lnutil.White("pubkeyhash"), lnutil.White("hostname"), lnutil.White("port")),
-	Description: fmt.Sprintf("%s\n%s\n%s\n",
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
 		"Make a connection to another host by connecting to their pubkeyhash",
 		"(printed when listening using the lis command), on the given host.",
-		"A port may be provided; if omitted, 2448 is used."),
+		"A port may be provided; if omitted, 2448 is used.",
+		"Giving a hex pubkey instead connects with the BOLT 8 transport.",
+		"A contact name from the address book works too."),
 	ShortDescription: "Make a connection to another host by connecting to their pubkeyhash\n",
 }
 
+var seedsCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.White("seeds")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Show the nodes the DNS seeds (--dnsseed) know about that we aren't",
+		"connected to, to pick new peers from."),
+	ShortDescription: "Show nodes from the DNS seeds.\n",
+}
+
+var debugCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.White("debug")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show what lit's goroutines are doing, how many messages are waiting to",
+		"go out, and which mutexes get waited on.  Mutex waits only show when lit",
+		"runs with --debugaddr, which serves pprof profiles too."),
+	ShortDescription: "Show goroutines, queues and lock contention.\n",
+}
+
+var loglevelCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("loglevel"),
+		lnutil.OptColor("subsystem"), lnutil.OptColor("level")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show each log subsystem's level, or set one.  Levels are trace, debug,",
+		"info, warn, error and off.  With just a level, set every subsystem's.",
+		"Levels set here last till lit restarts; --loglevel sets them at startup."),
+	ShortDescription: "Show or set log levels.\n",
+}
+
+var pluginsCommand = &Command{
+	Format:           fmt.Sprintf("%s\n", lnutil.White("plugins")),
+	Description:      "Show the running plugins, and the rpc methods, events and hooks each has.\n",
+	ShortDescription: "Show running plugins.\n",
+}
+
+var pluginCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("plugin"),
+		lnutil.ReqColor("method"), lnutil.OptColor("json params")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Call an rpc method a plugin added, like plugin hello {\"Name\": \"bob\"}.",
+		"The params go to the plugin as they are; its result is shown as json."),
+	ShortDescription: "Call a plugin's rpc method.\n",
+}
+
+var pairCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("pair"),
+		lnutil.OptColor("peer"), lnutil.OptColor("scope")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Let a node remote control this one, by peer index or hex pubkey.",
+		"scope is read (look only), pay (read, and pushes, sends and addresses)",
+		"or admin (everything); none unpairs it.",
+		"With no arguments, show the paired nodes."),
+	ShortDescription: "Pair a node for remote control.\n",
+}
+
+var remoteCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("remote"),
+		lnutil.ReqColor("peer", "method"), lnutil.OptColor("json args")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Call an rpc method on a connected node that's paired with this one,",
+		"like remote 1 Balance.  The reply is shown as json."),
+	ShortDescription: "Call an rpc method on a paired node.\n",
+}
+
+var customCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("custom"),
+		lnutil.ReqColor("peer", "type", "hex data")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Send an app's custom message to a peer that's allowed them.",
+		"type is 32768 or more; the ones below are kept for lit."),
+	ShortDescription: "Send a custom message to a peer.\n",
+}
+
+var customallowCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("customallow"),
+		lnutil.OptColor("peer"), lnutil.OptColor("off")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Swap custom messages with a peer, by peer index or hex pubkey, or stop with off.",
+		"With no arguments, show the peers allowed custom messages."),
+	ShortDescription: "Allow custom messages with a peer.\n",
+}
+
+var aliasCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("alias"), lnutil.OptColor("name")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Announce name to peers as what this node goes by; peers with gossip",
+		"pass it on.  none stops announcing one.",
+		"With no arguments, show the alias announced now."),
+	ShortDescription: "Show or set the alias announced to peers.\n",
+}
+
 var graphCommand = &Command{
 	Format:           fmt.Sprintf("%s\n", lnutil.White("graph")),
 	Description:      fmt.Sprintf("Dump the channel graph in graphviz DOT format\n"),
@@ -58,6 +162,87 @@ func (lc *litAfClient) Graph(textArgs []string) error {
 	return nil
 }
 
+func (lc *litAfClient) Pair(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, pairCommand.Format)
+		fmt.Fprintf(color.Output, pairCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.ListRemotePairingsReply)
+		err := lc.Call("LitRPC.ListRemotePairings", new(litrpc.NoArgs), reply)
+		if err != nil {
+			return err
+		}
+		if len(reply.Pairings) == 0 {
+			fmt.Fprintf(color.Output, "no nodes paired\n")
+			return nil
+		}
+		for _, p := range reply.Pairings {
+			fmt.Fprintf(color.Output, "%s %s %s\n",
+				lnutil.White(p.Scope), p.LitAdr, p.PubKey)
+		}
+		return nil
+	}
+	if len(textArgs) < 2 {
+		return fmt.Errorf(pairCommand.Format)
+	}
+
+	reply := new(litrpc.StatusReply)
+	var err error
+	if textArgs[1] == "none" {
+		err = lc.Call("LitRPC.UnpairRemote",
+			litrpc.UnpairRemoteArgs{Peer: textArgs[0]}, reply)
+	} else {
+		err = lc.Call("LitRPC.PairRemote",
+			litrpc.PairRemoteArgs{Peer: textArgs[0], Scope: textArgs[1]}, reply)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) Remote(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, remoteCommand.Format)
+		fmt.Fprintf(color.Output, remoteCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 2 {
+		return fmt.Errorf(remoteCommand.Format)
+	}
+
+	peer, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args := new(litrpc.RemoteCallArgs)
+	reply := new(litrpc.RemoteCallReply)
+	args.Peer = uint32(peer)
+	args.Method = textArgs[1]
+	if len(textArgs) > 2 {
+		params := strings.Join(textArgs[2:], " ")
+		if !json.Valid([]byte(params)) {
+			return fmt.Errorf("args %s aren't json", params)
+		}
+		args.Args = json.RawMessage(params)
+	}
+	err = lc.Call("LitRPC.RemoteCall", args, reply)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	err = json.Indent(&out, reply.Reply, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", out.String())
+	return nil
+}
+
 // RequestAsync keeps requesting messages from the server.  The server blocks
 // and will send a response once it gets one.  Once the rpc client receives a
 // response, it will immediately request another.
@@ -135,6 +320,27 @@ func (lc *litAfClient) Connect(textArgs []string) error {
 	return nil
 }
 
+func (lc *litAfClient) Seeds(textArgs []string) error {
+	err := CheckHelpCommand(seedsCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	reply := new(litrpc.SeedPeersReply)
+	err = lc.Call("LitRPC.SeedPeers", nil, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Nodes) == 0 {
+		fmt.Fprintf(color.Output, "no nodes from DNS seeds\n")
+	}
+	for _, n := range reply.Nodes {
+		fmt.Fprintf(color.Output, "%s@%s\n", lnutil.White(n.LitAdr), n.Host)
+	}
+	return nil
+}
+
 func (lc *litAfClient) Say(textArgs []string) error {
 	err := CheckHelpCommand(sayCommand, textArgs, 2)
 	if err != nil {
@@ -164,3 +370,279 @@ func (lc *litAfClient) Say(textArgs []string) error {
 	fmt.Fprintf(color.Output, "%s\n", reply.Status)
 	return nil
 }
+
+func (lc *litAfClient) Custom(textArgs []string) error {
+	err := CheckHelpCommand(customCommand, textArgs, 3)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.SendCustomMsgArgs)
+	reply := new(litrpc.StatusReply)
+
+	peerIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	msgType, err := strconv.ParseUint(textArgs[1], 10, 16)
+	if err != nil {
+		return err
+	}
+	args.Peer = uint32(peerIdx)
+	args.Type = uint16(msgType)
+	args.Data = textArgs[2]
+
+	err = lc.Call("LitRPC.SendCustomMsg", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) CustomAllow(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, customallowCommand.Format)
+		fmt.Fprintf(color.Output, customallowCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.CustomMsgPeersReply)
+		err := lc.Call("LitRPC.CustomMsgPeers", new(litrpc.NoArgs), reply)
+		if err != nil {
+			return err
+		}
+		if len(reply.LitAdrs) == 0 {
+			fmt.Fprintf(color.Output, "no peers allowed custom messages\n")
+			return nil
+		}
+		for _, adr := range reply.LitAdrs {
+			fmt.Fprintf(color.Output, "%s\n", adr)
+		}
+		return nil
+	}
+
+	args := new(litrpc.AllowCustomMsgsArgs)
+	reply := new(litrpc.StatusReply)
+	args.Peer = textArgs[0]
+	args.Allow = len(textArgs) < 2 || textArgs[1] != "off"
+
+	err := lc.Call("LitRPC.AllowCustomMsgs", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) Chat(textArgs []string) error {
+	err := CheckHelpCommand(chatCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ChatHistoryArgs)
+	reply := new(litrpc.ChatHistoryReply)
+
+	peerIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.Peer = uint32(peerIdx)
+	if len(textArgs) > 1 {
+		args.Limit, err = strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+	}
+	if len(textArgs) > 2 {
+		args.Before, err = strconv.ParseUint(textArgs[2], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = lc.Call("LitRPC.ChatHistory", args, reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Chats) == 0 {
+		fmt.Fprintf(color.Output, "no chat with peer %d\n", peerIdx)
+		return nil
+	}
+	for _, c := range reply.Chats {
+		when := time.Unix(c.Time, 0).Format("2006-01-02 15:04:05")
+		if c.Outgoing {
+			fmt.Fprintf(color.Output, "%d %s to %s: %s (%s)\n", c.ID, when,
+				lnutil.White(peerIdx), c.Text, c.Status)
+		} else {
+			fmt.Fprintf(color.Output, "%d %s from %s: %s\n", c.ID, when,
+				lnutil.White(peerIdx), lnutil.Green(c.Text))
+		}
+	}
+	return nil
+}
+
+func (lc *litAfClient) LogLevel(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, loglevelCommand.Format)
+		fmt.Fprintf(color.Output, loglevelCommand.Description)
+		return nil
+	}
+
+	reply := new(litrpc.LogLevelsReply)
+	var err error
+	switch len(textArgs) {
+	case 0:
+		err = lc.Call("LitRPC.LogLevels", new(litrpc.NoArgs), reply)
+	case 1:
+		args := &litrpc.LogLevelArgs{Level: textArgs[0]}
+		err = lc.Call("LitRPC.SetLogLevel", args, reply)
+	default:
+		args := &litrpc.LogLevelArgs{Subsystem: textArgs[0], Level: textArgs[1]}
+		err = lc.Call("LitRPC.SetLogLevel", args, reply)
+	}
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range reply.Levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(color.Output, "%-8s %s\n", lnutil.White(name), reply.Levels[name])
+	}
+	return nil
+}
+
+func (lc *litAfClient) Plugins(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, pluginsCommand.Format)
+		fmt.Fprintf(color.Output, pluginsCommand.Description)
+		return nil
+	}
+
+	reply := new(litrpc.ListPluginsReply)
+	err := lc.Call("LitRPC.ListPlugins", new(litrpc.NoArgs), reply)
+	if err != nil {
+		return err
+	}
+	if len(reply.Plugins) == 0 {
+		fmt.Fprintf(color.Output, "no plugins running\n")
+		return nil
+	}
+	for _, p := range reply.Plugins {
+		fmt.Fprintf(color.Output, "%s %s\n", lnutil.White(p.Name), p.Path)
+		for _, m := range p.RPCMethods {
+			fmt.Fprintf(color.Output, "\trpc %s %s\n", lnutil.White(m.Name), m.Description)
+		}
+		if len(p.Subscriptions) > 0 {
+			fmt.Fprintf(color.Output, "\tevents %s\n", strings.Join(p.Subscriptions, " "))
+		}
+		if len(p.Hooks) > 0 {
+			fmt.Fprintf(color.Output, "\thooks %s\n", strings.Join(p.Hooks, " "))
+		}
+	}
+	return nil
+}
+
+func (lc *litAfClient) Plugin(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, pluginCommand.Format)
+		fmt.Fprintf(color.Output, pluginCommand.Description)
+		return nil
+	}
+	if len(textArgs) < 1 {
+		return fmt.Errorf(pluginCommand.Format)
+	}
+
+	args := new(litrpc.PluginCallArgs)
+	reply := new(litrpc.PluginCallReply)
+	args.Method = textArgs[0]
+	if len(textArgs) > 1 {
+		params := strings.Join(textArgs[1:], " ")
+		if !json.Valid([]byte(params)) {
+			return fmt.Errorf("params %s aren't json", params)
+		}
+		args.Params = json.RawMessage(params)
+	}
+	err := lc.Call("LitRPC.PluginCall", args, reply)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	err = json.Indent(&out, reply.Result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", out.String())
+	return nil
+}
+
+func (lc *litAfClient) Debug(textArgs []string) error {
+	err := CheckHelpCommand(debugCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.NoArgs)
+	reply := new(litrpc.DebugStateReply)
+	err = lc.Call("LitRPC.DebugState", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s goroutines\n", lnutil.White(reply.Goroutines))
+	for _, g := range reply.Groups {
+		fmt.Fprintf(color.Output, "%6d %s @ %s\n", g.Count, g.Start, g.At)
+	}
+	fmt.Fprintf(color.Output, "OmniOut %d/%d, OmniIn %d/%d, user messages %d\n",
+		reply.OmniOut, reply.OmniOutCap, reply.OmniIn, reply.OmniInCap,
+		reply.UserMessages)
+	for _, p := range reply.Peers {
+		fmt.Fprintf(color.Output, "peer %s outbox %v, heard from %ds ago\n",
+			lnutil.White(p.PeerIdx), p.Outbox, p.SinceRecv)
+	}
+	for _, c := range reply.Contention {
+		fmt.Fprintf(color.Output, "%5.1f%% %6d waits %s\n", c.Share*100, c.Count, c.Func)
+	}
+	return nil
+}
+
+func (lc *litAfClient) Alias(textArgs []string) error {
+	if len(textArgs) > 0 && textArgs[0] == "-h" {
+		fmt.Fprintf(color.Output, aliasCommand.Format)
+		fmt.Fprintf(color.Output, aliasCommand.Description)
+		return nil
+	}
+
+	if len(textArgs) == 0 {
+		reply := new(litrpc.GetAliasReply)
+		err := lc.Call("LitRPC.GetAlias", new(litrpc.NoArgs), reply)
+		if err != nil {
+			return err
+		}
+		if reply.Alias == "" {
+			fmt.Fprintf(color.Output, "no alias\n")
+			return nil
+		}
+		fmt.Fprintf(color.Output, "%s\n", lnutil.White(reply.Alias))
+		return nil
+	}
+
+	args := new(litrpc.SetAliasArgs)
+	args.Alias = strings.Join(textArgs, " ")
+	if args.Alias == "none" {
+		args.Alias = ""
+	}
+	reply := new(litrpc.StatusReply)
+	err := lc.Call("LitRPC.SetAlias", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
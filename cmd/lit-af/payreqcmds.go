@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var payreqCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("payreq"),
+		lnutil.ReqColor("peer", "cointype", "amount"), lnutil.OptColor("memo")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Ask a connected peer to push the amount in one of our channels on the coin,",
+		"with a memo saying what for.  They're shown it, and pay or decline."),
+	ShortDescription: "Ask a peer to pay.\n",
+}
+
+var payreqsCommand = &Command{
+	Format:           fmt.Sprintf("%s\n", lnutil.White("payreqs")),
+	Description:      "Show the payment requests we've made and got.\n",
+	ShortDescription: "Show payment requests.\n",
+}
+
+var payreqpayCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("payreqpay"), lnutil.ReqColor("id")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Pay a payment request from a peer, pushing in the channel with them that",
+		"has the most of ours on the coin.  Get the id from payreqs."),
+	ShortDescription: "Pay a payment request.\n",
+}
+
+var payreqdeclineCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("payreqdecline"), lnutil.ReqColor("id")),
+	Description:      "Decline a payment request from a peer.\n",
+	ShortDescription: "Decline a payment request.\n",
+}
+
+func (lc *litAfClient) PayReq(textArgs []string) error {
+	err := CheckHelpCommand(payreqCommand, textArgs, 3)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.RequestPaymentArgs)
+	reply := new(litrpc.RequestPaymentReply)
+
+	peer, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	coin, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+	amt, err := strconv.ParseInt(textArgs[2], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	args.Peer = uint32(peer)
+	args.CoinType = uint32(coin)
+	args.Amt = amt
+	args.Memo = strings.Join(textArgs[3:], " ")
+
+	err = lc.Call("LitRPC.RequestPayment", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "sent payment request %s to peer %d\n",
+		lnutil.White(reply.ID), args.Peer)
+	return nil
+}
+
+func (lc *litAfClient) PayReqs(textArgs []string) error {
+	err := CheckHelpCommand(payreqsCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	reply := new(litrpc.ListPayRequestsReply)
+
+	err = lc.Call("LitRPC.ListPayRequests", nil, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Requests) == 0 {
+		fmt.Fprintf(color.Output, "no payment requests\n")
+	}
+	for _, p := range reply.Requests {
+		dir := "from"
+		if p.Outgoing {
+			dir = "to"
+		}
+		fmt.Fprintf(color.Output, "%s %s %s peer %d: %s on coin %d %q %s\n",
+			lnutil.White(p.ID), time.Unix(p.Time, 0).Format("2006-01-02 15:04"),
+			dir, p.Peer, lnutil.SatoshiColor(p.Amt), p.CoinType, p.Memo,
+			lnutil.Green(p.Status))
+	}
+	return nil
+}
+
+func (lc *litAfClient) PayReqPay(textArgs []string) error {
+	err := CheckHelpCommand(payreqpayCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.PayRequestArgs)
+	reply := new(litrpc.PushReply)
+
+	args.ID, err = strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	err = lc.Call("LitRPC.PayRequest", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "paid payment request %d; trace %d\n",
+		args.ID, reply.TraceID)
+	return nil
+}
+
+func (lc *litAfClient) PayReqDecline(textArgs []string) error {
+	err := CheckHelpCommand(payreqdeclineCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.PayRequestArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.ID, err = strconv.ParseUint(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	err = lc.Call("LitRPC.DeclinePayRequest", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
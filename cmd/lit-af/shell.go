@@ -68,6 +68,21 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		return parseErr(err, "watch")
 	}
 
+	if cmd == "autowatch" {
+		err = lc.AutoWatch(args)
+		return parseErr(err, "autowatch")
+	}
+
+	if cmd == "towers" {
+		err = lc.Towers(args)
+		return parseErr(err, "towers")
+	}
+
+	if cmd == "unwatch" {
+		err = lc.Unwatch(args)
+		return parseErr(err, "unwatch")
+	}
+
 	// address a new address and displays it
 	if cmd == "adr" {
 		err = lc.Address(args)
@@ -86,6 +101,24 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		return parseErr(err, "send")
 	}
 
+	if cmd == "addcontact" { // name an address in the address book
+		err = lc.AddContact(args)
+		return parseErr(err, "addcontact")
+	}
+	if cmd == "rmcontact" { // take a name out of the address book
+		err = lc.RmContact(args)
+		return parseErr(err, "rmcontact")
+	}
+	if cmd == "contacts" { // show the address book
+		err = lc.Contacts(args)
+		return parseErr(err, "contacts")
+	}
+
+	if cmd == "sendmany" { // pay many addresses in one tx
+		err = lc.SendMany(args)
+		return parseErr(err, "sendmany")
+	}
+
 	if cmd == "lis" { // listen for lnd peers
 		err = lc.Lis(args)
 		return parseErr(err, "lis")
@@ -100,6 +133,10 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		err = lc.Sweep(args)
 		return parseErr(err, "sweep")
 	}
+	if cmd == "sweepall" { // send everything to an address
+		err = lc.SweepAll(args)
+		return parseErr(err, "sweepall")
+	}
 
 	// push money in a channel away from you
 	if cmd == "push" {
@@ -107,11 +144,71 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		return parseErr(err, "push")
 	}
 
+	if cmd == "swapoffer" { // offer an atomic swap between two channels
+		err = lc.SwapOffer(args)
+		return parseErr(err, "swapoffer")
+	}
+	if cmd == "swapaccept" {
+		err = lc.SwapAccept(args)
+		return parseErr(err, "swapaccept")
+	}
+	if cmd == "swapdecline" {
+		err = lc.SwapDecline(args)
+		return parseErr(err, "swapdecline")
+	}
+	if cmd == "swaps" {
+		err = lc.Swaps(args)
+		return parseErr(err, "swaps")
+	}
+
+	if cmd == "payreq" { // ask a peer to pay
+		err = lc.PayReq(args)
+		return parseErr(err, "payreq")
+	}
+	if cmd == "payreqs" {
+		err = lc.PayReqs(args)
+		return parseErr(err, "payreqs")
+	}
+	if cmd == "payreqpay" {
+		err = lc.PayReqPay(args)
+		return parseErr(err, "payreqpay")
+	}
+	if cmd == "payreqdecline" {
+		err = lc.PayReqDecline(args)
+		return parseErr(err, "payreqdecline")
+	}
+
+	if cmd == "loopin" { // swap coins on chain for channel balance
+		err = lc.LoopIn(args)
+		return parseErr(err, "loopin")
+	}
+	if cmd == "loopout" { // swap channel balance for coins on chain
+		err = lc.LoopOut(args)
+		return parseErr(err, "loopout")
+	}
+	if cmd == "subswapaccept" {
+		err = lc.SubSwapAccept(args)
+		return parseErr(err, "subswapaccept")
+	}
+	if cmd == "subswapdecline" {
+		err = lc.SubSwapDecline(args)
+		return parseErr(err, "subswapdecline")
+	}
+	if cmd == "subswaps" {
+		err = lc.SubSwaps(args)
+		return parseErr(err, "subswaps")
+	}
+
 	if cmd == "con" { // connect to lnd host
 		err = lc.Connect(args)
 		return parseErr(err, "con")
 	}
 
+	if cmd == "seeds" {
+		err = lc.Seeds(args)
+		return parseErr(err, "seeds")
+	}
+
 	if cmd == "dlc" { // the root command for Discreet log contracts
 		err = lc.Dlc(args)
 		return parseErr(err, "dlc")
@@ -145,14 +242,170 @@ func (lc *litAfClient) Shellparse(cmdslice []string) error {
 		err = lc.Fee(args)
 		return parseErr(err, "fee")
 	}
+	if cmd == "bumpfee" { // raise the fee of an unconfirmed send
+		err = lc.BumpFee(args)
+		return parseErr(err, "bumpfee")
+	}
+	if cmd == "cpfp" { // speed up a tx with a high fee child
+		err = lc.Cpfp(args)
+		return parseErr(err, "cpfp")
+	}
+	if cmd == "lock" { // keep a utxo from being spent
+		err = lc.Lock(args)
+		return parseErr(err, "lock")
+	}
+	if cmd == "unlock" { // let a locked utxo be spent again
+		err = lc.Unlock(args)
+		return parseErr(err, "unlock")
+	}
+	if cmd == "locks" { // show locked utxos
+		err = lc.Locks(args)
+		return parseErr(err, "locks")
+	}
+	if cmd == "broadcasts" { // show txs being rebroadcast
+		err = lc.Broadcasts(args)
+		return parseErr(err, "broadcasts")
+	}
+	if cmd == "checkdb" { // check the dbs and channels
+		err = lc.CheckDB(args)
+		return parseErr(err, "checkdb")
+	}
+	if cmd == "unspent" { // show utxos, filtered
+		err = lc.Unspent(args)
+		return parseErr(err, "unspent")
+	}
+	if cmd == "label" { // label a tx or output
+		err = lc.Label(args)
+		return parseErr(err, "label")
+	}
+	if cmd == "labels" { // show labels
+		err = lc.Labels(args)
+		return parseErr(err, "labels")
+	}
+	if cmd == "rescan" { // go over the chain again from a height
+		err = lc.Rescan(args)
+		return parseErr(err, "rescan")
+	}
+	if cmd == "sync" { // show sync and rescan progress
+		err = lc.Sync(args)
+		return parseErr(err, "sync")
+	}
+	if cmd == "subscribe" { // subscribe to blocks, txs and outpoints
+		err = lc.Subscribe(args)
+		return parseErr(err, "subscribe")
+	}
+	if cmd == "events" { // show a subscription's events
+		err = lc.Events(args)
+		return parseErr(err, "events")
+	}
+	if cmd == "unsubscribe" { // end a subscription
+		err = lc.Unsubscribe(args)
+		return parseErr(err, "unsubscribe")
+	}
+	if cmd == "generate" { // mine regtest blocks
+		err = lc.Generate(args)
+		return parseErr(err, "generate")
+	}
+	if cmd == "minersend" { // get regtest coins from the node
+		err = lc.MinerSend(args)
+		return parseErr(err, "minersend")
+	}
+	if cmd == "mocktime" { // set the regtest node's clock
+		err = lc.MockTime(args)
+		return parseErr(err, "mocktime")
+	}
+	if cmd == "importxpub" { // watch an xpub without its keys
+		err = lc.ImportXpub(args)
+		return parseErr(err, "importxpub")
+	}
+	if cmd == "xpubs" { // show watched xpubs
+		err = lc.Xpubs(args)
+		return parseErr(err, "xpubs")
+	}
+	if cmd == "psbt" { // unsigned tx spending from a watched xpub
+		err = lc.Psbt(args)
+		return parseErr(err, "psbt")
+	}
+	if cmd == "xpubsend" { // send from a watched xpub with the external signer
+		err = lc.XpubSend(args)
+		return parseErr(err, "xpubsend")
+	}
+	if cmd == "pushpsbt" { // send a signed psbt
+		err = lc.PushPsbt(args)
+		return parseErr(err, "pushpsbt")
+	}
+	if cmd == "consolidate" { // spend small utxos to one
+		err = lc.Consolidate(args)
+		return parseErr(err, "consolidate")
+	}
+	if cmd == "newaccount" { // make a named account
+		err = lc.NewAccount(args)
+		return parseErr(err, "newaccount")
+	}
+	if cmd == "accounts" { // show accounts and their balances
+		err = lc.Accounts(args)
+		return parseErr(err, "accounts")
+	}
+	if cmd == "coinselect" { // get or set coin selection for a wallet
+		err = lc.CoinSelect(args)
+		return parseErr(err, "coinselect")
+	}
 	if cmd == "dump" { // dump all private keys
 		err = lc.Dump(args)
 		return parseErr(err, "dump")
 	}
+	if cmd == "trace" { // push timings
+		err = lc.Trace(args)
+		return parseErr(err, "trace")
+	}
+	if cmd == "audit" { // show or verify the audit log
+		err = lc.Audit(args)
+		return parseErr(err, "audit")
+	}
 	if cmd == "history" { // dump justice tx history
 		err = lc.History(args)
 		return parseErr(err, "history")
 	}
+	if cmd == "debug" { // goroutines, queues and lock contention
+		err = lc.Debug(args)
+		return parseErr(err, "debug")
+	}
+	if cmd == "loglevel" { // show or set log levels
+		err = lc.LogLevel(args)
+		return parseErr(err, "loglevel")
+	}
+	if cmd == "plugins" { // show running plugins
+		err = lc.Plugins(args)
+		return parseErr(err, "plugins")
+	}
+	if cmd == "plugin" { // call a plugin's rpc method
+		err = lc.Plugin(args)
+		return parseErr(err, "plugin")
+	}
+	if cmd == "alias" { // show or set the alias we announce
+		err = lc.Alias(args)
+		return parseErr(err, "alias")
+	}
+	if cmd == "pair" { // pair a node for remote control
+		err = lc.Pair(args)
+		return parseErr(err, "pair")
+	}
+	if cmd == "remote" { // call an rpc method on a paired node
+		err = lc.Remote(args)
+		return parseErr(err, "remote")
+	}
+	if cmd == "chat" { // show chat history with a peer
+		err = lc.Chat(args)
+		return parseErr(err, "chat")
+	}
+	if cmd == "custom" { // send an app's custom message
+		err = lc.Custom(args)
+		return parseErr(err, "custom")
+	}
+	if cmd == "customallow" { // allow custom messages with a peer
+		err = lc.CustomAllow(args)
+		return parseErr(err, "customallow")
+	}
 	if cmd == "graph" { // dump graphviz for channels
 		err = lc.Graph(args)
 		return parseErr(err, "grpah")
@@ -213,8 +466,16 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 	if len(pReply.Connections) > 0 {
 		fmt.Fprintf(color.Output, "\t%s\n", lnutil.Header("Peers:"))
 		for _, peer := range pReply.Connections {
-			fmt.Fprintf(color.Output, "%s %s\n",
-				lnutil.White(peer.PeerNumber), peer.RemoteHost)
+			// our name for them, then theirs
+			names := ""
+			if peer.Nickname != "" {
+				names += " " + peer.Nickname
+			}
+			if peer.Alias != "" {
+				names += fmt.Sprintf(" %q", peer.Alias)
+			}
+			fmt.Fprintf(color.Output, "%s %s%s\n",
+				lnutil.White(peer.PeerNumber), peer.RemoteHost, names)
 		}
 	}
 
@@ -258,6 +519,9 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 		if !t.Witty {
 			fmt.Fprintf(color.Output, " non-witness")
 		}
+		if t.Label != "" {
+			fmt.Fprintf(color.Output, " %s", lnutil.White(t.Label))
+		}
 		fmt.Fprintf(color.Output, "\n")
 	}
 
@@ -278,8 +542,12 @@ func (lc *litAfClient) Ls(textArgs []string) error {
 	}
 	fmt.Fprintf(color.Output, lnutil.Header("\tAddresses:\n"))
 	for i, a := range aReply.WitAddresses {
-		fmt.Fprintf(color.Output, "%d %s (%s)\n", i,
+		fmt.Fprintf(color.Output, "%d %s (%s)", i,
 			lnutil.Address(a), lnutil.Address(aReply.LegacyAddresses[i]))
+		if i < len(aReply.Used) && aReply.Used[i] {
+			fmt.Fprintf(color.Output, " used")
+		}
+		fmt.Fprintf(color.Output, "\n")
 	}
 
 	err = lc.Call("LitRPC.Balance", nil, bReply)
@@ -338,7 +606,7 @@ func printCointypes() {
 func (lc *litAfClient) Help(textArgs []string) error {
 	if len(textArgs) == 0 {
 		fmt.Fprintf(color.Output, lnutil.Header("Commands:\n"))
-		listofCommands := []*Command{helpCommand, sayCommand, lsCommand, addressCommand, sendCommand, fanCommand, sweepCommand, lisCommand, conCommand, dlcCommand, fundCommand, watchCommand, pushCommand, closeCommand, breakCommand, historyCommand, offCommand, exitCommand}
+		listofCommands := []*Command{helpCommand, sayCommand, chatCommand, lsCommand, addressCommand, sendCommand, sendmanyCommand, bumpfeeCommand, cpfpCommand, lockCommand, unlockCommand, locksCommand, broadcastsCommand, checkdbCommand, debugCommand, loglevelCommand, pluginsCommand, pluginCommand, aliasCommand, pairCommand, remoteCommand, customCommand, customallowCommand, unspentCommand, labelCommand, labelsCommand, rescanCommand, syncCommand, subscribeCommand, eventsCommand, unsubscribeCommand, generateCommand, minersendCommand, mocktimeCommand, importxpubCommand, xpubsCommand, psbtCommand, xpubsendCommand, pushpsbtCommand, newaccountCommand, accountsCommand, coinselectCommand, fanCommand, sweepCommand, sweepallCommand, consolidateCommand, lisCommand, conCommand, seedsCommand, addcontactCommand, rmcontactCommand, contactsCommand, dlcCommand, fundCommand, watchCommand, unwatchCommand, towersCommand, autowatchCommand, pushCommand, traceCommand, swapofferCommand, swapacceptCommand, swapdeclineCommand, swapsCommand, payreqCommand, payreqsCommand, payreqpayCommand, payreqdeclineCommand, loopinCommand, loopoutCommand, subswapacceptCommand, subswapdeclineCommand, subswapsCommand, closeCommand, breakCommand, historyCommand, auditCommand, offCommand, exitCommand}
 		printHelp(listofCommands)
 		fmt.Fprintf(color.Output, "\n\n")
 		fmt.Fprintf(color.Output, lnutil.Header("Coins:\n"))
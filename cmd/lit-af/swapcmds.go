@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+var swapofferCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("swapoffer"),
+		lnutil.ReqColor("give channel idx", "give amount",
+			"want channel idx", "want amount"),
+		lnutil.OptColor("timeout seconds")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		"Offer the peer of two channels, on different coins, to swap the give",
+		"amount in one channel for the want amount in the other, with HTLCs.",
+		"Our HTLC locks for the timeout (default a day), theirs for about half.",
+		"The swap goes ahead by itself if they accept."),
+	ShortDescription: "Offer an atomic swap between two channels.\n",
+}
+
+var swapacceptCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("swapaccept"), lnutil.ReqColor("hash")),
+	Description:      "Accept a swap we were offered.  Get the hash from swaps.\n",
+	ShortDescription: "Accept an atomic swap offer.\n",
+}
+
+var swapdeclineCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("swapdecline"), lnutil.ReqColor("hash")),
+	Description:      "Decline a swap we were offered.\n",
+	ShortDescription: "Decline an atomic swap offer.\n",
+}
+
+var swapsCommand = &Command{
+	Format:           fmt.Sprintf("%s\n", lnutil.White("swaps")),
+	Description:      "Show the swaps we've offered or been offered.\n",
+	ShortDescription: "Show atomic swaps.\n",
+}
+
+func (lc *litAfClient) SwapOffer(textArgs []string) error {
+	err := CheckHelpCommand(swapofferCommand, textArgs, 4)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.SwapOfferArgs)
+	reply := new(litrpc.StatusReply)
+
+	giveIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	giveAmt, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+	wantIdx, err := strconv.Atoi(textArgs[2])
+	if err != nil {
+		return err
+	}
+	wantAmt, err := strconv.Atoi(textArgs[3])
+	if err != nil {
+		return err
+	}
+	if len(textArgs) > 4 {
+		timeout, err := strconv.Atoi(textArgs[4])
+		if err != nil {
+			return err
+		}
+		args.Timeout = uint32(timeout)
+	}
+
+	args.GiveChanIdx = uint32(giveIdx)
+	args.GiveAmt = int64(giveAmt)
+	args.WantChanIdx = uint32(wantIdx)
+	args.WantAmt = int64(wantAmt)
+
+	err = lc.Call("LitRPC.SwapOffer", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) SwapAccept(textArgs []string) error {
+	return lc.swapReply(swapacceptCommand, "LitRPC.SwapAccept", textArgs)
+}
+
+func (lc *litAfClient) SwapDecline(textArgs []string) error {
+	return lc.swapReply(swapdeclineCommand, "LitRPC.SwapDecline", textArgs)
+}
+
+func (lc *litAfClient) swapReply(cmd *Command, method string, textArgs []string) error {
+	err := CheckHelpCommand(cmd, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.SwapArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.RHash = textArgs[0]
+
+	err = lc.Call(method, args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) Swaps(textArgs []string) error {
+	err := CheckHelpCommand(swapsCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	reply := new(litrpc.SwapsReply)
+
+	err = lc.Call("LitRPC.ListSwaps", nil, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Swaps) == 0 {
+		fmt.Fprintf(color.Output, "no swaps\n")
+	}
+	for _, s := range reply.Swaps {
+		who := "peer"
+		if s.Offerer {
+			who = "we"
+		}
+		fmt.Fprintf(color.Output,
+			"%s peer %d: %s give %s in chan %d for %s in chan %d, %ds %s\n",
+			lnutil.White(s.RHash), s.PeerIdx, who,
+			lnutil.SatoshiColor(s.GiveAmt), s.GiveChanIdx,
+			lnutil.SatoshiColor(s.WantAmt), s.WantChanIdx,
+			s.Timeout, lnutil.Green(s.Status))
+	}
+	return nil
+}
+
+var loopinCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("loopin"),
+		lnutil.ReqColor("channel idx", "chain amount", "channel amount"),
+		lnutil.OptColor("timeout blocks")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Offer the channel's peer a submarine swap: we pay the chain amount in an",
+		"on-chain HTLC, locked for the timeout (default 144 blocks), and they pay",
+		"the channel amount to us in the channel.  It goes ahead if they accept."),
+	ShortDescription: "Swap coins on chain for channel balance.\n",
+}
+
+var loopoutCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("loopout"),
+		lnutil.ReqColor("channel idx", "channel amount", "chain amount"),
+		lnutil.OptColor("timeout blocks")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Offer the channel's peer a submarine swap: we pay the channel amount in",
+		"an HTLC in the channel, locked for the timeout (default 144 blocks), and",
+		"they pay the chain amount to us on chain.  It goes ahead if they accept."),
+	ShortDescription: "Swap channel balance for coins on chain.\n",
+}
+
+var subswapacceptCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("subswapaccept"), lnutil.ReqColor("hash")),
+	Description:      "Accept a submarine swap we were offered.  Get the hash from subswaps.\n",
+	ShortDescription: "Accept a submarine swap offer.\n",
+}
+
+var subswapdeclineCommand = &Command{
+	Format:           fmt.Sprintf("%s%s\n", lnutil.White("subswapdecline"), lnutil.ReqColor("hash")),
+	Description:      "Decline a submarine swap we were offered.\n",
+	ShortDescription: "Decline a submarine swap offer.\n",
+}
+
+var subswapsCommand = &Command{
+	Format:           fmt.Sprintf("%s\n", lnutil.White("subswaps")),
+	Description:      "Show the submarine swaps we've offered or been offered.\n",
+	ShortDescription: "Show submarine swaps.\n",
+}
+
+func (lc *litAfClient) LoopIn(textArgs []string) error {
+	return lc.subSwapOffer(loopinCommand, true, textArgs)
+}
+
+func (lc *litAfClient) LoopOut(textArgs []string) error {
+	return lc.subSwapOffer(loopoutCommand, false, textArgs)
+}
+
+// subSwapOffer takes the channel, then the amount we pay and the amount we
+// get, then maybe a timeout.
+func (lc *litAfClient) subSwapOffer(cmd *Command, loopIn bool, textArgs []string) error {
+	err := CheckHelpCommand(cmd, textArgs, 3)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.SubSwapOfferArgs)
+	reply := new(litrpc.StatusReply)
+
+	cIdx, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	payAmt, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+	getAmt, err := strconv.Atoi(textArgs[2])
+	if err != nil {
+		return err
+	}
+	if len(textArgs) > 3 {
+		timeout, err := strconv.Atoi(textArgs[3])
+		if err != nil {
+			return err
+		}
+		args.Timeout = uint32(timeout)
+	}
+
+	args.ChanIdx = uint32(cIdx)
+	args.LoopIn = loopIn
+	if loopIn {
+		args.ChainAmt, args.ChanAmt = int64(payAmt), int64(getAmt)
+	} else {
+		args.ChanAmt, args.ChainAmt = int64(payAmt), int64(getAmt)
+	}
+
+	err = lc.Call("LitRPC.SubSwapOffer", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) SubSwapAccept(textArgs []string) error {
+	return lc.swapReply(subswapacceptCommand, "LitRPC.SubSwapAccept", textArgs)
+}
+
+func (lc *litAfClient) SubSwapDecline(textArgs []string) error {
+	return lc.swapReply(subswapdeclineCommand, "LitRPC.SubSwapDecline", textArgs)
+}
+
+func (lc *litAfClient) SubSwaps(textArgs []string) error {
+	err := CheckHelpCommand(subswapsCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	reply := new(litrpc.SubSwapsReply)
+
+	err = lc.Call("LitRPC.ListSubSwaps", nil, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.SubSwaps) == 0 {
+		fmt.Fprintf(color.Output, "no submarine swaps\n")
+	}
+	for _, s := range reply.SubSwaps {
+		dir := "out"
+		if s.LoopIn {
+			dir = "in"
+		}
+		who := "peer"
+		if s.Offerer {
+			who = "we"
+		}
+		fmt.Fprintf(color.Output,
+			"%s peer %d: %s loop %s, %s in chan %d, %s on chain, %d blocks %s\n",
+			lnutil.White(s.RHash), s.PeerIdx, who, dir,
+			lnutil.SatoshiColor(s.ChanAmt), s.ChanIdx,
+			lnutil.SatoshiColor(s.ChainAmt), s.Timeout, lnutil.Green(s.Status))
+		if s.FundOp != "" {
+			fmt.Fprintf(color.Output, "\ton-chain HTLC %s height %d locktime %d\n",
+				s.FundOp, s.FundHeight, s.Locktime)
+		}
+	}
+	return nil
+}
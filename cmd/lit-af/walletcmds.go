@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/litrpc"
@@ -10,19 +12,273 @@ import (
 )
 
 var sendCommand = &Command{
-	Format: fmt.Sprintf(
-		"%s%s\n", lnutil.White("send"), lnutil.ReqColor("address", "amount")),
-	Description:      "Send the given amount of satoshis to the given address.\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("send"),
+		lnutil.ReqColor("address", "amount"),
+		lnutil.OptColor("coinselect", "+txid:index", "-txid:index", "@account",
+			"change=address|type|@account")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n",
+		"Send the given amount of satoshis to the given address.",
+		"The address can be a contact name from the address book.",
+		"Optionally pick inputs with a coin selection other than the wallet's.",
+		"Spend only the +outpoints given, and none of the -outpoints given.",
+		"Send from a named @account instead of the default one.",
+		"Send change to an address, a bech32|legacy|taproot address, or another account."),
 	ShortDescription: "Send the given amount of satoshis to the given address.\n",
 }
 
+var sendmanyCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("sendmany"),
+		lnutil.ReqColor("address", "amount", "address", "amount", "..."),
+		lnutil.OptColor("coinselect", "+txid:index", "-txid:index", "@account",
+			"change=address|type|@account")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Pay all the addresses their amounts in one tx, with one fee.",
+		"Options are as for send."),
+	ShortDescription: "Pay many addresses in one tx.\n",
+}
+
+var bumpfeeCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("bumpfee"),
+		lnutil.ReqColor("txid", "feerate"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Replace an unconfirmed send with one paying feerate sat / byte.",
+		"The extra fee comes out of the change."),
+	ShortDescription: "Raise the fee of an unconfirmed send.\n",
+}
+
+var cpfpCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("cpfp"),
+		lnutil.ReqColor("txid:index", "feerate"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Spend an unconfirmed output of ours back to ourselves, paying enough",
+		"that it and its parent together pay feerate sat / byte."),
+	ShortDescription: "Speed up a tx by spending its output with a high fee.\n",
+}
+
+var lockCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("lock"),
+		lnutil.ReqColor("txid:index"), lnutil.OptColor("reason", "cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Keep a utxo from being spent by sends or channel funding.",
+		"The lock lasts through restarts, until unlock or the utxo is spent."),
+	ShortDescription: "Keep a utxo from being spent.\n",
+}
+
+var unlockCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("unlock"),
+		lnutil.ReqColor("txid:index"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Let a locked utxo be spent again.",
+		"For a utxo reserved by an unsent tx, that tx is called off."),
+	ShortDescription: "Let a locked utxo be spent again.\n",
+}
+
+var locksCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("locks"),
+		lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n",
+		"Show the utxos a wallet won't spend, and why."),
+	ShortDescription: "Show locked utxos.\n",
+}
+
+var broadcastsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("broadcasts"),
+		lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Show the txs the wallet has sent that it's sending out again till they",
+		"confirm, and the ones recently confirmed, conflicted or replaced."),
+	ShortDescription: "Show txs being rebroadcast.\n",
+}
+
+var checkdbCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.White("checkdb")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Read through all of lit's dbs and check the channels in them, and show",
+		"how much space compacting each would free up.  Compact them by running",
+		"lit with --compactdb while it's stopped."),
+	ShortDescription: "Check the dbs and channels.\n",
+}
+
+var unspentCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("unspent"),
+		lnutil.OptColor("minconf", "maxconf", "cointype"),
+		lnutil.OptColor("locked|unlocked")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show utxos with their confirmations, script type and key path.",
+		"maxconf of 0 is no max; no cointype is every wallet.",
+		"locked shows only locked utxos, unlocked leaves them out."),
+	ShortDescription: "Show utxos, filtered.\n",
+}
+
+var labelCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("label"),
+		lnutil.ReqColor("txid[:index]"), lnutil.OptColor("label")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Put a label on a wallet tx, or just one of its outputs, for accounting.",
+		"With no label, take it off.  Labels show in ls and labels."),
+	ShortDescription: "Label a tx or output.\n",
+}
+
+var labelsCommand = &Command{
+	Format: fmt.Sprintf("%s\n", lnutil.White("labels")),
+	Description: fmt.Sprintf("%s\n",
+		"Show the labels on txs and outputs in every wallet."),
+	ShortDescription: "Show tx and output labels.\n",
+}
+
+var rescanCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("rescan"),
+		lnutil.ReqColor("height"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Go over the chain again from height, for txs the wallet missed,",
+		"like after restoring from seed.  See how it's going with sync."),
+	ShortDescription: "Rescan the chain from a height.\n",
+}
+
+var syncCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("sync"),
+		lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n",
+		"Show the height a wallet is synced to, and how a rescan is going."),
+	ShortDescription: "Show wallet sync and rescan progress.\n",
+}
+
+var subscribeCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("subscribe"),
+		lnutil.ReqColor("cointype"), lnutil.OptColor("blocks", "txid/outpoint[=script]...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n",
+		"Subscribe to a coin's new blocks, if blocks is given, and to txids and",
+		"outpoints confirming and getting spent.  Cointype 0 is the default coin.",
+		"An outpoint's hex script can go after it; with compact filters, outpoints",
+		"need one, and txids can't be subscribed to.",
+		"Gives a subscription id to get the events with."),
+	ShortDescription: "Subscribe to blocks, txs and outpoints.\n",
+}
+
+var eventsCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("events"),
+		lnutil.ReqColor("subid"), lnutil.OptColor("wait")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Show a subscription's events since last time, waiting up to wait",
+		"seconds for some if there aren't any yet."),
+	ShortDescription: "Show a subscription's events.\n",
+}
+
+var unsubscribeCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("unsubscribe"),
+		lnutil.ReqColor("subid")),
+	Description:      fmt.Sprintf("%s\n", "End a subscription."),
+	ShortDescription: "End a subscription.\n",
+}
+
+var generateCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("generate"),
+		lnutil.ReqColor("blocks"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Have the regtest node mine blocks.  Needs a fullnode:// connection",
+		"to the node."),
+	ShortDescription: "Mine regtest blocks.\n",
+}
+
+var minersendCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("minersend"),
+		lnutil.ReqColor("address", "amount"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Have the regtest node's wallet send amount (in satoshis) to address,",
+		"like one of ours, to get coins to test with."),
+	ShortDescription: "Get regtest coins from the node.\n",
+}
+
+var mocktimeCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("mocktime"),
+		lnutil.ReqColor("unixtime"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n",
+		"Set the regtest node's clock, for timelocks.  0 goes back to the real time."),
+	ShortDescription: "Set the regtest node's time.\n",
+}
+
+var importxpubCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("importxpub"),
+		lnutil.ReqColor("xpub"), lnutil.OptColor("fingerprint/path", "rescanheight")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Watch an xpub, like cold storage, without holding its keys.",
+		"fingerprint/path is where it is under the signing wallet's master key,",
+		"like d34db33f/84'/0'/0'.  Give a height to rescan for its old txs."),
+	ShortDescription: "Watch an xpub without its keys.\n",
+}
+
+var xpubsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("xpubs"),
+		lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n",
+		"Show the watched xpubs, with their balances and utxos."),
+	ShortDescription: "Show watched xpubs.\n",
+}
+
+var psbtCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("psbt"),
+		lnutil.ReqColor("account", "address", "amount"), lnutil.OptColor("feerate")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Make an unsigned tx sending from a watched xpub, with change back to it,",
+		"as a psbt for the wallet with the keys to sign."),
+	ShortDescription: "Make a psbt spending from a watched xpub.\n",
+}
+
+var xpubsendCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("xpubsend"),
+		lnutil.ReqColor("account", "address", "amount"), lnutil.OptColor("feerate")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Send from a watched xpub, signing with the external signer set with",
+		"lit's --signer option, like a hardware wallet."),
+	ShortDescription: "Send from a watched xpub with the external signer.\n",
+}
+
+var pushpsbtCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("pushpsbt"),
+		lnutil.ReqColor("psbt"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n",
+		"Check the signatures on a signed psbt and send its tx."),
+	ShortDescription: "Send a signed psbt.\n",
+}
+
+var coinselectCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("coinselect"),
+		lnutil.OptColor("strategy", "cointype")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Show or set how a wallet picks inputs for sends and channel funding.",
+		"default: small utxos that are enough.  largest: biggest first.",
+		"bnb: try for no change output.  random: any order, for privacy."),
+	ShortDescription: "Show or set a wallet's coin selection.\n",
+}
+
 var addressCommand = &Command{
-	Format: fmt.Sprintf(
-		"%s%s\n", lnutil.White("adr"), lnutil.ReqColor("?amount", "?cointype")),
-	Description:      "Makes new addresses in a specified wallet.\n",
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("adr"),
+		lnutil.ReqColor("?amount", "?cointype", "?bech32|legacy|taproot"),
+		lnutil.OptColor("@account")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Makes new addresses in a specified wallet.",
+		"Shows every kind of each unless you ask for one.",
+		"With @account, the addresses are in that named account."),
 	ShortDescription: "Makes new addresses.\n",
 }
 
+var newaccountCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("newaccount"),
+		lnutil.ReqColor("name"), lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Make a named account, with its own keys and balance, like for a",
+		"routing float apart from savings.  Use it with @name in send and adr."),
+	ShortDescription: "Make a named account in a wallet.\n",
+}
+
+var accountsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.White("accounts"),
+		lnutil.OptColor("cointype")),
+	Description: fmt.Sprintf("%s\n",
+		"Show a wallet's accounts and their balances."),
+	ShortDescription: "Show a wallet's accounts.\n",
+}
+
 var fanCommand = &Command{
 	Format: fmt.Sprintf(
 		"%s%s\n", lnutil.White("fan"), lnutil.ReqColor("addr", "howmany", "howmuch")),
@@ -31,6 +287,25 @@ var fanCommand = &Command{
 	// TODO: Add description.
 }
 
+var sweepallCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("sweepall"),
+		lnutil.ReqColor("address"), lnutil.OptColor("feerate")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Send all confirmed utxos to the address in one tx, with no change.",
+		"The fee, at feerate sat / byte or the wallet's, comes out of the amount."),
+	ShortDescription: "Send everything in the wallet to an address.\n",
+}
+
+var consolidateCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.White("consolidate"),
+		lnutil.ReqColor("below"), lnutil.OptColor("maxfeerate", "auto", "cointype")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Spend all the utxos worth less than below to one new address, unless",
+		"the wallet's fee rate is over maxfeerate.  With auto, do it whenever a",
+		"block comes in and there are enough of them; auto with 0 below stops."),
+	ShortDescription: "Consolidate small utxos into one.\n",
+}
+
 var sweepCommand = &Command{
 	Format: fmt.Sprintf(
 		"%s%s%s\n", lnutil.White("sweep"),
@@ -66,6 +341,20 @@ func (lc *litAfClient) Send(textArgs []string) error {
 
 	args.DestAddrs = []string{textArgs[0]}
 	args.Amts = []int64{int64(amt)}
+	for _, a := range textArgs[2:] {
+		switch {
+		case strings.HasPrefix(a, "+"):
+			args.UseInputs = append(args.UseInputs, a[1:])
+		case strings.HasPrefix(a, "-"):
+			args.AvoidInputs = append(args.AvoidInputs, a[1:])
+		case strings.HasPrefix(a, "@"):
+			args.Account = a[1:]
+		case strings.HasPrefix(a, "change="):
+			changeArg(a, &args.ChangeAddress, &args.ChangeType, &args.ChangeAccount)
+		default:
+			args.CoinSelect = a
+		}
+	}
 
 	err = lc.Call("LitRPC.Send", args, reply)
 	if err != nil {
@@ -78,6 +367,154 @@ func (lc *litAfClient) Send(textArgs []string) error {
 	return nil
 }
 
+// changeArg fills in where change goes from a change=address, change=type
+// or change=@account arg.
+func changeArg(a string, adr, adrType, acct *string) {
+	v := strings.TrimPrefix(a, "change=")
+	switch {
+	case strings.HasPrefix(v, "@"):
+		*acct = v[1:]
+	case v == litrpc.AdrTypeBech32 || v == litrpc.AdrTypeLegacy ||
+		v == litrpc.AdrTypeTaproot:
+		*adrType = v
+	default:
+		*adr = v
+	}
+}
+
+// SendMany pays many addresses in one tx
+func (lc *litAfClient) SendMany(textArgs []string) error {
+	err := CheckHelpCommand(sendmanyCommand, textArgs, 2)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.SendManyArgs)
+	reply := new(litrpc.TxidsReply)
+
+	args.Outputs = make(map[string]int64)
+	// address amount pairs, then options
+	for len(textArgs) > 1 {
+		amt, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			break
+		}
+		if _, ok := args.Outputs[textArgs[0]]; ok {
+			return fmt.Errorf("%s is in there twice", textArgs[0])
+		}
+		args.Outputs[textArgs[0]] = int64(amt)
+		textArgs = textArgs[2:]
+	}
+	if len(args.Outputs) == 0 {
+		return fmt.Errorf("need address amount pairs")
+	}
+	for _, a := range textArgs {
+		switch {
+		case strings.HasPrefix(a, "+"):
+			args.UseInputs = append(args.UseInputs, a[1:])
+		case strings.HasPrefix(a, "-"):
+			args.AvoidInputs = append(args.AvoidInputs, a[1:])
+		case strings.HasPrefix(a, "@"):
+			args.Account = a[1:]
+		case strings.HasPrefix(a, "change="):
+			changeArg(a, &args.ChangeAddress, &args.ChangeType, &args.ChangeAccount)
+		default:
+			args.CoinSelect = a
+		}
+	}
+
+	err = lc.Call("LitRPC.SendMany", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "sent to %d addresses in txid:\n", len(args.Outputs))
+	for i, t := range reply.Txids {
+		fmt.Fprintf(color.Output, "\t%d %s\n", i, t)
+	}
+	return nil
+}
+
+// SweepAll sends all the confirmed utxos to an address in one tx
+func (lc *litAfClient) SweepAll(textArgs []string) error {
+	err := CheckHelpCommand(sweepallCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.SweepAllArgs)
+	reply := new(litrpc.TxidsReply)
+
+	args.DestAdr = textArgs[0]
+	if len(textArgs) > 1 {
+		feeint, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.FeeRate = int64(feeint)
+	}
+
+	err = lc.Call("LitRPC.SweepAll", args, reply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(color.Output, "Swept all to %s in txid %s\n",
+		lnutil.Address(args.DestAdr), reply.Txids[0])
+	return nil
+}
+
+// Consolidate spends small utxos to one, now or as blocks come in
+func (lc *litAfClient) Consolidate(textArgs []string) error {
+	err := CheckHelpCommand(consolidateCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ConsolidateArgs)
+	reply := new(litrpc.ConsolidateReply)
+
+	below, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.Below = int64(below)
+	rest := textArgs[1:]
+	if len(rest) > 0 && rest[0] != "auto" {
+		feeint, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return err
+		}
+		args.MaxFeeRate = int64(feeint)
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0] == "auto" {
+		args.Auto = true
+		rest = rest[1:]
+	}
+	// coin type 0 means default
+	if len(rest) > 0 {
+		coinint, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.Consolidate", args, reply)
+	if err != nil {
+		return err
+	}
+	if reply.Txid != "" {
+		fmt.Fprintf(color.Output, "consolidated in txid %s\n", reply.Txid)
+	}
+	if reply.Below == 0 {
+		fmt.Fprintf(color.Output, "auto consolidation off\n")
+	} else {
+		fmt.Fprintf(color.Output, "auto consolidating under %s at fee rate up to %d\n",
+			lnutil.SatoshiColor(reply.Below), reply.MaxFeeRate)
+	}
+	return nil
+}
+
 // Sweep moves utxos with many 1-in-1-out txs
 func (lc *litAfClient) Sweep(textArgs []string) error {
 	err := CheckHelpCommand(sweepCommand, textArgs, 2)
@@ -200,81 +637,1001 @@ func (lc *litAfClient) Fee(textArgs []string) error {
 	return nil
 }
 
-// ------------------ set fee
+// ------------------ bump fee
 
-func (lc *litAfClient) SetFee(textArgs []string) error {
+func (lc *litAfClient) BumpFee(textArgs []string) error {
+	err := CheckHelpCommand(bumpfeeCommand, textArgs, 2)
+	if err != nil {
+		return err
+	}
 
-	args := new(litrpc.SetFeeArgs)
-	reply := new(litrpc.FeeReply)
+	args := new(litrpc.BumpFeeArgs)
+	reply := new(litrpc.TxidsReply)
 
-	// there is at least 1 argument; that should be the new fee rate
-	if len(textArgs) > 0 {
-		feeint, err := strconv.Atoi(textArgs[0])
+	args.Txid = textArgs[0]
+	feeint, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+	args.FeeRate = int64(feeint)
+	// coin type 0 means default
+	if len(textArgs) > 2 {
+		coinint, err := strconv.Atoi(textArgs[2])
 		if err != nil {
 			return err
 		}
-		args.Fee = int64(feeint)
+		args.CoinType = uint32(coinint)
 	}
-	// there is another argument. That's the coin type. (coin type 0 means default)
+
+	err = lc.Call("LitRPC.BumpFee", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "replaced with txid %s\n", reply.Txids[0])
+	return nil
+}
+
+// ------------------ cpfp
+
+func (lc *litAfClient) Cpfp(textArgs []string) error {
+	err := CheckHelpCommand(cpfpCommand, textArgs, 2)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.CpfpArgs)
+	reply := new(litrpc.TxidsReply)
+
+	args.OutPoint = textArgs[0]
+	feeint, err := strconv.Atoi(textArgs[1])
+	if err != nil {
+		return err
+	}
+	args.FeeRate = int64(feeint)
+	// coin type 0 means default
+	if len(textArgs) > 2 {
+		coinint, err := strconv.Atoi(textArgs[2])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.CpfpSweep", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "sent child txid %s\n", reply.Txids[0])
+	return nil
+}
+
+// ------------------ utxo locks
+
+func (lc *litAfClient) Lock(textArgs []string) error {
+	err := CheckHelpCommand(lockCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.LockUtxoArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.OutPoint = textArgs[0]
 	if len(textArgs) > 1 {
-		coinint, err := strconv.Atoi(textArgs[1])
+		args.Reason = textArgs[1]
+	}
+	// coin type 0 means default
+	if len(textArgs) > 2 {
+		coinint, err := strconv.Atoi(textArgs[2])
 		if err != nil {
 			return err
 		}
 		args.CoinType = uint32(coinint)
 	}
 
-	fmt.Printf("Current fee rate %d sat / byte\n", reply.CurrentFee)
+	err = lc.Call("LitRPC.LockUtxo", args, reply)
+	if err != nil {
+		return err
+	}
 
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
 	return nil
 }
 
-// Address makes new addresses
-func (lc *litAfClient) Address(textArgs []string) error {
-	err := CheckHelpCommand(addressCommand, textArgs, 0)
+func (lc *litAfClient) Unlock(textArgs []string) error {
+	err := CheckHelpCommand(unlockCommand, textArgs, 1)
 	if err != nil {
 		return err
 	}
 
-	var cointype, numadrs uint32
+	args := new(litrpc.LockUtxoArgs)
+	reply := new(litrpc.StatusReply)
 
-	// if no arguments given, generate 1 new address.
-	// if no cointype given, assume type 1 (testnet)
-	switch len(textArgs) {
-	default: // meaning 2 or more args.  args 3+ are ignored
-		cnum, err := strconv.Atoi(textArgs[1])
+	args.OutPoint = textArgs[0]
+	// coin type 0 means default
+	if len(textArgs) > 1 {
+		coinint, err := strconv.Atoi(textArgs[1])
 		if err != nil {
 			return err
 		}
-		cointype = uint32(cnum)
-		fallthrough
-	case 1:
-		num, err := strconv.Atoi(textArgs[0])
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.UnlockUtxo", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) Locks(textArgs []string) error {
+	err := CheckHelpCommand(locksCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.CoinArgs)
+	reply := new(litrpc.ListLocksReply)
+
+	// coin type 0 means default
+	if len(textArgs) > 0 {
+		coinint, err := strconv.Atoi(textArgs[0])
 		if err != nil {
 			return err
 		}
-		numadrs = uint32(num)
-	case 0:
-		// default one new address
-		numadrs = 1
+		args.CoinType = uint32(coinint)
 	}
-	// cointype of 0 means default, not mainnet.
-	// this is ugly but does prevent mainnet use for now.
 
-	reply := new(litrpc.AddressReply)
+	err = lc.Call("LitRPC.ListLocks", args, reply)
+	if err != nil {
+		return err
+	}
 
-	args := new(litrpc.AddressArgs)
-	args.CoinType = cointype
-	args.NumToMake = numadrs
+	if len(reply.Locks) == 0 {
+		fmt.Fprintf(color.Output, "no locked utxos\n")
+	}
+	for _, l := range reply.Locks {
+		fmt.Fprintf(color.Output, "%s %s\n", lnutil.OutPoint(l.OutPoint), l.Reason)
+	}
+	return nil
+}
 
-	fmt.Printf("args: %v\n", args)
-	err = lc.Call("LitRPC.Address", args, reply)
+func (lc *litAfClient) Broadcasts(textArgs []string) error {
+	err := CheckHelpCommand(broadcastsCommand, textArgs, 0)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintf(color.Output, "new adr(s): %s\nold: %s\n",
-		lnutil.Address(reply.WitAddresses), lnutil.Address(reply.LegacyAddresses))
+	args := new(litrpc.CoinArgs)
+	reply := new(litrpc.ListBroadcastsReply)
+
+	// coin type 0 means default
+	if len(textArgs) > 0 {
+		coinint, err := strconv.Atoi(textArgs[0])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.ListBroadcasts", args, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Broadcasts) == 0 {
+		fmt.Fprintf(color.Output, "no txs being rebroadcast\n")
+	}
+	for _, b := range reply.Broadcasts {
+		fmt.Fprintf(color.Output, "%s %s", lnutil.Green(b.Txid), b.Status)
+		if b.Height != 0 {
+			fmt.Fprintf(color.Output, " at height %d", b.Height)
+		}
+		fmt.Fprintf(color.Output, ", sent %d times, last %s\n", b.Sends,
+			time.Unix(b.LastSent, 0).Format(time.RFC822))
+	}
+	return nil
+}
+
+func (lc *litAfClient) CheckDB(textArgs []string) error {
+	err := CheckHelpCommand(checkdbCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.NoArgs)
+	reply := new(litrpc.CheckDBReply)
+	err = lc.Call("LitRPC.CheckDB", args, reply)
+	if err != nil {
+		return err
+	}
+
+	for _, db := range reply.DBs {
+		fmt.Fprintf(color.Output, "%s (%s): %d buckets, %d keys; %d bytes, %d compacted\n",
+			lnutil.White(db.Path), db.Backend, db.Buckets, db.Keys, db.Before, db.After)
+	}
+	if len(reply.Problems) == 0 {
+		fmt.Fprintf(color.Output, "channels OK\n")
+	}
+	for _, p := range reply.Problems {
+		fmt.Fprintf(color.Output, "%s\n", lnutil.Red(p))
+	}
+	return nil
+}
+
+func (lc *litAfClient) Unspent(textArgs []string) error {
+	err := CheckHelpCommand(unspentCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ListUnspentArgs)
+	reply := new(litrpc.ListUnspentReply)
+
+	var nums []int64
+	for _, a := range textArgs {
+		switch a {
+		case "locked":
+			args.OnlyLocked = true
+		case "unlocked":
+			args.SkipLocked = true
+		default:
+			n, err := strconv.ParseInt(a, 10, 32)
+			if err != nil {
+				return err
+			}
+			nums = append(nums, n)
+		}
+	}
+	if len(nums) > 3 {
+		return fmt.Errorf("too many numbers; expect minconf maxconf cointype")
+	}
+	for i, n := range nums {
+		switch i {
+		case 0:
+			args.MinConf = int32(n)
+		case 1:
+			args.MaxConf = int32(n)
+		case 2:
+			args.CoinType = uint32(n)
+		}
+	}
+
+	err = lc.Call("LitRPC.ListUnspent", args, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Utxos) == 0 {
+		fmt.Fprintf(color.Output, "no utxos\n")
+	}
+	for _, u := range reply.Utxos {
+		fmt.Fprintf(color.Output, "%s %s type %d %d conf %s %s",
+			lnutil.OutPoint(u.OutPoint), lnutil.SatoshiColor(u.Amt),
+			u.CoinType, u.Confirmations, u.ScriptType, u.KeyPath)
+		if !u.Mature {
+			fmt.Fprintf(color.Output, " immature")
+		}
+		if u.Locked {
+			fmt.Fprintf(color.Output, " locked: %s", u.LockReason)
+		}
+		if u.Label != "" {
+			fmt.Fprintf(color.Output, " %s", lnutil.White(u.Label))
+		}
+		fmt.Fprintf(color.Output, "\n")
+	}
+	return nil
+}
+
+// ------------------ labels
+
+func (lc *litAfClient) Label(textArgs []string) error {
+	err := CheckHelpCommand(labelCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.LabelTxArgs)
+	reply := new(litrpc.StatusReply)
+
+	args.Target = textArgs[0]
+	args.Label = strings.Join(textArgs[1:], " ")
+
+	err = lc.Call("LitRPC.LabelTx", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) Labels(textArgs []string) error {
+	err := CheckHelpCommand(labelsCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	reply := new(litrpc.ListTxLabelsReply)
+
+	err = lc.Call("LitRPC.ListTxLabels", nil, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Labels) == 0 {
+		fmt.Fprintf(color.Output, "no labels\n")
+	}
+	for _, l := range reply.Labels {
+		fmt.Fprintf(color.Output, "%s %s\n", lnutil.OutPoint(l.Target), l.Label)
+	}
+	return nil
+}
+
+// ------------------ rescan / sync status
+
+func (lc *litAfClient) Rescan(textArgs []string) error {
+	err := CheckHelpCommand(rescanCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.RescanArgs)
+	reply := new(litrpc.StatusReply)
+
+	height, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.FromHeight = int32(height)
+	// coin type 0 means default
+	if len(textArgs) > 1 {
+		coinint, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.Rescan", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) Sync(textArgs []string) error {
+	err := CheckHelpCommand(syncCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.CoinArgs)
+	reply := new(litrpc.SyncStatusReply)
+
+	// coin type 0 means default
+	if len(textArgs) > 0 {
+		coinint, err := strconv.Atoi(textArgs[0])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.SyncStatus", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "coin type %d synced to height %d\n",
+		reply.CoinType, reply.SyncHeight)
+	if reply.Rescanning {
+		fmt.Fprintf(color.Output, "rescanning from %d: done to %d of %d\n",
+			reply.RescanFrom, reply.RescanHeight, reply.RescanTo)
+	}
+	return nil
+}
+
+// ------------------ chain event subscriptions
+
+func (lc *litAfClient) Subscribe(textArgs []string) error {
+	err := CheckHelpCommand(subscribeCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.SubscribeChainArgs)
+	reply := new(litrpc.SubscribeChainReply)
+
+	coinint, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.CoinType = uint32(coinint)
+	for _, s := range textArgs[1:] {
+		switch {
+		case s == "blocks":
+			args.Blocks = true
+		case strings.ContainsAny(s, ":;"):
+			// an outpoint can have its script after a =
+			op := strings.SplitN(s, "=", 2)
+			args.OutPoints = append(args.OutPoints, op[0])
+			if len(op) == 2 {
+				args.PkScripts = append(args.PkScripts, op[1])
+			} else {
+				args.PkScripts = append(args.PkScripts, "")
+			}
+		default:
+			args.Txids = append(args.Txids, s)
+		}
+	}
+
+	err = lc.Call("LitRPC.SubscribeChain", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "subscription %d\n", reply.SubID)
+	return nil
+}
+
+func (lc *litAfClient) Events(textArgs []string) error {
+	err := CheckHelpCommand(eventsCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ChainEventsArgs)
+	reply := new(litrpc.ChainEventsReply)
+
+	subint, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.SubID = uint32(subint)
+	if len(textArgs) > 1 {
+		args.Wait, err = strconv.ParseInt(textArgs[1], 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = lc.Call("LitRPC.ChainEvents", args, reply)
+	if err != nil {
+		return err
+	}
+
+	for _, ev := range reply.Events {
+		switch ev.Type {
+		case "block":
+			fmt.Fprintf(color.Output, "coin %d block %d %s\n",
+				ev.CoinType, ev.Height, ev.BlockHash)
+		case "spend":
+			fmt.Fprintf(color.Output, "coin %d %s spent by %s at height %d\n",
+				ev.CoinType, lnutil.OutPoint(ev.OutPoint), ev.SpendTxid, ev.Height)
+		case "reorg":
+			fmt.Fprintf(color.Output, "coin %d reorg back to %d; %s unconfirmed if above\n",
+				ev.CoinType, ev.Height, ev.Txid)
+		default:
+			fmt.Fprintf(color.Output, "coin %d tx %s at height %d\n",
+				ev.CoinType, ev.Txid, ev.Height)
+		}
+	}
+	return nil
+}
+
+func (lc *litAfClient) Unsubscribe(textArgs []string) error {
+	err := CheckHelpCommand(unsubscribeCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.UnsubscribeChainArgs)
+	reply := new(litrpc.StatusReply)
+
+	subint, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.SubID = uint32(subint)
+
+	err = lc.Call("LitRPC.UnsubscribeChain", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// ------------------ regtest
+
+func (lc *litAfClient) Generate(textArgs []string) error {
+	err := CheckHelpCommand(generateCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.GenerateArgs)
+	reply := new(litrpc.GenerateReply)
+
+	blocks, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return err
+	}
+	args.Blocks = uint32(blocks)
+	// coin type 0 means default
+	if len(textArgs) > 1 {
+		coinint, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.GenerateBlocks", args, reply)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range reply.BlockHashes {
+		fmt.Fprintf(color.Output, "mined %s\n", lnutil.Header(hash))
+	}
+	return nil
+}
+
+func (lc *litAfClient) MinerSend(textArgs []string) error {
+	err := CheckHelpCommand(minersendCommand, textArgs, 2)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.MinerSendArgs)
+	reply := new(litrpc.TxidsReply)
+
+	args.Address = textArgs[0]
+	amt, err := strconv.ParseInt(textArgs[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	args.Amt = amt
+	// coin type 0 means default
+	if len(textArgs) > 2 {
+		coinint, err := strconv.Atoi(textArgs[2])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.SendRawFromMiner", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "sent txid(s):\n")
+	for i, t := range reply.Txids {
+		fmt.Fprintf(color.Output, "\t%d %s\n", i, t)
+	}
+	return nil
+}
+
+func (lc *litAfClient) MockTime(textArgs []string) error {
+	err := CheckHelpCommand(mocktimeCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.MockTimeArgs)
+	reply := new(litrpc.StatusReply)
+
+	t, err := strconv.ParseInt(textArgs[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	args.Time = t
+	// coin type 0 means default
+	if len(textArgs) > 1 {
+		coinint, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.SetMockTime", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+// ------------------ watch-only xpubs
+
+func (lc *litAfClient) ImportXpub(textArgs []string) error {
+	err := CheckHelpCommand(importxpubCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.ImportXpubArgs)
+	reply := new(litrpc.ImportXpubReply)
+
+	args.Xpub = textArgs[0]
+	for _, arg := range textArgs[1:] {
+		// a number is the rescan height, anything else the key origin
+		height, err := strconv.Atoi(arg)
+		if err == nil {
+			args.RescanFrom = int32(height)
+			continue
+		}
+		origin := strings.SplitN(strings.Trim(arg, "[]"), "/", 2)
+		args.Fingerprint = origin[0]
+		if len(origin) > 1 {
+			args.Path = origin[1]
+		}
+	}
+
+	err = lc.Call("LitRPC.ImportXpub", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Status)
+	return nil
+}
+
+func (lc *litAfClient) Xpubs(textArgs []string) error {
+	err := CheckHelpCommand(xpubsCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.CoinArgs)
+	reply := new(litrpc.WatchAcctsReply)
+
+	// coin type 0 means default
+	if len(textArgs) > 0 {
+		coinint, err := strconv.Atoi(textArgs[0])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.WatchAccts", args, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.Accounts) == 0 {
+		fmt.Fprintf(color.Output, "no watched xpubs\n")
+	}
+	for _, a := range reply.Accounts {
+		fmt.Fprintf(color.Output, "%s %d %s [%s%s]\n",
+			lnutil.Header("Account"), a.Account, a.Xpub, a.Fingerprint,
+			strings.TrimPrefix(a.Path, "m"))
+		fmt.Fprintf(color.Output, "\t%s %s\t%s %s\n",
+			lnutil.White("Total"), lnutil.SatoshiColor(a.Total),
+			lnutil.White("Confirmed"), lnutil.SatoshiColor(a.Confirmed))
+		for _, t := range a.Txos {
+			fmt.Fprintf(color.Output, "\t%s h:%d amt:%s %s\n",
+				lnutil.OutPoint(t.OutPoint), t.Height,
+				lnutil.SatoshiColor(t.Amt), t.KeyPath)
+		}
+	}
+	return nil
+}
+
+// watchSendArgs parses account, address, amount and maybe feerate, for
+// psbt and xpubsend.
+func watchSendArgs(textArgs []string) (*litrpc.BuildPsbtArgs, error) {
+	args := new(litrpc.BuildPsbtArgs)
+
+	acct, err := strconv.Atoi(textArgs[0])
+	if err != nil {
+		return nil, err
+	}
+	args.Account = uint32(acct)
+	amt, err := strconv.Atoi(textArgs[2])
+	if err != nil {
+		return nil, err
+	}
+	args.DestAddrs = []string{textArgs[1]}
+	args.Amts = []int64{int64(amt)}
+	if len(textArgs) > 3 {
+		feeRate, err := strconv.Atoi(textArgs[3])
+		if err != nil {
+			return nil, err
+		}
+		args.FeeRate = int64(feeRate)
+	}
+	return args, nil
+}
+
+func (lc *litAfClient) Psbt(textArgs []string) error {
+	err := CheckHelpCommand(psbtCommand, textArgs, 3)
+	if err != nil {
+		return err
+	}
+
+	args, err := watchSendArgs(textArgs)
+	if err != nil {
+		return err
+	}
+	reply := new(litrpc.PsbtReply)
+
+	err = lc.Call("LitRPC.BuildPsbt", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "%s\n", reply.Psbt)
+	return nil
+}
+
+func (lc *litAfClient) XpubSend(textArgs []string) error {
+	err := CheckHelpCommand(xpubsendCommand, textArgs, 3)
+	if err != nil {
+		return err
+	}
+
+	args, err := watchSendArgs(textArgs)
+	if err != nil {
+		return err
+	}
+	reply := new(litrpc.TxidsReply)
+
+	err = lc.Call("LitRPC.SpendWatch", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "sent txid(s):\n")
+	for i, t := range reply.Txids {
+		fmt.Fprintf(color.Output, "\t%d %s\n", i, t)
+	}
+	return nil
+}
+
+func (lc *litAfClient) PushPsbt(textArgs []string) error {
+	err := CheckHelpCommand(pushpsbtCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.PushPsbtArgs)
+	reply := new(litrpc.TxidsReply)
+
+	args.Psbt = textArgs[0]
+	// coin type 0 means default
+	if len(textArgs) > 1 {
+		coinint, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.PushPsbt", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "sent txid(s):\n")
+	for i, t := range reply.Txids {
+		fmt.Fprintf(color.Output, "\t%d %s\n", i, t)
+	}
+	return nil
+}
+
+// ------------------ coin selection
+
+func (lc *litAfClient) CoinSelect(textArgs []string) error {
+	err := CheckHelpCommand(coinselectCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.CoinSelectArgs)
+	reply := new(litrpc.CoinSelectReply)
+
+	if len(textArgs) > 0 {
+		args.Strategy = textArgs[0]
+	}
+	// coin type 0 means default
+	if len(textArgs) > 1 {
+		coinint, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.CoinSelect", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "Coin selection %s (options %s)\n",
+		reply.Strategy, strings.Join(reply.Strategies, ", "))
+
+	return nil
+}
+
+// ------------------ set fee
+
+func (lc *litAfClient) SetFee(textArgs []string) error {
+
+	args := new(litrpc.SetFeeArgs)
+	reply := new(litrpc.FeeReply)
+
+	// there is at least 1 argument; that should be the new fee rate
+	if len(textArgs) > 0 {
+		feeint, err := strconv.Atoi(textArgs[0])
+		if err != nil {
+			return err
+		}
+		args.Fee = int64(feeint)
+	}
+	// there is another argument. That's the coin type. (coin type 0 means default)
+	if len(textArgs) > 1 {
+		coinint, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	fmt.Printf("Current fee rate %d sat / byte\n", reply.CurrentFee)
+
+	return nil
+}
+
+// ------------------ accounts
+
+func (lc *litAfClient) NewAccount(textArgs []string) error {
+	err := CheckHelpCommand(newaccountCommand, textArgs, 1)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.NewAccountArgs)
+	reply := new(litrpc.NewAccountReply)
+
+	args.Name = textArgs[0]
+	// coin type 0 means default
+	if len(textArgs) > 1 {
+		coinint, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.NewAccount", args, reply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(color.Output, "made account %d %s, adr %s\n",
+		reply.Account, args.Name, lnutil.Address(reply.Address))
+	return nil
+}
+
+func (lc *litAfClient) Accounts(textArgs []string) error {
+	err := CheckHelpCommand(accountsCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	args := new(litrpc.CoinArgs)
+	reply := new(litrpc.AccountsReply)
+
+	// coin type 0 means default
+	if len(textArgs) > 0 {
+		coinint, err := strconv.Atoi(textArgs[0])
+		if err != nil {
+			return err
+		}
+		args.CoinType = uint32(coinint)
+	}
+
+	err = lc.Call("LitRPC.Accounts", args, reply)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range reply.Accounts {
+		fmt.Fprintf(color.Output, "%s %d %s\t%d adrs\t%s %s\t%s %s\n",
+			lnutil.Header("Account"), a.Account, a.Name, a.NumAdrs,
+			lnutil.White("Total"), lnutil.SatoshiColor(a.TxoTotal),
+			lnutil.White("Spendable"), lnutil.SatoshiColor(a.MatureWitty))
+	}
+	return nil
+}
+
+// Address makes new addresses
+func (lc *litAfClient) Address(textArgs []string) error {
+	err := CheckHelpCommand(addressCommand, textArgs, 0)
+	if err != nil {
+		return err
+	}
+
+	var cointype, numadrs uint32
+	var adrType, account string
+
+	// @account can go anywhere
+	var posArgs []string
+	for _, a := range textArgs {
+		if strings.HasPrefix(a, "@") {
+			account = a[1:]
+		} else {
+			posArgs = append(posArgs, a)
+		}
+	}
+	textArgs = posArgs
+
+	// if no arguments given, generate 1 new address.
+	// if no cointype given, assume type 1 (testnet)
+	switch len(textArgs) {
+	default: // meaning 3 or more args.  args 4+ are ignored
+		adrType = textArgs[2]
+		fallthrough
+	case 2:
+		cnum, err := strconv.Atoi(textArgs[1])
+		if err != nil {
+			return err
+		}
+		cointype = uint32(cnum)
+		fallthrough
+	case 1:
+		num, err := strconv.Atoi(textArgs[0])
+		if err != nil {
+			return err
+		}
+		numadrs = uint32(num)
+	case 0:
+		// default one new address
+		numadrs = 1
+	}
+	// cointype of 0 means default, not mainnet.
+	// this is ugly but does prevent mainnet use for now.
+
+	reply := new(litrpc.AddressReply)
+
+	args := new(litrpc.AddressArgs)
+	args.CoinType = cointype
+	args.NumToMake = numadrs
+	args.AdrType = adrType
+	args.Account = account
+
+	fmt.Printf("args: %v\n", args)
+	err = lc.Call("LitRPC.Address", args, reply)
+	if err != nil {
+		return err
+	}
+
+	if len(reply.WitAddresses) != 0 {
+		fmt.Fprintf(color.Output, "new adr(s): %s\n",
+			lnutil.Address(reply.WitAddresses))
+	}
+	if len(reply.LegacyAddresses) != 0 {
+		fmt.Fprintf(color.Output, "old: %s\n",
+			lnutil.Address(reply.LegacyAddresses))
+	}
+	if len(reply.TaprootAddresses) != 0 {
+		fmt.Fprintf(color.Output, "taproot: %s\n",
+			lnutil.Address(reply.TaprootAddresses))
+	}
 	return nil
 
 }
@@ -22,6 +22,8 @@ var BC2NetParams = Params{
 	},
 	DiffCalcFunction:         diffBitcoin,
 	FeePerByte:               80,
+	MinRelayFee:              1,
+	DustLimit:                20000,
 	PowLimit:                 bc2NetPowLimit,
 	PowLimitBits:             0x1d7fffff,
 	CoinbaseMaturity:         10,
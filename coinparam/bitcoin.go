@@ -30,6 +30,8 @@ var BitcoinParams = Params{
 	},
 	DiffCalcFunction:         diffBitcoin,
 	FeePerByte:               80,
+	MinRelayFee:              1,
+	DustLimit:                20000,
 	PowLimit:                 mainPowLimit,
 	PowLimitBits:             0x1d00ffff,
 	CoinbaseMaturity:         100,
@@ -61,6 +63,9 @@ var BitcoinParams = Params{
 		{343185, newHashFromStr("0000000000000000072b8bf361d01a6ba7d445dd024203fafc78768ed4368554")},
 		{352940, newHashFromStr("000000000000000010755df42dba556bb72be6a32f3ce0b6941ce4430152c9ff")},
 		{382320, newHashFromStr("00000000000000000a8dc6ed5b133d0eb2fd6af56203e4159789b092defd8ab2")},
+		{400000, newHashFromStr("000000000000000004ec466ce4732fe6f1ed1cddc2ed4b328fff5224276e3f6f")},
+		{500000, newHashFromStr("00000000000000000024fb37364cbf81fd49cc2d51c09c75c35433c3a1945d04")},
+		{600000, newHashFromStr("00000000000000000007316856900e76b4f7a9139cfbfba89842c8d196cd5f91")},
 	},
 
 	// Enforce current block version once majority of the network has
@@ -117,6 +122,8 @@ var TestNet3Params = Params{
 		"ac5e6b067096bbd5dcf055fba7415aa313081a91ffefce"),
 	StartHeight:              1255968,
 	FeePerByte:               80,
+	MinRelayFee:              1,
+	DustLimit:                20000,
 	PowLimit:                 testNet3PowLimit,
 	PowLimitBits:             0x1d00ffff,
 	CoinbaseMaturity:         100,
@@ -182,6 +189,8 @@ var RegressionNetParams = Params{
 	//		return diffBTC(r, height, startheight, p, false)
 	//	},
 	FeePerByte:               80,
+	MinRelayFee:              1,
+	DustLimit:                20000,
 	PowLimit:                 regressionPowLimit,
 	PowLimitBits:             0x207fffff,
 	CoinbaseMaturity:         100,
@@ -0,0 +1,296 @@
+package coinparam
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+
+	"github.com/bitgoin/lyra2rev2"
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+Coins besides the built in ones can be given in a JSON file, as a list of
+CoinDefs, so test chains and altcoins can be used without changing the code.
+The proof of work and difficulty functions can't be given as code, so they're
+picked by name from the ones here.  Fields left out get bitcoin's values,
+except the ones that tell chains apart, which have to be there.
+
+[{
+  "name": "dogetest", "netmagic": "dcb7c1fc", "port": "44556",
+  "hdcointype": 65538, "bech32prefix": "tdoge",
+  "pubkeyhashaddrid": 113, "scripthashaddrid": 196, "privatekeyid": 241,
+  "hdprivatekeyid": "04358394", "hdpublickeyid": "043587cf",
+  "genesisheader": "01000000...", "pow": "scrypt", "powlimitbits": 504365055,
+  "feeperbyte": 1000, "minrelayfee": 100, "dustlimit": 100000000,
+  "targettimeperblock": 60
+}]
+*/
+
+// CoinDef is the JSON form of a coin's Params.  Times are in seconds, and
+// byte strings are hex.
+type CoinDef struct {
+	Name        string   `json:"name"`
+	NetMagic    string   `json:"netmagic"` // as written in 0x... form
+	DefaultPort string   `json:"port"`
+	DNSSeeds    []string `json:"dnsseeds"`
+
+	// GenesisHeader is the first block's header.  Instead, a chain can start
+	// from StartHeader at StartHeight.
+	GenesisHeader    string `json:"genesisheader"`
+	StartHeader      string `json:"startheader"`
+	StartHeight      int32  `json:"startheight"`
+	AssumeDiffBefore int32  `json:"assumediffbefore"`
+	MinHeaders       int32  `json:"minheaders"`
+
+	PoW  string `json:"pow"`  // sha256d, scrypt or lyra2rev2
+	Diff string `json:"diff"` // bitcoin or kgw
+
+	FeePerByte               int64  `json:"feeperbyte"`
+	MinRelayFee              int64  `json:"minrelayfee"`
+	DustLimit                int64  `json:"dustlimit"`
+	FundFeePerByte           int64  `json:"fundfeeperbyte"`
+	MaxCloseFee              int64  `json:"maxclosefee"`
+	MaxReorg                 int32  `json:"maxreorg"`
+	PowLimitBits             uint32 `json:"powlimitbits"`
+	CoinbaseMaturity         uint16 `json:"coinbasematurity"`
+	SubsidyReductionInterval int32  `json:"subsidyreductioninterval"`
+	TargetTimespan           int64  `json:"targettimespan"`
+	TargetTimePerBlock       int64  `json:"targettimeperblock"`
+	RetargetAdjustmentFactor int64  `json:"retargetadjustmentfactor"`
+	ReduceMinDifficulty      bool   `json:"reducemindifficulty"`
+	MinDiffReductionTime     int64  `json:"mindiffreductiontime"`
+
+	// Regtest chains are ones anyone can mine on
+	Regtest  bool `json:"regtest"`
+	TestCoin bool `json:"testcoin"`
+
+	PubKeyHashAddrID byte   `json:"pubkeyhashaddrid"`
+	ScriptHashAddrID byte   `json:"scripthashaddrid"`
+	PrivateKeyID     byte   `json:"privatekeyid"`
+	Bech32Prefix     string `json:"bech32prefix"`
+	HDPrivateKeyID   string `json:"hdprivatekeyid"`
+	HDPublicKeyID    string `json:"hdpublickeyid"`
+	HDCoinType       uint32 `json:"hdcointype"`
+}
+
+// powFunctions are the proof of work functions a CoinDef can name.
+var powFunctions = map[string]func(b []byte, height int32) chainhash.Hash{
+	"sha256d": func(b []byte, height int32) chainhash.Hash {
+		return chainhash.DoubleHashH(b)
+	},
+	"scrypt": func(b []byte, height int32) chainhash.Hash {
+		scryptBytes, _ := scrypt.Key(b, b, 1024, 1, 1, 32)
+		asChainHash, _ := chainhash.NewHash(scryptBytes)
+		return *asChainHash
+	},
+	"lyra2rev2": func(b []byte, height int32) chainhash.Hash {
+		lyraBytes, _ := lyra2rev2.Sum(b)
+		asChainHash, _ := chainhash.NewHash(lyraBytes)
+		return *asChainHash
+	},
+}
+
+// diffFunctions are the difficulty functions a CoinDef can name.
+var diffFunctions = map[string]func(
+	headers []*wire.BlockHeader, height int32, p *Params) (uint32, error){
+	"bitcoin": diffBitcoin,
+	"kgw":     diffVTC,
+}
+
+// Params makes the Params a CoinDef describes.
+func (d *CoinDef) Params() (*Params, error) {
+	if d.Name == "" {
+		return nil, fmt.Errorf("coin def has no name")
+	}
+	if d.Bech32Prefix == "" || d.DefaultPort == "" {
+		return nil, fmt.Errorf("%s: needs a port and bech32prefix", d.Name)
+	}
+	if d.HDCoinType == 0 {
+		return nil, fmt.Errorf("%s: needs an hdcointype", d.Name)
+	}
+
+	p := &Params{
+		Name:                     d.Name,
+		DefaultPort:              d.DefaultPort,
+		DNSSeeds:                 d.DNSSeeds,
+		StartHeight:              d.StartHeight,
+		AssumeDiffBefore:         d.AssumeDiffBefore,
+		MinHeaders:               d.MinHeaders,
+		FeePerByte:               d.FeePerByte,
+		MinRelayFee:              d.MinRelayFee,
+		DustLimit:                d.DustLimit,
+		FundFeePerByte:           d.FundFeePerByte,
+		MaxCloseFee:              d.MaxCloseFee,
+		MaxReorg:                 d.MaxReorg,
+		PowLimitBits:             d.PowLimitBits,
+		CoinbaseMaturity:         d.CoinbaseMaturity,
+		SubsidyReductionInterval: d.SubsidyReductionInterval,
+		TargetTimespan:           time.Second * time.Duration(d.TargetTimespan),
+		TargetTimePerBlock:       time.Second * time.Duration(d.TargetTimePerBlock),
+		RetargetAdjustmentFactor: d.RetargetAdjustmentFactor,
+		ReduceMinDifficulty:      d.ReduceMinDifficulty,
+		MinDiffReductionTime:     time.Second * time.Duration(d.MinDiffReductionTime),
+		GenerateSupported:        d.Regtest,
+		Checkpoints:              []Checkpoint{},
+		BlockEnforceNumRequired:  51,
+		BlockRejectNumRequired:   75,
+		BlockUpgradeNumToCheck:   100,
+		RelayNonStdTxs:           d.TestCoin,
+		PubKeyHashAddrID:         d.PubKeyHashAddrID,
+		ScriptHashAddrID:         d.ScriptHashAddrID,
+		PrivateKeyID:             d.PrivateKeyID,
+		Bech32Prefix:             d.Bech32Prefix,
+		HDCoinType:               d.HDCoinType,
+		TestCoin:                 d.TestCoin,
+	}
+
+	magic, err := strconv.ParseUint(d.NetMagic, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%s: netmagic %s", d.Name, err.Error())
+	}
+	p.NetMagicBytes = uint32(magic)
+
+	err = hexID(d.HDPrivateKeyID, &p.HDPrivateKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: hdprivatekeyid %s", d.Name, err.Error())
+	}
+	err = hexID(d.HDPublicKeyID, &p.HDPublicKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: hdpublickeyid %s", d.Name, err.Error())
+	}
+
+	// the header to start from, which is the genesis block's if there's
+	// no start height
+	if d.StartHeight != 0 {
+		err = hexHeader(d.StartHeader, &p.StartHeader)
+		if err != nil {
+			return nil, fmt.Errorf("%s: startheader %s", d.Name, err.Error())
+		}
+	}
+	var genesis [80]byte
+	err = hexHeader(d.GenesisHeader, &genesis)
+	if err != nil {
+		return nil, fmt.Errorf("%s: genesisheader %s", d.Name, err.Error())
+	}
+	p.GenesisBlock = new(wire.MsgBlock)
+	err = p.GenesisBlock.Header.Deserialize(bytes.NewReader(genesis[:]))
+	if err != nil {
+		return nil, fmt.Errorf("%s: genesisheader %s", d.Name, err.Error())
+	}
+	genesisHash := p.GenesisBlock.Header.BlockHash()
+	p.GenesisHash = &genesisHash
+
+	if d.PoW == "" {
+		d.PoW = "sha256d"
+	}
+	p.PoWFunction = powFunctions[d.PoW]
+	if p.PoWFunction == nil {
+		return nil, fmt.Errorf("%s: unknown pow %s", d.Name, d.PoW)
+	}
+	if d.Diff == "" {
+		d.Diff = "bitcoin"
+	}
+	p.DiffCalcFunction = diffFunctions[d.Diff]
+	if p.DiffCalcFunction == nil {
+		return nil, fmt.Errorf("%s: unknown diff %s", d.Name, d.Diff)
+	}
+
+	// bitcoin's values for whatever's left out
+	if p.FeePerByte == 0 {
+		p.FeePerByte = BitcoinParams.FeePerByte
+	}
+	if p.MinRelayFee == 0 {
+		p.MinRelayFee = BitcoinParams.MinRelayFee
+	}
+	if p.DustLimit == 0 {
+		p.DustLimit = BitcoinParams.DustLimit
+	}
+	if p.PowLimitBits == 0 {
+		p.PowLimitBits = BitcoinParams.PowLimitBits
+	}
+	p.PowLimit = CompactToBig(p.PowLimitBits)
+	if p.CoinbaseMaturity == 0 {
+		p.CoinbaseMaturity = BitcoinParams.CoinbaseMaturity
+	}
+	if p.SubsidyReductionInterval == 0 {
+		p.SubsidyReductionInterval = BitcoinParams.SubsidyReductionInterval
+	}
+	if p.TargetTimespan == 0 {
+		p.TargetTimespan = BitcoinParams.TargetTimespan
+	}
+	if p.TargetTimePerBlock == 0 {
+		p.TargetTimePerBlock = BitcoinParams.TargetTimePerBlock
+	}
+	if p.RetargetAdjustmentFactor == 0 {
+		p.RetargetAdjustmentFactor = BitcoinParams.RetargetAdjustmentFactor
+	}
+	return p, nil
+}
+
+// LoadCoinDefs reads a JSON list of CoinDefs from a file and registers the
+// coins, so they can be used like the built in ones.
+func LoadCoinDefs(filename string) ([]*Params, error) {
+	defBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var defs []CoinDef
+	err = json.Unmarshal(defBytes, &defs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+
+	var params []*Params
+	for _, def := range defs {
+		p, err := def.Params()
+		if err != nil {
+			return nil, err
+		}
+		_, err = PrefixToCoinType(p.Bech32Prefix)
+		if err == nil {
+			return nil, fmt.Errorf("%s: bech32prefix %s already used",
+				p.Name, p.Bech32Prefix)
+		}
+		err = Register(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cointype %d: %s",
+				p.Name, p.HDCoinType, err.Error())
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// hexID decodes a 4 byte hex key id.
+func hexID(s string, id *[4]byte) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != 4 {
+		return fmt.Errorf("%d bytes, expect 4", len(b))
+	}
+	copy(id[:], b)
+	return nil
+}
+
+// hexHeader decodes an 80 byte hex block header.
+func hexHeader(s string, hdr *[80]byte) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != 80 {
+		return fmt.Errorf("%d bytes, expect 80", len(b))
+	}
+	copy(hdr[:], b)
+	return nil
+}
@@ -41,6 +41,8 @@ var LiteCoinTestNet4Params = Params{
 	StartHeight:              48384,
 	AssumeDiffBefore:         50401,
 	FeePerByte:               800,
+	MinRelayFee:              10,
+	DustLimit:                100000,
 	PowLimit:                 liteCoinTestNet4PowLimit,
 	PowLimitBits:             0x1e0fffff,
 	CoinbaseMaturity:         100,
@@ -100,6 +102,8 @@ var LiteRegNetParams = Params{
 	},
 	DiffCalcFunction:         diffBitcoin,
 	FeePerByte:               800,
+	MinRelayFee:              10,
+	DustLimit:                100000,
 	PowLimit:                 regressionPowLimit,
 	PowLimitBits:             0x207fffff,
 	CoinbaseMaturity:         100,
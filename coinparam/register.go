@@ -61,6 +61,23 @@ type Params struct {
 	// Fee per byte for transactions
 	FeePerByte int64
 
+	// MinRelayFee is the lowest fee rate, in sat per byte, that nodes on
+	// the network pass on.  Txs aren't built paying less.
+	MinRelayFee int64
+
+	// DustLimit is the smallest output, in satoshis, worth making.  Less
+	// than that left over goes to miners instead.
+	DustLimit int64
+
+	// FundFeePerByte is the fee rate for channel funding txs.  0 uses the
+	// wallet's rate.
+	FundFeePerByte int64
+
+	// MaxCloseFee is the most, in satoshis, a channel we open can have its
+	// close tx pay in fees.  0 for no limit.  It doesn't stop closes; the
+	// peer has to come up with the same fee.
+	MaxCloseFee int64
+
 	// PowLimit defines the highest allowed proof of work value for a block
 	// as a uint256.
 	PowLimit *big.Int
@@ -110,6 +127,15 @@ type Params struct {
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
+	// VerifyAllPoW checks the proof of work and difficulty of headers up
+	// to the last checkpoint too, instead of just holding them to the
+	// checkpoint hashes.
+	VerifyAllPoW bool
+
+	// MaxReorg is the most blocks a reorg can take off the chain; deeper
+	// ones aren't followed.  0 for DefaultMaxReorg.
+	MaxReorg int32
+
 	// Enforce current block version once network has
 	// upgraded.  This is part of BIP0034.
 	BlockEnforceNumRequired uint64
@@ -173,6 +199,9 @@ var (
 	simNetPowLimit = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 255), bigOne)
 )
 
+// DefaultMaxReorg is the deepest reorg followed when a coin doesn't say.
+const DefaultMaxReorg = 100
+
 // Checkpoint identifies a known good point in the block chain.  Using
 // checkpoints allows a few optimizations for old blocks during initial download
 // and also prevents forks from old blocks.
@@ -185,6 +214,54 @@ type Checkpoint struct {
 	Hash   *chainhash.Hash
 }
 
+// CheckpointHash gives the hash of the checkpoint at a height, or nil if
+// there isn't one there.
+func (p *Params) CheckpointHash(height int32) *chainhash.Hash {
+	for _, cp := range p.Checkpoints {
+		if cp.Height == height {
+			return cp.Hash
+		}
+	}
+	return nil
+}
+
+// CheckpointBefore gives the height of the last checkpoint below a height,
+// or -1 if there isn't one.
+func (p *Params) CheckpointBefore(height int32) int32 {
+	before := int32(-1)
+	for _, cp := range p.Checkpoints {
+		if cp.Height < height {
+			before = cp.Height
+		}
+	}
+	return before
+}
+
+// ReorgDepth gives the deepest reorg to follow.
+func (p *Params) ReorgDepth() int32 {
+	if p.MaxReorg <= 0 {
+		return DefaultMaxReorg
+	}
+	return p.MaxReorg
+}
+
+// PoWCheckpoint gives the height of the checkpoint headers up to which
+// don't get their proof of work checked, or 0 if they all do.
+func (p *Params) PoWCheckpoint() int32 {
+	if p.VerifyAllPoW || len(p.Checkpoints) == 0 {
+		return 0
+	}
+	return p.Checkpoints[len(p.Checkpoints)-1].Height
+}
+
+// SkipPoW says whether a header at a height can go without its proof of
+// work and difficulty checked: it's at or under the last checkpoint, so it
+// has to lead up to that checkpoint's hash anyway.  Nothing can trust those
+// headers till they do; see PoWCheckpoint.  Never with VerifyAllPoW.
+func (p *Params) SkipPoW(height int32) bool {
+	return height <= p.PoWCheckpoint()
+}
+
 func init() {
 	// Register all default networks when the package is initialized.
 	mustRegister(&BitcoinParams)
@@ -261,6 +338,17 @@ func PrefixToCoinType(prefix string) (uint32, error) {
 	return coinType, nil
 }
 
+// ParamsByName returns the registered params set with the given name, or nil
+// if there isn't one.
+func ParamsByName(name string) *Params {
+	for _, p := range RegisteredNets {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
 // IsPubKeyHashAddrID returns whether the id is an identifier known to prefix a
 // pay-to-pubkey-hash address on any default or registered network.  This is
 // used when decoding an address string into a specific address type.  It is up
@@ -23,6 +23,8 @@ var VertcoinTestNetParams = Params{
 	DiffCalcFunction: diffVTCtest,
 	MinHeaders:       4032,
 	FeePerByte:       100,
+	MinRelayFee:      10,
+	DustLimit:        100000,
 	GenesisBlock:     &VertcoinTestnetGenesisBlock,
 	GenesisHash:      &VertcoinTestnetGenesisHash,
 	PowLimit:         liteCoinTestNet4PowLimit,
@@ -95,6 +97,8 @@ var VertcoinParams = Params{
 	DiffCalcFunction: diffVTC,
 	MinHeaders:       4032,
 	FeePerByte:       100,
+	MinRelayFee:      10,
+	DustLimit:        100000,
 	GenesisBlock:     &VertcoinGenesisBlock,
 	GenesisHash:      &VertcoinGenesisHash,
 	PowLimit:         liteCoinTestNet4PowLimit,
@@ -5,7 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 
-	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 )
 
@@ -19,13 +19,13 @@ var (
 func (mgr *DlcManager) InitDB(dbPath string) error {
 	var err error
 
-	mgr.DLCDB, err = bolt.Open(dbPath, 0600, nil)
+	mgr.DLCDB, err = kvdb.Open(dbPath, 0600)
 	if err != nil {
 		return err
 	}
 
 	// Ensure buckets exist that we need
-	err = mgr.DLCDB.Update(func(tx *bolt.Tx) error {
+	err = mgr.DLCDB.Update(func(tx kvdb.Tx) error {
 		_, err = tx.CreateBucketIfNotExists(BKTOracles)
 		if err != nil {
 			return err
@@ -40,7 +40,7 @@ func (mgr *DlcManager) InitDB(dbPath string) error {
 // SaveOracle saves an oracle into the database. Generates a new index if the
 // passed oracle doesn't have one
 func (mgr *DlcManager) SaveOracle(o *DlcOracle) error {
-	err := mgr.DLCDB.Update(func(tx *bolt.Tx) error {
+	err := mgr.DLCDB.Update(func(tx kvdb.Tx) error {
 		b := tx.Bucket(BKTOracles)
 
 		if o.Idx == 0 {
@@ -67,7 +67,7 @@ func (mgr *DlcManager) SaveOracle(o *DlcOracle) error {
 func (mgr *DlcManager) LoadOracle(idx uint64) (*DlcOracle, error) {
 	o := new(DlcOracle)
 
-	err := mgr.DLCDB.View(func(tx *bolt.Tx) error {
+	err := mgr.DLCDB.View(func(tx kvdb.Tx) error {
 		b := tx.Bucket(BKTOracles)
 
 		var wb bytes.Buffer
@@ -98,7 +98,7 @@ func (mgr *DlcManager) LoadOracle(idx uint64) (*DlcOracle, error) {
 // ListOracles loads all oracles from the database and returns them as an array
 func (mgr *DlcManager) ListOracles() ([]*DlcOracle, error) {
 	oracles := make([]*DlcOracle, 0)
-	err := mgr.DLCDB.View(func(tx *bolt.Tx) error {
+	err := mgr.DLCDB.View(func(tx kvdb.Tx) error {
 		b := tx.Bucket(BKTOracles)
 		c := b.Cursor()
 
@@ -124,7 +124,7 @@ func (mgr *DlcManager) ListOracles() ([]*DlcOracle, error) {
 // SaveContract saves a contract into the database. Will generate a new index
 // if the passed object doesn't have one.
 func (mgr *DlcManager) SaveContract(c *lnutil.DlcContract) error {
-	err := mgr.DLCDB.Update(func(tx *bolt.Tx) error {
+	err := mgr.DLCDB.Update(func(tx kvdb.Tx) error {
 		b := tx.Bucket(BKTContracts)
 
 		if c.Idx == 0 {
@@ -151,7 +151,7 @@ func (mgr *DlcManager) SaveContract(c *lnutil.DlcContract) error {
 func (mgr *DlcManager) LoadContract(idx uint64) (*lnutil.DlcContract, error) {
 	c := new(lnutil.DlcContract)
 
-	err := mgr.DLCDB.View(func(tx *bolt.Tx) error {
+	err := mgr.DLCDB.View(func(tx kvdb.Tx) error {
 		b := tx.Bucket(BKTContracts)
 
 		var wb bytes.Buffer
@@ -182,7 +182,7 @@ func (mgr *DlcManager) LoadContract(idx uint64) (*lnutil.DlcContract, error) {
 // ListContracts loads all contracts from the database
 func (mgr *DlcManager) ListContracts() ([]*lnutil.DlcContract, error) {
 	contracts := make([]*lnutil.DlcContract, 0)
-	err := mgr.DLCDB.View(func(tx *bolt.Tx) error {
+	err := mgr.DLCDB.View(func(tx kvdb.Tx) error {
 		b := tx.Bucket(BKTContracts)
 		c := b.Cursor()
 
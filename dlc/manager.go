@@ -1,11 +1,11 @@
 package dlc
 
 import (
-	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/kvdb"
 )
 
 type DlcManager struct {
-	DLCDB *bolt.DB
+	DLCDB kvdb.DB
 }
 
 // NewManager generates a new manager to add to the LitNode
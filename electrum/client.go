@@ -0,0 +1,247 @@
+package electrum
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/lndc"
+)
+
+/*
+Electrum servers speak json-rpc 2.0, one message per line.  Replies have the
+id of the request; notifications for subscriptions come with a method
+instead, and the subscribe call's name as the method.
+*/
+
+const (
+	// how long to wait for a server to answer a call
+	callTimeout = time.Minute
+
+	// biggest line to take from the server; a tx is at most 4MB, in hex
+	maxLine = 10000000
+)
+
+type response struct {
+	Result json.RawMessage
+	Err    error
+}
+
+// message is anything coming from the server: a reply or a notification.
+type message struct {
+	ID     *uint64         `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// dial connects to the server, through the proxy if there is one, and does
+// TLS on top if asked to.
+func (e *ServerLink) dial() (net.Conn, error) {
+	var con net.Conn
+	var err error
+	if e.proxyURL != "" {
+		dialer, err := lndc.ProxyDialer(e.proxyURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		con, err = dialer.Dial("tcp", e.addr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		con, err = net.DialTimeout("tcp", e.addr, callTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !e.useTLS {
+		return con, nil
+	}
+	host, _, err := net.SplitHostPort(e.addr)
+	if err != nil {
+		con.Close()
+		return nil, err
+	}
+	tlsCon := tls.Client(con, &tls.Config{
+		ServerName: host,
+		// lots of servers have self-signed certs
+		InsecureSkipVerify: e.insecure,
+	})
+	err = tlsCon.Handshake()
+	if err != nil {
+		con.Close()
+		return nil, err
+	}
+	return tlsCon, nil
+}
+
+// call sends a request to the server and waits for the reply, which goes
+// in result if that's not nil.
+func (e *ServerLink) call(
+	method string, params []interface{}, result interface{}) error {
+
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	e.conMtx.Lock()
+	if e.con == nil {
+		e.conMtx.Unlock()
+		return fmt.Errorf("%s: not connected", method)
+	}
+	e.nextID++
+	id := e.nextID
+	replyChan := make(chan response, 1)
+	e.pending[id] = replyChan
+
+	reqBytes, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	if err == nil {
+		_, err = e.con.Write(append(reqBytes, '\n'))
+	}
+	if err != nil {
+		delete(e.pending, id)
+		e.conMtx.Unlock()
+		return fmt.Errorf("%s: %s", method, err.Error())
+	}
+	e.conMtx.Unlock()
+
+	var reply response
+	select {
+	case reply = <-replyChan:
+	case <-time.After(callTimeout):
+		e.conMtx.Lock()
+		delete(e.pending, id)
+		e.conMtx.Unlock()
+		return fmt.Errorf("%s: no reply from server", method)
+	}
+	if reply.Err != nil {
+		return fmt.Errorf("%s: %s", method, reply.Err.Error())
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// readLoop reads from the server until the connection drops, handing out
+// replies and noting which subscriptions have news.
+func (e *ServerLink) readLoop(con net.Conn) {
+	r := bufio.NewReaderSize(con, 1<<16)
+	var err error
+	for {
+		var line []byte
+		line, err = readLine(r)
+		if err != nil {
+			break
+		}
+		var msg message
+		err = json.Unmarshal(line, &msg)
+		if err != nil {
+			break
+		}
+
+		if msg.ID == nil {
+			e.notification(msg)
+			continue
+		}
+		reply := response{Result: msg.Result}
+		if msg.Error != nil {
+			reply.Err = fmt.Errorf("%s", msg.Error.Message)
+		}
+		e.conMtx.Lock()
+		replyChan, ok := e.pending[*msg.ID]
+		delete(e.pending, *msg.ID)
+		e.conMtx.Unlock()
+		if ok {
+			replyChan <- reply
+		}
+	}
+	log.Printf("electrum server %s: %s\n", e.addr, err.Error())
+	e.disconnect(con)
+}
+
+// readLine reads one line, up to maxLine bytes long.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		part, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, part...)
+		if len(line) > maxLine {
+			return nil, fmt.Errorf("line longer than %d bytes", maxLine)
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// notification deals with a message the server sent on its own.
+func (e *ServerLink) notification(msg message) {
+	switch msg.Method {
+	case "blockchain.headers.subscribe":
+		if len(msg.Params) < 1 {
+			return
+		}
+		var tip headerInfo
+		err := json.Unmarshal(msg.Params[0], &tip)
+		if err != nil {
+			log.Printf("header notification: %s\n", err.Error())
+			return
+		}
+		e.subMtx.Lock()
+		if tip.Height > e.tip {
+			e.tip = tip.Height
+		}
+		e.subMtx.Unlock()
+	case "blockchain.scripthash.subscribe":
+		if len(msg.Params) < 1 {
+			return
+		}
+		var sh string
+		err := json.Unmarshal(msg.Params[0], &sh)
+		if err != nil {
+			log.Printf("scripthash notification: %s\n", err.Error())
+			return
+		}
+		e.subMtx.Lock()
+		e.dirty[sh] = true
+		e.subMtx.Unlock()
+	default:
+		return
+	}
+	e.poke()
+}
+
+// disconnect closes con, if it's still the connection, and fails the calls
+// waiting on it.  The sync loop connects again.
+func (e *ServerLink) disconnect(con net.Conn) {
+	e.conMtx.Lock()
+	defer e.conMtx.Unlock()
+	if e.con != con {
+		return
+	}
+	con.Close()
+	e.con = nil
+	for id, replyChan := range e.pending {
+		replyChan <- response{Err: fmt.Errorf("disconnected")}
+		delete(e.pending, id)
+	}
+	e.poke()
+}
@@ -0,0 +1,739 @@
+package electrum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+electrum is a chainhook to an Electrum server, for coins where there aren't
+peers serving filters, and running a full node is too much.  Like powless,
+it trusts the server to tell it about every tx paying or spending its
+scripts.  But a tx the server says is in a block has to come with a merkle
+proof to the header of that block, and headers have to have proof of work
+and follow on from the ones before.  The server does learn which addresses
+are ours, so it's best used with a server you run, or through tor.
+
+Each address (and taproot key) is a few scripts, and the server is asked to
+subscribe to the hash of each.  When one of them has a new tx, the server
+says so, and the script's history gets fetched again, and the new txs in it
+sent up.  Outpoints aren't something the server knows about, so for each one
+the tx that made it is fetched, and its script subscribed to; that finds the
+spend.
+
+The host string to use it looks like
+electrum://electrum.example.com:50001 for tcp, or
+electrums://electrum.example.com:50002 for TLS, with ?insecure=1 on the end
+if the server's cert is self-signed, and ?proxy=127.0.0.1:9050 to go
+through a SOCKS5 proxy.
+*/
+
+const (
+	// how often to ping the server, so it doesn't drop us
+	pingInterval = time.Minute
+
+	// how long to wait to reconnect after losing the server
+	retryInterval = 10 * time.Second
+
+	// the protocol version we speak
+	protocolVersion = "1.4"
+)
+
+// ServerLink is a chainhook to an Electrum server.
+type ServerLink struct {
+	addr     string
+	proxyURL string
+	useTLS   bool
+	insecure bool
+
+	// conMtx covers con, and the calls waiting on replies from it
+	conMtx  sync.Mutex
+	con     net.Conn
+	nextID  uint64
+	pending map[uint64]chan response
+
+	// TrackingAdrs and OPs are slices of addresses and outpoints to watch for.
+	TrackingAdrs    map[[20]byte]bool
+	TrackingAdrsMtx sync.Mutex
+	// taproot output keys; TrackingAdrsMtx covers these too
+	TrackingTapKeys map[[32]byte]bool
+
+	TrackingOPs    map[wire.OutPoint]bool
+	TrackingOPsMtx sync.Mutex
+
+	TxUpToWallit      chan lnutil.TxAndHeight
+	CurrentHeightChan chan int32
+
+	// subMtx covers the scripthashes and what's known about them, and the
+	// server's tip
+	subMtx sync.Mutex
+	// scripthashes to subscribe to, and whether they have been
+	subscribed map[string]bool
+	// scripthashes with history to look at
+	dirty map[string]bool
+	// outpoints we haven't found the script of yet
+	unknownOPs map[wire.OutPoint]bool
+	tip        int32
+	rescan     bool
+
+	// the sync loop's; height is what's been sent up, hashes the last
+	// p.ReorgDepth() block hashes, and sent the heights txs were sent up at
+	height int32
+	hashes map[int32]chainhash.Hash
+	sent   map[chainhash.Hash]int32
+
+	// newStuff pokes the sync loop
+	newStuff chan bool
+
+	rawBlockSender chan *wire.MsgBlock
+
+	p *coinparam.Params
+}
+
+// headerInfo is the server's tip, from blockchain.headers.subscribe.
+type headerInfo struct {
+	Height int32  `json:"height"`
+	Hex    string `json:"hex"`
+}
+
+// historyItem is one tx in a script's history.  Unconfirmed ones have height
+// 0, or -1 if they spend other unconfirmed txs.
+type historyItem struct {
+	TxHash string `json:"tx_hash"`
+	Height int32  `json:"height"`
+}
+
+// Start connects to the server, checks that it's on the right chain, and
+// starts sending up txs from startHeight.
+func (e *ServerLink) Start(
+	startHeight int32, host, path string, params *coinparam.Params) (
+	chan lnutil.TxAndHeight, chan int32, error) {
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "electrum":
+	case "electrums":
+		e.useTLS = true
+	default:
+		return nil, nil, fmt.Errorf(
+			"electrum host %s should start with electrum:// or electrums://", host)
+	}
+	e.addr = u.Host
+	if u.Port() == "" {
+		port := "50001"
+		if e.useTLS {
+			port = "50002"
+		}
+		e.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	e.proxyURL = u.Query().Get("proxy")
+	e.insecure = lnutil.YupString(u.Query().Get("insecure"))
+
+	e.p = params
+
+	e.pending = make(map[uint64]chan response)
+
+	e.TrackingAdrs = make(map[[20]byte]bool)
+	e.TrackingTapKeys = make(map[[32]byte]bool)
+	e.TrackingOPs = make(map[wire.OutPoint]bool)
+
+	e.subscribed = make(map[string]bool)
+	e.dirty = make(map[string]bool)
+	e.unknownOPs = make(map[wire.OutPoint]bool)
+
+	e.height = startHeight
+	e.hashes = make(map[int32]chainhash.Hash)
+	e.sent = make(map[chainhash.Hash]int32)
+
+	e.TxUpToWallit = make(chan lnutil.TxAndHeight, 1)
+	e.CurrentHeightChan = make(chan int32, 1)
+	e.newStuff = make(chan bool, 1)
+
+	err = e.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	go e.syncLoop()
+
+	return e.TxUpToWallit, e.CurrentHeightChan, nil
+}
+
+// connect dials the server, makes sure it's on our chain, and subscribes
+// to new blocks.  Scripts get subscribed to again by the sync loop.
+func (e *ServerLink) connect() error {
+	con, err := e.dial()
+	if err != nil {
+		return err
+	}
+	e.conMtx.Lock()
+	e.con = con
+	e.conMtx.Unlock()
+	go e.readLoop(con)
+
+	var version []string
+	err = e.call("server.version",
+		[]interface{}{"lit", protocolVersion}, &version)
+	if err != nil {
+		e.disconnect(con)
+		return err
+	}
+
+	genesis, err := e.headerHash(0)
+	if err != nil {
+		e.disconnect(con)
+		return err
+	}
+	if !genesis.IsEqual(e.p.GenesisHash) {
+		e.disconnect(con)
+		return fmt.Errorf("electrum server %s isn't on %s; genesis %s",
+			e.addr, e.p.Name, genesis.String())
+	}
+
+	var tip headerInfo
+	err = e.call("blockchain.headers.subscribe", nil, &tip)
+	if err != nil {
+		e.disconnect(con)
+		return err
+	}
+	log.Printf("connected to electrum server %s, %v, tip %d\n",
+		e.addr, version, tip.Height)
+
+	e.subMtx.Lock()
+	e.tip = tip.Height
+	for sh := range e.subscribed {
+		e.subscribed[sh] = false
+	}
+	e.subMtx.Unlock()
+	return nil
+}
+
+// connected says if there's a connection to the server.
+func (e *ServerLink) connected() bool {
+	e.conMtx.Lock()
+	defer e.conMtx.Unlock()
+	return e.con != nil
+}
+
+// scriptHash is how electrum servers index scripts: the sha256, backwards,
+// in hex.
+func scriptHash(pkScript []byte) string {
+	h := sha256.Sum256(pkScript)
+	for i := 0; i < len(h)/2; i++ {
+		h[i], h[len(h)-1-i] = h[len(h)-1-i], h[i]
+	}
+	return hex.EncodeToString(h[:])
+}
+
+// watchScript adds a script to subscribe to.
+func (e *ServerLink) watchScript(pkScript []byte) {
+	sh := scriptHash(pkScript)
+	e.subMtx.Lock()
+	if _, ok := e.subscribed[sh]; !ok {
+		e.subscribed[sh] = false
+	}
+	e.subMtx.Unlock()
+	e.poke()
+}
+
+// RegisterAddress tells the server to look for txs with an address, p2wpkh
+// or p2pkh.
+func (e *ServerLink) RegisterAddress(adr160 [20]byte) error {
+	e.TrackingAdrsMtx.Lock()
+	e.TrackingAdrs[adr160] = true
+	e.TrackingAdrsMtx.Unlock()
+
+	pkhScript, err := lnutil.PayToPubKeyHashScript(adr160[:])
+	if err != nil {
+		return err
+	}
+	e.watchScript(lnutil.DirectWPKHScriptFromPKH(adr160))
+	e.watchScript(pkhScript)
+	return nil
+}
+
+// RegisterTaprootKey tells the server to look for txs with a taproot key.
+func (e *ServerLink) RegisterTaprootKey(outKey [32]byte) error {
+	e.TrackingAdrsMtx.Lock()
+	e.TrackingTapKeys[outKey] = true
+	e.TrackingAdrsMtx.Unlock()
+
+	e.watchScript(lnutil.P2TRScript(outKey))
+	return nil
+}
+
+// RegisterOutPoint looks for txs spending an outpoint.  The sync loop finds
+// the outpoint's script, and subscribes to that.
+func (e *ServerLink) RegisterOutPoint(op wire.OutPoint) error {
+	e.TrackingOPsMtx.Lock()
+	e.TrackingOPs[op] = true
+	e.TrackingOPsMtx.Unlock()
+
+	e.subMtx.Lock()
+	e.unknownOPs[op] = true
+	e.subMtx.Unlock()
+	e.poke()
+	return nil
+}
+
+// Rescan sends up the histories of everything again.  The server has them
+// all, so there's no going back over blocks, and fromHeight doesn't matter.
+func (e *ServerLink) Rescan(fromHeight int32) error {
+	if fromHeight < e.p.StartHeight {
+		return fmt.Errorf("can't rescan from %d; %s starts at %d",
+			fromHeight, e.p.Name, e.p.StartHeight)
+	}
+	e.subMtx.Lock()
+	e.rescan = true
+	e.subMtx.Unlock()
+	e.poke()
+	return nil
+}
+
+// RescanScripts is just a Rescan; the histories are by script already.
+func (e *ServerLink) RescanScripts(fromHeight, toHeight int32, scripts [][]byte) error {
+	return e.Rescan(fromHeight)
+}
+
+// BlockHash asks the server for the header at a height, and hashes it.
+func (e *ServerLink) BlockHash(height int32) (*chainhash.Hash, error) {
+	hash, err := e.headerHash(height)
+	if err != nil {
+		return nil, err
+	}
+	return &hash, nil
+}
+
+// PushTx sends a tx out through the server.
+func (e *ServerLink) PushTx(tx *wire.MsgTx) error {
+	var buf bytes.Buffer
+	err := tx.Serialize(&buf)
+	if err != nil {
+		return err
+	}
+	var txid string
+	err = e.call("blockchain.transaction.broadcast",
+		[]interface{}{hex.EncodeToString(buf.Bytes())}, &txid)
+	if err != nil {
+		return err
+	}
+	log.Printf("pushed tx %s\n", txid)
+	return nil
+}
+
+// RebroadcastTx pushes the tx to the server again.
+func (e *ServerLink) RebroadcastTx(tx *wire.MsgTx) error {
+	return e.PushTx(tx)
+}
+
+// EstimateFee asks the server for a fee rate, in sat per byte, to confirm
+// within confTarget blocks.
+func (e *ServerLink) EstimateFee(confTarget uint32) (int64, error) {
+	// coins per kilobyte, or -1 if the server doesn't know
+	var rate float64
+	err := e.call("blockchain.estimatefee", []interface{}{confTarget}, &rate)
+	if err != nil {
+		return 0, err
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("blockchain.estimatefee: no estimate")
+	}
+	return int64(math.Ceil(rate * 1e8 / 1000)), nil
+}
+
+// RawBlocks returns a channel for blocks, but Electrum servers don't give
+// out blocks, so nothing ever comes over it.
+func (e *ServerLink) RawBlocks() chan *wire.MsgBlock {
+	log.Printf("electrum server %s can't give blocks\n", e.addr)
+	e.rawBlockSender = make(chan *wire.MsgBlock, 8)
+	return e.rawBlockSender
+}
+
+// poke tells the sync loop to look for new stuff now.
+func (e *ServerLink) poke() {
+	select {
+	case e.newStuff <- true:
+	default: // already poked
+	}
+}
+
+// syncLoop keeps up with the server, forever, connecting again if the
+// connection drops.
+func (e *ServerLink) syncLoop() {
+	for {
+		if !e.connected() {
+			err := e.connect()
+			if err != nil {
+				log.Printf("electrum server %s: %s; retrying in %s\n",
+					e.addr, err.Error(), retryInterval)
+				time.Sleep(retryInterval)
+				continue
+			}
+		}
+		err := e.sync()
+		if err != nil {
+			log.Printf("%s electrum sync: %s\n", e.p.Name, err.Error())
+		}
+		select {
+		case <-e.newStuff:
+		case <-time.After(pingInterval):
+			err = e.call("server.ping", nil, nil)
+			if err != nil {
+				log.Printf("electrum ping: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// sync finds scripts of new outpoints, subscribes to new scripts, sends up
+// new txs in the histories of scripts, and then the height.
+func (e *ServerLink) sync() error {
+	err := e.findOPScripts()
+	if err != nil {
+		return err
+	}
+	err = e.subscribe()
+	if err != nil {
+		return err
+	}
+	err = e.checkReorg()
+	if err != nil {
+		return err
+	}
+
+	e.subMtx.Lock()
+	if e.rescan {
+		log.Printf("rescanning all script histories\n")
+		e.sent = make(map[chainhash.Hash]int32)
+		for sh := range e.subscribed {
+			e.dirty[sh] = true
+		}
+		e.rescan = false
+	}
+	e.subMtx.Unlock()
+
+	err = e.sendHistories()
+	if err != nil {
+		return err
+	}
+
+	e.subMtx.Lock()
+	tip := e.tip
+	e.subMtx.Unlock()
+	if tip <= e.height {
+		return nil
+	}
+	err = e.keepHashes(tip)
+	if err != nil {
+		return err
+	}
+	e.height = tip
+	e.CurrentHeightChan <- tip
+	return nil
+}
+
+// findOPScripts gets the txs that made outpoints, and watches their scripts.
+// Outpoints whose txs the server doesn't have yet are tried again later.
+func (e *ServerLink) findOPScripts() error {
+	e.subMtx.Lock()
+	var ops []wire.OutPoint
+	for op := range e.unknownOPs {
+		ops = append(ops, op)
+	}
+	e.subMtx.Unlock()
+
+	for _, op := range ops {
+		tx, err := e.getTx(op.Hash)
+		if err != nil {
+			// probably not out yet; it gets subscribed once it is
+			continue
+		}
+		if int(op.Index) >= len(tx.TxOut) {
+			log.Printf("outpoint %s doesn't exist; not watching\n", op.String())
+		} else {
+			e.watchScript(tx.TxOut[op.Index].PkScript)
+		}
+		e.subMtx.Lock()
+		delete(e.unknownOPs, op)
+		e.subMtx.Unlock()
+	}
+	return nil
+}
+
+// subscribe subscribes to scripts that aren't yet.  Ones that already have
+// history get looked at.
+func (e *ServerLink) subscribe() error {
+	e.subMtx.Lock()
+	var toSub []string
+	for sh, done := range e.subscribed {
+		if !done {
+			toSub = append(toSub, sh)
+		}
+	}
+	e.subMtx.Unlock()
+
+	for _, sh := range toSub {
+		// status is a hash of the history, or null if there is none
+		var status *string
+		err := e.call("blockchain.scripthash.subscribe",
+			[]interface{}{sh}, &status)
+		if err != nil {
+			return err
+		}
+		e.subMtx.Lock()
+		e.subscribed[sh] = true
+		if status != nil {
+			e.dirty[sh] = true
+		}
+		e.subMtx.Unlock()
+	}
+	return nil
+}
+
+// sendHistories gets the histories of scripts that have changed, and sends
+// up the txs that are new, or at new heights.
+func (e *ServerLink) sendHistories() error {
+	e.subMtx.Lock()
+	dirty := e.dirty
+	e.dirty = make(map[string]bool)
+	e.subMtx.Unlock()
+
+	found := make(map[chainhash.Hash]int32)
+	for sh := range dirty {
+		var history []historyItem
+		err := e.call("blockchain.scripthash.get_history",
+			[]interface{}{sh}, &history)
+		if err != nil {
+			// look again next time
+			e.subMtx.Lock()
+			for sh := range dirty {
+				e.dirty[sh] = true
+			}
+			e.subMtx.Unlock()
+			return err
+		}
+		for _, item := range history {
+			txid, err := chainhash.NewHashFromStr(item.TxHash)
+			if err != nil {
+				return err
+			}
+			if item.Height < 0 {
+				item.Height = 0
+			}
+			found[*txid] = item.Height
+		}
+	}
+
+	var txahs []lnutil.TxAndHeight
+	for txid, height := range found {
+		sentHeight, ok := e.sent[txid]
+		if ok && sentHeight == height {
+			continue
+		}
+		tx, err := e.getTx(txid)
+		if err != nil {
+			return err
+		}
+		if height != 0 {
+			// don't take the server's word that it's in a block
+			err = e.checkMerkle(txid, height)
+			if err != nil {
+				return err
+			}
+		}
+		txahs = append(txahs, lnutil.TxAndHeight{Tx: tx, Height: height})
+	}
+
+	// in order, with unconfirmed ones last
+	sort.Slice(txahs, func(i, j int) bool {
+		hi, hj := txahs[i].Height, txahs[j].Height
+		if hi == 0 || hj == 0 {
+			return hj == 0 && hi != 0
+		}
+		return hi < hj
+	})
+	for _, txah := range txahs {
+		// MatchTx registers outputs we gain, so it has to go in order too
+		if e.MatchTx(txah.Tx) {
+			log.Printf("found matching tx %s at height %d\n",
+				txah.Tx.TxHash().String(), txah.Height)
+			e.TxUpToWallit <- txah
+		}
+		e.sent[txah.Tx.TxHash()] = txah.Height
+	}
+	return nil
+}
+
+// checkReorg sees if the last block sent up is still in the server's chain,
+// and if not, goes back to where it forked, and sends up everything after
+// that again.
+func (e *ServerLink) checkReorg() error {
+	var reorged bool
+	for {
+		oldHash, ok := e.hashes[e.height]
+		if !ok {
+			break
+		}
+		hash, err := e.headerHash(e.height)
+		if err == nil && hash.IsEqual(&oldHash) {
+			break
+		}
+		delete(e.hashes, e.height)
+		e.height--
+		reorged = true
+	}
+	if !reorged {
+		return nil
+	}
+	log.Printf("reorg; back to height %d\n", e.height)
+	for txid, height := range e.sent {
+		if height > e.height || height == 0 {
+			delete(e.sent, txid)
+		}
+	}
+	e.subMtx.Lock()
+	for sh := range e.subscribed {
+		e.dirty[sh] = true
+	}
+	e.subMtx.Unlock()
+	e.CurrentHeightChan <- e.height
+	return nil
+}
+
+// keepHashes keeps the hashes of blocks up to tip, to find reorgs by.
+func (e *ServerLink) keepHashes(tip int32) error {
+	start := e.height + 1
+	depth := e.p.ReorgDepth()
+	if start < tip-depth+1 {
+		start = tip - depth + 1
+	}
+	var chunk struct {
+		Count int32  `json:"count"`
+		Hex   string `json:"hex"`
+	}
+	err := e.call("blockchain.block.headers",
+		[]interface{}{start, tip - start + 1}, &chunk)
+	if err != nil {
+		return err
+	}
+	hdrBytes, err := hex.DecodeString(chunk.Hex)
+	if err != nil {
+		return err
+	}
+	r := bytes.NewReader(hdrBytes)
+	for i := int32(0); i < chunk.Count; i++ {
+		var hdr wire.BlockHeader
+		err = hdr.Deserialize(r)
+		if err != nil {
+			return err
+		}
+		// the headers have to have work, and follow on from each other
+		err = checkPoW(&hdr, e.p, start+i)
+		if err != nil {
+			return err
+		}
+		prev, ok := e.hashes[start+i-1]
+		if ok && !prev.IsEqual(&hdr.PrevBlock) {
+			return fmt.Errorf("block %d header doesn't follow %s",
+				start+i, prev.String())
+		}
+		e.hashes[start+i] = hdr.BlockHash()
+		delete(e.hashes, start+i-depth)
+	}
+	return nil
+}
+
+// headerHash gets the hash of the block at height.
+func (e *ServerLink) headerHash(height int32) (chainhash.Hash, error) {
+	hdr, err := e.header(height)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	return hdr.BlockHash(), nil
+}
+
+// getTx gets a tx from the server.
+func (e *ServerLink) getTx(txid chainhash.Hash) (*wire.MsgTx, error) {
+	var txHex string
+	err := e.call("blockchain.transaction.get",
+		[]interface{}{txid.String()}, &txHex)
+	if err != nil {
+		return nil, err
+	}
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+	tx := wire.NewMsgTx()
+	err = tx.Deserialize(bytes.NewReader(txBytes))
+	if err != nil {
+		return nil, err
+	}
+	if tx.TxHash() != txid {
+		return nil, fmt.Errorf("asked for tx %s, got %s",
+			txid.String(), tx.TxHash().String())
+	}
+	return tx, nil
+}
+
+// MatchTx says if a tx pays a registered address or key, or spends or
+// confirms a registered outpoint.  Outputs it gains get registered.
+func (e *ServerLink) MatchTx(tx *wire.MsgTx) bool {
+	gain := false
+	txid := tx.TxHash()
+
+	e.TrackingAdrsMtx.Lock()
+	defer e.TrackingAdrsMtx.Unlock()
+	e.TrackingOPsMtx.Lock()
+	defer e.TrackingOPsMtx.Unlock()
+
+	for i, out := range tx.TxOut {
+		op := wire.NewOutPoint(&txid, uint32(i))
+
+		var adr20 [20]byte
+		copy(adr20[:], lnutil.KeyHashFromPkScript(out.PkScript))
+		var tapKey [32]byte
+		copy(tapKey[:], lnutil.TaprootKeyFromPkScript(out.PkScript))
+
+		if e.TrackingAdrs[adr20] || e.TrackingTapKeys[tapKey] {
+			gain = true
+			e.TrackingOPs[*op] = true
+		}
+		if e.TrackingOPs[*op] {
+			gain = true
+		}
+	}
+	if gain {
+		return true
+	}
+
+	for _, in := range tx.TxIn {
+		if e.TrackingOPs[in.PreviousOutPoint] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsElectrumHost says if a host string is for this chainhook.
+func IsElectrumHost(host string) bool {
+	return strings.HasPrefix(host, "electrum://") ||
+		strings.HasPrefix(host, "electrums://")
+}
@@ -0,0 +1,121 @@
+package electrum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/adiabat/btcd/blockchain"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/coinparam"
+)
+
+// merkleProof is the merkle branch of a tx, from
+// blockchain.transaction.get_merkle.
+type merkleProof struct {
+	BlockHeight int32    `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         uint32   `json:"pos"`
+}
+
+// checkPoW errors if a header's hash isn't under the target in its bits,
+// or the target is over the coin's limit.
+func checkPoW(hdr *wire.BlockHeader, p *coinparam.Params, height int32) error {
+	target := blockchain.CompactToBig(hdr.Bits)
+	if target.Sign() <= 0 || target.Cmp(p.PowLimit) > 0 {
+		return fmt.Errorf("block %d target %064x out of range", height, target)
+	}
+	var buf bytes.Buffer
+	err := hdr.Serialize(&buf)
+	if err != nil {
+		return err
+	}
+	powHash := p.PoWFunction(buf.Bytes(), height)
+	if blockchain.HashToBig(&powHash).Cmp(target) > 0 {
+		return fmt.Errorf("block %d hash %s over its target %064x",
+			height, powHash.String(), target)
+	}
+	return nil
+}
+
+// merkleRoot gives the merkle root a tx at pos in a block makes with
+// branch, the hashes next to it on the way up.
+func merkleRoot(
+	txid chainhash.Hash, branch []chainhash.Hash, pos uint32) chainhash.Hash {
+	h := txid
+	for _, b := range branch {
+		if pos&1 == 1 {
+			h = chainhash.DoubleHashH(append(b[:], h[:]...))
+		} else {
+			h = chainhash.DoubleHashH(append(h[:], b[:]...))
+		}
+		pos >>= 1
+	}
+	return h
+}
+
+// header gets the header of the block at height, and checks its proof of
+// work.
+func (e *ServerLink) header(height int32) (*wire.BlockHeader, error) {
+	var hdrHex string
+	err := e.call("blockchain.block.header", []interface{}{height}, &hdrHex)
+	if err != nil {
+		return nil, err
+	}
+	hdrBytes, err := hex.DecodeString(hdrHex)
+	if err != nil {
+		return nil, err
+	}
+	hdr := new(wire.BlockHeader)
+	err = hdr.Deserialize(bytes.NewReader(hdrBytes))
+	if err != nil {
+		return nil, err
+	}
+	err = checkPoW(hdr, e.p, height)
+	if err != nil {
+		return nil, err
+	}
+	return hdr, nil
+}
+
+// checkMerkle makes sure a tx is in the block at height: the server's
+// merkle branch for it has to come to the merkle root of a header with
+// proof of work, which is the block we have at that height if we have one.
+func (e *ServerLink) checkMerkle(txid chainhash.Hash, height int32) error {
+	var proof merkleProof
+	err := e.call("blockchain.transaction.get_merkle",
+		[]interface{}{txid.String(), height}, &proof)
+	if err != nil {
+		return err
+	}
+	if proof.BlockHeight != height || len(proof.Merkle) > 32 {
+		return fmt.Errorf("tx %s merkle proof for block %d, %d long; expect %d",
+			txid.String(), proof.BlockHeight, len(proof.Merkle), height)
+	}
+	branch := make([]chainhash.Hash, len(proof.Merkle))
+	for i, s := range proof.Merkle {
+		h, err := chainhash.NewHashFromStr(s)
+		if err != nil {
+			return err
+		}
+		branch[i] = *h
+	}
+
+	hdr, err := e.header(height)
+	if err != nil {
+		return err
+	}
+	hash := hdr.BlockHash()
+	if kept, ok := e.hashes[height]; ok && !kept.IsEqual(&hash) {
+		return fmt.Errorf("server's block %d is %s, we have %s",
+			height, hash.String(), kept.String())
+	}
+	root := merkleRoot(txid, branch, proof.Pos)
+	if !root.IsEqual(&hdr.MerkleRoot) {
+		return fmt.Errorf("tx %s isn't in block %d; proof gives root %s, "+
+			"header has %s", txid.String(), height, root.String(),
+			hdr.MerkleRoot.String())
+	}
+	return nil
+}
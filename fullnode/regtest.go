@@ -0,0 +1,119 @@
+package fullnode
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+)
+
+// regtestOnly makes sure the node's on a chain where anyone can mine.
+func (f *RPCLink) regtestOnly() error {
+	if !f.p.GenerateSupported {
+		return fmt.Errorf("%s isn't a regtest chain", f.p.Name)
+	}
+	return nil
+}
+
+// GenerateBlocks has the node mine n blocks, to an address of its wallet.
+func (f *RPCLink) GenerateBlocks(n uint32) ([]chainhash.Hash, error) {
+	err := f.regtestOnly()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("can't generate 0 blocks")
+	}
+
+	var adr string
+	err = f.call("getnewaddress", nil, &adr)
+	if err != nil {
+		return nil, err
+	}
+	var hashStrs []string
+	err = f.call("generatetoaddress", []interface{}{n, adr}, &hashStrs)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]chainhash.Hash, len(hashStrs))
+	for i, s := range hashStrs {
+		hash, err := chainhash.NewHashFromStr(s)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = *hash
+	}
+	// no need to wait for zmq, or the poll
+	f.poke()
+	return hashes, nil
+}
+
+// SendRawFromMiner has the node's wallet pay amt to adr.  It's a raw tx the
+// wallet funds, so it pays the coin's fee rate; regtest nodes usually can't
+// estimate one for sendtoaddress.
+func (f *RPCLink) SendRawFromMiner(adr string, amt int64) (chainhash.Hash, error) {
+	err := f.regtestOnly()
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	if amt <= 0 {
+		return chainhash.Hash{}, fmt.Errorf("can't send %d", amt)
+	}
+
+	var rawHex string
+	err = f.call("createrawtransaction", []interface{}{
+		[]interface{}{},
+		map[string]interface{}{adr: float64(amt) / 1e8},
+	}, &rawHex)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	// fee rates here are in coins per kilobyte
+	var funded struct {
+		Hex string `json:"hex"`
+	}
+	err = f.call("fundrawtransaction", []interface{}{
+		rawHex,
+		map[string]interface{}{"feeRate": float64(f.p.FeePerByte) * 1000 / 1e8},
+	}, &funded)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	var signed struct {
+		Hex      string `json:"hex"`
+		Complete bool   `json:"complete"`
+	}
+	err = f.call("signrawtransactionwithwallet",
+		[]interface{}{funded.Hex}, &signed)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	if !signed.Complete {
+		return chainhash.Hash{}, fmt.Errorf("node's wallet couldn't sign the tx")
+	}
+
+	var txid string
+	err = f.call("sendrawtransaction", []interface{}{signed.Hex}, &txid)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	return *hash, nil
+}
+
+// SetMockTime sets the node's clock; 0 goes back to the real time.
+func (f *RPCLink) SetMockTime(t int64) error {
+	err := f.regtestOnly()
+	if err != nil {
+		return err
+	}
+	if t < 0 {
+		return fmt.Errorf("can't set time to %d", t)
+	}
+	return f.call("setmocktime", []interface{}{t}, nil)
+}
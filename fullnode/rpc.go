@@ -0,0 +1,54 @@
+package fullnode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// call sends a json-rpc request to the node, and puts the result in result
+// if that's not nil.
+func (f *RPCLink) call(
+	method string, params []interface{}, result interface{}) error {
+
+	if params == nil {
+		params = []interface{}{}
+	}
+	reqBytes, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0",
+		"id":      "lit",
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	response, err := f.client.Post(f.url, "text/plain", bytes.NewReader(reqBytes))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%s: bad rpc user / password", method)
+	}
+
+	// errors come with a 500 status, but still have a json body
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&reply)
+	if err != nil {
+		return fmt.Errorf("%s: %s", method, err.Error())
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("%s: %s", method, reply.Error.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
@@ -0,0 +1,227 @@
+package fullnode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+The node's zmq notifications come over ZMTP, zmq's wire protocol.  This
+speaks just enough of ZMTP 3.0 to be a SUB socket with no security, which is
+what bitcoind's zmqpub options give: a greeting each way, a READY command
+each way, then a subscription message for each topic.  After that messages
+come in as frames; bitcoind's are topic, body, and a sequence number.
+
+frame: 1 flags, then size (1 byte, or 8 if the long flag's set), then body
+*/
+
+const (
+	zmqFlagMore    = 0x01
+	zmqFlagLong    = 0x02
+	zmqFlagCommand = 0x04
+
+	// biggest frame to take; blocks aren't subscribed to, so txs are the
+	// biggest thing coming
+	zmqMaxFrame = 4000000
+
+	// how long to wait to reconnect after losing the node
+	zmqRetry = 10 * time.Second
+)
+
+// zmqLoop listens for notifications at endpoint, reconnecting when the
+// connection drops.  A hashblock pokes the sync loop, and a rawtx that
+// matches gets sent up unconfirmed.  Every rawtx goes to the mempool chan,
+// if someone's asked for it.
+func (f *RPCLink) zmqLoop(endpoint string) {
+	for {
+		err := f.zmqListen(endpoint)
+		log.Printf("zmq %s: %s; retrying in %s\n", endpoint, err.Error(), zmqRetry)
+		time.Sleep(zmqRetry)
+	}
+}
+
+// zmqListen connects and reads notifications until there's an error.
+func (f *RPCLink) zmqListen(endpoint string) error {
+	addr := strings.TrimPrefix(endpoint, "tcp://")
+	con, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	r := bufio.NewReader(con)
+	err = zmqHandshake(con, r)
+	if err != nil {
+		return err
+	}
+	for _, topic := range []string{"hashblock", "rawtx"} {
+		// a subscription is a message starting with 1
+		err = zmqWriteFrame(con, 0, append([]byte{1}, topic...))
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("listening for zmq notifications at %s\n", endpoint)
+
+	for {
+		msg, err := zmqReadMsg(r)
+		if err != nil {
+			return err
+		}
+		if len(msg) < 2 {
+			continue
+		}
+		switch string(msg[0]) {
+		case "hashblock":
+			f.poke()
+		case "rawtx":
+			tx := wire.NewMsgTx()
+			err = tx.Deserialize(bytes.NewReader(msg[1]))
+			if err != nil {
+				log.Printf("zmq rawtx: %s\n", err.Error())
+				continue
+			}
+			if cap(f.mempoolSender) != 0 {
+				select {
+				case f.mempoolSender <- tx:
+				default:
+					log.Printf("mempool chan full; dropped tx %s\n",
+						tx.TxHash().String())
+				}
+			}
+			if f.MatchTx(tx) {
+				log.Printf("found matching unconfirmed tx %s\n", tx.TxHash().String())
+				f.TxUpToWallit <- lnutil.TxAndHeight{Tx: tx, Height: 0}
+			}
+		}
+	}
+}
+
+// zmqHandshake does the greetings and READY commands for a SUB socket.
+func zmqHandshake(w io.Writer, r *bufio.Reader) error {
+	// signature, version 3.0, NULL mechanism, not a server, filler
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3
+	copy(greeting[12:], "NULL")
+	_, err := w.Write(greeting)
+	if err != nil {
+		return err
+	}
+
+	theirs := make([]byte, 64)
+	_, err = io.ReadFull(r, theirs)
+	if err != nil {
+		return err
+	}
+	if theirs[0] != 0xff || theirs[9] != 0x7f || theirs[10] < 3 {
+		return fmt.Errorf("not a ZMTP 3 greeting: %x", theirs[:11])
+	}
+	if string(bytes.TrimRight(theirs[12:32], "\x00")) != "NULL" {
+		return fmt.Errorf("zmq security %s; only NULL works",
+			bytes.TrimRight(theirs[12:32], "\x00"))
+	}
+
+	// READY, with the one property: Socket-Type SUB
+	var ready []byte
+	ready = append(ready, 5)
+	ready = append(ready, "READY"...)
+	ready = append(ready, 11)
+	ready = append(ready, "Socket-Type"...)
+	ready = append(ready, 0, 0, 0, 3)
+	ready = append(ready, "SUB"...)
+	err = zmqWriteFrame(w, zmqFlagCommand, ready)
+	if err != nil {
+		return err
+	}
+
+	flags, body, err := zmqReadFrame(r)
+	if err != nil {
+		return err
+	}
+	if flags&zmqFlagCommand == 0 || len(body) < 1 || len(body) < 1+int(body[0]) {
+		return fmt.Errorf("expected READY, got frame %x", body)
+	}
+	name := string(body[1 : 1+int(body[0])])
+	if name != "READY" {
+		return fmt.Errorf("expected READY, got %s", name)
+	}
+	return nil
+}
+
+// zmqWriteFrame writes one frame.
+func zmqWriteFrame(w io.Writer, flags byte, body []byte) error {
+	var frame []byte
+	if len(body) > 255 {
+		frame = append(frame, flags|zmqFlagLong)
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(body)))
+		frame = append(frame, size[:]...)
+	} else {
+		frame = append(frame, flags, byte(len(body)))
+	}
+	_, err := w.Write(append(frame, body...))
+	return err
+}
+
+// zmqReadFrame reads one frame.
+func zmqReadFrame(r *bufio.Reader) (byte, []byte, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&zmqFlagLong != 0 {
+		var sizeBytes [8]byte
+		_, err = io.ReadFull(r, sizeBytes[:])
+		if err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(sizeBytes[:])
+	} else {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > zmqMaxFrame {
+		return 0, nil, fmt.Errorf("%d byte zmq frame, max %d", size, zmqMaxFrame)
+	}
+	body := make([]byte, size)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+// zmqReadMsg reads the frames of the next message.  Commands, like
+// heartbeats, are skipped.
+func zmqReadMsg(r *bufio.Reader) ([][]byte, error) {
+	var msg [][]byte
+	for {
+		flags, body, err := zmqReadFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if flags&zmqFlagCommand != 0 {
+			continue
+		}
+		msg = append(msg, body)
+		if flags&zmqFlagMore == 0 {
+			return msg, nil
+		}
+	}
+}
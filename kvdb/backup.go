@@ -0,0 +1,95 @@
+package kvdb
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+/*
+Backups are bbolt files whatever the backend, since one file is easy to
+move around, and opening one with badger moves it over.  A snapshot is
+read in one transaction, so it's the db as it was at one moment, while
+lit keeps using it.  Encrypted dbs are copied as they're stored, so their
+backups are encrypted too, with the same key.
+*/
+
+// Snapshot copies db as it is now to a new bbolt file at path.
+func Snapshot(db DB, path string) error {
+	if edb, ok := db.(*encDB); ok {
+		db = edb.raw
+	}
+	// write it somewhere else first, so there's never half a backup at path
+	tmpPath := path + ".tmp"
+	err := os.RemoveAll(tmpPath)
+	if err != nil {
+		return err
+	}
+	dst, err := openStore(BackendBolt, tmpPath, 0600)
+	if err != nil {
+		return err
+	}
+	err = Migrate(db, dst)
+	dst.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("snapshot of %s: %s", db.Path(), err.Error())
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Restore puts the backup file at backup in place of the db at path, which
+// mustn't be open.  Whatever was there is renamed, not deleted.
+func Restore(backup, path string) error {
+	// make sure it's a db before moving anything
+	bdb, err := openStore(BackendBolt, backup, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = Check(bdb)
+	bdb.Close()
+	if err != nil {
+		return fmt.Errorf("backup %s: %s", backup, err.Error())
+	}
+
+	now := time.Now().Unix()
+	for _, backend := range Backends {
+		p, _ := storePath(backend, path)
+		_, err = os.Stat(p)
+		if err != nil {
+			continue
+		}
+		oldPath := fmt.Sprintf("%s.prerestore-%d", p, now)
+		log.Printf("moving %s to %s\n", p, oldPath)
+		err = os.Rename(p, oldPath)
+		if err != nil {
+			return err
+		}
+	}
+	return copyFile(backup, path)
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = out.Sync()
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
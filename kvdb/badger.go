@@ -0,0 +1,390 @@
+package kvdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+/*
+badger has one flat keyspace, so buckets are key prefixes.  A bucket's
+prefix is the names of the buckets down to it, each as 0x01, its length
+as a uvarint, and the name.  Its keys are the prefix, 0x00, then the key,
+so they sort together, ahead of what's in the buckets inside it.  The top
+level is the empty prefix.
+
+Each key's stored value starts with a byte saying what it is:
+v	a value; the rest is the value
+b	a bucket; the rest is its 8 byte sequence
+
+Only one update runs at a time, like with bolt, so they never conflict.
+*/
+
+const (
+	badgerKey       = 0x00
+	badgerBucketSep = 0x01
+
+	typeValue  = 'v'
+	typeBucket = 'b'
+)
+
+type badgerDB struct {
+	db   *badger.DB
+	path string
+	// updates go one at a time
+	updateMtx sync.Mutex
+}
+
+type badgerTx struct {
+	txn *badger.Txn
+	// root is the top level, which holds the buckets
+	root *badgerBucket
+}
+
+type badgerBucket struct {
+	txn    *badger.Txn
+	prefix []byte // prefix of keys in buckets in this one
+	// key is where this bucket is in the one it's in, which has
+	// parentPrefix; nil at the top
+	key          []byte
+	parentPrefix []byte
+}
+
+type badgerCursor struct {
+	b   *badgerBucket
+	cur []byte // key it's on, nil if none
+}
+
+func openBadger(path, dir string) (DB, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return nil, err
+	}
+	return &badgerDB{db: db, path: path}, nil
+}
+
+func newBadgerTx(txn *badger.Txn) *badgerTx {
+	return &badgerTx{txn: txn, root: &badgerBucket{txn: txn}}
+}
+
+func (d *badgerDB) Update(fn func(Tx) error) error {
+	d.updateMtx.Lock()
+	defer d.updateMtx.Unlock()
+	return d.db.Update(func(txn *badger.Txn) error { return fn(newBadgerTx(txn)) })
+}
+
+func (d *badgerDB) View(fn func(Tx) error) error {
+	return d.db.View(func(txn *badger.Txn) error { return fn(newBadgerTx(txn)) })
+}
+
+func (d *badgerDB) Close() error {
+	return d.db.Close()
+}
+
+func (d *badgerDB) Path() string {
+	return d.path
+}
+
+// the top level works just like a bucket, but gives out buckets only
+
+func (t *badgerTx) Bucket(name []byte) Bucket {
+	return t.root.Bucket(name)
+}
+
+func (t *badgerTx) CreateBucket(name []byte) (Bucket, error) {
+	return t.root.CreateBucket(name)
+}
+
+func (t *badgerTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	return t.root.CreateBucketIfNotExists(name)
+}
+
+func (t *badgerTx) DeleteBucket(name []byte) error {
+	return t.root.DeleteBucket(name)
+}
+
+func (t *badgerTx) ForEach(fn func(name []byte, b Bucket) error) error {
+	return t.root.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return fn(k, t.root.Bucket(k))
+	})
+}
+
+// badgerBucketPrefix gives the prefix for things in a bucket named name
+// inside one with prefix.
+func badgerBucketPrefix(prefix, name []byte) []byte {
+	p := make([]byte, 0, len(prefix)+1+binary.MaxVarintLen64+len(name))
+	p = append(p, prefix...)
+	p = append(p, badgerBucketSep)
+	var l [binary.MaxVarintLen64]byte
+	p = append(p, l[:binary.PutUvarint(l[:], uint64(len(name)))]...)
+	return append(p, name...)
+}
+
+// keyPrefix is what all the bucket's own keys start with.
+func (b *badgerBucket) keyPrefix() []byte {
+	return append(append([]byte(nil), b.prefix...), badgerKey)
+}
+
+func (b *badgerBucket) dbKey(key []byte) []byte {
+	return append(b.keyPrefix(), key...)
+}
+
+// getRaw gives a key's stored value, with its type byte; nil if it's not
+// there.
+func (b *badgerBucket) getRaw(key []byte) ([]byte, error) {
+	item, err := b.txn.Get(b.dbKey(key))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (b *badgerBucket) sub(name []byte) *badgerBucket {
+	return &badgerBucket{txn: b.txn, prefix: badgerBucketPrefix(b.prefix, name),
+		key: append([]byte(nil), name...), parentPrefix: b.prefix}
+}
+
+// parent is the bucket this one's in.
+func (b *badgerBucket) parent() *badgerBucket {
+	return &badgerBucket{txn: b.txn, prefix: b.parentPrefix}
+}
+
+func (b *badgerBucket) Bucket(name []byte) Bucket {
+	v, err := b.getRaw(name)
+	if err != nil || len(v) == 0 || v[0] != typeBucket {
+		return nil
+	}
+	return b.sub(name)
+}
+
+func (b *badgerBucket) CreateBucket(name []byte) (Bucket, error) {
+	v, err := b.getRaw(name)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		if v[0] == typeBucket {
+			return nil, ErrBucketExists
+		}
+		return nil, ErrIncompatibleValue
+	}
+	err = b.txn.Set(b.dbKey(name), append([]byte{typeBucket}, make([]byte, 8)...))
+	if err != nil {
+		return nil, err
+	}
+	return b.sub(name), nil
+}
+
+func (b *badgerBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	sub, err := b.CreateBucket(name)
+	if err == ErrBucketExists {
+		return b.sub(name), nil
+	}
+	return sub, err
+}
+
+func (b *badgerBucket) DeleteBucket(name []byte) error {
+	v, err := b.getRaw(name)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return ErrBucketNotFound
+	}
+	if v[0] != typeBucket {
+		return ErrIncompatibleValue
+	}
+	// everything in it, and in buckets in it, has its prefix
+	dels := [][]byte{b.dbKey(name)}
+	prefix := badgerBucketPrefix(b.prefix, name)
+	it := b.txn.NewIterator(badger.IteratorOptions{})
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		dels = append(dels, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	for _, k := range dels {
+		err = b.txn.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *badgerBucket) Get(key []byte) []byte {
+	v, err := b.getRaw(key)
+	if err != nil || len(v) == 0 || v[0] != typeValue {
+		return nil
+	}
+	return v[1:]
+}
+
+func (b *badgerBucket) Put(key, value []byte) error {
+	v, err := b.getRaw(key)
+	if err != nil {
+		return err
+	}
+	if len(v) != 0 && v[0] == typeBucket {
+		return ErrIncompatibleValue
+	}
+	return b.txn.Set(b.dbKey(key), append([]byte{typeValue}, value...))
+}
+
+func (b *badgerBucket) Delete(key []byte) error {
+	v, err := b.getRaw(key)
+	if err != nil {
+		return err
+	}
+	if len(v) != 0 && v[0] == typeBucket {
+		return ErrIncompatibleValue
+	}
+	return b.txn.Delete(b.dbKey(key))
+}
+
+// entry is a key in a bucket, and its value, nil for buckets.
+func badgerEntry(prefix []byte, item *badger.Item) ([]byte, []byte, error) {
+	k := item.KeyCopy(nil)[len(prefix):]
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(v) == 0 || v[0] != typeValue {
+		return k, nil, nil
+	}
+	return k, v[1:], nil
+}
+
+// ForEach reads the whole bucket before going through it, since a
+// read-write badger transaction can only have one iterator at a time, and
+// fn may well want to look in other buckets.
+func (b *badgerBucket) ForEach(fn func(k, v []byte) error) error {
+	var keys, values [][]byte
+	prefix := b.keyPrefix()
+	it := b.txn.NewIterator(badger.DefaultIteratorOptions)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		k, v, err := badgerEntry(prefix, it.Item())
+		if err != nil {
+			it.Close()
+			return err
+		}
+		keys, values = append(keys, k), append(values, v)
+	}
+	it.Close()
+
+	for i := range keys {
+		err := fn(keys[i], values[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *badgerBucket) Cursor() Cursor {
+	return &badgerCursor{b: b}
+}
+
+func (b *badgerBucket) Sequence() uint64 {
+	if b.key == nil {
+		return 0
+	}
+	v, err := b.parent().getRaw(b.key)
+	if err != nil || len(v) != 9 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v[1:])
+}
+
+func (b *badgerBucket) SetSequence(seq uint64) error {
+	if b.key == nil {
+		return ErrIncompatibleValue
+	}
+	v := make([]byte, 9)
+	v[0] = typeBucket
+	binary.BigEndian.PutUint64(v[1:], seq)
+	return b.txn.Set(b.parent().dbKey(b.key), v)
+}
+
+func (b *badgerBucket) NextSequence() (uint64, error) {
+	seq := b.Sequence() + 1
+	return seq, b.SetSequence(seq)
+}
+
+func (b *badgerBucket) Stats() BucketStats {
+	s := BucketStats{BucketN: 1}
+	// keys in buckets inside sort right after this bucket's own
+	it := b.txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(b.prefix); it.ValidForPrefix(b.prefix); it.Next() {
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil || len(v) == 0 {
+			continue
+		}
+		if v[0] == typeBucket {
+			s.BucketN++
+		} else {
+			s.KeyN++
+		}
+	}
+	return s
+}
+
+// seek finds the first key at or after from, or after it if after is
+// set; going backwards, the last one at or before it.
+func (c *badgerCursor) seek(from []byte, after, reverse bool) ([]byte, []byte) {
+	prefix := c.b.keyPrefix()
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = reverse
+	it := c.b.txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(from); it.ValidForPrefix(prefix); it.Next() {
+		if after && bytes.Equal(it.Item().Key(), from) {
+			continue
+		}
+		k, v, err := badgerEntry(prefix, it.Item())
+		if err != nil {
+			break
+		}
+		c.cur = k
+		return k, v
+	}
+	c.cur = nil
+	return nil, nil
+}
+
+func (c *badgerCursor) First() ([]byte, []byte) {
+	return c.seek(c.b.keyPrefix(), false, false)
+}
+
+func (c *badgerCursor) Last() ([]byte, []byte) {
+	// nothing's at the separator itself; buckets inside start after it
+	end := append(append([]byte(nil), c.b.prefix...), badgerBucketSep)
+	return c.seek(end, false, true)
+}
+
+func (c *badgerCursor) Next() ([]byte, []byte) {
+	if c.cur == nil {
+		return nil, nil
+	}
+	return c.seek(c.b.dbKey(c.cur), true, false)
+}
+
+func (c *badgerCursor) Prev() ([]byte, []byte) {
+	if c.cur == nil {
+		return nil, nil
+	}
+	return c.seek(c.b.dbKey(c.cur), true, true)
+}
+
+func (c *badgerCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.seek(c.b.dbKey(seek), false, false)
+}
@@ -0,0 +1,153 @@
+package kvdb
+
+import (
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the bbolt backend is a thin wrapper; its api is what kvdb's is based on.
+// Buckets that aren't there have to come back as plain nils, not nil
+// pointers in an interface, so nil checks work.
+
+type boltDB struct {
+	db   *bolt.DB
+	path string
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+func openBolt(path, file string, mode os.FileMode) (DB, error) {
+	// don't hang forever if another lit has the file open
+	db, err := bolt.Open(file, mode, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltDB{db: db, path: path}, nil
+}
+
+func wrapBolt(b *bolt.Bucket) Bucket {
+	if b == nil {
+		return nil
+	}
+	return &boltBucket{b: b}
+}
+
+func wrapBoltErr(b *bolt.Bucket, err error) (Bucket, error) {
+	if err != nil {
+		return nil, err
+	}
+	return wrapBolt(b), nil
+}
+
+func (d *boltDB) Update(fn func(Tx) error) error {
+	return d.db.Update(func(tx *bolt.Tx) error { return fn(&boltTx{tx: tx}) })
+}
+
+func (d *boltDB) View(fn func(Tx) error) error {
+	return d.db.View(func(tx *bolt.Tx) error { return fn(&boltTx{tx: tx}) })
+}
+
+func (d *boltDB) Close() error {
+	return d.db.Close()
+}
+
+func (d *boltDB) Path() string {
+	return d.path
+}
+
+// check has bolt look over its pages, giving the first problem it finds.
+func (d *boltDB) check() error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		var first error
+		// read them all, or the check never finishes
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+}
+
+func (t *boltTx) Bucket(name []byte) Bucket {
+	return wrapBolt(t.tx.Bucket(name))
+}
+
+func (t *boltTx) CreateBucket(name []byte) (Bucket, error) {
+	return wrapBoltErr(t.tx.CreateBucket(name))
+}
+
+func (t *boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	return wrapBoltErr(t.tx.CreateBucketIfNotExists(name))
+}
+
+func (t *boltTx) DeleteBucket(name []byte) error {
+	return t.tx.DeleteBucket(name)
+}
+
+func (t *boltTx) ForEach(fn func(name []byte, b Bucket) error) error {
+	return t.tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return fn(name, wrapBolt(b))
+	})
+}
+
+func (b *boltBucket) Bucket(name []byte) Bucket {
+	return wrapBolt(b.b.Bucket(name))
+}
+
+func (b *boltBucket) CreateBucket(name []byte) (Bucket, error) {
+	return wrapBoltErr(b.b.CreateBucket(name))
+}
+
+func (b *boltBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	return wrapBoltErr(b.b.CreateBucketIfNotExists(name))
+}
+
+func (b *boltBucket) DeleteBucket(name []byte) error {
+	return b.b.DeleteBucket(name)
+}
+
+func (b *boltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b *boltBucket) Put(key, value []byte) error {
+	return b.b.Put(key, value)
+}
+
+func (b *boltBucket) Delete(key []byte) error {
+	return b.b.Delete(key)
+}
+
+func (b *boltBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+func (b *boltBucket) Cursor() Cursor {
+	return b.b.Cursor()
+}
+
+func (b *boltBucket) NextSequence() (uint64, error) {
+	return b.b.NextSequence()
+}
+
+func (b *boltBucket) Sequence() uint64 {
+	return b.b.Sequence()
+}
+
+func (b *boltBucket) SetSequence(v uint64) error {
+	return b.b.SetSequence(v)
+}
+
+func (b *boltBucket) Stats() BucketStats {
+	s := b.b.Stats()
+	return BucketStats{KeyN: s.KeyN, BucketN: s.BucketN}
+}
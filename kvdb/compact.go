@@ -0,0 +1,205 @@
+package kvdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/*
+Compacting a db copies everything in it to a fresh store.  What's left
+behind is the free pages bolt never gives back to the disk, or the old
+versions badger hasn't gotten around to throwing out.  The copy gets
+checked against the original, bucket and key counts, before anything is
+replaced.
+
+Compact does it for real, with lit stopped.  CheckCompact works on a db
+that's in use: it makes and checks the copy, to see the db reads back
+fine and how much compacting would save, then throws the copy away.
+Swapping it in would lose whatever was written after it was made.
+*/
+
+// DBStats counts what's in a db.
+type DBStats struct {
+	Buckets int
+	Keys    int
+}
+
+// CompactReport is how compacting a db went.
+type CompactReport struct {
+	Path    string
+	Backend string
+	Before  int64 // bytes
+	After   int64
+	DBStats
+}
+
+// Check reads every bucket and key in a db, and counts them.  bbolt dbs
+// have their pages checked first.
+func Check(db DB) (DBStats, error) {
+	var s DBStats
+	if bdb, ok := db.(*boltDB); ok {
+		err := bdb.check()
+		if err != nil {
+			return s, err
+		}
+	}
+
+	var walk func(b Bucket) error
+	walk = func(b Bucket) error {
+		s.Buckets++
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				s.Keys++
+				return nil
+			}
+			sub := b.Bucket(k)
+			if sub == nil {
+				return fmt.Errorf("bucket %x listed but not there", k)
+			}
+			return walk(sub)
+		})
+	}
+	err := db.View(func(tx Tx) error {
+		return tx.ForEach(func(name []byte, b Bucket) error {
+			if b == nil {
+				return fmt.Errorf("bucket %x listed but not there", name)
+			}
+			return walk(b)
+		})
+	})
+	return s, err
+}
+
+// storeSize is how many bytes a backend uses for the db at path.
+func storeSize(backend, path string) (int64, error) {
+	p, err := storePath(backend, path)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	err = filepath.Walk(p, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// compactCopy copies db to a fresh store at its path with .compact added,
+// and checks the copy.  Gives the copy's path, which is the caller's to
+// deal with.
+func compactCopy(db DB, backend string) (CompactReport, string, error) {
+	path := db.Path()
+	r := CompactReport{Path: path, Backend: backend}
+	var err error
+	r.Before, err = storeSize(backend, path)
+	if err != nil {
+		return r, "", err
+	}
+
+	copyPath := path + ".compact"
+	copyStore, _ := storePath(backend, copyPath)
+	// from a compaction that didn't finish
+	err = os.RemoveAll(copyStore)
+	if err != nil {
+		return r, "", err
+	}
+
+	dst, err := openStore(backend, copyPath, fileMode(path))
+	if err != nil {
+		return r, "", err
+	}
+	err = Migrate(db, dst)
+	if err == nil {
+		r.DBStats, err = checkSame(db, dst)
+	}
+	dst.Close()
+	if err != nil {
+		os.RemoveAll(copyStore)
+		return r, "", fmt.Errorf("compacting %s: %s", path, err.Error())
+	}
+	r.After, err = storeSize(backend, copyPath)
+	return r, copyPath, err
+}
+
+// checkSame checks two dbs look the same, giving what's in them.
+func checkSame(a, b DB) (DBStats, error) {
+	as, err := Check(a)
+	if err != nil {
+		return as, err
+	}
+	bs, err := Check(b)
+	if err != nil {
+		return as, err
+	}
+	if as != bs {
+		return as, fmt.Errorf("copy has %d buckets, %d keys; original %d, %d",
+			bs.Buckets, bs.Keys, as.Buckets, as.Keys)
+	}
+	return as, nil
+}
+
+// Compact compacts the db at path, which mustn't be open, in Backend.
+func Compact(path string) (CompactReport, error) {
+	db, err := OpenBackend(Backend, path, 0600)
+	if err != nil {
+		return CompactReport{Path: path}, err
+	}
+	r, copyPath, err := compactCopy(db, Backend)
+	db.Close()
+	if err != nil {
+		return r, err
+	}
+	return r, replaceStore(Backend, path, copyPath)
+}
+
+// fileMode is the permissions of the file at path, for copies of it.
+func fileMode(path string) os.FileMode {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0600
+	}
+	return fi.Mode().Perm()
+}
+
+// replaceStore puts the store at copyPath in place of the one at path.
+func replaceStore(backend, path, copyPath string) error {
+	// keep the old one till the copy's in place
+	oldStore, _ := storePath(backend, path)
+	copyStore, _ := storePath(backend, copyPath)
+	err := os.Rename(oldStore, oldStore+".replaced")
+	if err != nil {
+		return err
+	}
+	err = os.Rename(copyStore, oldStore)
+	if err != nil {
+		// put it back
+		os.Rename(oldStore+".replaced", oldStore)
+		return err
+	}
+	return os.RemoveAll(oldStore + ".replaced")
+}
+
+// CheckCompact checks a db that's in use, and sees how much compacting it
+// would save.
+func CheckCompact(db DB) (CompactReport, error) {
+	// copy what's stored, so an encrypted db's copy stays that way
+	if edb, ok := db.(*encDB); ok {
+		db = edb.raw
+	}
+	backend := BackendBolt
+	if _, ok := db.(*badgerDB); ok {
+		backend = BackendBadger
+	}
+	r, copyPath, err := compactCopy(db, backend)
+	if err != nil {
+		return r, err
+	}
+	copyStore, _ := storePath(backend, copyPath)
+	return r, os.RemoveAll(copyStore)
+}
@@ -0,0 +1,529 @@
+package kvdb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/*
+Encrypted dbs have every value sealed with xchacha20-poly1305, under a key
+derived from the wallet key.  So they're as safe as the key file: with a
+passphrase, which scrypt makes the key file's key from, a copy of the lit
+folder doesn't give away channel states, preimages or which keys are used
+for what.  Without one, the key file gives the dbs away too.
+
+Keys aren't encrypted; bolt and badger need them in order, for cursors.
+They're mostly outpoints, txids and indexes, so an encrypted db still shows
+which channels and txs there are, just nothing about them.  Each value is
+sealed with the db's id, the version it was written at, the buckets it's in
+and its key as associated data, so values can't be moved around, or from
+one db to another, without it showing.
+
+The version counts the updates that put values.  It's kept in the kvdb
+bucket along with the id, and the check value is sealed again with each
+new version, so the version can't be changed without the key.  A value
+from a later version than the db's, like one copied in from a newer backup,
+doesn't open.
+
+encrypted value:
+8	version it was sealed at
+24	random nonce
+	the rest is the sealed value, with its 16 byte tag
+
+A db is encrypted if it has the check value in the kvdb bucket.  Once
+encrypted, it stays that way.  Encrypting a db that isn't copies it into a
+fresh encrypted store, like compacting does.  The old store is deleted, but
+its bytes may still be on the disk till they're written over.
+*/
+
+var (
+	bktKvdb    = []byte("kvdb")
+	keyCheck   = []byte("check")
+	keyID      = []byte("id")
+	keyVersion = []byte("version")
+	// checkValue is what's sealed in the check value
+	checkValue = []byte("lit kvdb")
+)
+
+// dbIDSize is how many random bytes an encrypted db's id is.
+const dbIDSize = 16
+
+// dbKey is what encrypted dbs are sealed with; nil if there isn't one.
+var dbKey []byte
+
+// EncryptAll is set to encrypt dbs that aren't, as they're opened.  Needs
+// a key set with SetKey.
+var EncryptAll bool
+
+// SetKey derives the key dbs are encrypted with from the wallet key.  Set
+// it before opening any.  It's only as secret as the wallet key; there's
+// no passphrase of its own.
+func SetKey(walletKey *[32]byte) {
+	h := sha256.New()
+	h.Write([]byte("lit kvdb encryption"))
+	h.Write(walletKey[:])
+	dbKey = h.Sum(nil)
+}
+
+// encDB is a db whose values are encrypted.
+type encDB struct {
+	raw  DB
+	aead cipherAEAD
+	id   []byte
+}
+
+// encTx is a transaction on an encrypted db.  A value that doesn't open is
+// kept in err, since Get and cursors can't give errors; the transaction
+// fails with it.
+type encTx struct {
+	raw Tx
+	db  *encDB
+	// version is what values are sealed at, and the latest that open
+	version uint64
+	// sealed is set once a value's been put, so the version goes up
+	sealed bool
+	err    error
+}
+
+type encBucket struct {
+	raw Bucket
+	tx  *encTx
+	// ad is the associated data for the bucket: the names of the buckets
+	// down to it
+	ad []byte
+}
+
+type encCursor struct {
+	raw Cursor
+	b   *encBucket
+}
+
+// cipherAEAD is what chacha20poly1305 gives; named to keep it short.
+type cipherAEAD interface {
+	NonceSize() int
+	Overhead() int
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// appendName adds a length prefixed name to associated data.
+func appendName(ad, name []byte) []byte {
+	var l [binary.MaxVarintLen64]byte
+	ad = append(append([]byte(nil), ad...), l[:binary.PutUvarint(l[:], uint64(len(name)))]...)
+	return append(ad, name...)
+}
+
+// valueAD is the associated data for a value: the db's id, the version
+// it's sealed at, the buckets it's in and its key.
+func (b *encBucket) valueAD(version []byte, key []byte) []byte {
+	ad := append(append([]byte(nil), b.tx.db.id...), version...)
+	return appendName(append(ad, b.ad...), key)
+}
+
+func (b *encBucket) seal(key, value []byte) ([]byte, error) {
+	aead := b.tx.db.aead
+	sealed := make([]byte, 8+aead.NonceSize(),
+		8+aead.NonceSize()+len(value)+aead.Overhead())
+	binary.BigEndian.PutUint64(sealed[:8], b.tx.version)
+	nonce := sealed[8:]
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	b.tx.sealed = true
+	return aead.Seal(sealed, nonce, value, b.valueAD(sealed[:8], key)), nil
+}
+
+// open decrypts a value.  There's no good way to go on from one that
+// doesn't decrypt; it's been corrupted or tampered with, and treating it as
+// missing could mean using old channel state.  So that's an error, which
+// fails the transaction.
+func (b *encBucket) open(key, sealed []byte) ([]byte, error) {
+	if sealed == nil {
+		return nil, nil
+	}
+	aead := b.tx.db.aead
+	ns := 8 + aead.NonceSize()
+	if len(sealed) < ns+aead.Overhead() {
+		return nil, fmt.Errorf("kvdb: value for %x too short to decrypt", key)
+	}
+	if binary.BigEndian.Uint64(sealed[:8]) > b.tx.version {
+		return nil, fmt.Errorf("kvdb: value for %x is from a later version "+
+			"of the db; tampered with", key)
+	}
+	v, err := aead.Open(nil, sealed[8:ns], sealed[ns:], b.valueAD(sealed[:8], key))
+	if err != nil {
+		return nil, fmt.Errorf("kvdb: value for %x doesn't decrypt; "+
+			"corrupted or tampered with", key)
+	}
+	if v == nil {
+		// empty values aren't buckets
+		v = []byte{}
+	}
+	return v, nil
+}
+
+// get opens a value, or gives nil and fails the transaction if it doesn't.
+func (b *encBucket) get(key, sealed []byte) []byte {
+	v, err := b.open(key, sealed)
+	if err != nil {
+		if b.tx.err == nil {
+			b.tx.err = err
+		}
+		return nil
+	}
+	return v
+}
+
+// rawVersion gives the version kept in a db's kvdb bucket.
+func rawVersion(tx Tx) (uint64, error) {
+	bkt := tx.Bucket(bktKvdb)
+	if bkt == nil {
+		return 0, fmt.Errorf("no kvdb bucket")
+	}
+	v := bkt.Get(keyVersion)
+	if len(v) != 8 {
+		return 0, fmt.Errorf("bad db version %x", v)
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// setVersion keeps the transaction's version as the db's, and seals the
+// check value again with it.
+func (t *encTx) setVersion() error {
+	bkt := t.raw.Bucket(bktKvdb)
+	if bkt == nil {
+		return fmt.Errorf("no kvdb bucket")
+	}
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], t.version)
+	err := bkt.Put(keyVersion, v[:])
+	if err != nil {
+		return err
+	}
+	return t.wrap(bktKvdb, bkt).Put(keyCheck, checkValue)
+}
+
+func (d *encDB) Update(fn func(Tx) error) error {
+	return d.raw.Update(func(tx Tx) error {
+		version, err := rawVersion(tx)
+		if err != nil {
+			return err
+		}
+		t := &encTx{raw: tx, db: d, version: version + 1}
+		err = fn(t)
+		if err == nil {
+			err = t.err
+		}
+		if err != nil || !t.sealed {
+			return err
+		}
+		return t.setVersion()
+	})
+}
+
+func (d *encDB) View(fn func(Tx) error) error {
+	return d.raw.View(func(tx Tx) error {
+		version, err := rawVersion(tx)
+		if err != nil {
+			return err
+		}
+		t := &encTx{raw: tx, db: d, version: version}
+		err = fn(t)
+		if err == nil {
+			err = t.err
+		}
+		return err
+	})
+}
+
+func (d *encDB) Close() error {
+	return d.raw.Close()
+}
+
+func (d *encDB) Path() string {
+	return d.raw.Path()
+}
+
+func (t *encTx) wrap(name []byte, b Bucket) Bucket {
+	if b == nil {
+		return nil
+	}
+	return &encBucket{raw: b, tx: t, ad: appendName(nil, name)}
+}
+
+func (t *encTx) wrapErr(name []byte, b Bucket, err error) (Bucket, error) {
+	if err != nil {
+		return nil, err
+	}
+	return t.wrap(name, b), nil
+}
+
+func (t *encTx) Bucket(name []byte) Bucket {
+	return t.wrap(name, t.raw.Bucket(name))
+}
+
+func (t *encTx) CreateBucket(name []byte) (Bucket, error) {
+	b, err := t.raw.CreateBucket(name)
+	return t.wrapErr(name, b, err)
+}
+
+func (t *encTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.raw.CreateBucketIfNotExists(name)
+	return t.wrapErr(name, b, err)
+}
+
+func (t *encTx) DeleteBucket(name []byte) error {
+	return t.raw.DeleteBucket(name)
+}
+
+func (t *encTx) ForEach(fn func(name []byte, b Bucket) error) error {
+	return t.raw.ForEach(func(name []byte, b Bucket) error {
+		return fn(name, t.wrap(name, b))
+	})
+}
+
+func (b *encBucket) wrap(name []byte, sub Bucket) Bucket {
+	if sub == nil {
+		return nil
+	}
+	return &encBucket{raw: sub, tx: b.tx, ad: appendName(b.ad, name)}
+}
+
+func (b *encBucket) wrapErr(name []byte, sub Bucket, err error) (Bucket, error) {
+	if err != nil {
+		return nil, err
+	}
+	return b.wrap(name, sub), nil
+}
+
+func (b *encBucket) Bucket(name []byte) Bucket {
+	return b.wrap(name, b.raw.Bucket(name))
+}
+
+func (b *encBucket) CreateBucket(name []byte) (Bucket, error) {
+	sub, err := b.raw.CreateBucket(name)
+	return b.wrapErr(name, sub, err)
+}
+
+func (b *encBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	sub, err := b.raw.CreateBucketIfNotExists(name)
+	return b.wrapErr(name, sub, err)
+}
+
+func (b *encBucket) DeleteBucket(name []byte) error {
+	return b.raw.DeleteBucket(name)
+}
+
+func (b *encBucket) Get(key []byte) []byte {
+	return b.get(key, b.raw.Get(key))
+}
+
+func (b *encBucket) Put(key, value []byte) error {
+	sealed, err := b.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return b.raw.Put(key, sealed)
+}
+
+func (b *encBucket) Delete(key []byte) error {
+	return b.raw.Delete(key)
+}
+
+func (b *encBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.raw.ForEach(func(k, v []byte) error {
+		v, err := b.open(k, v)
+		if err != nil {
+			return err
+		}
+		return fn(k, v)
+	})
+}
+
+func (b *encBucket) Cursor() Cursor {
+	return &encCursor{raw: b.raw.Cursor(), b: b}
+}
+
+func (b *encBucket) NextSequence() (uint64, error) {
+	return b.raw.NextSequence()
+}
+
+func (b *encBucket) Sequence() uint64 {
+	return b.raw.Sequence()
+}
+
+func (b *encBucket) SetSequence(v uint64) error {
+	return b.raw.SetSequence(v)
+}
+
+func (b *encBucket) Stats() BucketStats {
+	return b.raw.Stats()
+}
+
+func (c *encCursor) open(k, v []byte) ([]byte, []byte) {
+	return k, c.b.get(k, v)
+}
+
+func (c *encCursor) First() ([]byte, []byte) {
+	return c.open(c.raw.First())
+}
+
+func (c *encCursor) Last() ([]byte, []byte) {
+	return c.open(c.raw.Last())
+}
+
+func (c *encCursor) Next() ([]byte, []byte) {
+	return c.open(c.raw.Next())
+}
+
+func (c *encCursor) Prev() ([]byte, []byte) {
+	return c.open(c.raw.Prev())
+}
+
+func (c *encCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.open(c.raw.Seek(seek))
+}
+
+// isEncrypted says if a db has the check value.
+func isEncrypted(db DB) (bool, error) {
+	var enc bool
+	err := db.View(func(tx Tx) error {
+		bkt := tx.Bucket(bktKvdb)
+		enc = bkt != nil && bkt.Get(keyCheck) != nil
+		return nil
+	})
+	return enc, err
+}
+
+// encrypted wraps a db that's encrypted, checking the key's right.
+func encrypted(raw DB) (DB, error) {
+	if dbKey == nil {
+		return nil, fmt.Errorf("%s is encrypted, and there's no key", raw.Path())
+	}
+	aead, err := chacha20poly1305.NewX(dbKey)
+	if err != nil {
+		return nil, err
+	}
+	db := &encDB{raw: raw, aead: aead}
+	err = raw.View(func(tx Tx) error {
+		bkt := tx.Bucket(bktKvdb)
+		if bkt == nil || len(bkt.Get(keyID)) != dbIDSize {
+			return fmt.Errorf("%s has no db id", raw.Path())
+		}
+		db.id = append([]byte(nil), bkt.Get(keyID)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.View(func(tx Tx) error {
+		eb, ok := tx.Bucket(bktKvdb).(*encBucket)
+		if !ok {
+			return fmt.Errorf("%s has no check value", raw.Path())
+		}
+		// sealed again with each new version, so it's at the db's
+		sealed := eb.raw.Get(keyCheck)
+		v, err := eb.open(keyCheck, sealed)
+		if err != nil || !bytes.Equal(v, checkValue) ||
+			binary.BigEndian.Uint64(sealed[:8]) != eb.tx.version {
+			return fmt.Errorf("%s is encrypted with a different key, "+
+				"or its version was tampered with", raw.Path())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// encryptStore copies the plain db at path into a fresh encrypted store,
+// and puts that in its place.
+func encryptStore(path string) error {
+	log.Printf("encrypting %s\n", path)
+	copyPath := path + ".encrypt"
+	copyStore, _ := storePath(Backend, copyPath)
+	// from an encryption that didn't finish
+	err := os.RemoveAll(copyStore)
+	if err != nil {
+		return err
+	}
+
+	src, err := openStore(Backend, path, 0600)
+	if err != nil {
+		return err
+	}
+	dst, err := openStore(Backend, copyPath, fileMode(path))
+	if err != nil {
+		src.Close()
+		return err
+	}
+	err = dst.Update(func(tx Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(bktKvdb)
+		if err != nil {
+			return err
+		}
+		edb := &encDB{raw: dst, id: make([]byte, dbIDSize)}
+		_, err = rand.Read(edb.id)
+		if err != nil {
+			return err
+		}
+		err = bkt.Put(keyID, edb.id)
+		if err != nil {
+			return err
+		}
+		edb.aead, err = chacha20poly1305.NewX(dbKey)
+		if err != nil {
+			return err
+		}
+		t := &encTx{raw: tx, db: edb, version: 1}
+		return t.setVersion()
+	})
+	if err == nil {
+		var enc DB
+		enc, err = encrypted(dst)
+		if err == nil {
+			err = Migrate(src, enc)
+		}
+	}
+	if err == nil {
+		err = checkEncryptedCopy(src, dst)
+	}
+	dst.Close()
+	src.Close()
+	if err != nil {
+		os.RemoveAll(copyStore)
+		return fmt.Errorf("encrypting %s: %s", path, err.Error())
+	}
+	return replaceStore(Backend, path, copyPath)
+}
+
+// checkEncryptedCopy checks an encrypted copy has everything the original
+// does, plus the check value.
+func checkEncryptedCopy(src, dst DB) error {
+	ss, err := Check(src)
+	if err != nil {
+		return err
+	}
+	ds, err := Check(dst)
+	if err != nil {
+		return err
+	}
+	// the kvdb bucket, with the check value, id and version
+	ss.Buckets++
+	ss.Keys += 3
+	if ss != ds {
+		return fmt.Errorf("copy has %d buckets, %d keys; expect %d, %d",
+			ds.Buckets, ds.Keys, ss.Buckets, ss.Keys)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package kvdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testBkt = []byte("bkt")
+	testKey = []byte("key")
+	testVal = []byte("channel state")
+)
+
+// testEncDB makes an encrypted db in dir with a value in it.
+func testEncDB(t *testing.T, dir, name string) DB {
+	var key [32]byte
+	key[0] = 0x11
+	SetKey(&key)
+	EncryptAll = true
+	defer func() { EncryptAll = false }()
+
+	db, err := Open(filepath.Join(dir, name), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(testBkt)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(testKey, testVal)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// rawGet gives a value as it's stored.
+func rawGet(t *testing.T, db DB, key []byte) []byte {
+	var v []byte
+	err := db.(*encDB).raw.View(func(tx Tx) error {
+		v = append([]byte(nil), tx.Bucket(testBkt).Get(key)...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// rawPut stores a value as it is.
+func rawPut(t *testing.T, db DB, key, v []byte) {
+	err := db.(*encDB).raw.Update(func(tx Tx) error {
+		return tx.Bucket(testBkt).Put(key, v)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getErr gets a value through the encryption, giving the error.
+func getErr(db DB, key []byte) ([]byte, error) {
+	var v []byte
+	err := db.View(func(tx Tx) error {
+		v = append([]byte(nil), tx.Bucket(testBkt).Get(key)...)
+		return nil
+	})
+	return v, err
+}
+
+func TestEncryptSealOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := testEncDB(t, dir, "a.db")
+	defer db.Close()
+
+	v, err := getErr(db, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, testVal) {
+		t.Fatalf("got %x back, put %x", v, testVal)
+	}
+	if bytes.Contains(rawGet(t, db, testKey), testVal) {
+		t.Fatalf("value stored in the clear")
+	}
+
+	// and after opening it again
+	path := db.Path()
+	db.Close()
+	db, err = Open(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = getErr(db, testKey)
+	if err != nil || !bytes.Equal(v, testVal) {
+		t.Fatalf("reopened, got %x %v", v, err)
+	}
+
+	// not with another key though
+	db.Close()
+	var key [32]byte
+	key[0] = 0x22
+	SetKey(&key)
+	_, err = Open(path, 0600)
+	if err == nil {
+		t.Fatalf("opened with the wrong key")
+	}
+}
+
+func TestEncryptTamper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := testEncDB(t, dir, "a.db")
+	defer db.Close()
+	sealed := rawGet(t, db, testKey)
+
+	// a flipped bit
+	bad := append([]byte(nil), sealed...)
+	bad[len(bad)-1] ^= 1
+	rawPut(t, db, testKey, bad)
+	_, err = getErr(db, testKey)
+	if err == nil {
+		t.Fatalf("tampered value opened")
+	}
+
+	// the right value under another key
+	rawPut(t, db, testKey, sealed)
+	rawPut(t, db, []byte("other"), sealed)
+	_, err = getErr(db, []byte("other"))
+	if err == nil {
+		t.Fatalf("moved value opened")
+	}
+
+	// cursors and ForEach fail too, rather than skip it
+	err = db.View(func(tx Tx) error {
+		c := tx.Bucket(testBkt).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("cursor went past a moved value")
+	}
+	err = db.View(func(tx Tx) error {
+		return tx.Bucket(testBkt).ForEach(func(k, v []byte) error { return nil })
+	})
+	if err == nil {
+		t.Fatalf("ForEach went past a moved value")
+	}
+
+	// and a write failing to read doesn't go through
+	err = db.Update(func(tx Tx) error {
+		bkt := tx.Bucket(testBkt)
+		bkt.Get([]byte("other"))
+		return bkt.Put([]byte("new"), testVal)
+	})
+	if err == nil {
+		t.Fatalf("update went through after a bad value")
+	}
+	if rawGet(t, db, []byte("new")) != nil {
+		t.Fatalf("update wasn't rolled back")
+	}
+}
+
+func TestEncryptVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := testEncDB(t, dir, "a.db")
+	defer a.Close()
+	b := testEncDB(t, dir, "b.db")
+	defer b.Close()
+
+	// same bucket and key in another db
+	rawPut(t, b, testKey, rawGet(t, a, testKey))
+	_, err = getErr(b, testKey)
+	if err == nil {
+		t.Fatalf("value from another db opened")
+	}
+
+	// a value from after the db's version, like from a newer copy
+	old := rawGet(t, a, testKey)
+	err = a.Update(func(tx Tx) error {
+		return tx.Bucket(testBkt).Put(testKey, []byte("newer"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := rawGet(t, a, testKey)
+	err = a.(*encDB).raw.Update(func(tx Tx) error {
+		version, err := rawVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version != 3 {
+			t.Fatalf("version %d after encrypting and two updates", version)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a sealed value from version 2 is fine; one claiming version 4 isn't
+	rawPut(t, a, testKey, old)
+	_, err = getErr(a, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer[7]++
+	rawPut(t, a, testKey, newer)
+	_, err = getErr(a, testKey)
+	if err == nil {
+		t.Fatalf("value from a later version opened")
+	}
+
+	// nor can the version be changed to let it in
+	err = a.(*encDB).raw.Update(func(tx Tx) error {
+		return tx.Bucket(bktKvdb).Put(keyVersion, []byte{0, 0, 0, 0, 0, 0, 0, 4})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := a.Path()
+	a.Close()
+	_, err = Open(path, 0600)
+	if err == nil {
+		t.Fatalf("opened with the version changed")
+	}
+}
@@ -0,0 +1,237 @@
+package kvdb
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+/*
+kvdb is where lit keeps things: the wallet, channels, the watchtower and
+dlcs.  It's shaped like bolt, with nested buckets of sorted keys, since
+that's what everything was written against, but the store underneath can
+be swapped out.
+
+bbolt is the default.  It reads and writes the same files the old boltdb
+did, so those just keep working.  badger is an option for big nodes, where
+bolt's write amplification and never-shrinking file hurt; it keeps each db
+in a folder next to where the bolt file would be.
+
+Switching backends moves the data over the first time a db is opened with
+the new one, and renames the old store out of the way, so there's never a
+stale copy that could get opened by mistake.  Channel state going back in
+time would look like cheating to the other side.
+*/
+
+// backends
+const (
+	BackendBolt   = "bbolt"
+	BackendBadger = "badger"
+)
+
+// Backends are the stores dbs can be kept in.
+var Backends = []string{BackendBolt, BackendBadger}
+
+// Backend is the store dbs get opened with.  Set it before opening any.
+var Backend = BackendBolt
+
+var (
+	ErrBucketExists      = errors.New("bucket already exists")
+	ErrBucketNotFound    = errors.New("bucket not found")
+	ErrIncompatibleValue = errors.New("incompatible value")
+)
+
+// DB is a key-value store.  Only one Update runs at a time; Views can run
+// alongside it and each other.
+type DB interface {
+	Update(fn func(Tx) error) error
+	View(fn func(Tx) error) error
+	Close() error
+	// Path is where the db was opened, whatever the backend.
+	Path() string
+}
+
+// Tx is a transaction, holding the top level buckets.
+type Tx interface {
+	// Bucket gives a bucket, or nil if there isn't one by that name.
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+	// ForEach goes through the top level buckets in order.
+	ForEach(fn func(name []byte, b Bucket) error) error
+}
+
+// Bucket is a sorted set of keys, with values or buckets of their own.
+// Values gotten are only good till the transaction's over.
+type Bucket interface {
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+
+	// Get gives a key's value; nil if it's not there, or it's a bucket.
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// ForEach goes through the keys in order; buckets have a nil value.
+	// Don't change the bucket from fn.
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() Cursor
+
+	NextSequence() (uint64, error)
+	Sequence() uint64
+	SetSequence(v uint64) error
+
+	Stats() BucketStats
+}
+
+// Cursor goes through a bucket's keys in order.  Each gives nil keys once
+// there aren't any more.
+type Cursor interface {
+	First() (key []byte, value []byte)
+	Last() (key []byte, value []byte)
+	Next() (key []byte, value []byte)
+	Prev() (key []byte, value []byte)
+	// Seek goes to the first key at or after seek.
+	Seek(seek []byte) (key []byte, value []byte)
+}
+
+// BucketStats counts what's in a bucket, and in the buckets in it.
+type BucketStats struct {
+	KeyN    int // keys with values
+	BucketN int // buckets, including this one
+}
+
+// storePath is where a backend keeps the db at path.
+func storePath(backend, path string) (string, error) {
+	switch backend {
+	case BackendBolt:
+		return path, nil
+	case BackendBadger:
+		return path + ".badger", nil
+	}
+	return "", fmt.Errorf("unknown db backend %s; have %v", backend, Backends)
+}
+
+func openStore(backend, path string, mode os.FileMode) (DB, error) {
+	p, err := storePath(backend, path)
+	if err != nil {
+		return nil, err
+	}
+	if backend == BackendBadger {
+		return openBadger(path, p)
+	}
+	return openBolt(path, p, mode)
+}
+
+// Exists says if there's a db at path, in any backend.
+func Exists(path string) bool {
+	for _, backend := range Backends {
+		p, _ := storePath(backend, path)
+		_, err := os.Stat(p)
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens the db at path with Backend, making it if it's not there.  An
+// encrypted db needs the key set with SetKey; with EncryptAll, one that
+// isn't gets encrypted first.
+func Open(path string, mode os.FileMode) (DB, error) {
+	db, err := OpenBackend(Backend, path, mode)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := isEncrypted(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if !enc && EncryptAll && dbKey != nil {
+		db.Close()
+		err = encryptStore(path)
+		if err != nil {
+			return nil, err
+		}
+		db, err = openStore(Backend, path, mode)
+		if err != nil {
+			return nil, err
+		}
+		enc = true
+	}
+	if !enc {
+		return db, nil
+	}
+	edb, err := encrypted(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return edb, nil
+}
+
+// OpenBackend opens the db at path with a backend, making it if it's not
+// there.  If it's kept in a different backend, it's moved over first.
+func OpenBackend(backend, path string, mode os.FileMode) (DB, error) {
+	want, err := storePath(backend, path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(want)
+	have := err == nil
+
+	for _, other := range Backends {
+		if other == backend {
+			continue
+		}
+		otherPath, _ := storePath(other, path)
+		_, err = os.Stat(otherPath)
+		if err != nil {
+			continue
+		}
+		if have {
+			return nil, fmt.Errorf("both %s and %s exist; move the one not in use away",
+				want, otherPath)
+		}
+		err = migrateStore(other, backend, path, mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return openStore(backend, path, mode)
+}
+
+// migrateStore moves the db at path from one backend to another.  The old
+// store is renamed, not deleted, in case something goes wrong later.
+func migrateStore(from, to, path string, mode os.FileMode) error {
+	fromPath, _ := storePath(from, path)
+	log.Printf("moving %s from %s to %s\n", path, from, to)
+
+	src, err := openStore(from, path, mode)
+	if err != nil {
+		return err
+	}
+	dst, err := openStore(to, path, mode)
+	if err != nil {
+		src.Close()
+		return err
+	}
+	err = Migrate(src, dst)
+	src.Close()
+	dst.Close()
+	if err != nil {
+		// don't leave a half copy to be opened next time
+		toPath, _ := storePath(to, path)
+		os.RemoveAll(toPath)
+		return fmt.Errorf("moving %s to %s: %s", path, to, err.Error())
+	}
+
+	oldPath := fmt.Sprintf("%s.migrated-%d", fromPath, time.Now().Unix())
+	log.Printf("moved %s; old store kept at %s\n", path, oldPath)
+	return os.Rename(fromPath, oldPath)
+}
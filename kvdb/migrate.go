@@ -0,0 +1,103 @@
+package kvdb
+
+// migrateBatch is how many keys go in each transaction when copying a db;
+// badger can't take a whole big db in one.
+const migrateBatch = 1000
+
+// migrateOp is a key to copy: a value, or a bucket with its sequence.
+type migrateOp struct {
+	path   [][]byte // buckets it's in, from the top
+	key    []byte
+	value  []byte
+	bucket bool
+	seq    uint64
+}
+
+// Migrate copies everything in src to dst.  Buckets and keys already in
+// dst stay, unless src has the same key.
+func Migrate(src, dst DB) error {
+	var ops []migrateOp
+	flush := func() error {
+		err := dst.Update(func(tx Tx) error {
+			for _, op := range ops {
+				err := applyMigrateOp(tx, op)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		ops = ops[:0]
+		return err
+	}
+	add := func(op migrateOp) error {
+		ops = append(ops, op)
+		if len(ops) < migrateBatch {
+			return nil
+		}
+		return flush()
+	}
+
+	var walk func(path [][]byte, b Bucket) error
+	walk = func(path [][]byte, b Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			op := migrateOp{path: path, key: append([]byte(nil), k...)}
+			if v != nil {
+				op.value = append([]byte(nil), v...)
+				return add(op)
+			}
+			sub := b.Bucket(k)
+			op.bucket, op.seq = true, sub.Sequence()
+			err := add(op)
+			if err != nil {
+				return err
+			}
+			return walk(append(path[:len(path):len(path)], op.key), sub)
+		})
+	}
+
+	err := src.View(func(tx Tx) error {
+		return tx.ForEach(func(name []byte, b Bucket) error {
+			op := migrateOp{key: append([]byte(nil), name...),
+				bucket: true, seq: b.Sequence()}
+			err := add(op)
+			if err != nil {
+				return err
+			}
+			return walk([][]byte{op.key}, b)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+func applyMigrateOp(tx Tx, op migrateOp) error {
+	if len(op.path) == 0 {
+		b, err := tx.CreateBucketIfNotExists(op.key)
+		if err != nil {
+			return err
+		}
+		return b.SetSequence(op.seq)
+	}
+
+	b := tx.Bucket(op.path[0])
+	for _, name := range op.path[1:] {
+		if b == nil {
+			break
+		}
+		b = b.Bucket(name)
+	}
+	if b == nil {
+		return ErrBucketNotFound
+	}
+	if !op.bucket {
+		return b.Put(op.key, op.value)
+	}
+	sub, err := b.CreateBucketIfNotExists(op.key)
+	if err != nil {
+		return err
+	}
+	return sub.SetSequence(op.seq)
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logs"
 )
 
 // createDefaultConfigFile creates a config file  -- only call this if the
@@ -97,11 +103,21 @@ func litSetup(conf *config) *[32]byte {
 		log.Fatal(err)
 	}
 
-	logFilePath := filepath.Join(conf.LitHomeDir, "lit.log")
-
-	logfile, err := os.OpenFile(logFilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	// TODO ... what's this do?
-	defer logfile.Close()
+	logDir := conf.LogDir
+	if logDir == "" {
+		logDir = conf.LitHomeDir
+	}
+	// stays open till lit quits
+	logfile, err := logs.NewRotator(logDir, "lit.log", logs.RotateConfig{
+		MaxSize:  conf.LogMaxSize << 20,
+		MaxAge:   time.Duration(conf.LogRotateInterval) * time.Second,
+		Keep:     conf.LogKeep,
+		KeepDays: conf.LogKeepDays,
+		Compress: !conf.LogNoCompress,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 
@@ -125,10 +141,58 @@ func litSetup(conf *config) *[32]byte {
 	keyFilePath := filepath.Join(conf.LitHomeDir, defaultKeyFileName)
 
 	// read key file (generate if not found)
+	newKey := !fileExists(keyFilePath)
 	key, err := lnutil.ReadKeyFile(keyFilePath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	conf.BirthTime, err = keyBirthday(conf, newKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	return key
 }
+
+// keyBirthday gives when the key was made, in unix time, so wallets don't
+// have to scan blocks from before it.  A new key's birthday is now.  The
+// birthday option sets it, for keys brought from elsewhere.  Either way it's
+// saved in the birthday file.  0 if not known, like for keys from before
+// birthdays were kept.
+func keyBirthday(conf *config, newKey bool) (int64, error) {
+	birthdayFilePath := filepath.Join(conf.LitHomeDir, defaultBirthdayFileName)
+
+	var birthday int64
+	switch {
+	case conf.Birthday == "0":
+		// scan everything
+	case conf.Birthday != "":
+		t, err := time.Parse("2006-01-02", conf.Birthday)
+		if err != nil {
+			return 0, fmt.Errorf("birthday %s: %s", conf.Birthday, err.Error())
+		}
+		birthday = t.Unix()
+	case newKey:
+		birthday = time.Now().Unix()
+	default:
+		b, err := ioutil.ReadFile(birthdayFilePath)
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	}
+
+	if birthday != 0 {
+		log.Printf("key birthday %s\n", time.Unix(birthday, 0).UTC().String())
+	}
+	err := ioutil.WriteFile(birthdayFilePath,
+		[]byte(strconv.FormatInt(birthday, 10)+"\n"), 0600)
+	if err != nil {
+		return 0, err
+	}
+	return birthday, nil
+}
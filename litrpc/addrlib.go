@@ -23,6 +23,13 @@ func AdrStringToOutscript(adr string) ([]byte, error) {
 	var err error
 	var outScript []byte
 
+	// witness v1 (taproot) addresses are bech32m, which the bech32
+	// package doesn't know about
+	outScript, err = lnutil.TaprootAddressDecode(adr)
+	if err == nil {
+		return outScript, nil
+	}
+
 	// use HRP to determine network / wallet to use
 	outScript, err = bech32.SegWitAddressDecode(adr)
 	if err != nil { // valid bech32 string
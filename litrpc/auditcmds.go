@@ -0,0 +1,50 @@
+package litrpc
+
+import (
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- audit log
+type AuditEventsArgs struct {
+	// From is the sequence number to start at; 0 or 1 for the start
+	From uint64
+	// Max is the most events to give; 0 for qln.MaxAuditEvents
+	Max int
+}
+
+type AuditEventsReply struct {
+	Events []qln.AuditEvent
+	// Next is the sequence number to ask From next time
+	Next uint64
+}
+
+// AuditEvents gives events from the audit log, starting at a sequence
+// number, so a reader can keep up by asking again From Next.
+func (r *LitRPC) AuditEvents(args AuditEventsArgs, reply *AuditEventsReply) error {
+	var err error
+	reply.Events, err = r.Node.AuditEvents(args.From, args.Max)
+	if err != nil {
+		return err
+	}
+	reply.Next = args.From
+	if reply.Next == 0 {
+		reply.Next = 1
+	}
+	if len(reply.Events) != 0 {
+		reply.Next = reply.Events[len(reply.Events)-1].Seq + 1
+	}
+	return nil
+}
+
+type VerifyAuditReply struct {
+	// Events is how many events are good
+	Events uint64
+}
+
+// VerifyAudit checks the audit log's MAC chain; an error says where it
+// breaks.
+func (r *LitRPC) VerifyAudit(args NoArgs, reply *VerifyAuditReply) error {
+	var err error
+	reply.Events, err = r.Node.VerifyAudit()
+	return err
+}
@@ -17,6 +17,7 @@ type ChannelInfo struct {
 	Capacity      int64
 	MyBalance     int64
 	Height        int32  // block height of channel fund confirmation
+	ScanHeight    int32  // block height the channel's been watched to
 	StateNum      uint64 // Most recent commit number
 	PeerIdx, CIdx uint32
 	PeerID        string
@@ -60,6 +61,11 @@ func (r *LitRPC) ChannelList(args ChanArgs, reply *ChannelListReply) error {
 		reply.Channels[i].CIdx = q.KeyGen.Step[4] & 0x7fffffff
 		reply.Channels[i].Data = q.State.Data
 		reply.Channels[i].Pkh = q.WatchRefundAdr
+		hint, err := r.Node.ChanHeightHint(q)
+		if err != nil {
+			return err
+		}
+		reply.Channels[i].ScanHeight = hint.ScanHeight
 	}
 	return nil
 }
@@ -67,11 +73,20 @@ func (r *LitRPC) ChannelList(args ChanArgs, reply *ChannelListReply) error {
 // ------------------------- fund
 type FundArgs struct {
 	Peer        uint32 // who to make the channel with
+	Contact     string // or a name from the address book, instead of Peer
 	CoinType    uint32 // what coin to use
 	Capacity    int64  // later can be minimum capacity
 	Roundup     int64  // ignore for now; can be used to round-up capacity
 	InitialSend int64  // Initial send of -1 means "ALL"
 	Data        [32]byte
+	CoinSelect  string // coin selection strategy; empty for the wallet's
+	// manual coin control, as in SendArgs
+	UseInputs   []string
+	AvoidInputs []string
+	// where the funding tx's change goes, as in SendArgs
+	ChangeAddress string
+	ChangeType    string
+	ChangeAccount string
 }
 
 func (r *LitRPC) FundChannel(args FundArgs, reply *StatusReply) error {
@@ -96,6 +111,13 @@ func (r *LitRPC) FundChannel(args FundArgs, reply *StatusReply) error {
 		return fmt.Errorf("No wallet of cointype %d linked", args.CoinType)
 	}
 
+	if args.Contact != "" {
+		args.Peer, err = r.contactPeer(args.Contact)
+		if err != nil {
+			return err
+		}
+	}
+
 	nowHeight := wal.CurrentHeight()
 
 	// see if we have enough money before calling the funding function.  Not
@@ -114,8 +136,25 @@ func (r *LitRPC) FundChannel(args FundArgs, reply *StatusReply) error {
 			args.Capacity, spendable-consts.SafeFee)
 	}
 
-	idx, err := r.Node.FundChannel(
-		args.Peer, args.CoinType, args.Capacity, args.InitialSend, args.Data)
+	useOps, err := coinControlOps(args.UseInputs, allPorTxos)
+	if err != nil {
+		return err
+	}
+	avoidOps, err := coinControlOps(args.AvoidInputs, allPorTxos)
+	if err != nil {
+		return err
+	}
+
+	// funding spends from the default account
+	change, err := changeSpec(wal, 0,
+		args.ChangeAddress, args.ChangeType, args.ChangeAccount)
+	if err != nil {
+		return err
+	}
+
+	idx, err := r.Node.FundChannel(args.Peer, args.CoinType,
+		args.Capacity, args.InitialSend, args.Data, args.CoinSelect,
+		useOps, avoidOps, change)
 	if err != nil {
 		return err
 	}
@@ -153,6 +192,8 @@ type PushArgs struct {
 }
 type PushReply struct {
 	StateIndex uint64
+	// TraceID is for TracePayment
+	TraceID uint64
 }
 
 // Push is the command to push money to the other side of the channel.
@@ -181,15 +222,12 @@ func (r *LitRPC) Push(args PushArgs, reply *PushReply) error {
 	// but we want to reference the qc that's already in ram
 	// first see if we're connected to that peer
 
-	// map read, need mutex...?
-	r.Node.RemoteMtx.Lock()
-	peer, ok := r.Node.RemoteCons[dummyqc.Peer()]
-	r.Node.RemoteMtx.Unlock()
+	peer, ok := r.Node.GetPeer(dummyqc.Peer())
 	if !ok {
 		return fmt.Errorf("not connected to peer %d for channel %d",
 			dummyqc.Peer(), dummyqc.Idx())
 	}
-	qc, ok := peer.QCs[dummyqc.Idx()]
+	qc, ok := peer.GetQchan(dummyqc.Idx())
 	if !ok {
 		return fmt.Errorf("peer %d doesn't have channel %d",
 			dummyqc.Peer(), dummyqc.Idx())
@@ -206,7 +244,7 @@ func (r *LitRPC) Push(args PushArgs, reply *PushReply) error {
 	// to the Node.Func() calls.  For now though, set the height here...
 	qc.Height = dummyqc.Height
 
-	err = r.Node.PushChannel(qc, uint32(args.Amt), args.Data)
+	reply.TraceID, err = r.Node.TracedPush(qc, uint32(args.Amt), args.Data)
 	if err != nil {
 		return err
 	}
@@ -215,6 +253,38 @@ func (r *LitRPC) Push(args PushArgs, reply *PushReply) error {
 	return nil
 }
 
+// ------------------------- payment traces
+type TracePaymentArgs struct {
+	// TraceID is the push to show; 0 for the most recent ones
+	TraceID uint64
+	// ChanIdx limits the most recent ones to a channel; 0 for all
+	ChanIdx uint32
+	// Recent is how many of the most recent to show; 0 for 10
+	Recent int
+}
+
+type TracePaymentReply struct {
+	Traces []qln.PaymentTrace
+}
+
+// TracePayment shows when a push got through each stage of the state
+// update, or does for the most recent pushes.
+func (r *LitRPC) TracePayment(args TracePaymentArgs, reply *TracePaymentReply) error {
+	if args.TraceID != 0 {
+		pt, err := r.Node.TracePayment(args.TraceID)
+		if err != nil {
+			return err
+		}
+		reply.Traces = []qln.PaymentTrace{pt}
+		return nil
+	}
+	if args.Recent <= 0 {
+		args.Recent = 10
+	}
+	reply.Traces = r.Node.RecentTraces(args.ChanIdx, args.Recent)
+	return nil
+}
+
 // ------------------------- cclose
 type ChanArgs struct {
 	ChanIdx uint32
@@ -267,6 +337,12 @@ type DumpReply struct {
 
 // DumpPrivs returns WIF private keys for every utxo and channel
 func (r *LitRPC) DumpPrivs(args NoArgs, reply *DumpReply) error {
+	// no keys out without a record of it
+	err := r.Node.Audit(qln.AuditEvent{Type: qln.AuditDumpPrivs})
+	if err != nil {
+		return err
+	}
+
 	// get wifs for all channels
 	qcs, err := r.Node.GetAllQchans()
 	if err != nil {
@@ -0,0 +1,118 @@
+package litrpc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- address book
+
+type AddContactArgs struct {
+	Name    string
+	Address string // on-chain address; optional
+	LitAdr  string // lit address, maybe with @host:port; optional
+}
+
+// AddContact puts a name in the address book, so send, con and fund can use
+// it instead of an address.  The on-chain address has to be for a coin
+// there's a wallet for.
+func (r *LitRPC) AddContact(args AddContactArgs, reply *StatusReply) error {
+	// names can't look like what they stand in for
+	_, numErr := strconv.Atoi(args.Name)
+	_, adrErr := AdrStringToOutscript(args.Name)
+	if args.Name == "" || strings.ContainsAny(args.Name, " @:") ||
+		numErr == nil || adrErr == nil || lnutil.LitAdrOK(args.Name) {
+		return fmt.Errorf("can't use %q as a contact name", args.Name)
+	}
+
+	if args.Address != "" {
+		_, err := AdrStringToOutscript(args.Address)
+		if err != nil {
+			return fmt.Errorf("address %s: %s", args.Address, err.Error())
+		}
+		coinType := CoinTypeFromAdr(args.Address)
+		if _, ok := r.Node.SubWallet[coinType]; !ok {
+			return fmt.Errorf("address %s is for coin type %d; no wallet for it",
+				args.Address, coinType)
+		}
+	}
+	if args.LitAdr != "" {
+		pkh := strings.SplitN(args.LitAdr, "@", 2)[0]
+		if !lnutil.LitAdrOK(pkh) {
+			return fmt.Errorf("invalid ln address %s", args.LitAdr)
+		}
+	}
+
+	err := r.Node.AddContact(qln.Contact{
+		Name: args.Name, Address: args.Address, LitAdr: args.LitAdr})
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("added contact %s", args.Name)
+	return nil
+}
+
+type ContactArgs struct {
+	Name string
+}
+
+// RemoveContact takes a name out of the address book.
+func (r *LitRPC) RemoveContact(args ContactArgs, reply *StatusReply) error {
+	err := r.Node.RemoveContact(args.Name)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("removed contact %s", args.Name)
+	return nil
+}
+
+type ContactsReply struct {
+	Contacts []qln.Contact
+}
+
+// ListContacts gives the whole address book.
+func (r *LitRPC) ListContacts(args NoArgs, reply *ContactsReply) error {
+	var err error
+	reply.Contacts, err = r.Node.ListContacts()
+	return err
+}
+
+// contactAdr gives the on-chain address for a contact name, or the string
+// back if it isn't one.
+func (r *LitRPC) contactAdr(s string) string {
+	c, err := r.Node.GetContact(s)
+	if err != nil || c.Address == "" {
+		return s
+	}
+	return c.Address
+}
+
+// contactPeer finds the peer index for a contact, by its lit address.
+// We have to have connected to them before.
+func (r *LitRPC) contactPeer(name string) (uint32, error) {
+	c, err := r.Node.GetContact(name)
+	if err != nil {
+		return 0, err
+	}
+	if c.LitAdr == "" {
+		return 0, fmt.Errorf("contact %s has no lit address", name)
+	}
+	pkh := strings.SplitN(c.LitAdr, "@", 2)[0]
+
+	peers, err := r.Node.GetKnownPeers()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range peers {
+		// contact's address may be the short kind
+		if strings.HasPrefix(p.LitAdr, pkh) {
+			return p.PeerIdx, nil
+		}
+	}
+	return 0, fmt.Errorf("never connected to %s (%s); con to them first",
+		name, c.LitAdr)
+}
@@ -0,0 +1,147 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// ------------------------- apps' custom messages
+type SendCustomMsgArgs struct {
+	Peer uint32
+	// Type is the app's message type, 32768 or more
+	Type uint16
+	// Data is hex
+	Data string
+}
+
+// SendCustomMsg sends an app's message to a connected peer that's allowed
+// custom messages.
+func (r *LitRPC) SendCustomMsg(args SendCustomMsgArgs, reply *StatusReply) error {
+	data, err := hex.DecodeString(args.Data)
+	if err != nil {
+		return err
+	}
+	err = r.Node.SendCustomMsg(args.Peer, args.Type, data)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("sent type %d, %d bytes to peer %d",
+		args.Type, len(data), args.Peer)
+	return nil
+}
+
+type AllowCustomMsgsArgs struct {
+	// Peer is a pubkey in hex, or a peer index
+	Peer  string
+	Allow bool
+}
+
+// AllowCustomMsgs opts in to, or out of, swapping custom messages with a
+// peer.
+func (r *LitRPC) AllowCustomMsgs(args AllowCustomMsgsArgs, reply *StatusReply) error {
+	pub, err := r.banPubkey(args.Peer)
+	if err != nil {
+		return err
+	}
+	err = r.Node.AllowCustomMsgs(pub, args.Allow)
+	if err != nil {
+		return err
+	}
+	if args.Allow {
+		reply.Status = fmt.Sprintf("allowed custom messages with %x", pub)
+	} else {
+		reply.Status = fmt.Sprintf("stopped custom messages with %x", pub)
+	}
+	return nil
+}
+
+type CustomMsgPeersReply struct {
+	// LitAdrs of the peers allowed custom messages
+	LitAdrs []string
+}
+
+// CustomMsgPeers lists the peers allowed custom messages.
+func (r *LitRPC) CustomMsgPeers(args NoArgs, reply *CustomMsgPeersReply) error {
+	pubs, err := r.Node.CustomMsgPeers()
+	if err != nil {
+		return err
+	}
+	for _, pub := range pubs {
+		reply.LitAdrs = append(reply.LitAdrs, lnutil.LitAdrFromPubkey(pub))
+	}
+	return nil
+}
+
+type SubscribeCustomMsgsArgs struct {
+	// Types to hear about; none for all of them
+	Types []uint16
+}
+
+type SubscribeCustomMsgsReply struct {
+	SubID uint32
+}
+
+// SubscribeCustomMsgs starts a subscription to incoming custom messages.
+// Get them with CustomMsgs.
+func (r *LitRPC) SubscribeCustomMsgs(
+	args SubscribeCustomMsgsArgs, reply *SubscribeCustomMsgsReply) error {
+	var err error
+	reply.SubID, err = r.Node.SubscribeCustomMsgs(args.Types)
+	return err
+}
+
+type CustomMsgsArgs struct {
+	SubID uint32
+	// Wait is how many seconds to wait for a message if there aren't any
+	// yet; 0 to come right back
+	Wait int64
+}
+
+type CustomMsgInfo struct {
+	Peer uint32
+	Type uint16
+	// Data is hex
+	Data string
+	Time int64
+}
+
+type CustomMsgsReply struct {
+	Msgs []CustomMsgInfo
+}
+
+// CustomMsgs gives a subscription's messages since it was last asked,
+// waiting for some if there aren't any.
+func (r *LitRPC) CustomMsgs(args CustomMsgsArgs, reply *CustomMsgsReply) error {
+	if args.Wait < 0 {
+		return fmt.Errorf("can't wait %d seconds", args.Wait)
+	}
+	msgs, err := r.Node.CustomMsgs(
+		args.SubID, time.Duration(args.Wait)*time.Second)
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		reply.Msgs = append(reply.Msgs, CustomMsgInfo{Peer: m.Peer,
+			Type: m.Type, Data: hex.EncodeToString(m.Data), Time: m.Time})
+	}
+	return nil
+}
+
+type UnsubscribeCustomMsgsArgs struct {
+	SubID uint32
+}
+
+// UnsubscribeCustomMsgs ends a subscription.
+func (r *LitRPC) UnsubscribeCustomMsgs(
+	args UnsubscribeCustomMsgsArgs, reply *StatusReply) error {
+
+	err := r.Node.UnsubscribeCustomMsgs(args.SubID)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("ended custom message subscription %d", args.SubID)
+	return nil
+}
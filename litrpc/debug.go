@@ -0,0 +1,137 @@
+package litrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"time"
+
+	"github.com/mit-dci/lit/qln"
+)
+
+/*
+The debug listener serves profiles and the node's debug state over http,
+for working out why lit's stuck or slow.  It's off unless --debugaddr is
+given, and has no authentication, so keep it on localhost.
+
+net/http/pprof isn't used, since importing it puts its handlers on the
+default mux, which the RPC listener serves too.  The profiles are the same
+ones, for go tool pprof:
+
+/debug/pprof/<name>	goroutine, heap, allocs, block, mutex, threadcreate;
+			?debug=1 for text, ?debug=2 for goroutine stack dumps
+/debug/pprof/profile	cpu profile, ?seconds=30
+/debug/pprof/trace	execution trace, ?seconds=5
+/debug/state		DebugState, as json
+/metrics		the metrics, as for --metricsaddr
+*/
+
+const (
+	// a mutex contention event out of every this many is profiled
+	mutexProfileFraction = 5
+	// blocking is profiled about once per this many nanoseconds blocked
+	blockProfileRate = 10000
+	// longest cpu profile or trace to take
+	maxProfileSeconds = 300
+)
+
+// DebugListen serves profiles and debug state on addr.  Doesn't return; run
+// it in a goroutine.
+func DebugListen(rpcl *LitRPC, addr string) {
+	runtime.SetMutexProfileFraction(mutexProfileFraction)
+	runtime.SetBlockProfileRate(blockProfileRate)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", serveProfile)
+	mux.HandleFunc("/debug/pprof/profile", serveCPUProfile)
+	mux.HandleFunc("/debug/pprof/trace", serveTrace)
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(rpcl.Node.DebugState())
+	})
+	mux.HandleFunc("/metrics", rpcl.serveMetrics)
+
+	log.Printf("debug listener on http://%s/debug/\n", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// profileSeconds reads ?seconds=, within limits.
+func profileSeconds(r *http.Request, dflt int) time.Duration {
+	sec, err := strconv.Atoi(r.FormValue("seconds"))
+	if err != nil || sec <= 0 {
+		sec = dflt
+	}
+	if sec > maxProfileSeconds {
+		sec = maxProfileSeconds
+	}
+	return time.Duration(sec) * time.Second
+}
+
+func serveProfile(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/debug/pprof/"):]
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%d\t%s\n", p.Count(), p.Name())
+		}
+		fmt.Fprintf(w, "\tprofile\n\ttrace\n")
+		return
+	}
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, "no profile "+name, http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if debug == 0 {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	if name == "heap" && r.FormValue("gc") != "" {
+		runtime.GC()
+	}
+	p.WriteTo(w, debug)
+}
+
+func serveCPUProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	err := pprof.StartCPUProfile(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	time.Sleep(profileSeconds(r, 30))
+	pprof.StopCPUProfile()
+}
+
+func serveTrace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	err := trace.Start(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	time.Sleep(profileSeconds(r, 5))
+	trace.Stop()
+}
+
+// ------------------------- debug state
+
+type DebugStateReply struct {
+	qln.DebugState
+}
+
+// DebugState shows what the node's goroutines and queues are up to, for
+// working out what's stuck.
+func (r *LitRPC) DebugState(args NoArgs, reply *DebugStateReply) error {
+	reply.DebugState = r.Node.DebugState()
+	return nil
+}
@@ -317,6 +317,9 @@ func (r *LitRPC) SetContractCoinType(args SetContractCoinTypeArgs,
 type OfferContractArgs struct {
 	CIdx    uint64
 	PeerIdx uint32
+	// AllowNoRefund funds the contract even if the peer doesn't sign a
+	// refund, as older nodes don't
+	AllowNoRefund bool
 }
 
 type OfferContractReply struct {
@@ -328,7 +331,7 @@ func (r *LitRPC) OfferContract(args OfferContractArgs,
 	reply *OfferContractReply) error {
 	var err error
 
-	err = r.Node.OfferDlc(args.PeerIdx, args.CIdx)
+	err = r.Node.OfferDlc(args.PeerIdx, args.CIdx, args.AllowNoRefund)
 	if err != nil {
 		return err
 	}
@@ -361,6 +364,9 @@ func (r *LitRPC) DeclineContract(args DeclineContractArgs,
 
 type AcceptContractArgs struct {
 	CIdx uint64
+	// AllowNoRefund funds the contract even if the peer doesn't sign a
+	// refund, as older nodes don't
+	AllowNoRefund bool
 }
 
 type AcceptContractReply struct {
@@ -373,7 +379,7 @@ func (r *LitRPC) AcceptContract(args AcceptContractArgs,
 	reply *AcceptContractReply) error {
 	var err error
 
-	err = r.Node.AcceptDlc(args.CIdx)
+	err = r.Node.AcceptDlc(args.CIdx, args.AllowNoRefund)
 	if err != nil {
 		return err
 	}
@@ -410,3 +416,28 @@ func (r *LitRPC) SettleContract(args SettleContractArgs,
 	reply.Success = true
 	return nil
 }
+
+type RefundContractArgs struct {
+	CIdx uint64
+}
+
+type RefundContractReply struct {
+	Success      bool
+	RefundTxHash [32]byte
+}
+
+// RefundContract sends the contract's refund transaction, paying both sides
+// their funding back, for when the oracle hasn't published. It can only get
+// into a block a week after the settlement time.
+func (r *LitRPC) RefundContract(args RefundContractArgs,
+	reply *RefundContractReply) error {
+	var err error
+
+	reply.RefundTxHash, err = r.Node.RefundContract(args.CIdx)
+	if err != nil {
+		return err
+	}
+
+	reply.Success = true
+	return nil
+}
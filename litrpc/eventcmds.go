@@ -0,0 +1,109 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+// ------------------------- chain event subscriptions
+type SubscribeChainArgs struct {
+	CoinType uint32
+	// Blocks is set to hear about every new block
+	Blocks    bool
+	Txids     []string
+	OutPoints []string
+	// PkScripts are the hex scripts of OutPoints, in order, if known.  With
+	// compact filters, outpoints need them.
+	PkScripts []string
+}
+
+type SubscribeChainReply struct {
+	SubID uint32
+}
+
+// SubscribeChain starts a subscription to new blocks, and to txs and
+// outpoints confirming and getting spent.  Get what's happened with
+// ChainEvents.
+func (r *LitRPC) SubscribeChain(
+	args SubscribeChainArgs, reply *SubscribeChainReply) error {
+
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+
+	txids := make([]chainhash.Hash, len(args.Txids))
+	for i, s := range args.Txids {
+		txid, err := chainhash.NewHashFromStr(s)
+		if err != nil {
+			return err
+		}
+		txids[i] = *txid
+	}
+	ops := make([]wire.OutPoint, len(args.OutPoints))
+	for i, s := range args.OutPoints {
+		op, err := lnutil.OutPointFromString(s)
+		if err != nil {
+			return err
+		}
+		ops[i] = *op
+	}
+	var pkScripts [][]byte
+	for _, s := range args.PkScripts {
+		pkScript, err := hex.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		pkScripts = append(pkScripts, pkScript)
+	}
+
+	var err error
+	reply.SubID, err = r.Node.SubscribeChain(
+		args.CoinType, args.Blocks, txids, ops, pkScripts)
+	return err
+}
+
+type ChainEventsArgs struct {
+	SubID uint32
+	// Wait is how many seconds to wait for an event if there aren't any
+	// yet; 0 to come right back
+	Wait int64
+}
+
+type ChainEventsReply struct {
+	Events []qln.ChainEvent
+}
+
+// ChainEvents gives a subscription's events since it was last asked,
+// waiting for some if there aren't any.
+func (r *LitRPC) ChainEvents(args ChainEventsArgs, reply *ChainEventsReply) error {
+	if args.Wait < 0 {
+		return fmt.Errorf("can't wait %d seconds", args.Wait)
+	}
+	var err error
+	reply.Events, err = r.Node.ChainEvents(
+		args.SubID, time.Duration(args.Wait)*time.Second)
+	return err
+}
+
+type UnsubscribeChainArgs struct {
+	SubID uint32
+}
+
+// UnsubscribeChain ends a subscription.
+func (r *LitRPC) UnsubscribeChain(
+	args UnsubscribeChainArgs, reply *StatusReply) error {
+
+	err := r.Node.UnsubscribeChain(args.SubID)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("ended chain subscription %d", args.SubID)
+	return nil
+}
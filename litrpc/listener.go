@@ -29,7 +29,17 @@ type LitRPC struct {
 	OffButton chan bool
 }
 
-func serveWS(ws *websocket.Conn) {
+func (r *LitRPC) serveWS(ws *websocket.Conn) {
+	// there's no rpc auth, so who connected is what there is to audit
+	detail := "from " + ws.Request().RemoteAddr
+	if origin := ws.Request().Header.Get("Origin"); origin != "" {
+		detail += " origin " + origin
+	}
+	err := r.Node.Audit(qln.AuditEvent{Type: qln.AuditRPCConnect, Detail: detail})
+	if err != nil {
+		log.Printf("audit rpc connect: %s\n", err.Error())
+	}
+
 	body, err := ioutil.ReadAll(ws.Request().Body)
 	if err != nil {
 		log.Printf("Error reading body: %v", err)
@@ -48,6 +58,6 @@ func RPCListen(rpcl *LitRPC, host string, port uint16) {
 
 	listenString := fmt.Sprintf("%s:%d", host, port)
 
-	http.Handle("/ws", websocket.Handler(serveWS))
+	http.Handle("/ws", websocket.Handler(rpcl.serveWS))
 	log.Fatal(http.ListenAndServe(listenString, nil))
 }
@@ -0,0 +1,44 @@
+package litrpc
+
+import (
+	"github.com/mit-dci/lit/logs"
+)
+
+type LogLevelArgs struct {
+	// subsystem to set, like QLN; empty or "all" for all of them
+	Subsystem string
+	Level     string
+}
+
+type LogLevelsReply struct {
+	// subsystem name, level
+	Levels map[string]string
+}
+
+func logLevels(reply *LogLevelsReply) {
+	reply.Levels = make(map[string]string)
+	for name, level := range logs.Levels() {
+		reply.Levels[name] = level.String()
+	}
+}
+
+// LogLevels gives each log subsystem's level.
+func (r *LitRPC) LogLevels(args NoArgs, reply *LogLevelsReply) error {
+	logLevels(reply)
+	return nil
+}
+
+// SetLogLevel sets a log subsystem's level, or all of them, and gives the
+// levels after.
+func (r *LitRPC) SetLogLevel(args LogLevelArgs, reply *LogLevelsReply) error {
+	level, err := logs.ParseLevel(args.Level)
+	if err != nil {
+		return err
+	}
+	err = logs.SetLevel(args.Subsystem, level)
+	if err != nil {
+		return err
+	}
+	logLevels(reply)
+	return nil
+}
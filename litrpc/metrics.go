@@ -0,0 +1,107 @@
+package litrpc
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+/*
+The metrics listener serves /metrics in the prometheus text format, for
+scraping and alerting.  It's off unless --metricsaddr is given; the debug
+listener serves it too.  Counters count from startup.
+
+lit_peers{direction}			gauge, connected peers, in or out
+lit_channels_open{coin}			gauge
+lit_pushes_total{direction}		counter, pushes sent and received
+lit_htlcs_total{op}			counter, HTLCs committed (add, settle, fail)
+lit_justice_txs_total{by}		counter, justice txs sent by us or our tower
+lit_queue_depth{queue}			gauge, omni_out, omni_in, user_messages,
+					peer_outbox
+lit_sync_height{coin}			gauge
+lit_chain_tip_height{coin}		gauge, if the chainhook knows it
+lit_chain_lag_blocks{coin}		gauge, tip less sync height
+lit_db_bytes{db}			gauge
+
+lit doesn't forward payments between channels, so there's nothing to count
+there.
+*/
+
+// MetricsListen serves metrics on addr.  Doesn't return; run it in a
+// goroutine.
+func MetricsListen(rpcl *LitRPC, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", rpcl.serveMetrics)
+	log.Printf("metrics on http://%s/metrics\n", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+func (r *LitRPC) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	m := r.Node.Metrics()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	head := func(name, kind, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	head("lit_peers", "gauge", "Connected peers.")
+	fmt.Fprintf(bw, "lit_peers{direction=\"in\"} %d\n", m.PeersInbound)
+	fmt.Fprintf(bw, "lit_peers{direction=\"out\"} %d\n", m.PeersOutbound)
+
+	head("lit_channels_open", "gauge", "Channels not closed.")
+	for _, c := range m.Coins {
+		fmt.Fprintf(bw, "lit_channels_open{coin=\"%d\"} %d\n", c.CoinType, c.OpenChannels)
+	}
+
+	head("lit_pushes_total", "counter", "Pushes done since startup.")
+	fmt.Fprintf(bw, "lit_pushes_total{direction=\"sent\"} %d\n", m.PushesSent)
+	fmt.Fprintf(bw, "lit_pushes_total{direction=\"received\"} %d\n", m.PushesReceived)
+
+	head("lit_htlcs_total", "counter", "HTLC ops committed since startup.")
+	fmt.Fprintf(bw, "lit_htlcs_total{op=\"add\"} %d\n", m.HTLCsAdded)
+	fmt.Fprintf(bw, "lit_htlcs_total{op=\"settle\"} %d\n", m.HTLCsSettled)
+	fmt.Fprintf(bw, "lit_htlcs_total{op=\"fail\"} %d\n", m.HTLCsFailed)
+
+	head("lit_justice_txs_total", "counter", "Justice txs sent since startup.")
+	fmt.Fprintf(bw, "lit_justice_txs_total{by=\"node\"} %d\n", m.JusticeSent)
+	fmt.Fprintf(bw, "lit_justice_txs_total{by=\"tower\"} %d\n", m.TowerJusticeSent)
+
+	head("lit_queue_depth", "gauge", "Messages waiting in queues.")
+	fmt.Fprintf(bw, "lit_queue_depth{queue=\"omni_out\"} %d\n", m.OmniOut)
+	fmt.Fprintf(bw, "lit_queue_depth{queue=\"omni_in\"} %d\n", m.OmniIn)
+	fmt.Fprintf(bw, "lit_queue_depth{queue=\"user_messages\"} %d\n", m.UserMessages)
+	fmt.Fprintf(bw, "lit_queue_depth{queue=\"peer_outbox\"} %d\n", m.PeerOutbox)
+
+	head("lit_sync_height", "gauge", "Height the wallet is synced to.")
+	for _, c := range m.Coins {
+		fmt.Fprintf(bw, "lit_sync_height{coin=\"%d\"} %d\n", c.CoinType, c.SyncHeight)
+	}
+	head("lit_chain_tip_height", "gauge", "Height of the chain, as the chainhook knows it.")
+	head("lit_chain_lag_blocks", "gauge", "Blocks the wallet is behind the chain tip.")
+	for _, c := range m.Coins {
+		if c.TipHeight == 0 {
+			continue
+		}
+		lag := c.TipHeight - c.SyncHeight
+		if lag < 0 {
+			lag = 0
+		}
+		fmt.Fprintf(bw, "lit_chain_tip_height{coin=\"%d\"} %d\n", c.CoinType, c.TipHeight)
+		fmt.Fprintf(bw, "lit_chain_lag_blocks{coin=\"%d\"} %d\n", c.CoinType, lag)
+	}
+
+	head("lit_db_bytes", "gauge", "Size of each db on disk.")
+	var dbs []string
+	for db := range m.DBSizes {
+		dbs = append(dbs, db)
+	}
+	sort.Strings(dbs)
+	for _, db := range dbs {
+		fmt.Fprintf(bw, "lit_db_bytes{db=%q} %d\n", db, m.DBSizes[db])
+	}
+}
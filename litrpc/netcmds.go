@@ -1,11 +1,14 @@
 package litrpc
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/mdns"
 	"github.com/mit-dci/lit/qln"
 )
 
@@ -34,6 +37,31 @@ func (r *LitRPC) Listen(args ListenArgs, reply *ListeningPortsReply) error {
 	return nil
 }
 
+// AddListener starts listening on another address; same as Listen
+func (r *LitRPC) AddListener(args ListenArgs, reply *ListeningPortsReply) error {
+	return r.Listen(args, reply)
+}
+
+// RemoveListener stops listening on an address given to Listen
+func (r *LitRPC) RemoveListener(args ListenArgs, reply *StatusReply) error {
+	err := r.Node.RemoveListener(args.Port)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("stopped listening on %s", args.Port)
+	return nil
+}
+
+type ListListenersReply struct {
+	Listeners []qln.ListenerInfo
+}
+
+// ListListeners shows what we're listening on
+func (r *LitRPC) ListListeners(args NoArgs, reply *ListListenersReply) error {
+	reply.Listeners = r.Node.ListListeners()
+	return nil
+}
+
 // ------------------------- connect
 type ConnectArgs struct {
 	LNAddr string
@@ -41,6 +69,12 @@ type ConnectArgs struct {
 
 func (r *LitRPC) Connect(args ConnectArgs, reply *StatusReply) error {
 
+	// names from the address book stand for their lit address
+	c, err := r.Node.GetContact(args.LNAddr)
+	if err == nil && c.LitAdr != "" {
+		args.LNAddr = c.LitAdr
+	}
+
 	// first, see if the peer to connect to is referenced by peer index.
 	var connectAdr string
 	// check if a peer number was supplied instead of a pubkeyhash
@@ -86,8 +120,8 @@ func (r *LitRPC) AssignNickname(args AssignNicknameArgs, reply *StatusReply) err
 	// it's okay if we aren't connected to this peer right now, but if we are
 	// then their nickname needs to be updated in the remote connections list
 	// otherwise this doesn't get updated til after a restart
-	if peer, ok := r.Node.RemoteCons[args.Peer]; ok {
-		peer.Nickname = args.Nickname
+	if peer, ok := r.Node.GetPeer(args.Peer); ok {
+		peer.SetNickname(args.Nickname)
 	}
 
 	reply.Status = fmt.Sprintf("changed nickname of peer %d to %s",
@@ -95,6 +129,101 @@ func (r *LitRPC) AssignNickname(args AssignNicknameArgs, reply *StatusReply) err
 	return nil
 }
 
+// ------------------------- our alias
+type SetAliasArgs struct {
+	// Alias to announce; empty to have none
+	Alias string
+}
+
+// SetAlias changes the name we announce to peers.  Peers with gossip pass
+// it on to theirs.
+func (r *LitRPC) SetAlias(args SetAliasArgs, reply *StatusReply) error {
+	err := r.Node.SetAlias(args.Alias)
+	if err != nil {
+		return err
+	}
+	if args.Alias == "" {
+		reply.Status = "cleared alias"
+	} else {
+		reply.Status = fmt.Sprintf("announcing alias %q", args.Alias)
+	}
+	return nil
+}
+
+type GetAliasReply struct {
+	Alias string
+}
+
+// GetAlias gives the name we announce to peers.
+func (r *LitRPC) GetAlias(args NoArgs, reply *GetAliasReply) error {
+	reply.Alias = r.Node.MyAlias()
+	return nil
+}
+
+// ------------------------- ban / unban
+type BanPeerArgs struct {
+	// Peer is a pubkey in hex, or a peer index
+	Peer string
+	// Duration of the ban in seconds; 0 bans forever
+	Duration int64
+}
+
+// banPubkey gets a pubkey from either a peer index or a hex pubkey
+func (r *LitRPC) banPubkey(peer string) ([33]byte, error) {
+	var pub [33]byte
+	peerIdx, err := strconv.Atoi(peer)
+	if err == nil {
+		var empty [33]byte
+		pub, _ = r.Node.GetPubHostFromPeerIdx(uint32(peerIdx))
+		if pub == empty {
+			return pub, fmt.Errorf("no peer %d", peerIdx)
+		}
+		return pub, nil
+	}
+	pubBytes, err := hex.DecodeString(peer)
+	if err != nil || len(pubBytes) != 33 {
+		return pub, fmt.Errorf("%s isn't a peer index or 33 byte hex pubkey", peer)
+	}
+	copy(pub[:], pubBytes)
+	return pub, nil
+}
+
+func (r *LitRPC) BanPeer(args BanPeerArgs, reply *StatusReply) error {
+	pub, err := r.banPubkey(args.Peer)
+	if err != nil {
+		return err
+	}
+	err = r.Node.BanPeer(pub, time.Duration(args.Duration)*time.Second)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("banned %x", pub)
+	return nil
+}
+
+func (r *LitRPC) UnbanPeer(args BanPeerArgs, reply *StatusReply) error {
+	pub, err := r.banPubkey(args.Peer)
+	if err != nil {
+		return err
+	}
+	err = r.Node.UnbanPeer(pub)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("unbanned %x", pub)
+	return nil
+}
+
+type ListBansReply struct {
+	Bans []qln.BanInfo
+}
+
+func (r *LitRPC) ListBans(args NoArgs, reply *ListBansReply) error {
+	var err error
+	reply.Bans, err = r.Node.GetBans()
+	return err
+}
+
 // ------------------------- ShowConnections
 
 type ListConnectionsReply struct {
@@ -111,6 +240,57 @@ func (r *LitRPC) ListConnections(args NoArgs, reply *ListConnectionsReply) error
 	return nil
 }
 
+type ListKnownPeersReply struct {
+	Peers []qln.PeerRecord
+}
+
+// ListKnownPeers shows all the peers we've ever connected with, connected
+// right now or not
+func (r *LitRPC) ListKnownPeers(args NoArgs, reply *ListKnownPeersReply) error {
+	var err error
+	reply.Peers, err = r.Node.GetKnownPeers()
+	return err
+}
+
+type TrafficReply struct {
+	Peers []qln.PeerTraffic
+}
+
+// GetTraffic shows how much each peer has sent and received, by message type
+func (r *LitRPC) GetTraffic(args NoArgs, reply *TrafficReply) error {
+	reply.Peers = r.Node.GetTraffic()
+	return nil
+}
+
+// ResetTraffic zeroes the traffic counters
+func (r *LitRPC) ResetTraffic(args NoArgs, reply *StatusReply) error {
+	r.Node.ResetTraffic()
+	reply.Status = "traffic counters reset"
+	return nil
+}
+
+type DiscoverLocalReply struct {
+	Nodes []mdns.Node
+}
+
+// DiscoverLocal finds lit nodes on the local network with mDNS
+func (r *LitRPC) DiscoverLocal(args NoArgs, reply *DiscoverLocalReply) error {
+	var err error
+	reply.Nodes, err = r.Node.DiscoverLocal()
+	return err
+}
+
+type SeedPeersReply struct {
+	Nodes []qln.SeedNode
+}
+
+// SeedPeers gives the nodes the DNS seeds know about that we aren't
+// connected to, as candidates for new peers
+func (r *LitRPC) SeedPeers(args NoArgs, reply *SeedPeersReply) error {
+	reply.Nodes = r.Node.SeedCandidates()
+	return nil
+}
+
 func (r *LitRPC) GetListeningPorts(args NoArgs, reply *ListeningPortsReply) error {
 	reply.Adr, reply.LisIpPorts = r.Node.GetLisAddressAndPorts()
 	return nil
@@ -128,7 +308,51 @@ type SayArgs struct {
 }
 
 func (r *LitRPC) Say(args SayArgs, reply *StatusReply) error {
-	return r.Node.SendChat(args.Peer, args.Message)
+	err := r.Node.SendChat(args.Peer, args.Message)
+	if err != nil {
+		return err
+	}
+	if !r.Node.ConnectedToPeer(args.Peer) {
+		reply.Status = fmt.Sprintf("peer %d not connected; queued", args.Peer)
+	}
+	return nil
+}
+
+type ChatHistoryArgs struct {
+	Peer uint32
+	// Limit is the most messages to give; 0 for 50
+	Limit int
+	// Before is the ID to give messages from before; 0 for the newest
+	Before uint64
+}
+
+type ChatHistoryReply struct {
+	Chats []ChatInfo
+}
+
+type ChatInfo struct {
+	ID       uint64
+	Time     int64
+	Outgoing bool
+	Status   string
+	Text     string
+}
+
+// ChatHistory gives the chat messages with a peer, oldest first.  To page
+// back, ask again with Before as the first one's ID.
+func (r *LitRPC) ChatHistory(args ChatHistoryArgs, reply *ChatHistoryReply) error {
+	if args.Limit == 0 {
+		args.Limit = 50
+	}
+	chats, err := r.Node.ChatHistory(args.Peer, args.Limit, args.Before)
+	if err != nil {
+		return err
+	}
+	for _, c := range chats {
+		reply.Chats = append(reply.Chats, ChatInfo{ID: c.ID, Time: c.Time,
+			Outgoing: c.Outgoing, Status: c.StatusString(), Text: c.Text})
+	}
+	return nil
 }
 
 func (r *LitRPC) Stop(args NoArgs, reply *StatusReply) error {
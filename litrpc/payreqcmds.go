@@ -0,0 +1,108 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// ------------------------- payment requests
+
+type RequestPaymentArgs struct {
+	Peer     uint32
+	CoinType uint32
+	Amt      int64
+	Memo     string
+	// Hash is hex, and goes with the push paying the request; empty for a
+	// random one
+	Hash string
+}
+
+type RequestPaymentReply struct {
+	ID  uint64
+	Ref string // hex; the data of the push paying it
+}
+
+// RequestPayment asks a connected peer to push an amount in one of our
+// channels on a coin, with a memo saying what for.
+func (r *LitRPC) RequestPayment(args RequestPaymentArgs, reply *RequestPaymentReply) error {
+	var hash [32]byte
+	if args.Hash != "" {
+		b, err := hex.DecodeString(args.Hash)
+		if err != nil {
+			return err
+		}
+		if len(b) != 32 {
+			return fmt.Errorf("hash %d bytes, expect 32", len(b))
+		}
+		copy(hash[:], b)
+	}
+	p, err := r.Node.RequestPayment(
+		args.Peer, args.CoinType, args.Amt, args.Memo, hash)
+	if err != nil {
+		return err
+	}
+	reply.ID = p.ID
+	reply.Ref = hex.EncodeToString(p.Ref[:])
+	return nil
+}
+
+type PayRequestArgs struct {
+	ID uint64
+}
+
+// PayRequest pays a payment request from a peer, pushing in the channel
+// with them that has the most of ours in the request's coin.
+func (r *LitRPC) PayRequest(args PayRequestArgs, reply *PushReply) error {
+	var err error
+	reply.TraceID, err = r.Node.PayPayRequest(args.ID)
+	return err
+}
+
+// DeclinePayRequest won't pay a payment request from a peer.
+func (r *LitRPC) DeclinePayRequest(args PayRequestArgs, reply *StatusReply) error {
+	err := r.Node.DeclinePayRequest(args.ID)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("declined payment request %d", args.ID)
+	return nil
+}
+
+type PayRequestInfo struct {
+	ID       uint64
+	Peer     uint32
+	Outgoing bool // we asked them
+	CoinType uint32
+	Amt      int64
+	Ref      string
+	Memo     string
+	Time     int64
+	Status   string
+}
+
+type ListPayRequestsReply struct {
+	Requests []PayRequestInfo
+}
+
+// ListPayRequests gives the payment requests we've made and got, oldest
+// first.
+func (r *LitRPC) ListPayRequests(args NoArgs, reply *ListPayRequestsReply) error {
+	reqs, err := r.Node.PayRequests()
+	if err != nil {
+		return err
+	}
+	for _, p := range reqs {
+		reply.Requests = append(reply.Requests, PayRequestInfo{
+			ID:       p.ID,
+			Peer:     p.Peer,
+			Outgoing: p.Outgoing,
+			CoinType: p.CoinType,
+			Amt:      p.Amt,
+			Ref:      hex.EncodeToString(p.Ref[:]),
+			Memo:     p.Memo,
+			Time:     p.Time,
+			Status:   p.StatusString(),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package litrpc
+
+import (
+	"encoding/json"
+
+	"github.com/mit-dci/lit/qln"
+)
+
+type ListPluginsReply struct {
+	Plugins []qln.PluginInfo
+}
+
+// ListPlugins gives the running plugins, and the rpc methods, events and
+// hooks each has.
+func (r *LitRPC) ListPlugins(args NoArgs, reply *ListPluginsReply) error {
+	reply.Plugins = r.Node.Plugins()
+	return nil
+}
+
+type PluginCallArgs struct {
+	// Method is an rpc method a plugin added
+	Method string
+	// Params go to the plugin as they are
+	Params json.RawMessage
+}
+
+type PluginCallReply struct {
+	Result json.RawMessage
+}
+
+// PluginCall calls an rpc method a plugin added.
+func (r *LitRPC) PluginCall(args PluginCallArgs, reply *PluginCallReply) error {
+	var err error
+	reply.Result, err = r.Node.CallPlugin(args.Method, args.Params)
+	return err
+}
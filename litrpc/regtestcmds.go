@@ -0,0 +1,99 @@
+package litrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/chainhook"
+)
+
+// regtestHook gets the chainhook of a regtest wallet, if it can drive the
+// chain.  Coin type 0 is the default coin.
+func (r *LitRPC) regtestHook(coinType uint32) (chainhook.RegtestHook, error) {
+	if coinType == 0 {
+		coinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[coinType]
+	if !ok {
+		return nil, fmt.Errorf("no connnected wallet for coin type %d", coinType)
+	}
+	if !wal.Params().GenerateSupported {
+		return nil, fmt.Errorf("coin type %d (%s) isn't a regtest chain",
+			coinType, wal.Params().Name)
+	}
+	rh, ok := wal.ExportHook().(chainhook.RegtestHook)
+	if !ok {
+		return nil, fmt.Errorf(
+			"coin type %d's chainhook can't drive the chain; connect with fullnode://",
+			coinType)
+	}
+	return rh, nil
+}
+
+type GenerateArgs struct {
+	CoinType uint32
+	Blocks   uint32
+}
+
+type GenerateReply struct {
+	BlockHashes []string
+}
+
+// GenerateBlocks has the regtest node mine blocks.
+func (r *LitRPC) GenerateBlocks(args GenerateArgs, reply *GenerateReply) error {
+	rh, err := r.regtestHook(args.CoinType)
+	if err != nil {
+		return err
+	}
+	hashes, err := rh.GenerateBlocks(args.Blocks)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		reply.BlockHashes = append(reply.BlockHashes, hash.String())
+	}
+	return nil
+}
+
+type MinerSendArgs struct {
+	CoinType uint32
+	Address  string
+	Amt      int64
+}
+
+// SendRawFromMiner has the regtest node's wallet pay an address, like one
+// of ours, to get coins to test with.
+func (r *LitRPC) SendRawFromMiner(args MinerSendArgs, reply *TxidsReply) error {
+	rh, err := r.regtestHook(args.CoinType)
+	if err != nil {
+		return err
+	}
+	txid, err := rh.SendRawFromMiner(args.Address, args.Amt)
+	if err != nil {
+		return err
+	}
+	reply.Txids = append(reply.Txids, txid.String())
+	return nil
+}
+
+type MockTimeArgs struct {
+	CoinType uint32
+	Time     int64 // unix time; 0 for the real time
+}
+
+// SetMockTime sets the regtest node's clock, for timelocks.
+func (r *LitRPC) SetMockTime(args MockTimeArgs, reply *StatusReply) error {
+	rh, err := r.regtestHook(args.CoinType)
+	if err != nil {
+		return err
+	}
+	err = rh.SetMockTime(args.Time)
+	if err != nil {
+		return err
+	}
+	if args.Time == 0 {
+		reply.Status = "node back on real time"
+	} else {
+		reply.Status = fmt.Sprintf("node time set to %d", args.Time)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package litrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/qln"
+)
+
+// remoteReadMethods are what the read scope can call: ones that only look.
+var remoteReadMethods = map[string]bool{
+	"Balance": true, "TxoList": true, "ListUnspent": true, "SyncStatus": true,
+	"GetFee": true, "ListTxLabels": true, "ListLocks": true,
+	"ListBroadcasts": true, "Accounts": true,
+	"ChannelList": true, "TracePayment": true, "GetChannelMap": true,
+	"ListConnections": true, "ListKnownPeers": true, "ListBans": true,
+	"SeedPeers": true, "ListListeners": true, "GetListeningPorts": true,
+	"ListContacts": true, "GetAlias": true, "ListContracts": true,
+	"GetContract": true, "ListSwaps": true, "ListSubSwaps": true,
+	"TowerStatus": true, "TowerFees": true,
+	"TowerLedger": true, "ListPlugins": true, "ListPayRequests": true,
+}
+
+// remotePayMethods are what the pay scope can call on top of read's.
+var remotePayMethods = map[string]bool{
+	"Push": true, "Send": true, "SendMany": true, "Address": true,
+	"RequestPayment": true, "PayRequest": true, "DeclinePayRequest": true,
+}
+
+// remoteAllowed says whether a scope can call a method.
+func remoteAllowed(scope, method string) bool {
+	switch scope {
+	case qln.RemoteScopeAdmin:
+		return true
+	case qln.RemoteScopePay:
+		return remotePayMethods[method] || remoteReadMethods[method]
+	case qln.RemoteScopeRead:
+		return remoteReadMethods[method]
+	}
+	return false
+}
+
+// ServeRemote lets nodes paired for remote control call the rpc, within
+// their scope.  Their calls go to the rpc over a pipe, so they're handled
+// the same as local ones.
+func ServeRemote(rpcl *LitRPC) error {
+	server := rpc.NewServer()
+	err := server.Register(rpcl)
+	if err != nil {
+		return err
+	}
+	serverEnd, clientEnd := net.Pipe()
+	go server.ServeCodec(jsonrpc.NewServerCodec(serverEnd))
+	client := jsonrpc.NewClient(clientEnd)
+
+	rpcl.Node.RemoteRPC = func(scope, method string, args []byte) ([]byte, error) {
+		if !remoteAllowed(scope, method) {
+			return nil, fmt.Errorf("%s scope can't call %s", scope, method)
+		}
+		if len(args) == 0 {
+			args = []byte("{}")
+		}
+		var reply json.RawMessage
+		err := client.Call("LitRPC."+method, json.RawMessage(args), &reply)
+		return reply, err
+	}
+	return nil
+}
+
+type PairRemoteArgs struct {
+	// Peer is a pubkey in hex, or a peer index
+	Peer string
+	// Scope is read, pay or admin
+	Scope string
+}
+
+// PairRemote lets a node remote control this one, within a scope.
+func (r *LitRPC) PairRemote(args PairRemoteArgs, reply *StatusReply) error {
+	pub, err := r.banPubkey(args.Peer)
+	if err != nil {
+		return err
+	}
+	err = r.Node.PairRemote(pub, args.Scope)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("paired %x for %s", pub, args.Scope)
+	return nil
+}
+
+type UnpairRemoteArgs struct {
+	Peer string
+}
+
+// UnpairRemote stops a node remote controlling this one.
+func (r *LitRPC) UnpairRemote(args UnpairRemoteArgs, reply *StatusReply) error {
+	pub, err := r.banPubkey(args.Peer)
+	if err != nil {
+		return err
+	}
+	err = r.Node.UnpairRemote(pub)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("unpaired %x", pub)
+	return nil
+}
+
+type RemotePairingInfo struct {
+	PubKey string
+	LitAdr string
+	Scope  string
+}
+
+type ListRemotePairingsReply struct {
+	Pairings []RemotePairingInfo
+}
+
+// ListRemotePairings gives the nodes paired for remote control.
+func (r *LitRPC) ListRemotePairings(args NoArgs, reply *ListRemotePairingsReply) error {
+	pairings, err := r.Node.RemotePairings()
+	if err != nil {
+		return err
+	}
+	for _, p := range pairings {
+		reply.Pairings = append(reply.Pairings, RemotePairingInfo{
+			PubKey: fmt.Sprintf("%x", p.PubKey),
+			LitAdr: lnutil.LitAdrFromPubkey(p.PubKey),
+			Scope:  p.Scope,
+		})
+	}
+	return nil
+}
+
+type RemoteCallArgs struct {
+	Peer uint32
+	// Method is the rpc method to call there, like ChannelList
+	Method string
+	Args   json.RawMessage
+}
+
+type RemoteCallReply struct {
+	Reply json.RawMessage
+}
+
+// RemoteCall calls an rpc method on a connected node that's paired with
+// this one.
+func (r *LitRPC) RemoteCall(args RemoteCallArgs, reply *RemoteCallReply) error {
+	b, err := r.Node.RemoteCall(args.Peer, args.Method, args.Args)
+	if err != nil {
+		return err
+	}
+	reply.Reply = b
+	return nil
+}
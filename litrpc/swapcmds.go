@@ -0,0 +1,226 @@
+package litrpc
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// ------------------------- atomic swaps
+
+type SwapOfferArgs struct {
+	GiveChanIdx uint32
+	GiveAmt     int64
+	WantChanIdx uint32
+	WantAmt     int64
+	Timeout     uint32 // seconds our HTLC locks for; 0 for the default
+}
+
+// SwapOffer offers the peer of two channels, on different coins, to swap
+// GiveAmt in one for WantAmt in the other.
+func (r *LitRPC) SwapOffer(args SwapOfferArgs, reply *StatusReply) error {
+	s, err := r.Node.OfferSwap(args.GiveChanIdx, args.GiveAmt,
+		args.WantChanIdx, args.WantAmt, args.Timeout)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("offered swap %x to peer %d", s.RHash, s.PeerIdx)
+	return nil
+}
+
+type SwapArgs struct {
+	RHash string // hex
+}
+
+// swapHash decodes a swap's hash from hex.
+func swapHash(s string) ([32]byte, error) {
+	var rHash [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return rHash, err
+	}
+	if len(b) != 32 {
+		return rHash, fmt.Errorf("swap hash %d bytes, expect 32", len(b))
+	}
+	copy(rHash[:], b)
+	return rHash, nil
+}
+
+// SwapAccept accepts a swap we were offered.
+func (r *LitRPC) SwapAccept(args SwapArgs, reply *StatusReply) error {
+	rHash, err := swapHash(args.RHash)
+	if err != nil {
+		return err
+	}
+	err = r.Node.ReplySwap(rHash, true)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("accepted swap %x", rHash)
+	return nil
+}
+
+// SwapDecline declines a swap we were offered.
+func (r *LitRPC) SwapDecline(args SwapArgs, reply *StatusReply) error {
+	rHash, err := swapHash(args.RHash)
+	if err != nil {
+		return err
+	}
+	err = r.Node.ReplySwap(rHash, false)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("declined swap %x", rHash)
+	return nil
+}
+
+// SwapInfo is a swap, with channels by index.  Give and want are from the
+// offerer's side.
+type SwapInfo struct {
+	RHash       string
+	PeerIdx     uint32
+	Offerer     bool
+	GiveChanIdx uint32
+	GiveAmt     int64
+	WantChanIdx uint32
+	WantAmt     int64
+	Timeout     uint32
+	Status      string
+}
+
+type SwapsReply struct {
+	Swaps []SwapInfo
+}
+
+// ListSwaps gives the swaps we've offered or been offered.
+func (r *LitRPC) ListSwaps(args NoArgs, reply *SwapsReply) error {
+	swaps, err := r.Node.ListSwaps()
+	if err != nil {
+		return err
+	}
+	for _, s := range swaps {
+		si := SwapInfo{
+			RHash:   hex.EncodeToString(s.RHash[:]),
+			PeerIdx: s.PeerIdx,
+			Offerer: s.Offerer,
+			GiveAmt: s.GiveAmt,
+			WantAmt: s.WantAmt,
+			Timeout: s.Timeout,
+			Status:  s.StatusString(),
+		}
+		give, err := r.Node.GetQchan(lnutil.OutPointToBytes(s.GiveOp))
+		if err == nil {
+			si.GiveChanIdx = give.Idx()
+		}
+		want, err := r.Node.GetQchan(lnutil.OutPointToBytes(s.WantOp))
+		if err == nil {
+			si.WantChanIdx = want.Idx()
+		}
+		reply.Swaps = append(reply.Swaps, si)
+	}
+	return nil
+}
+
+// ------------------------- submarine swaps
+
+type SubSwapOfferArgs struct {
+	ChanIdx  uint32
+	LoopIn   bool // pay on chain for channel balance; false for the reverse
+	ChanAmt  int64
+	ChainAmt int64
+	Timeout  uint32 // blocks the first HTLC locks for; 0 for the default
+}
+
+// SubSwapOffer offers the peer of a channel a submarine swap, of channel
+// balance for coins on chain or the other way around.
+func (r *LitRPC) SubSwapOffer(args SubSwapOfferArgs, reply *StatusReply) error {
+	s, err := r.Node.OfferSubSwap(args.ChanIdx, args.LoopIn,
+		args.ChanAmt, args.ChainAmt, args.Timeout)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("offered subswap %x to peer %d", s.RHash, s.PeerIdx)
+	return nil
+}
+
+// SubSwapAccept accepts a submarine swap we were offered.
+func (r *LitRPC) SubSwapAccept(args SwapArgs, reply *StatusReply) error {
+	rHash, err := swapHash(args.RHash)
+	if err != nil {
+		return err
+	}
+	err = r.Node.ReplySubSwap(rHash, true)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("accepted subswap %x", rHash)
+	return nil
+}
+
+// SubSwapDecline declines a submarine swap we were offered.
+func (r *LitRPC) SubSwapDecline(args SwapArgs, reply *StatusReply) error {
+	rHash, err := swapHash(args.RHash)
+	if err != nil {
+		return err
+	}
+	err = r.Node.ReplySubSwap(rHash, false)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("declined subswap %x", rHash)
+	return nil
+}
+
+// SubSwapInfo is a submarine swap, with its channel by index.
+type SubSwapInfo struct {
+	RHash      string
+	PeerIdx    uint32
+	CoinType   uint32
+	Offerer    bool
+	LoopIn     bool
+	ChanIdx    uint32
+	ChanAmt    int64
+	ChainAmt   int64
+	Timeout    uint32
+	FundOp     string // the on-chain HTLC, once there is one
+	Locktime   uint32
+	FundHeight int32
+	Status     string
+}
+
+type SubSwapsReply struct {
+	SubSwaps []SubSwapInfo
+}
+
+// ListSubSwaps gives the submarine swaps we've offered or been offered.
+func (r *LitRPC) ListSubSwaps(args NoArgs, reply *SubSwapsReply) error {
+	swaps, err := r.Node.ListSubSwaps()
+	if err != nil {
+		return err
+	}
+	for _, s := range swaps {
+		si := SubSwapInfo{
+			RHash:      hex.EncodeToString(s.RHash[:]),
+			PeerIdx:    s.PeerIdx,
+			CoinType:   s.CoinType,
+			Offerer:    s.Offerer,
+			LoopIn:     s.LoopIn,
+			ChanAmt:    s.ChanAmt,
+			ChainAmt:   s.ChainAmt,
+			Timeout:    s.Timeout,
+			Locktime:   s.Locktime,
+			FundHeight: s.FundHeight,
+			Status:     s.StatusString(),
+		}
+		if s.Locktime != 0 {
+			si.FundOp = s.FundOp.String()
+		}
+		qc, err := r.Node.GetQchan(lnutil.OutPointToBytes(s.ChanOp))
+		if err == nil {
+			si.ChanIdx = qc.Idx()
+		}
+		reply.SubSwaps = append(reply.SubSwaps, si)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package litrpc
+
+import "github.com/mit-dci/lit/tor"
+
+type TorCircuitsReply struct {
+	Circuits []tor.Circuit
+}
+
+// TorCircuits shows the circuits of the tor daemon we're controlling
+func (r *LitRPC) TorCircuits(args NoArgs, reply *TorCircuitsReply) error {
+	circs, err := r.Node.TorCircuits()
+	if err != nil {
+		return err
+	}
+	reply.Circuits = circs
+	return nil
+}
+
+// TorNewIdentity has tor use new circuits from now on
+func (r *LitRPC) TorNewIdentity(args NoArgs, reply *StatusReply) error {
+	err := r.Node.TorNewIdentity()
+	if err != nil {
+		return err
+	}
+	reply.Status = "tor switched to new circuits"
+	return nil
+}
@@ -1,6 +1,11 @@
 package litrpc
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/lit/watchtower"
+)
 
 type WatchArgs struct {
 	ChanIdx, SendToPeer uint32
@@ -22,7 +27,9 @@ func (r *LitRPC) Watch(args WatchArgs, reply *WatchReply) error {
 		return fmt.Errorf("Can't push; channel %d closed", args.ChanIdx)
 	}
 
-	err = r.Node.SyncWatch(qc, args.SendToPeer)
+	// register the channel with this tower, alongside any others, and send
+	// it everything it's missing
+	err = r.Node.AddChanTower(qc, args.SendToPeer)
 	if err != nil {
 		return err
 	}
@@ -30,3 +37,114 @@ func (r *LitRPC) Watch(args WatchArgs, reply *WatchReply) error {
 	reply.Msg = "ok"
 	return nil
 }
+
+// Unwatch stops sending a channel's states to a tower.
+func (r *LitRPC) Unwatch(args WatchArgs, reply *StatusReply) error {
+	err := r.Node.RemoveChanTower(args.ChanIdx, args.SendToPeer)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("channel %d no longer sent to tower %d",
+		args.ChanIdx, args.SendToPeer)
+	return nil
+}
+
+type TowerLedgerReply struct {
+	Terms    watchtower.FeeTerms
+	Accounts []watchtower.Account
+}
+
+// TowerLedger shows what our tower charges and every client's account.
+func (r *LitRPC) TowerLedger(args NoArgs, reply *TowerLedgerReply) error {
+	var err error
+	reply.Terms = r.Node.Tower.GetTerms()
+	reply.Accounts, err = r.Node.Tower.Ledger()
+	return err
+}
+
+type TowerTermsArgs struct {
+	PerChannel, PerState int64
+}
+
+// SetTowerTerms changes what our tower charges.
+func (r *LitRPC) SetTowerTerms(args TowerTermsArgs, reply *StatusReply) error {
+	if args.PerChannel < 0 || args.PerState < 0 {
+		return fmt.Errorf("fees can't be negative")
+	}
+	r.Node.Tower.SetTerms(watchtower.FeeTerms{
+		PerChannel: args.PerChannel, PerState: args.PerState})
+	reply.Status = fmt.Sprintf("tower charges %d per channel, %d per state",
+		args.PerChannel, args.PerState)
+	return nil
+}
+
+type PayTowerArgs struct {
+	PeerIdx uint32
+	Amt     int64
+}
+
+// PayTower adds credit with a tower, pushing to it in a channel we have
+// with it.
+func (r *LitRPC) PayTower(args PayTowerArgs, reply *StatusReply) error {
+	err := r.Node.PayTower(args.PeerIdx, args.Amt)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("paid tower %d %d", args.PeerIdx, args.Amt)
+	return nil
+}
+
+type TowerFeesReply struct {
+	Budget int64
+	Towers []qln.TowerFeeInfo
+}
+
+// TowerFees shows what we've paid the towers we use, and our budget.
+func (r *LitRPC) TowerFees(args NoArgs, reply *TowerFeesReply) error {
+	var err error
+	reply.Budget = r.Node.TowerBudget
+	reply.Towers, err = r.Node.TowerFees()
+	return err
+}
+
+type TowerStatusReply struct {
+	Towers []qln.TowerStatus
+}
+
+// TowerStatus shows, for each tower we use, which channels it watches and
+// how far behind it is on each.
+func (r *LitRPC) TowerStatus(args NoArgs, reply *TowerStatusReply) error {
+	var err error
+	reply.Towers, err = r.Node.TowerStatus()
+	return err
+}
+
+type AutoWatchArgs struct {
+	ChanIdx uint32
+	// Policy is "on" or "off" to override whether the channel is sent to
+	// towers automatically, or "default" to do what the node does
+	Policy string
+}
+
+// AutoWatch sets whether one channel's states are sent to towers
+// automatically, whatever the node's setting.
+func (r *LitRPC) AutoWatch(args AutoWatchArgs, reply *StatusReply) error {
+	err := r.Node.SetChanAutoWatch(args.ChanIdx, args.Policy)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("channel %d auto-watch %s",
+		args.ChanIdx, args.Policy)
+	return nil
+}
+
+type FindTowersReply struct {
+	Towers []qln.TowerAd
+}
+
+// FindTowers asks the tracker for watchtowers we could use.
+func (r *LitRPC) FindTowers(args NoArgs, reply *FindTowersReply) error {
+	var err error
+	reply.Towers, err = qln.FindTowers(r.Node.TrackerURL, r.Node.ProxyURL)
+	return err
+}
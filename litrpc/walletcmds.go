@@ -1,14 +1,21 @@
 package litrpc
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 
 	"github.com/adiabat/bech32"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
 	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/qln"
+	"github.com/mit-dci/lit/wallit"
 )
 
 type TxidsReply struct {
@@ -93,6 +100,7 @@ type TxoInfo struct {
 	Witty    bool
 
 	KeyPath string
+	Label   string // the output's label, or else its tx's
 }
 type TxoListReply struct {
 	Txos []TxoInfo
@@ -122,6 +130,7 @@ func (r *LitRPC) TxoList(args *NoArgs, reply *TxoListReply) error {
 			}
 			theseTxos[i].Witty = u.Mode&portxo.FlagTxoWitness != 0
 			theseTxos[i].KeyPath = u.KeyGen.String()
+			theseTxos[i].Label = wal.TxoLabel(u.Op)
 		}
 
 		reply.Txos = append(reply.Txos, theseTxos...)
@@ -129,10 +138,242 @@ func (r *LitRPC) TxoList(args *NoArgs, reply *TxoListReply) error {
 	return nil
 }
 
+type ListUnspentArgs struct {
+	CoinType uint32 // 0 for every wallet
+
+	MinConf, MaxConf int32 // MaxConf 0 for no max
+	MinAmt, MaxAmt   int64 // MaxAmt 0 for no max
+
+	// locked includes frozen ones, reserved for txs not sent yet
+	SkipLocked bool // leave out locked utxos
+	OnlyLocked bool // only give locked utxos
+}
+type UnspentInfo struct {
+	OutPoint      string
+	CoinType      uint32
+	Amt           int64
+	Height        int32 // 0 if unconfirmed
+	Confirmations int32
+	Mature        bool // past any relative timelock
+
+	ScriptType string // like "witness pubkey hash compressed"
+	PkScript   string // hex
+	KeyPath    string // where the key is in the wallet's tree
+	Account    uint32 // named account, 0 for the default
+
+	Locked     bool
+	LockReason string
+	Label      string
+}
+type ListUnspentReply struct {
+	Utxos []UnspentInfo
+}
+
+// ListUnspent gives the wallets' utxos with what's needed to spend or
+// account for them, filtered by confirmations, amount and lock status.
+func (r *LitRPC) ListUnspent(args ListUnspentArgs, reply *ListUnspentReply) error {
+	if args.SkipLocked && args.OnlyLocked {
+		return fmt.Errorf("can't both skip locked utxos and only give locked ones")
+	}
+	if args.MaxConf != 0 && args.MaxConf < args.MinConf {
+		return fmt.Errorf("max confirmations %d under min %d",
+			args.MaxConf, args.MinConf)
+	}
+	if args.MaxAmt != 0 && args.MaxAmt < args.MinAmt {
+		return fmt.Errorf("max amount %d under min %d", args.MaxAmt, args.MinAmt)
+	}
+
+	coinTypes := make([]uint32, 0, len(r.Node.SubWallet))
+	if args.CoinType != 0 {
+		_, ok := r.Node.SubWallet[args.CoinType]
+		if !ok {
+			return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+		}
+		coinTypes = append(coinTypes, args.CoinType)
+	} else {
+		for coinType := range r.Node.SubWallet {
+			coinTypes = append(coinTypes, coinType)
+		}
+		sort.Slice(coinTypes, func(i, j int) bool { return coinTypes[i] < coinTypes[j] })
+	}
+
+	reply.Utxos = []UnspentInfo{}
+	for _, coinType := range coinTypes {
+		wal := r.Node.SubWallet[coinType]
+
+		utxos, err := wal.UtxoDump()
+		if err != nil {
+			return err
+		}
+		locks, err := wal.ListLocks()
+		if err != nil {
+			return err
+		}
+		lockReasons := make(map[wire.OutPoint]string, len(locks))
+		for _, l := range locks {
+			lockReasons[l.Op] = l.Reason
+		}
+
+		syncHeight := wal.CurrentHeight()
+		for _, u := range utxos {
+			var confs int32
+			if u.Height > 0 {
+				confs = syncHeight - u.Height + 1
+			}
+			reason, locked := lockReasons[u.Op]
+			if confs < args.MinConf || (args.MaxConf != 0 && confs > args.MaxConf) ||
+				u.Value < args.MinAmt || (args.MaxAmt != 0 && u.Value > args.MaxAmt) ||
+				(args.SkipLocked && locked) || (args.OnlyLocked && !locked) {
+				continue
+			}
+
+			reply.Utxos = append(reply.Utxos, UnspentInfo{
+				OutPoint:      u.Op.String(),
+				CoinType:      coinType,
+				Amt:           u.Value,
+				Height:        u.Height,
+				Confirmations: confs,
+				Mature:        u.Mature(syncHeight),
+				ScriptType:    u.Mode.String(),
+				PkScript:      hex.EncodeToString(u.PkScript),
+				KeyPath:       u.KeyGen.String(),
+				Account:       wallit.UtxoAcct(u),
+				Locked:        locked,
+				LockReason:    reason,
+				Label:         wal.TxoLabel(u.Op),
+			})
+		}
+	}
+	return nil
+}
+
+// ------------------------- sync status
+type SyncStatusReply struct {
+	CoinType   uint32
+	SyncHeight int32 // height the wallet is synced to
+	Rescanning bool
+	// for a rescan: where it started, the last block done, and where it ends
+	RescanFrom, RescanHeight, RescanTo int32
+}
+
+// SyncStatus tells how far a wallet has synced, and how a rescan is going.
+func (r *LitRPC) SyncStatus(args CoinArgs, reply *SyncStatusReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	reply.CoinType = args.CoinType
+	reply.SyncHeight = wal.CurrentHeight()
+	reply.RescanFrom, reply.RescanHeight, reply.RescanTo = wal.RescanStatus()
+	reply.Rescanning = reply.RescanTo != 0
+	return nil
+}
+
+type RescanArgs struct {
+	CoinType   uint32
+	FromHeight int32
+}
+
+// Rescan goes back over the chain from a height to pick up txs the wallet
+// missed, like after restoring from seed.  Check on it with SyncStatus.
+func (r *LitRPC) Rescan(args RescanArgs, reply *StatusReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	err := wal.Rescan(args.FromHeight)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("rescanning from height %d", args.FromHeight)
+	return nil
+}
+
 // ------------------------- send
 type SendArgs struct {
-	DestAddrs []string
-	Amts      []int64
+	DestAddrs  []string // addresses, or names from the address book
+	Amts       []int64
+	CoinSelect string // coin selection strategy; empty for the wallet's
+	// manual coin control, txid:index outpoints.  If UseInputs is given,
+	// exactly those are spent; AvoidInputs are never spent.
+	UseInputs   []string
+	AvoidInputs []string
+	Account     string // account to send from; empty for the default
+	// where change goes; all empty for a new witness address in Account.
+	// ChangeAddress wins over the others.
+	ChangeAddress string
+	ChangeType    string // AdrTypeBech32, AdrTypeLegacy or AdrTypeTaproot
+	ChangeAccount string // another account to put change in
+}
+
+// changeSpec makes a ChangeSpec out of send args; nil if they're all empty.
+// Change to an address has to be on the same coin as the wallet.
+func changeSpec(wal qln.UWallet, acct uint32,
+	adr, adrType, acctName string) (*lnutil.ChangeSpec, error) {
+	if adr == "" && adrType == "" && acctName == "" {
+		return nil, nil
+	}
+	spec := &lnutil.ChangeSpec{Acct: acct, AdrType: adrType}
+	if adr != "" {
+		if CoinTypeFromAdr(adr) != wal.Params().HDCoinType {
+			return nil, fmt.Errorf("change address %s isn't for %s",
+				adr, wal.Params().Name)
+		}
+		var err error
+		spec.Script, err = AdrStringToOutscript(adr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch adrType {
+	case "", AdrTypeBech32, AdrTypeLegacy, AdrTypeTaproot:
+	default:
+		return nil, fmt.Errorf("change type %s; expect %s, %s or %s",
+			adrType, AdrTypeBech32, AdrTypeLegacy, AdrTypeTaproot)
+	}
+	if acctName != "" {
+		var err error
+		spec.Acct, err = acctByName(wal, acctName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return spec, nil
+}
+
+// coinControlOps parses outpoint strings for coin control, and makes sure
+// they're all utxos in the wallet.
+func coinControlOps(
+	opStrings []string, utxos []*portxo.PorTxo) ([]wire.OutPoint, error) {
+	var ops []wire.OutPoint
+	for _, s := range opStrings {
+		op, err := lnutil.OutPointFromString(s)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, u := range utxos {
+			if lnutil.OutPointsEqual(u.Op, *op) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s isn't a utxo in the wallet", s)
+		}
+		ops = append(ops, *op)
+	}
+	return ops, nil
 }
 
 func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) error {
@@ -146,6 +387,9 @@ func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) error {
 		return fmt.Errorf("%d addresses but %d amounts specified",
 			nOutputs, len(args.Amts))
 	}
+	for i, a := range args.DestAddrs {
+		args.DestAddrs[i] = r.contactAdr(a)
+	}
 	// get cointype for first address.
 	coinType := CoinTypeFromAdr(args.DestAddrs[0])
 	// make sure we support that coin type
@@ -177,8 +421,32 @@ func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) error {
 		txOuts[i] = wire.NewTxOut(args.Amts[i], outScript)
 	}
 
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		return err
+	}
+	useOps, err := coinControlOps(args.UseInputs, utxos)
+	if err != nil {
+		return err
+	}
+	avoidOps, err := coinControlOps(args.AvoidInputs, utxos)
+	if err != nil {
+		return err
+	}
+
+	acct, err := acctByName(wal, args.Account)
+	if err != nil {
+		return err
+	}
+	change, err := changeSpec(wal, acct,
+		args.ChangeAddress, args.ChangeType, args.ChangeAccount)
+	if err != nil {
+		return err
+	}
+
 	// we don't care if it's witness or not
-	ops, err := wal.MaybeSend(txOuts, false)
+	ops, err := wal.MaybeSendFrom(
+		acct, txOuts, false, args.CoinSelect, useOps, avoidOps, change, 0)
 	if err != nil {
 		return err
 	}
@@ -192,6 +460,55 @@ func (r *LitRPC) Send(args SendArgs, reply *TxidsReply) error {
 	return nil
 }
 
+// ------------------------- send many
+type SendManyArgs struct {
+	Outputs    map[string]int64 // address (or contact name) : amount
+	CoinSelect string
+	// coin control, account and change, as in SendArgs
+	UseInputs     []string
+	AvoidInputs   []string
+	Account       string
+	ChangeAddress string
+	ChangeType    string
+	ChangeAccount string
+}
+
+// SendMany pays a bunch of addresses in one tx, with one change output and
+// one fee.
+func (r *LitRPC) SendMany(args SendManyArgs, reply *TxidsReply) error {
+	sendArgs := SendArgs{
+		CoinSelect:  args.CoinSelect,
+		UseInputs:   args.UseInputs,
+		AvoidInputs: args.AvoidInputs,
+		Account:     args.Account,
+
+		ChangeAddress: args.ChangeAddress,
+		ChangeType:    args.ChangeType,
+		ChangeAccount: args.ChangeAccount,
+	}
+	// same order every time
+	for adr := range args.Outputs {
+		sendArgs.DestAddrs = append(sendArgs.DestAddrs, adr)
+	}
+	sort.Strings(sendArgs.DestAddrs)
+
+	// a tx can't pay the same script twice; see MaybeSend
+	seen := make(map[string]string)
+	for _, adr := range sendArgs.DestAddrs {
+		sendArgs.Amts = append(sendArgs.Amts, args.Outputs[adr])
+		outScript, err := AdrStringToOutscript(r.contactAdr(adr))
+		if err != nil {
+			return err
+		}
+		if prev, ok := seen[string(outScript)]; ok {
+			return fmt.Errorf("%s and %s are the same address", prev, adr)
+		}
+		seen[string(outScript)] = adr
+	}
+
+	return r.Send(sendArgs, reply)
+}
+
 // ------------------------- sweep
 type SweepArgs struct {
 	DestAdr string
@@ -231,6 +548,88 @@ func (r *LitRPC) Sweep(args SweepArgs, reply *TxidsReply) error {
 	return nil
 }
 
+// ------------------------- sweep all
+type SweepAllArgs struct {
+	DestAdr string
+	FeeRate int64 // sat / byte; 0 for the wallet's
+}
+
+// SweepAll sends all of a wallet's confirmed utxos to an address in one tx.
+func (r *LitRPC) SweepAll(args SweepAllArgs, reply *TxidsReply) error {
+	// get cointype for the address.
+	coinType := CoinTypeFromAdr(args.DestAdr)
+	// make sure we support that coin type
+	wal, ok := r.Node.SubWallet[coinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for address %s type %d",
+			args.DestAdr, coinType)
+	}
+	if args.FeeRate < 0 {
+		return fmt.Errorf("Invalid fee rate %d", args.FeeRate)
+	}
+
+	outScript, err := AdrStringToOutscript(args.DestAdr)
+	if err != nil {
+		return err
+	}
+
+	txid, err := wal.SweepAll(outScript, args.FeeRate)
+	if err != nil {
+		return err
+	}
+	reply.Txids = append(reply.Txids, txid.String())
+	return nil
+}
+
+// ------------------------- consolidate
+type ConsolidateArgs struct {
+	CoinType   uint32
+	Below      int64 // utxos worth less than this get consolidated
+	MaxFeeRate int64 // don't if the wallet's fee rate is over this; 0 for any
+	// Auto sets the wallet to consolidate by itself as blocks come in,
+	// instead of now.  Below of 0 turns that off.
+	Auto bool
+}
+type ConsolidateReply struct {
+	Txid       string // empty if Auto
+	Below      int64  // auto consolidation settings now
+	MaxFeeRate int64
+}
+
+// Consolidate spends a wallet's small utxos to one of its own addresses in
+// one tx, or sets it to do so on its own when fees are low.
+func (r *LitRPC) Consolidate(args ConsolidateArgs, reply *ConsolidateReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	if args.MaxFeeRate < 0 {
+		return fmt.Errorf("Invalid fee rate %d", args.MaxFeeRate)
+	}
+
+	if args.Auto {
+		err := wal.SetAutoConsolidate(args.Below, args.MaxFeeRate)
+		if err != nil {
+			return err
+		}
+	} else {
+		if args.Below <= 0 {
+			return fmt.Errorf("can't consolidate utxos under %d", args.Below)
+		}
+		txid, err := wal.Consolidate(args.Below, args.MaxFeeRate)
+		if err != nil {
+			return err
+		}
+		reply.Txid = txid.String()
+	}
+	reply.Below, reply.MaxFeeRate = wal.AutoConsolidate()
+	return nil
+}
+
 // ------------------------- fanout
 type FanArgs struct {
 	DestAdr      string
@@ -269,7 +668,7 @@ func (r *LitRPC) Fanout(args FanArgs, reply *TxidsReply) error {
 	}
 
 	// don't care if inputs are witty or not
-	ops, err := wal.MaybeSend(txos, false)
+	ops, err := wal.MaybeSend(txos, false, "", nil, nil)
 	if err != nil {
 		return err
 	}
@@ -296,7 +695,8 @@ type FeeReply struct {
 	CurrentFee int64
 }
 
-// SetFee allows you to set a fee rate for a wallet.
+// SetFee allows you to set a fee rate for a wallet.  0 goes back to
+// estimating it.
 func (r *LitRPC) SetFee(args *SetFeeArgs, reply *FeeReply) error {
 	// if cointype is 0, use the node's default coin
 	if args.CoinType == 0 {
@@ -329,79 +729,702 @@ func (r *LitRPC) GetFee(args *FeeArgs, reply *FeeReply) error {
 	return nil
 }
 
-// ------------------------- address
-type AddressArgs struct {
-	NumToMake uint32
-	CoinType  uint32
+// ------------------------- bump fee
+type BumpFeeArgs struct {
+	Txid     string
+	FeeRate  int64 // new fee rate, sat / byte
+	CoinType uint32
 }
-type AddressReply struct {
-	WitAddresses    []string
-	LegacyAddresses []string
+
+// BumpFee replaces an unconfirmed wallet tx with one paying a higher fee.
+func (r *LitRPC) BumpFee(args BumpFeeArgs, reply *TxidsReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	if args.FeeRate < 1 {
+		return fmt.Errorf("Invalid fee rate %d", args.FeeRate)
+	}
+	txid, err := chainhash.NewHashFromStr(args.Txid)
+	if err != nil {
+		return err
+	}
+
+	newTxid, err := wal.BumpFee(*txid, args.FeeRate)
+	if err != nil {
+		return err
+	}
+	reply.Txids = append(reply.Txids, newTxid.String())
+	return nil
 }
 
-func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
-	var allAdr [][20]byte
-	var ctypesPerAdr []uint32
+// ------------------------- cpfp
+type CpfpArgs struct {
+	OutPoint string // txid:index
+	FeeRate  int64  // fee rate for parent and child together, sat / byte
+	CoinType uint32
+}
 
+// CpfpSweep spends an unconfirmed output with a high fee child, to get its
+// parent confirmed.
+func (r *LitRPC) CpfpSweep(args CpfpArgs, reply *TxidsReply) error {
 	// if cointype is 0, use the node's default coin
 	if args.CoinType == 0 {
 		args.CoinType = r.Node.DefaultCoin
 	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	if args.FeeRate < 1 {
+		return fmt.Errorf("Invalid fee rate %d", args.FeeRate)
+	}
+	op, err := lnutil.OutPointFromString(args.OutPoint)
+	if err != nil {
+		return err
+	}
 
-	// If you tell it to make 0 new addresses, it sends a list of all the old ones
-	// (from every wallet)
-	if args.NumToMake == 0 {
-		// this gets 20 byte addresses; need to convert them to bech32 / base58
-		// iterate through every wallet
-		for cointype, wal := range r.Node.SubWallet {
-			walAdr, err := wal.AdrDump()
-			if err != nil {
-				return err
-			}
+	txid, err := wal.CpfpSweep(*op, args.FeeRate)
+	if err != nil {
+		return err
+	}
+	reply.Txids = append(reply.Txids, txid.String())
+	return nil
+}
 
-			for _, _ = range walAdr {
-				ctypesPerAdr = append(ctypesPerAdr, cointype)
-			}
-			allAdr = append(allAdr, walAdr...)
+// ------------------------- tx labels
+type LabelTxArgs struct {
+	Target   string // txid for the whole tx, or txid:index for an output
+	Label    string // empty takes the label off
+	CoinType uint32 // 0 for whichever wallet has the tx
+}
+
+// LabelTx puts a label on a wallet tx or output.
+func (r *LitRPC) LabelTx(args LabelTxArgs, reply *StatusReply) error {
+	var txid chainhash.Hash
+	index := int32(-1)
+	if strings.ContainsAny(args.Target, ":;") {
+		op, err := lnutil.OutPointFromString(args.Target)
+		if err != nil {
+			return err
 		}
+		txid, index = op.Hash, int32(op.Index)
 	} else {
-		// if you have non-zero NumToMake, then cointype matters
+		hash, err := chainhash.NewHashFromStr(args.Target)
+		if err != nil {
+			return err
+		}
+		txid = *hash
+	}
+
+	var err error
+	if args.CoinType != 0 {
 		wal, ok := r.Node.SubWallet[args.CoinType]
 		if !ok {
-			return fmt.Errorf("No wallet of cointype %d linked", args.CoinType)
+			return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
 		}
-
-		// call NewAdr a bunch of times
-		remaining := args.NumToMake
-		for remaining > 0 {
-			adr, err := wal.NewAdr()
-			if err != nil {
-				return err
+		err = wal.LabelTx(txid, index, args.Label)
+	} else {
+		err = fmt.Errorf("no wallet has tx %s", txid.String())
+		for _, wal := range r.Node.SubWallet {
+			if wal.LabelTx(txid, index, args.Label) == nil {
+				err = nil
+				break
 			}
-			allAdr = append(allAdr, adr)
-			ctypesPerAdr = append(ctypesPerAdr, args.CoinType)
-			remaining--
 		}
 	}
+	if err != nil {
+		return err
+	}
 
-	reply.WitAddresses = make([]string, len(allAdr))
-	reply.LegacyAddresses = make([]string, len(allAdr))
-
-	for i, a := range allAdr {
-		// convert 20 byte array to old address
-
-		param := r.Node.SubWallet[ctypesPerAdr[i]].Params()
+	if args.Label == "" {
+		reply.Status = fmt.Sprintf("took label off %s", args.Target)
+	} else {
+		reply.Status = fmt.Sprintf("labeled %s %s", args.Target, args.Label)
+	}
+	return nil
+}
 
-		oldadr := lnutil.OldAddressFromPKH(a, param.PubKeyHashAddrID)
-		reply.LegacyAddresses[i] = oldadr
+type TxLabelInfo struct {
+	Target   string // txid, or txid:index
+	Label    string
+	CoinType uint32
+}
+type ListTxLabelsReply struct {
+	Labels []TxLabelInfo
+}
 
-		// convert 20-byte PKH to a bech32 segwit v0 address
-		bech32adr, err := bech32.SegWitV0Encode(param.Bech32Prefix, a[:])
+// ListTxLabels gives the labels in every wallet.
+func (r *LitRPC) ListTxLabels(args NoArgs, reply *ListTxLabelsReply) error {
+	for cointype, wal := range r.Node.SubWallet {
+		labels, err := wal.ListTxLabels()
+		if err != nil {
+			return err
+		}
+		for _, l := range labels {
+			target := l.Txid.String()
+			if l.Index != -1 {
+				target = fmt.Sprintf("%s:%d", target, l.Index)
+			}
+			reply.Labels = append(reply.Labels, TxLabelInfo{
+				Target: target, Label: l.Label, CoinType: cointype})
+		}
+	}
+	return nil
+}
+
+// ------------------------- utxo locks
+type LockUtxoArgs struct {
+	OutPoint string // txid:index
+	Reason   string // optional note shown in ListLocks
+	CoinType uint32
+}
+
+// LockUtxo keeps a utxo from being spent by sends or channel funding.
+func (r *LitRPC) LockUtxo(args LockUtxoArgs, reply *StatusReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	op, err := lnutil.OutPointFromString(args.OutPoint)
+	if err != nil {
+		return err
+	}
+	if args.Reason == "" {
+		args.Reason = "locked by user"
+	}
+
+	err = wal.LockUtxo(*op, args.Reason)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("locked %s", op.String())
+	return nil
+}
+
+// UnlockUtxo lets a locked utxo be spent again.
+func (r *LitRPC) UnlockUtxo(args LockUtxoArgs, reply *StatusReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	op, err := lnutil.OutPointFromString(args.OutPoint)
+	if err != nil {
+		return err
+	}
+
+	err = wal.UnlockUtxo(*op)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("unlocked %s", op.String())
+	return nil
+}
 
+type UtxoLockInfo struct {
+	OutPoint string
+	Reason   string
+}
+
+type ListLocksReply struct {
+	Locks []UtxoLockInfo
+}
+
+// ListLocks shows the utxos a wallet won't spend, and why.
+func (r *LitRPC) ListLocks(args CoinArgs, reply *ListLocksReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	locks, err := wal.ListLocks()
+	if err != nil {
+		return err
+	}
+	reply.Locks = make([]UtxoLockInfo, len(locks))
+	for i, l := range locks {
+		reply.Locks[i].OutPoint = l.Op.String()
+		reply.Locks[i].Reason = l.Reason
+	}
+	return nil
+}
+
+// ------------------------- rebroadcasts
+type BroadcastInfo struct {
+	Txid      string
+	Status    string
+	Height    int32 // where it, or what conflicts with it, confirmed
+	FirstSent int64
+	LastSent  int64
+	Sends     uint32
+}
+
+type ListBroadcastsReply struct {
+	Broadcasts []BroadcastInfo
+}
+
+// ListBroadcasts shows the txs a wallet sent that it's sending out again
+// till they confirm, and the ones that have recently confirmed or been
+// conflicted or replaced.
+func (r *LitRPC) ListBroadcasts(args CoinArgs, reply *ListBroadcastsReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	bs, err := wal.Broadcasts()
+	if err != nil {
+		return err
+	}
+	reply.Broadcasts = make([]BroadcastInfo, len(bs))
+	for i, b := range bs {
+		reply.Broadcasts[i] = BroadcastInfo{
+			Txid:      b.Tx.TxHash().String(),
+			Status:    b.Status,
+			Height:    b.Height,
+			FirstSent: b.FirstSent,
+			LastSent:  b.LastSent,
+			Sends:     b.Sends,
+		}
+	}
+	return nil
+}
+
+// ------------------------- db check
+type CheckDBReply struct {
+	DBs []kvdb.CompactReport
+	// Problems are channels whose saved data doesn't add up
+	Problems []string
+}
+
+// CheckDB reads through all the open dbs, checks the channels in them, and
+// says how much space compacting each would free up.  Compacting is done
+// with lit stopped, with --compactdb.
+func (r *LitRPC) CheckDB(args NoArgs, reply *CheckDBReply) error {
+	var err error
+	reply.DBs, reply.Problems, err = r.Node.CheckDBs()
+	return err
+}
+
+// ------------------------- watch-only xpub accounts
+type ImportXpubArgs struct {
+	CoinType uint32
+	Xpub     string
+	// where the xpub is under the signing wallet's master key, like
+	// d34db33f and m/84'/0'/0'.  Leave both empty if the xpub is the master.
+	Fingerprint string
+	Path        string
+	// if not 0, rescan from here to find the xpub's old txs
+	RescanFrom int32
+}
+
+type ImportXpubReply struct {
+	Account uint32
+	Status  string
+}
+
+// ImportXpub watches an xpub without its keys, for keeping an eye on cold
+// storage.  Its coins are never spent by the wallet; see BuildPsbt.
+func (r *LitRPC) ImportXpub(args ImportXpubArgs, reply *ImportXpubReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	var fingerprint [4]byte
+	if args.Fingerprint != "" {
+		fpBytes, err := hex.DecodeString(args.Fingerprint)
+		if err != nil {
+			return err
+		}
+		if len(fpBytes) != 4 {
+			return fmt.Errorf("fingerprint %s; expect 8 hex characters",
+				args.Fingerprint)
+		}
+		copy(fingerprint[:], fpBytes)
+	}
+	path, err := lnutil.Bip32PathFromString(args.Path)
+	if err != nil {
+		return err
+	}
+
+	reply.Account, err = wal.ImportXpub(args.Xpub, fingerprint, path)
+	if err != nil {
+		return err
+	}
+	reply.Status = fmt.Sprintf("watching xpub as account %d", reply.Account)
+
+	if args.RescanFrom != 0 {
+		err = wal.RescanWatchAcct(reply.Account, args.RescanFrom)
 		if err != nil {
 			return err
 		}
-		reply.WitAddresses[i] = bech32adr
+		reply.Status += fmt.Sprintf(", rescanning from height %d", args.RescanFrom)
+	}
+	return nil
+}
+
+type WatchAcctInfo struct {
+	Account     uint32
+	Xpub        string
+	Fingerprint string
+	Path        string
+	Total       int64 // all utxos
+	Confirmed   int64
+	Txos        []TxoInfo
+}
+
+type WatchAcctsReply struct {
+	Accounts []WatchAcctInfo
+}
+
+// WatchAccts lists a wallet's watch-only xpubs with their balances and utxos.
+func (r *LitRPC) WatchAccts(args CoinArgs, reply *WatchAcctsReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	accts, err := wal.WatchAccts()
+	if err != nil {
+		return err
+	}
+	reply.Accounts = make([]WatchAcctInfo, len(accts))
+	for i, a := range accts {
+		info := &reply.Accounts[i]
+		info.Account = a.Idx
+		info.Xpub = a.Xpub
+		info.Fingerprint = hex.EncodeToString(a.Fingerprint[:])
+		info.Path = lnutil.Bip32PathString(a.Path)
+
+		utxos, err := wal.WatchUtxos(a.Idx)
+		if err != nil {
+			return err
+		}
+		for _, u := range utxos {
+			info.Total += u.Value
+			if u.Height > 0 {
+				info.Confirmed += u.Value
+			}
+			info.Txos = append(info.Txos, TxoInfo{
+				OutPoint: u.Op.String(),
+				Amt:      u.Value,
+				Height:   u.Height,
+				CoinType: wal.Params().Name,
+				Witty:    u.Mode&portxo.FlagTxoWitness != 0,
+				KeyPath: lnutil.Bip32PathString(append(append([]uint32{},
+					a.Path...), u.KeyGen.Step[0], u.KeyGen.Step[1])),
+			})
+		}
+	}
+	return nil
+}
+
+type BuildPsbtArgs struct {
+	Account   uint32
+	DestAddrs []string
+	Amts      []int64
+	FeeRate   int64 // sat / byte; 0 for the wallet's
+}
+
+type PsbtReply struct {
+	Psbt string // base64
+}
+
+// watchSendOuts checks BuildPsbtArgs, and gives the wallet for the
+// addresses' coin type and the txouts to them.
+func (r *LitRPC) watchSendOuts(
+	args BuildPsbtArgs) (qln.UWallet, []*wire.TxOut, error) {
+
+	nOutputs := len(args.DestAddrs)
+	if nOutputs < 1 {
+		return nil, nil, fmt.Errorf("No destination address specified")
+	}
+	if nOutputs != len(args.Amts) {
+		return nil, nil, fmt.Errorf("%d addresses but %d amounts specified",
+			nOutputs, len(args.Amts))
+	}
+	if args.FeeRate < 0 {
+		return nil, nil, fmt.Errorf("Invalid fee rate %d", args.FeeRate)
+	}
+	// get cointype for first address.
+	coinType := CoinTypeFromAdr(args.DestAddrs[0])
+	// make sure we support that coin type
+	wal, ok := r.Node.SubWallet[coinType]
+	if !ok {
+		return nil, nil, fmt.Errorf("no connnected wallet for address %s type %d",
+			args.DestAddrs[0], coinType)
+	}
+
+	txOuts := make([]*wire.TxOut, nOutputs)
+	for i, s := range args.DestAddrs {
+		if CoinTypeFromAdr(s) != coinType {
+			return nil, nil, fmt.Errorf("Coin type mismatch for address %s, %s",
+				s, args.DestAddrs[0])
+		}
+		if args.Amts[i] < consts.MinSendAmt {
+			return nil, nil, fmt.Errorf("Amt %d less than minimum send amount %d",
+				args.Amts[i], consts.MinSendAmt)
+		}
+		outScript, err := AdrStringToOutscript(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		txOuts[i] = wire.NewTxOut(args.Amts[i], outScript)
+	}
+	return wal, txOuts, nil
+}
+
+// BuildPsbt makes an unsigned tx paying from a watch account, as a psbt
+// for the wallet with the keys to sign and broadcast.
+func (r *LitRPC) BuildPsbt(args BuildPsbtArgs, reply *PsbtReply) error {
+	wal, txOuts, err := r.watchSendOuts(args)
+	if err != nil {
+		return err
+	}
+
+	p, err := wal.BuildPsbt(args.Account, txOuts, args.FeeRate)
+	if err != nil {
+		return err
+	}
+	reply.Psbt, err = p.B64()
+	return err
+}
+
+// SpendWatch sends from a watch account, signing with the external signer
+// set up for that coin type.
+func (r *LitRPC) SpendWatch(args BuildPsbtArgs, reply *TxidsReply) error {
+	wal, txOuts, err := r.watchSendOuts(args)
+	if err != nil {
+		return err
+	}
+
+	txid, err := wal.SpendWatch(args.Account, txOuts, args.FeeRate)
+	if err != nil {
+		return err
+	}
+	reply.Txids = append(reply.Txids, txid.String())
+	return nil
+}
+
+type PushPsbtArgs struct {
+	CoinType uint32
+	Psbt     string // base64, signed
+}
+
+// PushPsbt takes a psbt signed elsewhere, checks it and sends the tx.
+func (r *LitRPC) PushPsbt(args PushPsbtArgs, reply *TxidsReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	p, err := lnutil.PsbtFromB64(args.Psbt)
+	if err != nil {
+		return err
+	}
+	txid, err := wal.PushPsbt(p)
+	if err != nil {
+		return err
+	}
+	reply.Txids = append(reply.Txids, txid.String())
+	return nil
+}
+
+// ------------------------- coin selection
+type CoinSelectArgs struct {
+	CoinType uint32
+	Strategy string // empty to leave it as it is
+}
+
+type CoinSelectReply struct {
+	Strategy   string
+	Strategies []string
+}
+
+// CoinSelect sets or gets the coin selection strategy for a wallet.
+func (r *LitRPC) CoinSelect(args *CoinSelectArgs, reply *CoinSelectReply) error {
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	// make sure we support that coin type
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+	if args.Strategy != "" {
+		err := wal.SetCoinSelect(args.Strategy)
+		if err != nil {
+			return err
+		}
+	}
+	reply.Strategy = wal.CoinSelect()
+	reply.Strategies = wallit.CoinSelectStrategies
+	return nil
+}
+
+// ------------------------- address
+
+// address types for AddressArgs
+const (
+	AdrTypeBech32  = lnutil.AdrTypeBech32
+	AdrTypeLegacy  = lnutil.AdrTypeLegacy
+	AdrTypeTaproot = lnutil.AdrTypeTaproot
+)
+
+type AddressArgs struct {
+	NumToMake uint32
+	CoinType  uint32
+	AdrType   string // AdrTypeBech32, AdrTypeLegacy, AdrTypeTaproot, or empty for all
+	Account   string // only this account's addresses; empty for the default
+}
+type AddressReply struct {
+	WitAddresses     []string
+	LegacyAddresses  []string
+	TaprootAddresses []string
+	Used             []bool // for each address, if it's been paid already
+}
+
+func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
+	var err error
+	var allAdr [][20]byte
+	var ctypesPerAdr []uint32
+
+	// if cointype is 0, use the node's default coin
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	// every kind of address comes from the same key, and the wallet watches
+	// for all of them, so the type only changes which encodings come back
+	wit := args.AdrType == "" || args.AdrType == AdrTypeBech32
+	legacy := args.AdrType == "" || args.AdrType == AdrTypeLegacy
+	taproot := args.AdrType == "" || args.AdrType == AdrTypeTaproot
+	if !wit && !legacy && !taproot {
+		return fmt.Errorf("address type %s; expect %s, %s or %s",
+			args.AdrType, AdrTypeBech32, AdrTypeLegacy, AdrTypeTaproot)
+	}
+
+	// If you tell it to make 0 new addresses, it sends a list of all the old ones
+	// (from every wallet)
+	if args.NumToMake == 0 {
+		// this gets 20 byte addresses; need to convert them to bech32 / base58
+		// iterate through every wallet
+		for cointype, wal := range r.Node.SubWallet {
+			var walAdr [][20]byte
+			if args.Account == "" {
+				walAdr, err = wal.AdrDump()
+			} else {
+				// named accounts are only in some wallets
+				acct, nameErr := acctByName(wal, args.Account)
+				if nameErr != nil {
+					continue
+				}
+				walAdr, err = wal.AcctAdrDump(acct)
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, _ = range walAdr {
+				ctypesPerAdr = append(ctypesPerAdr, cointype)
+			}
+			allAdr = append(allAdr, walAdr...)
+		}
+	} else {
+		// if you have non-zero NumToMake, then cointype matters
+		wal, ok := r.Node.SubWallet[args.CoinType]
+		if !ok {
+			return fmt.Errorf("No wallet of cointype %d linked", args.CoinType)
+		}
+
+		acct, err := acctByName(wal, args.Account)
+		if err != nil {
+			return err
+		}
+
+		// call NewAdr a bunch of times
+		remaining := args.NumToMake
+		for remaining > 0 {
+			adr, err := wal.NewAcctAdr160(acct)
+			if err != nil {
+				return err
+			}
+			allAdr = append(allAdr, adr)
+			ctypesPerAdr = append(ctypesPerAdr, args.CoinType)
+			remaining--
+		}
+	}
+
+	reply.WitAddresses = make([]string, 0, len(allAdr))
+	reply.LegacyAddresses = make([]string, 0, len(allAdr))
+	reply.TaprootAddresses = make([]string, 0, len(allAdr))
+	reply.Used = make([]bool, 0, len(allAdr))
+
+	for i, a := range allAdr {
+		param := r.Node.SubWallet[ctypesPerAdr[i]].Params()
+		reply.Used = append(reply.Used,
+			r.Node.SubWallet[ctypesPerAdr[i]].AdrUsed(a))
+
+		if legacy {
+			// convert 20 byte array to old address
+			oldadr := lnutil.OldAddressFromPKH(a, param.PubKeyHashAddrID)
+			reply.LegacyAddresses = append(reply.LegacyAddresses, oldadr)
+		}
+
+		if wit {
+			// convert 20-byte PKH to a bech32 segwit v0 address
+			bech32adr, err := bech32.SegWitV0Encode(param.Bech32Prefix, a[:])
+			if err != nil {
+				return err
+			}
+			reply.WitAddresses = append(reply.WitAddresses, bech32adr)
+		}
+
+		if taproot {
+			// taproot pays the tweaked pubkey itself, not its hash
+			tapKey, err := r.Node.SubWallet[ctypesPerAdr[i]].TaprootKeyForAdr(a)
+			if err != nil {
+				return err
+			}
+			tapAdr, err := lnutil.TaprootAddressEncode(param.Bech32Prefix, tapKey)
+			if err != nil {
+				return err
+			}
+			reply.TaprootAddresses = append(reply.TaprootAddresses, tapAdr)
+		}
 	}
 
 	return nil
@@ -414,3 +1437,114 @@ func (r *LitRPC) Address(args *AddressArgs, reply *AddressReply) error {
 //	}
 //	return base58.CheckEncode(pkHash, netID), nil
 //}
+
+// ------------------------- accounts
+// acctByName finds an account's number in a wallet.  Empty is the default.
+func acctByName(wal qln.UWallet, name string) (uint32, error) {
+	if name == "" {
+		return 0, nil
+	}
+	names, err := wal.Accounts()
+	if err != nil {
+		return 0, err
+	}
+	for i, n := range names {
+		if n == name {
+			return uint32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("no account %s", name)
+}
+
+type NewAccountArgs struct {
+	CoinType uint32
+	Name     string
+}
+type NewAccountReply struct {
+	Account uint32
+	Address string // first address of the account
+}
+
+// NewAccount makes a named account in a wallet, with its own keys and
+// balance.
+func (r *LitRPC) NewAccount(args NewAccountArgs, reply *NewAccountReply) error {
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	acct, err := wal.NewAccount(args.Name)
+	if err != nil {
+		return err
+	}
+	adrs, err := wal.AcctAdrDump(acct)
+	if err != nil {
+		return err
+	}
+	if len(adrs) == 0 {
+		return fmt.Errorf("account %d has no addresses", acct)
+	}
+
+	reply.Account = acct
+	reply.Address, err = bech32.SegWitV0Encode(
+		wal.Params().Bech32Prefix, adrs[0][:])
+	return err
+}
+
+type AcctInfo struct {
+	Account     uint32
+	Name        string
+	NumAdrs     int
+	TxoTotal    int64 // all utxos
+	MatureWitty int64 // confirmed, spendable and witness
+}
+type AccountsReply struct {
+	Accounts []AcctInfo
+}
+
+// Accounts gives the accounts in a wallet, and their balances.
+func (r *LitRPC) Accounts(args CoinArgs, reply *AccountsReply) error {
+	if args.CoinType == 0 {
+		args.CoinType = r.Node.DefaultCoin
+	}
+	wal, ok := r.Node.SubWallet[args.CoinType]
+	if !ok {
+		return fmt.Errorf("no connnected wallet for coin type %d", args.CoinType)
+	}
+
+	names, err := wal.Accounts()
+	if err != nil {
+		return err
+	}
+	utxos, err := wal.UtxoDump()
+	if err != nil {
+		return err
+	}
+	height := wal.CurrentHeight()
+
+	acctTxos := make([]portxo.TxoSliceByAmt, len(names))
+	for _, u := range utxos {
+		acct := wallit.UtxoAcct(u)
+		if acct < uint32(len(names)) {
+			acctTxos[acct] = append(acctTxos[acct], u)
+		}
+	}
+
+	for i, name := range names {
+		adrs, err := wal.AcctAdrDump(uint32(i))
+		if err != nil {
+			return err
+		}
+		reply.Accounts = append(reply.Accounts, AcctInfo{
+			Account:     uint32(i),
+			Name:        name,
+			NumAdrs:     len(adrs),
+			TxoTotal:    acctTxos[i].Sum(),
+			MatureWitty: acctTxos[i].SumWitness(height),
+		})
+	}
+	return nil
+}
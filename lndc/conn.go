@@ -6,9 +6,9 @@ import (
 	"crypto/hmac"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/proxy"
@@ -21,6 +21,11 @@ import (
 
 // Conn...
 type LNDConn struct {
+	// bytes on the wire each way, framing and all; first so they're 64-bit
+	// aligned for atomic access
+	bytesIn  uint64
+	bytesOut uint64
+
 	RemotePub *btcec.PublicKey
 
 	myNonceInt     uint64
@@ -44,8 +49,22 @@ type LNDConn struct {
 
 	version uint8
 
+	// noise is set if this connection uses the BOLT #8 transport instead
+	// of lit's own.
+	noise *noiseMachine
+
 	readBuf bytes.Buffer
 
+	// MaxMsgSize is the longest message to accept from the peer, once
+	// they're authenticated.  0 means the protocol max, 16MB.
+	MaxMsgSize uint32
+
+	// ProxyAuth is the username / password given to the SOCKS5 proxy when
+	// dialing through one, unless the proxy URL has its own login.  Tor puts
+	// streams with different credentials on different circuits, so set it
+	// per peer to keep them apart.
+	ProxyAuth *proxy.Auth
+
 	Conn net.Conn
 }
 
@@ -62,37 +81,50 @@ func IP4(ipAddress string) bool {
 	return true
 }
 
+// IsOnion says whether a host or host:port is a tor onion address.
+func IsOnion(netAddress string) bool {
+	host, _, err := net.SplitHostPort(netAddress)
+	if err != nil {
+		host = netAddress
+	}
+	return strings.HasSuffix(host, ".onion")
+}
+
 func parseAdr(netAddress string) (string, string, error) {
-	colonCount := strings.Count(netAddress, ":")
+	host, _, err := net.SplitHostPort(netAddress)
+	if err != nil {
+		// unbracketed ipv6 with port (5+ colons); the port is after the last
+		if strings.Count(netAddress, ":") >= 5 {
+			i := strings.LastIndex(netAddress, ":")
+			return net.JoinHostPort(netAddress[:i], netAddress[i+1:]), "tcp6", nil
+		}
+		return "", "", fmt.Errorf("Invalid ip")
+	}
 	var conMode string
-	if colonCount == 1 && IP4(strings.Split(netAddress, ":")[0]) {
-		// only ipv4 clears this since ipv6 has 6 colons (with port no)
+	if IP4(host) {
 		conMode = "tcp4"
 		return netAddress, conMode, nil
-	} else if colonCount >= 5 {
+	} else if net.ParseIP(host) != nil {
 		conMode = "tcp6"
 		return netAddress, conMode, nil
+	} else if IsOnion(host) {
+		// onion hosts are resolved by the (tor) SOCKS proxy
+		conMode = "tcp"
+		return netAddress, conMode, nil
 	} else {
 		return "", "", fmt.Errorf("Invalid ip")
 	}
 }
 
-// Dial...
-func (c *LNDConn) Dial(
-	myId *btcec.PrivateKey, netAddress string, remotePKH string, proxyURL string) error {
-
-	var err error
-	if myId == nil {
-		return fmt.Errorf("LNDConn Dial: nil myId")
-	}
-
+// open makes the TCP connection, through the proxy if there is one.
+func (c *LNDConn) open(netAddress string, proxyURL string) error {
 	if !c.ViaPbx {
 		if c.Conn != nil {
 			return fmt.Errorf("connection already established")
 		}
 
 		if proxyURL != "" {
-			d, err := proxy.SOCKS5("tcp", proxyURL, nil, proxy.Direct)
+			d, err := ProxyDialer(proxyURL, c.ProxyAuth)
 			if err != nil {
 				return err
 			}
@@ -106,6 +138,9 @@ func (c *LNDConn) Dial(
 				return err
 			}
 		} else {
+			if IsOnion(netAddress) {
+				return fmt.Errorf("can't reach %s without a tor proxy", netAddress)
+			}
 			// First, open the TCP connection itself.
 			netAddress, conMode, err := parseAdr(netAddress)
 			if err != nil {
@@ -118,6 +153,23 @@ func (c *LNDConn) Dial(
 		}
 	}
 
+	return nil
+}
+
+// Dial...
+func (c *LNDConn) Dial(
+	myId *btcec.PrivateKey, netAddress string, remotePKH string, proxyURL string) error {
+
+	var err error
+	if myId == nil {
+		return fmt.Errorf("LNDConn Dial: nil myId")
+	}
+
+	err = c.open(netAddress, proxyURL)
+	if err != nil {
+		return err
+	}
+
 	// check that remotePKH is ok
 	if !lnutil.LitAdrOK(remotePKH) {
 		return fmt.Errorf("invalid ln address %s", remotePKH)
@@ -148,7 +200,7 @@ func (c *LNDConn) Dial(
 	}
 
 	// Wait for theirs; Read, then deserialize their ephemeral public key.
-	theirEphPubBytes, err := readClear(c.Conn)
+	theirEphPubBytes, err := readClear(c.Conn, maxHandshakeMsg)
 	if err != nil {
 		return err
 	}
@@ -170,7 +222,7 @@ func (c *LNDConn) Dial(
 	}
 
 	// display private key for debug only
-	log.Printf("made session key %x\n", sessionKey)
+	log.Tracef("made session key %x\n", sessionKey)
 
 	c.myNonceInt = 1 << 63
 	c.remoteNonceInt = 0
@@ -270,7 +322,7 @@ func (c *LNDConn) authPKH(
 		return err
 	}
 	idDH := fastsha256.Sum256(btcec.GenerateSharedSecret(myId, theirPub))
-	log.Printf("made idDH %x\n", idDH)
+	log.Tracef("made idDH %x\n", idDH)
 	theirDHproof := fastsha256.Sum256(append(localEphPubBytes, idDH[:]...))
 
 	// Verify that their DH proof matches the one we just generated.
@@ -304,34 +356,59 @@ func (c *LNDConn) Read(b []byte) (n int, err error) {
 	// we read the next record, and feed it into the buffer. Otherwise, we
 	// read directly from the buffer.
 	if c.readBuf.Len() == 0 {
-		// The buffer is empty, so read the next cipher text.
-		ctext, err := readClear(c.Conn)
+		msg, err := c.ReadMsg()
 		if err != nil {
 			return 0, err
 		}
+		if _, err := c.readBuf.Write(msg); err != nil {
+			return 0, err
+		}
+	}
 
-		// Encode the current remote nonce, so we can use it to decrypt
-		// the cipher text.
-		var nonceBuf [8]byte
-		binary.BigEndian.PutUint64(nonceBuf[:], c.remoteNonceInt)
+	return c.readBuf.Read(b)
+}
+
+// ReadMsg reads and decrypts the next whole message.  Unlike Read, it
+// doesn't need a buffer big enough for the largest possible message.
+func (c *LNDConn) ReadMsg() ([]byte, error) {
+	// the tag adds 16 bytes to the ciphertext
+	maxLen := uint32(maxMsgSize)
+	if c.MaxMsgSize != 0 && c.MaxMsgSize < maxLen {
+		maxLen = c.MaxMsgSize
+	}
+	if !c.Authed {
+		maxLen = maxHandshakeMsg
+	}
+	if c.noise != nil {
+		msg, err := c.noise.readMsg(c.Conn, maxLen)
+		if err == nil {
+			atomic.AddUint64(&c.bytesIn, uint64(len(msg)+2+2*macSize))
+		}
+		return msg, err
+	}
+	ctext, err := readClear(c.Conn, maxLen+16)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&c.bytesIn, uint64(len(ctext)+4))
 
-		//		log.Printf("decrypt %d byte from %x nonce %d\n",
-		//			len(ctext), c.RemoteLNId, c.remoteNonceInt)
+	// Encode the current remote nonce, so we can use it to decrypt
+	// the cipher text.
+	var nonceBuf [8]byte
+	binary.BigEndian.PutUint64(nonceBuf[:], c.remoteNonceInt)
 
-		c.remoteNonceInt++ // increment remote nonce, no matter what...
+	//		log.Tracef("decrypt %d byte from %x nonce %d\n",
+	//			len(ctext), c.RemoteLNId, c.remoteNonceInt)
 
-		msg, err := c.chachaStream.Open(nil, nonceBuf[:], ctext, nil)
-		if err != nil {
-			log.Printf("decrypt %d byte ciphertext failed\n", len(ctext))
-			return 0, err
-		}
+	c.remoteNonceInt++ // increment remote nonce, no matter what...
 
-		if _, err := c.readBuf.Write(msg); err != nil {
-			return 0, err
-		}
+	msg, err := c.chachaStream.Open(nil, nonceBuf[:], ctext, nil)
+	if err != nil {
+		log.Errorf("decrypt %d byte ciphertext failed\n", len(ctext))
+		return nil, &FramingError{"decrypt failed: " + err.Error()}
 	}
 
-	return c.readBuf.Read(b)
+	return msg, nil
 }
 
 // Write writes data to the connection.
@@ -342,7 +419,12 @@ func (c *LNDConn) Write(b []byte) (n int, err error) {
 	if b == nil {
 		return 0, fmt.Errorf("write to %x nil", c.RemotePub.SerializeCompressed())
 	}
-	//	log.Printf("Encrypt %d byte plaintext to %x nonce %d\n",
+	if c.noise != nil {
+		n, err = c.noise.writeMsg(c.Conn, b)
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+		return n, err
+	}
+	//	log.Tracef("Encrypt %d byte plaintext to %x nonce %d\n",
 	//		len(b), c.RemoteLNId, c.myNonceInt)
 
 	// first encrypt message with shared key
@@ -360,7 +442,26 @@ func (c *LNDConn) Write(b []byte) (n int, err error) {
 	}
 
 	// use writeClear to prepend length / destination header
-	return writeClear(c.Conn, ctext)
+	n, err = writeClear(c.Conn, ctext)
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+	return n, err
+}
+
+// BytesRead is how many bytes have come in on the connection since it was
+// made or the counters were reset.
+func (c *LNDConn) BytesRead() uint64 {
+	return atomic.LoadUint64(&c.bytesIn)
+}
+
+// BytesWritten is how many bytes have gone out on the connection.
+func (c *LNDConn) BytesWritten() uint64 {
+	return atomic.LoadUint64(&c.bytesOut)
+}
+
+// ResetCounters zeroes BytesRead and BytesWritten.
+func (c *LNDConn) ResetCounters() {
+	atomic.StoreUint64(&c.bytesIn, 0)
+	atomic.StoreUint64(&c.bytesOut, 0)
 }
 
 // Close closes the connection.
@@ -3,19 +3,28 @@ package lndc
 import (
 	"crypto/hmac"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"time"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/btcsuite/fastsha256"
 	"github.com/codahale/chacha20poly1305"
 )
 
+// how long a new connection gets to finish the handshake
+const handshakeTimeout = 10 * time.Second
+
 // Listener...
 type Listener struct {
 	longTermPriv *btcec.PrivateKey
 
 	tcp *net.TCPListener
+
+	// AllowConn, if set, is asked about each new TCP connection before
+	// doing the (relatively expensive) handshake.  Returning false drops
+	// the connection.
+	AllowConn func(remote net.Addr) bool
 }
 
 var _ net.Listener = (*Listener)(nil)
@@ -35,24 +44,73 @@ func NewListener(localPriv *btcec.PrivateKey, listenAddr string) (*Listener, err
 		return nil, err
 	}
 
-	return &Listener{localPriv, l}, nil
+	return &Listener{longTermPriv: localPriv, tcp: l}, nil
 }
 
 // Accept waits for and returns the next connection to the listener.
 // Part of the net.Listener interface.
 func (l *Listener) Accept() (c net.Conn, err error) {
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	return l.Handshake(conn)
+}
+
+// AcceptTCP waits for the next TCP connection, without doing the
+// handshake.  Pass it to Handshake, in its own goroutine if one slow peer
+// shouldn't hold up the others.
+func (l *Listener) AcceptTCP() (net.Conn, error) {
 	conn, err := l.tcp.Accept()
 	if err != nil {
 		return nil, err
 	}
 
+	if l.AllowConn != nil && !l.AllowConn(conn.RemoteAddr()) {
+		conn.Close()
+		return nil, fmt.Errorf("dropped connection from %s", conn.RemoteAddr())
+	}
+	return conn, nil
+}
+
+// Handshake does either handshake on a connection from AcceptTCP, giving
+// up after handshakeTimeout.  conn is closed on error.
+func (l *Listener) Handshake(conn net.Conn) (*LNDConn, error) {
+	var err error
 	nLndc := NewConn(conn)
 
+	// don't let a peer that stops mid-handshake hold up the listener
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	// see which handshake they're doing
+	start := make([]byte, 2)
+	if _, err = io.ReadFull(conn, start); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if isNoiseAct1(start) {
+		act1 := make([]byte, noiseAct1Size)
+		copy(act1, start)
+		if _, err = io.ReadFull(conn, act1[2:]); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		err = nLndc.noiseRespond(newHandshakeState(l.longTermPriv), act1)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		return nLndc, nil
+	}
+	nLndc.Conn = &prefixConn{Conn: conn, prefix: start}
+
 	// Exchange an ephemeral public key with the remote connection in order
 	// to establish a confidential connection before we attempt to
 	// authenticated.
 	ephPubBytes, err := l.createCipherConn(nLndc)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
@@ -64,6 +122,8 @@ func (l *Listener) Accept() (c net.Conn, err error) {
 		return nil, err
 	}
 
+	conn.SetDeadline(time.Time{})
+
 	return nLndc, nil
 }
 
@@ -73,7 +133,7 @@ func (l *Listener) createCipherConn(lnConn *LNDConn) ([]byte, error) {
 	var theirEphPubBytes []byte
 
 	// First, read and deserialize their ephemeral public key.
-	theirEphPubBytes, err = readClear(lnConn.Conn)
+	theirEphPubBytes, err = readClear(lnConn.Conn, maxHandshakeMsg)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +163,7 @@ func (l *Listener) createCipherConn(lnConn *LNDConn) ([]byte, error) {
 	lnConn.chachaStream, err = chacha20poly1305.New(sessionKey[:])
 
 	// display private key for debug only
-	log.Printf("made session key %x\n", sessionKey)
+	log.Tracef("made session key %x\n", sessionKey)
 
 	lnConn.remoteNonceInt = 1 << 63
 	lnConn.myNonceInt = 0
@@ -122,11 +182,11 @@ func (l *Listener) authenticateConnection(
 	slice := make([]byte, 73)
 	n, err := lnConn.Conn.Read(slice)
 	if err != nil {
-		log.Printf("Read error: %s\n", err.Error())
+		log.Errorf("Read error: %s\n", err.Error())
 		return err
 	}
 
-	log.Printf("read %d bytes\n", n)
+	log.Tracef("read %d bytes\n", n)
 	authmsg := slice[:n]
 	if len(authmsg) != 53 && len(authmsg) != 45 {
 		return fmt.Errorf("got auth message of %d bytes, "+
@@ -160,7 +220,7 @@ func (l *Listener) authenticateConnection(
 	}
 	idDH :=
 		fastsha256.Sum256(btcec.GenerateSharedSecret(l.longTermPriv, theirPub))
-	log.Printf("made idDH %x\n", idDH)
+	log.Tracef("made idDH %x\n", idDH)
 	myDHproof := fastsha256.Sum256(
 		append(lnConn.RemotePub.SerializeCompressed(), idDH[:]...))
 	theirDHproof := fastsha256.Sum256(
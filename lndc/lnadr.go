@@ -5,7 +5,6 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"log"
 	"net"
 	"strings"
 
@@ -41,20 +40,38 @@ func (l *LNAdr) String() string {
 
 // ParseAdrString splits a string like
 // "ln1yrvw48uc3atg8e2lzs43mh74m39vl785g4ehem@myhost.co:8191 into a separate
-// pkh part and network part, adding the network part if needed
+// pkh part and network part, adding the network part if needed.
+// IPv6 hosts go in brackets, "ln1...@[2001:db8::1]:2448"; a bare IPv6
+// address with no port works too and gets bracketed.
 func SplitAdrString(adr string) (string, string) {
 
-	if !strings.ContainsRune(adr, ':') && strings.ContainsRune(adr, '@') {
-		adr += ":2448"
-	}
-
 	idHost := strings.Split(adr, "@")
 
 	if len(idHost) == 1 {
 		return idHost[0], ""
 	}
 
-	return idHost[0], idHost[1]
+	return idHost[0], addDefaultPort(idHost[1])
+}
+
+// addDefaultPort puts port 2448 on a host with no port, bracketing bare
+// IPv6 addresses.
+func addDefaultPort(host string) string {
+	if host == "" {
+		return host
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	// "[::1]" with no port
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host + ":2448"
+	}
+	// bare IPv6, can't have a port without brackets
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return net.JoinHostPort(host, "2448")
+	}
+	return host + ":2448"
 }
 
 // newLnAddr...
@@ -110,7 +127,7 @@ func LnAddrFromString(encodedAddr string, param *chaincfg.Params) (*LNAdr, error
 	case idLen > 30 && idLen < 39:
 		addr.Base58Adr, err = btcutil.DecodeAddress(idHost[0], param)
 		if err != nil {
-			log.Printf("error from DecodeAddress %s\n", idHost[0])
+			log.Errorf("error from DecodeAddress %s\n", idHost[0])
 			return nil, err
 		}
 	default:
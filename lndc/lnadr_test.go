@@ -0,0 +1,51 @@
+package lndc
+
+import "testing"
+
+const testPKH = "ln1yrvw48uc3atg8e2lzs43mh74m39vl785g4ehem"
+
+// SplitAdrString
+func TestSplitAdrString(t *testing.T) {
+	cases := []struct {
+		in, who, where string
+	}{
+		{testPKH, testPKH, ""},
+		{testPKH + "@1.2.3.4", testPKH, "1.2.3.4:2448"},
+		{testPKH + "@1.2.3.4:8191", testPKH, "1.2.3.4:8191"},
+		{testPKH + "@myhost.co", testPKH, "myhost.co:2448"},
+		{testPKH + "@[2001:db8::1]:8191", testPKH, "[2001:db8::1]:8191"},
+		{testPKH + "@[2001:db8::1]", testPKH, "[2001:db8::1]:2448"},
+		{testPKH + "@2001:db8::1", testPKH, "[2001:db8::1]:2448"},
+	}
+	for _, c := range cases {
+		who, where := SplitAdrString(c.in)
+		if who != c.who || where != c.where {
+			t.Fatalf("%s: got %s %s, expect %s %s", c.in, who, where, c.who, c.where)
+		}
+	}
+}
+
+// parseAdr
+func TestParseAdr(t *testing.T) {
+	cases := []struct {
+		in, adr, mode string
+	}{
+		{"1.2.3.4:2448", "1.2.3.4:2448", "tcp4"},
+		{"[2001:db8::1]:2448", "[2001:db8::1]:2448", "tcp6"},
+		{"2001:db8:0:0:0:0:1:2448", "[2001:db8:0:0:0:0:1]:2448", "tcp6"},
+		{"abcdefg.onion:2448", "abcdefg.onion:2448", "tcp"},
+	}
+	for _, c := range cases {
+		adr, mode, err := parseAdr(c.in)
+		if err != nil {
+			t.Fatalf("%s: %s", c.in, err.Error())
+		}
+		if adr != c.adr || mode != c.mode {
+			t.Fatalf("%s: got %s %s, expect %s %s", c.in, adr, mode, c.adr, c.mode)
+		}
+	}
+	_, _, err := parseAdr("myhost.co:2448")
+	if err == nil {
+		t.Fatalf("hostnames shouldn't parse")
+	}
+}
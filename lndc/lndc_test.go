@@ -98,3 +98,109 @@ func TestConnectionCorrectness(t *testing.T) {
 			string(readBuf), string(outMsg))
 	}
 }
+
+func TestByteCounters(t *testing.T) {
+	localPriv, _ := btcec.NewPrivateKey(btcec.S256())
+	remotePriv, _ := btcec.NewPrivateKey(btcec.S256())
+
+	listener, err := NewListener(localPriv, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create listener: %v", err)
+	}
+	conn := NewConn(nil)
+
+	var wg sync.WaitGroup
+	var dialErr error
+	wg.Add(1)
+	go func() {
+		dialErr = conn.DialNoise(
+			remotePriv, listener.Addr().String(), localPriv.PubKey(), "")
+		wg.Done()
+	}()
+
+	localConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("unable to accept connection: %v", err)
+	}
+	wg.Wait()
+	if dialErr != nil {
+		t.Fatalf("unable to establish connection: %v", dialErr)
+	}
+	lnConn := localConn.(*LNDConn)
+	conn.ResetCounters()
+	lnConn.ResetCounters()
+
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("remote conn failed to write: %v", err)
+		}
+		if _, err := lnConn.ReadMsg(); err != nil {
+			t.Fatalf("local conn failed to read: %v", err)
+		}
+	}
+
+	// both ends count the same bytes
+	if conn.BytesWritten() == 0 || conn.BytesWritten() != lnConn.BytesRead() {
+		t.Fatalf("wrote %d bytes, read %d",
+			conn.BytesWritten(), lnConn.BytesRead())
+	}
+	conn.ResetCounters()
+	if conn.BytesWritten() != 0 {
+		t.Fatalf("counters not reset")
+	}
+}
+
+func TestMaxMsgSize(t *testing.T) {
+	localPriv, _ := btcec.NewPrivateKey(btcec.S256())
+	remotePriv, _ := btcec.NewPrivateKey(btcec.S256())
+
+	listener, err := NewListener(localPriv, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create listener: %v", err)
+	}
+	conn := NewConn(nil)
+	var myPub [33]byte
+	copy(myPub[:], localPriv.PubKey().SerializeCompressed())
+	myAddress := lnutil.LitAdrFromPubkey(myPub)
+
+	var wg sync.WaitGroup
+	var dialErr error
+	wg.Add(1)
+	go func() {
+		dialErr = conn.Dial(remotePriv, listener.Addr().String(), myAddress, "")
+		wg.Done()
+	}()
+
+	netConn, listenErr := listener.Accept()
+	if listenErr != nil {
+		t.Fatalf("unable to accept connection: %v", listenErr)
+	}
+	wg.Wait()
+	if dialErr != nil {
+		t.Fatalf("unable to establish connection: %v", dialErr)
+	}
+	localConn := netConn.(*LNDConn)
+	localConn.MaxMsgSize = 100
+
+	// right at the limit is fine
+	msg := make([]byte, 100)
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("remote conn failed to write: %v", err)
+	}
+	readMsg, err := localConn.ReadMsg()
+	if err != nil {
+		t.Fatalf("local conn failed to read: %v", err)
+	}
+	if !bytes.Equal(readMsg, msg) {
+		t.Fatalf("messages don't match")
+	}
+
+	// one over is a framing error
+	if _, err := conn.Write(make([]byte, 101)); err != nil {
+		t.Fatalf("remote conn failed to write: %v", err)
+	}
+	_, err = localConn.ReadMsg()
+	if _, ok := err.(*FramingError); !ok {
+		t.Fatalf("expected framing error, got %v", err)
+	}
+}
@@ -12,6 +12,21 @@ import (
 // encrypted size.
 const maxMsgSize = 1 << 24
 
+// maxHandshakeMsg is the longest message we'll take before the other side
+// has authenticated; handshake messages are all under 100 bytes.
+const maxHandshakeMsg = 1024
+
+// FramingError is the other side breaking the wire protocol; a message that's
+// too long or doesn't decrypt.  There's no getting back in sync after one, so
+// the connection should be dropped.
+type FramingError struct {
+	msg string
+}
+
+func (e *FramingError) Error() string {
+	return e.msg
+}
+
 // New & improved tcp open session.
 // There's connector A and listener B.  Once the connection is set up there's no
 // difference, but there can be during the setup.
@@ -57,13 +72,19 @@ const maxMsgSize = 1 << 24
 // (they aren't exported).  They're also used in the key agreement phase.
 
 // readClear reads the next length-prefixed message from the underlying raw
-// TCP connection.
-func readClear(c net.Conn) ([]byte, error) {
+// TCP connection.  Messages over maxLen are refused before allocating
+// anything, so a peer can't make us allocate a huge buffer by claiming one
+// is coming.
+func readClear(c net.Conn, maxLen uint32) ([]byte, error) {
 	var msgLen uint32
 
 	if err := binary.Read(c, binary.BigEndian, &msgLen); err != nil {
 		return nil, err
 	}
+	if msgLen > maxLen {
+		return nil, &FramingError{
+			fmt.Sprintf("incoming message %d bytes, max %d", msgLen, maxLen)}
+	}
 
 	msg := make([]byte, msgLen)
 	if _, err := io.ReadFull(c, msg); err != nil {
@@ -0,0 +1,407 @@
+package lndc
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/adiabat/btcd/btcec"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Noise_XK_secp256k1_ChaChaPoly_SHA256, as in BOLT #8.  This is the
+// transport lnd, c-lightning and eclair use.  Lit's own handshake works from
+// a pubkey hash, but XK needs the responder's whole pubkey up front, so
+// noise connections are only dialed when we have that.  Listeners take
+// either; the first bytes on the wire say which one the dialer is using.
+
+const (
+	noiseProtocol = "Noise_XK_secp256k1_ChaChaPoly_SHA256"
+	noisePrologue = "lightning"
+
+	noiseVersion = 0
+
+	noiseAct1Size = 50
+	noiseAct2Size = 50
+	noiseAct3Size = 66
+
+	// keys rotate after this many messages each way
+	noiseRotateInterval = 1000
+
+	// noise messages have a 2 byte length
+	noiseMaxMsgSize = 65535
+
+	macSize = 16
+)
+
+// ecdh is sha256 of the compressed shared point.  (Lit's own handshake uses
+// btcec.GenerateSharedSecret which is just the x coordinate.)
+func ecdh(priv *btcec.PrivateKey, pub *btcec.PublicKey) [32]byte {
+	x, y := btcec.S256().ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+	shared := btcec.PublicKey{Curve: btcec.S256(), X: x, Y: y}
+	return sha256.Sum256(shared.SerializeCompressed())
+}
+
+// hkdf2 derives the two 32 byte outputs BOLT #8 calls for.
+func hkdf2(salt, ikm []byte) (k1, k2 [32]byte) {
+	r := hkdf.New(sha256.New, ikm, salt, nil)
+	io.ReadFull(r, k1[:])
+	io.ReadFull(r, k2[:])
+	return
+}
+
+// cipherState is a key and nonce for one direction.
+type cipherState struct {
+	nonce  uint64
+	key    [32]byte
+	salt   [32]byte // chaining key, only used for rotation
+	cipher cipher.AEAD
+}
+
+func (cs *cipherState) init(key, salt [32]byte) {
+	cs.key = key
+	cs.salt = salt
+	cs.nonce = 0
+	cs.cipher, _ = chacha20poly1305.New(cs.key[:])
+}
+
+func (cs *cipherState) nonceBytes() []byte {
+	var n [12]byte
+	binary.LittleEndian.PutUint64(n[4:], cs.nonce)
+	return n[:]
+}
+
+func (cs *cipherState) encrypt(ad, plaintext []byte) []byte {
+	ctext := cs.cipher.Seal(nil, cs.nonceBytes(), plaintext, ad)
+	cs.next()
+	return ctext
+}
+
+func (cs *cipherState) decrypt(ad, ctext []byte) ([]byte, error) {
+	msg, err := cs.cipher.Open(nil, cs.nonceBytes(), ctext, ad)
+	cs.next()
+	return msg, err
+}
+
+func (cs *cipherState) next() {
+	cs.nonce++
+	if cs.nonce == noiseRotateInterval {
+		salt, key := hkdf2(cs.salt[:], cs.key[:])
+		cs.init(key, salt)
+	}
+}
+
+// handshakeState is the symmetric state during the 3 acts.
+type handshakeState struct {
+	h  [32]byte // handshake hash
+	ck [32]byte // chaining key
+	cs cipherState
+
+	localStatic  *btcec.PrivateKey
+	localEph     *btcec.PrivateKey
+	remoteStatic *btcec.PublicKey
+	remoteEph    *btcec.PublicKey
+
+	// makeEph is how ephemeral keys are made; replaced in tests that
+	// check against the BOLT #8 vectors
+	makeEph func() (*btcec.PrivateKey, error)
+}
+
+func newHandshakeState(local *btcec.PrivateKey) *handshakeState {
+	hs := new(handshakeState)
+	hs.localStatic = local
+	hs.h = sha256.Sum256([]byte(noiseProtocol))
+	hs.ck = hs.h
+	hs.mixHash([]byte(noisePrologue))
+	hs.makeEph = func() (*btcec.PrivateKey, error) {
+		return btcec.NewPrivateKey(btcec.S256())
+	}
+	return hs
+}
+
+func (hs *handshakeState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(hs.h[:])
+	h.Write(data)
+	copy(hs.h[:], h.Sum(nil))
+}
+
+func (hs *handshakeState) mixKey(ikm [32]byte) {
+	var k [32]byte
+	hs.ck, k = hkdf2(hs.ck[:], ikm[:])
+	hs.cs.init(k, hs.ck)
+}
+
+func (hs *handshakeState) encryptAndHash(plaintext []byte) []byte {
+	ctext := hs.cs.encrypt(hs.h[:], plaintext)
+	hs.mixHash(ctext)
+	return ctext
+}
+
+func (hs *handshakeState) decryptAndHash(ctext []byte) ([]byte, error) {
+	msg, err := hs.cs.decrypt(hs.h[:], ctext)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixHash(ctext)
+	return msg, nil
+}
+
+// split makes the transport ciphers once the handshake is done.
+func (hs *handshakeState) split(initiator bool) *noiseMachine {
+	k1, k2 := hkdf2(hs.ck[:], nil)
+	nm := new(noiseMachine)
+	if initiator {
+		nm.send.init(k1, hs.ck)
+		nm.recv.init(k2, hs.ck)
+	} else {
+		nm.send.init(k2, hs.ck)
+		nm.recv.init(k1, hs.ck)
+	}
+	return nm
+}
+
+// act1 is the initiator's first message: e, es
+func (hs *handshakeState) genAct1() ([]byte, error) {
+	var err error
+	hs.mixHash(hs.remoteStatic.SerializeCompressed())
+
+	hs.localEph, err = hs.makeEph()
+	if err != nil {
+		return nil, err
+	}
+	ephPub := hs.localEph.PubKey().SerializeCompressed()
+	hs.mixHash(ephPub)
+	hs.mixKey(ecdh(hs.localEph, hs.remoteStatic))
+	tag := hs.encryptAndHash(nil)
+
+	act := []byte{noiseVersion}
+	act = append(act, ephPub...)
+	return append(act, tag...), nil
+}
+
+func (hs *handshakeState) recvAct1(act []byte) error {
+	var err error
+	hs.mixHash(hs.localStatic.PubKey().SerializeCompressed())
+
+	if len(act) != noiseAct1Size || act[0] != noiseVersion {
+		return fmt.Errorf("bad noise act 1")
+	}
+	hs.remoteEph, err = btcec.ParsePubKey(act[1:34], btcec.S256())
+	if err != nil {
+		return err
+	}
+	hs.mixHash(act[1:34])
+	hs.mixKey(ecdh(hs.localStatic, hs.remoteEph))
+	_, err = hs.decryptAndHash(act[34:])
+	return err
+}
+
+// act2 is the responder's reply: e, ee
+func (hs *handshakeState) genAct2() ([]byte, error) {
+	var err error
+	hs.localEph, err = hs.makeEph()
+	if err != nil {
+		return nil, err
+	}
+	ephPub := hs.localEph.PubKey().SerializeCompressed()
+	hs.mixHash(ephPub)
+	hs.mixKey(ecdh(hs.localEph, hs.remoteEph))
+	tag := hs.encryptAndHash(nil)
+
+	act := []byte{noiseVersion}
+	act = append(act, ephPub...)
+	return append(act, tag...), nil
+}
+
+func (hs *handshakeState) recvAct2(act []byte) error {
+	var err error
+	if len(act) != noiseAct2Size || act[0] != noiseVersion {
+		return fmt.Errorf("bad noise act 2")
+	}
+	hs.remoteEph, err = btcec.ParsePubKey(act[1:34], btcec.S256())
+	if err != nil {
+		return err
+	}
+	hs.mixHash(act[1:34])
+	hs.mixKey(ecdh(hs.localEph, hs.remoteEph))
+	_, err = hs.decryptAndHash(act[34:])
+	return err
+}
+
+// act3 is the initiator revealing who they are: s, se
+func (hs *handshakeState) genAct3() []byte {
+	ctext := hs.encryptAndHash(hs.localStatic.PubKey().SerializeCompressed())
+	hs.mixKey(ecdh(hs.localStatic, hs.remoteEph))
+	tag := hs.encryptAndHash(nil)
+
+	act := []byte{noiseVersion}
+	act = append(act, ctext...)
+	return append(act, tag...)
+}
+
+func (hs *handshakeState) recvAct3(act []byte) error {
+	if len(act) != noiseAct3Size || act[0] != noiseVersion {
+		return fmt.Errorf("bad noise act 3")
+	}
+	pubBytes, err := hs.decryptAndHash(act[1:50])
+	if err != nil {
+		return err
+	}
+	hs.remoteStatic, err = btcec.ParsePubKey(pubBytes, btcec.S256())
+	if err != nil {
+		return err
+	}
+	hs.mixKey(ecdh(hs.localEph, hs.remoteStatic))
+	_, err = hs.decryptAndHash(act[50:])
+	return err
+}
+
+// noiseMachine encrypts and frames messages once the handshake is done.
+// Each message is an encrypted 2 byte length, then the encrypted body.
+type noiseMachine struct {
+	send cipherState
+	recv cipherState
+}
+
+func (nm *noiseMachine) writeMsg(w io.Writer, msg []byte) (int, error) {
+	if len(msg) > noiseMaxMsgSize {
+		return 0, fmt.Errorf("noise message too long, %d bytes", len(msg))
+	}
+	var lenBytes [2]byte
+	binary.BigEndian.PutUint16(lenBytes[:], uint16(len(msg)))
+
+	out := nm.send.encrypt(nil, lenBytes[:])
+	out = append(out, nm.send.encrypt(nil, msg)...)
+	return w.Write(out)
+}
+
+func (nm *noiseMachine) readMsg(r io.Reader, maxLen uint32) ([]byte, error) {
+	var lenCtext [2 + macSize]byte
+	if _, err := io.ReadFull(r, lenCtext[:]); err != nil {
+		return nil, err
+	}
+	lenBytes, err := nm.recv.decrypt(nil, lenCtext[:])
+	if err != nil {
+		return nil, &FramingError{"decrypt length failed: " + err.Error()}
+	}
+	msgLen := uint32(binary.BigEndian.Uint16(lenBytes))
+	if msgLen > maxLen {
+		return nil, &FramingError{
+			fmt.Sprintf("incoming message %d bytes, max %d", msgLen, maxLen)}
+	}
+
+	ctext := make([]byte, msgLen+macSize)
+	if _, err := io.ReadFull(r, ctext); err != nil {
+		return nil, err
+	}
+	msg, err := nm.recv.decrypt(nil, ctext)
+	if err != nil {
+		return nil, &FramingError{"decrypt failed: " + err.Error()}
+	}
+	return msg, nil
+}
+
+// DialNoise connects to a node using the BOLT #8 transport.  Unlike Dial,
+// it needs the remote node's full pubkey.
+func (c *LNDConn) DialNoise(myId *btcec.PrivateKey, netAddress string,
+	remotePub *btcec.PublicKey, proxyURL string) error {
+
+	if myId == nil || remotePub == nil {
+		return fmt.Errorf("LNDConn DialNoise: nil key")
+	}
+	err := c.open(netAddress, proxyURL)
+	if err != nil {
+		return err
+	}
+
+	hs := newHandshakeState(myId)
+	hs.remoteStatic = remotePub
+	return c.noiseInitiate(hs)
+}
+
+// noiseInitiate runs the initiator side of the handshake over c.Conn.
+func (c *LNDConn) noiseInitiate(hs *handshakeState) error {
+	act1, err := hs.genAct1()
+	if err != nil {
+		return err
+	}
+	if _, err = c.Conn.Write(act1); err != nil {
+		return err
+	}
+
+	act2 := make([]byte, noiseAct2Size)
+	if _, err = io.ReadFull(c.Conn, act2); err != nil {
+		return err
+	}
+	if err = hs.recvAct2(act2); err != nil {
+		return err
+	}
+
+	if _, err = c.Conn.Write(hs.genAct3()); err != nil {
+		return err
+	}
+
+	c.noise = hs.split(true)
+	c.RemotePub = hs.remoteStatic
+	c.Authed = true
+	return nil
+}
+
+// noiseRespond runs the responder side of the handshake; act1 has already
+// been read off the connection.
+func (c *LNDConn) noiseRespond(hs *handshakeState, act1 []byte) error {
+	err := hs.recvAct1(act1)
+	if err != nil {
+		return err
+	}
+	act2, err := hs.genAct2()
+	if err != nil {
+		return err
+	}
+	if _, err = c.Conn.Write(act2); err != nil {
+		return err
+	}
+
+	act3 := make([]byte, noiseAct3Size)
+	if _, err = io.ReadFull(c.Conn, act3); err != nil {
+		return err
+	}
+	if err = hs.recvAct3(act3); err != nil {
+		return err
+	}
+
+	c.noise = hs.split(false)
+	c.RemotePub = hs.remoteStatic
+	c.Authed = true
+	return nil
+}
+
+// isNoiseAct1 tells a noise dialer from a lit one by the first two bytes
+// they send.  Act 1 starts with the version and then a compressed pubkey
+// (02 or 03).  Lit's handshake starts with a 4 byte length, which is 33, so
+// 00 00.
+func isNoiseAct1(start []byte) bool {
+	return len(start) >= 2 && start[0] == noiseVersion &&
+		(start[1] == 0x02 || start[1] == 0x03)
+}
+
+// prefixConn is a connection with some bytes already read off it, which are
+// given back first.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (p *prefixConn) Read(b []byte) (int, error) {
+	if len(p.prefix) > 0 {
+		n := copy(b, p.prefix)
+		p.prefix = p.prefix[n:]
+		return n, nil
+	}
+	return p.Conn.Read(b)
+}
@@ -0,0 +1,153 @@
+package lndc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sync"
+	"testing"
+
+	"github.com/adiabat/btcd/btcec"
+)
+
+func privFromByte(b byte) *btcec.PrivateKey {
+	priv, _ := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{b}, 32))
+	return priv
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// handshake and transport test vectors from BOLT #8
+func TestNoiseVectors(t *testing.T) {
+	initiator := newHandshakeState(privFromByte(0x11))
+	initiator.remoteStatic = privFromByte(0x21).PubKey()
+	initiator.makeEph = func() (*btcec.PrivateKey, error) {
+		return privFromByte(0x12), nil
+	}
+	responder := newHandshakeState(privFromByte(0x21))
+	responder.makeEph = func() (*btcec.PrivateKey, error) {
+		return privFromByte(0x22), nil
+	}
+
+	act1, err := initiator.genAct1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(act1, unhex(t, "00036360e856310ce5d294e8be33fc807077dc56ac80d95d9cd4ddbd21325eff73f70df6086551151f58b8afe6c195782c6a")) {
+		t.Fatalf("act 1 %x", act1)
+	}
+	if err = responder.recvAct1(act1); err != nil {
+		t.Fatal(err)
+	}
+
+	act2, err := responder.genAct2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(act2, unhex(t, "0002466d7fcae563e5cb09a0d1870bb580344804617879a14949cf22285f1bae3f276e2470b93aac583c9ef6eafca3f730ae")) {
+		t.Fatalf("act 2 %x", act2)
+	}
+	if err = initiator.recvAct2(act2); err != nil {
+		t.Fatal(err)
+	}
+
+	act3 := initiator.genAct3()
+	if !bytes.Equal(act3, unhex(t, "00b9e3a702e93e3a9948c2ed6e5fd7590a6e1c3a0344cfc9d5b57357049aa22355361aa02e55a8fc28fef5bd6d71ad0c38228dc68b1c466263b47fdf31e560e139ba")) {
+		t.Fatalf("act 3 %x", act3)
+	}
+	if err = responder.recvAct3(act3); err != nil {
+		t.Fatal(err)
+	}
+	if !responder.remoteStatic.IsEqual(privFromByte(0x11).PubKey()) {
+		t.Fatalf("responder got wrong initiator pubkey")
+	}
+
+	send := initiator.split(true)
+	recv := responder.split(false)
+	if !bytes.Equal(send.send.key[:], unhex(t, "969ab31b4d288cedf6218839b27a3e2140827047f2c0f01bf5c04435d43511a9")) {
+		t.Fatalf("sk %x", send.send.key)
+	}
+	if !bytes.Equal(send.recv.key[:], unhex(t, "bb9020b8965f4df047e07f955f3c4b88418984aadc5cdb35096b9ea8fa5c3442")) {
+		t.Fatalf("rk %x", send.recv.key)
+	}
+
+	// messages 0, 1, 500, 501, 1000, 1001 of "hello"; crossing key rotations
+	want := map[int]string{
+		0:    "cf2b30ddf0cf3f80e7c35a6e6730b59fe802473180f396d88a8fb0db8cbcf25d2f214cf9ea1d95",
+		1:    "72887022101f0b6753e0c7de21657d35a4cb2a1f5cde2650528bbc8f837d0f0d7ad833b1a256a1",
+		500:  "178cb9d7387190fa34db9c2d50027d21793c9bc2d40b1e14dcf30ebeeeb220f48364f7a4c68bf8",
+		501:  "1b186c57d44eb6de4c057c49940d79bb838a145cb528d6e8fd26dbe50a60ca2c104b56b60e45bd",
+		1000: "4a2f3cc3b5e78ddb83dcb426d9863d9d9a723b0337c89dd0b005d89f8d3c05c52b76b29b740f09",
+		1001: "2ecd8c8a5629d0d02ab457a0fdd0f7b90a192cd46be5ecb6ca570bfc5e268338b1a16cf4ef2d36",
+	}
+	var buf bytes.Buffer
+	for i := 0; i <= 1001; i++ {
+		buf.Reset()
+		if _, err := send.writeMsg(&buf, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if w, ok := want[i]; ok && !bytes.Equal(buf.Bytes(), unhex(t, w)) {
+			t.Fatalf("message %d %x", i, buf.Bytes())
+		}
+		msg, err := recv.readMsg(&buf, noiseMaxMsgSize)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if string(msg) != "hello" {
+			t.Fatalf("message %d read %q", i, msg)
+		}
+	}
+}
+
+// a listener takes noise connections as well as lit ones
+func TestNoiseConnection(t *testing.T) {
+	localPriv, _ := btcec.NewPrivateKey(btcec.S256())
+	remotePriv, _ := btcec.NewPrivateKey(btcec.S256())
+
+	listener, err := NewListener(localPriv, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create listener: %v", err)
+	}
+	conn := NewConn(nil)
+
+	var wg sync.WaitGroup
+	var dialErr error
+	wg.Add(1)
+	go func() {
+		dialErr = conn.DialNoise(
+			remotePriv, listener.Addr().String(), localPriv.PubKey(), "")
+		wg.Done()
+	}()
+
+	localConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("unable to accept connection: %v", err)
+	}
+	wg.Wait()
+	if dialErr != nil {
+		t.Fatalf("unable to establish connection: %v", dialErr)
+	}
+	lnConn := localConn.(*LNDConn)
+	if !lnConn.RemotePub.IsEqual(remotePriv.PubKey()) {
+		t.Fatalf("listener got wrong remote pubkey")
+	}
+
+	for i := 0; i < 10; i++ {
+		msg := bytes.Repeat([]byte{byte(i)}, i*100)
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatalf("remote conn failed to write: %v", err)
+		}
+		readMsg, err := lnConn.ReadMsg()
+		if err != nil {
+			t.Fatalf("local conn failed to read: %v", err)
+		}
+		if !bytes.Equal(readMsg, msg) {
+			t.Fatalf("message %d doesn't match", i)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package lndc
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyDialer makes a SOCKS5 dialer for proxyURL.  That's host:port, with an
+// optional socks5:// in front, and optionally user:password@ before the host
+// for proxies that need a login.  Without a login in the URL, auth (which can
+// be nil) is sent instead; that's how we get tor to isolate streams.
+func ProxyDialer(proxyURL string, auth *proxy.Auth) (proxy.Dialer, error) {
+	host, urlAuth, err := ParseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if urlAuth != nil {
+		// the proxy wants these, so they win over isolation credentials
+		auth = urlAuth
+	}
+	return proxy.SOCKS5("tcp", host, auth, proxy.Direct)
+}
+
+// ParseProxyURL splits a proxy URL into host:port and credentials.  The
+// credentials are nil if there aren't any.
+func ParseProxyURL(proxyURL string) (string, *proxy.Auth, error) {
+	if !strings.Contains(proxyURL, "://") {
+		proxyURL = "socks5://" + proxyURL
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return "", nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return "", nil, fmt.Errorf("proxy %s: only socks5 proxies supported", u.Host)
+	}
+	if u.Host == "" {
+		return "", nil, fmt.Errorf("proxy URL %s has no host", proxyURL)
+	}
+
+	if u.User == nil {
+		return u.Host, nil, nil
+	}
+	auth := new(proxy.Auth)
+	auth.User = u.User.Username()
+	auth.Password, _ = u.User.Password()
+	return u.Host, auth, nil
+}
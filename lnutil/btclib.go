@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/adiabat/btcd/blockchain"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/txscript"
 	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil"
@@ -27,8 +30,82 @@ type OutPointEvent struct {
 	Op     wire.OutPoint // the outpoint being described
 	Height int32         // the height of the event
 	Tx     *wire.MsgTx   // the tx spending the outpoint
+	// Reorg means the chain went back to Height; anything about the
+	// outpoint confirmed above it isn't anymore.
+	Reorg bool
 }
 
+// HeightEvent is a block the wallet's synced up to.  A lower height than
+// the last one means a reorg back to there.
+type HeightEvent struct {
+	CoinType uint32
+	Height   int32
+	Hash     chainhash.Hash // zero if the chainhook couldn't say
+}
+
+// UtxoLock is a utxo the wallet won't pick for spending, and why.
+type UtxoLock struct {
+	Op     wire.OutPoint
+	Reason string
+}
+
+// address types, for asking for addresses and change outputs
+const (
+	AdrTypeBech32  = "bech32"  // native segwit P2WPKH
+	AdrTypeLegacy  = "legacy"  // base58 P2PKH
+	AdrTypeTaproot = "taproot" // bech32m P2TR, key path only
+)
+
+// ChangeSpec says where a tx's change goes: to Script if that's set, or
+// else to a new address of ours of AdrType (AdrTypeBech32 if empty) in
+// account Acct.
+type ChangeSpec struct {
+	Script  []byte
+	AdrType string
+	Acct    uint32
+}
+
+// TxLabel is a note about a wallet tx, or one of its outputs.  Index is -1
+// for the whole tx.
+type TxLabel struct {
+	Txid  chainhash.Hash
+	Index int32
+	Label string
+}
+
+// WatchAcct is an xpub the wallet watches without having its keys.
+// Fingerprint and Path say where the xpub is in the tree of the wallet that
+// does have them.  NextExt and NextInt are the first unused receive and
+// change indexes.
+type WatchAcct struct {
+	Idx         uint32
+	Xpub        string
+	Fingerprint [4]byte
+	Path        []uint32
+	NextExt     uint32
+	NextInt     uint32
+}
+
+// BroadcastTx is a tx we sent out, and what's become of it.  Pending ones
+// get sent out again now and then.  Height is where it, or the tx it
+// conflicts with, confirmed.
+type BroadcastTx struct {
+	Tx        *wire.MsgTx
+	Status    string
+	Height    int32
+	FirstSent int64 // unix time
+	LastSent  int64
+	Sends     uint32
+}
+
+// broadcast tx statuses
+const (
+	BroadcastPending    = "pending"
+	BroadcastConfirmed  = "confirmed"
+	BroadcastConflicted = "conflicted" // another tx spending its inputs confirmed
+	BroadcastReplaced   = "replaced"   // we sent another tx spending its inputs
+)
+
 // need this because before I was comparing pointers maybe?
 // so they were the same outpoint but stored in 2 places so false negative?
 func OutPointsEqual(a, b wire.OutPoint) bool {
@@ -66,6 +143,25 @@ func OutPointFromBytes(b [36]byte) *wire.OutPoint {
 	return op
 }
 
+// OutPointFromString parses an outpoint written txid;index, the way
+// wire.OutPoint's String() writes it, or txid:index like most everything
+// else writes it.
+func OutPointFromString(s string) (*wire.OutPoint, error) {
+	parts := strings.Split(strings.Replace(s, ";", ":", -1), ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("outpoint %s; expect txid:index", s)
+	}
+	hash, err := chainhash.NewHashFromStr(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	idx, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return wire.NewOutPoint(hash, uint32(idx)), nil
+}
+
 // P2WSHify takes a script and turns it into a 34 byte long P2WSH PkScript
 func P2WSHify(scriptBytes []byte) []byte {
 	bldr := txscript.NewScriptBuilder()
@@ -91,6 +187,7 @@ func DirectWPKHScriptFromPKH(pkh [20]byte) []byte {
 }
 
 // KeyHashFromPkScript extracts the 20 or 32 byte hash from a txout PkScript
+// (or for taproot, the 32 byte output key)
 func KeyHashFromPkScript(pkscript []byte) []byte {
 	// match p2pkh
 	if len(pkscript) == 25 && pkscript[0] == 0x76 && pkscript[1] == 0xa9 &&
@@ -108,6 +205,11 @@ func KeyHashFromPkScript(pkscript []byte) []byte {
 		return pkscript[2:]
 	}
 
+	// match p2tr; the 32 byte output key
+	if len(pkscript) == 34 && pkscript[0] == 0x51 && pkscript[1] == 0x20 {
+		return pkscript[2:]
+	}
+
 	return nil
 }
 
@@ -118,6 +220,16 @@ func PayToPubKeyHashScript(pubKeyHash []byte) ([]byte, error) {
 		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
 }
 
+// AdrScripts gives the pkScripts a pubkey hash can show up as: p2wpkh and
+// p2pkh.
+func AdrScripts(pkh [20]byte) ([][]byte, error) {
+	pkhScript, err := PayToPubKeyHashScript(pkh[:])
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{DirectWPKHScriptFromPKH(pkh), pkhScript}, nil
+}
+
 // TxToString prints out some info about a transaction. for testing / debugging
 func TxToString(tx *wire.MsgTx) string {
 	utx := btcutil.NewTx(tx)
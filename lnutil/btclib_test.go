@@ -192,6 +192,40 @@ func TestOutPointFromBytes(t *testing.T) {
 	// TODO: one more test case
 }
 
+// OutPointFromString
+// should read back what OutPoint's String() writes, and reject junk
+func TestOutPointFromString(t *testing.T) {
+	var hash chainhash.Hash
+	hash[0] = 0xab
+	hash[31] = 0x01
+	op := wire.NewOutPoint(&hash, 3)
+
+	op2, err := OutPointFromString(op.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !OutPointsEqual(*op, *op2) {
+		t.Fatalf("got %s, want %s", op2.String(), op.String())
+	}
+	op2, err = OutPointFromString(hash.String() + ":3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !OutPointsEqual(*op, *op2) {
+		t.Fatalf("got %s, want %s", op2.String(), op.String())
+	}
+
+	for _, bad := range []string{
+		"", hash.String(), hash.String() + ":x", "zz:1",
+		hash.String() + ":1:2", hash.String() + ";1:2",
+		hash.String() + ":4294967296"} {
+		_, err = OutPointFromString(bad)
+		if err == nil {
+			t.Fatalf("parsed bad outpoint %q", bad)
+		}
+	}
+}
+
 // P2WSHify
 // test some simple script bytes
 func TestP2WSHify(t *testing.T) {
@@ -0,0 +1,61 @@
+package lnutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// CompressThreshold is the smallest message worth compressing.  Below this
+// the savings are a few bytes and not worth holding up a small message.
+const CompressThreshold = 1024
+
+// maxDecompressed caps how big a compressed message can get when the
+// caller doesn't say, so a small message can't blow up into gigabytes.
+// Same as the lndc max.
+const maxDecompressed = 1 << 24
+
+// CompressMsg wraps a serialized message in a MSGID_COMPRESSED message.
+// If that doesn't make it any smaller, the message is returned as is.
+func CompressMsg(b []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(MSGID_COMPRESSED)
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return b
+	}
+	w.Write(b)
+	w.Close()
+	if buf.Len() >= len(b) {
+		return b
+	}
+	return buf.Bytes()
+}
+
+// DecompressMsg gets the message out of a MSGID_COMPRESSED message,
+// refusing one that comes out over maxLen bytes; the same limit as for an
+// uncompressed message.  0 is the protocol max.
+func DecompressMsg(b []byte, maxLen uint32) ([]byte, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("got %d bytes, expect at least 2", len(b))
+	}
+	max := int64(maxDecompressed)
+	if maxLen != 0 && int64(maxLen) < max {
+		max = int64(maxLen)
+	}
+	r := flate.NewReader(bytes.NewReader(b[1:]))
+	defer r.Close()
+	inner, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(inner)) > max {
+		return nil, fmt.Errorf("compressed message over %d bytes", max)
+	}
+	if len(inner) > 0 && inner[0] == MSGID_COMPRESSED {
+		return nil, fmt.Errorf("compressed message inside compressed message")
+	}
+	return inner, nil
+}
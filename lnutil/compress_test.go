@@ -0,0 +1,65 @@
+package lnutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	// compressible chat
+	msg := NewChatMsg(peerid, string(bytes.Repeat([]byte("lit "), 1000)))
+	b := CompressMsg(msg.Bytes())
+	if b[0] != MSGID_COMPRESSED || len(b) >= len(msg.Bytes()) {
+		t.Fatalf("message didn't compress")
+	}
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("decompress mismatch")
+	}
+
+	// random bytes don't compress and are left alone
+	junk := make([]byte, 2000)
+	rand.Read(junk)
+	msg = NewChatMsg(peerid, string(junk))
+	if !bytes.Equal(CompressMsg(msg.Bytes()), msg.Bytes()) {
+		t.Fatalf("incompressible message changed")
+	}
+
+	// compressed inside compressed is refused
+	inner := CompressMsg(
+		NewChatMsg(peerid, string(bytes.Repeat([]byte("ln "), 5000))).Bytes())
+	var buf bytes.Buffer
+	buf.WriteByte(MSGID_COMPRESSED)
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(inner)
+	w.Close()
+	_, err = LitMsgFromBytes(buf.Bytes(), peerid)
+	if err == nil {
+		t.Fatalf("should have errored on nested compression")
+	}
+}
+
+func TestDecompressMsgMaxLen(t *testing.T) {
+	msg := NewChatMsg(1, string(bytes.Repeat([]byte("ln "), 5000)))
+	b := CompressMsg(msg.Bytes())
+
+	_, err := DecompressMsg(b, uint32(len(msg.Bytes())-1))
+	if err == nil {
+		t.Fatalf("decompressed past the max")
+	}
+	inner, err := DecompressMsg(b, uint32(len(msg.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(inner, msg.Bytes()) {
+		t.Fatalf("decompress mismatch")
+	}
+}
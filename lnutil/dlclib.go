@@ -6,12 +6,16 @@ import (
 	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
 	"math/big"
+	"time"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/txscript"
 
 	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil/txsort"
 )
 
 // DlcContractStatus is an enumeration containing the various statuses a
@@ -28,11 +32,16 @@ const (
 	ContractStatusActive       DlcContractStatus = 6
 	ContractStatusSettling     DlcContractStatus = 7
 	ContractStatusClosed       DlcContractStatus = 8
+	ContractStatusRefunded     DlcContractStatus = 9
 )
 
 // scalarSize is the size of an encoded big endian scalar.
 const scalarSize = 32
 
+// DlcRefundDelay is how long after the settlement time, in seconds, either
+// side can take its funding back if the oracle hasn't published
+const DlcRefundDelay = 7 * 24 * 60 * 60
+
 // DlcContract is a struct containing all elements to work with a Discreet
 // Log Contract. This struct is stored in the database
 type DlcContract struct {
@@ -72,6 +81,12 @@ type DlcContract struct {
 	// The outpoint of the funding TX we want to spend in the settlement
 	// for easier monitoring
 	FundingOutpoint wire.OutPoint
+	// Signature from the counter party for the refund transaction. Empty if
+	// they didn't send one
+	TheirRefundSignature [64]byte
+	// Whether we fund the contract even if the counter party doesn't sign a
+	// refund. Without one, the funding is stuck if the oracle never publishes
+	AllowNoRefund bool
 }
 
 // DlcContractDivision describes a single division of the contract. If the
@@ -230,6 +245,14 @@ func DlcContractFromBytes(b []byte) (*DlcContract, error) {
 	copy(op[:], buf.Next(36))
 	c.FundingOutpoint = *OutPointFromBytes(op)
 
+	// contracts saved before refunds existed stop here
+	if buf.Len() >= 64 {
+		copy(c.TheirRefundSignature[:], buf.Next(64))
+	}
+	if buf.Len() >= 1 {
+		c.AllowNoRefund = buf.Next(1)[0] == 1
+	}
+
 	return c, nil
 }
 
@@ -297,6 +320,14 @@ func (self *DlcContract) Bytes() []byte {
 	opArr := OutPointToBytes(self.FundingOutpoint)
 	buf.Write(opArr[:])
 
+	buf.Write(self.TheirRefundSignature[:])
+
+	if self.AllowNoRefund {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+
 	return buf.Bytes()
 }
 
@@ -507,3 +538,70 @@ func SettlementTx(c *DlcContract, d DlcContractDivision,
 
 	return tx, nil
 }
+
+// RefundLockTime returns the time from which the contract's refund
+// transaction can be published
+func (c DlcContract) RefundLockTime() uint32 {
+	return uint32(c.OracleTimestamp + DlcRefundDelay)
+}
+
+// CheckRefundLockTime says why the contract's refund can't be signed, if it
+// can't: the RefundLockTime has to be a time rather than a block height,
+// fit in the locktime, and still be ahead of now. Otherwise the refund
+// either can be sent straight away or never.
+func (c DlcContract) CheckRefundLockTime(now time.Time) error {
+	if c.OracleTimestamp > math.MaxUint32-DlcRefundDelay {
+		return fmt.Errorf("settlement time %d is too late for a refund "+
+			"locktime", c.OracleTimestamp)
+	}
+	lockTime := c.RefundLockTime()
+	if lockTime < txscript.LockTimeThreshold {
+		return fmt.Errorf("refund locktime %d would be a block height, "+
+			"settlement time %d isn't a unix time", lockTime, c.OracleTimestamp)
+	}
+	if int64(lockTime) <= now.Unix() {
+		return fmt.Errorf("refund locktime %d has already passed",
+			lockTime)
+	}
+	return nil
+}
+
+// DlcRefundTx returns the transaction paying both sides their funding back
+// to their change addresses, for when the oracle never publishes. It's
+// locked till RefundLockTime, and both sides build the same transaction, so
+// the signatures swapped for it work either way.
+func DlcRefundTx(c *DlcContract) *wire.MsgTx {
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	tx.LockTime = c.RefundLockTime()
+
+	in := wire.NewTxIn(&c.FundingOutpoint, nil, nil)
+	// the locktime only counts if the input isn't final
+	in.Sequence = wire.MaxTxInSequenceNum - 1
+	tx.AddTxIn(in)
+
+	totalFee := int64(1000) // TODO: Calculate
+	// Split the fee, unless one side hasn't got its half. Then it pays what
+	// it put in and the other side pays the rest
+	feeOurs := totalFee / 2
+	if c.OurFundingAmount < feeOurs {
+		feeOurs = c.OurFundingAmount
+	}
+	feeTheirs := totalFee - feeOurs
+	if c.TheirFundingAmount < feeTheirs {
+		feeTheirs = c.TheirFundingAmount
+		feeOurs = totalFee - feeTheirs
+	}
+
+	if c.OurFundingAmount-feeOurs > 0 {
+		tx.AddTxOut(wire.NewTxOut(c.OurFundingAmount-feeOurs,
+			DirectWPKHScriptFromPKH(c.OurChangePKH)))
+	}
+	if c.TheirFundingAmount-feeTheirs > 0 {
+		tx.AddTxOut(wire.NewTxOut(c.TheirFundingAmount-feeTheirs,
+			DirectWPKHScriptFromPKH(c.TheirChangePKH)))
+	}
+
+	txsort.InPlaceSort(tx)
+	return tx
+}
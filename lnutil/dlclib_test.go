@@ -0,0 +1,151 @@
+package lnutil
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adiabat/btcd/wire"
+)
+
+// mirror gives the contract as the other side has it
+func mirror(c *DlcContract) *DlcContract {
+	m := *c
+	m.OurFundingAmount, m.TheirFundingAmount =
+		c.TheirFundingAmount, c.OurFundingAmount
+	m.OurChangePKH, m.TheirChangePKH = c.TheirChangePKH, c.OurChangePKH
+	return &m
+}
+
+func TestDlcRefundTx(t *testing.T) {
+	c := new(DlcContract)
+	c.OracleTimestamp = 1600000000
+	c.FundingOutpoint = wire.OutPoint{Index: 0}
+	c.FundingOutpoint.Hash[0] = 0x77
+	c.OurChangePKH[0] = 0x01
+	c.TheirChangePKH[0] = 0x02
+
+	tests := []struct {
+		ours, theirs       int64
+		outOurs, outTheirs int64
+	}{
+		{100000, 50000, 99500, 49500},
+		{100000, 300, 99300, 0},
+		{100000, 0, 99000, 0},
+	}
+
+	for _, tt := range tests {
+		c.OurFundingAmount = tt.ours
+		c.TheirFundingAmount = tt.theirs
+
+		tx := DlcRefundTx(c)
+		if tx.LockTime != 1600000000+DlcRefundDelay {
+			t.Fatalf("locktime %d", tx.LockTime)
+		}
+		if tx.TxIn[0].Sequence == wire.MaxTxInSequenceNum {
+			t.Fatalf("input is final, locktime won't count")
+		}
+
+		got := make(map[string]int64)
+		for _, out := range tx.TxOut {
+			got[string(out.PkScript)] = out.Value
+		}
+		if got[string(DirectWPKHScriptFromPKH(c.OurChangePKH))] != tt.outOurs ||
+			got[string(DirectWPKHScriptFromPKH(c.TheirChangePKH))] != tt.outTheirs {
+			t.Fatalf("funded %d/%d, refund outputs %v", tt.ours, tt.theirs, got)
+		}
+
+		// the peer has to build the same tx for the sigs to work
+		if tx.TxHash() != DlcRefundTx(mirror(c)).TxHash() {
+			t.Fatalf("funded %d/%d, sides build different refunds",
+				tt.ours, tt.theirs)
+		}
+	}
+}
+
+func TestDlcContractRefundSig(t *testing.T) {
+	c := new(DlcContract)
+	c.Idx = 3
+	c.TheirRefundSignature[5] = 0xaa
+	c.AllowNoRefund = true
+
+	b := c.Bytes()
+	c2, err := DlcContractFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.TheirRefundSignature != c.TheirRefundSignature || !c2.AllowNoRefund {
+		t.Fatalf("refund sig %x came back %x %v",
+			c.TheirRefundSignature, c2.TheirRefundSignature, c2.AllowNoRefund)
+	}
+
+	// contracts saved before refunds don't have one
+	c3, err := DlcContractFromBytes(b[:len(b)-65])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c3.Idx != 3 || c3.TheirRefundSignature != [64]byte{} || c3.AllowNoRefund {
+		t.Fatalf("old contract came back %d %x %v",
+			c3.Idx, c3.TheirRefundSignature, c3.AllowNoRefund)
+	}
+}
+
+func TestDlcCheckRefundLockTime(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		timestamp uint64
+		ok        bool
+	}{
+		{1600000000, true},
+		{1600000000 - DlcRefundDelay + 1, true},
+		// refund could go out right away
+		{1600000000 - DlcRefundDelay, false},
+		// a block height, not a time
+		{700000, false},
+		// the latest that fits, but well past the threshold
+		{math.MaxUint32 - DlcRefundDelay, true},
+		// wraps around to a small locktime
+		{math.MaxUint32 - DlcRefundDelay + 1, false},
+		{1 << 40, false},
+	}
+
+	for _, tt := range tests {
+		c := DlcContract{OracleTimestamp: tt.timestamp}
+		err := c.CheckRefundLockTime(now)
+		if (err == nil) != tt.ok {
+			t.Fatalf("settlement time %d: got err %v", tt.timestamp, err)
+		}
+	}
+}
+
+func TestDlcContractAckMsgRefundSig(t *testing.T) {
+	c := new(DlcContract)
+	c.TheirIdx = 9
+	var sig [64]byte
+	sig[0] = 0x55
+	sigs := []DlcContractSettlementSignature{{Outcome: 7}}
+
+	msg := NewDlcContractAckMsg(c, sigs, sig)
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, msg2.Bytes()) ||
+		msg2.(DlcContractAckMsg).RefundSignature != sig {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", b, msg2.Bytes())
+	}
+
+	// older nodes don't send a refund sig
+	msg3, err := LitMsgFromBytes(b[:len(b)-64], 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg3.(DlcContractAckMsg).RefundSignature != [64]byte{} ||
+		len(msg3.(DlcContractAckMsg).SettlementSignatures) != 1 {
+		t.Fatalf("old ack came back %x", msg3.Bytes())
+	}
+}
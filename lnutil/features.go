@@ -0,0 +1,95 @@
+package lnutil
+
+import "fmt"
+
+// ProtocolVersion is the version of the peer protocol we speak, sent in
+// the init message.  Peers older than MinProtocolVersion get disconnected.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// FeatureBits are the features a node has.  Each feature has a pair of
+// bits, as in BOLT #9: the even one means the node needs the other side to
+// have it, the odd one that it's optional.  A peer that needs a feature we
+// don't know about can't talk to us; one that merely has it can.
+type FeatureBits uint64
+
+const (
+	FeatureGossipRequired   = 0 // link advertisements for routing
+	FeatureGossipOptional   = 1
+	FeatureHTLCRequired     = 2 // hash time locked contracts, for swaps and payments
+	FeatureHTLCOptional     = 3
+	FeatureTaprootRequired  = 4 // taproot channels
+	FeatureTaprootOptional  = 5
+	FeatureCompressRequired = 6 // deflate big bulk messages
+	FeatureCompressOptional = 7
+	FeatureChatAckRequired  = 8 // chat with ids, acked
+	FeatureChatAckOptional  = 9
+	FeatureAliasRequired    = 10 // nodes announce their own aliases
+	FeatureAliasOptional    = 11
+	FeaturePayReqRequired   = 12 // payment requests
+	FeaturePayReqOptional   = 13
+	FeaturePingRequired     = 14 // keepalive pings
+	FeaturePingOptional     = 15
+	FeatureNodeAddrRequired = 16 // peers say where they've moved to
+	FeatureNodeAddrOptional = 17
+	FeatureTowerFeeRequired = 18 // paid towers: terms, blobs and acks
+	FeatureTowerFeeOptional = 19
+	FeatureCustomRequired   = 20 // apps' own messages
+	FeatureCustomOptional   = 21
+)
+
+// KnownFeatures are the features this code understands, whether or not it
+// turns them on.
+const KnownFeatures = FeatureBits(1<<FeatureGossipRequired |
+	1<<FeatureGossipOptional |
+	1<<FeatureHTLCRequired | 1<<FeatureHTLCOptional |
+	1<<FeatureCompressRequired | 1<<FeatureCompressOptional |
+	1<<FeatureChatAckRequired | 1<<FeatureChatAckOptional |
+	1<<FeatureAliasRequired | 1<<FeatureAliasOptional |
+	1<<FeaturePayReqRequired | 1<<FeaturePayReqOptional |
+	1<<FeaturePingRequired | 1<<FeaturePingOptional |
+	1<<FeatureNodeAddrRequired | 1<<FeatureNodeAddrOptional |
+	1<<FeatureTowerFeeRequired | 1<<FeatureTowerFeeOptional |
+	1<<FeatureCustomRequired | 1<<FeatureCustomOptional)
+
+// DefaultFeatures are the features a node has unless told otherwise.
+const DefaultFeatures = FeatureBits(1<<FeatureGossipOptional |
+	1<<FeatureHTLCOptional | 1<<FeatureCompressOptional |
+	1<<FeatureChatAckOptional | 1<<FeatureAliasOptional |
+	1<<FeaturePayReqOptional | 1<<FeaturePingOptional |
+	1<<FeatureNodeAddrOptional | 1<<FeatureTowerFeeOptional |
+	1<<FeatureCustomOptional)
+
+// Set turns a feature bit on.
+func (f FeatureBits) Set(bit uint) FeatureBits {
+	return f | 1<<bit
+}
+
+// Has says whether a node has the feature the bit is for, required or
+// optional.
+func (f FeatureBits) Has(bit uint) bool {
+	pair := FeatureBits(3) << (bit &^ 1)
+	return f&pair != 0
+}
+
+// NegotiateFeatures works out what features are on for a connection; the
+// ones both sides have.  It errors if the remote requires something we
+// don't know.
+func NegotiateFeatures(local, remote FeatureBits) (FeatureBits, error) {
+	var both FeatureBits
+	for bit := uint(0); bit < 64; bit += 2 {
+		if remote&(1<<bit) != 0 && KnownFeatures&(1<<bit) == 0 {
+			return 0, fmt.Errorf("peer requires unknown feature %d", bit)
+		}
+		if local.Has(bit) && remote.Has(bit) {
+			both = both.Set(bit + 1)
+		} else if local&(1<<bit) != 0 {
+			return 0, fmt.Errorf("peer doesn't have required feature %d", bit)
+		} else if remote&(1<<bit) != 0 {
+			return 0, fmt.Errorf("peer requires feature %d, which is off", bit)
+		}
+	}
+	return both, nil
+}
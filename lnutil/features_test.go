@@ -0,0 +1,48 @@
+package lnutil
+
+import "testing"
+
+func TestNegotiateFeatures(t *testing.T) {
+	// optional on both sides is on
+	f, err := NegotiateFeatures(DefaultFeatures, DefaultFeatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Has(FeatureGossipOptional) {
+		t.Fatalf("gossip should be on")
+	}
+
+	// optional on one side is off, but ok
+	f, err = NegotiateFeatures(DefaultFeatures, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Has(FeatureGossipOptional) {
+		t.Fatalf("gossip should be off")
+	}
+
+	// required and known works if they have it
+	local := FeatureBits(0).Set(FeatureGossipRequired)
+	_, err = NegotiateFeatures(local, DefaultFeatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// but not if they don't
+	_, err = NegotiateFeatures(local, 0)
+	if err == nil {
+		t.Fatalf("should have errored on missing required feature")
+	}
+
+	// they require something we don't know
+	_, err = NegotiateFeatures(DefaultFeatures,
+		FeatureBits(0).Set(FeatureTaprootRequired))
+	if err == nil {
+		t.Fatalf("should have errored on unknown required feature")
+	}
+	// it's ok to be odd
+	_, err = NegotiateFeatures(DefaultFeatures,
+		FeatureBits(0).Set(FeatureTaprootOptional))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
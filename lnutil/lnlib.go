@@ -41,6 +41,97 @@ func CommitScript(RKey, TKey [33]byte, delay uint16) []byte {
 	return s
 }
 
+// HTLCScript is the script for a hash time locked output in a commitment tx.
+// Like the CommitScript, the revocable key can always take it.  Otherwise
+// the receiver takes it with the preimage of rHash, or the offerer after
+// the locktime height.  Whichever of those is the tx owner also waits out
+// the CSV delay.  OKey is the other side's key; offered says if the tx owner
+// offered the HTLC.
+//
+// Stack for the preimage path is [sig, preimage, 1, 0], for the timeout
+// path [sig, 0, 0], and for revocation [sig, 1].
+func HTLCScript(RKey, TKey, OKey [33]byte, rHash [32]byte,
+	locktime uint32, delay uint16, offered bool) []byte {
+
+	builder := txscript.NewScriptBuilder()
+
+	// 1 for revoked, 0 for the other paths
+	builder.AddOp(txscript.OP_IF)
+	builder.AddData(RKey[:])
+	builder.AddOp(txscript.OP_ELSE)
+
+	// 1 for the preimage, 0 for timeout
+	builder.AddOp(txscript.OP_IF)
+	builder.AddOp(txscript.OP_SIZE)
+	builder.AddInt64(32)
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddOp(txscript.OP_SHA256)
+	builder.AddData(rHash[:])
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	if offered {
+		// they received it, so they can take it right away
+		builder.AddData(OKey[:])
+	} else {
+		builder.AddInt64(int64(delay))
+		builder.AddOp(txscript.OP_NOP3) // really OP_CHECKSEQUENCEVERIFY
+		builder.AddOp(txscript.OP_DROP)
+		builder.AddData(TKey[:])
+	}
+	builder.AddOp(txscript.OP_ELSE)
+
+	builder.AddInt64(int64(locktime))
+	builder.AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
+	builder.AddOp(txscript.OP_DROP)
+	if offered {
+		builder.AddInt64(int64(delay))
+		builder.AddOp(txscript.OP_NOP3) // really OP_CHECKSEQUENCEVERIFY
+		builder.AddOp(txscript.OP_DROP)
+		builder.AddData(TKey[:])
+	} else {
+		builder.AddData(OKey[:])
+	}
+	builder.AddOp(txscript.OP_ENDIF)
+
+	builder.AddOp(txscript.OP_ENDIF)
+
+	builder.AddOp(txscript.OP_CHECKSIG)
+
+	s, _ := builder.Script()
+	return s
+}
+
+// SubSwapScript is the script for the on-chain side of a submarine swap.
+// The claim key takes it with the preimage of rHash, and the refund key
+// after the locktime height.
+//
+// Stack for the claim is [sig, preimage, 1], for the refund [sig, 0].
+func SubSwapScript(claimKey, refundKey [33]byte, rHash [32]byte,
+	locktime uint32) []byte {
+
+	builder := txscript.NewScriptBuilder()
+
+	// 1 for the preimage, 0 for the refund
+	builder.AddOp(txscript.OP_IF)
+	builder.AddOp(txscript.OP_SIZE)
+	builder.AddInt64(32)
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddOp(txscript.OP_SHA256)
+	builder.AddData(rHash[:])
+	builder.AddOp(txscript.OP_EQUALVERIFY)
+	builder.AddData(claimKey[:])
+	builder.AddOp(txscript.OP_ELSE)
+	builder.AddInt64(int64(locktime))
+	builder.AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
+	builder.AddOp(txscript.OP_DROP)
+	builder.AddData(refundKey[:])
+	builder.AddOp(txscript.OP_ENDIF)
+
+	builder.AddOp(txscript.OP_CHECKSIG)
+
+	s, _ := builder.Script()
+	return s
+}
+
 // FundMultiPre generates the non-p2sh'd multisig script for 2 of 2 pubkeys.
 // useful for making transactions spending the fundtx.
 // returns a bool which is true if swapping occurs.
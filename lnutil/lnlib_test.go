@@ -2,7 +2,13 @@ package lnutil
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"testing"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
 )
 
 var (
@@ -58,6 +64,153 @@ func TestScriptInCommitScript(t *testing.T) {
 	}
 }
 
+// spendHTLC runs the script engine on a tx spending an HTLC output, signed
+// with priv and with the given stack items before the script.
+func spendHTLC(script []byte, priv *btcec.PrivateKey,
+	items [][]byte, locktime, seq uint32) error {
+	const amt = 100000
+	pkScript := P2WSHify(script)
+
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	tx.LockTime = locktime
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+	tx.TxIn[0].Sequence = seq
+	tx.AddTxOut(wire.NewTxOut(amt-1000, pkScript))
+
+	hCache := txscript.NewTxSigHashes(tx)
+	sig, err := txscript.RawTxInWitnessSignature(
+		tx, hCache, 0, amt, script, txscript.SigHashAll, priv)
+	if err != nil {
+		return err
+	}
+	tx.TxIn[0].Witness = append([][]byte{sig}, items...)
+	tx.TxIn[0].Witness = append(tx.TxIn[0].Witness, script)
+
+	flags := txscript.ScriptBip16 | txscript.ScriptVerifyWitness |
+		txscript.ScriptVerifyCheckLockTimeVerify
+	vm, err := txscript.NewEngine(pkScript, tx, 0, flags, nil, hCache, amt)
+	if err != nil {
+		return err
+	}
+	return vm.Execute()
+}
+
+// HTLCScript
+func TestHTLCScriptPaths(t *testing.T) {
+	revPriv, _ := btcec.NewPrivateKey(btcec.S256())
+	timePriv, _ := btcec.NewPrivateKey(btcec.S256())
+	otherPriv, _ := btcec.NewPrivateKey(btcec.S256())
+	var revPub, timePub, otherPub [33]byte
+	copy(revPub[:], revPriv.PubKey().SerializeCompressed())
+	copy(timePub[:], timePriv.PubKey().SerializeCompressed())
+	copy(otherPub[:], otherPriv.PubKey().SerializeCompressed())
+
+	r := []byte("an htlc preimage, 32 bytes long.")
+	rHash := sha256.Sum256(r)
+	var locktime uint32 = 500
+	var delay uint16 = 5
+
+	offered := HTLCScript(revPub, timePub, otherPub, rHash, locktime, delay, true)
+	received := HTLCScript(revPub, timePub, otherPub, rHash, locktime, delay, false)
+
+	preimage := [][]byte{r, {0x01}, nil}
+	timeout := [][]byte{nil, nil}
+	revoke := [][]byte{{0x01}}
+
+	for _, script := range [][]byte{offered, received} {
+		err := spendHTLC(script, revPriv, revoke, 0, 0)
+		if err != nil {
+			t.Fatalf("revoke path: %s", err.Error())
+		}
+		err = spendHTLC(script, otherPriv, revoke, 0, 0)
+		if err == nil {
+			t.Fatalf("revoke path with wrong key should fail")
+		}
+	}
+
+	// offered: they take it with the preimage, we time out
+	err := spendHTLC(offered, otherPriv, preimage, 0, 0)
+	if err != nil {
+		t.Fatalf("offered preimage path: %s", err.Error())
+	}
+	err = spendHTLC(offered, otherPriv,
+		[][]byte{bytes.Repeat([]byte{0x01}, 32), {0x01}, nil}, 0, 0)
+	if err == nil {
+		t.Fatalf("offered preimage path with wrong preimage should fail")
+	}
+	err = spendHTLC(offered, timePriv, timeout, locktime, uint32(delay))
+	if err != nil {
+		t.Fatalf("offered timeout path: %s", err.Error())
+	}
+	err = spendHTLC(offered, timePriv, timeout, locktime-1, uint32(delay))
+	if err == nil {
+		t.Fatalf("offered timeout path before locktime should fail")
+	}
+
+	// received: we take it with the preimage, they time out
+	err = spendHTLC(received, timePriv, preimage, 0, uint32(delay))
+	if err != nil {
+		t.Fatalf("received preimage path: %s", err.Error())
+	}
+	err = spendHTLC(received, otherPriv, preimage, 0, 0)
+	if err == nil {
+		t.Fatalf("received preimage path with their key should fail")
+	}
+	err = spendHTLC(received, otherPriv, timeout, locktime, 0)
+	if err != nil {
+		t.Fatalf("received timeout path: %s", err.Error())
+	}
+	err = spendHTLC(received, otherPriv, timeout, locktime-1, 0)
+	if err == nil {
+		t.Fatalf("received timeout path before locktime should fail")
+	}
+}
+
+// SubSwapScript
+func TestSubSwapScriptPaths(t *testing.T) {
+	claimPriv, _ := btcec.NewPrivateKey(btcec.S256())
+	refundPriv, _ := btcec.NewPrivateKey(btcec.S256())
+	var claimPub, refundPub [33]byte
+	copy(claimPub[:], claimPriv.PubKey().SerializeCompressed())
+	copy(refundPub[:], refundPriv.PubKey().SerializeCompressed())
+
+	r := []byte("a swap's preimage, 32 bytes long")
+	rHash := sha256.Sum256(r)
+	var locktime uint32 = 500
+
+	script := SubSwapScript(claimPub, refundPub, rHash, locktime)
+
+	claim := [][]byte{r, {0x01}}
+	refund := [][]byte{nil}
+
+	err := spendHTLC(script, claimPriv, claim, 0, 0)
+	if err != nil {
+		t.Fatalf("claim path: %s", err.Error())
+	}
+	err = spendHTLC(script, refundPriv, claim, 0, 0)
+	if err == nil {
+		t.Fatalf("claim path with the refund key should fail")
+	}
+	err = spendHTLC(script, claimPriv,
+		[][]byte{bytes.Repeat([]byte{0x01}, 32), {0x01}}, 0, 0)
+	if err == nil {
+		t.Fatalf("claim path with wrong preimage should fail")
+	}
+	err = spendHTLC(script, refundPriv, refund, locktime, 0)
+	if err != nil {
+		t.Fatalf("refund path: %s", err.Error())
+	}
+	err = spendHTLC(script, refundPriv, refund, locktime-1, 0)
+	if err == nil {
+		t.Fatalf("refund path before locktime should fail")
+	}
+	err = spendHTLC(script, claimPriv, refund, locktime, 0)
+	if err == nil {
+		t.Fatalf("refund path with the claim key should fail")
+	}
+}
+
 // FundTxScript
 func TestFundTxScript(t *testing.T) {
 	// test for a normal situation
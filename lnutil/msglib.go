@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
@@ -12,7 +14,15 @@ import (
 
 //id numbers for messages, semi-arbitrary
 const (
-	MSGID_TEXTCHAT = 0x00 // send a text message
+	MSGID_TEXTCHAT   = 0x00 // send a text message
+	MSGID_NODEADDR   = 0x01 // tell a peer where we can be reached
+	MSGID_PING       = 0x02 // are you still there?
+	MSGID_PONG       = 0x03 // reply to ping
+	MSGID_INIT       = 0x04 // protocol version and features, sent first
+	MSGID_COMPRESSED = 0x05 // another message, deflated
+	MSGID_CHAT_ID    = 0x06 // a text message with an id, to be acked
+	MSGID_CHAT_ACK   = 0x07 // got the text message with this id
+	MSGID_NODEALIAS  = 0x08 // the name a node goes by, signed so it can be gossiped
 
 	//Channel creation messages
 	MSGID_POINTREQ  = 0x10
@@ -23,13 +33,15 @@ const (
 
 	//Channel destruction messages
 	MSGID_CLOSEREQ  = 0x20 // close channel
-	MSGID_CLOSERESP = 0x21
+	MSGID_CLOSERESP = 0x21 // won't sign the close, and why
 
 	//Push Pull Messages
-	MSGID_DELTASIG  = 0x30 // pushing funds in channel; request to send
-	MSGID_SIGREV    = 0x31 // pulling funds; signing new state and revoking old
-	MSGID_GAPSIGREV = 0x32 // resolving collision
-	MSGID_REV       = 0x33 // pushing funds; revoking previous channel state
+	MSGID_DELTASIG     = 0x30 // pushing funds in channel; request to send
+	MSGID_SIGREV       = 0x31 // pulling funds; signing new state and revoking old
+	MSGID_GAPSIGREV    = 0x32 // resolving collision
+	MSGID_REV          = 0x33 // pushing funds; revoking previous channel state
+	MSGID_HTLCSIG      = 0x34 // adding or removing an HTLC; request to send
+	MSGID_DELTADECLINE = 0x35 // won't take the push, and why
 
 	//not implemented
 	MSGID_FWDMSG     = 0x40
@@ -42,6 +54,11 @@ const (
 	MSGID_WATCH_DESC     = 0x60 // desc describes a new channel
 	MSGID_WATCH_STATEMSG = 0x61 // commsg is a single state in the channel
 	MSGID_WATCH_DELETE   = 0x62 // Watch_clear marks a channel as ok to delete.  No further updates possible.
+	MSGID_WATCH_TERMSREQ = 0x63 // asks a tower what it charges
+	MSGID_WATCH_TERMS    = 0x64 // tower's fees, and how much credit the client has left
+	MSGID_WATCH_BLOB     = 0x65 // encrypted justice data for a single state
+	MSGID_WATCH_BLOBDEL  = 0x66 // blobs a tower can delete, by hint
+	MSGID_WATCH_ACK      = 0x67 // tower has saved a state
 
 	//Routing messages
 	MSGID_LINK_DESC = 0x70 // Describes a new channel for routing
@@ -53,6 +70,27 @@ const (
 	MSGID_DLC_CONTRACTACK         = 0x93 // Acknowledge an acceptance
 	MSGID_DLC_CONTRACTFUNDINGSIGS = 0x94 // Funding signatures
 	MSGID_DLC_SIGPROOF            = 0x95 // Sigproof
+
+	//Atomic swap messages
+	MSGID_SWAP_OFFER   = 0xA0 // offer to swap between channels of 2 coins
+	MSGID_SWAP_ACCEPT  = 0xA1 // accept the swap
+	MSGID_SWAP_DECLINE = 0xA2 // decline the swap
+
+	MSGID_SUBSWAP_OFFER   = 0xA3 // offer to swap channel balance for on-chain coins
+	MSGID_SUBSWAP_ACCEPT  = 0xA4 // accept the submarine swap
+	MSGID_SUBSWAP_DECLINE = 0xA5 // decline the submarine swap
+	MSGID_SUBSWAP_FUND    = 0xA6 // the submarine swap's on-chain HTLC is out
+
+	// remote control
+	MSGID_REMOTE_RPC      = 0xB0 // an rpc call from a paired node
+	MSGID_REMOTE_RPCREPLY = 0xB1 // what it gave back
+
+	//Application messages
+	MSGID_CUSTOM = 0xC0 // an app's own message type and payload
+
+	// payment requests
+	MSGID_PAYREQ         = 0xD0 // asks the peer to push an amount
+	MSGID_PAYREQ_DECLINE = 0xD1 // won't pay the request
 )
 
 //interface that all messages follow, for easy use
@@ -79,6 +117,24 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 	switch msgType {
 	case MSGID_TEXTCHAT:
 		return NewChatMsgFromBytes(b, peerid)
+	case MSGID_CHAT_ID:
+		return NewChatIDMsgFromBytes(b, peerid)
+	case MSGID_CHAT_ACK:
+		return NewChatAckMsgFromBytes(b, peerid)
+	case MSGID_NODEADDR:
+		return NewNodeAddrMsgFromBytes(b, peerid)
+	case MSGID_NODEALIAS:
+		return NewNodeAliasMsgFromBytes(b, peerid)
+	case MSGID_PING, MSGID_PONG:
+		return NewPingMsgFromBytes(b, peerid)
+	case MSGID_INIT:
+		return NewInitMsgFromBytes(b, peerid)
+	case MSGID_COMPRESSED:
+		inner, err := DecompressMsg(b, 0)
+		if err != nil {
+			return nil, err
+		}
+		return LitMsgFromBytes(inner, peerid)
 	case MSGID_POINTREQ:
 		return NewPointReqMsgFromBytes(b, peerid)
 	case MSGID_POINTRESP:
@@ -92,9 +148,8 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 
 	case MSGID_CLOSEREQ:
 		return NewCloseReqMsgFromBytes(b, peerid)
-	/* not implemented
 	case MSGID_CLOSERESP:
-	*/
+		return NewCloseRespMsgFromBytes(b, peerid)
 
 	case MSGID_DELTASIG:
 		return NewDeltaSigMsgFromBytes(b, peerid)
@@ -104,6 +159,10 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 		return NewGapSigRevFromBytes(b, peerid)
 	case MSGID_REV:
 		return NewRevMsgFromBytes(b, peerid)
+	case MSGID_HTLCSIG:
+		return NewHTLCSigMsgFromBytes(b, peerid)
+	case MSGID_DELTADECLINE:
+		return NewDeltaDeclineMsgFromBytes(b, peerid)
 
 	/*
 		case MSGID_FWDMSG:
@@ -116,9 +175,16 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 		return NewWatchDescMsgFromBytes(b, peerid)
 	case MSGID_WATCH_STATEMSG:
 		return NewWatchStateMsgFromBytes(b, peerid)
-	/*
-		case MSGID_WATCH_DELETE:
-	*/
+	case MSGID_WATCH_TERMSREQ, MSGID_WATCH_TERMS:
+		return NewWatchTermsMsgFromBytes(b, peerid)
+	case MSGID_WATCH_BLOB:
+		return NewWatchBlobMsgFromBytes(b, peerid)
+	case MSGID_WATCH_DELETE:
+		return NewWatchDelMsgFromBytes(b, peerid)
+	case MSGID_WATCH_BLOBDEL:
+		return NewWatchBlobDelMsgFromBytes(b, peerid)
+	case MSGID_WATCH_ACK:
+		return NewWatchAckMsgFromBytes(b, peerid)
 
 	case MSGID_LINK_DESC:
 		return NewLinkMsgFromBytes(b, peerid)
@@ -136,6 +202,30 @@ func LitMsgFromBytes(b []byte, peerid uint32) (LitMsg, error) {
 	case MSGID_DLC_SIGPROOF:
 		return NewDlcContractSigProofMsgFromBytes(b, peerid)
 
+	case MSGID_SWAP_OFFER:
+		return NewSwapOfferMsgFromBytes(b, peerid)
+	case MSGID_SWAP_ACCEPT, MSGID_SWAP_DECLINE:
+		return NewSwapReplyMsgFromBytes(b, peerid)
+	case MSGID_SUBSWAP_OFFER:
+		return NewSubSwapOfferMsgFromBytes(b, peerid)
+	case MSGID_SUBSWAP_ACCEPT, MSGID_SUBSWAP_DECLINE:
+		return NewSubSwapReplyMsgFromBytes(b, peerid)
+	case MSGID_SUBSWAP_FUND:
+		return NewSubSwapFundMsgFromBytes(b, peerid)
+
+	case MSGID_REMOTE_RPC:
+		return NewRemoteRPCMsgFromBytes(b, peerid)
+	case MSGID_REMOTE_RPCREPLY:
+		return NewRemoteRPCReplyMsgFromBytes(b, peerid)
+
+	case MSGID_CUSTOM:
+		return NewCustomMsgFromBytes(b, peerid)
+
+	case MSGID_PAYREQ:
+		return NewPayReqMsgFromBytes(b, peerid)
+	case MSGID_PAYREQ_DECLINE:
+		return NewPayReqDeclineMsgFromBytes(b, peerid)
+
 	default:
 		return nil, fmt.Errorf("Unknown message of type %d ", msgType)
 	}
@@ -182,6 +272,317 @@ func (self ChatMsg) MsgType() uint8 { return MSGID_TEXTCHAT }
 
 //----------
 
+// ChatIDMsg is a text message with the sender's id for it, which the peer
+// acks, and when it was written.  A resent one has the same id, so the
+// peer can tell it's seen it.
+// msgtype
+// ID 8
+// Time 8
+// Text (the rest)
+type ChatIDMsg struct {
+	PeerIdx uint32
+	ID      uint64
+	Time    int64
+	Text    string
+}
+
+func NewChatIDMsg(peerid uint32, id uint64, t int64, text string) ChatIDMsg {
+	c := new(ChatIDMsg)
+	c.PeerIdx = peerid
+	c.ID = id
+	c.Time = t
+	c.Text = text
+	return *c
+}
+
+func NewChatIDMsgFromBytes(b []byte, peerid uint32) (ChatIDMsg, error) {
+	c := new(ChatIDMsg)
+	c.PeerIdx = peerid
+
+	if len(b) < 17 {
+		return *c, fmt.Errorf("ChatIDMsg %d bytes, expect 17 or more", len(b))
+	}
+
+	c.ID = BtU64(b[1:9])
+	c.Time = BtI64(b[9:17])
+	c.Text = string(b[17:])
+	return *c, nil
+}
+
+func (self ChatIDMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(U64tB(self.ID))
+	buf.Write(I64tB(self.Time))
+	buf.WriteString(self.Text)
+	return buf.Bytes()
+}
+
+func (self ChatIDMsg) Peer() uint32   { return self.PeerIdx }
+func (self ChatIDMsg) MsgType() uint8 { return MSGID_CHAT_ID }
+
+//----------
+
+// ChatAckMsg says a ChatIDMsg got here.
+// msgtype
+// ID 8
+type ChatAckMsg struct {
+	PeerIdx uint32
+	ID      uint64
+}
+
+func NewChatAckMsg(peerid uint32, id uint64) ChatAckMsg {
+	c := new(ChatAckMsg)
+	c.PeerIdx = peerid
+	c.ID = id
+	return *c
+}
+
+func NewChatAckMsgFromBytes(b []byte, peerid uint32) (ChatAckMsg, error) {
+	c := new(ChatAckMsg)
+	c.PeerIdx = peerid
+
+	if len(b) != 9 {
+		return *c, fmt.Errorf("ChatAckMsg %d bytes, expect 9", len(b))
+	}
+
+	c.ID = BtU64(b[1:])
+	return *c, nil
+}
+
+func (self ChatAckMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	msg = append(msg, U64tB(self.ID)...)
+	return msg
+}
+
+func (self ChatAckMsg) Peer() uint32   { return self.PeerIdx }
+func (self ChatAckMsg) MsgType() uint8 { return MSGID_CHAT_ACK }
+
+//----------
+
+// NodeAddrMsg tells a peer the host:port we're listening on, so they can
+// reconnect to us there (eg after our external IP changes)
+type NodeAddrMsg struct {
+	PeerIdx uint32
+	Host    string
+}
+
+func NewNodeAddrMsg(peerid uint32, host string) NodeAddrMsg {
+	n := new(NodeAddrMsg)
+	n.PeerIdx = peerid
+	n.Host = host
+	return *n
+}
+
+func NewNodeAddrMsgFromBytes(b []byte, peerid uint32) (NodeAddrMsg, error) {
+	n := new(NodeAddrMsg)
+	n.PeerIdx = peerid
+
+	if len(b) <= 1 {
+		return *n, fmt.Errorf("got %d bytes, expect 2 or more", len(b))
+	}
+
+	n.Host = string(b[1:])
+	return *n, nil
+}
+
+func (self NodeAddrMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	msg = append(msg, []byte(self.Host)...)
+	return msg
+}
+
+func (self NodeAddrMsg) Peer() uint32   { return self.PeerIdx }
+func (self NodeAddrMsg) MsgType() uint8 { return MSGID_NODEADDR }
+
+//----------
+
+// MaxAliasLen is the most bytes a node's alias can be.
+const MaxAliasLen = 32
+
+// CheckAlias says whether a node can go by alias: it's printable and not
+// too long.  Empty is fine; it's no alias.
+func CheckAlias(alias string) error {
+	if len(alias) > MaxAliasLen {
+		return fmt.Errorf("alias %d bytes, max %d", len(alias), MaxAliasLen)
+	}
+	return CheckPrintable(alias)
+}
+
+// CheckPrintable says whether text from a peer is fit to show: it's UTF-8,
+// and has nothing that'd mess up a terminal.
+func CheckPrintable(s string) error {
+	if !utf8.ValidString(s) {
+		return fmt.Errorf("text isn't UTF-8")
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("text has control characters")
+		}
+	}
+	return nil
+}
+
+// NodeAliasMsg is the alias a node goes by, signed with its identity key.
+// It's passed along by gossip as is, so PubKey is the node it's about, not
+// necessarily the peer it came from.  A later Time replaces an earlier one.
+// msgtype
+// PubKey 33
+// Time 8 (unix)
+// Sig 64
+// Alias (rest)
+type NodeAliasMsg struct {
+	PeerIdx uint32
+	PubKey  [33]byte
+	Time    int64
+	Sig     [64]byte
+	Alias   string
+}
+
+func NewNodeAliasMsg(peerid uint32, pub [33]byte, t int64, alias string) NodeAliasMsg {
+	n := new(NodeAliasMsg)
+	n.PeerIdx = peerid
+	n.PubKey = pub
+	n.Time = t
+	n.Alias = alias
+	return *n
+}
+
+func NewNodeAliasMsgFromBytes(b []byte, peerid uint32) (NodeAliasMsg, error) {
+	n := new(NodeAliasMsg)
+	n.PeerIdx = peerid
+
+	if len(b) < 106 {
+		return *n, fmt.Errorf("NodeAliasMsg %d bytes, expect at least 106", len(b))
+	}
+	if len(b) > 106+MaxAliasLen {
+		return *n, fmt.Errorf("NodeAliasMsg %d bytes, max %d",
+			len(b), 106+MaxAliasLen)
+	}
+
+	copy(n.PubKey[:], b[1:34])
+	n.Time = BtI64(b[34:42])
+	copy(n.Sig[:], b[42:106])
+	n.Alias = string(b[106:])
+	return *n, nil
+}
+
+// SigHash is what the node signs: its pubkey, the time and the alias.
+func (self NodeAliasMsg) SigHash() chainhash.Hash {
+	var buf bytes.Buffer
+	buf.WriteString("lit node alias")
+	buf.Write(self.PubKey[:])
+	buf.Write(I64tB(self.Time))
+	buf.WriteString(self.Alias)
+	return chainhash.DoubleHashH(buf.Bytes())
+}
+
+func (self NodeAliasMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.PubKey[:])
+	buf.Write(I64tB(self.Time))
+	buf.Write(self.Sig[:])
+	buf.WriteString(self.Alias)
+	return buf.Bytes()
+}
+
+func (self NodeAliasMsg) Peer() uint32   { return self.PeerIdx }
+func (self NodeAliasMsg) MsgType() uint8 { return MSGID_NODEALIAS }
+
+//----------
+
+// PingMsg is a keepalive; either a ping or the pong answering it.  The pong
+// has the same nonce as the ping.
+type PingMsg struct {
+	PeerIdx uint32
+	Pong    bool
+	Nonce   uint64
+}
+
+func NewPingMsg(peerid uint32, pong bool, nonce uint64) PingMsg {
+	p := new(PingMsg)
+	p.PeerIdx = peerid
+	p.Pong = pong
+	p.Nonce = nonce
+	return *p
+}
+
+func NewPingMsgFromBytes(b []byte, peerid uint32) (PingMsg, error) {
+	p := new(PingMsg)
+	p.PeerIdx = peerid
+
+	if len(b) != 9 {
+		return *p, fmt.Errorf("got %d bytes, expect 9", len(b))
+	}
+
+	p.Pong = b[0] == MSGID_PONG
+	p.Nonce = BtU64(b[1:])
+	return *p, nil
+}
+
+func (self PingMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	msg = append(msg, U64tB(self.Nonce)...)
+	return msg
+}
+
+func (self PingMsg) Peer() uint32 { return self.PeerIdx }
+func (self PingMsg) MsgType() uint8 {
+	if self.Pong {
+		return MSGID_PONG
+	}
+	return MSGID_PING
+}
+
+//----------
+
+// InitMsg is the first message each side sends on a new connection, saying
+// what protocol version and features it has.
+type InitMsg struct {
+	PeerIdx  uint32
+	Version  uint16
+	Features FeatureBits
+}
+
+func NewInitMsg(peerid uint32, version uint16, features FeatureBits) InitMsg {
+	i := new(InitMsg)
+	i.PeerIdx = peerid
+	i.Version = version
+	i.Features = features
+	return *i
+}
+
+func NewInitMsgFromBytes(b []byte, peerid uint32) (InitMsg, error) {
+	i := new(InitMsg)
+	i.PeerIdx = peerid
+
+	if len(b) != 11 {
+		return *i, fmt.Errorf("got %d bytes, expect 11", len(b))
+	}
+
+	i.Version = uint16(b[1])<<8 | uint16(b[2])
+	i.Features = FeatureBits(BtU64(b[3:]))
+	return *i, nil
+}
+
+func (self InitMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	msg = append(msg, byte(self.Version>>8), byte(self.Version))
+	msg = append(msg, U64tB(uint64(self.Features))...)
+	return msg
+}
+
+func (self InitMsg) Peer() uint32   { return self.PeerIdx }
+func (self InitMsg) MsgType() uint8 { return MSGID_INIT }
+
+//----------
+
 //message with no information, just shows a point is requested
 type PointReqMsg struct {
 	PeerIdx  uint32
@@ -507,6 +908,93 @@ func (self CloseReqMsg) Bytes() []byte {
 func (self CloseReqMsg) Peer() uint32   { return self.PeerIdx }
 func (self CloseReqMsg) MsgType() uint8 { return MSGID_CLOSEREQ }
 
+// CloseRespMsg says the peer won't sign a close we asked for, and why.
+type CloseRespMsg struct {
+	PeerIdx  uint32
+	Outpoint wire.OutPoint
+	Reason   string
+}
+
+func NewCloseRespMsg(peerid uint32, OP wire.OutPoint, reason string) CloseRespMsg {
+	cr := new(CloseRespMsg)
+	cr.PeerIdx = peerid
+	cr.Outpoint = OP
+	cr.Reason = reason
+	return *cr
+}
+
+func NewCloseRespMsgFromBytes(b []byte, peerid uint32) (CloseRespMsg, error) {
+	crm := new(CloseRespMsg)
+	crm.PeerIdx = peerid
+
+	if len(b) < 37 {
+		return *crm, fmt.Errorf("got %d byte closeresp, expect 37+", len(b))
+	}
+
+	var op [36]byte
+	copy(op[:], b[1:37])
+	crm.Outpoint = *OutPointFromBytes(op)
+	crm.Reason = string(b[37:])
+	return *crm, nil
+}
+
+func (self CloseRespMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	opArr := OutPointToBytes(self.Outpoint)
+	msg = append(msg, opArr[:]...)
+	msg = append(msg, []byte(self.Reason)...)
+	return msg
+}
+
+func (self CloseRespMsg) Peer() uint32   { return self.PeerIdx }
+func (self CloseRespMsg) MsgType() uint8 { return MSGID_CLOSERESP }
+
+//----------
+
+// DeltaDeclineMsg says the peer won't take a push we sent a DeltaSig for,
+// and why.
+type DeltaDeclineMsg struct {
+	PeerIdx  uint32
+	Outpoint wire.OutPoint
+	Reason   string
+}
+
+func NewDeltaDeclineMsg(peerid uint32, OP wire.OutPoint, reason string) DeltaDeclineMsg {
+	dd := new(DeltaDeclineMsg)
+	dd.PeerIdx = peerid
+	dd.Outpoint = OP
+	dd.Reason = reason
+	return *dd
+}
+
+func NewDeltaDeclineMsgFromBytes(b []byte, peerid uint32) (DeltaDeclineMsg, error) {
+	dd := new(DeltaDeclineMsg)
+	dd.PeerIdx = peerid
+
+	if len(b) < 37 {
+		return *dd, fmt.Errorf("got %d byte deltadecline, expect 37+", len(b))
+	}
+
+	var op [36]byte
+	copy(op[:], b[1:37])
+	dd.Outpoint = *OutPointFromBytes(op)
+	dd.Reason = string(b[37:])
+	return *dd, nil
+}
+
+func (self DeltaDeclineMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	opArr := OutPointToBytes(self.Outpoint)
+	msg = append(msg, opArr[:]...)
+	msg = append(msg, []byte(self.Reason)...)
+	return msg
+}
+
+func (self DeltaDeclineMsg) Peer() uint32   { return self.PeerIdx }
+func (self DeltaDeclineMsg) MsgType() uint8 { return MSGID_DELTADECLINE }
+
 //----------
 
 //message for sending an amount with the signature
@@ -718,6 +1206,80 @@ func (self RevMsg) Bytes() []byte {
 func (self RevMsg) Peer() uint32   { return self.PeerIdx }
 func (self RevMsg) MsgType() uint8 { return MSGID_REV }
 
+// HTLC ops an HTLCSig can do
+const (
+	HTLCOpAdd    = 1 // offer an HTLC
+	HTLCOpSettle = 2 // the receiver takes it, revealing R
+	HTLCOpFail   = 3 // it goes back to the offerer
+)
+
+// HTLCSigMsg is like a DeltaSig, but instead of pushing an amount it adds,
+// settles or fails an HTLC, and signs the state after that.  R is only
+// filled in to settle.
+type HTLCSigMsg struct {
+	PeerIdx   uint32
+	Outpoint  wire.OutPoint
+	Op        uint8
+	Amt       int64
+	RHash     [32]byte
+	Locktime  uint32
+	R         [32]byte
+	Signature [64]byte
+}
+
+func NewHTLCSigMsg(peerid uint32, OP wire.OutPoint, op uint8, amt int64,
+	rHash [32]byte, locktime uint32, R [32]byte, SIG [64]byte) HTLCSigMsg {
+	h := new(HTLCSigMsg)
+	h.PeerIdx = peerid
+	h.Outpoint = OP
+	h.Op = op
+	h.Amt = amt
+	h.RHash = rHash
+	h.Locktime = locktime
+	h.R = R
+	h.Signature = SIG
+	return *h
+}
+
+func NewHTLCSigMsgFromBytes(b []byte, peerid uint32) (HTLCSigMsg, error) {
+	hs := new(HTLCSigMsg)
+	hs.PeerIdx = peerid
+
+	if len(b) < 178 {
+		return *hs, fmt.Errorf("got %d byte HTLCSig, expect 178", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(op[:], buf.Next(36))
+	hs.Outpoint = *OutPointFromBytes(op)
+	hs.Op = buf.Next(1)[0]
+	hs.Amt = BtI64(buf.Next(8))
+	copy(hs.RHash[:], buf.Next(32))
+	hs.Locktime = BtU32(buf.Next(4))
+	copy(hs.R[:], buf.Next(32))
+	copy(hs.Signature[:], buf.Next(64))
+	return *hs, nil
+}
+
+func (self HTLCSigMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	opArr := OutPointToBytes(self.Outpoint)
+	msg = append(msg, opArr[:]...)
+	msg = append(msg, self.Op)
+	msg = append(msg, I64tB(self.Amt)...)
+	msg = append(msg, self.RHash[:]...)
+	msg = append(msg, U32tB(self.Locktime)...)
+	msg = append(msg, self.R[:]...)
+	msg = append(msg, self.Signature[:]...)
+	return msg
+}
+
+func (self HTLCSigMsg) Peer() uint32   { return self.PeerIdx }
+func (self HTLCSigMsg) MsgType() uint8 { return MSGID_HTLCSIG }
+
 //----------
 
 // 2 structs that the watchtower gets from clients: Descriptors and Msgs
@@ -874,6 +1436,14 @@ type WatchDelMsg struct {
 	// Don't actually have to send DestPKH huh.  Send anyway.
 }
 
+func NewWatchDelMsg(peerIdx uint32, destPKH [20]byte, revealPK [33]byte) WatchDelMsg {
+	sm := new(WatchDelMsg)
+	sm.PeerIdx = peerIdx
+	sm.DestPKH = destPKH
+	sm.RevealPK = revealPK
+	return *sm
+}
+
 // Bytes turns a ComMsg into 132 bytes
 func (self WatchDelMsg) Bytes() []byte {
 	var buf bytes.Buffer
@@ -903,43 +1473,249 @@ func NewWatchDelMsgFromBytes(b []byte, peerIDX uint32) (WatchDelMsg, error) {
 func (self WatchDelMsg) Peer() uint32   { return self.PeerIdx }
 func (self WatchDelMsg) MsgType() uint8 { return MSGID_WATCH_DELETE }
 
-// Link message
+//----------
 
-type LinkMsg struct {
-	PeerIdx   uint32
-	PKHScript [20]byte // ChanPKH (channel ID)
-	APKH      [20]byte // APKH (A's LN address)
-	ACapacity int64    // ACapacity (A's channel balance)
-	BPKH      [20]byte // BPKH (B's LN address)
-	CoinType  uint32   // CoinType (Network of the channel)
-	Seq       uint32   // seq (Link state sequence #)
-	Timestamp int64
+// WatchTermsMsg is what a tower charges, in satoshis: once for each new
+// channel, and for each state it stores.  Balance is how much the client
+// has paid which hasn't been used up yet.  A request has everything zero.
+// 25 bytes:
+// msgtype
+// PerChannel 8
+// PerState 8
+// Balance 8
+type WatchTermsMsg struct {
+	PeerIdx    uint32
+	Request    bool
+	PerChannel int64
+	PerState   int64
+	Balance    int64
 }
 
-func NewLinkMsgFromBytes(b []byte, peerIDX uint32) (LinkMsg, error) {
-	sm := new(LinkMsg)
-	sm.PeerIdx = peerIDX
+func NewWatchTermsMsg(peerIdx uint32, request bool,
+	perChannel, perState, balance int64) WatchTermsMsg {
+	wt := new(WatchTermsMsg)
+	wt.PeerIdx = peerIdx
+	wt.Request = request
+	wt.PerChannel = perChannel
+	wt.PerState = perState
+	wt.Balance = balance
+	return *wt
+}
 
-	if len(b) < 76 {
-		return *sm, fmt.Errorf("LinkMsg %d bytes, expect 76", len(b))
+func NewWatchTermsMsgFromBytes(b []byte, peerIDX uint32) (WatchTermsMsg, error) {
+	wt := new(WatchTermsMsg)
+	wt.PeerIdx = peerIDX
+
+	if len(b) < 25 {
+		return *wt, fmt.Errorf("WatchTermsMsg %d bytes, expect 25", len(b))
 	}
 
+	wt.Request = b[0] == MSGID_WATCH_TERMSREQ
 	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	_ = binary.Read(buf, binary.BigEndian, &wt.PerChannel)
+	_ = binary.Read(buf, binary.BigEndian, &wt.PerState)
+	_ = binary.Read(buf, binary.BigEndian, &wt.Balance)
 
-	copy(sm.PKHScript[:], buf.Next(20))
-	copy(sm.APKH[:], buf.Next(20))
-	_ = binary.Read(buf, binary.BigEndian, &sm.ACapacity)
-	copy(sm.BPKH[:], buf.Next(20))
-	_ = binary.Read(buf, binary.BigEndian, &sm.CoinType)
-	_ = binary.Read(buf, binary.BigEndian, &sm.Seq)
-
-	return *sm, nil
+	return *wt, nil
 }
 
-// ToBytes turns a LinkMsg into 88 bytes
-func (self LinkMsg) Bytes() []byte {
+func (self WatchTermsMsg) Bytes() []byte {
 	var buf bytes.Buffer
-
+	buf.WriteByte(self.MsgType())
+	binary.Write(&buf, binary.BigEndian, self.PerChannel)
+	binary.Write(&buf, binary.BigEndian, self.PerState)
+	binary.Write(&buf, binary.BigEndian, self.Balance)
+	return buf.Bytes()
+}
+
+func (self WatchTermsMsg) Peer() uint32 { return self.PeerIdx }
+func (self WatchTermsMsg) MsgType() uint8 {
+	if self.Request {
+		return MSGID_WATCH_TERMSREQ
+	}
+	return MSGID_WATCH_TERMS
+}
+
+//----------
+
+// WatchBlobMsg is a state for the tower to watch for, encrypted so that it
+// can only be read once the commitment tx it's for shows up (see
+// watchblob.go).  Replaces WatchDescMsg and WatchStateMsg, which tell the
+// tower about the channel up front.
+// msgtype
+// CoinType 4
+// Hint 16
+// Blob (rest)
+type WatchBlobMsg struct {
+	PeerIdx  uint32
+	CoinType uint32
+	Hint     [16]byte // first 16 bytes of the commitment txid
+	Blob     []byte   // encrypted JusticeBlob
+}
+
+func NewWatchBlobMsg(peerIdx, coinType uint32, hint [16]byte, blob []byte) WatchBlobMsg {
+	wb := new(WatchBlobMsg)
+	wb.PeerIdx = peerIdx
+	wb.CoinType = coinType
+	wb.Hint = hint
+	wb.Blob = blob
+	return *wb
+}
+
+func NewWatchBlobMsgFromBytes(b []byte, peerIDX uint32) (WatchBlobMsg, error) {
+	wb := new(WatchBlobMsg)
+	wb.PeerIdx = peerIDX
+
+	if len(b) < 22 {
+		return *wb, fmt.Errorf("WatchBlobMsg %d bytes, expect at least 22", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	_ = binary.Read(buf, binary.BigEndian, &wb.CoinType)
+	copy(wb.Hint[:], buf.Next(16))
+	wb.Blob = buf.Bytes()
+
+	return *wb, nil
+}
+
+func (self WatchBlobMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	binary.Write(&buf, binary.BigEndian, self.CoinType)
+	buf.Write(self.Hint[:])
+	buf.Write(self.Blob)
+	return buf.Bytes()
+}
+
+func (self WatchBlobMsg) Peer() uint32   { return self.PeerIdx }
+func (self WatchBlobMsg) MsgType() uint8 { return MSGID_WATCH_BLOB }
+
+//----------
+
+// WatchBlobDelMsg tells a tower it can delete the blobs we sent it with
+// these hints, because the channel they're for is closed.
+// msgtype
+// Hints 16 each (rest)
+type WatchBlobDelMsg struct {
+	PeerIdx uint32
+	Hints   [][16]byte
+}
+
+func NewWatchBlobDelMsg(peerIdx uint32, hints [][16]byte) WatchBlobDelMsg {
+	wd := new(WatchBlobDelMsg)
+	wd.PeerIdx = peerIdx
+	wd.Hints = hints
+	return *wd
+}
+
+func NewWatchBlobDelMsgFromBytes(b []byte, peerIDX uint32) (WatchBlobDelMsg, error) {
+	wd := new(WatchBlobDelMsg)
+	wd.PeerIdx = peerIDX
+
+	if len(b) < 17 || (len(b)-1)%16 != 0 {
+		return *wd, fmt.Errorf("WatchBlobDelMsg %d bytes, expect 1 + 16 per hint", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+	wd.Hints = make([][16]byte, buf.Len()/16)
+	for i := range wd.Hints {
+		copy(wd.Hints[i][:], buf.Next(16))
+	}
+
+	return *wd, nil
+}
+
+func (self WatchBlobDelMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	for _, hint := range self.Hints {
+		buf.Write(hint[:])
+	}
+	return buf.Bytes()
+}
+
+func (self WatchBlobDelMsg) Peer() uint32   { return self.PeerIdx }
+func (self WatchBlobDelMsg) MsgType() uint8 { return MSGID_WATCH_BLOBDEL }
+
+//----------
+
+// WatchAckMsg is a tower saying it has saved a state, identified by the
+// first 16 bytes of the txid it's for: the blob hint, or the ParTxid of a
+// WatchStateMsg.
+// msgtype
+// Hint 16
+type WatchAckMsg struct {
+	PeerIdx uint32
+	Hint    [16]byte
+}
+
+func NewWatchAckMsg(peerIdx uint32, hint [16]byte) WatchAckMsg {
+	wa := new(WatchAckMsg)
+	wa.PeerIdx = peerIdx
+	wa.Hint = hint
+	return *wa
+}
+
+func NewWatchAckMsgFromBytes(b []byte, peerIDX uint32) (WatchAckMsg, error) {
+	wa := new(WatchAckMsg)
+	wa.PeerIdx = peerIDX
+
+	if len(b) < 17 {
+		return *wa, fmt.Errorf("WatchAckMsg %d bytes, expect 17", len(b))
+	}
+
+	copy(wa.Hint[:], b[1:17])
+
+	return *wa, nil
+}
+
+func (self WatchAckMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.Hint[:])
+	return buf.Bytes()
+}
+
+func (self WatchAckMsg) Peer() uint32   { return self.PeerIdx }
+func (self WatchAckMsg) MsgType() uint8 { return MSGID_WATCH_ACK }
+
+// Link message
+
+type LinkMsg struct {
+	PeerIdx   uint32
+	PKHScript [20]byte // ChanPKH (channel ID)
+	APKH      [20]byte // APKH (A's LN address)
+	ACapacity int64    // ACapacity (A's channel balance)
+	BPKH      [20]byte // BPKH (B's LN address)
+	CoinType  uint32   // CoinType (Network of the channel)
+	Seq       uint32   // seq (Link state sequence #)
+	Timestamp int64
+}
+
+func NewLinkMsgFromBytes(b []byte, peerIDX uint32) (LinkMsg, error) {
+	sm := new(LinkMsg)
+	sm.PeerIdx = peerIDX
+
+	if len(b) < 76 {
+		return *sm, fmt.Errorf("LinkMsg %d bytes, expect 76", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	copy(sm.PKHScript[:], buf.Next(20))
+	copy(sm.APKH[:], buf.Next(20))
+	_ = binary.Read(buf, binary.BigEndian, &sm.ACapacity)
+	copy(sm.BPKH[:], buf.Next(20))
+	_ = binary.Read(buf, binary.BigEndian, &sm.CoinType)
+	_ = binary.Read(buf, binary.BigEndian, &sm.Seq)
+
+	return *sm, nil
+}
+
+// ToBytes turns a LinkMsg into 88 bytes
+func (self LinkMsg) Bytes() []byte {
+	var buf bytes.Buffer
+
 	buf.WriteByte(self.MsgType())
 
 	buf.Write(self.PKHScript[:])
@@ -1086,12 +1862,16 @@ type DlcOfferAcceptMsg struct {
 	FundingInputs []DlcContractFundingInput
 	// The signatures for settling the contract at various values
 	SettlementSignatures []DlcContractSettlementSignature
+	// The signature for the refund transaction. Empty from nodes that
+	// don't know about refunds
+	RefundSignature [64]byte
 }
 
 // NewDlcOfferAcceptMsg generates a new DlcOfferAcceptMsg struct based on the
 // passed contract and signatures
 func NewDlcOfferAcceptMsg(contract *DlcContract,
-	signatures []DlcContractSettlementSignature) DlcOfferAcceptMsg {
+	signatures []DlcContractSettlementSignature,
+	refundSig [64]byte) DlcOfferAcceptMsg {
 
 	msg := new(DlcOfferAcceptMsg)
 	msg.PeerIdx = contract.PeerIdx
@@ -1103,6 +1883,7 @@ func NewDlcOfferAcceptMsg(contract *DlcContract,
 	msg.OurPayoutBase = contract.OurPayoutBase
 	msg.OurPayoutPKH = contract.OurPayoutPKH
 	msg.SettlementSignatures = signatures
+	msg.RefundSignature = refundSig
 	return *msg
 }
 
@@ -1149,6 +1930,10 @@ func NewDlcOfferAcceptMsgFromBytes(b []byte,
 		copy(msg.SettlementSignatures[i].Signature[:], buf.Next(64))
 	}
 
+	if buf.Len() >= 64 {
+		copy(msg.RefundSignature[:], buf.Next(64))
+	}
+
 	return *msg, nil
 }
 
@@ -1182,6 +1967,7 @@ func (msg DlcOfferAcceptMsg) Bytes() []byte {
 		wire.WriteVarInt(&buf, 0, uint64(msg.SettlementSignatures[i].Outcome))
 		buf.Write(msg.SettlementSignatures[i].Signature[:])
 	}
+	buf.Write(msg.RefundSignature[:])
 	return buf.Bytes()
 }
 
@@ -1205,17 +1991,22 @@ type DlcContractAckMsg struct {
 	Idx uint64
 	// The settlement signatures of the party acknowledging
 	SettlementSignatures []DlcContractSettlementSignature
+	// The refund signature of the party acknowledging. Empty from nodes
+	// that don't know about refunds
+	RefundSignature [64]byte
 }
 
 // NewDlcContractAckMsg generates a new DlcContractAckMsg struct based on the
 // passed contract and signatures
 func NewDlcContractAckMsg(contract *DlcContract,
-	signatures []DlcContractSettlementSignature) DlcContractAckMsg {
+	signatures []DlcContractSettlementSignature,
+	refundSig [64]byte) DlcContractAckMsg {
 
 	msg := new(DlcContractAckMsg)
 	msg.PeerIdx = contract.PeerIdx
 	msg.Idx = contract.TheirIdx
 	msg.SettlementSignatures = signatures
+	msg.RefundSignature = refundSig
 	return *msg
 }
 
@@ -1245,6 +2036,10 @@ func NewDlcContractAckMsgFromBytes(b []byte,
 		copy(msg.SettlementSignatures[i].Signature[:], buf.Next(64))
 	}
 
+	if buf.Len() >= 64 {
+		copy(msg.RefundSignature[:], buf.Next(64))
+	}
+
 	return *msg, nil
 }
 
@@ -1263,6 +2058,7 @@ func (msg DlcContractAckMsg) Bytes() []byte {
 		binary.Write(&buf, binary.BigEndian, outcome)
 		buf.Write(msg.SettlementSignatures[i].Signature[:])
 	}
+	buf.Write(msg.RefundSignature[:])
 	return buf.Bytes()
 }
 
@@ -1413,3 +2209,567 @@ func (msg DlcContractSigProofMsg) Peer() uint32 {
 func (msg DlcContractSigProofMsg) MsgType() uint8 {
 	return MSGID_DLC_SIGPROOF
 }
+
+//----------
+
+// SwapOfferMsg offers to swap GiveAmt in one channel for WantAmt in another
+// channel with the same peer, on a different coin.  Swaps are known by the
+// hash of the preimage the offerer picked.  The offerer's HTLC locks for
+// Timeout seconds, the peer's for half that.
+// msgtype
+// RHash 32
+// GiveOutpoint 36
+// GiveAmt 8
+// WantOutpoint 36
+// WantAmt 8
+// Timeout 4
+type SwapOfferMsg struct {
+	PeerIdx      uint32
+	RHash        [32]byte
+	GiveOutpoint wire.OutPoint
+	GiveAmt      int64
+	WantOutpoint wire.OutPoint
+	WantAmt      int64
+	Timeout      uint32
+}
+
+func NewSwapOfferMsgFromBytes(b []byte, peerIdx uint32) (SwapOfferMsg, error) {
+	so := new(SwapOfferMsg)
+	so.PeerIdx = peerIdx
+
+	if len(b) < 125 {
+		return *so, fmt.Errorf("SwapOfferMsg %d bytes, expect 125", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(so.RHash[:], buf.Next(32))
+	copy(op[:], buf.Next(36))
+	so.GiveOutpoint = *OutPointFromBytes(op)
+	so.GiveAmt = BtI64(buf.Next(8))
+	copy(op[:], buf.Next(36))
+	so.WantOutpoint = *OutPointFromBytes(op)
+	so.WantAmt = BtI64(buf.Next(8))
+	so.Timeout = BtU32(buf.Next(4))
+	return *so, nil
+}
+
+func (self SwapOfferMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.RHash[:])
+	opArr := OutPointToBytes(self.GiveOutpoint)
+	buf.Write(opArr[:])
+	buf.Write(I64tB(self.GiveAmt))
+	opArr = OutPointToBytes(self.WantOutpoint)
+	buf.Write(opArr[:])
+	buf.Write(I64tB(self.WantAmt))
+	buf.Write(U32tB(self.Timeout))
+	return buf.Bytes()
+}
+
+func (self SwapOfferMsg) Peer() uint32   { return self.PeerIdx }
+func (self SwapOfferMsg) MsgType() uint8 { return MSGID_SWAP_OFFER }
+
+// SwapReplyMsg accepts or declines the swap offer with the hash.
+// msgtype (accept or decline)
+// RHash 32
+type SwapReplyMsg struct {
+	PeerIdx uint32
+	RHash   [32]byte
+	Accept  bool
+}
+
+func NewSwapReplyMsg(peerIdx uint32, rHash [32]byte, accept bool) SwapReplyMsg {
+	sr := new(SwapReplyMsg)
+	sr.PeerIdx = peerIdx
+	sr.RHash = rHash
+	sr.Accept = accept
+	return *sr
+}
+
+func NewSwapReplyMsgFromBytes(b []byte, peerIdx uint32) (SwapReplyMsg, error) {
+	sr := new(SwapReplyMsg)
+	sr.PeerIdx = peerIdx
+
+	if len(b) < 33 {
+		return *sr, fmt.Errorf("SwapReplyMsg %d bytes, expect 33", len(b))
+	}
+
+	sr.Accept = b[0] == MSGID_SWAP_ACCEPT
+	copy(sr.RHash[:], b[1:33])
+	return *sr, nil
+}
+
+func (self SwapReplyMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.RHash[:])
+	return buf.Bytes()
+}
+
+func (self SwapReplyMsg) Peer() uint32 { return self.PeerIdx }
+func (self SwapReplyMsg) MsgType() uint8 {
+	if self.Accept {
+		return MSGID_SWAP_ACCEPT
+	}
+	return MSGID_SWAP_DECLINE
+}
+
+//----------
+
+// SubSwapOfferMsg offers a submarine swap in a channel: for loop in, the
+// offerer pays ChainAmt on chain for ChanAmt in the channel; for loop out,
+// the other way around.  Pub is the offerer's claim key for loop out.
+// The first HTLC locks for Timeout blocks, the second for half that.
+// msgtype
+// RHash 32
+// Outpoint 36
+// LoopIn 1
+// ChanAmt 8
+// ChainAmt 8
+// Timeout 4
+// Pub 33
+type SubSwapOfferMsg struct {
+	PeerIdx  uint32
+	RHash    [32]byte
+	Outpoint wire.OutPoint
+	LoopIn   bool
+	ChanAmt  int64
+	ChainAmt int64
+	Timeout  uint32
+	Pub      [33]byte
+}
+
+func NewSubSwapOfferMsgFromBytes(b []byte, peerIdx uint32) (SubSwapOfferMsg, error) {
+	so := new(SubSwapOfferMsg)
+	so.PeerIdx = peerIdx
+
+	if len(b) < 123 {
+		return *so, fmt.Errorf("SubSwapOfferMsg %d bytes, expect 123", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(so.RHash[:], buf.Next(32))
+	copy(op[:], buf.Next(36))
+	so.Outpoint = *OutPointFromBytes(op)
+	so.LoopIn = buf.Next(1)[0] != 0
+	so.ChanAmt = BtI64(buf.Next(8))
+	so.ChainAmt = BtI64(buf.Next(8))
+	so.Timeout = BtU32(buf.Next(4))
+	copy(so.Pub[:], buf.Next(33))
+	return *so, nil
+}
+
+func (self SubSwapOfferMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.RHash[:])
+	opArr := OutPointToBytes(self.Outpoint)
+	buf.Write(opArr[:])
+	if self.LoopIn {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.Write(I64tB(self.ChanAmt))
+	buf.Write(I64tB(self.ChainAmt))
+	buf.Write(U32tB(self.Timeout))
+	buf.Write(self.Pub[:])
+	return buf.Bytes()
+}
+
+func (self SubSwapOfferMsg) Peer() uint32   { return self.PeerIdx }
+func (self SubSwapOfferMsg) MsgType() uint8 { return MSGID_SUBSWAP_OFFER }
+
+// SubSwapReplyMsg accepts or declines the submarine swap with the hash.
+// Pub is the accepter's claim key for loop in.
+// msgtype (accept or decline)
+// RHash 32
+// Pub 33
+type SubSwapReplyMsg struct {
+	PeerIdx uint32
+	RHash   [32]byte
+	Accept  bool
+	Pub     [33]byte
+}
+
+func NewSubSwapReplyMsg(peerIdx uint32, rHash [32]byte, accept bool,
+	pub [33]byte) SubSwapReplyMsg {
+	sr := new(SubSwapReplyMsg)
+	sr.PeerIdx = peerIdx
+	sr.RHash = rHash
+	sr.Accept = accept
+	sr.Pub = pub
+	return *sr
+}
+
+func NewSubSwapReplyMsgFromBytes(b []byte, peerIdx uint32) (SubSwapReplyMsg, error) {
+	sr := new(SubSwapReplyMsg)
+	sr.PeerIdx = peerIdx
+
+	if len(b) < 66 {
+		return *sr, fmt.Errorf("SubSwapReplyMsg %d bytes, expect 66", len(b))
+	}
+
+	sr.Accept = b[0] == MSGID_SUBSWAP_ACCEPT
+	copy(sr.RHash[:], b[1:33])
+	copy(sr.Pub[:], b[33:66])
+	return *sr, nil
+}
+
+func (self SubSwapReplyMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.RHash[:])
+	buf.Write(self.Pub[:])
+	return buf.Bytes()
+}
+
+func (self SubSwapReplyMsg) Peer() uint32 { return self.PeerIdx }
+func (self SubSwapReplyMsg) MsgType() uint8 {
+	if self.Accept {
+		return MSGID_SUBSWAP_ACCEPT
+	}
+	return MSGID_SUBSWAP_DECLINE
+}
+
+// SubSwapFundMsg says where the submarine swap's on-chain HTLC is, and the
+// rest of its script: the funder's refund key and the locktime.
+// msgtype
+// RHash 32
+// Outpoint 36
+// Pub 33
+// Locktime 4
+type SubSwapFundMsg struct {
+	PeerIdx  uint32
+	RHash    [32]byte
+	Outpoint wire.OutPoint
+	Pub      [33]byte
+	Locktime uint32
+}
+
+func NewSubSwapFundMsg(peerIdx uint32, rHash [32]byte, op wire.OutPoint,
+	pub [33]byte, locktime uint32) SubSwapFundMsg {
+	sf := new(SubSwapFundMsg)
+	sf.PeerIdx = peerIdx
+	sf.RHash = rHash
+	sf.Outpoint = op
+	sf.Pub = pub
+	sf.Locktime = locktime
+	return *sf
+}
+
+func NewSubSwapFundMsgFromBytes(b []byte, peerIdx uint32) (SubSwapFundMsg, error) {
+	sf := new(SubSwapFundMsg)
+	sf.PeerIdx = peerIdx
+
+	if len(b) < 106 {
+		return *sf, fmt.Errorf("SubSwapFundMsg %d bytes, expect 106", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	var op [36]byte
+	copy(sf.RHash[:], buf.Next(32))
+	copy(op[:], buf.Next(36))
+	sf.Outpoint = *OutPointFromBytes(op)
+	copy(sf.Pub[:], buf.Next(33))
+	sf.Locktime = BtU32(buf.Next(4))
+	return *sf, nil
+}
+
+func (self SubSwapFundMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(self.RHash[:])
+	opArr := OutPointToBytes(self.Outpoint)
+	buf.Write(opArr[:])
+	buf.Write(self.Pub[:])
+	buf.Write(U32tB(self.Locktime))
+	return buf.Bytes()
+}
+
+func (self SubSwapFundMsg) Peer() uint32   { return self.PeerIdx }
+func (self SubSwapFundMsg) MsgType() uint8 { return MSGID_SUBSWAP_FUND }
+
+//----------
+
+// RemoteRPCMsg calls an rpc method on a node that's paired with us.  Args
+// are json, as the rpc takes them; ID matches the reply to it.
+// msgtype
+// ID 8
+// method length 1
+// Method
+// Args (the rest)
+type RemoteRPCMsg struct {
+	PeerIdx uint32
+	ID      uint64
+	Method  string
+	Args    []byte
+}
+
+func NewRemoteRPCMsg(peerIdx uint32, id uint64, method string,
+	args []byte) RemoteRPCMsg {
+	rr := new(RemoteRPCMsg)
+	rr.PeerIdx = peerIdx
+	rr.ID = id
+	rr.Method = method
+	rr.Args = args
+	return *rr
+}
+
+func NewRemoteRPCMsgFromBytes(b []byte, peerIdx uint32) (RemoteRPCMsg, error) {
+	rr := new(RemoteRPCMsg)
+	rr.PeerIdx = peerIdx
+
+	if len(b) < 10 {
+		return *rr, fmt.Errorf("RemoteRPCMsg %d bytes, expect 10 or more", len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	rr.ID = BtU64(buf.Next(8))
+	methodLen, _ := buf.ReadByte()
+	if buf.Len() < int(methodLen) {
+		return *rr, fmt.Errorf("RemoteRPCMsg method %d bytes, only %d left",
+			methodLen, buf.Len())
+	}
+	rr.Method = string(buf.Next(int(methodLen)))
+	rr.Args = append([]byte(nil), buf.Bytes()...)
+	return *rr, nil
+}
+
+func (self RemoteRPCMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(U64tB(self.ID))
+	// method names are short; a long one gets cut off and won't be found
+	method := self.Method
+	if len(method) > 255 {
+		method = method[:255]
+	}
+	buf.WriteByte(uint8(len(method)))
+	buf.WriteString(method)
+	buf.Write(self.Args)
+	return buf.Bytes()
+}
+
+func (self RemoteRPCMsg) Peer() uint32   { return self.PeerIdx }
+func (self RemoteRPCMsg) MsgType() uint8 { return MSGID_REMOTE_RPC }
+
+//----------
+
+// RemoteRPCReplyMsg is a remote rpc call's reply, as json, or its error.
+// msgtype
+// ID 8
+// error length 4
+// Error
+// Reply (the rest)
+type RemoteRPCReplyMsg struct {
+	PeerIdx uint32
+	ID      uint64
+	Error   string
+	Reply   []byte
+}
+
+func NewRemoteRPCReplyMsg(peerIdx uint32, id uint64, reply []byte,
+	errStr string) RemoteRPCReplyMsg {
+	rr := new(RemoteRPCReplyMsg)
+	rr.PeerIdx = peerIdx
+	rr.ID = id
+	rr.Reply = reply
+	rr.Error = errStr
+	return *rr
+}
+
+func NewRemoteRPCReplyMsgFromBytes(b []byte,
+	peerIdx uint32) (RemoteRPCReplyMsg, error) {
+	rr := new(RemoteRPCReplyMsg)
+	rr.PeerIdx = peerIdx
+
+	if len(b) < 13 {
+		return *rr, fmt.Errorf("RemoteRPCReplyMsg %d bytes, expect 13 or more",
+			len(b))
+	}
+
+	buf := bytes.NewBuffer(b[1:]) // get rid of messageType
+
+	rr.ID = BtU64(buf.Next(8))
+	errLen := BtU32(buf.Next(4))
+	if uint64(buf.Len()) < uint64(errLen) {
+		return *rr, fmt.Errorf("RemoteRPCReplyMsg error %d bytes, only %d left",
+			errLen, buf.Len())
+	}
+	rr.Error = string(buf.Next(int(errLen)))
+	rr.Reply = append([]byte(nil), buf.Bytes()...)
+	return *rr, nil
+}
+
+func (self RemoteRPCReplyMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(U64tB(self.ID))
+	buf.Write(U32tB(uint32(len(self.Error))))
+	buf.WriteString(self.Error)
+	buf.Write(self.Reply)
+	return buf.Bytes()
+}
+
+func (self RemoteRPCReplyMsg) Peer() uint32   { return self.PeerIdx }
+func (self RemoteRPCReplyMsg) MsgType() uint8 { return MSGID_REMOTE_RPCREPLY }
+
+//----------
+
+// CustomMsgTypeMin is the lowest type apps can use for custom messages;
+// the ones below are kept for lit.
+const CustomMsgTypeMin = 0x8000
+
+// CustomMsg is an app's own message, with a type the app picks and
+// whatever data it wants.
+// msgtype
+// Type 2
+// Data (the rest)
+type CustomMsg struct {
+	PeerIdx uint32
+	Type    uint16
+	Data    []byte
+}
+
+func NewCustomMsg(peerIdx uint32, msgType uint16, data []byte) CustomMsg {
+	cm := new(CustomMsg)
+	cm.PeerIdx = peerIdx
+	cm.Type = msgType
+	cm.Data = data
+	return *cm
+}
+
+func NewCustomMsgFromBytes(b []byte, peerIdx uint32) (CustomMsg, error) {
+	cm := new(CustomMsg)
+	cm.PeerIdx = peerIdx
+
+	if len(b) < 3 {
+		return *cm, fmt.Errorf("CustomMsg %d bytes, expect 3 or more", len(b))
+	}
+
+	cm.Type = binary.BigEndian.Uint16(b[1:3])
+	cm.Data = append([]byte(nil), b[3:]...)
+	return *cm, nil
+}
+
+func (self CustomMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	var t [2]byte
+	binary.BigEndian.PutUint16(t[:], self.Type)
+	buf.Write(t[:])
+	buf.Write(self.Data)
+	return buf.Bytes()
+}
+
+func (self CustomMsg) Peer() uint32   { return self.PeerIdx }
+func (self CustomMsg) MsgType() uint8 { return MSGID_CUSTOM }
+
+//----------
+
+// MaxPayReqMemo is the longest memo a payment request can have, in bytes.
+const MaxPayReqMemo = 256
+
+// PayReqMsg asks the peer to push Amt in a channel of CoinType, with Ref as
+// the push's data so the asker can tell it's for this request.
+// msgtype
+// CoinType 4
+// Amt 8
+// Ref 32
+// Memo (the rest)
+type PayReqMsg struct {
+	PeerIdx  uint32
+	CoinType uint32
+	Amt      int64
+	Ref      [32]byte
+	Memo     string
+}
+
+func NewPayReqMsg(peerid, coin uint32, amt int64, ref [32]byte, memo string) PayReqMsg {
+	p := new(PayReqMsg)
+	p.PeerIdx = peerid
+	p.CoinType = coin
+	p.Amt = amt
+	p.Ref = ref
+	p.Memo = memo
+	return *p
+}
+
+func NewPayReqMsgFromBytes(b []byte, peerid uint32) (PayReqMsg, error) {
+	p := new(PayReqMsg)
+	p.PeerIdx = peerid
+
+	if len(b) < 45 {
+		return *p, fmt.Errorf("PayReqMsg %d bytes, expect 45 or more", len(b))
+	}
+	if len(b) > 45+MaxPayReqMemo {
+		return *p, fmt.Errorf("PayReqMsg %d bytes, max %d",
+			len(b), 45+MaxPayReqMemo)
+	}
+
+	p.CoinType = BtU32(b[1:5])
+	p.Amt = BtI64(b[5:13])
+	copy(p.Ref[:], b[13:45])
+	p.Memo = string(b[45:])
+	return *p, nil
+}
+
+func (self PayReqMsg) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(self.MsgType())
+	buf.Write(U32tB(self.CoinType))
+	buf.Write(I64tB(self.Amt))
+	buf.Write(self.Ref[:])
+	buf.WriteString(self.Memo)
+	return buf.Bytes()
+}
+
+func (self PayReqMsg) Peer() uint32   { return self.PeerIdx }
+func (self PayReqMsg) MsgType() uint8 { return MSGID_PAYREQ }
+
+//----------
+
+// PayReqDeclineMsg says the request with Ref won't be paid.
+// msgtype
+// Ref 32
+type PayReqDeclineMsg struct {
+	PeerIdx uint32
+	Ref     [32]byte
+}
+
+func NewPayReqDeclineMsg(peerid uint32, ref [32]byte) PayReqDeclineMsg {
+	p := new(PayReqDeclineMsg)
+	p.PeerIdx = peerid
+	p.Ref = ref
+	return *p
+}
+
+func NewPayReqDeclineMsgFromBytes(b []byte, peerid uint32) (PayReqDeclineMsg, error) {
+	p := new(PayReqDeclineMsg)
+	p.PeerIdx = peerid
+
+	if len(b) != 33 {
+		return *p, fmt.Errorf("PayReqDeclineMsg %d bytes, expect 33", len(b))
+	}
+
+	copy(p.Ref[:], b[1:])
+	return *p, nil
+}
+
+func (self PayReqDeclineMsg) Bytes() []byte {
+	var msg []byte
+	msg = append(msg, self.MsgType())
+	msg = append(msg, self.Ref[:]...)
+	return msg
+}
+
+func (self PayReqDeclineMsg) Peer() uint32   { return self.PeerIdx }
+func (self PayReqDeclineMsg) MsgType() uint8 { return MSGID_PAYREQ_DECLINE }
@@ -41,6 +41,198 @@ func TestChatMsg(t *testing.T) {
 	}
 }
 
+func TestChatIDMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	msg := NewChatIDMsg(peerid, rand.Uint64(), rand.Int63(), "hello")
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+	if msg2.(ChatIDMsg).Text != "hello" {
+		t.Fatalf("text %s came back %s", "hello", msg2.(ChatIDMsg).Text)
+	}
+
+	_, err = LitMsgFromBytes(b[:16], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestChatAckMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	msg := NewChatAckMsg(peerid, rand.Uint64())
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:8], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+func TestNodeAliasMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var pub [33]byte
+	var sig [64]byte
+	rand.Read(pub[:])
+	rand.Read(sig[:])
+
+	msg := NewNodeAliasMsg(peerid, pub, rand.Int63(), "bob's node")
+	msg.Sig = sig
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg.Alias = ""
+	if _, err = LitMsgFromBytes(msg.Bytes(), peerid); err != nil {
+		t.Fatalf("no alias should be fine: %s", err.Error())
+	}
+
+	_, err = LitMsgFromBytes(b[:105], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+
+	if CheckAlias("bob's node") != nil {
+		t.Fatalf("alias should be fine")
+	}
+	if CheckAlias("\x1b[2Jbob") == nil {
+		t.Fatalf("alias with escapes should have errored, but didn't")
+	}
+	if CheckAlias(string(make([]byte, MaxAliasLen+1))) == nil {
+		t.Fatalf("long alias should have errored, but didn't")
+	}
+}
+func TestNodeAddrMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	host := "[2001:db8::1]:2448"
+
+	msg := NewNodeAddrMsg(peerid, host)
+	b := msg.Bytes()
+
+	msg2, err := NewNodeAddrMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:1], peerid) //purposely error to check working
+
+	if err == nil {
+		t.Fatalf("Should have errored NodeAddr Msg, but didn't")
+	}
+}
+
+func TestPingMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	for _, pong := range []bool{false, true} {
+		msg := NewPingMsg(peerid, pong, rand.Uint64())
+		b := msg.Bytes()
+
+		msg2, err := NewPingMsgFromBytes(b, peerid)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !LitMsgEqual(msg, msg2) {
+			t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+		}
+
+		msg3, err := LitMsgFromBytes(b, peerid)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !LitMsgEqual(msg2, msg3) {
+			t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+		}
+
+		_, err = LitMsgFromBytes(b[:5], peerid) //purposely error to check working
+
+		if err == nil {
+			t.Fatalf("Should have errored Ping Msg, but didn't")
+		}
+	}
+}
+
+func TestInitMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	msg := NewInitMsg(peerid, uint16(rand.Uint32()), FeatureBits(rand.Uint64()))
+	b := msg.Bytes()
+
+	msg2, err := NewInitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:5], peerid) //purposely error to check working
+
+	if err == nil {
+		t.Fatalf("Should have errored Init Msg, but didn't")
+	}
+}
+
 func TestPointReqMsg(t *testing.T) {
 	peerid := rand.Uint32()
 	cointype := rand.Uint32()
@@ -265,6 +457,52 @@ func TestSigProofMsg(t *testing.T) {
 	}
 }
 
+func TestCloseRespMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+	_, _ = rand.Read(outPoint[:])
+	op := *OutPointFromBytes(outPoint)
+
+	msg := NewCloseRespMsg(peerid, op, "no such channel")
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) || msg2.(CloseRespMsg).Reason != msg.Reason {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = NewCloseRespMsgFromBytes(b[:36], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestDeltaDeclineMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+	_, _ = rand.Read(outPoint[:])
+	op := *OutPointFromBytes(outPoint)
+
+	msg := NewDeltaDeclineMsg(peerid, op, "plugin: unknown payment")
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !LitMsgEqual(msg, msg2) || msg2.(DeltaDeclineMsg).Reason != msg.Reason {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = NewDeltaDeclineMsgFromBytes(b[:36], peerid)
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
 func TestCloseReqMsg(t *testing.T) {
 	peerid := rand.Uint32()
 	var outPoint [36]byte
@@ -480,6 +718,50 @@ func TestRevMsg(t *testing.T) {
 	}
 }
 
+func TestHTLCSigMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var outPoint [36]byte
+	var rHash, r [32]byte
+	var sig [64]byte
+
+	_, _ = rand.Read(outPoint[:])
+	_, _ = rand.Read(rHash[:])
+	_, _ = rand.Read(r[:])
+	_, _ = rand.Read(sig[:])
+
+	op := *OutPointFromBytes(outPoint)
+
+	msg := NewHTLCSigMsg(peerid, op, HTLCOpSettle, rand.Int63(), rHash,
+		rand.Uint32(), r, sig)
+	b := msg.Bytes()
+
+	msg2, err := NewHTLCSigMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:150], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
 func TestWatchDescMsg(t *testing.T) {
 	peerid := rand.Uint32()
 	cointype := rand.Uint32()
@@ -566,3 +848,448 @@ func TestComMsg(t *testing.T) {
 		t.Fatalf("Should have errored, but didn't")
 	}
 }
+
+func TestWatchTermsMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	msg := NewWatchTermsMsg(peerid, rand.Intn(2) == 0,
+		rand.Int63(), rand.Int63(), rand.Int63())
+	b := msg.Bytes()
+
+	msg2, err := NewWatchTermsMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:20], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestWatchBlobMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	cointype := rand.Uint32()
+	var hint [16]byte
+	blob := make([]byte, 176)
+	_, _ = rand.Read(hint[:])
+	_, _ = rand.Read(blob)
+
+	msg := NewWatchBlobMsg(peerid, cointype, hint, blob)
+	b := msg.Bytes()
+
+	msg2, err := NewWatchBlobMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("from bytes mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	msg3, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg2, msg3) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg2.Bytes(), msg3.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:21], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestWatchDelMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var pkh [20]byte
+	var pub [33]byte
+	_, _ = rand.Read(pkh[:])
+	_, _ = rand.Read(pub[:])
+
+	msg := NewWatchDelMsg(peerid, pkh, pub)
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:53], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestWatchBlobDelMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	hints := make([][16]byte, 5)
+	for i := range hints {
+		_, _ = rand.Read(hints[i][:])
+	}
+
+	msg := NewWatchBlobDelMsg(peerid, hints)
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:40], peerid) //purposely error to check working by sending a partial hint
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestWatchAckMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var hint [16]byte
+	_, _ = rand.Read(hint[:])
+
+	msg := NewWatchAckMsg(peerid, hint)
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:16], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestSwapOfferMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var giveOp, wantOp [36]byte
+	var msg SwapOfferMsg
+
+	_, _ = rand.Read(msg.RHash[:])
+	_, _ = rand.Read(giveOp[:])
+	_, _ = rand.Read(wantOp[:])
+
+	msg.PeerIdx = peerid
+	msg.GiveOutpoint = *OutPointFromBytes(giveOp)
+	msg.GiveAmt = rand.Int63()
+	msg.WantOutpoint = *OutPointFromBytes(wantOp)
+	msg.WantAmt = rand.Int63()
+	msg.Timeout = rand.Uint32()
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:100], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestSwapReplyMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var rHash [32]byte
+	_, _ = rand.Read(rHash[:])
+
+	for _, accept := range []bool{true, false} {
+		msg := NewSwapReplyMsg(peerid, rHash, accept)
+		b := msg.Bytes()
+
+		msg2, err := LitMsgFromBytes(b, peerid)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !LitMsgEqual(msg, msg2) {
+			t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+		}
+		if msg2.(SwapReplyMsg).Accept != accept {
+			t.Fatalf("accept %t came back %t", accept, !accept)
+		}
+
+		_, err = LitMsgFromBytes(b[:20], peerid) //purposely error to check working by not sending enough bytes
+
+		if err == nil {
+			t.Fatalf("Should have errored, but didn't")
+		}
+	}
+}
+
+func TestSubSwapOfferMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var op [36]byte
+	var msg SubSwapOfferMsg
+
+	_, _ = rand.Read(msg.RHash[:])
+	_, _ = rand.Read(op[:])
+	_, _ = rand.Read(msg.Pub[:])
+
+	msg.PeerIdx = peerid
+	msg.Outpoint = *OutPointFromBytes(op)
+	msg.LoopIn = true
+	msg.ChanAmt = rand.Int63()
+	msg.ChainAmt = rand.Int63()
+	msg.Timeout = rand.Uint32()
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:100], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestSubSwapReplyMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var rHash [32]byte
+	var pub [33]byte
+	_, _ = rand.Read(rHash[:])
+	_, _ = rand.Read(pub[:])
+
+	for _, accept := range []bool{true, false} {
+		msg := NewSubSwapReplyMsg(peerid, rHash, accept, pub)
+		b := msg.Bytes()
+
+		msg2, err := LitMsgFromBytes(b, peerid)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !LitMsgEqual(msg, msg2) {
+			t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+		}
+		if msg2.(SubSwapReplyMsg).Accept != accept {
+			t.Fatalf("accept %t came back %t", accept, !accept)
+		}
+
+		_, err = LitMsgFromBytes(b[:40], peerid) //purposely error to check working by not sending enough bytes
+
+		if err == nil {
+			t.Fatalf("Should have errored, but didn't")
+		}
+	}
+}
+
+func TestSubSwapFundMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var rHash [32]byte
+	var op [36]byte
+	var pub [33]byte
+	_, _ = rand.Read(rHash[:])
+	_, _ = rand.Read(op[:])
+	_, _ = rand.Read(pub[:])
+
+	msg := NewSubSwapFundMsg(peerid, rHash, *OutPointFromBytes(op), pub,
+		rand.Uint32())
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:80], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestRemoteRPCMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	msg := NewRemoteRPCMsg(peerid, rand.Uint64(), "ChannelList",
+		[]byte(`{"ChanIdx":3}`))
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+	if msg2.(RemoteRPCMsg).Method != "ChannelList" {
+		t.Fatalf("method %s came back %s", "ChannelList", msg2.(RemoteRPCMsg).Method)
+	}
+
+	_, err = LitMsgFromBytes(b[:12], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestRemoteRPCReplyMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	for _, errStr := range []string{"", "not allowed"} {
+		msg := NewRemoteRPCReplyMsg(peerid, rand.Uint64(),
+			[]byte(`{"Status":"ok"}`), errStr)
+		b := msg.Bytes()
+
+		msg2, err := LitMsgFromBytes(b, peerid)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !LitMsgEqual(msg, msg2) {
+			t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+		}
+		if msg2.(RemoteRPCReplyMsg).Error != errStr {
+			t.Fatalf("error %q came back %q", errStr, msg2.(RemoteRPCReplyMsg).Error)
+		}
+
+		_, err = LitMsgFromBytes(b[:10], peerid) //purposely error to check working by not sending enough bytes
+
+		if err == nil {
+			t.Fatalf("Should have errored, but didn't")
+		}
+	}
+}
+
+func TestCustomMsg(t *testing.T) {
+	peerid := rand.Uint32()
+
+	for _, data := range [][]byte{nil, []byte("hello app")} {
+		msg := NewCustomMsg(peerid, CustomMsgTypeMin+7, data)
+		b := msg.Bytes()
+
+		msg2, err := LitMsgFromBytes(b, peerid)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !LitMsgEqual(msg, msg2) {
+			t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+		}
+		if msg2.(CustomMsg).Type != CustomMsgTypeMin+7 {
+			t.Fatalf("type %d came back %d", CustomMsgTypeMin+7, msg2.(CustomMsg).Type)
+		}
+	}
+
+	_, err := LitMsgFromBytes([]byte{MSGID_CUSTOM, 0x80}, peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestPayReqMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var ref [32]byte
+	rand.Read(ref[:])
+
+	msg := NewPayReqMsg(peerid, 1, 25000, ref, "2 coffees")
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:44], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
+
+func TestPayReqDeclineMsg(t *testing.T) {
+	peerid := rand.Uint32()
+	var ref [32]byte
+	rand.Read(ref[:])
+
+	msg := NewPayReqDeclineMsg(peerid, ref)
+	b := msg.Bytes()
+
+	msg2, err := LitMsgFromBytes(b, peerid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !LitMsgEqual(msg, msg2) {
+		t.Fatalf("interface mismatch:\n%x\n%x\n", msg.Bytes(), msg2.Bytes())
+	}
+
+	_, err = LitMsgFromBytes(b[:32], peerid) //purposely error to check working by not sending enough bytes
+
+	if err == nil {
+		t.Fatalf("Should have errored, but didn't")
+	}
+}
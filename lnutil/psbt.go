@@ -0,0 +1,479 @@
+package lnutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
+)
+
+// A minimal BIP174 partially signed bitcoin transaction: just enough to hand
+// an unsigned tx to some other wallet holding the keys, and take it back
+// signed.  The unsigned tx, the outputs being spent, where the keys are in a
+// bip32 tree, and signatures.  Keys this doesn't know about are dropped when
+// parsing.
+
+// psbtMagic starts every psbt; "psbt" and 0xff
+var psbtMagic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// psbt key types used here
+const (
+	psbtGlobalUnsignedTx = 0x00
+
+	psbtInNonWitnessUtxo = 0x00
+	psbtInWitnessUtxo    = 0x01
+	psbtInPartialSig     = 0x02
+	psbtInBip32          = 0x06
+	psbtInFinalScriptSig = 0x07
+	psbtInFinalWitness   = 0x08
+
+	psbtOutBip32 = 0x02
+)
+
+// PsbtBip32 says where a pubkey comes from: the fingerprint of the master
+// key and the path down from there.
+type PsbtBip32 struct {
+	PubKey      [33]byte
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+// PsbtPartialSig is a signature for an input, sighash byte on the end.
+type PsbtPartialSig struct {
+	PubKey [33]byte
+	Sig    []byte
+}
+
+// PsbtInput has what a signer needs for one input.  For witness inputs
+// WitnessUtxo is enough; others need the whole tx being spent.  Once signed
+// there are PartialSigs, and once finalized, the FinalScriptSig and / or
+// FinalWitness to put in the tx.
+type PsbtInput struct {
+	NonWitnessUtxo *wire.MsgTx
+	WitnessUtxo    *wire.TxOut
+	PartialSigs    []PsbtPartialSig
+	Bip32          []PsbtBip32
+	FinalScriptSig []byte
+	FinalWitness   wire.TxWitness
+}
+
+// PsbtOutput has the key paths for an output, so the signer can tell
+// change from a payment.
+type PsbtOutput struct {
+	Bip32 []PsbtBip32
+}
+
+// Psbt is an unsigned tx along with one PsbtInput for each txin and one
+// PsbtOutput for each txout.
+type Psbt struct {
+	Tx      *wire.MsgTx
+	Inputs  []PsbtInput
+	Outputs []PsbtOutput
+}
+
+// NewPsbt makes an empty psbt around an unsigned tx.
+func NewPsbt(tx *wire.MsgTx) (*Psbt, error) {
+	for i, in := range tx.TxIn {
+		if len(in.SignatureScript) != 0 || len(in.Witness) != 0 {
+			return nil, fmt.Errorf("psbt tx input %d is already signed", i)
+		}
+	}
+	return &Psbt{
+		Tx:      tx,
+		Inputs:  make([]PsbtInput, len(tx.TxIn)),
+		Outputs: make([]PsbtOutput, len(tx.TxOut)),
+	}, nil
+}
+
+// Bytes serializes a psbt.
+func (p *Psbt) Bytes() ([]byte, error) {
+	if len(p.Inputs) != len(p.Tx.TxIn) || len(p.Outputs) != len(p.Tx.TxOut) {
+		return nil, fmt.Errorf("psbt has %d/%d inputs and %d/%d outputs",
+			len(p.Inputs), len(p.Tx.TxIn), len(p.Outputs), len(p.Tx.TxOut))
+	}
+	var buf bytes.Buffer
+	buf.Write(psbtMagic)
+
+	var txBuf bytes.Buffer
+	err := p.Tx.SerializeNoWitness(&txBuf)
+	if err != nil {
+		return nil, err
+	}
+	err = writePsbtKV(&buf, []byte{psbtGlobalUnsignedTx}, txBuf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte(0x00)
+
+	for _, in := range p.Inputs {
+		if in.NonWitnessUtxo != nil {
+			var prevBuf bytes.Buffer
+			err = in.NonWitnessUtxo.Serialize(&prevBuf)
+			if err != nil {
+				return nil, err
+			}
+			err = writePsbtKV(&buf, []byte{psbtInNonWitnessUtxo}, prevBuf.Bytes())
+			if err != nil {
+				return nil, err
+			}
+		}
+		if in.WitnessUtxo != nil {
+			var outBuf bytes.Buffer
+			binary.Write(&outBuf, binary.LittleEndian, in.WitnessUtxo.Value)
+			err = wire.WriteVarBytes(&outBuf, 0, in.WitnessUtxo.PkScript)
+			if err != nil {
+				return nil, err
+			}
+			err = writePsbtKV(&buf, []byte{psbtInWitnessUtxo}, outBuf.Bytes())
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, ps := range in.PartialSigs {
+			err = writePsbtKV(&buf,
+				append([]byte{psbtInPartialSig}, ps.PubKey[:]...), ps.Sig)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = writePsbtBip32(&buf, psbtInBip32, in.Bip32)
+		if err != nil {
+			return nil, err
+		}
+		if in.FinalScriptSig != nil {
+			err = writePsbtKV(&buf, []byte{psbtInFinalScriptSig}, in.FinalScriptSig)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if in.FinalWitness != nil {
+			var witBuf bytes.Buffer
+			err = wire.WriteVarInt(&witBuf, 0, uint64(len(in.FinalWitness)))
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range in.FinalWitness {
+				err = wire.WriteVarBytes(&witBuf, 0, item)
+				if err != nil {
+					return nil, err
+				}
+			}
+			err = writePsbtKV(&buf, []byte{psbtInFinalWitness}, witBuf.Bytes())
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteByte(0x00)
+	}
+
+	for _, out := range p.Outputs {
+		err = writePsbtBip32(&buf, psbtOutBip32, out.Bip32)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(0x00)
+	}
+	return buf.Bytes(), nil
+}
+
+// B64 gives the psbt in base64, the way it usually gets passed around.
+func (p *Psbt) B64() (string, error) {
+	b, err := p.Bytes()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// PsbtFromBytes parses a serialized psbt.
+func PsbtFromBytes(b []byte) (*Psbt, error) {
+	if !bytes.HasPrefix(b, psbtMagic) {
+		return nil, fmt.Errorf("not a psbt; no magic bytes")
+	}
+	buf := bytes.NewReader(b[len(psbtMagic):])
+
+	var p Psbt
+	kvs, err := readPsbtMap(buf)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		if len(kv[0]) == 1 && kv[0][0] == psbtGlobalUnsignedTx {
+			p.Tx = wire.NewMsgTx()
+			err = p.Tx.DeserializeNoWitness(bytes.NewReader(kv[1]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.Tx == nil {
+		return nil, fmt.Errorf("psbt has no unsigned tx")
+	}
+
+	p.Inputs = make([]PsbtInput, len(p.Tx.TxIn))
+	for i := range p.Inputs {
+		kvs, err = readPsbtMap(buf)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvs {
+			switch kv[0][0] {
+			case psbtInNonWitnessUtxo:
+				prevTx := wire.NewMsgTx()
+				err = prevTx.Deserialize(bytes.NewReader(kv[1]))
+				if err != nil {
+					return nil, err
+				}
+				p.Inputs[i].NonWitnessUtxo = prevTx
+			case psbtInWitnessUtxo:
+				if len(kv[1]) < 9 {
+					return nil, fmt.Errorf("psbt input %d witness utxo too short", i)
+				}
+				r := bytes.NewReader(kv[1][8:])
+				script, err := wire.ReadVarBytes(r, 0, 10000, "pkscript")
+				if err != nil {
+					return nil, err
+				}
+				value := int64(binary.LittleEndian.Uint64(kv[1][:8]))
+				p.Inputs[i].WitnessUtxo = wire.NewTxOut(value, script)
+			case psbtInPartialSig:
+				if len(kv[0]) != 34 {
+					return nil, fmt.Errorf("psbt input %d sig key %d bytes", i, len(kv[0]))
+				}
+				var ps PsbtPartialSig
+				copy(ps.PubKey[:], kv[0][1:])
+				ps.Sig = kv[1]
+				p.Inputs[i].PartialSigs = append(p.Inputs[i].PartialSigs, ps)
+			case psbtInBip32:
+				d, err := psbtBip32FromKV(kv)
+				if err != nil {
+					return nil, err
+				}
+				p.Inputs[i].Bip32 = append(p.Inputs[i].Bip32, d)
+			case psbtInFinalScriptSig:
+				p.Inputs[i].FinalScriptSig = kv[1]
+			case psbtInFinalWitness:
+				r := bytes.NewReader(kv[1])
+				n, err := wire.ReadVarInt(r, 0)
+				if err != nil {
+					return nil, err
+				}
+				if n > 500 {
+					return nil, fmt.Errorf("psbt input %d has %d witness items", i, n)
+				}
+				wit := make(wire.TxWitness, n)
+				for j := range wit {
+					wit[j], err = wire.ReadVarBytes(r, 0, 10000, "witness item")
+					if err != nil {
+						return nil, err
+					}
+				}
+				p.Inputs[i].FinalWitness = wit
+			}
+		}
+	}
+
+	p.Outputs = make([]PsbtOutput, len(p.Tx.TxOut))
+	for i := range p.Outputs {
+		kvs, err = readPsbtMap(buf)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvs {
+			if kv[0][0] == psbtOutBip32 {
+				d, err := psbtBip32FromKV(kv)
+				if err != nil {
+					return nil, err
+				}
+				p.Outputs[i].Bip32 = append(p.Outputs[i].Bip32, d)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// PrevOut gives the output input i spends, from the witness utxo or the
+// whole previous tx.
+func (p *Psbt) PrevOut(i int) (*wire.TxOut, error) {
+	in := p.Inputs[i]
+	if in.WitnessUtxo != nil {
+		return in.WitnessUtxo, nil
+	}
+	op := p.Tx.TxIn[i].PreviousOutPoint
+	if in.NonWitnessUtxo == nil || in.NonWitnessUtxo.TxHash() != op.Hash ||
+		int(op.Index) >= len(in.NonWitnessUtxo.TxOut) {
+		return nil, fmt.Errorf("psbt input %d doesn't say what it spends", i)
+	}
+	return in.NonWitnessUtxo.TxOut[op.Index], nil
+}
+
+// Finalize turns the signatures on each input into the scriptSig or
+// witness that goes in the tx.  Only single key inputs, p2wpkh and p2pkh,
+// can be done here; inputs the signer finalized itself are left alone.
+func (p *Psbt) Finalize() error {
+	for i := range p.Inputs {
+		in := &p.Inputs[i]
+		if in.FinalScriptSig != nil || in.FinalWitness != nil {
+			continue
+		}
+		if len(in.PartialSigs) != 1 {
+			return fmt.Errorf("psbt input %d has %d sigs, expect 1",
+				i, len(in.PartialSigs))
+		}
+		prevOut, err := p.PrevOut(i)
+		if err != nil {
+			return err
+		}
+		ps := in.PartialSigs[0]
+		var pkh [20]byte
+		copy(pkh[:], btcutil.Hash160(ps.PubKey[:]))
+		p2pkh, err := PayToPubKeyHashScript(pkh[:])
+		if err != nil {
+			return err
+		}
+		switch {
+		case bytes.Equal(prevOut.PkScript, DirectWPKHScriptFromPKH(pkh)):
+			in.FinalWitness = wire.TxWitness{ps.Sig, ps.PubKey[:]}
+		case bytes.Equal(prevOut.PkScript, p2pkh):
+			in.FinalScriptSig, err = txscript.NewScriptBuilder().
+				AddData(ps.Sig).AddData(ps.PubKey[:]).Script()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("psbt input %d: can't finalize %x with key %x",
+				i, prevOut.PkScript, ps.PubKey)
+		}
+		// finalized inputs don't need these anymore
+		in.PartialSigs = nil
+		in.Bip32 = nil
+	}
+	return nil
+}
+
+// Extract gives the signed tx out of a finalized psbt.
+func (p *Psbt) Extract() (*wire.MsgTx, error) {
+	tx := p.Tx.Copy()
+	for i, in := range p.Inputs {
+		if in.FinalScriptSig == nil && in.FinalWitness == nil {
+			return nil, fmt.Errorf("psbt input %d isn't finalized", i)
+		}
+		tx.TxIn[i].SignatureScript = in.FinalScriptSig
+		tx.TxIn[i].Witness = in.FinalWitness
+	}
+	return tx, nil
+}
+
+// PsbtFromB64 parses a base64 psbt.
+func PsbtFromB64(s string) (*Psbt, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return PsbtFromBytes(b)
+}
+
+// writePsbtKV writes a key and a value, each with a length in front.
+func writePsbtKV(w io.Writer, k, v []byte) error {
+	err := wire.WriteVarBytes(w, 0, k)
+	if err != nil {
+		return err
+	}
+	return wire.WriteVarBytes(w, 0, v)
+}
+
+// writePsbtBip32 writes key paths; the key is the type and the pubkey, the
+// value the fingerprint and the path, little endian.
+func writePsbtBip32(w io.Writer, keyType byte, ds []PsbtBip32) error {
+	for _, d := range ds {
+		v := make([]byte, 4, 4+4*len(d.Path))
+		copy(v, d.Fingerprint[:])
+		for _, step := range d.Path {
+			var stepBytes [4]byte
+			binary.LittleEndian.PutUint32(stepBytes[:], step)
+			v = append(v, stepBytes[:]...)
+		}
+		err := writePsbtKV(w, append([]byte{keyType}, d.PubKey[:]...), v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPsbtMap reads key value pairs up to the 0x00 that ends a map.
+func readPsbtMap(r io.Reader) ([][2][]byte, error) {
+	var kvs [][2][]byte
+	for {
+		k, err := wire.ReadVarBytes(r, 0, 10000, "psbt key")
+		if err != nil {
+			return nil, err
+		}
+		if len(k) == 0 {
+			return kvs, nil
+		}
+		v, err := wire.ReadVarBytes(r, 0, wire.MaxMessagePayload, "psbt value")
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, [2][]byte{k, v})
+	}
+}
+
+// psbtBip32FromKV parses a key path written by writePsbtBip32.
+func psbtBip32FromKV(kv [2][]byte) (PsbtBip32, error) {
+	var d PsbtBip32
+	if len(kv[0]) != 34 || len(kv[1]) < 4 || len(kv[1])%4 != 0 {
+		return d, fmt.Errorf("bad psbt key path; %d byte key, %d byte value",
+			len(kv[0]), len(kv[1]))
+	}
+	copy(d.PubKey[:], kv[0][1:])
+	copy(d.Fingerprint[:], kv[1][:4])
+	for i := 4; i < len(kv[1]); i += 4 {
+		d.Path = append(d.Path, binary.LittleEndian.Uint32(kv[1][i:i+4]))
+	}
+	return d, nil
+}
+
+// Bip32PathFromString parses a path like m/84'/0'/0'.  h works as well as '
+// for hardened steps; "m" or "" is the empty path.
+func Bip32PathFromString(s string) ([]uint32, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "m")
+	var path []uint32
+	for _, step := range strings.Split(s, "/") {
+		if step == "" {
+			continue
+		}
+		var hardened uint32
+		if strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h") {
+			hardened = 1 << 31
+			step = step[:len(step)-1]
+		}
+		n, err := strconv.ParseUint(step, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("bad path step %q: %s", step, err.Error())
+		}
+		path = append(path, uint32(n)|hardened)
+	}
+	return path, nil
+}
+
+// Bip32PathString writes a path the way Bip32PathFromString reads it.
+func Bip32PathString(path []uint32) string {
+	s := "m"
+	for _, step := range path {
+		if step >= 1<<31 {
+			s += fmt.Sprintf("/%d'", step-(1<<31))
+		} else {
+			s += fmt.Sprintf("/%d", step)
+		}
+	}
+	return s
+}
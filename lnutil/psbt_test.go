@@ -0,0 +1,179 @@
+package lnutil
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
+)
+
+func TestPsbtRoundTrip(t *testing.T) {
+	prevTx := wire.NewMsgTx()
+	prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 3}, []byte{0x51}, nil))
+	prevTx.AddTxOut(wire.NewTxOut(90000, bytes.Repeat([]byte{0xaa}, 25)))
+
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}, Index: 1}, nil, nil))
+	prevHash := prevTx.TxHash()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(150000, DirectWPKHScriptFromPKH([20]byte{2})))
+	tx.AddTxOut(wire.NewTxOut(30000, DirectWPKHScriptFromPKH([20]byte{3})))
+
+	p, err := NewPsbt(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Inputs[0].WitnessUtxo = wire.NewTxOut(100000, DirectWPKHScriptFromPKH([20]byte{4}))
+	p.Inputs[0].Bip32 = []PsbtBip32{{
+		PubKey:      [33]byte{0x02, 5},
+		Fingerprint: [4]byte{0xde, 0xad, 0xbe, 0xef},
+		Path:        []uint32{84 | 1<<31, 1 << 31, 1 << 31, 0, 7},
+	}}
+	p.Inputs[1].NonWitnessUtxo = prevTx
+	p.Outputs[1].Bip32 = []PsbtBip32{{
+		PubKey:      [33]byte{0x03, 6},
+		Fingerprint: [4]byte{0xde, 0xad, 0xbe, 0xef},
+		Path:        []uint32{84 | 1<<31, 1 << 31, 1 << 31, 1, 2},
+	}}
+
+	s, err := p.B64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// everyone's psbts start like this
+	if !strings.HasPrefix(s, "cHNidP8B") {
+		t.Fatalf("psbt %s doesn't start with magic", s)
+	}
+
+	p2, err := PsbtFromB64(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2.Tx.TxHash() != tx.TxHash() {
+		t.Fatalf("tx %s came back as %s", tx.TxHash(), p2.Tx.TxHash())
+	}
+	if p2.Inputs[1].NonWitnessUtxo.TxHash() != prevHash {
+		t.Fatalf("non-witness utxo didn't come back")
+	}
+	p2.Inputs[1].NonWitnessUtxo = prevTx
+	p2.Tx = tx
+	if !reflect.DeepEqual(p, p2) {
+		t.Fatalf("psbt changed in round trip:\n%+v\n%+v", p, p2)
+	}
+}
+
+func TestNewPsbtSigned(t *testing.T) {
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, [][]byte{{1}}))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	_, err := NewPsbt(tx)
+	if err == nil {
+		t.Fatalf("made a psbt with a signed input")
+	}
+}
+
+func TestBip32Path(t *testing.T) {
+	path, err := Bip32PathFromString("m/84'/1h/0'/1/20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{84 | 1<<31, 1 | 1<<31, 1 << 31, 1, 20}
+	if !reflect.DeepEqual(path, want) {
+		t.Fatalf("path %v, expect %v", path, want)
+	}
+	if Bip32PathString(path) != "m/84'/1'/0'/1/20" {
+		t.Fatalf("path string %s", Bip32PathString(path))
+	}
+
+	path, err = Bip32PathFromString("m")
+	if err != nil || len(path) != 0 {
+		t.Fatalf("m gave path %v, err %v", path, err)
+	}
+	_, err = Bip32PathFromString("m/2147483648")
+	if err == nil {
+		t.Fatalf("step too big for non-hardened parsed")
+	}
+}
+
+func TestPsbtFinalize(t *testing.T) {
+	var pub [33]byte
+	pub[0] = 0x02
+	pub[1] = 7
+	var pkh [20]byte
+	copy(pkh[:], btcutil.Hash160(pub[:]))
+	p2pkh, _ := PayToPubKeyHashScript(pkh[:])
+	sig := append(bytes.Repeat([]byte{0x30}, 70), 0x01)
+
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}}, nil, nil))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{2}}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	p, err := NewPsbt(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Inputs[0].WitnessUtxo = wire.NewTxOut(2000, DirectWPKHScriptFromPKH(pkh))
+	p.Inputs[1].WitnessUtxo = wire.NewTxOut(2000, p2pkh)
+	_, err = p.Extract()
+	if err == nil {
+		t.Fatalf("extracted an unsigned psbt")
+	}
+	if p.Finalize() == nil {
+		t.Fatalf("finalized with no sigs")
+	}
+
+	for i := range p.Inputs {
+		p.Inputs[i].PartialSigs = []PsbtPartialSig{{PubKey: pub, Sig: sig}}
+	}
+	// sigs have to survive the trip back from the signer
+	s, err := p.B64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = PsbtFromB64(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = p.B64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = PsbtFromB64(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := p.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := signed.Copy()
+	for _, in := range stripped.TxIn {
+		in.SignatureScript, in.Witness = nil, nil
+	}
+	if stripped.TxHash() != tx.TxHash() {
+		t.Fatalf("signed tx %s isn't unsigned tx %s", stripped.TxHash(), tx.TxHash())
+	}
+	wantWit := wire.TxWitness{sig, pub[:]}
+	if !reflect.DeepEqual(signed.TxIn[0].Witness, wantWit) ||
+		len(signed.TxIn[0].SignatureScript) != 0 {
+		t.Fatalf("p2wpkh input finalized as %x / %x",
+			signed.TxIn[0].SignatureScript, signed.TxIn[0].Witness)
+	}
+	wantScript, _ := txscript.NewScriptBuilder().AddData(sig).AddData(pub[:]).Script()
+	if !bytes.Equal(signed.TxIn[1].SignatureScript, wantScript) ||
+		len(signed.TxIn[1].Witness) != 0 {
+		t.Fatalf("p2pkh input finalized as %x / %x",
+			signed.TxIn[1].SignatureScript, signed.TxIn[1].Witness)
+	}
+}
@@ -0,0 +1,253 @@
+package lnutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/mit-dci/lit/sig64"
+)
+
+/* BIP340 Schnorr signatures
+
+Signatures are 64 bytes, R.x then s, which is the same size as the
+compressed ECDSA sigs from sig64.  So anywhere we store or send a [64]byte
+sig, it can be either kind; the sig type of the script being spent decides
+which one it is.  Pubkeys are "x-only", 32 bytes, and always refer to the
+point with the even y coordinate.
+
+The message being signed is a 32 byte hash.  Note that BIP341 (taproot)
+sighashes are different from the segwit v0 sighashes we use now; it's up to
+the caller to feed in the right one.
+*/
+
+const (
+	// SigTypeECDSA is the DER-style ECDSA sig used by all segwit v0 scripts.
+	SigTypeECDSA = uint8(0)
+	// SigTypeSchnorr is a BIP340 sig, used by witness v1 (taproot) outputs.
+	SigTypeSchnorr = uint8(1)
+)
+
+// SigTypeForScript returns the kind of signature needed to spend a pkscript.
+// Witness v1 outputs with a 32 byte program need schnorr, everything else
+// gets ECDSA.
+func SigTypeForScript(pkScript []byte) uint8 {
+	// OP_1 (0x51), then push 32 bytes
+	if len(pkScript) == 34 && pkScript[0] == 0x51 && pkScript[1] == 0x20 {
+		return SigTypeSchnorr
+	}
+	return SigTypeECDSA
+}
+
+// TaggedHash is the BIP340 tagged hash: sha256(sha256(tag)|sha256(tag)|msg)
+func TaggedHash(tag string, msgs ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, m := range msgs {
+		h.Write(m)
+	}
+	var out [32]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// XOnlyPub returns the 32 byte x-only pubkey for a private key.
+func XOnlyPub(priv *btcec.PrivateKey) (xPub [32]byte) {
+	x, _ := btcec.S256().ScalarBaseMult(priv.D.Bytes())
+	copy(xPub[:], BigIntToEncodedBytes(x)[:])
+	return
+}
+
+// XOnlyFromPub drops the y coordinate from a 33 byte compressed pubkey.
+func XOnlyFromPub(pub [33]byte) (xPub [32]byte) {
+	copy(xPub[:], pub[1:])
+	return
+}
+
+// liftX gets the point with even y for an x-only pubkey.
+func liftX(xPub [32]byte) (*btcec.PublicKey, error) {
+	var cpub [33]byte
+	cpub[0] = 0x02
+	copy(cpub[1:], xPub[:])
+	return btcec.ParsePubKey(cpub[:], btcec.S256())
+}
+
+// SchnorrSign makes a BIP340 signature of hash with priv.  aux is the
+// auxiliary randomness; all zeros is fine (and deterministic), but fresh
+// random bytes give some protection against side channels.
+func SchnorrSign(
+	priv *btcec.PrivateKey, hash [32]byte, aux [32]byte) (sig [64]byte, err error) {
+
+	curve := btcec.S256()
+	n := curve.N
+
+	if priv == nil || priv.D.Sign() == 0 || priv.D.Cmp(n) >= 0 {
+		err = fmt.Errorf("SchnorrSign: invalid private key")
+		return
+	}
+
+	// negate d if P would have odd y
+	px, py := curve.ScalarBaseMult(priv.D.Bytes())
+	d := new(big.Int).Set(priv.D)
+	if py.Bit(0) == 1 {
+		d.Sub(n, d)
+	}
+	pxBytes := BigIntToEncodedBytes(px)
+
+	// mask d with the hashed aux data to get t
+	auxHash := TaggedHash("BIP0340/aux", aux[:])
+	t := BigIntToEncodedBytes(d)
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+
+	rand := TaggedHash("BIP0340/nonce", t[:], pxBytes[:], hash[:])
+	k := new(big.Int).SetBytes(rand[:])
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		err = fmt.Errorf("SchnorrSign: nonce is zero")
+		return
+	}
+
+	rx, ry := curve.ScalarBaseMult(k.Bytes())
+	if ry.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+	rxBytes := BigIntToEncodedBytes(rx)
+
+	e := schnorrChallenge(rxBytes[:], pxBytes[:], hash)
+
+	// s = k + e*d mod n
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+
+	copy(sig[:32], rxBytes[:])
+	copy(sig[32:], BigIntToEncodedBytes(s)[:])
+
+	// verify before handing it out, like BIP340 recommends
+	var xPub [32]byte
+	copy(xPub[:], pxBytes[:])
+	if !SchnorrVerify(xPub, hash, sig) {
+		err = fmt.Errorf("SchnorrSign: created sig doesn't verify")
+	}
+	return
+}
+
+// SchnorrVerify checks a BIP340 signature of hash by x-only pubkey xPub.
+func SchnorrVerify(xPub [32]byte, hash [32]byte, sig [64]byte) bool {
+	curve := btcec.S256()
+
+	pub, err := liftX(xPub)
+	if err != nil {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	if r.Cmp(curve.P) >= 0 {
+		return false
+	}
+	s := new(big.Int).SetBytes(sig[32:])
+	if s.Cmp(curve.N) >= 0 {
+		return false
+	}
+
+	e := schnorrChallenge(sig[:32], xPub[:], hash)
+
+	// R = sG - eP, done as sG + (n-e)P
+	sgx, sgy := curve.ScalarBaseMult(s.Bytes())
+	negE := new(big.Int).Sub(curve.N, e)
+	epx, epy := curve.ScalarMult(pub.X, pub.Y, negE.Bytes())
+	rx, ry := curve.Add(sgx, sgy, epx, epy)
+
+	// fail on point at infinity
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+	if ry.Bit(0) == 1 {
+		return false
+	}
+	return rx.Cmp(r) == 0
+}
+
+// schnorrChallenge is e = int(hash(R.x | P.x | m)) mod n
+func schnorrChallenge(rx, px []byte, hash [32]byte) *big.Int {
+	eHash := TaggedHash("BIP0340/challenge", rx, px, hash[:])
+	e := new(big.Int).SetBytes(eHash[:])
+	return e.Mod(e, btcec.S256().N)
+}
+
+// SignHash signs a 32 byte sighash with whichever scheme sigType says,
+// returning a 64 byte sig.  ECDSA sigs come back sig64 compressed, without
+// the sighash type byte.
+func SignHash(
+	sigType uint8, priv *btcec.PrivateKey, hash []byte) (sig [64]byte, err error) {
+
+	if len(hash) != 32 {
+		err = fmt.Errorf("SignHash: got %d byte hash, expect 32", len(hash))
+		return
+	}
+	switch sigType {
+	case SigTypeECDSA:
+		ecSig, err := priv.Sign(hash)
+		if err != nil {
+			return sig, err
+		}
+		return sig64.SigCompress(ecSig.Serialize())
+	case SigTypeSchnorr:
+		var h, aux [32]byte
+		copy(h[:], hash)
+		return SchnorrSign(priv, h, aux)
+	}
+	err = fmt.Errorf("SignHash: unknown sig type %d", sigType)
+	return
+}
+
+// VerifyHash checks a 64 byte sig (of either type) on a 32 byte sighash.
+// Sigs that were good before aren't checked again; see sigcache.go.
+func VerifyHash(sigType uint8, pub [33]byte, hash []byte, sig [64]byte) error {
+	cache := getSigCache()
+	if cache.Exists(sigType, pub, hash, sig) {
+		return nil
+	}
+	err := verifyHash(sigType, pub, hash, sig)
+	if err != nil {
+		return err
+	}
+	cache.Add(sigType, pub, hash, sig)
+	return nil
+}
+
+// verifyHash is VerifyHash without the cache.
+func verifyHash(sigType uint8, pub [33]byte, hash []byte, sig [64]byte) error {
+	if len(hash) != 32 {
+		return fmt.Errorf("VerifyHash: got %d byte hash, expect 32", len(hash))
+	}
+	switch sigType {
+	case SigTypeECDSA:
+		pSig, err := btcec.ParseDERSignature(
+			sig64.SigDecompress(sig), btcec.S256())
+		if err != nil {
+			return err
+		}
+		pubKey, err := btcec.ParsePubKey(pub[:], btcec.S256())
+		if err != nil {
+			return err
+		}
+		if !pSig.Verify(hash, pubKey) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+		return nil
+	case SigTypeSchnorr:
+		var h [32]byte
+		copy(h[:], hash)
+		if !SchnorrVerify(XOnlyFromPub(pub), h, sig) {
+			return fmt.Errorf("invalid schnorr signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("VerifyHash: unknown sig type %d", sigType)
+}
@@ -0,0 +1,123 @@
+package lnutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+)
+
+// test vectors 0 and 1 from BIP340
+var bip340Vectors = []struct {
+	priv, pub, aux, msg, sig string
+}{
+	{
+		"0000000000000000000000000000000000000000000000000000000000000003",
+		"F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA8215" +
+			"25F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+	},
+	{
+		"B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+		"DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+		"6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE3341" +
+			"8906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+	},
+}
+
+func hex32(t *testing.T, s string) (b [32]byte) {
+	d, err := hex.DecodeString(s)
+	if err != nil || len(d) != 32 {
+		t.Fatalf("bad test hex %s", s)
+	}
+	copy(b[:], d)
+	return
+}
+
+// SchnorrSign, SchnorrVerify
+func TestSchnorrVectors(t *testing.T) {
+	for i, v := range bip340Vectors {
+		privBytes := hex32(t, v.priv)
+		priv, _ := btcec.PrivKeyFromBytes(btcec.S256(), privBytes[:])
+
+		xPub := XOnlyPub(priv)
+		if xPub != hex32(t, v.pub) {
+			t.Fatalf("vector %d: pubkey %x mismatch", i, xPub)
+		}
+
+		sig, err := SchnorrSign(priv, hex32(t, v.msg), hex32(t, v.aux))
+		if err != nil {
+			t.Fatalf("vector %d: %s", i, err.Error())
+		}
+		wantSig, _ := hex.DecodeString(v.sig)
+		if !bytes.Equal(sig[:], wantSig) {
+			t.Fatalf("vector %d: sig %x, want %x", i, sig, wantSig)
+		}
+
+		if !SchnorrVerify(xPub, hex32(t, v.msg), sig) {
+			t.Fatalf("vector %d: sig doesn't verify", i)
+		}
+
+		// flip a bit of s; should fail
+		sig[63] ^= 0x01
+		if SchnorrVerify(xPub, hex32(t, v.msg), sig) {
+			t.Fatalf("vector %d: modified sig verifies", i)
+		}
+	}
+}
+
+// SignHash, VerifyHash
+func TestSignVerifyHash(t *testing.T) {
+	priv, _ := btcec.PrivKeyFromBytes(btcec.S256(), privKeyB1)
+	var pub [33]byte
+	copy(pub[:], priv.PubKey().SerializeCompressed())
+
+	hash := chainhash.DoubleHashB([]byte("lit"))
+
+	for _, sigType := range []uint8{SigTypeECDSA, SigTypeSchnorr} {
+		sig, err := SignHash(sigType, priv, hash)
+		if err != nil {
+			t.Fatalf("type %d sign: %s", sigType, err.Error())
+		}
+		err = VerifyHash(sigType, pub, hash, sig)
+		if err != nil {
+			t.Fatalf("type %d verify: %s", sigType, err.Error())
+		}
+		// other hash shouldn't verify
+		err = VerifyHash(sigType, pub, chainhash.DoubleHashB(hash), sig)
+		if err == nil {
+			t.Fatalf("type %d: sig verifies for wrong hash", sigType)
+		}
+	}
+
+	// a schnorr sig isn't an ECDSA sig
+	sig, _ := SignHash(SigTypeSchnorr, priv, hash)
+	if VerifyHash(SigTypeECDSA, pub, hash, sig) == nil {
+		t.Fatalf("schnorr sig verifies as ECDSA")
+	}
+
+	if VerifyHash(7, pub, hash, sig) == nil {
+		t.Fatalf("unknown sig type accepted")
+	}
+}
+
+// SigTypeForScript
+func TestSigTypeForScript(t *testing.T) {
+	v1 := append([]byte{0x51, 0x20}, make([]byte, 32)...)
+	if SigTypeForScript(v1) != SigTypeSchnorr {
+		t.Fatalf("witness v1 script should need schnorr")
+	}
+	v0 := append([]byte{0x00, 0x20}, make([]byte, 32)...)
+	if SigTypeForScript(v0) != SigTypeECDSA {
+		t.Fatalf("witness v0 script should need ECDSA")
+	}
+	if SigTypeForScript(nil) != SigTypeECDSA {
+		t.Fatalf("empty script should default to ECDSA")
+	}
+}
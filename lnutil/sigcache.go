@@ -0,0 +1,269 @@
+package lnutil
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"runtime"
+	"sync"
+
+	"github.com/adiabat/btcd/btcec"
+)
+
+/* Signature caching and batching
+
+The same sig gets checked more than once: a peer resends a state after a
+reconnect, a channel gets reloaded and its state checked again, and so on.
+VerifyHash remembers sigs that were good, keyed by everything that went into
+checking them, and doesn't check those again.  Only good sigs are kept, so
+nobody can fill the cache with junk.  When it's full, a random entry makes
+room, like btcd's sig cache.
+
+VerifyBatch checks a bunch of sigs at once.  Schnorr sigs get checked in one
+go, as in BIP340: with random a_i (a_0 = 1), all of them are good if
+  (sum a_i*s_i)G == sum a_i*R_i + sum (a_i*e_i)P_i
+where the P_i terms with the same pubkey are added up first, so a batch
+from one signer costs about half what checking them one by one does.  If
+the batch fails, they're checked one by one to find the bad one.  ECDSA
+sigs can't be batched that way, so they're spread over the CPUs.
+*/
+
+// DefaultSigCacheSize is how many good sigs VerifyHash remembers.
+const DefaultSigCacheSize = 50000
+
+// SigCache remembers sigs that verified.
+type SigCache struct {
+	mtx   sync.RWMutex
+	valid map[[32]byte]struct{}
+	max   int
+}
+
+// NewSigCache makes a sig cache holding up to max sigs.
+func NewSigCache(max int) *SigCache {
+	return &SigCache{valid: make(map[[32]byte]struct{}), max: max}
+}
+
+// sigCacheKey commits to everything that went into checking a sig.
+func sigCacheKey(sigType uint8, pub [33]byte, hash []byte, sig [64]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte{sigType})
+	h.Write(pub[:])
+	h.Write(hash)
+	h.Write(sig[:])
+	var k [32]byte
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// Exists says whether a sig is known to be good.
+func (c *SigCache) Exists(sigType uint8, pub [33]byte, hash []byte, sig [64]byte) bool {
+	k := sigCacheKey(sigType, pub, hash, sig)
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	_, ok := c.valid[k]
+	return ok
+}
+
+// Add remembers a good sig, dropping a random one if the cache is full.
+func (c *SigCache) Add(sigType uint8, pub [33]byte, hash []byte, sig [64]byte) {
+	if c.max <= 0 {
+		return
+	}
+	k := sigCacheKey(sigType, pub, hash, sig)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.valid) >= c.max {
+		// map iteration order is random enough
+		for old := range c.valid {
+			delete(c.valid, old)
+			break
+		}
+	}
+	c.valid[k] = struct{}{}
+}
+
+// Len is how many sigs are cached.
+func (c *SigCache) Len() int {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return len(c.valid)
+}
+
+var (
+	sigCacheMtx sync.Mutex
+	sigCache    = NewSigCache(DefaultSigCacheSize)
+)
+
+// SetSigCacheSize changes how many good sigs VerifyHash remembers, emptying
+// the cache.  0 turns it off.
+func SetSigCacheSize(n int) {
+	sigCacheMtx.Lock()
+	defer sigCacheMtx.Unlock()
+	sigCache = NewSigCache(n)
+}
+
+func getSigCache() *SigCache {
+	sigCacheMtx.Lock()
+	defer sigCacheMtx.Unlock()
+	return sigCache
+}
+
+// SigCheck is a sig to check in a batch.
+type SigCheck struct {
+	SigType uint8
+	Pub     [33]byte
+	Hash    []byte
+	Sig     [64]byte
+}
+
+// VerifyBatch checks a bunch of sigs, returning an error for the first bad
+// one.  Good ones go in the sig cache, and ones already there aren't
+// checked again.
+func VerifyBatch(checks []SigCheck) error {
+	cache := getSigCache()
+	var ecdsa, schnorr []int
+	for i, sc := range checks {
+		if len(sc.Hash) != 32 {
+			return fmt.Errorf("sig %d: got %d byte hash, expect 32", i, len(sc.Hash))
+		}
+		if cache.Exists(sc.SigType, sc.Pub, sc.Hash, sc.Sig) {
+			continue
+		}
+		switch sc.SigType {
+		case SigTypeECDSA:
+			ecdsa = append(ecdsa, i)
+		case SigTypeSchnorr:
+			schnorr = append(schnorr, i)
+		default:
+			return fmt.Errorf("sig %d: unknown sig type %d", i, sc.SigType)
+		}
+	}
+
+	if len(schnorr) > 1 && schnorrBatchOK(checks, schnorr) {
+		for _, i := range schnorr {
+			cache.Add(checks[i].SigType, checks[i].Pub, checks[i].Hash, checks[i].Sig)
+		}
+	} else {
+		// one's bad, or there's just one; check them separately
+		ecdsa = append(ecdsa, schnorr...)
+	}
+	bad := verifyParallel(checks, ecdsa)
+	if bad != -1 {
+		sc := checks[bad]
+		err := verifyHash(sc.SigType, sc.Pub, sc.Hash, sc.Sig)
+		return fmt.Errorf("sig %d: %s", bad, err.Error())
+	}
+	return nil
+}
+
+// verifyParallel checks the sigs at idxs over all the CPUs, returning the
+// lowest bad index or -1.  Good ones are cached by VerifyHash.
+func verifyParallel(checks []SigCheck, idxs []int) int {
+	workers := runtime.NumCPU()
+	if workers > len(idxs) {
+		workers = len(idxs)
+	}
+	bad := -1
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				sc := checks[i]
+				if VerifyHash(sc.SigType, sc.Pub, sc.Hash, sc.Sig) != nil {
+					mtx.Lock()
+					if bad == -1 || i < bad {
+						bad = i
+					}
+					mtx.Unlock()
+				}
+			}
+		}()
+	}
+	for _, i := range idxs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return bad
+}
+
+// schnorrBatchOK checks the schnorr sigs at idxs all at once.
+func schnorrBatchOK(checks []SigCheck, idxs []int) bool {
+	curve := btcec.S256()
+	n := curve.N
+
+	sSum := new(big.Int)
+	var rhsX, rhsY *big.Int
+	add := func(x, y *big.Int) {
+		if rhsX == nil {
+			rhsX, rhsY = x, y
+			return
+		}
+		rhsX, rhsY = curve.Add(rhsX, rhsY, x, y)
+	}
+	// a_i*e_i summed per pubkey
+	pubScalars := make(map[[32]byte]*big.Int)
+	var pubOrder [][32]byte
+
+	for j, i := range idxs {
+		sc := checks[i]
+		xPub := XOnlyFromPub(sc.Pub)
+		var rx [32]byte
+		copy(rx[:], sc.Sig[:32])
+		r := new(big.Int).SetBytes(rx[:])
+		s := new(big.Int).SetBytes(sc.Sig[32:])
+		if r.Cmp(curve.P) >= 0 || s.Cmp(n) >= 0 {
+			return false
+		}
+		// R is the point with x = r and even y
+		bigR, err := liftX(rx)
+		if err != nil {
+			return false
+		}
+
+		a := big.NewInt(1)
+		if j != 0 {
+			a, err = rand.Int(rand.Reader, new(big.Int).Sub(n, big.NewInt(1)))
+			if err != nil {
+				return false
+			}
+			a.Add(a, big.NewInt(1))
+		}
+
+		var h [32]byte
+		copy(h[:], sc.Hash)
+		e := schnorrChallenge(rx[:], xPub[:], h)
+
+		sSum.Add(sSum, new(big.Int).Mul(a, s))
+		sSum.Mod(sSum, n)
+
+		add(curve.ScalarMult(bigR.X, bigR.Y, a.Bytes()))
+
+		ae := new(big.Int).Mul(a, e)
+		ae.Mod(ae, n)
+		sum, ok := pubScalars[xPub]
+		if !ok {
+			sum = new(big.Int)
+			pubScalars[xPub] = sum
+			pubOrder = append(pubOrder, xPub)
+		}
+		sum.Add(sum, ae)
+		sum.Mod(sum, n)
+	}
+
+	for _, xPub := range pubOrder {
+		pub, err := liftX(xPub)
+		if err != nil {
+			return false
+		}
+		add(curve.ScalarMult(pub.X, pub.Y, pubScalars[xPub].Bytes()))
+	}
+
+	lhsX, lhsY := curve.ScalarBaseMult(sSum.Bytes())
+	return lhsX.Cmp(rhsX) == 0 && lhsY.Cmp(rhsY) == 0
+}
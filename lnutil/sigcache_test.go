@@ -0,0 +1,95 @@
+package lnutil
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/adiabat/btcd/btcec"
+)
+
+// makeChecks signs n hashes with each sig type, with two keys.
+func makeChecks(t *testing.T, n int) []SigCheck {
+	var privs []*btcec.PrivateKey
+	for i := 0; i < 2; i++ {
+		priv, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatal(err)
+		}
+		privs = append(privs, priv)
+	}
+	var checks []SigCheck
+	for i := 0; i < n; i++ {
+		for _, sigType := range []uint8{SigTypeECDSA, SigTypeSchnorr} {
+			priv := privs[i%2]
+			hash := sha256.Sum256([]byte{byte(i), sigType})
+			sig, err := SignHash(sigType, priv, hash[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pub [33]byte
+			copy(pub[:], priv.PubKey().SerializeCompressed())
+			checks = append(checks,
+				SigCheck{SigType: sigType, Pub: pub, Hash: hash[:], Sig: sig})
+		}
+	}
+	return checks
+}
+
+// VerifyBatch with good sigs, then one bad one
+func TestVerifyBatch(t *testing.T) {
+	SetSigCacheSize(0)
+	defer SetSigCacheSize(DefaultSigCacheSize)
+
+	checks := makeChecks(t, 6)
+	err := VerifyBatch(checks)
+	if err != nil {
+		t.Fatalf("good batch: %s", err.Error())
+	}
+
+	for _, bad := range []int{3, 6} {
+		checks[bad].Sig[40] ^= 1
+		err = VerifyBatch(checks)
+		if err == nil {
+			t.Fatalf("batch with bad sig %d verified", bad)
+		}
+		checks[bad].Sig[40] ^= 1
+	}
+
+	// a sig on the wrong hash
+	checks[5].Hash = checks[7].Hash
+	if VerifyBatch(checks) == nil {
+		t.Fatalf("batch with sig on wrong hash verified")
+	}
+}
+
+// good sigs get cached, bad ones don't, and the cache stays under max
+func TestSigCache(t *testing.T) {
+	SetSigCacheSize(4)
+	defer SetSigCacheSize(DefaultSigCacheSize)
+
+	checks := makeChecks(t, 4)
+	sc := checks[0]
+	err := VerifyHash(sc.SigType, sc.Pub, sc.Hash, sc.Sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !getSigCache().Exists(sc.SigType, sc.Pub, sc.Hash, sc.Sig) {
+		t.Fatalf("good sig not cached")
+	}
+
+	sc.Sig[40] ^= 1
+	if VerifyHash(sc.SigType, sc.Pub, sc.Hash, sc.Sig) == nil {
+		t.Fatalf("bad sig verified")
+	}
+	if getSigCache().Exists(sc.SigType, sc.Pub, sc.Hash, sc.Sig) {
+		t.Fatalf("bad sig cached")
+	}
+
+	err = VerifyBatch(checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getSigCache().Len() != 4 {
+		t.Fatalf("cache has %d sigs, max 4", getSigCache().Len())
+	}
+}
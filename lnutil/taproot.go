@@ -0,0 +1,222 @@
+package lnutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/adiabat/bech32"
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/wire"
+)
+
+/* Taproot (BIP341) key path outputs
+
+The wallet only makes key path outputs: no script tree.  The output key is
+the wallet's usual HD pubkey tweaked by hash_TapTweak(P.x), and the
+pkscript is OP_1 <32 byte output key>.  Spending takes a single 64 byte
+schnorr sig on the BIP341 sighash, with the private key tweaked the same
+way.
+
+Witness v1 addresses use bech32m (BIP350) instead of bech32; the checksum
+constant is different, so the adiabat/bech32 package can't do them.
+*/
+
+// bech32mConst is what a valid bech32m string's checksum polymod comes to.
+const bech32mConst = 0x2bc830a3
+
+// taprootCharset is the bech32 alphabet, same as in the bech32 package.
+const taprootCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// P2TRScript makes a witness v1 pkscript from a 32 byte output key.
+func P2TRScript(outKey [32]byte) []byte {
+	// OP_1 (0x51), then push 32 bytes
+	return append([]byte{0x51, 0x20}, outKey[:]...)
+}
+
+// TaprootKeyFromPkScript returns the output key of a witness v1 pkscript,
+// or nil if it isn't one.
+func TaprootKeyFromPkScript(pkScript []byte) []byte {
+	if SigTypeForScript(pkScript) != SigTypeSchnorr {
+		return nil
+	}
+	return pkScript[2:]
+}
+
+// taprootTweak is the BIP341 tweak for a key with no script tree.
+func taprootTweak(xPub [32]byte) *big.Int {
+	tHash := TaggedHash("TapTweak", xPub[:])
+	return new(big.Int).SetBytes(tHash[:])
+}
+
+// TaprootOutputKey tweaks an internal pubkey into the x-only key that goes
+// in a key path only taproot output.
+func TaprootOutputKey(pub *btcec.PublicKey) (outKey [32]byte, err error) {
+	curve := btcec.S256()
+	var cpub [33]byte
+	copy(cpub[:], pub.SerializeCompressed())
+	xPub := XOnlyFromPub(cpub)
+
+	t := taprootTweak(xPub)
+	if t.Cmp(curve.N) >= 0 {
+		err = fmt.Errorf("TaprootOutputKey: tweak out of range")
+		return
+	}
+	p, err := liftX(xPub)
+	if err != nil {
+		return
+	}
+	tx, ty := curve.ScalarBaseMult(t.Bytes())
+	qx, qy := curve.Add(p.X, p.Y, tx, ty)
+	if qx.Sign() == 0 && qy.Sign() == 0 {
+		err = fmt.Errorf("TaprootOutputKey: output key at infinity")
+		return
+	}
+	copy(outKey[:], BigIntToEncodedBytes(qx)[:])
+	return
+}
+
+// TaprootTweakPriv gives the private key for the output key that
+// TaprootOutputKey makes from priv's pubkey.
+func TaprootTweakPriv(priv *btcec.PrivateKey) (*btcec.PrivateKey, error) {
+	curve := btcec.S256()
+	if priv == nil || priv.D.Sign() == 0 {
+		return nil, fmt.Errorf("TaprootTweakPriv: invalid private key")
+	}
+
+	// the internal key is the even y one, so negate d if P has odd y
+	d := new(big.Int).Set(priv.D)
+	if priv.PubKey().Y.Bit(0) == 1 {
+		d.Sub(curve.N, d)
+	}
+	t := taprootTweak(XOnlyPub(priv))
+	if t.Cmp(curve.N) >= 0 {
+		return nil, fmt.Errorf("TaprootTweakPriv: tweak out of range")
+	}
+	d.Add(d, t)
+	d.Mod(d, curve.N)
+	if d.Sign() == 0 {
+		return nil, fmt.Errorf("TaprootTweakPriv: tweaked key is zero")
+	}
+
+	tweaked, _ := btcec.PrivKeyFromBytes(curve, BigIntToEncodedBytes(d)[:])
+	return tweaked, nil
+}
+
+// TaprootSigHash computes the BIP341 key path sighash, SIGHASH_DEFAULT
+// (everything), for input idx of tx.  Unlike segwit v0, this commits to
+// the amount and pkscript of every input, so prevOuts needs all of them in
+// txin order.
+func TaprootSigHash(
+	tx *wire.MsgTx, idx int, prevOuts []*wire.TxOut) ([32]byte, error) {
+
+	var empty [32]byte
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return empty, fmt.Errorf("TaprootSigHash: input %d of %d",
+			idx, len(tx.TxIn))
+	}
+	if len(prevOuts) != len(tx.TxIn) {
+		return empty, fmt.Errorf("TaprootSigHash: %d prevouts for %d inputs",
+			len(prevOuts), len(tx.TxIn))
+	}
+
+	var ops, amts, scripts, seqs, outs bytes.Buffer
+	for i, in := range tx.TxIn {
+		if prevOuts[i] == nil {
+			return empty, fmt.Errorf("TaprootSigHash: nil prevout %d", i)
+		}
+		opBytes := OutPointToBytes(in.PreviousOutPoint)
+		ops.Write(opBytes[:32])
+		binary.Write(&ops, binary.LittleEndian, in.PreviousOutPoint.Index)
+		binary.Write(&amts, binary.LittleEndian, prevOuts[i].Value)
+		wire.WriteVarBytes(&scripts, 0, prevOuts[i].PkScript)
+		binary.Write(&seqs, binary.LittleEndian, in.Sequence)
+	}
+	for _, out := range tx.TxOut {
+		wire.WriteTxOut(&outs, 0, 0, out)
+	}
+
+	var msg bytes.Buffer
+	msg.WriteByte(0x00) // sighash epoch
+	msg.WriteByte(0x00) // SIGHASH_DEFAULT
+	binary.Write(&msg, binary.LittleEndian, tx.Version)
+	binary.Write(&msg, binary.LittleEndian, tx.LockTime)
+	for _, b := range [][]byte{ops.Bytes(), amts.Bytes(), scripts.Bytes(),
+		seqs.Bytes(), outs.Bytes()} {
+		h := sha256.Sum256(b)
+		msg.Write(h[:])
+	}
+	msg.WriteByte(0x00) // key path, no annex
+	binary.Write(&msg, binary.LittleEndian, uint32(idx))
+
+	return TaggedHash("TapSighash", msg.Bytes()), nil
+}
+
+// bech32mChecksum makes the 6 character checksum for squashed data.
+func bech32mChecksum(hrp string, data []byte) []byte {
+	values := append(bech32.HRPExpand(hrp), data...)
+	values = append(values, make([]byte, 6)...)
+	mod := bech32.PolyMod(values) ^ bech32mConst
+	sum := make([]byte, 6)
+	for i := range sum {
+		sum[i] = byte(mod>>(5*(5-uint32(i)))) & 0x1f
+	}
+	return sum
+}
+
+// TaprootAddressEncode makes a bech32m witness v1 address for an output key.
+func TaprootAddressEncode(hrp string, outKey [32]byte) (string, error) {
+	data := append([]byte{1}, bech32.Bytes8to5(outKey[:])...)
+	data = append(data, bech32mChecksum(hrp, data)...)
+	dataString, err := bech32.SquashedBytesToString(data)
+	if err != nil {
+		return "", err
+	}
+	return hrp + "1" + dataString, nil
+}
+
+// TaprootAddressDecode takes a bech32m witness v1 address and returns the
+// pkscript that pays to it.
+func TaprootAddressDecode(adr string) ([]byte, error) {
+	lowAdr := strings.ToLower(adr)
+	if adr != lowAdr && adr != strings.ToUpper(adr) {
+		return nil, fmt.Errorf("mixed case address")
+	}
+	adr = lowAdr
+
+	splitLoc := strings.LastIndex(adr, "1")
+	if splitLoc < 1 || len(adr)-splitLoc < 8 {
+		return nil, fmt.Errorf("1 separator missing or misplaced in address")
+	}
+	hrp := adr[:splitLoc]
+	for _, c := range adr[splitLoc+1:] {
+		if !strings.ContainsRune(taprootCharset, c) {
+			return nil, fmt.Errorf("contains invalid character %s", string(c))
+		}
+	}
+	data, err := bech32.StringToSquashedBytes(adr[splitLoc+1:])
+	if err != nil {
+		return nil, err
+	}
+	if bech32.PolyMod(append(bech32.HRPExpand(hrp), data...)) != bech32mConst {
+		return nil, fmt.Errorf("bech32m checksum invalid")
+	}
+	data = data[:len(data)-6]
+
+	if len(data) == 0 || data[0] != 1 {
+		return nil, fmt.Errorf("not a witness v1 address")
+	}
+	prog, err := bech32.Bytes5to8(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(prog) != 32 {
+		return nil, fmt.Errorf("expect 32 byte v1 witprog, got %d", len(prog))
+	}
+	var outKey [32]byte
+	copy(outKey[:], prog)
+	return P2TRScript(outKey), nil
+}
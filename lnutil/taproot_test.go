@@ -0,0 +1,118 @@
+package lnutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/adiabat/bech32"
+	"github.com/adiabat/btcd/btcec"
+	"github.com/adiabat/btcd/wire"
+)
+
+// first key path only vector from the BIP341 wallet test vectors
+func TestTaprootOutputKey(t *testing.T) {
+	internal, _ := hex.DecodeString(
+		"02d6889cb081036e0faefa3a35157ad71086b123b2b144b649798b494c300a961d")
+	pub, err := btcec.ParsePubKey(internal, btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outKey, err := TaprootOutputKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := hex32(t,
+		"53a1f6e454df1aa2776a2814a721372d6258050de330b3c6d10ee8f4e0dda343")
+	if outKey != want {
+		t.Fatalf("output key %x, expect %x", outKey, want)
+	}
+
+	adr, err := TaprootAddressEncode("bc", outKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAdr := "bc1p2wsldez5mud2yam29q22wgfh9439spgduvct83k3pm50fcxa5dps59h4z5"
+	if adr != wantAdr {
+		t.Fatalf("address %s, expect %s", adr, wantAdr)
+	}
+}
+
+func TestTaprootAddressDecode(t *testing.T) {
+	// from BIP350
+	script, err := TaprootAddressDecode(
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString("5120" +
+		"79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	if !bytes.Equal(script, want) {
+		t.Fatalf("script %x, expect %x", script, want)
+	}
+
+	// same program, but with a bech32 (not bech32m) checksum
+	oldAdr, err := bech32.SegWitAddressEncode("bc", append([]byte{1}, want[1:]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = TaprootAddressDecode(oldAdr)
+	if err == nil {
+		t.Fatalf("decoded a v1 address with a bech32 checksum")
+	}
+	// v0 addresses aren't taproot
+	_, err = TaprootAddressDecode("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4")
+	if err == nil {
+		t.Fatalf("decoded a v0 address as taproot")
+	}
+}
+
+func TestTaprootSignSpend(t *testing.T) {
+	priv, _ := btcec.PrivKeyFromBytes(btcec.S256(), bytes.Repeat([]byte{7}, 32))
+	outKey, err := TaprootOutputKey(priv.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweaked, err := TaprootTweakPriv(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if XOnlyPub(tweaked) != outKey {
+		t.Fatalf("tweaked priv gives %x, output key %x", XOnlyPub(tweaked), outKey)
+	}
+
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 2}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(90000, P2TRScript(outKey)))
+	prevOuts := []*wire.TxOut{
+		wire.NewTxOut(50000, P2TRScript(outKey)),
+		wire.NewTxOut(50000, DirectWPKHScriptFromPKH([20]byte{1})),
+	}
+
+	hash, err := TaprootSigHash(tx, 0, prevOuts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := SignHash(SigTypeSchnorr, tweaked, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SchnorrVerify(outKey, hash, sig) {
+		t.Fatalf("sig doesn't verify with output key")
+	}
+
+	// the sighash covers other inputs' amounts
+	prevOuts[1].Value++
+	hash2, err := TaprootSigHash(tx, 0, prevOuts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash2 == hash {
+		t.Fatalf("sighash doesn't commit to input amounts")
+	}
+	if _, err = TaprootSigHash(tx, 0, prevOuts[:1]); err == nil {
+		t.Fatalf("sighash with missing prevouts")
+	}
+}
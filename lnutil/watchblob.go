@@ -0,0 +1,138 @@
+package lnutil
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/*
+Watch blobs are everything a tower needs to build a justice tx for one
+state, encrypted to the txid of the commitment tx they're for.  The tower
+files them under the first 16 bytes of the txid (the hint), and checks
+every tx it sees against the hints.  Only when a revoked commitment tx
+actually shows up does the tower have the whole txid, and can decrypt.
+Until then it doesn't know the channel, balance, or counterparty.
+
+The key is sha256(txid), so the hint says nothing about the key.  Each key
+encrypts only one blob, so the nonce can be all zeros.
+
+Fees can go up a lot between signing a state and it being broadcast, so
+besides the justice tx at Fee, a blob can have signatures for versions
+paying 2x, 4x, 8x... Fee.  If the first one doesn't confirm, the tower
+replaces it with the next.  The justice output goes to the client, so the
+tower can't CPFP; replacing is all it can do.
+*/
+
+// JusticeBlob is the plaintext of a watch blob.  160 bytes + 64 per bump:
+// DestPKH 20
+// Delay 2
+// Fee 8
+// RevPub 33
+// TimeoutPub 33
+// Sig 64
+// BumpSigs 64 each (rest)
+type JusticeBlob struct {
+	DestPKH    [20]byte   // where the justice tx sends the money
+	Delay      uint16     // the commitment script's timeout
+	Fee        int64      // fee the justice tx pays
+	RevPub     [33]byte   // revocable key in the commitment script
+	TimeoutPub [33]byte   // timeout key in the commitment script
+	Sig        [64]byte   // signature for the justice tx
+	BumpSigs   [][64]byte // signatures for justice txs paying Fee<<1, Fee<<2...
+}
+
+const justiceBlobLen = 160
+
+// MaxJusticeBumps is the most fee bumps a blob can have.
+const MaxJusticeBumps = 8
+
+// BumpFee is the fee the justice tx pays at a bump level; level 0 is Fee.
+func (jb *JusticeBlob) BumpFee(level int) int64 {
+	return jb.Fee << uint(level)
+}
+
+// LevelSig is the signature for the justice tx at a bump level.
+func (jb *JusticeBlob) LevelSig(level int) [64]byte {
+	if level == 0 {
+		return jb.Sig
+	}
+	return jb.BumpSigs[level-1]
+}
+
+func (jb *JusticeBlob) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(jb.DestPKH[:])
+	binary.Write(&buf, binary.BigEndian, jb.Delay)
+	binary.Write(&buf, binary.BigEndian, jb.Fee)
+	buf.Write(jb.RevPub[:])
+	buf.Write(jb.TimeoutPub[:])
+	buf.Write(jb.Sig[:])
+	for _, sig := range jb.BumpSigs {
+		buf.Write(sig[:])
+	}
+	return buf.Bytes()
+}
+
+func JusticeBlobFromBytes(b []byte) (JusticeBlob, error) {
+	var jb JusticeBlob
+	if len(b) < justiceBlobLen || (len(b)-justiceBlobLen)%64 != 0 ||
+		(len(b)-justiceBlobLen)/64 > MaxJusticeBumps {
+		return jb, fmt.Errorf("JusticeBlob %d bytes, expect %d + 64 per bump",
+			len(b), justiceBlobLen)
+	}
+	buf := bytes.NewBuffer(b)
+	copy(jb.DestPKH[:], buf.Next(20))
+	_ = binary.Read(buf, binary.BigEndian, &jb.Delay)
+	_ = binary.Read(buf, binary.BigEndian, &jb.Fee)
+	copy(jb.RevPub[:], buf.Next(33))
+	copy(jb.TimeoutPub[:], buf.Next(33))
+	copy(jb.Sig[:], buf.Next(64))
+	for buf.Len() > 0 {
+		var sig [64]byte
+		copy(sig[:], buf.Next(64))
+		jb.BumpSigs = append(jb.BumpSigs, sig)
+	}
+	return jb, nil
+}
+
+// BlobHint is the part of a txid a tower files its blob under.
+func BlobHint(txid chainhash.Hash) [16]byte {
+	var hint [16]byte
+	copy(hint[:], txid[:16])
+	return hint
+}
+
+func blobAEAD(txid chainhash.Hash) (cipher.AEAD, error) {
+	key := sha256.Sum256(txid[:])
+	return chacha20poly1305.New(key[:])
+}
+
+// Encrypt makes a watch blob for the commitment tx with the given txid.
+func (jb *JusticeBlob) Encrypt(txid chainhash.Hash) ([]byte, error) {
+	aead, err := blobAEAD(txid)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	return aead.Seal(nil, nonce, jb.Bytes(), nil), nil
+}
+
+// DecryptJusticeBlob opens a watch blob, now that we've seen its tx.
+func DecryptJusticeBlob(txid chainhash.Hash, blob []byte) (JusticeBlob, error) {
+	aead, err := blobAEAD(txid)
+	if err != nil {
+		return JusticeBlob{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	plain, err := aead.Open(nil, nonce, blob, nil)
+	if err != nil {
+		return JusticeBlob{}, fmt.Errorf("can't decrypt blob for %s", txid.String())
+	}
+	return JusticeBlobFromBytes(plain)
+}
@@ -0,0 +1,77 @@
+package lnutil
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+)
+
+func TestJusticeBlob(t *testing.T) {
+	var jb JusticeBlob
+	_, _ = rand.Read(jb.DestPKH[:])
+	_, _ = rand.Read(jb.RevPub[:])
+	_, _ = rand.Read(jb.TimeoutPub[:])
+	_, _ = rand.Read(jb.Sig[:])
+	jb.Delay = uint16(rand.Uint32())
+	jb.Fee = rand.Int63()
+
+	var txid chainhash.Hash
+	_, _ = rand.Read(txid[:])
+
+	blob, err := jb.Encrypt(txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jb2, err := DecryptJusticeBlob(txid, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(jb2.Bytes(), jb.Bytes()) || jb2.BumpSigs != nil {
+		t.Fatalf("decrypted blob mismatch:\n%x\n%x\n", jb.Bytes(), jb2.Bytes())
+	}
+
+	// a different txid with the same hint can't open it
+	other := txid
+	other[31] ^= 1
+	if BlobHint(other) != BlobHint(txid) {
+		t.Fatalf("hint should only use the first 16 bytes")
+	}
+	_, err = DecryptJusticeBlob(other, blob)
+	if err == nil {
+		t.Fatalf("decrypted blob with the wrong txid")
+	}
+}
+
+func TestJusticeBlobBumps(t *testing.T) {
+	var jb JusticeBlob
+	_, _ = rand.Read(jb.Sig[:])
+	jb.Fee = 1000
+	jb.BumpSigs = make([][64]byte, 3)
+	for i := range jb.BumpSigs {
+		_, _ = rand.Read(jb.BumpSigs[i][:])
+	}
+
+	b := jb.Bytes()
+	if len(b) != justiceBlobLen+3*64 {
+		t.Fatalf("blob with 3 bumps is %d bytes", len(b))
+	}
+	jb2, err := JusticeBlobFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(jb2.Bytes(), b) {
+		t.Fatalf("bumped blob mismatch:\n%x\n%x\n", b, jb2.Bytes())
+	}
+	if jb2.BumpFee(2) != 4000 || jb2.LevelSig(2) != jb.BumpSigs[1] ||
+		jb2.LevelSig(0) != jb.Sig {
+		t.Fatalf("wrong fee or sig for bump level")
+	}
+
+	_, err = JusticeBlobFromBytes(b[:len(b)-1])
+	if err == nil {
+		t.Fatalf("read blob with a partial bump sig")
+	}
+}
@@ -0,0 +1,210 @@
+// Package logs is leveled, per-subsystem logging with key-value fields.
+//
+// Each subsystem (QLN, LNDC, WALLIT...) gets a Logger, and has its own
+// level, which can be changed while running.  Lines go out through the
+// standard log package, so they end up wherever log.SetOutput says, like
+// lit.log:
+//
+//	2018/03/01 12:00:00 INF QLN: got deltasig peer=3 chan=7
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	stdlog "log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Level is how important a log line is.  Lines below a subsystem's level
+// aren't logged.
+type Level int32
+
+const (
+	LevelTrace Level = iota // message dumps and other very chatty stuff
+	LevelDebug              // what's going on, step by step
+	LevelInfo               // things worth knowing about
+	LevelWarn               // things that went wrong but are handled
+	LevelError              // things that went wrong
+	LevelOff                // nothing at all
+)
+
+// DefaultLevel is what subsystems log at unless they're set otherwise.
+const DefaultLevel = LevelInfo
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error", "off"}
+
+// tags are what go at the start of lines
+var levelTags = []string{"TRC", "DBG", "INF", "WRN", "ERR", "OFF"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("level%d", l)
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads a level name: trace, debug, info, warn, error or off.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("log level %s; expect one of %s",
+		s, strings.Join(levelNames, ", "))
+}
+
+// subsystem is a subsystem's name and level, shared by its Logger and ones
+// made from it With fields.
+type subsystem struct {
+	name  string
+	level int32 // a Level; atomic
+}
+
+var (
+	subsMtx sync.Mutex
+	subs    = make(map[string]*subsystem)
+)
+
+// Logger logs for a subsystem, with fields to put on every line.
+type Logger struct {
+	sub    *subsystem
+	fields string // " k=v k=v"
+}
+
+// New gets the Logger for a subsystem, making it if it's new.  Names are
+// upper case by convention.
+func New(name string) *Logger {
+	subsMtx.Lock()
+	defer subsMtx.Unlock()
+	sub, ok := subs[name]
+	if !ok {
+		sub = &subsystem{name: name, level: int32(DefaultLevel)}
+		subs[name] = sub
+	}
+	return &Logger{sub: sub}
+}
+
+// SetLevel sets a subsystem's level; "" or "all" sets every subsystem's.
+func SetLevel(name string, level Level) error {
+	subsMtx.Lock()
+	defer subsMtx.Unlock()
+	if name == "" || strings.EqualFold(name, "all") {
+		for _, sub := range subs {
+			atomic.StoreInt32(&sub.level, int32(level))
+		}
+		return nil
+	}
+	sub, ok := subs[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("no log subsystem %s", name)
+	}
+	atomic.StoreInt32(&sub.level, int32(level))
+	return nil
+}
+
+// SetLevels sets levels from a spec like "debug" or "info,QLN=trace,LNDC=warn":
+// a bare level is for all subsystems, name=level for one.  Later ones win.
+func SetLevels(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvl := "", part
+		if i := strings.Index(part, "="); i != -1 {
+			name, lvl = part[:i], part[i+1:]
+		}
+		level, err := ParseLevel(lvl)
+		if err != nil {
+			return err
+		}
+		err = SetLevel(name, level)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Levels gives each subsystem's level, by name.
+func Levels() map[string]Level {
+	subsMtx.Lock()
+	defer subsMtx.Unlock()
+	levels := make(map[string]Level)
+	for name, sub := range subs {
+		levels[name] = Level(atomic.LoadInt32(&sub.level))
+	}
+	return levels
+}
+
+// Subsystems lists the subsystems' names, sorted.
+func Subsystems() []string {
+	var names []string
+	for name := range Levels() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// With gives a Logger that puts key-value fields on every line, after the
+// ones this one has.  Give it pairs: With("peer", 3, "chan", 7).
+func (l *Logger) With(kv ...interface{}) *Logger {
+	var b bytes.Buffer
+	b.WriteString(l.fields)
+	for i := 0; i < len(kv); i += 2 {
+		var v interface{} = "?"
+		if i+1 < len(kv) {
+			v = kv[i+1]
+		}
+		val := fmt.Sprint(v)
+		if strings.ContainsAny(val, " \t\n\"=") {
+			val = fmt.Sprintf("%q", val)
+		}
+		fmt.Fprintf(&b, " %v=%s", kv[i], val)
+	}
+	return &Logger{sub: l.sub, fields: b.String()}
+}
+
+// Enabled says whether lines at a level get logged, for skipping work
+// that's only for the log.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&l.sub.level))
+}
+
+func (l *Logger) output(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) || level >= LevelOff {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	stdlog.Output(3, levelTags[level]+" "+l.sub.name+": "+msg+l.fields)
+}
+
+func (l *Logger) Tracef(format string, args ...interface{}) {
+	l.output(LevelTrace, format, args...)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.output(LevelDebug, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.output(LevelInfo, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.output(LevelWarn, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.output(LevelError, format, args...)
+}
+
+// Printf logs at info, like the log package's Printf.
+func (l *Logger) Printf(format string, args ...interface{}) {
+	l.output(LevelInfo, format, args...)
+}
@@ -0,0 +1,45 @@
+package logs
+
+import (
+	"bytes"
+	stdlog "log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// lines below the level don't show, and fields go at the end
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	stdlog.SetOutput(&buf)
+	stdlog.SetFlags(0)
+	defer stdlog.SetOutput(os.Stderr)
+
+	l := New("TEST")
+	err := SetLevels("warn,TEST=debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Tracef("no")
+	l.With("peer", 3, "note", "a b").Debugf("yes %d\n", 1)
+	if buf.String() != "DBG TEST: yes 1 peer=3 note=\"a b\"\n" {
+		t.Fatalf("got %q", buf.String())
+	}
+
+	SetLevel("test", LevelError)
+	buf.Reset()
+	l.Warnf("no")
+	if buf.Len() != 0 {
+		t.Fatalf("got %q at error level", buf.String())
+	}
+	if Levels()["TEST"] != LevelError {
+		t.Fatalf("level %s, expect error", Levels()["TEST"])
+	}
+
+	if SetLevels("QLN=loud") == nil || SetLevel("NOPE", LevelInfo) == nil {
+		t.Fatalf("bad level or subsystem set")
+	}
+	if !strings.Contains(strings.Join(Subsystems(), " "), "TEST") {
+		t.Fatalf("TEST not in %v", Subsystems())
+	}
+}
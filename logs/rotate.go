@@ -0,0 +1,300 @@
+package logs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Log rotation
+
+A Rotator is a log file that starts over when it gets too big or too old.
+The old one gets renamed with the time it was rotated, lit.log becoming
+lit-20180301-120000.000.log, and then gzipped to lit-20180301-120000.000.log.gz
+while logging carries on.  After that, old logs past the number to keep,
+or older than the days to keep, are deleted.
+*/
+
+const (
+	// rotateTimeFormat sorts in time order
+	rotateTimeFormat = "20060102-150405.000"
+
+	// DefaultMaxLogSize is how big a log gets before it's rotated.
+	DefaultMaxLogSize = 10 << 20
+	// DefaultLogKeep is how many old logs stay in the log folder.
+	DefaultLogKeep = 10
+)
+
+// RotateConfig says when to rotate and what to keep.  Zeros mean never or
+// no limit.
+type RotateConfig struct {
+	MaxSize  int64         // bytes
+	MaxAge   time.Duration // since the file was started
+	Keep     int           // old logs
+	KeepDays int           // days since an old log was rotated
+	Compress bool
+}
+
+// Rotator is a log file that rotates itself.  Safe to write from more
+// than one goroutine.
+type Rotator struct {
+	dir, name string // name like lit.log
+	cfg       RotateConfig
+
+	mtx     sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time
+
+	// one compress and prune at a time, behind the writer
+	cleanMtx sync.Mutex
+}
+
+// NewRotator opens or makes a log file in dir to append to, making dir if
+// it's not there.
+func NewRotator(dir, name string, cfg RotateConfig) (*Rotator, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rotator{dir: dir, name: name, cfg: cfg}
+	err = r.open()
+	if err != nil {
+		return nil, err
+	}
+	// a log left from an earlier run may already be due
+	if r.due(0) {
+		err = r.rotate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// compress and prune whatever an earlier run left
+	go r.clean()
+	return r, nil
+}
+
+func (r *Rotator) path() string {
+	return filepath.Join(r.dir, r.name)
+}
+
+func (r *Rotator) open() error {
+	f, err := os.OpenFile(r.path(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size, r.started = f, fi.Size(), time.Now()
+	if r.size != 0 {
+		// can't tell when it was started; the last write is close enough
+		r.started = fi.ModTime()
+	}
+	return nil
+}
+
+// due says whether writing n more bytes should go in a new file.  An
+// empty file's never due; there'd be nothing to rotate.
+func (r *Rotator) due(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.cfg.MaxSize > 0 && r.size+int64(n) > r.cfg.MaxSize {
+		return true
+	}
+	return r.cfg.MaxAge > 0 && time.Since(r.started) > r.cfg.MaxAge
+}
+
+// Write writes to the log file, rotating first if it's due.
+func (r *Rotator) Write(p []byte) (int, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.file == nil {
+		return 0, fmt.Errorf("log %s closed", r.name)
+	}
+	if r.due(len(p)) {
+		err := r.rotate()
+		if err != nil {
+			// keep logging to the old one rather than lose lines
+			fmt.Fprintf(os.Stderr, "log rotate: %s\n", err.Error())
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate starts a new log file now.
+func (r *Rotator) Rotate() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.file == nil {
+		return fmt.Errorf("log %s closed", r.name)
+	}
+	return r.rotate()
+}
+
+// rotate renames the log and starts a new one.  Call with mtx held.
+func (r *Rotator) rotate() error {
+	err := r.file.Close()
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(r.name)
+	var old string
+	// a name that's taken gets the next millisecond
+	for t := time.Now(); ; t = t.Add(time.Millisecond) {
+		old = filepath.Join(r.dir, strings.TrimSuffix(r.name, ext)+"-"+
+			t.Format(rotateTimeFormat)+ext)
+		if !fileExists(old) && !fileExists(old+".gz") {
+			break
+		}
+	}
+	err = os.Rename(r.path(), old)
+	if err != nil {
+		r.open()
+		return err
+	}
+	err = r.open()
+	if err != nil {
+		return err
+	}
+	go r.clean()
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Close closes the log file; writes after fail.
+func (r *Rotator) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// clean compresses rotated logs that aren't yet, and prunes old ones.
+func (r *Rotator) clean() {
+	r.cleanMtx.Lock()
+	defer r.cleanMtx.Unlock()
+	err := r.compress()
+	if err == nil {
+		err = r.prune()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log clean: %s\n", err.Error())
+	}
+}
+
+// rotated lists the rotated logs, oldest first.
+func (r *Rotator) rotated() ([]os.FileInfo, error) {
+	fis, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(r.name)
+	prefix := strings.TrimSuffix(r.name, ext) + "-"
+	var old []os.FileInfo
+	for _, fi := range fis {
+		name := strings.TrimSuffix(fi.Name(), ".gz")
+		if !fi.IsDir() && strings.HasPrefix(name, prefix) &&
+			strings.HasSuffix(name, ext) {
+			old = append(old, fi)
+		}
+	}
+	// the time in the name sorts them
+	sort.Slice(old, func(i, j int) bool { return old[i].Name() < old[j].Name() })
+	return old, nil
+}
+
+func (r *Rotator) compress() error {
+	if !r.cfg.Compress {
+		return nil
+	}
+	old, err := r.rotated()
+	if err != nil {
+		return err
+	}
+	for _, fi := range old {
+		if strings.HasSuffix(fi.Name(), ".gz") {
+			continue
+		}
+		err = gzipFile(filepath.Join(r.dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipFile gzips path to path.gz, then deletes path.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// write to a temp file so a crash doesn't leave half a .gz
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, path+".gz")
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune deletes old logs past the number to keep, and ones rotated more
+// than the days to keep ago.
+func (r *Rotator) prune() error {
+	old, err := r.rotated()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -r.cfg.KeepDays)
+	for i, fi := range old {
+		tooMany := r.cfg.Keep > 0 && i < len(old)-r.cfg.Keep
+		tooOld := r.cfg.KeepDays > 0 && fi.ModTime().Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		err = os.Remove(filepath.Join(r.dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package logs
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// rotates by size, gzips the old ones, and keeps only so many
+func TestRotator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "litlogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRotator(dir, "lit.log",
+		RotateConfig{MaxSize: 100, Keep: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for i := 0; i < 8; i++ {
+		_, err = r.Write(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 8 lines, 1 a file: 7 rotated, the newest 2 kept
+	r.clean()
+
+	fi, err := os.Stat(r.path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len(line)) {
+		t.Fatalf("lit.log %d bytes, expect %d", fi.Size(), len(line))
+	}
+	old, err := r.rotated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old) != 2 {
+		t.Fatalf("%d rotated logs, expect 2", len(old))
+	}
+	for _, fi := range old {
+		if !strings.HasSuffix(fi.Name(), ".log.gz") {
+			t.Fatalf("rotated log %s not gzipped", fi.Name())
+		}
+	}
+}
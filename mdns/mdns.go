@@ -0,0 +1,352 @@
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+/*
+Finding lit nodes on the local network with multicast DNS (RFC 6762), the
+way bonjour / avahi find printers.
+
+A node advertises itself as the instance <ln address>._lit._tcp.local,
+with an SRV record for each port it listens on and A records for its
+addresses.  Discover multicasts a query for _lit._tcp.local and collects
+whatever answers come back.  Queries come from an ephemeral port, so the
+answers are unicast straight back to us (section 6.7 of the RFC) and we
+don't need to share 5353 with the system's own responder.
+*/
+
+// Service is the DNS-SD service type for lit.
+const Service = "_lit._tcp.local."
+
+// how long records are good for, in seconds
+const recordTTL = 120
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Node is a lit node found on the local network.
+type Node struct {
+	LitAdr string
+	Host   string // host:port
+}
+
+// Advertiser answers mDNS queries for our node.
+type Advertiser struct {
+	conn   *net.UDPConn
+	litAdr string
+
+	mtx   sync.Mutex
+	ports []uint16
+}
+
+// Advertise starts answering queries for a node with the lit address
+// litAdr.  Add the ports it listens on with AddPort.
+func Advertise(litAdr string) (*Advertiser, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	a := &Advertiser{conn: conn, litAdr: litAdr}
+	go a.serve()
+	return a, nil
+}
+
+// AddPort advertises another port.
+func (a *Advertiser) AddPort(port uint16) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for _, p := range a.ports {
+		if p == port {
+			return
+		}
+	}
+	a.ports = append(a.ports, port)
+}
+
+// RemovePort stops advertising a port.
+func (a *Advertiser) RemovePort(port uint16) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for i, p := range a.ports {
+		if p == port {
+			a.ports = append(a.ports[:i], a.ports[i+1:]...)
+			return
+		}
+	}
+}
+
+// Close stops advertising.
+func (a *Advertiser) Close() error {
+	return a.conn.Close()
+}
+
+func (a *Advertiser) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			// closed
+			return
+		}
+		var p dnsmessage.Parser
+		hdr, err := p.Start(buf[:n])
+		if err != nil || hdr.Response {
+			continue
+		}
+		if !asksForLit(&p) {
+			continue
+		}
+
+		a.mtx.Lock()
+		ports := append([]uint16(nil), a.ports...)
+		a.mtx.Unlock()
+		if len(ports) == 0 {
+			continue
+		}
+
+		resp, err := answer(hdr.ID, a.litAdr, ports, localIPs())
+		if err != nil {
+			log.Printf("mdns answer: %s\n", err.Error())
+			continue
+		}
+		// queries from other ports get a unicast answer
+		dst := mdnsGroup
+		if src.Port != mdnsGroup.Port {
+			dst = src
+		}
+		a.conn.WriteToUDP(resp, dst)
+	}
+}
+
+// asksForLit says whether a query has a question about the lit service.
+func asksForLit(p *dnsmessage.Parser) bool {
+	for {
+		q, err := p.Question()
+		if err != nil {
+			return false
+		}
+		if strings.EqualFold(q.Name.String(), Service) &&
+			(q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+			return true
+		}
+	}
+}
+
+// answer builds the response describing our node.
+func answer(id uint16, litAdr string, ports []uint16, ips []net.IP) ([]byte, error) {
+	service, err := dnsmessage.NewName(Service)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(litAdr + "." + Service)
+	if err != nil {
+		return nil, err
+	}
+	target, err := dnsmessage.NewName(litAdr + ".local.")
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err = b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	hdr := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{
+			Name: name, Class: dnsmessage.ClassINET, TTL: recordTTL}
+	}
+
+	err = b.PTRResource(hdr(service), dnsmessage.PTRResource{PTR: instance})
+	if err != nil {
+		return nil, err
+	}
+	for _, port := range ports {
+		err = b.SRVResource(hdr(instance),
+			dnsmessage.SRVResource{Port: port, Target: target})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = b.TXTResource(hdr(instance),
+		dnsmessage.TXTResource{TXT: []string{"adr=" + litAdr}})
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var a dnsmessage.AResource
+		copy(a.A[:], ip.To4())
+		if err = b.AResource(hdr(target), a); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// localIPs are our IPv4 addresses other than loopback.
+func localIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, adr := range addrs {
+		ipNet, ok := adr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		ips = append(ips, ipNet.IP.To4())
+	}
+	return ips
+}
+
+// Discover asks the local network for lit nodes, and returns all the ones
+// which answer within timeout.
+func Discover(timeout time.Duration) ([]Node, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query, err := litQuery()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.WriteToUDP(query, mdnsGroup); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	var nodes []Node
+	buf := make([]byte, 9000)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// the deadline is how we stop
+			break
+		}
+		for _, node := range parseAnswer(buf[:n], src.IP) {
+			if !found[node.LitAdr+node.Host] {
+				found[node.LitAdr+node.Host] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// litQuery is a PTR query for the lit service.
+func litQuery() ([]byte, error) {
+	service, err := dnsmessage.NewName(Service)
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err = b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	err = b.Question(dnsmessage.Question{
+		Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	if err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseAnswer gets the nodes out of a response.  If the response doesn't
+// say what IP a node is at, it's at the IP the response came from.
+func parseAnswer(b []byte, src net.IP) []Node {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(b)
+	if err != nil || !hdr.Response {
+		return nil
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil
+	}
+
+	type srv struct {
+		target string
+		port   uint16
+	}
+	srvs := make(map[string][]srv) // instance : srvs
+	ips := make(map[string]net.IP) // target : ip
+
+	// answers and additionals both count
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			if err = p.SkipAllAuthorities(); err != nil {
+				break
+			}
+			rh, err = p.AdditionalHeader()
+		}
+		if err != nil {
+			break
+		}
+		name := strings.ToLower(rh.Name.String())
+		switch rh.Type {
+		case dnsmessage.TypeSRV:
+			r, err := p.SRVResource()
+			if err != nil {
+				return nil
+			}
+			srvs[name] = append(srvs[name],
+				srv{strings.ToLower(r.Target.String()), r.Port})
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil
+			}
+			ips[name] = net.IP(r.A[:])
+		default:
+			if err = skipResource(&p, rh); err != nil {
+				return nil
+			}
+		}
+	}
+
+	var nodes []Node
+	for instance, ss := range srvs {
+		if !strings.HasSuffix(instance, Service) {
+			continue
+		}
+		litAdr := strings.TrimSuffix(instance, "."+Service)
+		for _, s := range ss {
+			ip, ok := ips[s.target]
+			if !ok {
+				ip = src
+			}
+			if ip == nil {
+				continue
+			}
+			nodes = append(nodes, Node{
+				LitAdr: litAdr,
+				Host: net.JoinHostPort(
+					ip.String(), strconv.Itoa(int(s.port))),
+			})
+		}
+	}
+	return nodes
+}
+
+// skipResource skips the body of a record we don't care about, in whichever
+// section the parser is in.
+func skipResource(p *dnsmessage.Parser, rh dnsmessage.ResourceHeader) error {
+	_, err := p.UnknownResource()
+	if err != nil {
+		return fmt.Errorf("skip %s record: %s", rh.Type, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testAdr = "ln1pmclh89haeswrw0unf8awuyqeu4t2uell58nea"
+
+func TestQuery(t *testing.T) {
+	q, err := litQuery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p dnsmessage.Parser
+	if _, err = p.Start(q); err != nil {
+		t.Fatal(err)
+	}
+	if !asksForLit(&p) {
+		t.Fatalf("query doesn't ask for lit")
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	ip := net.IPv4(192, 168, 1, 20).To4()
+	b, err := answer(0, testAdr, []uint16{2448, 2449}, []net.IP{ip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := parseAnswer(b, net.IPv4(10, 0, 0, 1))
+	if len(nodes) != 2 {
+		t.Fatalf("got %d nodes, expect 2", len(nodes))
+	}
+	for i, host := range []string{"192.168.1.20:2448", "192.168.1.20:2449"} {
+		if nodes[i].LitAdr != testAdr || nodes[i].Host != host {
+			t.Fatalf("node %d %v, expect %s@%s", i, nodes[i], testAdr, host)
+		}
+	}
+
+	// no A record means the sender's IP
+	b, err = answer(0, testAdr, []uint16{2448}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes = parseAnswer(b, net.IPv4(10, 0, 0, 1))
+	if len(nodes) != 1 || nodes[0].Host != "10.0.0.1:2448" {
+		t.Fatalf("got %v, expect %s@10.0.0.1:2448", nodes, testAdr)
+	}
+
+	// queries aren't answers
+	q, _ := litQuery()
+	if len(parseAnswer(q, nil)) != 0 {
+		t.Fatalf("found nodes in a query")
+	}
+}
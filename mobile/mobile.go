@@ -0,0 +1,362 @@
+// Package mobile runs a lit node inside an iOS or Android app, instead of
+// the app talking to a lit running somewhere else.  Build it with
+//
+//	gomobile bind github.com/mit-dci/lit/mobile
+//
+// gomobile only passes simple types across, so things like channel lists
+// come back as json, the same as the rpc's replies, and Call reaches any
+// rpc method that isn't wrapped here.
+package mobile
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/litrpc"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/logs"
+	"github.com/mit-dci/lit/qln"
+)
+
+const (
+	keyFileName      = "privkey.hex"
+	birthdayFileName = "birthday"
+	// eventQueue is how many events can wait for the app's handler; past
+	// that, they're dropped.
+	eventQueue = 1000
+)
+
+// EventHandler is what the app gives to hear about events: payments
+// received, channels opened and closed, breaches and peers disconnecting.
+// eventType is like payment_received, and eventJSON has the rest, as
+// webhooks get it.  Events come one at a time, in order, on a goroutine of
+// their own.
+type EventHandler interface {
+	OnEvent(eventType string, eventJSON string)
+}
+
+// Node is a lit node kept in an app's folder.
+type Node struct {
+	dir string
+
+	mtx      sync.Mutex
+	rpc      *litrpc.LitRPC
+	client   *rpc.Client
+	logFile  *logs.Rotator
+	detached bool
+	handler  EventHandler
+	events   chan string
+}
+
+// NewNode gives a node kept in dir, which lit makes if it's not there.  It
+// isn't running till Start.
+func NewNode(dir string) *Node {
+	return &Node{dir: dir}
+}
+
+func (n *Node) keyPath() string {
+	return filepath.Join(n.dir, keyFileName)
+}
+
+// HasKey says whether the node has a key yet; if not, make one with NewKey.
+func (n *Node) HasKey() bool {
+	_, err := os.Stat(n.keyPath())
+	return err == nil
+}
+
+// NewKey makes the node's key, encrypted with passphrase.  There's only
+// one key; it won't replace one that's there.
+func (n *Node) NewKey(passphrase string) error {
+	if n.HasKey() {
+		return fmt.Errorf("already have a key in %s", n.dir)
+	}
+	if passphrase == "" {
+		return fmt.Errorf("need a passphrase to encrypt the key with")
+	}
+	err := os.MkdirAll(n.dir, 0700)
+	if err != nil {
+		return err
+	}
+	key := new([32]byte)
+	_, err = rand.Read(key[:])
+	if err != nil {
+		return err
+	}
+	err = lnutil.SaveKeyToFileArg(n.keyPath(), key, []byte(passphrase))
+	if err != nil {
+		return err
+	}
+	// a new key has nothing on chain from before now
+	return ioutil.WriteFile(filepath.Join(n.dir, birthdayFileName),
+		[]byte(strconv.FormatInt(time.Now().Unix(), 10)+"\n"), 0600)
+}
+
+// birthday is when the key was made, or 0 if that's not known.
+func (n *Node) birthday() int64 {
+	b, err := ioutil.ReadFile(filepath.Join(n.dir, birthdayFileName))
+	if err != nil {
+		return 0
+	}
+	t, _ := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return t
+}
+
+// Start unlocks the key with passphrase and starts the node, with a wallet
+// for coin, named as in coinparam (like testnet3), syncing from host.
+func (n *Node) Start(passphrase, coin, host string) error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.rpc != nil {
+		return fmt.Errorf("already started")
+	}
+	if n.detached {
+		return fmt.Errorf("a detached node can't start again till the app restarts")
+	}
+	p := coinparam.ParamsByName(coin)
+	if p == nil {
+		return fmt.Errorf("no coin named %s", coin)
+	}
+	key, err := lnutil.LoadKeyFromFileArg(n.keyPath(), []byte(passphrase))
+	if err != nil {
+		return fmt.Errorf("unlock: %s", err.Error())
+	}
+
+	n.logFile, err = logs.NewRotator(n.dir, "lit.log", logs.RotateConfig{
+		MaxSize: logs.DefaultMaxLogSize, Keep: 2, Compress: true})
+	if err != nil {
+		return err
+	}
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	log.SetOutput(n.logFile)
+
+	kvdb.SetKey(key)
+	node, err := qln.NewLitNode(key, n.dir, "", "")
+	if err != nil {
+		return err
+	}
+	err = node.LinkBaseWallet(key, p.StartHeight, n.birthday(), false, false,
+		host, p)
+	if err != nil {
+		return err
+	}
+	err = node.ResumeStateUpdates()
+	if err != nil {
+		return err
+	}
+	node.OnEvent(n.event)
+
+	n.rpc = &litrpc.LitRPC{Node: node, OffButton: make(chan bool, 1)}
+	// Call talks to the rpc over a pipe, not the network
+	server := rpc.NewServer()
+	err = server.Register(n.rpc)
+	if err != nil {
+		return err
+	}
+	serverEnd, clientEnd := net.Pipe()
+	go server.ServeCodec(jsonrpc.NewServerCodec(serverEnd))
+	n.client = jsonrpc.NewClient(clientEnd)
+
+	go node.ConnectChannelPeers()
+	go node.HTLCExpiryLoop()
+	return nil
+}
+
+// Detach cuts the app off from the node, for when it's about to exit: no
+// more calls or events, no new connections in, and the log file's closed.
+// It doesn't stop the node.  Peers stay connected and the wallet keeps
+// syncing till the app exits, since lit can't close its wallets and dbs
+// while it's running; so the node can't start again till then either.
+func (n *Node) Detach() error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.rpc == nil {
+		return nil
+	}
+	for _, l := range n.rpc.Node.ListListeners() {
+		n.rpc.Node.RemoveListener(l.Addr)
+	}
+	n.rpc.Node.UnmapPorts()
+	n.client.Close()
+	n.rpc, n.client = nil, nil
+	n.detached = true
+	if n.events != nil {
+		close(n.events)
+		n.events = nil
+	}
+	log.SetOutput(os.Stderr)
+	return n.logFile.Close()
+}
+
+// Running says whether the node's started and not detached.
+func (n *Node) Running() bool {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.rpc != nil
+}
+
+// SetEventHandler has the app's handler hear about events from now on.
+func (n *Node) SetEventHandler(h EventHandler) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.handler = h
+	if n.events == nil && !n.detached {
+		n.events = make(chan string, eventQueue)
+		go n.eventLoop(n.events)
+	}
+}
+
+// event is called by the node; it queues the event for the handler.
+func (n *Node) event(e qln.WebhookEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.events == nil {
+		return
+	}
+	select {
+	case n.events <- string(b):
+	default:
+		log.Printf("app's event handler backed up; dropping %s\n", e.Type)
+	}
+}
+
+func (n *Node) eventLoop(events chan string) {
+	for s := range events {
+		var e qln.WebhookEvent
+		json.Unmarshal([]byte(s), &e)
+		n.mtx.Lock()
+		h := n.handler
+		n.mtx.Unlock()
+		if h != nil {
+			h.OnEvent(e.Type, s)
+		}
+	}
+}
+
+func (n *Node) running() (*litrpc.LitRPC, error) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.rpc == nil {
+		return nil, fmt.Errorf("node isn't running")
+	}
+	return n.rpc, nil
+}
+
+// Call calls an rpc method, like ChannelList, with params as json, giving
+// the reply as json.
+func (n *Node) Call(method, params string) (string, error) {
+	n.mtx.Lock()
+	client := n.client
+	n.mtx.Unlock()
+	if client == nil {
+		return "", fmt.Errorf("node isn't running")
+	}
+	if params == "" {
+		params = "{}"
+	}
+	if !strings.Contains(method, ".") {
+		method = "LitRPC." + method
+	}
+	var reply json.RawMessage
+	err := client.Call(method, json.RawMessage(params), &reply)
+	return string(reply), err
+}
+
+// Address gives a new address to receive coins on, for funding channels.
+func (n *Node) Address() (string, error) {
+	r, err := n.running()
+	if err != nil {
+		return "", err
+	}
+	reply := new(litrpc.AddressReply)
+	err = r.Address(&litrpc.AddressArgs{NumToMake: 1,
+		AdrType: litrpc.AdrTypeBech32}, reply)
+	if err != nil {
+		return "", err
+	}
+	if len(reply.WitAddresses) == 0 {
+		return "", fmt.Errorf("no address made")
+	}
+	return reply.WitAddresses[0], nil
+}
+
+// Balance gives the wallet's balances as json, as the Balance rpc does.
+func (n *Node) Balance() (string, error) {
+	r, err := n.running()
+	if err != nil {
+		return "", err
+	}
+	reply := new(litrpc.BalanceReply)
+	err = r.Balance(new(litrpc.NoArgs), reply)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(reply)
+	return string(b), err
+}
+
+// Connect connects to a peer, by lit address (with @host:port if it's not
+// findable from the tracker) or peer index.
+func (n *Node) Connect(adr string) error {
+	r, err := n.running()
+	if err != nil {
+		return err
+	}
+	return r.Connect(litrpc.ConnectArgs{LNAddr: adr}, new(litrpc.StatusReply))
+}
+
+// Fund makes a channel with a connected peer, of capacity satoshis,
+// giving them initialSend of it to start.
+func (n *Node) Fund(peer int32, capacity, initialSend int64) error {
+	r, err := n.running()
+	if err != nil {
+		return err
+	}
+	return r.FundChannel(litrpc.FundArgs{Peer: uint32(peer),
+		CoinType: r.Node.DefaultCoin, Capacity: capacity,
+		InitialSend: initialSend}, new(litrpc.StatusReply))
+}
+
+// Push sends amt satoshis to the other side of a channel, giving the
+// trace id to look it up with TracePayment.
+func (n *Node) Push(chanIdx int32, amt int64) (int64, error) {
+	r, err := n.running()
+	if err != nil {
+		return 0, err
+	}
+	reply := new(litrpc.PushReply)
+	err = r.Push(litrpc.PushArgs{ChanIdx: uint32(chanIdx), Amt: amt}, reply)
+	return int64(reply.TraceID), err
+}
+
+// Channels gives the channels as json, as the ChannelList rpc does.
+func (n *Node) Channels() (string, error) {
+	r, err := n.running()
+	if err != nil {
+		return "", err
+	}
+	reply := new(litrpc.ChannelListReply)
+	err = r.ChannelList(litrpc.ChanArgs{}, reply)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(reply)
+	return string(b), err
+}
@@ -0,0 +1,62 @@
+package nat
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+/*
+Automatic port forwarding on home routers.
+
+Two protocols are tried: NAT-PMP (RFC 6886, apple routers and most things
+running miniupnpd) which is a couple of UDP packets to the gateway, and UPnP
+IGD, which finds the router by multicast and then talks SOAP over http.
+Either way the router forwards an external TCP port to us for a limited
+time; the mapping has to be renewed before it runs out, and should be
+deleted when we shut down.
+*/
+
+// Mapper can forward ports on a NAT router.
+type Mapper interface {
+	// AddPortMapping asks the router to forward TCP connections on extPort to
+	// intPort on this host for lifetime.  Returns the external port the router
+	// actually gave us, which may differ from the one asked for.
+	AddPortMapping(intPort, extPort uint16,
+		desc string, lifetime time.Duration) (uint16, error)
+	// DeletePortMapping removes a mapping made with AddPortMapping.
+	DeletePortMapping(intPort, extPort uint16) error
+	// ExternalIP is the router's public IP address.
+	ExternalIP() (net.IP, error)
+	// Name says which protocol this is, for logging.
+	Name() string
+}
+
+// Discover finds a router which can forward ports, trying NAT-PMP first and
+// then UPnP.
+func Discover() (Mapper, error) {
+	gw, err := defaultGateway()
+	if err == nil {
+		pmp := NewPMP(gw)
+		_, err = pmp.ExternalIP()
+		if err == nil {
+			return pmp, nil
+		}
+	}
+	upnp, upErr := DiscoverUPnP(3 * time.Second)
+	if upErr == nil {
+		return upnp, nil
+	}
+	return nil, fmt.Errorf("no NAT-PMP (%v) or UPnP (%v) router found", err, upErr)
+}
+
+// localIPFor returns our IP address on the interface used to reach dest.
+func localIPFor(dest net.IP) (net.IP, error) {
+	// UDP "connect" doesn't send anything, it just picks the route
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: dest, Port: 1})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
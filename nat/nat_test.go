@@ -0,0 +1,159 @@
+package nat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseProcRoute
+func TestParseProcRoute(t *testing.T) {
+	route := "Iface\tDestination\tGateway\tFlags\tRefCnt\tUse\tMetric\tMask\n" +
+		"eth0\t0000A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\n" +
+		"eth0\t00000000\t0101A8C0\t0003\t0\t0\t0\t00000000\n"
+	gw, err := parseProcRoute(bufio.NewScanner(strings.NewReader(route)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gw.Equal(net.IPv4(192, 168, 1, 1)) {
+		t.Fatalf("gateway %s, expect 192.168.1.1", gw)
+	}
+}
+
+// fakePMP answers NAT-PMP requests like a router would
+func fakePMP(t *testing.T, conn net.PacketConn) {
+	buf := make([]byte, 64)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 2 {
+			continue
+		}
+		resp := make([]byte, 16)
+		resp[1] = buf[1] | 0x80
+		binary.BigEndian.PutUint32(resp[4:8], 1000)
+		switch buf[1] {
+		case pmpOpExternal:
+			copy(resp[8:12], []byte{203, 0, 113, 7})
+			conn.WriteTo(resp[:12], from)
+		case pmpOpMapTCP:
+			copy(resp[8:10], buf[4:6])
+			// give a different port than asked for
+			binary.BigEndian.PutUint16(resp[10:12],
+				binary.BigEndian.Uint16(buf[6:8])+1)
+			copy(resp[12:16], buf[8:12])
+			conn.WriteTo(resp, from)
+		}
+	}
+}
+
+// PMP ExternalIP, AddPortMapping, DeletePortMapping
+func TestPMP(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go fakePMP(t, conn)
+
+	p := NewPMP(net.IPv4(127, 0, 0, 1))
+	p.port = conn.LocalAddr().(*net.UDPAddr).Port
+
+	ip, err := p.ExternalIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.IPv4(203, 0, 113, 7)) {
+		t.Fatalf("external IP %s", ip)
+	}
+	ext, err := p.AddPortMapping(2448, 2448, "lit", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext != 2449 {
+		t.Fatalf("mapped to %d, expect 2449", ext)
+	}
+	err = p.DeletePortMapping(2448, ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<device>
+ <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+ <deviceList><device>
+  <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+  <deviceList><device>
+   <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+   <serviceList><service>
+    <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+    <controlURL>/ctl/IPConn</controlURL>
+   </service></serviceList>
+  </device></deviceList>
+ </device></deviceList>
+</device>
+</root>`
+
+// upnpFromLocation, UPnP ExternalIP, AddPortMapping
+func TestUPnP(t *testing.T) {
+	var lastAction, lastBody string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/rootDesc.xml" {
+				fmt.Fprint(w, testDescription)
+				return
+			}
+			if r.URL.Path != "/ctl/IPConn" {
+				http.NotFound(w, r)
+				return
+			}
+			lastAction = r.Header.Get("SOAPAction")
+			body, _ := ioutil.ReadAll(r.Body)
+			lastBody = string(body)
+			fmt.Fprint(w, `<?xml version="1.0"?>`+
+				`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">`+
+				`<s:Body><u:GetExternalIPAddressResponse>`+
+				`<NewExternalIPAddress>198.51.100.3</NewExternalIPAddress>`+
+				`</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`)
+		}))
+	defer srv.Close()
+
+	u, err := upnpFromLocation(srv.URL + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.controlURL != srv.URL+"/ctl/IPConn" {
+		t.Fatalf("control URL %s", u.controlURL)
+	}
+
+	ip, err := u.ExternalIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("198.51.100.3")) {
+		t.Fatalf("external IP %s", ip)
+	}
+
+	_, err = u.AddPortMapping(2448, 2448, "lit", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastAction != `"urn:schemas-upnp-org:service:WANIPConnection:1#AddPortMapping"` {
+		t.Fatalf("SOAPAction %s", lastAction)
+	}
+	if !strings.Contains(lastBody, "<NewExternalPort>2448</NewExternalPort>") ||
+		!strings.Contains(lastBody, "<NewLeaseDuration>3600</NewLeaseDuration>") {
+		t.Fatalf("bad AddPortMapping body %s", lastBody)
+	}
+}
@@ -0,0 +1,147 @@
+package nat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	pmpPort = 5351
+
+	pmpOpExternal = 0
+	pmpOpMapTCP   = 2
+
+	// the RFC says 9 tries, doubling from 250ms, but that's over a minute
+	pmpTries = 4
+)
+
+// PMP is a NAT-PMP gateway.
+type PMP struct {
+	gateway net.IP
+	port    int
+}
+
+// NewPMP makes a NAT-PMP client for the given gateway.
+func NewPMP(gateway net.IP) *PMP {
+	return &PMP{gateway: gateway, port: pmpPort}
+}
+
+// Name is "NAT-PMP"
+func (p *PMP) Name() string {
+	return "NAT-PMP"
+}
+
+// ExternalIP asks the gateway for its public address.
+func (p *PMP) ExternalIP() (net.IP, error) {
+	resp, err := p.call([]byte{0, pmpOpExternal}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+// AddPortMapping maps a TCP port.
+func (p *PMP) AddPortMapping(intPort, extPort uint16,
+	desc string, lifetime time.Duration) (uint16, error) {
+
+	resp, err := p.call(pmpMapRequest(intPort, extPort, lifetime), 16)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(resp[10:12]), nil
+}
+
+// DeletePortMapping removes a mapping; that's a map request with lifetime 0.
+func (p *PMP) DeletePortMapping(intPort, extPort uint16) error {
+	_, err := p.call(pmpMapRequest(intPort, 0, 0), 16)
+	return err
+}
+
+// pmpMapRequest builds a TCP mapping request:
+// version, op, reserved(2), internal port, external port, lifetime(4)
+func pmpMapRequest(intPort, extPort uint16, lifetime time.Duration) []byte {
+	req := make([]byte, 12)
+	req[1] = pmpOpMapTCP
+	binary.BigEndian.PutUint16(req[4:6], intPort)
+	binary.BigEndian.PutUint16(req[6:8], extPort)
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	return req
+}
+
+// call sends a request to the gateway and waits for a response of respLen
+// bytes, resending with exponential backoff.  Responses are
+// version, op+128, result code(2), seconds since epoch(4), then op data.
+func (p *PMP) call(req []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil,
+		&net.UDPAddr{IP: p.gateway, Port: p.port})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	resp := make([]byte, 16)
+	wait := 250 * time.Millisecond
+	for i := 0; i < pmpTries; i++ {
+		_, err = conn.Write(req)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(wait))
+		wait *= 2
+
+		n, err := conn.Read(resp)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		if n < respLen || resp[0] != 0 || resp[1] != req[1]|0x80 {
+			// not an answer to our question; ignore
+			continue
+		}
+		result := binary.BigEndian.Uint16(resp[2:4])
+		if result != 0 {
+			return nil, fmt.Errorf("NAT-PMP: gateway %s result code %d",
+				p.gateway, result)
+		}
+		return resp[:respLen], nil
+	}
+	return nil, fmt.Errorf("NAT-PMP: no response from %s", p.gateway)
+}
+
+// defaultGateway finds the IPv4 default route's gateway.  Only linux is
+// supported, from /proc/net/route.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("can't find default gateway: %s", err.Error())
+	}
+	defer f.Close()
+	return parseProcRoute(bufio.NewScanner(f))
+}
+
+// parseProcRoute reads lines like
+// "eth0	00000000	0101A8C0	0003	0	0	0	00000000..."
+// where the gateway is little-endian hex, and the default route has
+// destination 0.
+func parseProcRoute(sc *bufio.Scanner) (net.IP, error) {
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := hex.DecodeString(fields[2])
+		if err != nil || len(gw) != 4 {
+			continue
+		}
+		return net.IPv4(gw[3], gw[2], gw[1], gw[0]), nil
+	}
+	return nil, fmt.Errorf("no default route")
+}
@@ -0,0 +1,254 @@
+package nat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const ssdpAdr = "239.255.255.250:1900"
+
+// the two kinds of IGD service which can map ports
+var upnpServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// UPnP is an internet gateway device found with SSDP.
+type UPnP struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+}
+
+// Name is "UPnP"
+func (u *UPnP) Name() string {
+	return "UPnP"
+}
+
+// DiscoverUPnP multicasts an SSDP search for an internet gateway and
+// returns the first one with a port mapping service.
+func DiscoverUPnP(timeout time.Duration) (*UPnP, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAdr)
+	if err != nil {
+		return nil, err
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAdr + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	_, err = conn.WriteTo([]byte(search), dst)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 2048)
+	for time.Now().Before(deadline) {
+		conn.SetReadDeadline(deadline)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("UPnP: no gateway found: %s", err.Error())
+		}
+		location := ssdpLocation(buf[:n])
+		if location == "" {
+			continue
+		}
+		u, err := upnpFromLocation(location)
+		if err != nil {
+			// maybe another device will answer
+			continue
+		}
+		return u, nil
+	}
+	return nil, fmt.Errorf("UPnP: no gateway found")
+}
+
+// ssdpLocation gets the LOCATION header from an SSDP response.
+func ssdpLocation(resp []byte) string {
+	rd := bufio.NewReader(bytes.NewReader(resp))
+	r, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		return ""
+	}
+	return r.Header.Get("Location")
+}
+
+// upnpDevice is the part of a device description we look through; devices
+// nest, and the WAN connection service is a few levels down.
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findService looks through a device tree for a port mapping service.
+func (d *upnpDevice) findService() (serviceType, controlURL string) {
+	for _, s := range d.Services {
+		for _, st := range upnpServices {
+			if s.ServiceType == st {
+				return s.ServiceType, s.ControlURL
+			}
+		}
+	}
+	for i := range d.Devices {
+		st, cu := d.Devices[i].findService()
+		if st != "" {
+			return st, cu
+		}
+	}
+	return "", ""
+}
+
+// upnpFromLocation fetches a device description and finds its control URL.
+func upnpFromLocation(location string) (*UPnP, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var root struct {
+		Device upnpDevice `xml:"device"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&root)
+	if err != nil {
+		return nil, err
+	}
+	serviceType, controlURL := root.Device.findService()
+	if serviceType == "" {
+		return nil, fmt.Errorf("UPnP: %s has no WAN connection service", location)
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	ctl, err := base.Parse(controlURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// the router needs to know where to send the connections
+	gwHost, _, err := net.SplitHostPort(base.Host)
+	if err != nil {
+		gwHost = base.Host
+	}
+	localIP, err := localIPFor(net.ParseIP(gwHost))
+	if err != nil {
+		return nil, err
+	}
+
+	u := new(UPnP)
+	u.controlURL = ctl.String()
+	u.serviceType = serviceType
+	u.localIP = localIP
+	return u, nil
+}
+
+// soap calls an action on the gateway's control URL, returning the body of
+// the response.
+func (u *UPnP) soap(action string, args [][2]string) ([]byte, error) {
+	var argXML bytes.Buffer
+	for _, a := range args {
+		argXML.WriteString("<" + a[0] + ">")
+		xml.EscapeText(&argXML, []byte(a[1]))
+		argXML.WriteString("</" + a[0] + ">")
+	}
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + u.serviceType + `">` +
+		argXML.String() +
+		`</u:` + action + `></s:Body></s:Envelope>`
+
+	req, err := http.NewRequest("POST", u.controlURL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.serviceType+"#"+action+`"`)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP %s: %s", action, resp.Status)
+	}
+	return respBody, nil
+}
+
+// ExternalIP asks the gateway for its public address.
+func (u *UPnP) ExternalIP() (net.IP, error) {
+	body, err := u.soap("GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	err = xml.Unmarshal(body, &reply)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(reply.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("UPnP: bad external IP %q", reply.IP)
+	}
+	return ip, nil
+}
+
+// AddPortMapping maps a TCP port.  UPnP gives us the port we ask for or
+// fails.
+func (u *UPnP) AddPortMapping(intPort, extPort uint16,
+	desc string, lifetime time.Duration) (uint16, error) {
+
+	_, err := u.soap("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprintf("%d", extPort)},
+		{"NewProtocol", "TCP"},
+		{"NewInternalPort", fmt.Sprintf("%d", intPort)},
+		{"NewInternalClient", u.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", desc},
+		{"NewLeaseDuration", fmt.Sprintf("%d", lifetime/time.Second)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return extPort, nil
+}
+
+// DeletePortMapping removes a mapping.
+func (u *UPnP) DeletePortMapping(intPort, extPort uint16) error {
+	_, err := u.soap("DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprintf("%d", extPort)},
+		{"NewProtocol", "TCP"},
+	})
+	return err
+}
@@ -69,6 +69,11 @@ func TxoModeFromPkScript(script []byte) TxoMode {
 		mode = TxoP2WSHComp // does compressed even mean anything for SH..?
 	}
 
+	// check for taproot (witness v1, 32 byte program)
+	if len(script) == 34 && script[0] == 0x51 && script[1] == 0x20 {
+		mode = TxoP2TR
+	}
+
 	// couldn't find anything, unknown
 	return mode
 }
@@ -59,6 +59,7 @@ const (
 	FlagTxoWitness      TxoMode = 0x04
 	FlagTxoCompressed   TxoMode = 0x08
 	FlagTxoUncompressed TxoMode = 0x10
+	FlagTxoTaproot      TxoMode = 0x20
 
 	// fully specified tx output modes
 	// raw pubkey outputs (old school)
@@ -81,6 +82,9 @@ const (
 	TxoP2WSHUncomp = FlagTxoWitness | FlagTxoScript | FlagTxoUncompressed
 	TxoP2WSHComp   = FlagTxoWitness | FlagTxoScript | FlagTxoCompressed
 
+	// witness v1 taproot, key path only
+	TxoP2TR = FlagTxoWitness | FlagTxoTaproot
+
 	// unknown
 	TxoUnknownMode = 0x80
 )
@@ -100,6 +104,8 @@ var modeStrings = map[TxoMode]string{
 
 	TxoP2WSHUncomp: "witness script hash uncompressed",
 	TxoP2WSHComp:   "witness script hash compressed",
+
+	TxoP2TR: "taproot key path",
 }
 
 // String returns the InvType in human-readable form.
@@ -308,6 +314,7 @@ func (u *PorTxo) EstSize() int64 {
 	// P2 PKH is op,seq (40) + pub(33) + sig(71) = 144
 	// P2 WPKH is op,seq(40) + [(33+71 / 4) = 26] = 66
 	// P2 WSH is op,seq(40) + [75(script) + 71]/4 (36) = 76
+	// P2 TR is op,seq(40) + [(1+1+64) / 4 = 17] = 57
 	switch u.Mode {
 	case TxoP2PKHComp: // non witness is about 150 bytes
 		return 144
@@ -315,6 +322,8 @@ func (u *PorTxo) EstSize() int64 {
 		return 66
 	case TxoP2WSHComp:
 		return 76
+	case TxoP2TR: // schnorr sig only, no pubkey
+		return 57
 	}
 	return 150 // guess that unknown is 150 bytes
 }
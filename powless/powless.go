@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/adiabat/bech32"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/lnutil"
@@ -63,9 +64,15 @@ ChainHook interface
 		chan lnutil.TxAndHeight, chan int32, error)
 
 	RegisterAddress(address [20]byte) error
+	RegisterTaprootKey(outKey [32]byte) error
 	RegisterOutPoint(wire.OutPoint) error
 
+	Rescan(fromHeight int32) error
+	RescanScripts(fromHeight, toHeight int32, scripts [][]byte) error
+	BlockHash(height int32) (*chainhash.Hash, error)
+
 	PushTx(tx *wire.MsgTx) error
+	RebroadcastTx(tx *wire.MsgTx) error
 
 	RawBlocks() chan *wire.MsgBlock
 
@@ -79,6 +86,8 @@ type APILink struct {
 	// Using struct{} saves a byte of RAM but is ugly so I'll use bool.
 	TrackingAdrs    map[[20]byte]bool
 	TrackingAdrsMtx sync.Mutex
+	// taproot output keys; TrackingAdrsMtx covers these too
+	TrackingTapKeys map[[32]byte]bool
 
 	TrackingOPs    map[wire.OutPoint]bool
 	TrackingOPsMtx sync.Mutex
@@ -90,6 +99,10 @@ type APILink struct {
 	// we've "synced" up to this height; older txs won't get pushed up to wallit
 	height int32
 
+	// if not 0, the next address query goes back to here instead of height
+	rescanFrom    int32
+	rescanFromMtx sync.Mutex
+
 	// this is the hash on the tip of the chain; if it changes, we need to update
 	tipBlockHash string
 
@@ -108,6 +121,7 @@ func (a *APILink) Start(
 	a.p = params
 
 	a.TrackingAdrs = make(map[[20]byte]bool)
+	a.TrackingTapKeys = make(map[[32]byte]bool)
 	a.TrackingOPs = make(map[wire.OutPoint]bool)
 
 	a.TxUpToWallit = make(chan lnutil.TxAndHeight, 1)
@@ -153,6 +167,11 @@ func (a *APILink) PushTx(tx *wire.MsgTx) error {
 	return err
 }
 
+// RebroadcastTx pushes the tx to the indexer again.
+func (a *APILink) RebroadcastTx(tx *wire.MsgTx) error {
+	return a.PushTx(tx)
+}
+
 // RegisterAddress gets a 20 byte address from the wallit and starts
 // watching for utxos at that address.
 func (a *APILink) RegisterAddress(adr160 [20]byte) error {
@@ -166,6 +185,18 @@ func (a *APILink) RegisterAddress(adr160 [20]byte) error {
 	return nil
 }
 
+// RegisterTaprootKey gets a 32 byte taproot output key from the wallit and
+// starts watching for utxos paying it.
+func (a *APILink) RegisterTaprootKey(outKey [32]byte) error {
+	log.Printf("register taproot %x\n", outKey)
+	a.TrackingAdrsMtx.Lock()
+	a.TrackingTapKeys[outKey] = true
+	a.TrackingAdrsMtx.Unlock()
+	a.dirtyChan <- nil
+
+	return nil
+}
+
 // RegisterOutPoint gets an outpoint from the wallit and starts looking
 // for txins that spend it.
 func (a *APILink) RegisterOutPoint(op wire.OutPoint) error {
@@ -272,11 +303,42 @@ type VRawTx struct {
 	Tx      string
 }
 
+// Rescan has the next address query ask for everything since fromHeight.
+// There aren't blocks to go through again; the indexer has it all.
+func (a *APILink) Rescan(fromHeight int32) error {
+	if fromHeight < 1 || fromHeight > a.height {
+		return fmt.Errorf("can't rescan from %d; synced to %d",
+			fromHeight, a.height)
+	}
+	a.rescanFromMtx.Lock()
+	a.rescanFrom = fromHeight
+	a.rescanFromMtx.Unlock()
+
+	a.dirtyChan <- nil
+	return nil
+}
+
+// RescanScripts is just a Rescan; the address queries only ask about our
+// addresses anyway.
+func (a *APILink) RescanScripts(fromHeight, toHeight int32, scripts [][]byte) error {
+	return a.Rescan(fromHeight)
+}
+
 // GetVAdrTxos gets new utxos for the wallet from the indexer.
 func (a *APILink) GetVAdrTxos() error {
 
 	apitxourl := a.apiUrl + "addressTxosSince/"
 
+	// ask from the usual height, or further back for a rescan
+	a.rescanFromMtx.Lock()
+	since := a.height
+	rescan := a.rescanFrom != 0
+	if rescan {
+		since = a.rescanFrom
+		a.rescanFrom = 0
+	}
+	a.rescanFromMtx.Unlock()
+
 	var urls []string
 	a.TrackingAdrsMtx.Lock()
 	for adr160, _ := range a.TrackingAdrs {
@@ -290,9 +352,19 @@ func (a *APILink) GetVAdrTxos() error {
 
 		// make request URLs for both
 		urls = append(urls,
-			fmt.Sprintf("%s%d/%s%s", apitxourl, a.height, adrBch, "?raw=1"))
+			fmt.Sprintf("%s%d/%s%s", apitxourl, since, adrBch, "?raw=1"))
 		urls = append(urls,
-			fmt.Sprintf("%s%d/%s%s", apitxourl, a.height, adr58, "?raw=1"))
+			fmt.Sprintf("%s%d/%s%s", apitxourl, since, adr58, "?raw=1"))
+	}
+	for tapKey, _ := range a.TrackingTapKeys {
+		// taproot keys only have a bech32m address
+		adrTap, err := lnutil.TaprootAddressEncode(a.p.Bech32Prefix, tapKey)
+		if err != nil {
+			a.TrackingAdrsMtx.Unlock()
+			return err
+		}
+		urls = append(urls,
+			fmt.Sprintf("%s%d/%s%s", apitxourl, since, adrTap, "?raw=1"))
 	}
 	a.TrackingAdrsMtx.Unlock()
 
@@ -350,6 +422,10 @@ func (a *APILink) GetVAdrTxos() error {
 		}
 	}
 	log.Printf("GetVAdrTxos complete\n")
+	if rescan {
+		// the wallit takes getting back to the tip as the rescan being done
+		a.CurrentHeightChan <- a.height
+	}
 	return nil
 }
 
@@ -460,6 +536,16 @@ func (a *APILink) VGetRawTx(txid string) (*wire.MsgTx, error) {
 	return tx, nil
 }
 
+// BlockHash gives the hash of the tip; that's the only one the indexer
+// tells us about.
+func (a *APILink) BlockHash(height int32) (*chainhash.Hash, error) {
+	if height != a.height || a.tipBlockHash == "" {
+		return nil, fmt.Errorf("only know the hash of tip %d, not %d",
+			a.height, height)
+	}
+	return chainhash.NewHashFromStr(a.tipBlockHash)
+}
+
 func (a *APILink) UpdateHeight(height int32) {
 	// if it's an increment (note reorgs are still... not a thing yet)
 	if height > a.height {
@@ -0,0 +1,191 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+An alias is the name a node picks for itself, like "bob's node", where a
+nickname is one we give a peer ourselves.  Nodes announce their alias to
+peers when they connect, signed with their identity key along with when it
+was set, so peers with gossip on can pass it along to theirs; wherever it
+gets to, a later one replaces an earlier one.  Peer listings show both.
+Anyone can call their node anything, so a nickname, being ours, is the one
+to go by.
+*/
+
+// maxAliases is how many nodes' aliases we keep.  Past that, only peers'
+// get saved; the rest, which gossip could bring any number of, don't.
+const maxAliases = 10000
+
+// SetAlias signs alias as ours, saves it and tells connected peers.  An
+// empty alias clears it.
+func (nd *LitNode) SetAlias(alias string) error {
+	err := lnutil.CheckAlias(alias)
+	if err != nil {
+		return err
+	}
+	var pub [33]byte
+	copy(pub[:], nd.IdKey().PubKey().SerializeCompressed())
+	msg := lnutil.NewNodeAliasMsg(0, pub, time.Now().Unix(), alias)
+	// a new one has to be later than the last, even if the clock went back
+	if old, ok := nd.aliasMsg(pub); ok && msg.Time <= old.Time {
+		msg.Time = old.Time + 1
+	}
+	h := msg.SigHash()
+	msg.Sig, err = lnutil.SignHash(lnutil.SigTypeECDSA, nd.IdKey(), h[:])
+	if err != nil {
+		return err
+	}
+	_, err = nd.saveAliasMsg(msg)
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range nd.peerList() {
+		if peer.HasFeature(lnutil.FeatureAliasOptional) {
+			msg.PeerIdx = peer.Idx
+			nd.OmniOut <- msg
+		}
+	}
+	return nil
+}
+
+// MyAlias is the alias we announce; empty if there isn't one.
+func (nd *LitNode) MyAlias() string {
+	var pub [33]byte
+	copy(pub[:], nd.IdKey().PubKey().SerializeCompressed())
+	return nd.GetAlias(pub)
+}
+
+// GetAlias is the alias a node announced; empty if we haven't heard one.
+func (nd *LitNode) GetAlias(pub [33]byte) string {
+	msg, ok := nd.aliasMsg(pub)
+	if !ok {
+		return ""
+	}
+	return msg.Alias
+}
+
+// aliasMsg is a node's latest alias announcement.
+func (nd *LitNode) aliasMsg(pub [33]byte) (lnutil.NodeAliasMsg, bool) {
+	var msg lnutil.NodeAliasMsg
+	var ok bool
+	nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTAliases)
+		if bkt == nil {
+			return nil
+		}
+		v := bkt.Get(pub[:])
+		if v == nil {
+			return nil
+		}
+		var err error
+		msg, err = lnutil.NewNodeAliasMsgFromBytes(v, 0)
+		ok = err == nil
+		return nil
+	})
+	return msg, ok
+}
+
+// aliasFromBytes gets the alias from a saved announcement.
+func aliasFromBytes(v []byte) string {
+	if v == nil {
+		return ""
+	}
+	msg, err := lnutil.NewNodeAliasMsgFromBytes(v, 0)
+	if err != nil {
+		return ""
+	}
+	return msg.Alias
+}
+
+// saveAliasMsg saves an alias announcement, and says whether it's news:
+// later than the one we had, and not dropped because we have too many.
+func (nd *LitNode) saveAliasMsg(msg lnutil.NodeAliasMsg) (bool, error) {
+	var news bool
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTAliases)
+		if bkt == nil {
+			return fmt.Errorf("no aliases bucket")
+		}
+		v := bkt.Get(msg.PubKey[:])
+		if v != nil {
+			old, err := lnutil.NewNodeAliasMsgFromBytes(v, 0)
+			if err == nil && msg.Time <= old.Time {
+				return nil
+			}
+		} else if bkt.Stats().KeyN >= maxAliases {
+			prs := btx.Bucket(BKTPeers)
+			if prs == nil || prs.Bucket(msg.PubKey[:]) == nil {
+				return nil
+			}
+		}
+		news = true
+		return bkt.Put(msg.PubKey[:], msg.Bytes())
+	})
+	return news, err
+}
+
+// sendAlias tells a peer that just connected our alias, if we have one.
+func (nd *LitNode) sendAlias(peerIdx uint32) {
+	peer, ok := nd.GetPeer(peerIdx)
+	if !ok || !peer.HasFeature(lnutil.FeatureAliasOptional) {
+		return
+	}
+	var pub [33]byte
+	copy(pub[:], nd.IdKey().PubKey().SerializeCompressed())
+	msg, ok := nd.aliasMsg(pub)
+	if !ok {
+		return
+	}
+	msg.PeerIdx = peerIdx
+	nd.OmniOut <- msg
+}
+
+// NodeAliasHandler saves a node's alias, if it's signed by them and later
+// than the one we have, and passes it on to peers with gossip on.  Only
+// peers we gossip with can tell us other nodes' aliases.
+func (nd *LitNode) NodeAliasHandler(msg lnutil.NodeAliasMsg, peer *RemotePeer) error {
+	if peer.Con.RemotePub == nil {
+		return nil // already closed
+	}
+	var from [33]byte
+	copy(from[:], peer.Con.RemotePub.SerializeCompressed())
+	if msg.PubKey != from && !peer.HasFeature(lnutil.FeatureGossipOptional) {
+		return fmt.Errorf("peer %d sent alias for %x without gossip",
+			msg.Peer(), msg.PubKey)
+	}
+	err := lnutil.CheckAlias(msg.Alias)
+	if err != nil {
+		return fmt.Errorf("peer %d sent bad alias for %x: %s",
+			msg.Peer(), msg.PubKey, err.Error())
+	}
+	h := msg.SigHash()
+	err = lnutil.VerifyHash(lnutil.SigTypeECDSA, msg.PubKey, h[:], msg.Sig)
+	if err != nil {
+		return fmt.Errorf("peer %d sent alias for %x: %s",
+			msg.Peer(), msg.PubKey, err.Error())
+	}
+
+	news, err := nd.saveAliasMsg(msg)
+	if err != nil || !news {
+		return err
+	}
+	log.Debugf("node %x goes by %q\n", msg.PubKey, msg.Alias)
+
+	// pass it on, with no locks held; OmniOut can fill up
+	origIdx := msg.Peer()
+	for _, p := range nd.peerList() {
+		if p.Idx != origIdx && p.HasFeature(lnutil.FeatureGossipOptional) &&
+			p.HasFeature(lnutil.FeatureAliasOptional) {
+			msg.PeerIdx = p.Idx
+			nd.OmniOut <- msg
+		}
+	}
+	return nil
+}
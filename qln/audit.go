@@ -0,0 +1,394 @@
+package qln
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+The audit log is a record of what an operator or an investigator would
+want to know happened: channels opening and closing, pushes, rpc clients
+connecting, remote control calls, and private keys getting dumped.  It's
+audit.log in the lit folder, one json event a line, and only ever gets
+appended to.  It's kept apart from ln.db so restoring a channel db backup
+doesn't take it back.  The last event's sequence number and MAC are kept in
+ln.db too, so events cut off the end of the log show up.
+
+Each event gets the next sequence number, and a MAC over the event and the
+MAC of the one before it, keyed from the node's identity key.  Changing,
+dropping or reordering events breaks the chain from there on, and making a
+new chain that checks out needs the key.  VerifyAudit checks the chain; a
+chain broken when lit starts gets logged, and if the log is short of what
+ln.db says, that goes in the log as well, as it'd otherwise be lost once
+new events are added.
+
+lit's rpc has no authentication, so for rpc the log has who connected, from
+where.
+*/
+
+// audit event types
+const (
+	AuditStart      = "start"
+	AuditChanOpen   = "chanopen"
+	AuditChanClose  = "chanclose"
+	AuditPushSent   = "pushsent"
+	AuditPushRecv   = "pushrecv"
+	AuditRPCConnect = "rpcconnect"
+	AuditDumpPrivs  = "dumpprivs"
+	AuditRemoteRPC  = "remoterpc"
+	AuditBroken     = "broken" // what was wrong with the log at start
+)
+
+// MaxAuditEvents is the most events AuditEvents gives at once.
+const MaxAuditEvents = 1000
+
+// AuditEvent is something that happened, for the audit log.
+type AuditEvent struct {
+	Seq     uint64
+	Time    int64 // unix
+	Type    string
+	Peer    uint32 `json:",omitempty"`
+	ChanIdx uint32 `json:",omitempty"`
+	Amt     int64  `json:",omitempty"`
+	Txid    string `json:",omitempty"`
+	Detail  string `json:",omitempty"`
+	// MAC chains the event to the one before; hex
+	MAC string
+}
+
+// auditLog is the open audit log file.
+type auditLog struct {
+	mtx     sync.Mutex
+	file    *os.File
+	key     []byte
+	offsets []int64 // where each event starts, by seq-1
+	lastMAC []byte
+
+	// head gives the sequence number and MAC of the last event saved, from
+	// outside the file; saveHead keeps them there
+	head     func() (uint64, []byte, error)
+	saveHead func(seq uint64, mac []byte) error
+}
+
+// auditMAC is the MAC for an event, given the MAC of the one before.
+func auditMAC(key, prev []byte, e AuditEvent) ([]byte, error) {
+	e.MAC = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(prev)
+	mac.Write(b)
+	return mac.Sum(nil), nil
+}
+
+// readAudit goes through the events in an audit log from offset, seq
+// first, calling f with each and where it starts.  It stops at the end,
+// or when f returns false, and gives where it stopped.  A last line with
+// no newline was cut short and is left out.
+func readAudit(r io.Reader, offset int64, seq uint64,
+	f func(e AuditEvent, offset int64) bool) (int64, error) {
+	br := bufio.NewReader(r)
+	for ; ; seq++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		var e AuditEvent
+		err = json.Unmarshal(line, &e)
+		if err != nil {
+			return offset, fmt.Errorf("audit event %d: %s", seq, err.Error())
+		}
+		if !f(e, offset) {
+			return offset, nil
+		}
+		offset += int64(len(line))
+	}
+}
+
+// openAudit opens the audit log at path, making it if it's not there, and
+// checks its chain against itself and against head.  A broken chain is
+// logged, not an error; events still get added after it.  A log that's
+// been cut short, or ends in a line a crash cut short, gets an event
+// saying so.
+func openAudit(path string, key []byte, head func() (uint64, []byte, error),
+	saveHead func(seq uint64, mac []byte) error) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{file: f, key: key, head: head, saveHead: saveHead}
+
+	headSeq, headMAC, err := head()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var broken error
+	var macAtHead []byte
+	end, err := readAudit(f, 0, 1, func(e AuditEvent, offset int64) bool {
+		if broken == nil {
+			broken = a.check(e)
+		}
+		a.offsets = append(a.offsets, offset)
+		a.lastMAC, _ = hex.DecodeString(e.MAC)
+		if uint64(len(a.offsets)) == headSeq {
+			macAtHead = a.lastMAC
+		}
+		return true
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// a line cut short by a crash can't be read; drop it
+	err = f.Truncate(end)
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if broken != nil {
+		log.Errorf("audit log %s: %s\n", path, broken.Error())
+	}
+
+	var notes []string
+	if info.Size() > end {
+		notes = append(notes, fmt.Sprintf(
+			"dropped %d bytes at the end, cut short", info.Size()-end))
+	}
+	bad := checkAuditHead(uint64(len(a.offsets)), macAtHead, headSeq, headMAC)
+	if bad != nil {
+		notes = append(notes, bad.Error())
+	}
+	for _, note := range notes {
+		log.Errorf("audit log %s: %s\n", path, note)
+		err = a.add(AuditEvent{Type: AuditBroken, Detail: note})
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// checkAuditHead checks a log of n events, whose event headSeq has MAC
+// macAtHead, against the head kept outside it.
+func checkAuditHead(n uint64, macAtHead []byte, headSeq uint64, headMAC []byte) error {
+	if n < headSeq {
+		return fmt.Errorf("log ends at event %d, but event %d was written; cut off",
+			n, headSeq)
+	}
+	if headSeq != 0 && !hmac.Equal(macAtHead, headMAC) {
+		return fmt.Errorf("event %d isn't the one written; replaced", headSeq)
+	}
+	return nil
+}
+
+// check checks an event against the ones before it, as the next one.
+func (a *auditLog) check(e AuditEvent) error {
+	seq := uint64(len(a.offsets)) + 1
+	if e.Seq != seq {
+		return fmt.Errorf("event %d has sequence number %d", seq, e.Seq)
+	}
+	mac, err := auditMAC(a.key, a.lastMAC, e)
+	if err != nil {
+		return err
+	}
+	got, err := hex.DecodeString(e.MAC)
+	if err != nil || !hmac.Equal(mac, got) {
+		return fmt.Errorf("event %d MAC doesn't match; changed after", seq)
+	}
+	return nil
+}
+
+// add appends an event, filling in its sequence number, time and MAC.
+func (a *auditLog) add(e AuditEvent) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	e.Seq = uint64(len(a.offsets)) + 1
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	mac, err := auditMAC(a.key, a.lastMAC, e)
+	if err != nil {
+		return err
+	}
+	e.MAC = hex.EncodeToString(mac)
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	offset, err := a.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = a.file.Write(append(b, '\n'))
+	if err == nil {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		// don't leave half an event
+		a.file.Truncate(offset)
+		a.file.Seek(offset, io.SeekStart)
+		return err
+	}
+	a.offsets = append(a.offsets, offset)
+	a.lastMAC = mac
+	return a.saveHead(e.Seq, mac)
+}
+
+// events gives up to max events from seq from on.
+func (a *auditLog) events(from uint64, max int) ([]AuditEvent, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if from == 0 {
+		from = 1
+	}
+	if from > uint64(len(a.offsets)) {
+		return nil, nil
+	}
+	offset := a.offsets[from-1]
+	var evs []AuditEvent
+	r := io.NewSectionReader(a.file, offset, 1<<62)
+	_, err := readAudit(r, offset, from, func(e AuditEvent, _ int64) bool {
+		evs = append(evs, e)
+		return len(evs) < max
+	})
+	return evs, err
+}
+
+// verify checks the whole chain, giving how many events there are.
+func (a *auditLog) verify() (uint64, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	headSeq, headMAC, err := a.head()
+	if err != nil {
+		return 0, err
+	}
+	// check against a fresh chain, not the one open made
+	v := &auditLog{key: a.key}
+	var bad error
+	var macAtHead []byte
+	_, err = readAudit(io.NewSectionReader(a.file, 0, 1<<62), 0, 1,
+		func(e AuditEvent, offset int64) bool {
+			bad = v.check(e)
+			v.offsets = append(v.offsets, offset)
+			v.lastMAC, _ = hex.DecodeString(e.MAC)
+			if uint64(len(v.offsets)) == headSeq {
+				macAtHead = v.lastMAC
+			}
+			return bad == nil
+		})
+	if err != nil {
+		return 0, err
+	}
+	if bad != nil {
+		return uint64(len(v.offsets)) - 1, bad
+	}
+	n := uint64(len(v.offsets))
+	if n != uint64(len(a.offsets)) {
+		return n, fmt.Errorf("%d events in the file, %d written", n, len(a.offsets))
+	}
+	return n, checkAuditHead(n, macAtHead, headSeq, headMAC)
+}
+
+// auditKey is the audit log's MAC key, from the identity key.
+func (nd *LitNode) auditKey() []byte {
+	h := sha256.New()
+	h.Write([]byte("lit audit log"))
+	h.Write(nd.IdentityKey.Serialize())
+	return h.Sum(nil)
+}
+
+// auditHead gives the audit log's last sequence number and MAC from ln.db;
+// 0 and nil if it's never been written.
+func (nd *LitNode) auditHead() (uint64, []byte, error) {
+	var seq uint64
+	var mac []byte
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTAudit)
+		if bkt == nil {
+			return fmt.Errorf("no audit bucket")
+		}
+		v := bkt.Get(KEYAudHead)
+		if len(v) < 8 {
+			return nil
+		}
+		seq = lnutil.BtU64(v[:8])
+		mac = append([]byte(nil), v[8:]...)
+		return nil
+	})
+	return seq, mac, err
+}
+
+// saveAuditHead saves the audit log's last sequence number and MAC to
+// ln.db.
+func (nd *LitNode) saveAuditHead(seq uint64, mac []byte) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTAudit)
+		if bkt == nil {
+			return fmt.Errorf("no audit bucket")
+		}
+		return bkt.Put(KEYAudHead, append(lnutil.U64tB(seq), mac...))
+	})
+}
+
+// Audit adds an event to the audit log.
+func (nd *LitNode) Audit(e AuditEvent) error {
+	if nd.auditLog == nil {
+		return fmt.Errorf("no audit log")
+	}
+	return nd.auditLog.add(e)
+}
+
+// audit adds an event to the audit log, logging it if that fails.
+func (nd *LitNode) audit(e AuditEvent) {
+	err := nd.Audit(e)
+	if err != nil {
+		log.Errorf("audit %s: %s\n", e.Type, err.Error())
+	}
+}
+
+// AuditEvents gives up to max events from the audit log, starting at
+// sequence number from, so a reader can pick up where it left off.
+func (nd *LitNode) AuditEvents(from uint64, max int) ([]AuditEvent, error) {
+	if nd.auditLog == nil {
+		return nil, fmt.Errorf("no audit log")
+	}
+	if max <= 0 || max > MaxAuditEvents {
+		max = MaxAuditEvents
+	}
+	return nd.auditLog.events(from, max)
+}
+
+// VerifyAudit checks the audit log's chain, giving how many events are
+// good; with an error, the next one is where it breaks.
+func (nd *LitNode) VerifyAudit() (uint64, error) {
+	if nd.auditLog == nil {
+		return 0, fmt.Errorf("no audit log")
+	}
+	return nd.auditLog.verify()
+}
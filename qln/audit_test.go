@@ -0,0 +1,145 @@
+package qln
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memHead keeps an audit log's head in memory, as ln.db would.
+type memHead struct {
+	seq uint64
+	mac []byte
+}
+
+func (m *memHead) get() (uint64, []byte, error) { return m.seq, m.mac, nil }
+
+func (m *memHead) save(seq uint64, mac []byte) error {
+	m.seq, m.mac = seq, mac
+	return nil
+}
+
+// testAudit makes an audit log with n events in a new folder.
+func testAudit(t *testing.T, n int) (string, *memHead, *auditLog) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "audit.log")
+	head := new(memHead)
+	a, err := openAudit(path, []byte("key"), head.get, head.save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		err = a.add(AuditEvent{Type: AuditPushSent, Amt: int64(1000 + i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, head, a
+}
+
+func TestAuditVerify(t *testing.T) {
+	path, _, a := testAudit(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer a.file.Close()
+
+	n, err := a.verify()
+	if n != 3 || err != nil {
+		t.Fatalf("verified %d events, %v", n, err)
+	}
+	evs, err := a.events(2, 10)
+	if err != nil || len(evs) != 2 || evs[0].Amt != 1001 {
+		t.Fatalf("events from 2: %v %v", evs, err)
+	}
+}
+
+func TestAuditTampered(t *testing.T) {
+	path, _, a := testAudit(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+	defer a.file.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = []byte(strings.Replace(string(b), `"Amt":1001`, `"Amt":9001`, 1))
+	err = ioutil.WriteFile(path, b, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := a.verify()
+	if err == nil || n != 1 {
+		t.Fatalf("changed event 2 verified %d events, %v", n, err)
+	}
+}
+
+// lastEvent is the last event in an audit log.
+func lastEvent(t *testing.T, a *auditLog) AuditEvent {
+	evs, err := a.events(uint64(len(a.offsets)), 1)
+	if err != nil || len(evs) != 1 {
+		t.Fatalf("last event: %v %v", evs, err)
+	}
+	return evs[0]
+}
+
+func TestAuditCutOff(t *testing.T) {
+	path, head, a := testAudit(t, 3)
+	defer os.RemoveAll(filepath.Dir(path))
+	cut := a.offsets[2]
+	a.file.Close()
+
+	// dropping the last event leaves a good chain; the head shows it
+	err := os.Truncate(path, cut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err = openAudit(path, []byte("key"), head.get, head.save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := lastEvent(t, a)
+	if e.Type != AuditBroken || !strings.Contains(e.Detail, "cut off") {
+		t.Fatalf("cut off log, last event %v", e)
+	}
+	n, err := a.verify()
+	if n != 3 || err != nil {
+		t.Fatalf("verified %d events, %v", n, err)
+	}
+
+	// verify checks the head too
+	head.seq = 4
+	_, err = a.verify()
+	if err == nil {
+		t.Fatalf("head past the end verified")
+	}
+	a.file.Close()
+}
+
+func TestAuditCutShort(t *testing.T) {
+	path, head, a := testAudit(t, 2)
+	defer os.RemoveAll(filepath.Dir(path))
+	_, err := a.file.Write([]byte(`{"Seq":3,"Ti`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.file.Close()
+
+	a, err = openAudit(path, []byte("key"), head.get, head.save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.file.Close()
+	e := lastEvent(t, a)
+	if e.Seq != 3 || e.Type != AuditBroken ||
+		!strings.Contains(e.Detail, "cut short") {
+		t.Fatalf("cut short log, last event %v", e)
+	}
+	n, err := a.verify()
+	if n != 3 || err != nil {
+		t.Fatalf("verified %d events, %v", n, err)
+	}
+}
@@ -2,7 +2,6 @@ package qln
 
 import (
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/adiabat/bech32"
@@ -24,15 +23,13 @@ func (nd *LitNode) AutoReconnect(listenPort string, interval int64) {
 			var empty [33]byte
 			i := uint32(1)
 			for {
-				pubKey, _ := nd.GetPubHostFromPeerIdx(i)
+				pubKey, host := nd.GetPubHostFromPeerIdx(i)
 				if pubKey == empty {
-					log.Printf("Done, tried %d hosts\n", i-1)
+					log.Debugf("Done, tried %d hosts\n", i-1)
 					break
 				}
 
-				nd.RemoteMtx.Lock()
-				_, alreadyConnected := nd.RemoteCons[i]
-				nd.RemoteMtx.Unlock()
+				_, alreadyConnected := nd.GetPeer(i)
 
 				if alreadyConnected {
 					i++
@@ -41,11 +38,16 @@ func (nd *LitNode) AutoReconnect(listenPort string, interval int64) {
 
 				idHash := fastsha256.Sum256(pubKey[:])
 				adr := bech32.Encode("ln", idHash[:20])
+				// use the last host they told us about, if any.  Otherwise
+				// DialPeer asks the tracker.
+				if host != "" {
+					adr += "@" + host
+				}
 
 				err := nd.DialPeer(adr)
 
 				if err != nil {
-					log.Printf("Could not restore connection to %s: %s\n", adr, err.Error())
+					log.Errorf("Could not restore connection to %s: %s\n", adr, err.Error())
 				}
 
 				i++
@@ -0,0 +1,104 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// per-channel auto-watch overrides, saved in BKTAutoWch
+const (
+	AutoWatchDefault = "default" // do whatever the node does
+	AutoWatchOn      = "on"
+	AutoWatchOff     = "off"
+)
+
+// SetChanAutoWatch overrides whether a channel's states are sent to towers
+// automatically.  AutoWatchDefault removes the override.
+func (nd *LitNode) SetChanAutoWatch(cIdx uint32, policy string) error {
+	var v byte
+	switch policy {
+	case AutoWatchDefault:
+	case AutoWatchOn:
+		v = 1
+	case AutoWatchOff:
+		v = 0
+	default:
+		return fmt.Errorf("auto-watch policy %q; expect %s, %s or %s",
+			policy, AutoWatchOn, AutoWatchOff, AutoWatchDefault)
+	}
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTAutoWch)
+		if bkt == nil {
+			return fmt.Errorf("no auto-watch bucket")
+		}
+		if policy == AutoWatchDefault {
+			return bkt.Delete(lnutil.U32tB(cIdx))
+		}
+		return bkt.Put(lnutil.U32tB(cIdx), []byte{v})
+	})
+}
+
+// chanAutoWatch says whether a channel's states go to towers automatically:
+// its override if it has one, otherwise whether we have a default tower.
+func (nd *LitNode) chanAutoWatch(cIdx uint32) (bool, error) {
+	on := nd.AutoWatchTower != 0
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTAutoWch)
+		if bkt == nil {
+			return fmt.Errorf("no auto-watch bucket")
+		}
+		v := bkt.Get(lnutil.U32tB(cIdx))
+		if len(v) == 1 {
+			on = v[0] == 1
+		}
+		return nil
+	})
+	return on, err
+}
+
+// autoWatch sends a channel's new states to its towers, if the channel is
+// auto-watched, registering it with the default tower first if needed.
+// Loads the channel itself so it can run on its own while the channel
+// moves on.
+func (nd *LitNode) autoWatch(cIdx uint32) {
+	on, err := nd.chanAutoWatch(cIdx)
+	if err != nil {
+		log.Errorf("autoWatch channel %d: %s\n", cIdx, err.Error())
+		return
+	}
+	if !on {
+		return
+	}
+	qc, err := nd.GetQchanByIdx(cIdx)
+	if err != nil {
+		log.Errorf("autoWatch channel %d: %s\n", cIdx, err.Error())
+		return
+	}
+	// the tower can't watch a channel with itself, and paying it would
+	// make a new state to send it, and so on
+	if qc.CloseData.Closed || qc.Peer() == nd.AutoWatchTower {
+		return
+	}
+	towers, err := nd.ChanTowers(cIdx)
+	if err != nil {
+		log.Errorf("autoWatch channel %d: %s\n", cIdx, err.Error())
+		return
+	}
+	if nd.AutoWatchTower != 0 {
+		if _, ok := towers[nd.AutoWatchTower]; !ok {
+			err = nd.registerTower(qc, nd.AutoWatchTower)
+			if err != nil {
+				log.Errorf("autoWatch channel %d: %s\n", cIdx, err.Error())
+				return
+			}
+			log.Infof("auto-watching channel %d with tower %d\n",
+				cIdx, nd.AutoWatchTower)
+		}
+	}
+	err = nd.SyncTowers(qc)
+	if err != nil {
+		log.Errorf("autoWatch: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,178 @@
+package qln
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+)
+
+/*
+Backups are snapshots of the channel db, ln.db, made every so often and
+right after a channel opens or closes.  Each goes in the backup folder as
+ln-<time>.db, where only the newest few are kept, then gets copied to each
+backup target: another folder, an scp destination, or an S3-compatible
+bucket.  Old backups on targets are left for the target to clean up, with
+something like an S3 lifecycle rule.
+
+A backup is only as current as when it was made.  Restoring one from
+before the last state update, and then closing the channel from it,
+broadcasts a revoked state, and the peer can take everything in the
+channel.  They're for when the alternative is losing the channels anyway.
+*/
+
+const (
+	backupPrefix = "ln-"
+	backupSuffix = ".db"
+	// backupTimeFormat sorts in time order
+	backupTimeFormat = "20060102-150405"
+	// DefaultBackupKeep is how many backups stay in the backup folder.
+	DefaultBackupKeep = 7
+)
+
+// backups is what's needed to make backups; nil when not making any.
+type backups struct {
+	dir     string
+	targets []backupTarget
+	keep    int
+	// req asks the backup loop for one soon
+	req chan struct{}
+	// one backup at a time
+	mtx sync.Mutex
+}
+
+// StartBackups starts backing up the channel db to dir, then to each
+// target, every interval (0 for only after channels open and close),
+// keeping the newest keep in dir.
+func (nd *LitNode) StartBackups(
+	dir string, targets []string, interval time.Duration, keep int) error {
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	if keep < 1 {
+		return fmt.Errorf("keep %d backups; need at least 1", keep)
+	}
+	b := &backups{dir: dir, keep: keep, req: make(chan struct{}, 1)}
+	for _, s := range targets {
+		t, err := nd.parseBackupTarget(s)
+		if err != nil {
+			return err
+		}
+		b.targets = append(b.targets, t)
+	}
+
+	nd.BackupMtx.Lock()
+	nd.backups = b
+	nd.BackupMtx.Unlock()
+
+	go nd.backupLoop(b, interval)
+	return nil
+}
+
+// backupLoop makes a backup when asked, and every interval.
+func (nd *LitNode) backupLoop(b *backups, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-b.req:
+		}
+		_, err := nd.Backup()
+		if err != nil {
+			log.Errorf("backup error %s\n", err.Error())
+		}
+	}
+}
+
+// requestBackup has a backup made soon, if backups are on.  Asks while
+// one's already waiting don't make another.
+func (nd *LitNode) requestBackup() {
+	nd.BackupMtx.Lock()
+	b := nd.backups
+	nd.BackupMtx.Unlock()
+	if b == nil {
+		return
+	}
+	select {
+	case b.req <- struct{}{}:
+	default:
+	}
+}
+
+// Backup backs up the channel db now, giving the backup's file name.
+// Copying to targets is tried for each, even if some fail.
+func (nd *LitNode) Backup() (string, error) {
+	nd.BackupMtx.Lock()
+	b := nd.backups
+	nd.BackupMtx.Unlock()
+	if b == nil {
+		return "", fmt.Errorf("backups are off; start lit with --backupdir")
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	name := backupPrefix + time.Now().UTC().Format(backupTimeFormat) + backupSuffix
+	file := filepath.Join(b.dir, name)
+	err := kvdb.Snapshot(nd.LitDB, file)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("backed up channel db to %s\n", file)
+
+	err = b.prune()
+	if err != nil {
+		log.Errorf("backup prune error %s\n", err.Error())
+	}
+
+	var failed []string
+	for _, t := range b.targets {
+		err = t.Put(file, name)
+		if err != nil {
+			log.Errorf("backup to %s error %s\n", t, err.Error())
+			failed = append(failed, t.String())
+		}
+	}
+	if len(failed) != 0 {
+		return name, fmt.Errorf("backup %s not copied to %s",
+			name, strings.Join(failed, ", "))
+	}
+	return name, nil
+}
+
+// prune deletes all but the newest backups in the backup folder.
+func (b *backups) prune() error {
+	fis, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.IsDir() && strings.HasPrefix(name, backupPrefix) &&
+			strings.HasSuffix(name, backupSuffix) {
+			names = append(names, name)
+		}
+	}
+	if len(names) <= b.keep {
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-b.keep] {
+		err = os.Remove(filepath.Join(b.dir, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package qln
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// BanInfo is a banned peer, and when the ban runs out (0 for never)
+type BanInfo struct {
+	Pubkey [33]byte
+	Until  int64
+}
+
+// BanPeer bans a pubkey for duration, or forever if duration is 0.  Banned
+// peers can't connect to us and we won't connect to them.  If they're
+// connected now, they get disconnected.
+func (nd *LitNode) BanPeer(pub [33]byte, duration time.Duration) error {
+	var until int64
+	if duration > 0 {
+		until = time.Now().Add(duration).Unix()
+	}
+
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bans := btx.Bucket(BKTBans)
+		if bans == nil {
+			return fmt.Errorf("no bans bucket")
+		}
+		return bans.Put(pub[:], lnutil.I64tB(until))
+	})
+	if err != nil {
+		return err
+	}
+
+	// kick them off if they're here now
+	var kick []*RemotePeer
+	for _, peer := range nd.peerList() {
+		if peer.Con.RemotePub == nil {
+			continue // already closed
+		}
+		var peerPub [33]byte
+		copy(peerPub[:], peer.Con.RemotePub.SerializeCompressed())
+		if peerPub == pub {
+			kick = append(kick, peer)
+		}
+	}
+	for _, peer := range kick {
+		log.Infof("disconnecting banned peer %d\n", peer.Idx)
+		peer.Con.Close()
+	}
+	return nil
+}
+
+// how long a peer is banned for breaking the wire protocol
+const framingBanTime = time.Hour
+
+// framingPenalty bans a peer that sent garbage framing for a while, so they
+// can't just keep reconnecting and doing it.  Peers with open channels are
+// spared since we need to talk to them eventually.
+func (nd *LitNode) framingPenalty(peer *RemotePeer) {
+	if peer.hasOpenChannel() || peer.Con.RemotePub == nil {
+		return
+	}
+	var pub [33]byte
+	copy(pub[:], peer.Con.RemotePub.SerializeCompressed())
+	log.Infof("banning peer %d for %s for framing violation\n",
+		peer.Idx, framingBanTime)
+	err := nd.BanPeer(pub, framingBanTime)
+	if err != nil {
+		log.Errorf("framingPenalty: %s\n", err.Error())
+	}
+}
+
+// UnbanPeer lifts a ban.
+func (nd *LitNode) UnbanPeer(pub [33]byte) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bans := btx.Bucket(BKTBans)
+		if bans == nil {
+			return fmt.Errorf("no bans bucket")
+		}
+		if bans.Get(pub[:]) == nil {
+			return fmt.Errorf("%x isn't banned", pub)
+		}
+		return bans.Delete(pub[:])
+	})
+}
+
+// PeerBanned says whether a pubkey is banned right now.  Expired bans are
+// ignored (and get cleaned up the next time bans are listed).
+func (nd *LitNode) PeerBanned(pub [33]byte) bool {
+	var banned bool
+	nd.LitDB.View(func(btx kvdb.Tx) error {
+		bans := btx.Bucket(BKTBans)
+		if bans == nil {
+			return nil
+		}
+		v := bans.Get(pub[:])
+		if v == nil {
+			return nil
+		}
+		until := lnutil.BtI64(v)
+		banned = until == 0 || until > time.Now().Unix()
+		return nil
+	})
+	return banned
+}
+
+// GetBans lists the current bans, and deletes expired ones.
+func (nd *LitNode) GetBans() ([]BanInfo, error) {
+	var bans []BanInfo
+	now := time.Now().Unix()
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTBans)
+		if bkt == nil {
+			return fmt.Errorf("no bans bucket")
+		}
+		var expired [][]byte
+		err := bkt.ForEach(func(k, v []byte) error {
+			var b BanInfo
+			copy(b.Pubkey[:], k)
+			b.Until = lnutil.BtI64(v)
+			if b.Until != 0 && b.Until <= now {
+				expired = append(expired, k)
+				return nil
+			}
+			bans = append(bans, b)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// can't delete while iterating
+		for _, k := range expired {
+			err = bkt.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return bans, err
+}
+
+// SetWhitelist puts the listener in whitelist-only mode: only the given
+// peers can connect in.  Entries are hex pubkeys or ln addresses.  An empty
+// list turns whitelist mode off.  Outgoing connections aren't affected.
+func (nd *LitNode) SetWhitelist(entries []string) error {
+	if len(entries) == 0 {
+		nd.wlMtx.Lock()
+		nd.Whitelist = nil
+		nd.wlMtx.Unlock()
+		return nil
+	}
+
+	wl := make(map[string]bool)
+	for _, e := range entries {
+		if lnutil.LitAdrOK(e) {
+			wl[e] = true
+			continue
+		}
+		pubBytes, err := hex.DecodeString(e)
+		if err != nil || len(pubBytes) != 33 {
+			return fmt.Errorf("whitelist entry %s isn't an ln address or pubkey", e)
+		}
+		var pub [33]byte
+		copy(pub[:], pubBytes)
+		wl[lnutil.LitAdrFromPubkey(pub)] = true
+	}
+
+	nd.wlMtx.Lock()
+	nd.Whitelist = wl
+	nd.wlMtx.Unlock()
+	return nil
+}
+
+// inboundAllowed says whether a peer may connect to us; always true unless
+// we're in whitelist mode.
+func (nd *LitNode) inboundAllowed(pub [33]byte) bool {
+	nd.wlMtx.Lock()
+	defer nd.wlMtx.Unlock()
+	if nd.Whitelist == nil {
+		return true
+	}
+	return nd.Whitelist[lnutil.LitAdrFromPubkey(pub)]
+}
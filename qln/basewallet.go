@@ -2,14 +2,16 @@ package qln
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/chainhook"
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
-	"github.com/mit-dci/lit/uspv"
 )
 
 // The UWallet interface are the functions needed to work with the LnNode
@@ -26,9 +28,22 @@ type UWallet interface {
 	// Needed for channel break / cooperative close.  Maybe grabs.
 
 	// export the chainhook that the UWallet uses, for pushTx and fullblock
-	ExportHook() uspv.ChainHook
+	ExportHook() chainhook.ChainHook
+	// BroadcastHook is the same chainhook, but txs pushed through it are
+	// sent out again till they confirm, like ones the wallet sends.
+	BroadcastHook() chainhook.ChainHook
+	// Broadcasts gives the txs being rebroadcast, and recently settled ones.
+	Broadcasts() ([]lnutil.BroadcastTx, error)
+	// WalletDB is the db the wallet keeps everything in.
+	WalletDB() kvdb.DB
 
 	PushTx(tx *wire.MsgTx) error
+	// PushImportant sends a tx out every way it can: the chainhook, the
+	// fee rpc full node, and push-tx services.  For justice and force-close
+	// txs, which one node shouldn't be able to hold up.
+	PushImportant(tx *wire.MsgTx) error
+	// AddPushService has important txs posted to a push-tx url too.
+	AddPushService(url string, client *http.Client)
 
 	// ExportUtxo gives a utxo to the underlying wallet; that wallet saves it
 	// and can spend it later.  Doesn't return errors; error will exist only in
@@ -43,7 +58,10 @@ type UWallet interface {
 	// Retruns the txid, and then the txout indexes of the specified txos.
 	// The outpoints returned will all have the same hash (txid)
 	// So if you (as usual) just give one txo, you basically get back an outpoint.
-	MaybeSend(txos []*wire.TxOut, onlyWit bool) ([]*wire.OutPoint, error)
+	// coinSelect is the coin selection strategy; empty for the wallet's.
+	// use, if not empty, are the inputs to spend; avoid are ones not to.
+	MaybeSend(txos []*wire.TxOut, onlyWit bool, coinSelect string,
+		use, avoid []wire.OutPoint) ([]*wire.OutPoint, error)
 
 	// ReallySend really sends the transaction specified previously in MaybeSend.
 	// Underlying wallet does all needed signing.
@@ -63,9 +81,22 @@ type UWallet interface {
 	// Dump all the addresses the sub wallet is watching
 	AdrDump() ([][20]byte, error)
 
+	// AdrUsed says if an address from the wallet has been paid; those
+	// shouldn't be given out again.
+	AdrUsed(adr160 [20]byte) bool
+
+	// Return the taproot output key for the same pubkey as an address
+	TaprootKeyForAdr(adr160 [20]byte) ([32]byte, error)
+
 	// Return current height the wallet is synced to
 	CurrentHeight() int32
 
+	// Rescan goes over the chain again from a height, to find txs for keys
+	// the wallet didn't have the first time.  RescanStatus gives the start,
+	// progress and end heights of a rescan going on; all 0 if there isn't one.
+	Rescan(fromHeight int32) error
+	RescanStatus() (from, at, to int32)
+
 	// This is redundand... just use UtxoDump and figure it out yourself.
 	// Feels like helper functions shouldn't be in the interface.
 	// how much utxo the wallet has -- only confirmed segwit outputs
@@ -76,10 +107,16 @@ type UWallet interface {
 
 	// WatchThis tells the basewallet to watch an outpoint
 	WatchThis(wire.OutPoint) error
+	// WatchScript watches an outpoint with its script, if known, and says
+	// if it wasn't watched already.  Unwatch stops watching one of those.
+	WatchScript(op wire.OutPoint, pkScript []byte) (bool, error)
+	Unwatch(wire.OutPoint) error
 
 	// LetMeKnow opens the chan where OutPointEvent flows from the underlying
 	// wallet up to the LN module.
 	LetMeKnow() chan lnutil.OutPointEvent
+	// LetMeKnowHeight opens the chan where new blocks flow up.
+	LetMeKnowHeight() chan lnutil.HeightEvent
 
 	// Ask for network parameters
 	Params() *coinparam.Params
@@ -87,15 +124,111 @@ type UWallet interface {
 	// Get current fee rate.
 	Fee() int64
 
-	// Set fee rate
+	// Set fee rate; 0 means estimate it
 	SetFee(int64) int64
 
+	// FeeTarget gives the fee rate to confirm within some number of blocks.
+	FeeTarget(uint32) int64
+
+	// SetFeeRPC has the wallet estimate fees with a full node's
+	// estimatesmartfee, at a json-rpc url.  Empty stops.
+	SetFeeRPC(url string) error
+
+	// Get and set the coin selection strategy
+	CoinSelect() string
+	SetCoinSelect(string) error
+
+	// BumpFee replaces an unconfirmed tx we sent with one paying a higher
+	// fee rate, and returns the new txid.
+	BumpFee(chainhash.Hash, int64) (*chainhash.Hash, error)
+
+	// CpfpSweep spends an unconfirmed output of ours with a fee high enough
+	// to pull its parent along, and returns the child's txid.
+	CpfpSweep(wire.OutPoint, int64) (*chainhash.Hash, error)
+
+	// SweepUtxo spends one utxo to a new address of the wallet's, fee rate
+	// (0 for the wallet's) taken out of the amount.
+	SweepUtxo(wire.OutPoint, int64) (*chainhash.Hash, error)
+
+	// GetSavedTx gives a tx the wallet kept: one of its own, or one with an
+	// outpoint it was told to watch.
+	GetSavedTx(*chainhash.Hash) (*wire.MsgTx, error)
+
+	// SweepAll sends every confirmed utxo to a pkscript in one tx with no
+	// change, fee rate (0 for the wallet's) taken out of the amount.
+	SweepAll([]byte, int64) (*chainhash.Hash, error)
+
+	// LockUtxo keeps a utxo from being spent until UnlockUtxo.  Locks are
+	// saved, so they last through restarts.
+	LockUtxo(op wire.OutPoint, reason string) error
+	UnlockUtxo(op wire.OutPoint) error
+
+	// ListLocks gives all the locked utxos, including ones frozen by
+	// MaybeSend.
+	ListLocks() ([]lnutil.UtxoLock, error)
+
+	// ReserveUtxos picks utxos like PickUtxos and locks them at the same
+	// time, so nothing else can spend them before the caller does.
+	ReserveUtxos(amtWanted, outputByteSize, feePerByte int64,
+		ow bool, coinSelect, reason string) (portxo.TxoSliceByBip69, int64, error)
+
+	// ImportXpub watches an xpub the wallet has no keys for, as an account
+	// kept apart from its own utxos.  The fingerprint and path are where the
+	// xpub sits under the signing wallet's master key.  Returns the account.
+	ImportXpub(xpub string, fingerprint [4]byte, path []uint32) (uint32, error)
+	WatchAccts() ([]lnutil.WatchAcct, error)
+	// RescanWatchAcct is a Rescan for just a watch account's addresses.
+	RescanWatchAcct(acct uint32, fromHeight int32) error
+
+	// WatchUtxos gives a watch account's utxos; 0 for every account's.
+	WatchUtxos(acct uint32) ([]*portxo.PorTxo, error)
+
+	// BuildPsbt makes an unsigned tx spending from a watch account, with
+	// change back to it, for the wallet with the keys to sign.
+	BuildPsbt(acct uint32, txos []*wire.TxOut, feeRate int64) (*lnutil.Psbt, error)
+
+	// SetExtSigner sets a command that signs psbts with keys kept off this
+	// host, like on a hardware wallet.  SpendWatch uses it to send from a
+	// watch account in one go.  PushPsbt sends a psbt signed some other way.
+	SetExtSigner(command string) error
+	SpendWatch(acct uint32, txos []*wire.TxOut, feeRate int64) (*chainhash.Hash, error)
+	PushPsbt(p *lnutil.Psbt) (*chainhash.Hash, error)
+
+	// Consolidate spends the wallet's small utxos, under below, to one of
+	// its own addresses, if its fee rate is at most maxFeeRate (0 for any).
+	// SetAutoConsolidate has it do that as blocks come in; below of 0 is off.
+	Consolidate(below, maxFeeRate int64) (*chainhash.Hash, error)
+	SetAutoConsolidate(below, maxFeeRate int64) error
+	AutoConsolidate() (below, maxFeeRate int64)
+
+	// LabelTx puts a label on a wallet tx, or one of its outputs; index -1
+	// for the whole tx.  Empty label takes it off.  TxoLabel gives an
+	// output's label, or its tx's.
+	LabelTx(txid chainhash.Hash, index int32, label string) error
+	ListTxLabels() ([]lnutil.TxLabel, error)
+	TxoLabel(op wire.OutPoint) string
+
+	// NewAccount makes a named account with its own branch of keys, kept
+	// apart from the default account 0.  Accounts gives the names; the
+	// index is the account number.
+	NewAccount(name string) (uint32, error)
+	Accounts() ([]string, error)
+	NewAcctAdr160(acct uint32) ([20]byte, error)
+	AcctAdrDump(acct uint32) ([][20]byte, error)
+
+	// MaybeSendFrom is MaybeSend spending only an account's utxos.  Change
+	// goes where change says; nil for a new witness address in acct.  The
+	// fee rate is sat per byte, 0 for the wallet's.
+	MaybeSendFrom(acct uint32, txos []*wire.TxOut, onlyWit bool,
+		coinSelect string, use, avoid []wire.OutPoint,
+		change *lnutil.ChangeSpec, feeRate int64) ([]*wire.OutPoint, error)
+
 	// ===== TESTING / SPAMMING ONLY, these funcs will not be in the real interface
 	// Sweep sends lots of txs (uint32 of them) to the specified address.
 	Sweep([]byte, uint32) ([]*chainhash.Hash, error)
 
-	PickUtxos(amtWanted, outputByteSize,
-		feePerByte int64, ow bool) (portxo.TxoSliceByBip69, int64, error)
+	PickUtxos(amtWanted, outputByteSize, feePerByte int64, ow bool,
+		coinSelect string, use, avoid []wire.OutPoint) (portxo.TxoSliceByBip69, int64, error)
 
 	SignMyInputs(tx *wire.MsgTx) error
 
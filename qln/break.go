@@ -2,7 +2,7 @@ package qln
 
 import (
 	"fmt"
-	"log"
+	"time"
 )
 
 // ------------------------- break
@@ -21,17 +21,17 @@ func (nd *LitNode) BreakChannel(q *Qchan) error {
 		return fmt.Errorf("Can't break (%d,%d), already closed\n", q.Peer(), q.Idx())
 	}
 
-	log.Printf("breaking (%d,%d)\n", q.Peer(), q.Idx())
+	log.Debugf("breaking (%d,%d)\n", q.Peer(), q.Idx())
 	z, err := q.ElkSnd.AtIndex(0)
 	if err != nil {
 		return err
 	}
-	log.Printf("elk send 0: %s\n", z.String())
+	log.Tracef("elk send 0: %s\n", z.String())
 	z, err = q.ElkRcv.AtIndex(0)
 	if err != nil {
 		return err
 	}
-	log.Printf("elk recv 0: %s\n", z.String())
+	log.Tracef("elk recv 0: %s\n", z.String())
 
 	// set delta to 0... needed for break
 	q.State.Delta = 0
@@ -49,6 +49,26 @@ func (nd *LitNode) BreakChannel(q *Qchan) error {
 		return err
 	}
 
-	// broadcast break tx directly
-	return nd.SubWallet[q.Coin()].PushTx(tx)
+	// broadcast break tx directly, every way we can
+	return nd.SubWallet[q.Coin()].PushImportant(tx)
+}
+
+// pushTimeout is how long a push-tx service gets to take a tx; over tor,
+// that can be a while.
+const pushTimeout = 30 * time.Second
+
+// AddPushService has a coin's wallet post justice and force-close txs to a
+// push-tx service too, through the proxy if there is one.
+func (nd *LitNode) AddPushService(coin uint32, url string) error {
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", coin)
+	}
+	client, err := proxyClient(nd.ProxyURL, "pushtx")
+	if err != nil {
+		return err
+	}
+	client.Timeout = pushTimeout
+	wal.AddPushService(url, client)
+	return nil
 }
@@ -2,7 +2,6 @@ package qln
 
 import (
 	"fmt"
-	"log"
 
 	"github.com/mit-dci/lit/consts"
 	"github.com/mit-dci/lit/lnutil"
@@ -29,7 +28,7 @@ func GetStateIdxFromTx(tx *wire.MsgTx, x uint64) uint64 {
 	}
 	// check that indicating high bytes are correct.  If not, return 0
 	if tx.TxIn[0].Sequence>>24 != 0xff || tx.LockTime>>24 != 0x21 {
-		//		log.Printf("sequence byte %x, locktime byte %x\n",
+		//		log.Debugf("sequence byte %x, locktime byte %x\n",
 		//			tx.TxIn[0].Sequence>>24, tx.LockTime>>24 != 0x21)
 		return 0
 	}
@@ -74,6 +73,10 @@ func (q *Qchan) SimpleCloseTx() (*wire.MsgTx, error) {
 	if q == nil || q.State == nil {
 		return nil, fmt.Errorf("SimpleCloseTx: nil chan / state")
 	}
+	// the close tx only has our 2 outputs
+	if q.State.HasHTLC || q.State.HTLCOp != 0 {
+		return nil, fmt.Errorf("SimpleCloseTx: channel has an HTLC")
+	}
 
 	fee := q.State.Fee // symmetric fee
 
@@ -121,10 +124,11 @@ func (q *Qchan) BuildStateTx(mine bool) (*wire.MsgTx, error) {
 	var fancyAmt, pkhAmt, theirAmt int64 // output amounts
 	var revPub, timePub [33]byte         // pubkeys
 	var pkhPub [33]byte                  // the simple output's pub key hash
+	var htlcScript []byte                // the HTLC output's script, if any
 
 	fee := s.Fee // fixed fee for now
 
-	theirAmt = q.Value - s.MyAmt
+	theirAmt = q.TheirAmt()
 
 	// the PKH clear refund also has elkrem points added to mask the PKH.
 	// this changes the txouts at each state to blind sorcerer better.
@@ -141,6 +145,11 @@ func (q *Qchan) BuildStateTx(mine bool) (*wire.MsgTx, error) {
 
 		pkhPub = q.TheirRefundPub
 
+		if s.HasHTLC {
+			htlcScript = lnutil.HTLCScript(revPub, timePub, q.TheirRefundPub,
+				s.HTLC.RHash, s.HTLC.Locktime, q.Delay, !s.HTLC.Incoming)
+		}
+
 		// nonzero amts means build the output
 		if theirAmt > 0 {
 			pkhAmt = theirAmt - fee
@@ -150,13 +159,18 @@ func (q *Qchan) BuildStateTx(mine bool) (*wire.MsgTx, error) {
 		}
 	} else { // build THEIR tx (to sign)
 		// Their tx that they store.  I get funds PKH.  SH is theirs eventually.
-		log.Printf("using elkpoint %x\n", s.ElkPoint)
+		log.Tracef("using elkpoint %x\n", s.ElkPoint)
 		// SH pubkeys are our base points plus the received elk point
 		revPub = lnutil.CombinePubs(q.MyHAKDBase, s.ElkPoint)
 		timePub = lnutil.AddPubsEZ(q.TheirHAKDBase, s.ElkPoint)
 		// PKH output
 		pkhPub = q.MyRefundPub
 
+		if s.HasHTLC {
+			htlcScript = lnutil.HTLCScript(revPub, timePub, q.MyRefundPub,
+				s.HTLC.RHash, s.HTLC.Locktime, q.Delay, s.HTLC.Incoming)
+		}
+
 		// nonzero amts means build the output
 		if theirAmt > 0 {
 			fancyAmt = theirAmt - fee
@@ -179,19 +193,19 @@ func (q *Qchan) BuildStateTx(mine bool) (*wire.MsgTx, error) {
 	fancyScript := lnutil.CommitScript(revPub, timePub, q.Delay)
 	pkhScript := lnutil.DirectWPKHScript(pkhPub) // p2wpkh-ify
 
-	log.Printf("> made SH script, state %d\n", s.StateIdx)
-	log.Printf("\t revPub %x timeout pub %x \n", revPub, timePub)
-	log.Printf("\t script %x ", fancyScript)
+	log.Debugf("> made SH script, state %d\n", s.StateIdx)
+	log.Tracef("\t revPub %x timeout pub %x \n", revPub, timePub)
+	log.Tracef("\t script %x ", fancyScript)
 
 	fancyScript = lnutil.P2WSHify(fancyScript) // p2wsh-ify
 
-	log.Printf("\t scripthash %x\n", fancyScript)
+	log.Tracef("\t scripthash %x\n", fancyScript)
 
 	// create txouts by assigning amounts
 	outFancy := wire.NewTxOut(fancyAmt, fancyScript)
 	outPKH := wire.NewTxOut(pkhAmt, pkhScript)
 
-	log.Printf("\tcombined refund %x, pkh %x\n", pkhPub, outPKH.PkScript)
+	log.Tracef("\tcombined refund %x, pkh %x\n", pkhPub, outPKH.PkScript)
 
 	// make a new tx
 	tx := wire.NewMsgTx()
@@ -202,6 +216,10 @@ func (q *Qchan) BuildStateTx(mine bool) (*wire.MsgTx, error) {
 	if pkhAmt != 0 {
 		tx.AddTxOut(outPKH)
 	}
+	// the HTLC doesn't pay any of the fee
+	if htlcScript != nil {
+		tx.AddTxOut(wire.NewTxOut(s.HTLC.Amt, lnutil.P2WSHify(htlcScript)))
+	}
 
 	if len(tx.TxOut) < 1 {
 		return nil, fmt.Errorf("No outputs, all below minOutput")
@@ -0,0 +1,492 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Chat history keeps the chat messages sent to and got from each peer, so
+they're still there after they've scrolled off the shell.  They're in
+BKTChats, a bucket per peer index, keyed by a sequence number that's the
+message's ID.  The newest maxChatHistory a peer are kept.
+
+A message we send is "queued" until its peer is connected and we've had
+their init, then "sending" till their writer puts it on the connection.
+Peers with FeatureChatAckOptional get it with our ID for it, and ack that,
+so it's "unacked" and then "delivered"; if they go away before the ack,
+it's queued again and resent when they're back.  Others get plain chat,
+and it's "sent": it left here, but there's no telling if it got there.
+Chat that waits more than ChatExpiry from when it was written is given up
+on ("failed").  Queued chat goes in order, and before anything newer.
+
+A peer can get a message twice, if its ack didn't make it back before a
+disconnect.  IDs go up, so we keep the highest ID and time each peer's
+sent us, in BKTChtSeen, and ack but drop one that's not past those.  The
+time is there so a peer that restored an old db, and counts from lower
+IDs again, isn't ignored.  Received chat has the time the sender wrote it,
+so both sides show the same.
+*/
+
+const (
+	// maxChatHistory is how many chat messages are kept for each peer.
+	maxChatHistory = 10000
+	// DefaultChatExpiry is how long chat waits for a peer to connect.
+	DefaultChatExpiry = 7 * 24 * time.Hour
+)
+
+// chat message statuses
+const (
+	ChatSending = iota
+	ChatSent
+	ChatFailed
+	ChatReceived
+	ChatQueued
+	ChatUnacked
+	ChatDelivered
+)
+
+var chatStatusNames = []string{
+	"sending", "sent", "failed", "received", "queued", "unacked", "delivered"}
+
+// ChatEntry is a chat message sent to or got from a peer.
+type ChatEntry struct {
+	ID       uint64
+	Peer     uint32
+	Time     int64 // unix
+	Outgoing bool
+	Status   uint8
+	Text     string
+}
+
+// StatusString gives the message's status as a word.
+func (c *ChatEntry) StatusString() string {
+	if int(c.Status) < len(chatStatusNames) {
+		return chatStatusNames[c.Status]
+	}
+	return fmt.Sprintf("unknown status %d", c.Status)
+}
+
+/* chat serialization, the value in a peer's bucket in BKTChats; the key is
+the ID:
+8	time
+1	outgoing
+1	status
+	text (the rest)
+*/
+
+// Bytes serializes a ChatEntry, without its ID or peer.
+func (c *ChatEntry) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(lnutil.I64tB(c.Time))
+	if c.Outgoing {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.WriteByte(c.Status)
+	buf.WriteString(c.Text)
+	return buf.Bytes()
+}
+
+// ChatEntryFromBytes deserializes a ChatEntry from its peer, key and value.
+func ChatEntryFromBytes(peer uint32, k, v []byte) (*ChatEntry, error) {
+	if len(k) != 8 || len(v) < 10 {
+		return nil, fmt.Errorf("chat %x: %d bytes, expect 10 or more", k, len(v))
+	}
+	c := new(ChatEntry)
+	c.ID = lnutil.BtU64(k)
+	c.Peer = peer
+	c.Time = lnutil.BtI64(v[:8])
+	c.Outgoing = v[8] != 0
+	c.Status = v[9]
+	c.Text = string(v[10:])
+	return c, nil
+}
+
+// chatOutbox has the IDs of the chat messages on their way to each peer,
+// in the order they'll go.
+type chatOutbox struct {
+	mtx sync.Mutex
+	ids map[uint32][]uint64
+}
+
+// saveChat saves a chat message, giving it its ID, and drops the peer's
+// oldest once there are too many.
+func (nd *LitNode) saveChat(c *ChatEntry) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		cb := btx.Bucket(BKTChats)
+		if cb == nil {
+			return fmt.Errorf("no chats bucket")
+		}
+		peerBkt, err := cb.CreateBucketIfNotExists(lnutil.U32tB(c.Peer))
+		if err != nil {
+			return err
+		}
+		c.ID, err = peerBkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		if c.ID > maxChatHistory {
+			err = peerBkt.Delete(lnutil.U64tB(c.ID - maxChatHistory))
+			if err != nil {
+				return err
+			}
+		}
+		return peerBkt.Put(lnutil.U64tB(c.ID), c.Bytes())
+	})
+}
+
+// setChatStatus changes a saved chat message's status.
+func (nd *LitNode) setChatStatus(peer uint32, id uint64, status uint8) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		cb := btx.Bucket(BKTChats)
+		if cb == nil {
+			return fmt.Errorf("no chats bucket")
+		}
+		peerBkt := cb.Bucket(lnutil.U32tB(peer))
+		if peerBkt == nil {
+			return fmt.Errorf("no chats with peer %d", peer)
+		}
+		k := lnutil.U64tB(id)
+		v := peerBkt.Get(k)
+		if v == nil {
+			return fmt.Errorf("no chat %d with peer %d", id, peer)
+		}
+		c, err := ChatEntryFromBytes(peer, k, v)
+		if err != nil {
+			return err
+		}
+		c.Status = status
+		return peerBkt.Put(k, c.Bytes())
+	})
+}
+
+// recordChat saves a chat message as it's sent, queued or got.  If that
+// fails, it's logged and the ID is 0.
+func (nd *LitNode) recordChat(peer uint32, text string, status uint8) uint64 {
+	c := &ChatEntry{Peer: peer, Time: time.Now().Unix(),
+		Outgoing: status != ChatReceived, Status: status, Text: text}
+	err := nd.saveChat(c)
+	if err != nil {
+		log.Errorf("saving chat with peer %d: %s\n", peer, err.Error())
+		return 0
+	}
+	return c.ID
+}
+
+// chatQueued notes a chat message on its way to a peer.
+func (nd *LitNode) chatQueued(peer uint32, id uint64) {
+	nd.chatOut.mtx.Lock()
+	defer nd.chatOut.mtx.Unlock()
+	if nd.chatOut.ids == nil {
+		nd.chatOut.ids = make(map[uint32][]uint64)
+	}
+	nd.chatOut.ids[peer] = append(nd.chatOut.ids[peer], id)
+}
+
+// chatDone sets the status of the next chat message to a peer, once it's
+// gone out or been dropped.  Chat goes to a peer in order, so it's the
+// oldest one on its way.
+func (nd *LitNode) chatDone(peer uint32, status uint8) {
+	nd.chatOut.mtx.Lock()
+	ids := nd.chatOut.ids[peer]
+	if len(ids) == 0 {
+		nd.chatOut.mtx.Unlock()
+		return
+	}
+	id := ids[0]
+	if len(ids) == 1 {
+		delete(nd.chatOut.ids, peer)
+	} else {
+		nd.chatOut.ids[peer] = ids[1:]
+	}
+	nd.chatOut.mtx.Unlock()
+
+	if id == 0 {
+		return // never saved
+	}
+	err := nd.setChatStatus(peer, id, status)
+	if err != nil {
+		log.Errorf("chat %d with peer %d: %s\n", id, peer, err.Error())
+	}
+}
+
+// requeueChats queues chat messages that were on their way, or waiting on
+// acks, when lit last stopped, to go when their peers connect.
+func (nd *LitNode) requeueChats() error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		cb := btx.Bucket(BKTChats)
+		if cb == nil {
+			return fmt.Errorf("no chats bucket")
+		}
+		return cb.ForEach(func(pk, _ []byte) error {
+			peerBkt := cb.Bucket(pk)
+			if peerBkt == nil || len(pk) != 4 {
+				return nil
+			}
+			return requeuePeerChats(peerBkt, lnutil.BtU32(pk))
+		})
+	})
+}
+
+// requeueUnacked queues chat a peer hadn't acked when they went away, to
+// send again when they're back.  If they already are, it goes now.
+func (nd *LitNode) requeueUnacked(peer uint32) {
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		cb := btx.Bucket(BKTChats)
+		if cb == nil {
+			return fmt.Errorf("no chats bucket")
+		}
+		peerBkt := cb.Bucket(lnutil.U32tB(peer))
+		if peerBkt == nil {
+			return nil // haven't chatted
+		}
+		return requeuePeerChats(peerBkt, peer)
+	})
+	if err != nil {
+		log.Errorf("requeueUnacked: %s\n", err.Error())
+		return
+	}
+	if nd.chatReady(peer) {
+		nd.sendQueuedChats(peer)
+	}
+}
+
+// requeuePeerChats queues a peer's chat that's sending or unacked.
+func requeuePeerChats(peerBkt kvdb.Bucket, peer uint32) error {
+	var requeue []*ChatEntry
+	err := peerBkt.ForEach(func(k, v []byte) error {
+		c, err := ChatEntryFromBytes(peer, k, v)
+		if err != nil {
+			return err
+		}
+		if c.Status == ChatSending || c.Status == ChatUnacked {
+			requeue = append(requeue, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// not while going through the bucket
+	for _, c := range requeue {
+		c.Status = ChatQueued
+		err = peerBkt.Put(lnutil.U64tB(c.ID), c.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chatReady says whether a peer's connected and we've had their init, so
+// we know what kind of chat they take.
+func (nd *LitNode) chatReady(peerIdx uint32) bool {
+	peer, ok := nd.GetPeer(peerIdx)
+	if !ok {
+		return false
+	}
+	peer.mtx.Lock()
+	defer peer.mtx.Unlock()
+	return peer.Version != 0
+}
+
+// sendQueuedChats sends a peer that's just connected the chat that's
+// waiting for them, and gives up on what's been waiting too long.
+func (nd *LitNode) sendQueuedChats(peer uint32) {
+	nd.chatSendMtx.Lock()
+	defer nd.chatSendMtx.Unlock()
+	p, ok := nd.GetPeer(peer)
+	if !ok {
+		return // gone again; it'll keep
+	}
+	acks := p.HasFeature(lnutil.FeatureChatAckOptional)
+
+	var send []*ChatEntry
+	var expired int
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		cb := btx.Bucket(BKTChats)
+		if cb == nil {
+			return fmt.Errorf("no chats bucket")
+		}
+		peerBkt := cb.Bucket(lnutil.U32tB(peer))
+		if peerBkt == nil {
+			return nil // haven't chatted
+		}
+		var queued []*ChatEntry
+		err := peerBkt.ForEach(func(k, v []byte) error {
+			c, err := ChatEntryFromBytes(peer, k, v)
+			if err != nil {
+				return err
+			}
+			if c.Status == ChatQueued {
+				queued = append(queued, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// not while going through the bucket
+		for _, c := range queued {
+			if time.Since(time.Unix(c.Time, 0)) > nd.ChatExpiry {
+				c.Status = ChatFailed
+				expired++
+			} else {
+				c.Status = ChatSending
+				send = append(send, c)
+			}
+			err = peerBkt.Put(lnutil.U64tB(c.ID), c.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("sendQueuedChats: %s\n", err.Error())
+		return
+	}
+	if expired != 0 {
+		log.Infof("gave up on %d chat messages to peer %d\n", expired, peer)
+	}
+	if len(send) == 0 {
+		return
+	}
+	log.Debugf("sending %d queued chat messages to peer %d\n", len(send), peer)
+	for _, c := range send {
+		nd.chatQueued(peer, c.ID)
+		if acks {
+			nd.OmniOut <- lnutil.NewChatIDMsg(peer, c.ID, c.Time, c.Text)
+		} else {
+			nd.OmniOut <- lnutil.NewChatMsg(peer, c.Text)
+		}
+	}
+}
+
+// ChatIDHandler saves and shows a chat message from a peer, unless it's
+// one we've had already, and acks it either way.
+func (nd *LitNode) ChatIDHandler(msg lnutil.ChatIDMsg) {
+	peer := msg.Peer()
+	var dup bool
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		sb := btx.Bucket(BKTChtSeen)
+		if sb == nil {
+			return fmt.Errorf("no chat seen bucket")
+		}
+		k := lnutil.U32tB(peer)
+		if v := sb.Get(k); len(v) == 16 {
+			dup = msg.ID <= lnutil.BtU64(v[:8]) && msg.Time <= lnutil.BtI64(v[8:])
+		}
+		if dup {
+			return nil
+		}
+		seen := append(lnutil.U64tB(msg.ID), lnutil.I64tB(msg.Time)...)
+		return sb.Put(k, seen)
+	})
+	if err != nil {
+		// better to show it twice than not at all
+		log.Errorf("chat from peer %d: %s\n", peer, err.Error())
+	}
+	nd.OmniOut <- lnutil.NewChatAckMsg(peer, msg.ID)
+	if dup {
+		log.Debugf("chat %d from peer %d again; dropped\n", msg.ID, peer)
+		return
+	}
+
+	c := &ChatEntry{Peer: peer, Time: msg.Time, Status: ChatReceived, Text: msg.Text}
+	err = nd.saveChat(c)
+	if err != nil {
+		log.Errorf("saving chat with peer %d: %s\n", peer, err.Error())
+	}
+	nd.UserMessageBox <- fmt.Sprintf(
+		"\nmsg from %s: %s", lnutil.White(peer), lnutil.Green(msg.Text))
+}
+
+// ChatAckHandler marks chat the peer acked as delivered.
+func (nd *LitNode) ChatAckHandler(msg lnutil.ChatAckMsg) {
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		cb := btx.Bucket(BKTChats)
+		if cb == nil {
+			return fmt.Errorf("no chats bucket")
+		}
+		peerBkt := cb.Bucket(lnutil.U32tB(msg.Peer()))
+		if peerBkt == nil {
+			return fmt.Errorf("no chats with peer %d", msg.Peer())
+		}
+		k := lnutil.U64tB(msg.ID)
+		v := peerBkt.Get(k)
+		if v == nil {
+			return fmt.Errorf("no chat %d with peer %d", msg.ID, msg.Peer())
+		}
+		c, err := ChatEntryFromBytes(msg.Peer(), k, v)
+		if err != nil {
+			return err
+		}
+		// a late ack, for one that's queued again or given up on, still
+		// counts
+		if !c.Outgoing || c.Status == ChatDelivered {
+			return nil
+		}
+		c.Status = ChatDelivered
+		return peerBkt.Put(k, c.Bytes())
+	})
+	if err != nil {
+		log.Warnf("chat ack from peer %d: %s\n", msg.Peer(), err.Error())
+	}
+}
+
+// ChatHistory gives up to limit of the chat messages with a peer from
+// before the one with ID before, oldest first; before 0 for the newest.
+func (nd *LitNode) ChatHistory(peer uint32, limit int, before uint64) (
+	[]ChatEntry, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit %d; expect 1 or more", limit)
+	}
+	var chats []ChatEntry
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		cb := btx.Bucket(BKTChats)
+		if cb == nil {
+			return fmt.Errorf("no chats bucket")
+		}
+		peerBkt := cb.Bucket(lnutil.U32tB(peer))
+		if peerBkt == nil {
+			return nil // haven't chatted
+		}
+		cur := peerBkt.Cursor()
+		var k, v []byte
+		if before == 0 {
+			k, v = cur.Last()
+		} else {
+			// Seek lands on before or past it; back up to the one before
+			k, _ = cur.Seek(lnutil.U64tB(before))
+			if k == nil {
+				k, v = cur.Last()
+			} else {
+				k, v = cur.Prev()
+			}
+		}
+		for ; k != nil && len(chats) < limit; k, v = cur.Prev() {
+			c, err := ChatEntryFromBytes(peer, k, v)
+			if err != nil {
+				return err
+			}
+			chats = append(chats, *c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// they were read newest first
+	for i, j := 0, len(chats)-1; i < j; i, j = i+1, j-1 {
+		chats[i], chats[j] = chats[j], chats[i]
+	}
+	return chats, nil
+}
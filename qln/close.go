@@ -3,12 +3,12 @@ package qln
 import (
 	"bytes"
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/txscript"
 	"github.com/adiabat/btcd/wire"
 	"github.com/btcsuite/fastsha256"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/sig64"
@@ -21,16 +21,16 @@ just with no timeouts.
 Users might want a more advanced close function which allows multiple outputs.
 They can exchange txouts and sigs.  That could be "fancyClose", but this is
 just close, so only a signature is sent by the initiator, and the receiver
-doesn't reply, as the channel is closed.
+doesn't reply, as the channel is closed.  Unless it won't sign; then it
+replies with a CloseResp saying why, and the initiator takes the channel out
+of closed, to use or break.
 
 */
 
 // CoopClose requests a cooperative close of the channel
 func (nd *LitNode) CoopClose(q *Qchan) error {
 
-	nd.RemoteMtx.Lock()
-	_, ok := nd.RemoteCons[q.Peer()]
-	nd.RemoteMtx.Unlock()
+	_, ok := nd.GetPeer(q.Peer())
 	if !ok {
 		return fmt.Errorf("not connected to peer %d ", q.Peer())
 	}
@@ -82,15 +82,24 @@ func (nd *LitNode) CoopClose(q *Qchan) error {
 func (nd *LitNode) CloseReqHandler(msg lnutil.CloseReqMsg) {
 	opArr := lnutil.OutPointToBytes(msg.Outpoint)
 
+	// tell them we're not signing, so they don't wait on a close that
+	// won't come
+	decline := func(reason string) {
+		log.Errorf("CloseReqHandler %s: %s", msg.Outpoint.String(), reason)
+		nd.OmniOut <- lnutil.NewCloseRespMsg(msg.Peer(), msg.Outpoint, reason)
+	}
+
 	// get channel
 	q, err := nd.GetQchan(opArr)
-	if err != nil {
-		log.Printf("CloseReqHandler GetQchan err %s", err.Error())
+	if err != nil || q.Peer() != msg.Peer() {
+		decline("no such channel")
 		return
 	}
 
-	if nd.SubWallet[q.Coin()] == nil {
-		log.Printf("Not connected to coin type %d\n", q.Coin())
+	wal, ok := nd.SubWallet[q.Coin()]
+	if !ok {
+		decline(fmt.Sprintf("not connected to coin type %d", q.Coin()))
+		return
 	}
 
 	// verify their sig?  should do that before signing our side just to be safe
@@ -99,14 +108,14 @@ func (nd *LitNode) CloseReqHandler(msg lnutil.CloseReqMsg) {
 	// build close tx
 	tx, err := q.SimpleCloseTx()
 	if err != nil {
-		log.Printf("CloseReqHandler SimpleCloseTx err %s", err.Error())
+		decline(err.Error())
 		return
 	}
 
 	// sign close
 	mySig, err := nd.SignSimpleClose(q, tx)
 	if err != nil {
-		log.Printf("CloseReqHandler SignSimpleClose err %s", err.Error())
+		decline(err.Error())
 		return
 	}
 
@@ -119,7 +128,7 @@ func (nd *LitNode) CloseReqHandler(msg lnutil.CloseReqMsg) {
 
 	pre, swap, err := lnutil.FundTxScript(q.MyPub, q.TheirPub)
 	if err != nil {
-		log.Printf("CloseReqHandler FundTxScript err %s", err.Error())
+		decline(err.Error())
 		return
 	}
 
@@ -129,27 +138,76 @@ func (nd *LitNode) CloseReqHandler(msg lnutil.CloseReqMsg) {
 	} else {
 		tx.TxIn[0].Witness = SpendMultiSigWitStack(pre, myBigSig, theirBigSig)
 	}
-	log.Printf(lnutil.TxToString(tx))
+	log.Tracef("%s\n", lnutil.TxToString(tx))
 
 	// save channel state to db as closed.
 	q.CloseData.Closed = true
 	q.CloseData.CloseTxid = tx.TxHash()
 	err = nd.SaveQchanUtxoData(q)
 	if err != nil {
-		log.Printf("CloseReqHandler SaveQchanUtxoData err %s", err.Error())
+		log.Errorf("CloseReqHandler SaveQchanUtxoData err %s", err.Error())
 		return
 	}
 
 	// broadcast
-	err = nd.SubWallet[q.Coin()].PushTx(tx)
+	err = wal.PushTx(tx)
 	if err != nil {
-		log.Printf("CloseReqHandler NewOutgoingTx err %s", err.Error())
+		log.Errorf("CloseReqHandler NewOutgoingTx err %s", err.Error())
 		return
 	}
 
 	return
 }
 
+// chanFee gives the fee each side of a new channel pays out of its output
+// in the channel's txs: a thousand bytes at the coin's fixed fee rate.  The
+// fee isn't in the ChanDesc, so both sides have to come up with the same
+// one on their own; estimates and local policy would differ.
+func chanFee(wal UWallet) int64 {
+	return wal.Params().FeePerByte * 1000
+}
+
+// CloseRespHandler takes a close our peer won't sign.  The channel was
+// saved as closed when we asked; if the close hasn't shown up, it goes back
+// to open, so it can be used, or closed again, or broken.
+func (nd *LitNode) CloseRespHandler(msg lnutil.CloseRespMsg) {
+	opArr := lnutil.OutPointToBytes(msg.Outpoint)
+	q, err := nd.GetQchan(opArr)
+	if err != nil || q.Peer() != msg.Peer() {
+		log.Warnf("CloseRespHandler: peer %d has no channel %s\n",
+			msg.Peer(), msg.Outpoint.String())
+		return
+	}
+	log.Warnf("peer %d won't sign close of channel %d: %s\n",
+		msg.Peer(), q.Idx(), msg.Reason)
+
+	if !q.CloseData.Closed || q.CloseData.CloseHeight != 0 {
+		return
+	}
+	err = nd.reopenQchan(q)
+	if err != nil {
+		log.Errorf("CloseRespHandler reopenQchan err %s", err.Error())
+	}
+}
+
+// reopenQchan takes a channel out of closed, after a close that didn't
+// happen.
+func (nd *LitNode) reopenQchan(q *Qchan) error {
+	q.CloseData = QCloseData{}
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		cbk := btx.Bucket(BKTChannel)
+		if cbk == nil {
+			return fmt.Errorf("no channels")
+		}
+		opArr := lnutil.OutPointToBytes(q.Op)
+		qcBucket := cbk.Bucket(opArr[:])
+		if qcBucket == nil {
+			return fmt.Errorf("outpoint %s not in db", q.Op.String())
+		}
+		return qcBucket.Delete(KEYqclose)
+	})
+}
+
 // GetCloseTxos takes in a tx and sets the QcloseTXO fields based on the tx.
 // It also returns the spendable (u)txos generated by the close.
 // TODO way too long.  Need to split up.
@@ -169,7 +227,8 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 	cTxos := make([]portxo.PorTxo, 1)
 	myPKHPkSript := lnutil.DirectWPKHScript(q.MyRefundPub)
 	shIdx = 999 // set high here to detect if there's no SH output
-	// Classify outputs.  Assumes only 1 SH output.  Later recognize HTLC outputs
+	// Classify outputs.  If there's an HTLC output too, the SH output is
+	// found again by its script below.
 	for i, out := range tx.TxOut {
 		if len(out.PkScript) == 34 {
 			shIdx = uint32(i)
@@ -182,7 +241,7 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 
 	// if pkh is mine, grab it.
 	if pkhIsMine {
-		log.Printf("got PKH output from channel close")
+		log.Debugf("got PKH output from channel close")
 		var pkhTxo portxo.PorTxo // create new utxo and copy into it
 
 		pkhTxo.Op.Hash = txid
@@ -209,7 +268,7 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 		comNum = GetStateIdxFromTx(tx, q.GetChanHint(true))
 	}
 	if comNum > q.State.StateIdx { // future state, uhoh.  Crash for now.
-		log.Printf("indicated state %d but we know up to %d",
+		log.Debugf("indicated state %d but we know up to %d",
 			comNum, q.State.StateIdx)
 		return cTxos, nil
 	}
@@ -227,13 +286,14 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 		revokePub := lnutil.CombinePubs(q.TheirHAKDBase, theirElkPoint)
 
 		script := lnutil.CommitScript(revokePub, timeoutPub, q.Delay)
+		shIdx = q.findSHOutput(tx, script, shIdx)
 		// script check.  redundant / just in case
 		genSH := fastsha256.Sum256(script)
 		if !bytes.Equal(genSH[:], tx.TxOut[shIdx].PkScript[2:34]) {
-			log.Printf("got different observed and generated SH scripts.\n")
-			log.Printf("in %s:%d, see %x\n", txid, shIdx, tx.TxOut[shIdx].PkScript)
-			log.Printf("generated %x \n", genSH)
-			log.Printf("revokable pub %x\ntimeout pub %x\n", revokePub, timeoutPub)
+			log.Debugf("got different observed and generated SH scripts.\n")
+			log.Tracef("in %s:%d, see %x\n", txid, shIdx, tx.TxOut[shIdx].PkScript)
+			log.Debugf("generated %x \n", genSH)
+			log.Tracef("revokable pub %x\ntimeout pub %x\n", revokePub, timeoutPub)
 		}
 
 		// create the ScriptHash, timeout portxo.
@@ -280,14 +340,15 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 		timeoutPub := lnutil.AddPubsEZ(q.TheirHAKDBase, myElkPoint)
 		revokePub := lnutil.CombinePubs(q.MyHAKDBase, myElkPoint)
 		script := lnutil.CommitScript(revokePub, timeoutPub, q.Delay)
+		shIdx = q.findSHOutput(tx, script, shIdx)
 
 		// script check
 		wshScript := lnutil.P2WSHify(script)
 		if !bytes.Equal(wshScript[:], tx.TxOut[shIdx].PkScript) {
-			log.Printf("got different observed and generated SH scripts.\n")
-			log.Printf("in %s:%d, see %x\n", txid, shIdx, tx.TxOut[shIdx].PkScript)
-			log.Printf("generated %x \n", wshScript)
-			log.Printf("revokable pub %x\ntimeout pub %x\n", revokePub, timeoutPub)
+			log.Debugf("got different observed and generated SH scripts.\n")
+			log.Tracef("in %s:%d, see %x\n", txid, shIdx, tx.TxOut[shIdx].PkScript)
+			log.Debugf("generated %x \n", wshScript)
+			log.Tracef("revokable pub %x\ntimeout pub %x\n", revokePub, timeoutPub)
 		}
 
 		// myElkHashR added to HAKD private key
@@ -316,7 +377,33 @@ func (q *Qchan) GetCloseTxos(tx *wire.MsgTx) ([]portxo.PorTxo, error) {
 		shTxo.PreSigStack = make([][]byte, 1) // timeout SH has one presig item
 		shTxo.PreSigStack[0] = []byte{0x01}   // and that item is a 1 (justice)
 		cTxos = append(cTxos, shTxo)
+
+		// and an HTLC output, if the state had one
+		cTxos = append(cTxos,
+			q.revokedHTLCTxos(tx, revokePub, timeoutPub, elk)...)
+	}
+
+	// an HTLC in the current state may be ours too
+	if comNum != 0 && comNum == q.State.StateIdx {
+		htlcTxo, err := q.htlcCloseTxo(tx, pkhIsMine)
+		if err != nil {
+			log.Errorf("GetCloseTxos HTLC: %s\n", err.Error())
+		} else if htlcTxo != nil {
+			cTxos = append(cTxos, *htlcTxo)
+		}
 	}
 
 	return cTxos, nil
 }
+
+// findSHOutput gives the index of the output paying to the script's hash,
+// or idx if there isn't one.
+func (q *Qchan) findSHOutput(tx *wire.MsgTx, script []byte, idx uint32) uint32 {
+	wsh := lnutil.P2WSHify(script)
+	for i, out := range tx.TxOut {
+		if bytes.Equal(out.PkScript, wsh) {
+			return uint32(i)
+		}
+	}
+	return idx
+}
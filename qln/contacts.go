@@ -0,0 +1,95 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/kvdb"
+)
+
+// Contact is an address book entry: a name for someone's on-chain address,
+// lit address, or both.  Either can be empty.
+type Contact struct {
+	Name    string
+	Address string // on-chain address
+	LitAdr  string // lit address, maybe with @host:port
+}
+
+/* contact serialization, the value in BKTContact:
+1	length of the on-chain address
+	on-chain address
+	the rest is the lit address
+*/
+
+// AddContact saves a contact, replacing any with the same name.  Callers
+// check the addresses; the node doesn't know what networks they're for.
+func (nd *LitNode) AddContact(c Contact) error {
+	if c.Name == "" {
+		return fmt.Errorf("contact needs a name")
+	}
+	if c.Address == "" && c.LitAdr == "" {
+		return fmt.Errorf("contact %s needs an address", c.Name)
+	}
+	if len(c.Address) > 255 {
+		return fmt.Errorf("address %s too long", c.Address)
+	}
+
+	v := append([]byte{byte(len(c.Address))}, c.Address...)
+	v = append(v, c.LitAdr...)
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTContact).Put([]byte(c.Name), v)
+	})
+}
+
+// RemoveContact takes a name out of the address book.
+func (nd *LitNode) RemoveContact(name string) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTContact)
+		if bkt.Get([]byte(name)) == nil {
+			return fmt.Errorf("no contact %s", name)
+		}
+		return bkt.Delete([]byte(name))
+	})
+}
+
+// GetContact looks a name up in the address book.
+func (nd *LitNode) GetContact(name string) (Contact, error) {
+	var c Contact
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		v := btx.Bucket(BKTContact).Get([]byte(name))
+		if v == nil {
+			return fmt.Errorf("no contact %s", name)
+		}
+		var err error
+		c, err = contactFromBytes([]byte(name), v)
+		return err
+	})
+	return c, err
+}
+
+// ListContacts gives the whole address book, by name.
+func (nd *LitNode) ListContacts() ([]Contact, error) {
+	var contacts []Contact
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTContact).ForEach(func(k, v []byte) error {
+			c, err := contactFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			contacts = append(contacts, c)
+			return nil
+		})
+	})
+	return contacts, err
+}
+
+func contactFromBytes(k, v []byte) (Contact, error) {
+	if len(v) < 1 || len(v) < 1+int(v[0]) {
+		return Contact{}, fmt.Errorf("contact %s: %d bytes, too short",
+			string(k), len(v))
+	}
+	return Contact{
+		Name:    string(k),
+		Address: string(v[1 : 1+v[0]]),
+		LitAdr:  string(v[1+v[0]:]),
+	}, nil
+}
@@ -0,0 +1,238 @@
+package qln
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Custom messages let apps built on lit talk to each other over the lndc
+connection between their nodes, with message types of their own and
+whatever data they like, instead of squeezing it into chat.  Types from
+lnutil.CustomMsgTypeMin up are the apps'; the ones below are kept for lit.
+
+A node only sends custom messages to, and takes them from, peers it's opted
+in to with AllowCustomMsgs; ones from anyone else are dropped.  Apps hear
+about incoming ones by subscribing to the types they want, then asking for
+messages, like chain event subscriptions.
+*/
+
+// MaxCustomMsgData is the most data a custom message can carry; lndc
+// messages are at most 65535 bytes, and 3 go to the message type and the
+// app's type.
+const MaxCustomMsgData = 65535 - 3
+
+// CustomMsgEvent is a custom message that came in.
+type CustomMsgEvent struct {
+	Peer uint32
+	Type uint16
+	Data []byte
+	Time int64 // unix
+}
+
+// customSub is one subscription's types, and the messages it hasn't
+// picked up yet.
+type customSub struct {
+	types map[uint16]bool // empty for all of them
+
+	msgs     []CustomMsgEvent
+	wake     chan struct{} // something in msgs
+	lastPoll time.Time
+}
+
+// customSubs are all the custom message subscriptions, by id.
+type customSubs struct {
+	mtx    sync.Mutex
+	subs   map[uint32]*customSub
+	nextID uint32
+}
+
+// AllowCustomMsgs opts in to, or out of, swapping custom messages with a
+// pubkey.
+func (nd *LitNode) AllowCustomMsgs(pub [33]byte, allow bool) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTCustoms)
+		if bkt == nil {
+			return fmt.Errorf("no custom message peers bucket")
+		}
+		if !allow {
+			return bkt.Delete(pub[:])
+		}
+		return bkt.Put(pub[:], []byte{1})
+	})
+}
+
+// CustomMsgPeers lists the pubkeys we swap custom messages with.
+func (nd *LitNode) CustomMsgPeers() ([][33]byte, error) {
+	var pubs [][33]byte
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTCustoms)
+		if bkt == nil {
+			return fmt.Errorf("no custom message peers bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var pub [33]byte
+			copy(pub[:], k)
+			pubs = append(pubs, pub)
+			return nil
+		})
+	})
+	return pubs, err
+}
+
+// customMsgsAllowed says whether we've opted in to a pubkey's custom
+// messages.
+func (nd *LitNode) customMsgsAllowed(pub [33]byte) bool {
+	var allowed bool
+	nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTCustoms)
+		if bkt == nil {
+			return nil
+		}
+		allowed = bkt.Get(pub[:]) != nil
+		return nil
+	})
+	return allowed
+}
+
+// SendCustomMsg sends an app's message to a connected peer we've opted in
+// to custom messages with.
+func (nd *LitNode) SendCustomMsg(peerIdx uint32, msgType uint16, data []byte) error {
+	if msgType < lnutil.CustomMsgTypeMin {
+		return fmt.Errorf("custom message type %d is kept for lit; use %d or more",
+			msgType, lnutil.CustomMsgTypeMin)
+	}
+	if len(data) > MaxCustomMsgData {
+		return fmt.Errorf("custom message %d bytes, max %d",
+			len(data), MaxCustomMsgData)
+	}
+	if !nd.ConnectedToPeer(peerIdx) {
+		return fmt.Errorf("not connected to peer %d", peerIdx)
+	}
+	pub, _ := nd.GetPubHostFromPeerIdx(peerIdx)
+	if !nd.customMsgsAllowed(pub) {
+		return fmt.Errorf("peer %d isn't allowed custom messages", peerIdx)
+	}
+	if !nd.peerHasFeature(peerIdx, lnutil.FeatureCustomOptional) {
+		return fmt.Errorf("peer %d doesn't take custom messages", peerIdx)
+	}
+	nd.OmniOut <- lnutil.NewCustomMsg(peerIdx, msgType, data)
+	return nil
+}
+
+// CustomMsgHandler hands a custom message to the subscriptions that want
+// its type, if it's from a peer we've opted in to.
+func (nd *LitNode) CustomMsgHandler(msg lnutil.CustomMsg, peer *RemotePeer) {
+	if peer.Con.RemotePub == nil {
+		return // already closed
+	}
+	var pub [33]byte
+	copy(pub[:], peer.Con.RemotePub.SerializeCompressed())
+	if !nd.customMsgsAllowed(pub) {
+		log.Debugf("dropping custom message %d from peer %d; not allowed\n",
+			msg.Type, msg.Peer())
+		return
+	}
+	if msg.Type < lnutil.CustomMsgTypeMin {
+		log.Debugf("dropping custom message %d from peer %d; type kept for lit\n",
+			msg.Type, msg.Peer())
+		return
+	}
+
+	ev := CustomMsgEvent{Peer: msg.Peer(), Type: msg.Type, Data: msg.Data,
+		Time: time.Now().Unix()}
+	nd.customSubs.mtx.Lock()
+	defer nd.customSubs.mtx.Unlock()
+	for id, sub := range nd.customSubs.subs {
+		// nobody's asked in a long time; probably nobody will
+		if time.Since(sub.lastPoll) > subExpiry {
+			log.Infof("custom message subscription %d expired\n", id)
+			delete(nd.customSubs.subs, id)
+			continue
+		}
+		if len(sub.types) != 0 && !sub.types[msg.Type] {
+			continue
+		}
+		sub.msgs = append(sub.msgs, ev)
+		if len(sub.msgs) > maxSubEvents {
+			sub.msgs = sub.msgs[len(sub.msgs)-maxSubEvents:]
+		}
+		select {
+		case sub.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SubscribeCustomMsgs starts a subscription to incoming custom messages of
+// types, or all of them if there are none.  Returns the subscription's id.
+func (nd *LitNode) SubscribeCustomMsgs(types []uint16) (uint32, error) {
+	sub := &customSub{
+		types:    make(map[uint16]bool),
+		wake:     make(chan struct{}, 1),
+		lastPoll: time.Now(),
+	}
+	for _, t := range types {
+		if t < lnutil.CustomMsgTypeMin {
+			return 0, fmt.Errorf("custom message type %d is kept for lit", t)
+		}
+		sub.types[t] = true
+	}
+
+	nd.customSubs.mtx.Lock()
+	defer nd.customSubs.mtx.Unlock()
+	if nd.customSubs.subs == nil {
+		nd.customSubs.subs = make(map[uint32]*customSub)
+	}
+	nd.customSubs.nextID++
+	nd.customSubs.subs[nd.customSubs.nextID] = sub
+	return nd.customSubs.nextID, nil
+}
+
+// UnsubscribeCustomMsgs ends a subscription.
+func (nd *LitNode) UnsubscribeCustomMsgs(id uint32) error {
+	nd.customSubs.mtx.Lock()
+	defer nd.customSubs.mtx.Unlock()
+	if nd.customSubs.subs[id] == nil {
+		return fmt.Errorf("no custom message subscription %d", id)
+	}
+	delete(nd.customSubs.subs, id)
+	return nil
+}
+
+// CustomMsgs gives a subscription's messages since last time, waiting up
+// to wait for some if there aren't any yet.
+func (nd *LitNode) CustomMsgs(id uint32, wait time.Duration) ([]CustomMsgEvent, error) {
+	if wait > MaxEventWait {
+		wait = MaxEventWait
+	}
+
+	nd.customSubs.mtx.Lock()
+	sub := nd.customSubs.subs[id]
+	if sub == nil {
+		nd.customSubs.mtx.Unlock()
+		return nil, fmt.Errorf("no custom message subscription %d", id)
+	}
+	sub.lastPoll = time.Now()
+	if len(sub.msgs) == 0 && wait > 0 {
+		nd.customSubs.mtx.Unlock()
+		select {
+		case <-sub.wake:
+		case <-time.After(wait):
+		}
+		nd.customSubs.mtx.Lock()
+	}
+	msgs := sub.msgs
+	sub.msgs = nil
+	// it may have been woken for these; don't wake for them again
+	select {
+	case <-sub.wake:
+	default:
+	}
+	nd.customSubs.mtx.Unlock()
+	return msgs, nil
+}
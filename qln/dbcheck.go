@@ -0,0 +1,115 @@
+package qln
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// CheckChannelDB goes through every channel in an ln.db, and says what's
+// wrong with any of them: ones that won't load, or whose saved state
+// doesn't add up.  Doesn't need the wallets, so works with lit stopped.
+func CheckChannelDB(db kvdb.DB) ([]string, error) {
+	var problems []string
+	err := db.View(func(btx kvdb.Tx) error {
+		cbk := btx.Bucket(BKTChannel)
+		if cbk == nil {
+			return fmt.Errorf("no channels")
+		}
+		return cbk.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil // non-bucket
+			}
+			for _, p := range checkQchanBucket(k, cbk.Bucket(k)) {
+				problems = append(problems, fmt.Sprintf("channel %x: %s", k, p))
+			}
+			return nil
+		})
+	})
+	return problems, err
+}
+
+// checkQchanBucket checks a channel's saved data against itself.
+func checkQchanBucket(opBytes []byte, bkt kvdb.Bucket) []string {
+	var problems []string
+	q, err := QchanFromBytes(bkt.Get(KEYutxo))
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	opArr := lnutil.OutPointToBytes(q.Op)
+	if !bytes.Equal(opArr[:], opBytes) {
+		problems = append(problems, fmt.Sprintf("saved under the wrong outpoint; is %s",
+			q.Op.String()))
+	}
+	if q.Value <= 0 {
+		problems = append(problems, fmt.Sprintf("capacity %d", q.Value))
+	}
+
+	closeData, err := QCloseFromBytes(bkt.Get(KEYqclose))
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if closeData.CloseHeight != 0 && !closeData.Closed {
+		problems = append(problems, fmt.Sprintf("close height %d, but no close tx",
+			closeData.CloseHeight))
+	}
+
+	_, err = oldHTLCsFromBytes(bkt.Get(KEYHTLCs))
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	stBytes := bkt.Get(KEYState)
+	if stBytes == nil {
+		// not there yet during funding
+		return problems
+	}
+	st, err := StatComFromBytes(stBytes)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	if st.MyAmt < 0 || st.MyAmt > q.Value {
+		problems = append(problems, fmt.Sprintf("my amount %d out of capacity %d",
+			st.MyAmt, q.Value))
+	}
+	if st.Fee < 0 {
+		problems = append(problems, fmt.Sprintf("fee %d", st.Fee))
+	}
+
+	rcv, err := elkrem.ElkremReceiverFromBytes(bkt.Get(KEYElkRecv))
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	// can't have their revocation for a state we're not past yet
+	if rcv.UpTo() > st.StateIdx {
+		problems = append(problems, fmt.Sprintf("elkrem receiver at %d, past state %d",
+			rcv.UpTo(), st.StateIdx))
+	}
+	return problems
+}
+
+// CheckDBs checks the dbs lit has open, and the channels in them, and sees
+// how much compacting each would save.  Compacting for real has to be done
+// with lit stopped, with --compactdb.
+func (nd *LitNode) CheckDBs() ([]kvdb.CompactReport, []string, error) {
+	dbs := []kvdb.DB{nd.LitDB, nd.DlcManager.DLCDB}
+	for _, wal := range nd.SubWallet {
+		dbs = append(dbs, wal.WalletDB())
+	}
+	if nd.Tower != nil && nd.Tower.DB() != nil {
+		dbs = append(dbs, nd.Tower.DB())
+	}
+
+	var reports []kvdb.CompactReport
+	for _, db := range dbs {
+		r, err := kvdb.CheckCompact(db)
+		if err != nil {
+			return reports, nil, err
+		}
+		reports = append(reports, r)
+	}
+	problems, err := CheckChannelDB(nd.LitDB)
+	return reports, problems, err
+}
@@ -0,0 +1,187 @@
+package qln
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxDebugRows is the most goroutine groups or contended spots DebugState
+// lists.
+const maxDebugRows = 30
+
+// GoroutineGroup is a bunch of goroutines that started in the same function
+// and are at the same spot now.
+type GoroutineGroup struct {
+	Count int
+	Start string // the function the goroutines started in
+	At    string // where they are, outside the runtime
+}
+
+// PeerQueues is how backed up sending to a peer is.
+type PeerQueues struct {
+	PeerIdx uint32
+	// messages waiting for the peer's writer, by priority: control,
+	// payment, bulk
+	Outbox []int
+	// seconds since we last heard from them
+	SinceRecv int64
+}
+
+// Contention is how much waiting on mutexes was for ones unlocked in one
+// function.
+type Contention struct {
+	Func  string
+	Count int64
+	Share float64 // of all the time spent waiting on mutexes
+}
+
+// DebugState is what the node's goroutines and queues are up to, for
+// working out what's stuck.
+type DebugState struct {
+	Goroutines int
+	Groups     []GoroutineGroup // most first
+
+	OmniOut, OmniOutCap int
+	OmniIn, OmniInCap   int
+	UserMessages        int
+	Peers               []PeerQueues
+
+	// mutex contention since startup; empty unless mutex profiling is on,
+	// which the debug listener turns on
+	Contention []Contention
+}
+
+// DebugState gives a snapshot of the node's goroutines, queues and lock
+// contention.
+func (nd *LitNode) DebugState() DebugState {
+	s := DebugState{
+		Goroutines:   runtime.NumGoroutine(),
+		Groups:       goroutineGroups(),
+		OmniOut:      len(nd.OmniOut),
+		OmniOutCap:   cap(nd.OmniOut),
+		OmniIn:       len(nd.OmniIn),
+		OmniInCap:    cap(nd.OmniIn),
+		UserMessages: len(nd.UserMessageBox),
+		Contention:   mutexContention(),
+	}
+
+	now := time.Now().UnixNano()
+	for _, peer := range nd.peerList() {
+		pq := PeerQueues{PeerIdx: peer.Idx, Outbox: make([]int, numPrios)}
+		for i := range peer.outbox {
+			pq.Outbox[i] = len(peer.outbox[i])
+		}
+		last := atomic.LoadInt64(&peer.lastRecv)
+		if last != 0 {
+			pq.SinceRecv = (now - last) / int64(time.Second)
+		}
+		s.Peers = append(s.Peers, pq)
+	}
+	sort.Slice(s.Peers, func(i, j int) bool {
+		return s.Peers[i].PeerIdx < s.Peers[j].PeerIdx
+	})
+	return s
+}
+
+// inRuntime says if a function is the runtime's, not ours.
+func inRuntime(fn string) bool {
+	return strings.HasPrefix(fn, "runtime.") || strings.HasPrefix(fn, "sync.") ||
+		strings.HasPrefix(fn, "internal/")
+}
+
+// goroutineGroups groups every goroutine by where it started and where it
+// is.
+func goroutineGroups() []GoroutineGroup {
+	n, _ := runtime.GoroutineProfile(nil)
+	// more may start in between; leave room
+	recs := make([]runtime.StackRecord, n+16)
+	n, ok := runtime.GoroutineProfile(recs)
+	if !ok {
+		return nil
+	}
+
+	counts := make(map[GoroutineGroup]int)
+	for _, r := range recs[:n] {
+		var g GoroutineGroup
+		frames := runtime.CallersFrames(r.Stack())
+		for {
+			f, more := frames.Next()
+			if !inRuntime(f.Function) {
+				if g.At == "" {
+					g.At = f.Function
+				}
+				// the last one is where it started
+				g.Start = f.Function
+			}
+			if !more {
+				break
+			}
+		}
+		counts[g]++
+	}
+
+	var groups []GoroutineGroup
+	for g, c := range counts {
+		g.Count = c
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Start+groups[i].At < groups[j].Start+groups[j].At
+	})
+	if len(groups) > maxDebugRows {
+		groups = groups[:maxDebugRows]
+	}
+	return groups
+}
+
+// mutexContention sums the mutex profile by the function that held the
+// mutex others waited on.
+func mutexContention() []Contention {
+	n, _ := runtime.MutexProfile(nil)
+	recs := make([]runtime.BlockProfileRecord, n+16)
+	n, ok := runtime.MutexProfile(recs)
+	if !ok {
+		return nil
+	}
+
+	counts := make(map[string]int64)
+	cycles := make(map[string]int64)
+	var total int64
+	for _, r := range recs[:n] {
+		fn := "?"
+		frames := runtime.CallersFrames(r.Stack())
+		for {
+			f, more := frames.Next()
+			if !inRuntime(f.Function) {
+				fn = f.Function
+				break
+			}
+			if !more {
+				break
+			}
+		}
+		counts[fn] += r.Count
+		cycles[fn] += r.Cycles
+		total += r.Cycles
+	}
+
+	var cs []Contention
+	for fn, c := range counts {
+		con := Contention{Func: fn, Count: c}
+		if total > 0 {
+			con.Share = float64(cycles[fn]) / float64(total)
+		}
+		cs = append(cs, con)
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Share > cs[j].Share })
+	if len(cs) > maxDebugRows {
+		cs = cs[:maxDebugRows]
+	}
+	return cs
+}
@@ -2,7 +2,7 @@ package qln
 
 import (
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/txscript"
@@ -25,7 +25,9 @@ func (nd *LitNode) AddContract() (*lnutil.DlcContract, error) {
 	return c, nil
 }
 
-func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64) error {
+// OfferDlc offers a draft contract to a peer. Unless allowNoRefund is set,
+// the contract isn't funded if the peer doesn't sign a refund.
+func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64, allowNoRefund bool) error {
 	c, err := nd.DlcManager.LoadContract(cIdx)
 	if err != nil {
 		return err
@@ -53,6 +55,11 @@ func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64) error {
 		return fmt.Errorf("You need to set a settlement time for the contract before offering it")
 	}
 
+	err = c.CheckRefundLockTime(time.Now())
+	if err != nil {
+		return fmt.Errorf("Can't offer contract %d: %s", c.Idx, err.Error())
+	}
+
 	if c.CoinType == dlc.COINTYPE_NOT_SET {
 		return fmt.Errorf("You need to set a coin type for the contract before offering it")
 	}
@@ -66,6 +73,7 @@ func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64) error {
 	}
 
 	c.PeerIdx = peerIdx
+	c.AllowNoRefund = allowNoRefund
 
 	var kg portxo.KeyGen
 	kg.Depth = 5
@@ -90,6 +98,11 @@ func (nd *LitNode) OfferDlc(peerIdx uint32, cIdx uint64) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			nd.ReleaseContractInputs(c)
+		}
+	}()
 
 	msg := lnutil.NewDlcOfferMsg(peerIdx, c)
 
@@ -131,7 +144,9 @@ func (nd *LitNode) DeclineDlc(cIdx uint64, reason uint8) error {
 	return nil
 }
 
-func (nd *LitNode) AcceptDlc(cIdx uint64) error {
+// AcceptDlc accepts a contract offered to us. Unless allowNoRefund is set,
+// the contract isn't funded if the peer doesn't sign a refund.
+func (nd *LitNode) AcceptDlc(cIdx uint64, allowNoRefund bool) error {
 	c, err := nd.DlcManager.LoadContract(cIdx)
 	if err != nil {
 		return err
@@ -145,11 +160,22 @@ func (nd *LitNode) AcceptDlc(cIdx uint64) error {
 		return fmt.Errorf("You are not connected to peer %d, do that first", c.PeerIdx)
 	}
 
+	err = c.CheckRefundLockTime(time.Now())
+	if err != nil {
+		return fmt.Errorf("Can't accept contract %d: %s", c.Idx, err.Error())
+	}
+	c.AllowNoRefund = allowNoRefund
+
 	// Fund the contract
 	err = nd.FundContract(c)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			nd.ReleaseContractInputs(c)
+		}
+	}()
 
 	var kg portxo.KeyGen
 	kg.Depth = 5
@@ -184,7 +210,12 @@ func (nd *LitNode) AcceptDlc(cIdx uint64) error {
 		return err
 	}
 
-	msg := lnutil.NewDlcOfferAcceptMsg(c, sigs)
+	refundSig, err := nd.SignRefund(c)
+	if err != nil {
+		return err
+	}
+
+	msg := lnutil.NewDlcOfferAcceptMsg(c, sigs, refundSig)
 	c.Status = lnutil.ContractStatusAccepted
 
 	err = nd.DlcManager.SaveContract(c)
@@ -229,7 +260,7 @@ func (nd *LitNode) DlcOfferHandler(msg lnutil.DlcOfferMsg, peer *RemotePeer) {
 
 	err := nd.DlcManager.SaveContract(c)
 	if err != nil {
-		log.Printf("DlcOfferHandler SaveContract err %s\n", err.Error())
+		log.Errorf("DlcOfferHandler SaveContract err %s\n", err.Error())
 		return
 	}
 
@@ -237,46 +268,105 @@ func (nd *LitNode) DlcOfferHandler(msg lnutil.DlcOfferMsg, peer *RemotePeer) {
 	if !ok {
 		// We don't have this coin type, automatically decline
 		nd.DeclineDlc(c.Idx, 0x02)
+		return
 	}
 
+	err = c.CheckRefundLockTime(time.Now())
+	if err != nil {
+		// The refund couldn't be signed, so it can't be accepted either
+		log.Warnf("DlcOfferHandler declining contract %d: %s\n", c.Idx, err.Error())
+		nd.DeclineDlc(c.Idx, 0x03)
+	}
 }
 
 func (nd *LitNode) DlcDeclineHandler(msg lnutil.DlcOfferDeclineMsg, peer *RemotePeer) {
 	c, err := nd.DlcManager.LoadContract(msg.Idx)
 	if err != nil {
-		log.Printf("DlcDeclineHandler FindContract err %s\n", err.Error())
+		log.Errorf("DlcDeclineHandler FindContract err %s\n", err.Error())
 		return
 	}
 
 	c.Status = lnutil.ContractStatusDeclined
 	err = nd.DlcManager.SaveContract(c)
 	if err != nil {
-		log.Printf("DlcDeclineHandler SaveContract err %s\n", err.Error())
+		log.Errorf("DlcDeclineHandler SaveContract err %s\n", err.Error())
 		return
 	}
+
+	nd.ReleaseContractInputs(c)
+}
+
+// refuseDlc calls off a contract that isn't funded yet, and tells the peer
+// so they call it off too.
+func (nd *LitNode) refuseDlc(c *lnutil.DlcContract, reason uint8) {
+	c.Status = lnutil.ContractStatusDeclined
+	err := nd.DlcManager.SaveContract(c)
+	if err != nil {
+		log.Errorf("refuseDlc SaveContract err %s\n", err.Error())
+	}
+
+	nd.ReleaseContractInputs(c)
+
+	nd.OmniOut <- lnutil.NewDlcOfferDeclineMsg(c.PeerIdx, reason, c.TheirIdx)
+}
+
+// ReleaseContractInputs unlocks the inputs FundContract reserved, for a
+// contract that won't be funded.
+func (nd *LitNode) ReleaseContractInputs(c *lnutil.DlcContract) {
+	wal, ok := nd.SubWallet[c.CoinType]
+	if !ok {
+		return
+	}
+	for _, u := range c.OurFundingInputs {
+		err := wal.UnlockUtxo(u.Outpoint)
+		if err != nil {
+			log.Errorf("ReleaseContractInputs %s\n", err.Error())
+		}
+	}
 }
 
 func (nd *LitNode) DlcAcceptHandler(msg lnutil.DlcOfferAcceptMsg, peer *RemotePeer) error {
 	c, err := nd.DlcManager.LoadContract(msg.Idx)
 	if err != nil {
-		log.Printf("DlcAcceptHandler FindContract err %s\n", err.Error())
+		log.Errorf("DlcAcceptHandler FindContract err %s\n", err.Error())
 		return err
 	}
 
-	// TODO: Check signatures
-
 	c.TheirChangePKH = msg.OurChangePKH
 	c.TheirFundingInputs = msg.FundingInputs
-	c.TheirSettlementSignatures = msg.SettlementSignatures
 	c.TheirFundMultisigPub = msg.OurFundMultisigPub
 	c.TheirPayoutBase = msg.OurPayoutBase
 	c.TheirPayoutPKH = msg.OurPayoutPKH
 	c.TheirIdx = msg.OurIdx
 
+	err = nd.VerifySettlementSigs(c, msg.SettlementSignatures)
+	if err != nil {
+		log.Errorf("DlcAcceptHandler %s\n", err.Error())
+		return err
+	}
+	c.TheirSettlementSignatures = msg.SettlementSignatures
+
+	// older nodes don't sign a refund; then the contract can't be refunded,
+	// so it's only funded if we said that's ok
+	if msg.RefundSignature != [64]byte{} {
+		err = nd.VerifyRefundSig(c, msg.RefundSignature)
+		if err != nil {
+			log.Errorf("DlcAcceptHandler %s\n", err.Error())
+			return err
+		}
+	} else if !c.AllowNoRefund {
+		err = fmt.Errorf("Peer %d didn't sign a refund for contract %d; "+
+			"declined", c.PeerIdx, c.Idx)
+		log.Errorf("DlcAcceptHandler %s\n", err.Error())
+		nd.refuseDlc(c, 0x03)
+		return err
+	}
+	c.TheirRefundSignature = msg.RefundSignature
+
 	c.Status = lnutil.ContractStatusAccepted
 	err = nd.DlcManager.SaveContract(c)
 	if err != nil {
-		log.Printf("DlcAcceptHandler SaveContract err %s\n", err.Error())
+		log.Errorf("DlcAcceptHandler SaveContract err %s\n", err.Error())
 		return err
 	}
 
@@ -286,7 +376,12 @@ func (nd *LitNode) DlcAcceptHandler(msg lnutil.DlcOfferAcceptMsg, peer *RemotePe
 		return err
 	}
 
-	outMsg := lnutil.NewDlcContractAckMsg(c, sigs)
+	refundSig, err := nd.SignRefund(c)
+	if err != nil {
+		return err
+	}
+
+	outMsg := lnutil.NewDlcContractAckMsg(c, sigs, refundSig)
 	c.Status = lnutil.ContractStatusAcknowledged
 
 	err = nd.DlcManager.SaveContract(c)
@@ -303,36 +398,55 @@ func (nd *LitNode) DlcAcceptHandler(msg lnutil.DlcOfferAcceptMsg, peer *RemotePe
 func (nd *LitNode) DlcContractAckHandler(msg lnutil.DlcContractAckMsg, peer *RemotePeer) {
 	c, err := nd.DlcManager.LoadContract(msg.Idx)
 	if err != nil {
-		log.Printf("DlcContractAckHandler FindContract err %s\n", err.Error())
+		log.Errorf("DlcContractAckHandler FindContract err %s\n", err.Error())
 		return
 	}
 
-	// TODO: Check signatures
+	err = nd.VerifySettlementSigs(c, msg.SettlementSignatures)
+	if err != nil {
+		log.Errorf("DlcContractAckHandler %s\n", err.Error())
+		return
+	}
+	c.TheirSettlementSignatures = msg.SettlementSignatures
+
+	if msg.RefundSignature != [64]byte{} {
+		err = nd.VerifyRefundSig(c, msg.RefundSignature)
+		if err != nil {
+			log.Errorf("DlcContractAckHandler %s\n", err.Error())
+			return
+		}
+	} else if !c.AllowNoRefund {
+		log.Errorf("DlcContractAckHandler Peer %d didn't sign a refund for "+
+			"contract %d; declined\n", c.PeerIdx, c.Idx)
+		nd.refuseDlc(c, 0x03)
+		return
+	}
+	c.TheirRefundSignature = msg.RefundSignature
 
 	c.Status = lnutil.ContractStatusAcknowledged
 
 	err = nd.DlcManager.SaveContract(c)
 	if err != nil {
-		log.Printf("DlcContractAckHandler SaveContract err %s\n", err.Error())
+		log.Errorf("DlcContractAckHandler SaveContract err %s\n", err.Error())
 		return
 	}
 
 	// We have everything now, send our signatures to the funding TX
 	wal, ok := nd.SubWallet[c.CoinType]
 	if !ok {
-		log.Printf("DlcContractAckHandler No wallet for cointype %d\n", c.CoinType)
+		log.Errorf("DlcContractAckHandler No wallet for cointype %d\n", c.CoinType)
 		return
 	}
 
 	tx, err := nd.BuildDlcFundingTransaction(c)
 	if err != nil {
-		log.Printf("DlcContractAckHandler BuildDlcFundingTransaction err %s\n", err.Error())
+		log.Errorf("DlcContractAckHandler BuildDlcFundingTransaction err %s\n", err.Error())
 		return
 	}
 
 	err = wal.SignMyInputs(&tx)
 	if err != nil {
-		log.Printf("DlcContractAckHandler SignMyInputs err %s\n", err.Error())
+		log.Errorf("DlcContractAckHandler SignMyInputs err %s\n", err.Error())
 		return
 	}
 
@@ -344,7 +458,7 @@ func (nd *LitNode) DlcContractAckHandler(msg lnutil.DlcContractAckMsg, peer *Rem
 func (nd *LitNode) DlcFundingSigsHandler(msg lnutil.DlcContractFundingSigsMsg, peer *RemotePeer) {
 	c, err := nd.DlcManager.LoadContract(msg.Idx)
 	if err != nil {
-		log.Printf("DlcFundingSigsHandler FindContract err %s\n", err.Error())
+		log.Errorf("DlcFundingSigsHandler FindContract err %s\n", err.Error())
 		return
 	}
 
@@ -353,7 +467,7 @@ func (nd *LitNode) DlcFundingSigsHandler(msg lnutil.DlcContractFundingSigsMsg, p
 	// We have everything now. Sign our inputs to the funding TX and send it to the blockchain.
 	wal, ok := nd.SubWallet[c.CoinType]
 	if !ok {
-		log.Printf("DlcFundingSigsHandler No wallet for cointype %d\n", c.CoinType)
+		log.Errorf("DlcFundingSigsHandler No wallet for cointype %d\n", c.CoinType)
 		return
 	}
 
@@ -363,14 +477,14 @@ func (nd *LitNode) DlcFundingSigsHandler(msg lnutil.DlcContractFundingSigsMsg, p
 
 	err = wal.WatchThis(c.FundingOutpoint)
 	if err != nil {
-		log.Printf("DlcFundingSigsHandler WatchThis err %s\n", err.Error())
+		log.Errorf("DlcFundingSigsHandler WatchThis err %s\n", err.Error())
 		return
 	}
 
 	c.Status = lnutil.ContractStatusActive
 	err = nd.DlcManager.SaveContract(c)
 	if err != nil {
-		log.Printf("DlcFundingSigsHandler SaveContract err %s\n", err.Error())
+		log.Errorf("DlcFundingSigsHandler SaveContract err %s\n", err.Error())
 		return
 	}
 
@@ -382,27 +496,27 @@ func (nd *LitNode) DlcFundingSigsHandler(msg lnutil.DlcContractFundingSigsMsg, p
 func (nd *LitNode) DlcSigProofHandler(msg lnutil.DlcContractSigProofMsg, peer *RemotePeer) {
 	c, err := nd.DlcManager.LoadContract(msg.Idx)
 	if err != nil {
-		log.Printf("DlcSigProofHandler FindContract err %s\n", err.Error())
+		log.Errorf("DlcSigProofHandler FindContract err %s\n", err.Error())
 		return
 	}
 
 	// TODO: Check signatures
 	wal, ok := nd.SubWallet[c.CoinType]
 	if !ok {
-		log.Printf("DlcSigProofHandler No wallet for cointype %d\n", c.CoinType)
+		log.Errorf("DlcSigProofHandler No wallet for cointype %d\n", c.CoinType)
 		return
 	}
 
 	err = wal.WatchThis(c.FundingOutpoint)
 	if err != nil {
-		log.Printf("DlcSigProofHandler WatchThis err %s\n", err.Error())
+		log.Errorf("DlcSigProofHandler WatchThis err %s\n", err.Error())
 		return
 	}
 
 	c.Status = lnutil.ContractStatusActive
 	err = nd.DlcManager.SaveContract(c)
 	if err != nil {
-		log.Printf("DlcSigProofHandler SaveContract err %s\n", err.Error())
+		log.Errorf("DlcSigProofHandler SaveContract err %s\n", err.Error())
 		return
 	}
 }
@@ -447,6 +561,42 @@ func (nd *LitNode) SignSettlementDivisions(c *lnutil.DlcContract) ([]lnutil.DlcC
 	return returnValue, nil
 }
 
+// SignRefund signs the contract's refund tx. The funding outpoint has to be
+// set already. It won't sign a refund that could be sent straight away, or
+// never.
+func (nd *LitNode) SignRefund(c *lnutil.DlcContract) ([64]byte, error) {
+	err := c.CheckRefundLockTime(time.Now())
+	if err != nil {
+		return [64]byte{}, fmt.Errorf("Won't sign refund for contract %d: %s",
+			c.Idx, err.Error())
+	}
+	return nd.signRefund(c)
+}
+
+// signRefund signs the contract's refund tx without checking its locktime,
+// for sending it once the locktime's passed.
+func (nd *LitNode) signRefund(c *lnutil.DlcContract) ([64]byte, error) {
+	wal, ok := nd.SubWallet[c.CoinType]
+	if !ok {
+		return [64]byte{}, fmt.Errorf("Wallet of type %d not found", c.CoinType)
+	}
+
+	var kg portxo.KeyGen
+	kg.Depth = 5
+	kg.Step[0] = 44 | 1<<31
+	kg.Step[1] = c.CoinType | 1<<31
+	kg.Step[2] = UseContractFundMultisig
+	kg.Step[3] = c.PeerIdx | 1<<31
+	kg.Step[4] = uint32(c.Idx) | 1<<31
+
+	priv, err := wal.GetPriv(kg)
+	if err != nil {
+		return [64]byte{}, fmt.Errorf("Could not get private key for contract %d", c.Idx)
+	}
+
+	return nd.SignSettlementTx(c, lnutil.DlcRefundTx(c), priv)
+}
+
 func (nd *LitNode) BuildDlcFundingTransaction(c *lnutil.DlcContract) (wire.MsgTx, error) {
 	// make the tx
 	tx := wire.NewMsgTx()
@@ -495,7 +645,9 @@ func (nd *LitNode) FundContract(c *lnutil.DlcContract) error {
 		return fmt.Errorf("No wallet of type %d connected", c.CoinType)
 	}
 
-	utxos, _, err := wal.PickUtxos(int64(c.OurFundingAmount), 500, wal.Fee(), true)
+	// lock the inputs, so they're still there when the funding tx is signed
+	utxos, _, err := wal.ReserveUtxos(int64(c.OurFundingAmount), 500,
+		wal.Fee(), true, "", fmt.Sprintf("funding dlc contract %d", c.Idx))
 	if err != nil {
 		return err
 	}
@@ -507,6 +659,7 @@ func (nd *LitNode) FundContract(c *lnutil.DlcContract) error {
 
 	c.OurChangePKH, err = wal.NewAdr()
 	if err != nil {
+		nd.ReleaseContractInputs(c)
 		return err
 	}
 
@@ -517,20 +670,20 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 
 	c, err := nd.DlcManager.LoadContract(cIdx)
 	if err != nil {
-		log.Printf("SettleContract FindContract err %s\n", err.Error())
+		log.Errorf("SettleContract FindContract err %s\n", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
 	c.Status = lnutil.ContractStatusSettling
 	err = nd.DlcManager.SaveContract(c)
 	if err != nil {
-		log.Printf("SettleContract SaveContract err %s\n", err.Error())
+		log.Errorf("SettleContract SaveContract err %s\n", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
 	d, err := c.GetDivision(oracleValue)
 	if err != nil {
-		log.Printf("SettleContract GetDivision err %s\n", err.Error())
+		log.Errorf("SettleContract GetDivision err %s\n", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
@@ -554,13 +707,13 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 
 	settleTx, err := lnutil.SettlementTx(c, *d, false)
 	if err != nil {
-		log.Printf("SettleContract SettlementTx err %s\n", err.Error())
+		log.Errorf("SettleContract SettlementTx err %s\n", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
 	mySig, err := nd.SignSettlementTx(c, settleTx, priv)
 	if err != nil {
-		log.Printf("SettleContract SignSettlementTx err %s", err.Error())
+		log.Errorf("SettleContract SignSettlementTx err %s", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
@@ -575,7 +728,7 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 
 	pre, swap, err := lnutil.FundTxScript(c.OurFundMultisigPub, c.TheirFundMultisigPub)
 	if err != nil {
-		log.Printf("SettleContract FundTxScript err %s", err.Error())
+		log.Errorf("SettleContract FundTxScript err %s", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
@@ -589,7 +742,7 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 	// Settlement TX should be valid here, so publish it.
 	err = wal.DirectSendTx(settleTx)
 	if err != nil {
-		log.Printf("SettleContract DirectSendTx (settle) err %s", err.Error())
+		log.Errorf("SettleContract DirectSendTx (settle) err %s", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
@@ -618,14 +771,14 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 	settleScript := lnutil.DlcCommitScript(c.OurPayoutBase, pubOracleBytes, c.TheirPayoutBase, 5)
 	err = nd.SignClaimTx(txClaim, settleTx.TxOut[0].Value, settleScript, privContractOutput, false)
 	if err != nil {
-		log.Printf("SettleContract SignClaimTx err %s", err.Error())
+		log.Errorf("SettleContract SignClaimTx err %s", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
 	// Claim TX should be valid here, so publish it.
 	err = wal.DirectSendTx(txClaim)
 	if err != nil {
-		log.Printf("SettleContract DirectSendTx (claim) err %s", err.Error())
+		log.Errorf("SettleContract DirectSendTx (claim) err %s", err.Error())
 		return [32]byte{}, [32]byte{}, err
 	}
 
@@ -636,3 +789,65 @@ func (nd *LitNode) SettleContract(cIdx uint64, oracleValue int64, oracleSig [32]
 	}
 	return settleTx.TxHash(), txClaim.TxHash(), nil
 }
+
+// RefundContract sends the contract's refund tx, paying both sides their
+// funding back, for when the oracle hasn't published. It won't get into a
+// block before the contract's RefundLockTime. The contract is marked
+// refunded once the tx is seen spending the funding output.
+func (nd *LitNode) RefundContract(cIdx uint64) ([32]byte, error) {
+	c, err := nd.DlcManager.LoadContract(cIdx)
+	if err != nil {
+		log.Errorf("RefundContract FindContract err %s\n", err.Error())
+		return [32]byte{}, err
+	}
+
+	if c.Status != lnutil.ContractStatusActive {
+		return [32]byte{}, fmt.Errorf("You can only refund an active contract")
+	}
+
+	if c.TheirRefundSignature == [64]byte{} {
+		return [32]byte{}, fmt.Errorf("Peer %d didn't sign a refund for contract %d", c.PeerIdx, c.Idx)
+	}
+
+	wal, ok := nd.SubWallet[c.CoinType]
+	if !ok {
+		return [32]byte{}, fmt.Errorf("RefundContract Wallet of type %d not found", c.CoinType)
+	}
+
+	refundTx := lnutil.DlcRefundTx(c)
+
+	// the locktime was checked when the refund was signed for the peer
+	mySig, err := nd.signRefund(c)
+	if err != nil {
+		log.Errorf("RefundContract signRefund err %s", err.Error())
+		return [32]byte{}, err
+	}
+
+	myBigSig := sig64.SigDecompress(mySig)
+	theirBigSig := sig64.SigDecompress(c.TheirRefundSignature)
+
+	// put the sighash all byte on the end of both signatures
+	myBigSig = append(myBigSig, byte(txscript.SigHashAll))
+	theirBigSig = append(theirBigSig, byte(txscript.SigHashAll))
+
+	pre, swap, err := lnutil.FundTxScript(c.OurFundMultisigPub, c.TheirFundMultisigPub)
+	if err != nil {
+		log.Errorf("RefundContract FundTxScript err %s", err.Error())
+		return [32]byte{}, err
+	}
+
+	// swap if needed
+	if swap {
+		refundTx.TxIn[0].Witness = SpendMultiSigWitStack(pre, theirBigSig, myBigSig)
+	} else {
+		refundTx.TxIn[0].Witness = SpendMultiSigWitStack(pre, myBigSig, theirBigSig)
+	}
+
+	err = wal.DirectSendTx(refundTx)
+	if err != nil {
+		log.Errorf("RefundContract DirectSendTx err %s", err.Error())
+		return [32]byte{}, err
+	}
+
+	return refundTx.TxHash(), nil
+}
@@ -0,0 +1,123 @@
+package qln
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// DNS seeds are a fallback for the tracker.  A seed answers SRV queries for
+// _nodes._tcp.<seed> with records whose targets are <lit address>.<seed>,
+// and A / AAAA queries for those targets, like the BOLT #10 seeds.  Any
+// number of seeds can be run by anyone, so there isn't one server that
+// takes everyone down with it.
+
+// SeedNode is a node a DNS seed told us about.
+type SeedNode struct {
+	LitAdr string
+	Host   string // host:port
+}
+
+// QueryDNSSeed gets the nodes a seed knows about.
+func QueryDNSSeed(seed string) ([]SeedNode, error) {
+	_, srvs, err := net.LookupSRV("nodes", "tcp", seed)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []SeedNode
+	for _, srv := range srvs {
+		target := strings.TrimSuffix(srv.Target, ".")
+		adr := strings.SplitN(target, ".", 2)[0]
+		if !lnutil.LitAdrOK(adr) {
+			continue
+		}
+		ips, err := net.LookupHost(target)
+		if err != nil || len(ips) == 0 {
+			continue
+		}
+		nodes = append(nodes, SeedNode{
+			LitAdr: adr,
+			Host:   net.JoinHostPort(ips[0], strconv.Itoa(int(srv.Port))),
+		})
+	}
+	return nodes, nil
+}
+
+// seedLookup finds a node's address with the DNS seeds, for when the
+// tracker doesn't answer.  First the seed's node list, then asking the
+// seed for the node by name, on the default port.
+func (nd *LitNode) seedLookup(litadr string) ([]string, error) {
+	// DNS would go around the proxy and say who we're looking for
+	if nd.ProxyURL != "" {
+		return nil, fmt.Errorf("not using DNS seeds through a proxy")
+	}
+	for _, seed := range nd.DNSSeeds {
+		nodes, err := QueryDNSSeed(seed)
+		if err != nil {
+			log.Errorf("DNS seed %s: %s\n", seed, err.Error())
+		}
+		for _, n := range nodes {
+			if n.LitAdr == litadr {
+				return []string{n.Host}, nil
+			}
+		}
+		ips, err := net.LookupHost(litadr + "." + seed)
+		if err != nil || len(ips) == 0 {
+			continue
+		}
+		var hosts []string
+		for _, ip := range ips {
+			hosts = append(hosts, net.JoinHostPort(ip, "2448"))
+		}
+		return hosts, nil
+	}
+	return nil, fmt.Errorf("%s not found on DNS seeds", litadr)
+}
+
+// SeedCandidates is every node the DNS seeds know about that we aren't
+// already connected to; for picking new peers.
+func (nd *LitNode) SeedCandidates() []SeedNode {
+	if nd.ProxyURL != "" {
+		return nil
+	}
+	idPub := nd.IdKey().PubKey().SerializeCompressed()
+	var myPub [33]byte
+	copy(myPub[:], idPub)
+	me := lnutil.LitAdrFromPubkey(myPub)
+
+	seen := make(map[string]bool)
+	var cands []SeedNode
+	for _, seed := range nd.DNSSeeds {
+		nodes, err := QueryDNSSeed(seed)
+		if err != nil {
+			log.Errorf("DNS seed %s: %s\n", seed, err.Error())
+			continue
+		}
+		for _, n := range nodes {
+			if n.LitAdr == me || seen[n.LitAdr] {
+				continue
+			}
+			seen[n.LitAdr] = true
+			if nd.connectedToAdr(n.LitAdr) {
+				continue
+			}
+			cands = append(cands, n)
+		}
+	}
+	return cands
+}
+
+// connectedToAdr says whether we're connected to the node with a lit address.
+func (nd *LitNode) connectedToAdr(adr string) bool {
+	for _, peer := range nd.peerList() {
+		var pub [33]byte
+		copy(pub[:], peer.Con.RemotePub.SerializeCompressed())
+		if lnutil.LitAdrFromPubkey(pub) == adr {
+			return true
+		}
+	}
+	return false
+}
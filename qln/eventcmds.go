@@ -0,0 +1,156 @@
+package qln
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+/*
+Event commands are the simple way to act on events: a command run for an
+event type, like --onpaymentreceived=/usr/local/bin/notify.sh, with what
+happened in its environment:
+
+LIT_EVENT    the event type, like payment_received
+LIT_TIME     unix time
+LIT_PEER     peer index
+LIT_CHANIDX  channel index
+LIT_AMT      satoshis
+LIT_TXID     txid
+LIT_DETAIL   anything else
+
+The event types are the same as webhooks'; variables that don't apply are
+empty.  Commands are split on spaces, not run with a shell.  At most limit
+run at once; the rest wait in line, and if too many are waiting, new ones
+are dropped.  One still going after the timeout is killed.
+*/
+
+const (
+	// eventCmdQueue is how many event commands can wait to run
+	eventCmdQueue = 1000
+	// DefaultEventCmdLimit is how many event commands run at once.
+	DefaultEventCmdLimit = 4
+	// DefaultEventCmdTimeout is how long an event command gets.
+	DefaultEventCmdTimeout = time.Minute
+)
+
+// eventCmds are the commands to run for each event type.  nil if there
+// aren't any.
+type eventCmds struct {
+	cmds    map[string][]string // event type: args
+	timeout time.Duration
+	queue   chan eventCmdRun
+}
+
+type eventCmdRun struct {
+	args []string
+	e    WebhookEvent
+}
+
+// SetEventCommands sets the command to run for each event type, and
+// starts limit workers to run them.  Call once, before events happen.
+func (nd *LitNode) SetEventCommands(cmds map[string]string, limit int,
+	timeout time.Duration) error {
+	ec := &eventCmds{cmds: make(map[string][]string), timeout: timeout,
+		queue: make(chan eventCmdRun, eventCmdQueue)}
+	for event, command := range cmds {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			continue
+		}
+		switch event {
+		case WebhookPaymentReceived, WebhookChannelOpened, WebhookChannelClosed,
+			WebhookBreachDetected, WebhookPeerDisconnected:
+		default:
+			return fmt.Errorf("no event %s to run a command on", event)
+		}
+		_, err := exec.LookPath(args[0])
+		if err != nil {
+			return fmt.Errorf("%s command: %s", event, err.Error())
+		}
+		ec.cmds[event] = args
+	}
+	if len(ec.cmds) == 0 {
+		return nil
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	if ec.timeout <= 0 {
+		ec.timeout = DefaultEventCmdTimeout
+	}
+	for i := 0; i < limit; i++ {
+		go ec.runLoop()
+	}
+	nd.eventCmds = ec
+	return nil
+}
+
+// runEventCommand queues the command for an event, if there is one.
+func (nd *LitNode) runEventCommand(e WebhookEvent) {
+	ec := nd.eventCmds
+	if ec == nil {
+		return
+	}
+	args, ok := ec.cmds[e.Type]
+	if !ok {
+		return
+	}
+	select {
+	case ec.queue <- eventCmdRun{args: args, e: e}:
+	default:
+		log.Warnf("too many event commands waiting; dropping %s's\n", e.Type)
+	}
+}
+
+func (ec *eventCmds) runLoop() {
+	for run := range ec.queue {
+		err := ec.run(run.args, run.e)
+		if err != nil {
+			log.Errorf("%s command %s: %s\n", run.e.Type, run.args[0], err.Error())
+		}
+	}
+}
+
+// run runs an event's command, killing it at the timeout.
+func (ec *eventCmds) run(args []string, e WebhookEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ec.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), eventEnv(e)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("killed after %s", ec.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s", err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// eventEnv is an event as environment variables.
+func eventEnv(e WebhookEvent) []string {
+	env := []string{
+		"LIT_EVENT=" + e.Type,
+		fmt.Sprintf("LIT_TIME=%d", e.Time),
+		"LIT_PEER=", "LIT_CHANIDX=", "LIT_AMT=",
+		"LIT_TXID=" + e.Txid,
+		"LIT_DETAIL=" + e.Detail,
+	}
+	if e.Peer != 0 {
+		env[2] = fmt.Sprintf("LIT_PEER=%d", e.Peer)
+	}
+	if e.ChanIdx != 0 {
+		env[3] = fmt.Sprintf("LIT_CHANIDX=%d", e.ChanIdx)
+	}
+	if e.Amt != 0 {
+		env[4] = fmt.Sprintf("LIT_AMT=%d", e.Amt)
+	}
+	return env
+}
@@ -0,0 +1,109 @@
+package qln
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// WatchExternalIP checks our external IP every interval, and when it
+// changes, tells the tracker and all connected peers where we are now.
+// Otherwise a node on a home connection with a dynamic IP silently becomes
+// unreachable.  The IP comes from the NAT router if we're mapping ports,
+// otherwise from resolverURL (or the default web service if that's empty).
+// Doesn't return; run it in a goroutine.
+func (nd *LitNode) WatchExternalIP(resolverURL string, interval time.Duration) {
+	if resolverURL == "" {
+		resolverURL = defaultIPv4Resolver
+	}
+	var lastIP string
+	for {
+		ip, err := nd.externalIP(resolverURL)
+		if err != nil {
+			log.Errorf("external IP check error %s\n", err.Error())
+		} else if ip != lastIP {
+			if lastIP != "" {
+				log.Debugf("external IP changed from %s to %s\n", lastIP, ip)
+				nd.reannounce(ip)
+			}
+			lastIP = ip
+		}
+		time.Sleep(interval)
+	}
+}
+
+// externalIP gets our IPv4 address as the outside world sees it.
+func (nd *LitNode) externalIP(resolverURL string) (string, error) {
+	nd.NatMtx.Lock()
+	mapper := nd.NatMapper
+	nd.NatMtx.Unlock()
+
+	if mapper != nil {
+		ip, err := mapper.ExternalIP()
+		if err == nil {
+			return ip.String(), nil
+		}
+		log.Errorf("%s external IP error %s\n", mapper.Name(), err.Error())
+	}
+	return ExternalIP(resolverURL)
+}
+
+// reannounce tells the tracker and connected peers about our new IP, for
+// each port we're listening on.
+func (nd *LitNode) reannounce(ip string) {
+	nd.lisMtx.Lock()
+	ports := nd.LisIpPorts
+	nd.lisMtx.Unlock()
+	var peerIdxs []uint32
+	for _, peer := range nd.peerList() {
+		peerIdxs = append(peerIdxs, peer.Idx)
+	}
+
+	// don't give our IP out if we're hiding behind a proxy
+	if nd.ProxyURL != "" || len(ports) == 0 {
+		return
+	}
+
+	err := nd.announce(ip)
+	if err != nil {
+		log.Errorf("Announcement error %s", err.Error())
+	}
+
+	// peers only get one address; the first port we listen on
+	_, port, err := net.SplitHostPort(ports[0])
+	if err != nil {
+		log.Errorf("reannounce: %s", err.Error())
+		return
+	}
+	host := net.JoinHostPort(ip, nd.externalPort(port))
+	for _, idx := range peerIdxs {
+		nd.OmniOut <- lnutil.NewNodeAddrMsg(idx, host)
+	}
+}
+
+// NodeAddrHandler saves the new host:port a peer says they're at, so we
+// reconnect to them there.  Only public IPs are taken; a peer shouldn't be
+// able to point us at our own machine or LAN.
+func (nd *LitNode) NodeAddrHandler(msg lnutil.NodeAddrMsg, peer *RemotePeer) error {
+	host, port, err := net.SplitHostPort(msg.Host)
+	if err != nil {
+		return err
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || portNum == 0 {
+		return fmt.Errorf("NodeAddrHandler: bad port in %s", msg.Host)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("NodeAddrHandler: %s isn't an IP address", host)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return fmt.Errorf("NodeAddrHandler: %s isn't a public address", host)
+	}
+	log.Infof("peer %d now at %s\n", msg.Peer(), msg.Host)
+	return nd.SavePeerHost(msg.Host, msg.Peer())
+}
@@ -0,0 +1,93 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// how long to wait for a new peer's init before deciding what to send them
+const initWait = 5 * time.Second
+
+// msgFeature gives the feature a message type needs.  Peers from before it
+// can't parse it, so it's only sent once we've both said we have it.
+func msgFeature(msgType uint8) (uint, bool) {
+	switch msgType {
+	case lnutil.MSGID_PING, lnutil.MSGID_PONG:
+		return lnutil.FeaturePingOptional, true
+	case lnutil.MSGID_NODEADDR:
+		return lnutil.FeatureNodeAddrOptional, true
+	case lnutil.MSGID_WATCH_TERMSREQ, lnutil.MSGID_WATCH_TERMS,
+		lnutil.MSGID_WATCH_BLOB, lnutil.MSGID_WATCH_BLOBDEL,
+		lnutil.MSGID_WATCH_ACK:
+		return lnutil.FeatureTowerFeeOptional, true
+	case lnutil.MSGID_CUSTOM:
+		return lnutil.FeatureCustomOptional, true
+	}
+	return 0, false
+}
+
+// sendInit queues our init message ahead of anything else for a new peer.
+// Call before the peer's writer starts.
+func (nd *LitNode) sendInit(peer *RemotePeer) {
+	peer.outbox[prioControl] <- lnutil.NewInitMsg(
+		peer.Idx, lnutil.ProtocolVersion, nd.Features)
+}
+
+// InitHandler works out what we can do with a peer from their init message.
+// If we can't talk to them, they're disconnected; their side sees our init
+// and does the same, so nobody is left waiting on the other.
+func (nd *LitNode) InitHandler(msg lnutil.InitMsg, peer *RemotePeer) error {
+	if msg.Version < lnutil.MinProtocolVersion {
+		peer.Con.Close()
+		return fmt.Errorf("peer %d protocol version %d, need %d",
+			peer.Idx, msg.Version, lnutil.MinProtocolVersion)
+	}
+	features, err := lnutil.NegotiateFeatures(nd.Features, msg.Features)
+	if err != nil {
+		peer.Con.Close()
+		return fmt.Errorf("peer %d incompatible: %s", peer.Idx, err.Error())
+	}
+
+	peer.mtx.Lock()
+	peer.Version = msg.Version
+	peer.Features = features
+	peer.mtx.Unlock()
+	peer.initOnce.Do(func() { close(peer.initDone) })
+
+	log.Debugf("peer %d protocol version %d features %x\n",
+		peer.Idx, msg.Version, uint64(features))
+	// now we know how to send them the chat that's waiting, and our alias
+	go nd.sendQueuedChats(peer.Idx)
+	go nd.sendAlias(peer.Idx)
+	return nil
+}
+
+// HasFeature says whether a feature is on for our connection with the peer.
+// Until their init message comes in, nothing is.
+func (p *RemotePeer) HasFeature(bit uint) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.Features.Has(bit)
+}
+
+// peerHasFeature says whether a feature is on with a connected peer,
+// waiting a bit for their init if it hasn't come in yet.
+func (nd *LitNode) peerHasFeature(peerIdx uint32, bit uint) bool {
+	peer, ok := nd.GetPeer(peerIdx)
+	if !ok {
+		return false
+	}
+	select {
+	case <-peer.initDone:
+	case <-time.After(initWait):
+	}
+	return peer.HasFeature(bit)
+}
+
+// willCompress says whether big bulk messages to the peer get compressed.
+// Small or urgent ones never are; it isn't worth the delay.
+func (nd *LitNode) willCompress(peer *RemotePeer) bool {
+	return peer.HasFeature(lnutil.FeatureCompressOptional)
+}
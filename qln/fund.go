@@ -2,7 +2,6 @@ package qln
 
 import (
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/wire"
@@ -101,13 +100,24 @@ an exact timing for the payment.
 
 // FundChannel opens a channel with a peer.  Doesn't return until the channel
 // has been created.  Maybe timeout if it takes too long?
-func (nd *LitNode) FundChannel(
-	peerIdx, cointype uint32, ccap, initSend int64, data [32]byte) (uint32, error) {
-
-	_, ok := nd.SubWallet[cointype]
+// coinSelect picks the funding inputs; empty for the wallet's strategy.
+// useInputs, if given, are the funding inputs instead; avoidInputs won't be.
+// change says where the funding tx's change goes; nil for the default.
+func (nd *LitNode) FundChannel(peerIdx, cointype uint32, ccap, initSend int64,
+	data [32]byte, coinSelect string,
+	useInputs, avoidInputs []wire.OutPoint,
+	change *lnutil.ChangeSpec) (uint32, error) {
+
+	wal, ok := nd.SubWallet[cointype]
 	if !ok {
 		return 0, fmt.Errorf("No wallet of type %d connected", cointype)
 	}
+	// the close would pay each side's fee
+	maxFee := wal.Params().MaxCloseFee
+	if maxFee != 0 && chanFee(wal)*2 > maxFee {
+		return 0, fmt.Errorf("channel close would pay %d fee, over %s max %d",
+			chanFee(wal)*2, wal.Params().Name, maxFee)
+	}
 
 	nd.InProg.mtx.Lock()
 	//	defer nd.InProg.mtx.Lock()
@@ -156,6 +166,10 @@ func (nd *LitNode) FundChannel(
 	nd.InProg.Amt = ccap
 	nd.InProg.InitSend = initSend
 	nd.InProg.Data = data
+	nd.InProg.CoinSelect = coinSelect
+	nd.InProg.UseInputs = useInputs
+	nd.InProg.AvoidInputs = avoidInputs
+	nd.InProg.Change = change
 
 	nd.InProg.Coin = cointype
 	nd.InProg.mtx.Unlock() // switch to defer
@@ -178,7 +192,7 @@ func (nd *LitNode) PointReqHandler(msg lnutil.PointReqMsg) {
 
 	/* shouldn't be possible to get this error...
 	if nd.RemoteCon == nil || nd.RemoteCon.RemotePub == nil {
-		log.Printf("Not connected to anyone\n")
+		log.Warnf("Not connected to anyone\n")
 		return
 	}*/
 
@@ -187,13 +201,13 @@ func (nd *LitNode) PointReqHandler(msg lnutil.PointReqMsg) {
 
 	cIdx, err := nd.NextChannelIdx()
 	if err != nil {
-		log.Printf("PointReqHandler err %s", err.Error())
+		log.Errorf("PointReqHandler err %s", err.Error())
 		return
 	}
 
 	_, ok := nd.SubWallet[msg.Cointype]
 	if !ok {
-		log.Printf("PointReqHandler err no wallet for type %d", msg.Cointype)
+		log.Errorf("PointReqHandler err no wallet for type %d", msg.Cointype)
 		return
 	}
 
@@ -209,11 +223,11 @@ func (nd *LitNode) PointReqHandler(msg lnutil.PointReqMsg) {
 	myRefundPub, _ := nd.GetUsePub(kg, UseChannelRefund)
 	myHAKDbase, err := nd.GetUsePub(kg, UseChannelHAKDBase)
 	if err != nil {
-		log.Printf("PointReqHandler err %s", err.Error())
+		log.Errorf("PointReqHandler err %s", err.Error())
 		return
 	}
 
-	log.Printf("Generated channel pubkey %x\n", myChanPub)
+	log.Debugf("Generated channel pubkey %x\n", myChanPub)
 
 	outMsg := lnutil.NewPointRespMsg(msg.Peer(), myChanPub, myRefundPub, myHAKDbase)
 	nd.OmniOut <- outMsg
@@ -291,8 +305,13 @@ func (nd *LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 	}
 
 	// call MaybeSend, freezing inputs and learning the txid of the channel
-	// here, we require only witness inputs
-	outPoints, err := nd.SubWallet[q.Coin()].MaybeSend([]*wire.TxOut{txo}, true)
+	// here, we require only witness inputs.  The coin may have its own fee
+	// rate for funding txs.
+	wal := nd.SubWallet[q.Coin()]
+	outPoints, err := wal.MaybeSendFrom(0,
+		[]*wire.TxOut{txo}, true, nd.InProg.CoinSelect,
+		nd.InProg.UseInputs, nd.InProg.AvoidInputs, nd.InProg.Change,
+		wal.Params().FundFeePerByte)
 	if err != nil {
 		return err
 	}
@@ -302,6 +321,14 @@ func (nd *LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 		return fmt.Errorf("got %d OPs from MaybeSend (expect 1)", len(outPoints))
 	}
 
+	// if the channel doesn't get described after this, unfreeze the inputs
+	// so other sends can use them
+	defer func() {
+		if err != nil {
+			nd.SubWallet[q.Coin()].NahDontSend(&outPoints[0].Hash)
+		}
+	}()
+
 	// save fund outpoint to inProg
 	nd.InProg.op = outPoints[0]
 	// also set outpoint in channel
@@ -311,9 +338,9 @@ func (nd *LitNode) PointRespHandler(msg lnutil.PointRespMsg) error {
 	q.State = new(StatCom)
 	q.State.StateIdx = 0
 	q.State.MyAmt = nd.InProg.Amt - nd.InProg.InitSend
-	// get fee from sub wallet.  Later should make fee per channel and update state
-	// based on size
-	q.State.Fee = nd.SubWallet[q.Coin()].Fee() * 1000
+	// fee is fixed per coin so the other side has the same one.  Later should
+	// make fee per channel and update state based on size
+	q.State.Fee = chanFee(wal)
 
 	q.State.Data = nd.InProg.Data
 
@@ -359,7 +386,16 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) {
 
 	wal, ok := nd.SubWallet[msg.CoinType]
 	if !ok {
-		log.Printf("QChanDescHandler err no wallet for type %d", msg.CoinType)
+		log.Errorf("QChanDescHandler err no wallet for type %d", msg.CoinType)
+		return
+	}
+
+	err := nd.pluginHook(HookChannelAccept, ChannelAcceptHook{
+		Peer: msg.Peer(), CoinType: msg.CoinType, Capacity: msg.Capacity,
+		InitPayment: msg.InitPayment, OutPoint: msg.Outpoint.String()})
+	if err != nil {
+		log.Infof("not taking channel %s from peer %d: %s\n",
+			msg.Outpoint.String(), msg.Peer(), err.Error())
 		return
 	}
 
@@ -370,7 +406,7 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) {
 
 	cIdx, err := nd.NextChannelIdx()
 	if err != nil {
-		log.Printf("QChanDescHandler err %s", err.Error())
+		log.Errorf("QChanDescHandler err %s", err.Error())
 		return
 	}
 
@@ -399,17 +435,17 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) {
 	//	qc, err := nd.SaveFundTx(
 	//		op, amt, peerArr, theirPub, theirRefundPub, theirHAKDbase)
 	//	if err != nil {
-	//		log.Printf("QChanDescHandler SaveFundTx err %s", err.Error())
+	//		log.Errorf("QChanDescHandler SaveFundTx err %s", err.Error())
 	//		return
 	//	}
-	log.Printf("got multisig output %s amt %d\n", op.String(), amt)
+	log.Debugf("got multisig output %s amt %d\n", op.String(), amt)
 
 	// create initial state
 	qc.State = new(StatCom)
 	// similar to SIGREV in pushpull
 
-	// TODO assumes both parties use same fee
-	qc.State.Fee = wal.Fee() * 1000
+	// same fixed fee the funder used
+	qc.State.Fee = chanFee(wal)
 	qc.State.MyAmt = msg.InitPayment
 
 	qc.State.Data = msg.Data
@@ -423,38 +459,38 @@ func (nd *LitNode) QChanDescHandler(msg lnutil.ChanDescMsg) {
 	// save new channel to db
 	err = nd.SaveQChan(qc)
 	if err != nil {
-		log.Printf("QChanDescHandler err %s", err.Error())
+		log.Errorf("QChanDescHandler err %s", err.Error())
 		return
 	}
 
 	// load ... the thing I just saved.  why?
 	qc, err = nd.GetQchan(opArr)
 	if err != nil {
-		log.Printf("QChanDescHandler GetQchan err %s", err.Error())
+		log.Errorf("QChanDescHandler GetQchan err %s", err.Error())
 		return
 	}
 
 	// when funding a channel, give them the first *2* elkpoints.
 	theirElkPointZero, err := qc.ElkPoint(false, 0)
 	if err != nil {
-		log.Printf("QChanDescHandler err %s", err.Error())
+		log.Errorf("QChanDescHandler err %s", err.Error())
 		return
 	}
 	theirElkPointOne, err := qc.ElkPoint(false, 1)
 	if err != nil {
-		log.Printf("QChanDescHandler err %s", err.Error())
+		log.Errorf("QChanDescHandler err %s", err.Error())
 		return
 	}
 
 	theirElkPointTwo, err := qc.N2ElkPointForThem()
 	if err != nil {
-		log.Printf("QChanDescHandler err %s", err.Error())
+		log.Errorf("QChanDescHandler err %s", err.Error())
 		return
 	}
 
 	sig, err := nd.SignState(qc)
 	if err != nil {
-		log.Printf("QChanDescHandler SignState err %s", err.Error())
+		log.Errorf("QChanDescHandler SignState err %s", err.Error())
 		return
 	}
 
@@ -479,13 +515,13 @@ func (nd *LitNode) QChanAckHandler(msg lnutil.ChanAckMsg, peer *RemotePeer) {
 	// load channel to save their refund address
 	qc, err := nd.GetQchan(opArr)
 	if err != nil {
-		log.Printf("QChanAckHandler GetQchan err %s", err.Error())
+		log.Errorf("QChanAckHandler GetQchan err %s", err.Error())
 		return
 	}
 
 	//	err = qc.IngestElkrem(revElk)
 	//	if err != nil { // this can't happen because it's the first elk... remove?
-	//		log.Printf("QChanAckHandler IngestElkrem err %s", err.Error())
+	//		log.Errorf("QChanAckHandler IngestElkrem err %s", err.Error())
 	//		return
 	//	}
 	qc.State.ElkPoint = msg.ElkZero
@@ -494,14 +530,14 @@ func (nd *LitNode) QChanAckHandler(msg lnutil.ChanAckMsg, peer *RemotePeer) {
 
 	err = qc.VerifySig(sig)
 	if err != nil {
-		log.Printf("QChanAckHandler VerifySig err %s", err.Error())
+		log.Errorf("QChanAckHandler VerifySig err %s", err.Error())
 		return
 	}
 
 	// verify worked; Save state 1 to DB
 	err = nd.SaveQchanState(qc)
 	if err != nil {
-		log.Printf("QChanAckHandler SaveQchanState err %s", err.Error())
+		log.Errorf("QChanAckHandler SaveQchanState err %s", err.Error())
 		return
 	}
 
@@ -510,20 +546,20 @@ func (nd *LitNode) QChanAckHandler(msg lnutil.ChanAckMsg, peer *RemotePeer) {
 	// sign their com tx to send
 	sig, err = nd.SignState(qc)
 	if err != nil {
-		log.Printf("QChanAckHandler SignState err %s", err.Error())
+		log.Errorf("QChanAckHandler SignState err %s", err.Error())
 		return
 	}
 
 	// OK to fund.
 	err = nd.SubWallet[qc.Coin()].ReallySend(&qc.Op.Hash)
 	if err != nil {
-		log.Printf("QChanAckHandler ReallySend err %s", err.Error())
+		log.Errorf("QChanAckHandler ReallySend err %s", err.Error())
 		return
 	}
 
 	err = nd.SubWallet[qc.Coin()].WatchThis(qc.Op)
 	if err != nil {
-		log.Printf("QChanAckHandler WatchThis err %s", err.Error())
+		log.Errorf("QChanAckHandler WatchThis err %s", err.Error())
 		return
 	}
 
@@ -543,8 +579,10 @@ func (nd *LitNode) QChanAckHandler(msg lnutil.ChanAckMsg, peer *RemotePeer) {
 	nd.InProg.Clear()
 	nd.InProg.mtx.Unlock()
 
-	peer.QCs[qc.Idx()] = qc
-	peer.OpMap[opArr] = qc.Idx()
+	peer.addQchan(qc)
+
+	// register with the default tower if we're auto-watching
+	go nd.autoWatch(qc.Idx())
 
 	// sig proof should be sent later once there are confirmations.
 	// it'll have an spv proof of the fund tx.
@@ -567,33 +605,33 @@ func (nd *LitNode) SigProofHandler(msg lnutil.SigProofMsg, peer *RemotePeer) {
 
 	qc, err := nd.GetQchan(opArr)
 	if err != nil {
-		log.Printf("SigProofHandler err %s", err.Error())
+		log.Errorf("SigProofHandler err %s", err.Error())
 		return
 	}
 
 	wal, ok := nd.SubWallet[qc.Coin()]
 	if !ok {
-		log.Printf("Not connected to coin type %d\n", qc.Coin())
+		log.Warnf("Not connected to coin type %d\n", qc.Coin())
 		return
 	}
 
 	err = qc.VerifySig(msg.Signature)
 	if err != nil {
-		log.Printf("SigProofHandler err %s", err.Error())
+		log.Errorf("SigProofHandler err %s", err.Error())
 		return
 	}
 
 	// sig OK, save
 	err = nd.SaveQchanState(qc)
 	if err != nil {
-		log.Printf("SigProofHandler err %s", err.Error())
+		log.Errorf("SigProofHandler err %s", err.Error())
 		return
 	}
 
 	err = wal.WatchThis(op)
 
 	if err != nil {
-		log.Printf("SigProofHandler err %s", err.Error())
+		log.Errorf("SigProofHandler err %s", err.Error())
 		return
 	}
 
@@ -604,8 +642,10 @@ func (nd *LitNode) SigProofHandler(msg lnutil.SigProofMsg, peer *RemotePeer) {
 	nullTxo.KeyGen.Step[2] = UseChannelWatchRefund
 	wal.ExportUtxo(nullTxo)
 
-	peer.QCs[qc.Idx()] = qc
-	peer.OpMap[opArr] = qc.Idx()
+	peer.addQchan(qc)
+
+	// register with the default tower if we're auto-watching
+	go nd.autoWatch(qc.Idx())
 
 	// sig OK; in terms of UI here's where you can say "payment received"
 	// "channel online" etc
@@ -0,0 +1,184 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Height hints say where on the chain each channel has been looked after.
+When a channel's funding tx confirms, that height is saved, and each block
+the wallet gets after that moves the channel's scanned height up.  If lit
+was down, or the wallet got ahead of the channels some other way, the wallet
+starts syncing from the lowest scanned height of the open channels, so
+nothing spending them gets missed.  The funding height is a floor; nothing
+before it can spend the channel, so there's never any reason to go back
+further, no matter how old the wallet is.
+
+hint bucket, by channel outpoint (36 bytes):
+4	coin type
+4	height the funding tx confirmed at
+4	height the channel's been scanned to
+*/
+
+// HeightHint is how far along the chain a channel has been watched.
+type HeightHint struct {
+	CoinType   uint32
+	FundHeight int32
+	ScanHeight int32
+}
+
+func (h HeightHint) Bytes() []byte {
+	b := make([]byte, 0, 12)
+	b = append(b, lnutil.U32tB(h.CoinType)...)
+	b = append(b, lnutil.I32tB(h.FundHeight)...)
+	return append(b, lnutil.I32tB(h.ScanHeight)...)
+}
+
+func HeightHintFromBytes(b []byte) (HeightHint, error) {
+	var h HeightHint
+	if len(b) != 12 {
+		return h, fmt.Errorf("height hint %d bytes, expect 12", len(b))
+	}
+	h.CoinType = lnutil.BtU32(b[:4])
+	h.FundHeight = lnutil.BtI32(b[4:8])
+	h.ScanHeight = lnutil.BtI32(b[8:12])
+	return h, nil
+}
+
+// ChanHeightHint gives a channel's height hint; a zero hint if there isn't
+// one, like if it hasn't confirmed yet.
+func (nd *LitNode) ChanHeightHint(q *Qchan) (HeightHint, error) {
+	var h HeightHint
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTHints)
+		if bkt == nil {
+			return fmt.Errorf("no height hint bucket")
+		}
+		opArr := lnutil.OutPointToBytes(q.Op)
+		v := bkt.Get(opArr[:])
+		if v == nil {
+			return nil
+		}
+		var err error
+		h, err = HeightHintFromBytes(v)
+		return err
+	})
+	return h, err
+}
+
+func (nd *LitNode) saveHeightHint(q *Qchan, h HeightHint) error {
+	opArr := lnutil.OutPointToBytes(q.Op)
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTHints)
+		if bkt == nil {
+			return fmt.Errorf("no height hint bucket")
+		}
+		return bkt.Put(opArr[:], h.Bytes())
+	})
+}
+
+// saveFundHeight records the height a channel's funding tx confirmed at.
+// It's been scanned up to there too, at least as far as the channel cares.
+func (nd *LitNode) saveFundHeight(q *Qchan, height int32) error {
+	opArr := lnutil.OutPointToBytes(q.Op)
+	opBytes := opArr[:]
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTHints)
+		if bkt == nil {
+			return fmt.Errorf("no height hint bucket")
+		}
+		h := HeightHint{CoinType: q.Coin()}
+		v := bkt.Get(opBytes)
+		if v != nil {
+			var err error
+			h, err = HeightHintFromBytes(v)
+			if err != nil {
+				return err
+			}
+		}
+		h.FundHeight = height
+		if h.ScanHeight < height {
+			h.ScanHeight = height
+		}
+		return bkt.Put(opBytes, h.Bytes())
+	})
+}
+
+// saveScanHeights moves the scanned height of every channel on a coin to a
+// new block's height.  That's down as well as up; after a reorg, the blocks
+// past the new tip haven't really been scanned.  Hints never go below the
+// funding height.
+func (nd *LitNode) saveScanHeights(cointype uint32, height int32) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTHints)
+		if bkt == nil {
+			return fmt.Errorf("no height hint bucket")
+		}
+		puts := make(map[string][]byte)
+		err := bkt.ForEach(func(k, v []byte) error {
+			h, err := HeightHintFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if h.CoinType != cointype || h.ScanHeight == height {
+				return nil
+			}
+			h.ScanHeight = height
+			if h.ScanHeight < h.FundHeight {
+				h.ScanHeight = h.FundHeight
+			}
+			puts[string(k)] = h.Bytes()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range puts {
+			err = bkt.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// chanSyncHeight is the lowest height the open channels on a coin have been
+// scanned to, or 0 if none need watching.  Channels from before there were
+// hints get one here, with their funding height but no scanned height; they
+// were watched along with the wallet, so there's no going back for them.
+func (nd *LitNode) chanSyncHeight(cointype uint32, qcs []*Qchan) (int32, error) {
+	var low int32
+	for _, q := range qcs {
+		if q.Coin() != cointype || q.Height == 0 {
+			continue
+		}
+		// done once the close is confirmed; a close that isn't yet could
+		// still be reorged out
+		if q.CloseData.Closed && q.CloseData.CloseHeight != 0 {
+			continue
+		}
+		h, err := nd.ChanHeightHint(q)
+		if err != nil {
+			return 0, err
+		}
+		if h.FundHeight == 0 {
+			err = nd.saveHeightHint(q, HeightHint{
+				CoinType: cointype, FundHeight: q.Height})
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if low == 0 || h.ScanHeight < low {
+			low = h.ScanHeight
+		}
+	}
+	if low != 0 {
+		log.Debugf("open channels on coin %d scanned to height %d\n", cointype, low)
+	}
+	return low, nil
+}
@@ -0,0 +1,678 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+HTLCs (hash time locked contracts) move an amount out of one side's balance
+into an output of its own, which the other side gets by showing the preimage
+of a hash, or which goes back after a locktime height.  A channel has at most
+one HTLC at a time; that's all swaps need.
+
+Adding, settling and failing an HTLC are state updates like a push, with an
+HTLCSig in place of the DeltaSig:
+
+HTLCSig: the op, the HTLC, and a signature for the state after the op
+SigRev, Rev: as for pushes
+
+The one who starts the op has a negative delta, the other side positive, so
+resends work the same as pushes.  If a push and an HTLC op collide, the push
+goes first and the HTLC op is dropped; if two HTLC ops collide, settles go
+first, then whoever has the smaller pubkey.  Either way the dropped op's
+caller gets an error and can try again.
+
+On chain, whoever has the preimage or is past the locktime can take the
+HTLC output of the current state.  There are no second stage txs, so the
+receiver has to get its claim in before the locktime; adds need a locktime at
+least the channel delay plus htlcMinBlocks away.  If they broadcast a revoked
+state, its HTLC output gets taken along with the main output, with the
+revocation key.  States only have the HTLC in them now, so every HTLC the
+channel's had is kept under KEYHTLCs, and tried against the bad tx.
+*/
+
+// HTLC is a hash time locked amount in a channel.
+type HTLC struct {
+	Amt      int64
+	RHash    [32]byte // sha256 of R
+	R        [32]byte // the preimage, once we know it
+	Locktime uint32   // block height from which the offerer can take it back
+	Incoming bool     // they offered it to us
+}
+
+// blocks an HTLC's receiver gets, past the channel delay, to get a claim
+// confirmed before the offerer can time out
+const htlcMinBlocks = 6
+
+// how often to look for HTLCs we offered that have timed out
+const htlcExpiryInterval = 5 * time.Minute
+
+// oldHTLCSize is the bytes of an HTLC kept for revoked states: amount,
+// hash, locktime and incoming.  The preimage isn't needed.
+const oldHTLCSize = 8 + 32 + 4 + 1
+
+// oldBytes serializes an HTLC to keep for revoked states.
+func (h *HTLC) oldBytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(lnutil.I64tB(h.Amt))
+	buf.Write(h.RHash[:])
+	buf.Write(lnutil.U32tB(h.Locktime))
+	if h.Incoming {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// oldHTLCsFromBytes gives the HTLCs kept for revoked states.
+func oldHTLCsFromBytes(b []byte) ([]HTLC, error) {
+	if len(b)%oldHTLCSize != 0 {
+		return nil, fmt.Errorf("old HTLCs %d bytes, not a multiple of %d",
+			len(b), oldHTLCSize)
+	}
+	var hs []HTLC
+	for ; len(b) > 0; b = b[oldHTLCSize:] {
+		var h HTLC
+		h.Amt = lnutil.BtI64(b[:8])
+		copy(h.RHash[:], b[8:40])
+		h.Locktime = lnutil.BtU32(b[40:44])
+		h.Incoming = b[44] == 1
+		hs = append(hs, h)
+	}
+	return hs, nil
+}
+
+// putOldHTLC adds the HTLC in, or being added to, a channel's state to the
+// ones kept for revoked states, if it isn't there yet.
+func putOldHTLC(qcBucket kvdb.Bucket, q *Qchan) error {
+	s := q.State
+	if !s.HasHTLC && s.HTLCOp != lnutil.HTLCOpAdd {
+		return nil
+	}
+	hb := s.HTLC.oldBytes()
+	for _, h := range q.OldHTLCs {
+		if bytes.Equal(h.oldBytes(), hb) {
+			return nil
+		}
+	}
+	q.OldHTLCs = append(q.OldHTLCs, HTLC{Amt: s.HTLC.Amt,
+		RHash: s.HTLC.RHash, Locktime: s.HTLC.Locktime, Incoming: s.HTLC.Incoming})
+	return qcBucket.Put(KEYHTLCs, append(qcBucket.Get(KEYHTLCs), hb...))
+}
+
+// revokedHTLCTxos makes portxos for HTLC outputs of a revoked state they
+// broadcast, for the revocation key to take.  revPub and timePub are that
+// state's, elk its elkrem hash.  Like the main output, PrivKey is the elk
+// scalar, for the caller to combine with the HAKD base.
+func (q *Qchan) revokedHTLCTxos(tx *wire.MsgTx,
+	revPub, timePub [33]byte, elk *chainhash.Hash) []portxo.PorTxo {
+
+	var txos []portxo.PorTxo
+	txid := tx.TxHash()
+	for _, h := range q.OldHTLCs {
+		// their tx, so offered if it's incoming to us
+		script := lnutil.HTLCScript(revPub, timePub, q.MyRefundPub,
+			h.RHash, h.Locktime, q.Delay, h.Incoming)
+		i := q.findSHOutput(tx, script, uint32(len(tx.TxOut)))
+		if int(i) == len(tx.TxOut) {
+			continue
+		}
+		var txo portxo.PorTxo
+		txo.KeyGen = q.KeyGen
+		txo.KeyGen.Step[2] = UseChannelHAKDBase
+		txo.Op = *wire.NewOutPoint(&txid, i)
+		txo.Height = q.CloseData.CloseHeight
+		txo.PrivKey = lnutil.ElkScalar(elk)
+		txo.PkScript = script
+		txo.Value = tx.TxOut[i].Value
+		txo.Mode = portxo.TxoP2WSHComp
+		txo.Seq = 1                        // 1 means grab immediately
+		txo.PreSigStack = [][]byte{{0x01}} // revocation path
+		txos = append(txos, txo)
+	}
+	return txos
+}
+
+// HasR says if we know the HTLC's preimage.
+func (h *HTLC) HasR() bool {
+	return sha256.Sum256(h.R[:]) == h.RHash
+}
+
+// htlcAmtChange is how much the pending HTLC op moves my allocation.  Adding
+// takes the amount out of the offerer; settling gives it to the receiver, and
+// failing gives it back to the offerer.
+func (s *StatCom) htlcAmtChange() int64 {
+	switch s.HTLCOp {
+	case lnutil.HTLCOpAdd:
+		if !s.HTLC.Incoming {
+			return -s.HTLC.Amt
+		}
+	case lnutil.HTLCOpSettle:
+		if s.HTLC.Incoming {
+			return s.HTLC.Amt
+		}
+	case lnutil.HTLCOpFail:
+		if !s.HTLC.Incoming {
+			return s.HTLC.Amt
+		}
+	}
+	return 0
+}
+
+// stepAmt moves my allocation, and the HTLC if there's an op, on to the
+// next state.  delta is the push amount, for when there's no HTLC op.
+func (s *StatCom) stepAmt(delta int32) {
+	if s.HTLCOp == 0 {
+		s.MyAmt += int64(delta)
+		return
+	}
+	s.MyAmt += s.htlcAmtChange()
+	s.HasHTLC = s.HTLCOp == lnutil.HTLCOpAdd
+}
+
+// prevAmt gives my allocation, and whether there was an HTLC, in the state
+// before a stepAmt.
+func (s *StatCom) prevAmt(delta int32) (int64, bool) {
+	if s.HTLCOp == 0 {
+		return s.MyAmt - int64(delta), s.HasHTLC
+	}
+	return s.MyAmt - s.htlcAmtChange(), s.HTLCOp != lnutil.HTLCOpAdd
+}
+
+// abortHTLCOp drops an HTLC op we sent which lost a collision.  Only the
+// op and delta were saved, so it's as if it never started.
+func (s *StatCom) abortHTLCOp() {
+	if s.HTLCOp == lnutil.HTLCOpAdd {
+		s.HTLC = HTLC{}
+	}
+	s.HTLCOp = 0
+	s.Delta = 0
+}
+
+// htlcOpFirst says if our pending HTLC op goes before theirs when both
+// sides send one at once.  Settles go first, so a known preimage never
+// loses to a fail; otherwise the smaller pubkey goes first.
+func (q *Qchan) htlcOpFirst(theirOp uint8) bool {
+	if q.State.HTLCOp == lnutil.HTLCOpSettle {
+		return true
+	}
+	if theirOp == lnutil.HTLCOpSettle {
+		return false
+	}
+	return q.ImFirst()
+}
+
+// checkHTLCOp checks that an HTLC op can happen on the channel now.
+// fromThem is for ops they sent.  For adds, h is the new HTLC; for
+// settles, h.R is the preimage.
+func (q *Qchan) checkHTLCOp(op uint8, h HTLC, fromThem bool, height int32) error {
+	s := q.State
+	switch op {
+	case lnutil.HTLCOpAdd:
+		if s.HasHTLC {
+			return fmt.Errorf("channel %d already has an HTLC", q.Idx())
+		}
+		if h.Amt < consts.MinOutput || h.Amt >= 1<<30 {
+			return fmt.Errorf("HTLC amount %d out of range", h.Amt)
+		}
+		// the offerer's output stays above the minimum
+		offererAmt := s.MyAmt
+		if fromThem {
+			offererAmt = q.TheirAmt()
+		}
+		if offererAmt-h.Amt-s.Fee < consts.MinOutput {
+			return fmt.Errorf("HTLC of %s leaves the offerer %s after %s fee",
+				lnutil.SatoshiColor(h.Amt),
+				lnutil.SatoshiColor(offererAmt-h.Amt), lnutil.SatoshiColor(s.Fee))
+		}
+		// and the receiver has time to claim it on chain
+		minLocktime := int64(height) + int64(q.Delay) + htlcMinBlocks
+		if int64(h.Locktime) < minLocktime {
+			return fmt.Errorf("HTLC locktime %d too soon; need at least %d",
+				h.Locktime, minLocktime)
+		}
+
+	case lnutil.HTLCOpSettle:
+		if !s.HasHTLC {
+			return fmt.Errorf("channel %d has no HTLC to settle", q.Idx())
+		}
+		if s.HTLC.Incoming == fromThem {
+			return fmt.Errorf("only the receiver can settle an HTLC")
+		}
+		if sha256.Sum256(h.R[:]) != s.HTLC.RHash {
+			return fmt.Errorf("preimage %x doesn't match hash %x",
+				h.R, s.HTLC.RHash)
+		}
+
+	case lnutil.HTLCOpFail:
+		if !s.HasHTLC {
+			return fmt.Errorf("channel %d has no HTLC to fail", q.Idx())
+		}
+		// the receiver can give it back any time; the offerer can only
+		// take it back after the locktime
+		offerer := s.HTLC.Incoming == fromThem
+		if offerer && height < int32(s.HTLC.Locktime) {
+			return fmt.Errorf("HTLC can't fail until height %d; now %d",
+				s.HTLC.Locktime, height)
+		}
+		// we'll settle instead
+		if fromThem && offerer && s.HTLC.HasR() {
+			return fmt.Errorf("we know the preimage, so won't fail the HTLC")
+		}
+
+	default:
+		return fmt.Errorf("unknown HTLC op %d", op)
+	}
+	return nil
+}
+
+// peerHasHTLCs says if our connection with the peer does HTLCs.
+func (nd *LitNode) peerHasHTLCs(peerIdx uint32) bool {
+	peer, ok := nd.GetPeer(peerIdx)
+	return ok && peer.HasFeature(lnutil.FeatureHTLCOptional)
+}
+
+// HTLCChannel adds, settles or fails the channel's HTLC, the way
+// PushChannel pushes.  For adds, h is the HTLC we offer; for settles, h.R
+// is the preimage.  It returns once the op is in both sides' states, or
+// with an error if it lost a collision and has to be tried again.
+func (nd *LitNode) HTLCChannel(qc *Qchan, op uint8, h HTLC) error {
+	if !nd.peerHasHTLCs(qc.Peer()) {
+		return fmt.Errorf("peer %d doesn't do HTLCs", qc.Peer())
+	}
+
+	// see if channel is busy
+	// lock this channel
+	qc.acquire()
+	// ClearToSend is now empty
+
+	// reload from disk here, after unlock
+	err := nd.ReloadQchanState(qc)
+	if err != nil {
+		// don't clear to send here; something is wrong with the channel
+		qc.ChanMtx.Unlock()
+		return err
+	}
+
+	wal, ok := nd.SubWallet[qc.Coin()]
+	if !ok {
+		qc.ClearToSend <- true
+		qc.ChanMtx.Unlock()
+		return fmt.Errorf("Not connected to coin type %d\n", qc.Coin())
+	}
+
+	if !wal.Params().TestCoin && qc.Height < 100 {
+		qc.ClearToSend <- true
+		qc.ChanMtx.Unlock()
+		return fmt.Errorf(
+			"height %d; must wait min 1 conf for non-test coin\n", qc.Height)
+	}
+
+	// if we got here, but channel is not in rest state, try to fix it.
+	if qc.State.Delta != 0 {
+		err = nd.ReSendMsg(qc)
+		if err != nil {
+			qc.ClearToSend <- true
+			qc.ChanMtx.Unlock()
+			return err
+		}
+		qc.ChanMtx.Unlock()
+		return fmt.Errorf("Didn't send.  Recovered though, so try again!")
+	}
+
+	err = qc.checkHTLCOp(op, h, false, wal.CurrentHeight())
+	if err != nil {
+		qc.ClearToSend <- true
+		qc.ChanMtx.Unlock()
+		return err
+	}
+
+	switch op {
+	case lnutil.HTLCOpAdd:
+		h.R = [32]byte{}
+		h.Incoming = false
+		qc.State.HTLC = h
+	case lnutil.HTLCOpSettle:
+		qc.State.HTLC.R = h.R
+	}
+	qc.State.HTLCOp = op
+	qc.State.Delta = -int32(qc.State.HTLC.Amt)
+	qc.lastHTLCOp = 0
+
+	// save to db with ONLY the op and delta changed
+	err = nd.SaveQchanState(qc)
+	if err != nil {
+		// don't clear to send here; something is wrong with the channel
+		qc.ChanMtx.Unlock()
+		return err
+	}
+
+	err = nd.SendHTLCSig(qc)
+	if err != nil {
+		qc.ChanMtx.Unlock()
+		// don't clear; something is wrong with the network
+		return err
+	}
+
+	// block until clear to send is full again
+	qc.ChanMtx.Unlock()
+
+	qc.acquire()
+
+	// it may have lost a collision, in which case something else happened
+	if qc.lastHTLCOp != op {
+		err = fmt.Errorf("HTLC op %d on channel %d collided; try again",
+			op, qc.Idx())
+	}
+
+	// since we cleared with that statement, fill it again before returning
+	qc.ClearToSend <- true
+	qc.ChanMtx.Unlock()
+
+	return err
+}
+
+// SendHTLCSig sends the pending HTLC op, and a sig for the state after it.
+func (nd *LitNode) SendHTLCSig(q *Qchan) error {
+	// increment state number, update balance and HTLC, go to next elkpoint
+	q.State.StateIdx++
+	q.State.stepAmt(q.State.Delta)
+	q.State.ElkPoint = q.State.NextElkPoint
+	q.State.NextElkPoint = q.State.N2ElkPoint
+	// N2Elk is now invalid
+
+	// make the signature to send over
+	sig, err := nd.SignState(q)
+	if err != nil {
+		return err
+	}
+
+	// only settles give away the preimage
+	h := q.State.HTLC
+	var r [32]byte
+	if q.State.HTLCOp == lnutil.HTLCOpSettle {
+		r = h.R
+	}
+
+	outMsg := lnutil.NewHTLCSigMsg(q.Peer(), q.Op, q.State.HTLCOp,
+		h.Amt, h.RHash, h.Locktime, r, sig)
+
+	log.Debugf("Sending HTLCSig: %v", outMsg)
+
+	nd.OmniOut <- outMsg
+
+	return nil
+}
+
+// HTLCSigHandler takes in an HTLCSig and, if the op checks out, responds
+// with a SigRev.  Leaves the channel expecting a Rev, like a DeltaSig does.
+func (nd *LitNode) HTLCSigHandler(msg lnutil.HTLCSigMsg, qc *Qchan) error {
+	log.Debugf("Got HTLCSig: %v", msg)
+
+	var collision bool
+
+	// we should be clear to send when we get an HTLCSig
+	select {
+	case <-qc.ClearToSend:
+	// keep going, normal
+	default:
+		// collision
+		collision = true
+	}
+
+	// load state from disk
+	err := nd.ReloadQchanState(qc)
+	if err != nil {
+		return fmt.Errorf("HTLCSigHandler ReloadQchan err %s", err.Error())
+	}
+
+	if qc.CloseData.Closed {
+		return fmt.Errorf("HTLCSigHandler err: %d, %d is closed.",
+			qc.Peer(), qc.Idx())
+	}
+
+	if qc.State.Delta > 0 {
+		log.Errorf(
+			"HTLCSigHandler err: chan %d delta %d, expect rev, send empty rev",
+			qc.Idx(), qc.State.Delta)
+
+		return nd.SendREV(qc)
+	}
+
+	if collision && qc.State.Delta < 0 {
+		// our push goes first; they drop this when they get it
+		if qc.State.HTLCOp == 0 {
+			log.Warnf("HTLCSig collided with our push; ignoring it\n")
+			return nil
+		}
+		if qc.htlcOpFirst(msg.Op) {
+			log.Warnf("HTLCSig collided with our HTLC op; ours goes first\n")
+			return nil
+		}
+		log.Warnf("HTLCSig collided with our HTLC op %d; theirs goes first\n",
+			qc.State.HTLCOp)
+		qc.State.abortHTLCOp()
+		collision = false
+	}
+
+	wal, ok := nd.SubWallet[qc.Coin()]
+	if !ok {
+		if !collision {
+			qc.ClearToSend <- true
+		}
+		return fmt.Errorf("Not connected to coin type %d\n", qc.Coin())
+	}
+
+	h := HTLC{
+		Amt:      msg.Amt,
+		RHash:    msg.RHash,
+		R:        msg.R,
+		Locktime: msg.Locktime,
+		Incoming: true,
+	}
+	if msg.Op != lnutil.HTLCOpAdd && msg.RHash != qc.State.HTLC.RHash {
+		err = fmt.Errorf("HTLC op %d for hash %x, but ours is %x",
+			msg.Op, msg.RHash, qc.State.HTLC.RHash)
+	} else {
+		err = qc.checkHTLCOp(msg.Op, h, true, wal.CurrentHeight())
+	}
+	if err != nil {
+		if !collision {
+			qc.ClearToSend <- true
+		}
+		return fmt.Errorf("HTLCSigHandler err %s", err.Error())
+	}
+
+	switch msg.Op {
+	case lnutil.HTLCOpAdd:
+		qc.State.HTLC = h
+	case lnutil.HTLCOpSettle:
+		qc.State.HTLC.R = msg.R
+	}
+	qc.State.HTLCOp = msg.Op
+	qc.State.Delta = int32(qc.State.HTLC.Amt)
+
+	// update to the next state to verify
+	qc.State.StateIdx++
+	qc.State.stepAmt(qc.State.Delta)
+
+	// verify sig for the next state. only save if this works
+	err = qc.VerifySig(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("HTLCSigHandler err %s", err.Error())
+	}
+
+	// save channel with new state, new sig, and positive delta set
+	err = nd.SaveQchanState(qc)
+	if err != nil {
+		return fmt.Errorf("HTLCSigHandler SaveQchanState err %s", err.Error())
+	}
+
+	err = nd.SendSigRev(qc)
+	if err != nil {
+		return fmt.Errorf("HTLCSigHandler SendSigRev err %s", err.Error())
+	}
+	return nil
+}
+
+// htlcCommitted is called, with ChanMtx held, once an HTLC op is in both
+// sides' states.  ours is for ops we sent.
+func (nd *LitNode) htlcCommitted(qc *Qchan, op uint8, ours bool) {
+	if int(op) < len(nd.counters.htlcs) {
+		atomic.AddInt64(&nd.counters.htlcs[op], 1)
+	}
+	if ours {
+		qc.lastHTLCOp = op
+	}
+	go nd.swapStep(qc.Peer(), qc.Op, op, qc.State.HTLC)
+}
+
+// htlcCloseTxo makes a portxo for the HTLC output of a close tx with the
+// current state, if we can take it: after the locktime if we offered it,
+// or with the preimage if they did.  theirTx is for when they broadcast.
+// Returns nil if there's nothing for us.
+func (q *Qchan) htlcCloseTxo(tx *wire.MsgTx, theirTx bool) (*portxo.PorTxo, error) {
+	s := q.State
+	h := s.HTLC
+	if !s.HasHTLC || (h.Incoming && !h.HasR()) {
+		return nil, nil
+	}
+
+	var txo portxo.PorTxo
+	var script []byte
+	txo.KeyGen = q.KeyGen
+	if theirTx {
+		// their tx, so their keys but my refund key to take it
+		revPub := lnutil.CombinePubs(q.MyHAKDBase, s.ElkPoint)
+		timePub := lnutil.AddPubsEZ(q.TheirHAKDBase, s.ElkPoint)
+		script = lnutil.HTLCScript(revPub, timePub, q.MyRefundPub,
+			h.RHash, h.Locktime, q.Delay, h.Incoming)
+
+		txo.KeyGen.Step[2] = UseChannelRefund
+		if !h.Incoming {
+			// no delay, but not 1 (justice) so the wallet waits for the
+			// locktime below
+			txo.Seq = 2
+		}
+	} else {
+		// my tx; I take it with the timeout key, after the delay
+		elkPoint, err := q.ElkPoint(false, s.StateIdx)
+		if err != nil {
+			return nil, err
+		}
+		revPub := lnutil.CombinePubs(q.TheirHAKDBase, elkPoint)
+		timePub := lnutil.AddPubsEZ(q.MyHAKDBase, elkPoint)
+		script = lnutil.HTLCScript(revPub, timePub, q.TheirRefundPub,
+			h.RHash, h.Locktime, q.Delay, !h.Incoming)
+
+		txo.KeyGen.Step[2] = UseChannelHAKDBase
+		txo.PrivKey = chainhash.DoubleHashH(
+			append(elkPoint[:], q.MyHAKDBase[:]...))
+		txo.Seq = uint32(q.Delay)
+	}
+
+	// preimage or timeout path
+	if h.Incoming {
+		txo.PreSigStack = [][]byte{h.R[:], {0x01}, nil}
+	} else {
+		txo.PreSigStack = [][]byte{nil, nil}
+	}
+
+	pkScript := lnutil.P2WSHify(script)
+	txid := tx.TxHash()
+	found := false
+	for i, out := range tx.TxOut {
+		if bytes.Equal(out.PkScript, pkScript) {
+			txo.Op = *wire.NewOutPoint(&txid, uint32(i))
+			txo.Value = out.Value
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no HTLC output in close tx %s", txid.String())
+	}
+	txo.Mode = portxo.TxoP2WSHComp
+	txo.PkScript = script
+	txo.Height = q.CloseData.CloseHeight
+
+	// the wallet only knows relative locks, so for the timeout make it
+	// look like it confirmed late enough to be past the locktime too
+	if !h.Incoming && txo.Height != 0 &&
+		txo.Height+int32(txo.Seq) < int32(h.Locktime) {
+		txo.Height = int32(h.Locktime) - int32(txo.Seq)
+	}
+	return &txo, nil
+}
+
+// HTLCExpiryLoop fails HTLCs we offered once they time out, so the amount
+// comes back without closing the channel, and refunds submarine swaps'
+// on-chain HTLCs.  Doesn't return.
+func (nd *LitNode) HTLCExpiryLoop() {
+	ticker := time.NewTicker(htlcExpiryInterval)
+	for range ticker.C {
+		err := nd.FailExpiredHTLCs()
+		if err != nil {
+			log.Errorf("FailExpiredHTLCs: %s\n", err.Error())
+		}
+		err = nd.RefundExpiredSubSwaps()
+		if err != nil {
+			log.Errorf("RefundExpiredSubSwaps: %s\n", err.Error())
+		}
+	}
+}
+
+// FailExpiredHTLCs fails the HTLCs we offered that are past their locktime,
+// on channels with connected peers.
+func (nd *LitNode) FailExpiredHTLCs() error {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return err
+	}
+	for _, dbqc := range qcs {
+		if dbqc.CloseData.Closed || !dbqc.State.HasHTLC ||
+			dbqc.State.HTLC.Incoming {
+			continue
+		}
+		wal, ok := nd.SubWallet[dbqc.Coin()]
+		if !ok || wal.CurrentHeight() < int32(dbqc.State.HTLC.Locktime) {
+			continue
+		}
+		qc, err := nd.liveQchan(dbqc.Peer(), dbqc.Op)
+		if err != nil {
+			continue
+		}
+		log.Warnf("HTLC %x on channel %d timed out; failing it\n",
+			dbqc.State.HTLC.RHash[:4], qc.Idx())
+		err = nd.HTLCChannel(qc, lnutil.HTLCOpFail, dbqc.State.HTLC)
+		if err != nil {
+			log.Errorf("fail HTLC on channel %d: %s\n", qc.Idx(), err.Error())
+		}
+	}
+	return nil
+}
+
+// liveQchan gets a channel from its peer's connection, so ops on it go
+// through the same ClearToSend as the peer's messages.
+func (nd *LitNode) liveQchan(peerIdx uint32, op wire.OutPoint) (*Qchan, error) {
+	peer, ok := nd.GetPeer(peerIdx)
+	if !ok {
+		return nil, fmt.Errorf("not connected to peer %d", peerIdx)
+	}
+	qc, ok := peer.qchanByOp(lnutil.OutPointToBytes(op))
+	if !ok {
+		return nil, fmt.Errorf("peer %d has no channel %s", peerIdx, op.String())
+	}
+	return qc, nil
+}
@@ -2,14 +2,13 @@ package qln
 
 import (
 	"fmt"
-	"log"
 	"path/filepath"
 
 	"github.com/adiabat/btcutil"
 	"github.com/adiabat/btcutil/hdkeychain"
-	"github.com/boltdb/bolt"
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/dlc"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/wallit"
@@ -28,6 +27,10 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string, proxyURL stri
 	if err != nil {
 		return nil, err
 	}
+	err = nd.requeueChats()
+	if err != nil {
+		return nil, err
+	}
 
 	// Maybe make a new parameter set for "LN".. meh
 	// TODO change this to a non-coin
@@ -48,6 +51,13 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string, proxyURL stri
 		return nil, err
 	}
 
+	nd.auditLog, err = openAudit(filepath.Join(nd.LitFolder, "audit.log"),
+		nd.auditKey(), nd.auditHead, nd.saveAuditHead)
+	if err != nil {
+		return nil, err
+	}
+	nd.audit(AuditEvent{Type: AuditStart})
+
 	nd.TrackerURL = trackerURL
 
 	nd.ProxyURL = proxyURL
@@ -71,6 +81,16 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string, proxyURL stri
 	nd.InProg.done = make(chan uint32, 1)
 
 	nd.RemoteCons = make(map[uint32]*RemotePeer)
+	nd.reconnecting = make(map[uint32]bool)
+	nd.listeners = make(map[string]*litListener)
+	nd.towerTerms = make(map[uint32]lnutil.WatchTermsMsg)
+	nd.towerTermsCh = make(map[uint32]chan bool)
+
+	nd.MaxInbound = DefaultMaxInbound
+	nd.WatchRetain = DefaultWatchRetain
+	nd.ChatExpiry = DefaultChatExpiry
+	nd.inLimiter = newInboundLimiter(DefaultInboundRate)
+	nd.Features = lnutil.DefaultFeatures
 
 	nd.SubWallet = make(map[uint32]UWallet)
 
@@ -84,9 +104,11 @@ func NewLitNode(privKey *[32]byte, path string, trackerURL string, proxyURL stri
 }
 
 // LinkBaseWallet activates a wallet and hooks it into the litnode.
+// birthday is when privKey was made, in unix time, so the wallet can skip
+// blocks from before then; 0 to scan them all.
 func (nd *LitNode) LinkBaseWallet(
-	privKey *[32]byte, birthHeight int32, resync bool, tower bool,
-	host string, param *coinparam.Params) error {
+	privKey *[32]byte, birthHeight int32, birthday int64, resync bool,
+	tower bool, host string, param *coinparam.Params) error {
 
 	rootpriv, err := hdkeychain.NewMaster(privKey[:], param)
 	if err != nil {
@@ -111,16 +133,22 @@ func (nd *LitNode) LinkBaseWallet(
 		nd.MultiWallet = true
 	}
 
-	// if there aren't, Multiwallet will still be false; set new wallit to
-	// be the first & default
-	nd.SubWallet[WallitIdx] = wallit.NewWallit(
-		rootpriv, birthHeight, resync, host, nd.LitFolder, param)
-
-	// re-register channel addresses
+	// open channels might not have been scanned as far as the wallet has
 	qChans, err := nd.GetAllQchans()
 	if err != nil {
 		return err
 	}
+	chanHeight, err := nd.chanSyncHeight(WallitIdx, qChans)
+	if err != nil {
+		return err
+	}
+
+	// if there aren't, Multiwallet will still be false; set new wallit to
+	// be the first & default
+	nd.SubWallet[WallitIdx] = wallit.NewWallit(rootpriv, birthHeight,
+		birthday, resync, chanHeight, host, nd.LitFolder, param)
+
+	// re-register channel addresses
 
 	for _, qChan := range qChans {
 		var pkh [20]byte
@@ -128,12 +156,13 @@ func (nd *LitNode) LinkBaseWallet(
 		copy(pkh[:], pkhSlice)
 		nd.SubWallet[WallitIdx].ExportHook().RegisterAddress(pkh)
 
-		log.Printf("Registering outpoint %v", qChan.PorTxo.Op)
+		log.Debugf("Registering outpoint %v", qChan.PorTxo.Op)
 
 		nd.SubWallet[WallitIdx].WatchThis(qChan.PorTxo.Op)
 	}
 
 	go nd.OPEventHandler(nd.SubWallet[WallitIdx].LetMeKnow())
+	go nd.HeightEventHandler(nd.SubWallet[WallitIdx].LetMeKnowHeight())
 
 	if !nd.MultiWallet {
 		nd.DefaultCoin = param.HDCoinType
@@ -144,7 +173,7 @@ func (nd *LitNode) LinkBaseWallet(
 
 	if tower {
 		err = nd.Tower.HookLink(
-			nd.LitFolder, param, nd.SubWallet[WallitIdx].ExportHook())
+			nd.LitFolder, param, nd.SubWallet[WallitIdx].BroadcastHook())
 		if err != nil {
 			return err
 		}
@@ -157,12 +186,12 @@ func (nd *LitNode) LinkBaseWallet(
 func (nd *LitNode) OpenDB(filename string) error {
 	var err error
 
-	nd.LitDB, err = bolt.Open(filename, 0644, nil)
+	nd.LitDB, err = kvdb.Open(filename, 0644)
 	if err != nil {
 		return err
 	}
 	// create buckets if they're not already there
-	err = nd.LitDB.Update(func(btx *bolt.Tx) error {
+	err = nd.LitDB.Update(func(btx kvdb.Tx) error {
 		_, err := btx.CreateBucketIfNotExists(BKTChannel)
 		if err != nil {
 			return err
@@ -186,6 +215,99 @@ func (nd *LitNode) OpenDB(filename string) error {
 		if err != nil {
 			return err
 		}
+		_, err = btx.CreateBucketIfNotExists(BKTBlobs)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTTwrPaid)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTTowers)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTTwrSeen)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTTwrSync)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTAutoWch)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTHints)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTBans)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTPending)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTContact)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTSwaps)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTSubSwps)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTIntents)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTRemotes)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTCustoms)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTChats)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTChtSeen)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTAliases)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTPayReqs)
+		if err != nil {
+			return err
+		}
+
+		_, err = btx.CreateBucketIfNotExists(BKTAudit)
+		if err != nil {
+			return err
+		}
 
 		return nil
 	})
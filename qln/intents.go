@@ -0,0 +1,181 @@
+package qln
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Finishing a state update takes more than saving the new state: the justice
+sig for the state before it gets built and saved, a tower fee pushed to us
+gets credited in the watchtower's db, and HTLC ops move their swaps along.
+Those are each their own write, some in another db, so a crash part way
+through used to leave the channel at the new state with the rest never
+done.
+
+So the new state is saved along with an intent, in the same transaction,
+saying what's left to do.  Once it's all done the intent is deleted.  On
+startup, any intent that's still there gets done again.  A crash before
+the save leaves nothing: the old state's still in place, and the peer
+sends again.  Doing things again is fine: justice sigs overwrite
+themselves, and the tower only credits a state's fee once.
+
+IntentBucket is k:v
+chanIdx (4) : stateIntent (35 bytes)
+*/
+
+// stateIntent is what's left to do after saving a channel's new state.
+type stateIntent struct {
+	StateIdx uint64 // the new state, saved with the intent
+	// the state to build a justice sig for, and my amount in it
+	JusticeIdx  uint64
+	JusticeAmt  int64
+	JusticeHTLC bool
+	// tower fee pushed to us in the new state, to credit
+	TowerFee int64
+	// HTLC op committed in the new state, if any; Ours if we sent it
+	HTLCOp uint8
+	Ours   bool
+}
+
+// stateIntents are 35 bytes
+// StateIdx 8
+// JusticeIdx 8
+// JusticeAmt 8
+// JusticeHTLC 1
+// TowerFee 8
+// HTLCOp 1
+// Ours 1
+func (si *stateIntent) Bytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, si.StateIdx)
+	binary.Write(&buf, binary.BigEndian, si.JusticeIdx)
+	binary.Write(&buf, binary.BigEndian, si.JusticeAmt)
+	binary.Write(&buf, binary.BigEndian, si.JusticeHTLC)
+	binary.Write(&buf, binary.BigEndian, si.TowerFee)
+	binary.Write(&buf, binary.BigEndian, si.HTLCOp)
+	binary.Write(&buf, binary.BigEndian, si.Ours)
+	return buf.Bytes()
+}
+
+func stateIntentFromBytes(b []byte) (stateIntent, error) {
+	var si stateIntent
+	if len(b) != 35 {
+		return si, fmt.Errorf("stateIntentFromBytes got %d bytes, expect 35", len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	_ = binary.Read(buf, binary.BigEndian, &si.StateIdx)
+	_ = binary.Read(buf, binary.BigEndian, &si.JusticeIdx)
+	_ = binary.Read(buf, binary.BigEndian, &si.JusticeAmt)
+	_ = binary.Read(buf, binary.BigEndian, &si.JusticeHTLC)
+	_ = binary.Read(buf, binary.BigEndian, &si.TowerFee)
+	_ = binary.Read(buf, binary.BigEndian, &si.HTLCOp)
+	_ = binary.Read(buf, binary.BigEndian, &si.Ours)
+	return si, nil
+}
+
+// SaveQchanStateIntent saves a channel's state, and what's left to do
+// after, in one transaction.
+func (nd *LitNode) SaveQchanStateIntent(q *Qchan, si stateIntent) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		err := putQchanState(btx, q)
+		if err != nil {
+			return err
+		}
+		bkt := btx.Bucket(BKTIntents)
+		if bkt == nil {
+			return fmt.Errorf("no intent bucket")
+		}
+		return bkt.Put(lnutil.U32tB(q.Idx()), si.Bytes())
+	})
+}
+
+// finishState does what's left after a state save, then deletes the intent.
+func (nd *LitNode) finishState(qc *Qchan, si stateIntent) {
+	nd.towerPaid(qc, si.TowerFee)
+
+	// go back to the old state to build its justice sig
+	stateIdx, myAmt, hasHTLC := qc.State.StateIdx, qc.State.MyAmt, qc.State.HasHTLC
+	qc.State.StateIdx = si.JusticeIdx
+	qc.State.MyAmt = si.JusticeAmt
+	qc.State.HasHTLC = si.JusticeHTLC
+	err := nd.BuildJusticeSig(qc)
+	if err != nil {
+		log.With("chanIdx", qc.Idx()).Errorf("BuildJusticeSig err %s", err.Error())
+	} else {
+		// send it, and anything before it, to towers if we do that
+		go nd.autoWatch(qc.Idx())
+	}
+	qc.State.StateIdx, qc.State.MyAmt, qc.State.HasHTLC = stateIdx, myAmt, hasHTLC
+
+	if si.HTLCOp != 0 {
+		nd.htlcCommitted(qc, si.HTLCOp, si.Ours)
+	}
+
+	nd.deleteIntent(qc.Idx())
+}
+
+func (nd *LitNode) deleteIntent(idx uint32) {
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTIntents)
+		if bkt == nil {
+			return fmt.Errorf("no intent bucket")
+		}
+		return bkt.Delete(lnutil.U32tB(idx))
+	})
+	if err != nil {
+		log.With("chanIdx", idx).Errorf("intent delete err %s", err.Error())
+	}
+}
+
+// ResumeStateUpdates finishes state updates a crash or shutdown cut short.
+// Call once the wallets and tower are up.
+func (nd *LitNode) ResumeStateUpdates() error {
+	intents := make(map[uint32]stateIntent)
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTIntents)
+		if bkt == nil {
+			return fmt.Errorf("no intent bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			si, err := stateIntentFromBytes(v)
+			if err != nil {
+				return err
+			}
+			intents[lnutil.BtU32(k)] = si
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for idx, si := range intents {
+		clog := log.With("chanIdx", idx)
+		qc, err := nd.GetQchanByIdx(idx)
+		if err != nil {
+			clog.Errorf("unfinished update: %s\n", err.Error())
+			continue
+		}
+		if qc.State.StateIdx != si.StateIdx {
+			// only the last save's intent is kept, and this isn't it
+			clog.Warnf("at state %d, intent for %d; dropping it\n",
+				qc.State.StateIdx, si.StateIdx)
+			nd.deleteIntent(idx)
+			continue
+		}
+		if _, ok := nd.SubWallet[qc.Coin()]; !ok {
+			// leave it for when that coin's connected
+			clog.Warnf("unfinished update; not connected to coin type %d\n",
+				qc.Coin())
+			continue
+		}
+		clog.Debugf("finishing update to state %d\n", si.StateIdx)
+		nd.finishState(qc, si)
+	}
+	return nil
+}
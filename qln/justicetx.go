@@ -3,11 +3,11 @@ package qln
 import (
 	"bytes"
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/txscript"
 	"github.com/adiabat/btcd/wire"
-	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/sig64"
 )
@@ -20,6 +20,18 @@ because we're using the sipa/schnorr delinearization, we don't need to vary the
 anymore.  We can hand over 1 point per commit & figure everything out from that.
 */
 
+// justiceTxSize is the vsize of a justice tx, to figure its fee from a
+// fee rate.  1 P2WSH input with a sig, 1 P2WPKH output.
+const justiceTxSize = 125
+
+// justiceConfTarget is the blocks a justice tx should confirm in, for its
+// fee estimate.  It has to get in before the bad tx's timeout.
+const justiceConfTarget = 2
+
+// JusticeFeeBumps is how many higher-fee versions of each justice tx we sign
+// for towers, each paying double the one before.
+const JusticeFeeBumps = 4
+
 type JusticeTx struct {
 	Sig  [64]byte
 	Txid [16]byte
@@ -85,7 +97,8 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	// in this function, "bad" refers to the hypothetical transaction spending the
 	// com tx.  "justice" is the tx spending the bad tx
 
-	fee := int64(5000) // fixed fee for now
+	// pay what the wallet would to confirm fast; towers can bump it later
+	fee := nd.SubWallet[q.Coin()].FeeTarget(justiceConfTarget) * justiceTxSize
 
 	// first we need the keys in the bad script.  Start by getting the elk-scalar
 	// we should have it at the "current" state number
@@ -113,11 +126,11 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	var badAmt int64
 	badIdx := uint32(len(badTx.TxOut) + 1)
 
-	log.Printf("made revpub %x timeout pub %x\nscript:%x\nhash %x\n",
+	log.Tracef("made revpub %x timeout pub %x\nscript:%x\nhash %x\n",
 		badRevokePub[:], badTimeoutPub[:], script, scriptHashOutScript)
 	// figure out which output to bring justice to
 	for i, out := range badTx.TxOut {
-		log.Printf("txout %d pkscript %x\n", i, out.PkScript)
+		log.Debugf("txout %d pkscript %x\n", i, out.PkScript)
 		if bytes.Equal(out.PkScript, scriptHashOutScript) {
 			badIdx = uint32(i)
 			badAmt = out.Value
@@ -148,29 +161,39 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	justiceIn.Sequence = 1
 	// make justice output script
 	justiceScript := lnutil.DirectWPKHScriptFromPKH(q.WatchRefundAdr)
-	// make justice txout
-	justiceOut := wire.NewTxOut(badAmt-fee, justiceScript)
 
-	justiceTx := wire.NewMsgTx()
-	// set to version 2, though might not matter as no CSV is used
-	justiceTx.Version = 2
+	// signJustice signs the justice tx paying a given fee
+	signJustice := func(fee int64) ([64]byte, error) {
+		var sig [64]byte
+		// make justice txout
+		justiceOut := wire.NewTxOut(badAmt-fee, justiceScript)
+
+		justiceTx := wire.NewMsgTx()
+		// set to version 2, though might not matter as no CSV is used
+		justiceTx.Version = 2
 
-	// add inputs and outputs
-	justiceTx.AddTxIn(justiceIn)
-	justiceTx.AddTxOut(justiceOut)
+		// add inputs and outputs
+		justiceTx.AddTxIn(justiceIn)
+		justiceTx.AddTxOut(justiceOut)
 
-	jtxid := justiceTx.TxHash()
-	log.Printf("made justice tx %s\n", jtxid.String())
-	// get hashcache for signing
-	hCache := txscript.NewTxSigHashes(justiceTx)
+		jtxid := justiceTx.TxHash()
+		log.Tracef("made justice tx %s fee %d\n", jtxid.String(), fee)
+		// get hashcache for signing
+		hCache := txscript.NewTxSigHashes(justiceTx)
 
-	// sign with combined key.  Justice txs always have only 1 input, so txin is 0
-	bigSig, err := txscript.RawTxInWitnessSignature(
-		justiceTx, hCache, 0, badAmt, script, txscript.SigHashAll, combinedPrivKey)
-	// truncate sig (last byte is sighash type, always sighashAll)
-	bigSig = bigSig[:len(bigSig)-1]
+		// sign with combined key.  Justice txs always have only 1 input, so txin is 0
+		bigSig, err := txscript.RawTxInWitnessSignature(
+			justiceTx, hCache, 0, badAmt, script, txscript.SigHashAll, combinedPrivKey)
+		if err != nil {
+			return sig, err
+		}
+		// truncate sig (last byte is sighash type, always sighashAll)
+		bigSig = bigSig[:len(bigSig)-1]
+
+		return sig64.SigCompress(bigSig)
+	}
 
-	sig, err := sig64.SigCompress(bigSig)
+	sig, err := signJustice(fee)
 	if err != nil {
 		return err
 	}
@@ -189,13 +212,44 @@ func (nd *LitNode) BuildJusticeSig(q *Qchan) error {
 	var justiceBytesFixed [120]byte
 	copy(justiceBytesFixed[:], justiceBytes[:120])
 
+	// also save the encrypted version for towers, which can only open it
+	// if they see the bad tx
+	jb := lnutil.JusticeBlob{
+		DestPKH:    q.WatchRefundAdr,
+		Delay:      q.Delay,
+		Fee:        fee,
+		RevPub:     badRevokePub,
+		TimeoutPub: badTimeoutPub,
+		Sig:        jte.Sig,
+	}
+	// sign bumped versions too, while the output's still worth grabbing
+	for i := 1; i <= JusticeFeeBumps; i++ {
+		if badAmt-jb.BumpFee(i) < consts.MinOutput {
+			break
+		}
+		bumpSig, err := signJustice(jb.BumpFee(i))
+		if err != nil {
+			return err
+		}
+		jb.BumpSigs = append(jb.BumpSigs, bumpSig)
+	}
+	blob, err := jb.Encrypt(badTxid)
+	if err != nil {
+		return err
+	}
+	err = nd.SaveWatchBlob(
+		q.State.StateIdx, q.WatchRefundAdr, lnutil.BlobHint(badTxid), blob)
+	if err != nil {
+		return err
+	}
+
 	return nd.SaveJusticeSig(q.State.StateIdx, q.WatchRefundAdr, justiceBytesFixed)
 }
 
 // SaveJusticeSig save the txid/sig of a justice transaction to the db.  Pretty
 // straightforward
 func (nd *LitNode) SaveJusticeSig(comnum uint64, pkh [20]byte, txidsig [120]byte) error {
-	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
 		sigs := btx.Bucket(BKTWatch)
 		if sigs == nil {
 			return fmt.Errorf("no justice bucket")
@@ -210,10 +264,51 @@ func (nd *LitNode) SaveJusticeSig(comnum uint64, pkh [20]byte, txidsig [120]byte
 	})
 }
 
+// SaveWatchBlob saves the hint and encrypted blob for a state, to send to
+// towers later.
+func (nd *LitNode) SaveWatchBlob(
+	comnum uint64, pkh [20]byte, hint [16]byte, blob []byte) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		blobs := btx.Bucket(BKTBlobs)
+		if blobs == nil {
+			return fmt.Errorf("no blob bucket")
+		}
+		// one bucket per refund PKH, same as justice sigs
+		pkhBkt, err := blobs.CreateBucketIfNotExists(pkh[:])
+		if err != nil {
+			return err
+		}
+		return pkhBkt.Put(lnutil.U64tB(comnum), append(hint[:], blob...))
+	})
+}
+
+// LoadWatchBlob gets back the hint and blob for a state.
+func (nd *LitNode) LoadWatchBlob(
+	comnum uint64, pkh [20]byte) (hint [16]byte, blob []byte, err error) {
+	err = nd.LitDB.View(func(btx kvdb.Tx) error {
+		blobs := btx.Bucket(BKTBlobs)
+		if blobs == nil {
+			return fmt.Errorf("no blob bucket")
+		}
+		pkhBkt := blobs.Bucket(pkh[:])
+		if pkhBkt == nil {
+			return fmt.Errorf("pkh %x not in blob bucket", pkh)
+		}
+		v := pkhBkt.Get(lnutil.U64tB(comnum))
+		if len(v) < 16 {
+			return fmt.Errorf("state %d not in blobs under pkh %x", comnum, pkh)
+		}
+		copy(hint[:], v[:16])
+		blob = append([]byte(nil), v[16:]...)
+		return nil
+	})
+	return
+}
+
 func (nd *LitNode) LoadJusticeSig(comnum uint64, pkh [20]byte) (JusticeTx, error) {
 	var txidsig JusticeTx
 
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
 		sigs := btx.Bucket(BKTWatch)
 		if sigs == nil {
 			return fmt.Errorf("no justice bucket")
@@ -243,7 +338,7 @@ func (nd *LitNode) LoadJusticeSig(comnum uint64, pkh [20]byte) (JusticeTx, error
 func (nd *LitNode) DumpJusticeDB() ([]JusticeTx, error) {
 	var txs []JusticeTx
 
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
 		sigs := btx.Bucket(BKTWatch)
 		if sigs == nil {
 			return fmt.Errorf("no justice bucket")
@@ -277,7 +372,7 @@ func (nd *LitNode) DumpJusticeDB() ([]JusticeTx, error) {
 func (nd *LitNode) ShowJusticeDB() (string, error) {
 	var s string
 
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
 		sigs := btx.Bucket(BKTWatch)
 		if sigs == nil {
 			return fmt.Errorf("no justice bucket")
@@ -299,47 +394,79 @@ func (nd *LitNode) ShowJusticeDB() (string, error) {
 	return s, err
 }
 
-// SendWatch syncs up the remote watchtower with all justice signatures
+// SyncWatch sends a tower every state of a channel it doesn't have yet,
+// up to 1 less than the current state.
 func (nd *LitNode) SyncWatch(qc *Qchan, watchPeer uint32) error {
+	nd.towerSyncMtx.Lock()
+	defer nd.towerSyncMtx.Unlock()
 
 	if !nd.ConnectedToPeer(watchPeer) {
 		return fmt.Errorf("SyncWatch: not connected to peer %d", watchPeer)
 	}
-	// if watchUpTo isn't 2 behind the state number, there's nothing to send
-	// kindof confusing inequality: can't send state 0 info to watcher when at
-	// state 1.  State 0 needs special handling.
-	if qc.State.WatchUpTo+2 > qc.State.StateIdx || qc.State.StateIdx < 2 {
-		return fmt.Errorf("Channel at state %d, up to %d exported, nothing to do",
-			qc.State.StateIdx, qc.State.WatchUpTo)
+	towers, err := nd.ChanTowers(qc.Idx())
+	if err != nil {
+		return err
+	}
+	tp, ok := towers[watchPeer]
+	if !ok {
+		return fmt.Errorf("channel %d not registered with tower %d",
+			qc.Idx(), watchPeer)
 	}
-	// send initial description if we haven't sent anything yet
-	if qc.State.WatchUpTo == 0 {
-		desc := lnutil.NewWatchDescMsg(watchPeer, qc.Coin(),
+	next := tp.Sent
+	// can't send the current state; it hasn't been revoked
+	if next >= qc.State.StateIdx {
+		return nil
+	}
+
+	// send blobs if we have them.  States from before we made blobs have
+	// to go out the old way, which tells the tower about the channel.
+	_, _, err = nd.LoadWatchBlob(next, qc.WatchRefundAdr)
+	blobs := err == nil
+	// the old way, state 0 has to go out with state 1, so there's nothing
+	// to do before state 2
+	if !blobs && qc.State.StateIdx < 2 {
+		return nil
+	}
+	if blobs && !nd.peerHasFeature(watchPeer, lnutil.FeatureTowerFeeOptional) {
+		return fmt.Errorf("SyncWatch: tower %d is too old to take blobs",
+			watchPeer)
+	}
+
+	// pay for what we're about to send, and the description if it's new
+	err = nd.payForWatch(
+		watchPeer, !blobs && next == 0, qc.State.StateIdx-next)
+	if err != nil {
+		return err
+	}
+	if !blobs && next == 0 {
+		nd.OmniOut <- lnutil.NewWatchDescMsg(watchPeer, qc.Coin(),
 			qc.WatchRefundAdr, qc.Delay, 5000, qc.TheirHAKDBase, qc.MyHAKDBase)
+	}
 
-		nd.OmniOut <- desc
-		// after sending description, must send at least states 0 and 1.
-		err := nd.SendWatchComMsg(qc, 0, watchPeer)
-		if err != nil {
-			return err
-		}
-		err = nd.SendWatchComMsg(qc, 1, watchPeer)
-		if err != nil {
-			return err
+	// a channel from before blobs has them only for its newer states.  Those
+	// have to go as blobs: their justice txs don't pay the fee the tower
+	// was told in the description.
+	for idx := next; idx < qc.State.StateIdx; idx++ {
+		hint, blob, err := nd.LoadWatchBlob(idx, qc.WatchRefundAdr)
+		if err == nil {
+			nd.OmniOut <- lnutil.NewWatchBlobMsg(watchPeer, qc.Coin(), hint, blob)
+		} else {
+			err = nd.SendWatchComMsg(qc, idx, watchPeer)
+			if err != nil {
+				return err
+			}
 		}
-		qc.State.WatchUpTo = 1
 	}
-	// send messages to get up to 1 less than current state
-	for qc.State.WatchUpTo < qc.State.StateIdx-1 {
-		// increment watchupto number
-		qc.State.WatchUpTo++
-		err := nd.SendWatchComMsg(qc, qc.State.WatchUpTo, watchPeer)
-		if err != nil {
-			return err
-		}
+	err = nd.updateTowerProgress(qc.Idx(), watchPeer,
+		func(tp *TowerProgress) bool {
+			tp.Sent = qc.State.StateIdx
+			return true
+		})
+	if err != nil {
+		return err
 	}
-	// save updated WatchUpTo number
-	return nd.SaveQchanState(qc)
+	nd.towerContact(watchPeer)
+	return nil
 }
 
 // send WatchComMsg generates and sends the ComMsg to a watchtower
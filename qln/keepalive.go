@@ -0,0 +1,76 @@
+package qln
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+const (
+	// ping a peer we haven't heard from in this long
+	pingInterval = time.Minute
+	// and hang up if they don't say anything back in this long
+	pongTimeout = 30 * time.Second
+)
+
+// heardFrom notes that a message just came in from the peer.
+func (p *RemotePeer) heardFrom() {
+	atomic.StoreInt64(&p.lastRecv, time.Now().UnixNano())
+}
+
+// idle is how long since we got a message from the peer.
+func (p *RemotePeer) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&p.lastRecv)))
+}
+
+// keepAlive pings a peer when the connection has been idle, and closes it if
+// they don't answer.  A dead TCP connection otherwise only shows up when a
+// write fails, which could be a long time if we've got nothing to say.
+// Any message counts as an answer, not just the pong.  Returns once the
+// peer isn't connected on this connection any more.  Peers without pings
+// aren't pinged.
+func (nd *LitNode) keepAlive(peer *RemotePeer) {
+	ticker := time.NewTicker(pongTimeout / 2)
+	defer ticker.Stop()
+
+	var pingSent time.Time
+	for range ticker.C {
+		cur, _ := nd.GetPeer(peer.Idx)
+		current := cur == peer
+		if !current {
+			return
+		}
+
+		idle := peer.idle()
+		if idle < pingInterval || !peer.HasFeature(lnutil.FeaturePingOptional) {
+			pingSent = time.Time{}
+			continue
+		}
+
+		if pingSent.IsZero() {
+			pingSent = time.Now()
+			nd.OmniOut <- lnutil.NewPingMsg(peer.Idx, false, rand.Uint64())
+			continue
+		}
+
+		if time.Since(pingSent) > pongTimeout {
+			log.Infof("peer %d silent for %s, disconnecting\n",
+				peer.Idx, idle.Truncate(time.Second))
+			// the reader notices the close too; peerDropped is ok to call twice
+			peer.Con.Close()
+			nd.peerDropped(peer)
+			return
+		}
+	}
+}
+
+// PingHandler answers pings.  Pongs don't need anything; the reader already
+// noted that the peer is alive.
+func (nd *LitNode) PingHandler(msg lnutil.PingMsg) {
+	if msg.Pong {
+		return
+	}
+	nd.OmniOut <- lnutil.NewPingMsg(msg.Peer(), true, msg.Nonce)
+}
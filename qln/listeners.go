@@ -0,0 +1,186 @@
+package qln
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mit-dci/lit/lndc"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// litListener is one of the addresses we're listening on.
+type litListener struct {
+	lisIpPort string // what we were asked to listen on
+	lis       *lndc.Listener
+	onion     string // onion host:port in front of it, if any
+	onionOnly bool   // only reachable through the onion
+}
+
+// ListenerInfo describes a listener, for the RPC.
+type ListenerInfo struct {
+	Addr  string // as given to TCPListener
+	Bound string // the address actually bound
+	Onion string // onion host:port, if there's an onion service for it
+}
+
+// addListener records a new listener.  It errors if we're already
+// listening on lisIpPort.
+func (nd *LitNode) addListener(ll *litListener) error {
+	nd.lisMtx.Lock()
+	defer nd.lisMtx.Unlock()
+	if _, ok := nd.listeners[ll.lisIpPort]; ok {
+		return fmt.Errorf("already listening on %s", ll.lisIpPort)
+	}
+	nd.listeners[ll.lisIpPort] = ll
+	nd.LisIpPorts = append(nd.LisIpPorts, ll.lisIpPort)
+	return nil
+}
+
+// listening says whether a listener is still open; its accept loop stops
+// once it's been removed.
+func (nd *LitNode) listening(ll *litListener) bool {
+	nd.lisMtx.Lock()
+	defer nd.lisMtx.Unlock()
+	return nd.listeners[ll.lisIpPort] == ll
+}
+
+// ListListeners returns everything we're listening on.
+func (nd *LitNode) ListListeners() []ListenerInfo {
+	nd.lisMtx.Lock()
+	defer nd.lisMtx.Unlock()
+
+	var infos []ListenerInfo
+	for _, lisIpPort := range nd.LisIpPorts {
+		ll := nd.listeners[lisIpPort]
+		infos = append(infos, ListenerInfo{
+			Addr:  ll.lisIpPort,
+			Bound: ll.lis.Addr().String(),
+			Onion: ll.onion,
+		})
+	}
+	return infos
+}
+
+// RemoveListener stops listening on lisIpPort, along with the onion service,
+// port mapping and mDNS advertisement for it.  Peers already connected
+// through it stay connected.
+func (nd *LitNode) RemoveListener(lisIpPort string) error {
+	nd.lisMtx.Lock()
+	ll, ok := nd.listeners[lisIpPort]
+	if !ok {
+		nd.lisMtx.Unlock()
+		return fmt.Errorf("not listening on %s", lisIpPort)
+	}
+	delete(nd.listeners, lisIpPort)
+	for i, p := range nd.LisIpPorts {
+		if p == lisIpPort {
+			nd.LisIpPorts = append(nd.LisIpPorts[:i], nd.LisIpPorts[i+1:]...)
+			break
+		}
+	}
+	nd.lisMtx.Unlock()
+
+	port := uint16(ll.lis.Addr().(*net.TCPAddr).Port)
+	err := ll.lis.Close()
+	if err != nil {
+		return err
+	}
+
+	if ll.onion != "" {
+		host, _, _ := net.SplitHostPort(ll.onion)
+		err = nd.removeOnion(strings.TrimSuffix(host, ".onion"))
+		if err != nil {
+			log.Errorf("remove onion %s error %s\n", ll.onion, err.Error())
+		}
+	}
+	nd.UnmapPort(port)
+
+	nd.MDNSMtx.Lock()
+	if nd.MDNSAd != nil {
+		nd.MDNSAd.RemovePort(port)
+	}
+	nd.MDNSMtx.Unlock()
+
+	log.Infof("stopped listening on %s\n", lisIpPort)
+	return nil
+}
+
+// announce tells the tracker every way we can be reached, in one
+// announcement: the first clearnet listener's port at our external IPs
+// (unless we're behind a proxy), and the first onion.  ip is our external
+// IPv4 address if we know it; otherwise it's looked up.
+func (nd *LitNode) announce(ip string) error {
+	idPriv := nd.IdKey()
+	var idPub [33]byte
+	copy(idPub[:], idPriv.PubKey().SerializeCompressed())
+	adr := lnutil.LitAdrFromPubkey(idPub)
+
+	var port, onion string
+	nd.lisMtx.Lock()
+	for _, lisIpPort := range nd.LisIpPorts {
+		ll := nd.listeners[lisIpPort]
+		if onion == "" {
+			onion = ll.onion
+		}
+		if port == "" && !ll.onionOnly {
+			_, port, _ = net.SplitHostPort(ll.lis.Addr().String())
+		}
+	}
+	nd.lisMtx.Unlock()
+	if port != "" {
+		port = nd.externalPort(port)
+	}
+
+	// Don't announce our IP if we are communicating via SOCKS proxy
+	var ipv4, ipv6 string
+	if nd.ProxyURL == "" && port != "" {
+		var err error
+		if ip == "" {
+			ip, err = ExternalIP(defaultIPv4Resolver)
+			if err != nil {
+				return err
+			}
+		}
+		ipv4 = net.JoinHostPort(ip, port)
+
+		ip6, err := ExternalIP(defaultIPv6Resolver)
+		if err != nil {
+			log.Errorf("%v", err)
+		} else {
+			ipv6 = net.JoinHostPort(ip6, port)
+		}
+	}
+	if ipv4 == "" && onion == "" {
+		return nil
+	}
+	err := AnnounceEndpoints(idPriv, ipv4, ipv6, onion, adr,
+		nd.TrackerURL, nd.ProxyURL)
+	if err != nil {
+		return err
+	}
+
+	// if we're a tower, say so too
+	coins := nd.Tower.CoinTypes()
+	if len(coins) == 0 {
+		return nil
+	}
+	terms := nd.Tower.GetTerms()
+	return AnnounceTower(idPriv, TowerAd{
+		Addr:       adr,
+		CoinTypes:  coins,
+		Blobs:      true,
+		PerChannel: terms.PerChannel,
+		PerState:   terms.PerState,
+	}, nd.TrackerURL, nd.ProxyURL)
+}
+
+// endpointOrder is the order to try a node's endpoints in.  Through a
+// proxy, the onion first, since it doesn't leave tor; without one, only
+// clearnet, since onions can't be reached.
+func endpointOrder(ipv4, ipv6, onion string, proxy bool) []string {
+	if !proxy {
+		return []string{ipv4, ipv6}
+	}
+	return []string{onion, ipv4, ipv6}
+}
@@ -3,8 +3,8 @@ package qln
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"sync"
+	"time"
 
 	"github.com/mit-dci/lit/elkrem"
 	"github.com/mit-dci/lit/lnutil"
@@ -42,8 +42,16 @@ type Qchan struct {
 
 	State *StatCom // S current state of channel
 
+	OldHTLCs []HTLC // S every HTLC the channel's had, to take from revoked states
+
+	// ClearToSend holds a true when no update's in flight; it's only taken
+	// or checked with ChanMtx held.  ChanMtx is last in the lock order (see
+	// LitNode.RemoteMtx).
 	ClearToSend chan bool // send a true here when you get a rev
 	ChanMtx     sync.Mutex
+	lastHTLCOp  uint8 // our last HTLC op to go through
+	// why the peer turned down our push; set till the push sees it
+	pushDeclined string
 	// exists only in ram, doesn't touch disk
 }
 
@@ -52,7 +60,7 @@ type Qchan struct {
 type StatCom struct {
 	StateIdx uint64 // this is the n'th state commitment
 
-	WatchUpTo uint64 // have sent out to watchtowers up to this state  ( < stateidx)
+	WatchUpTo uint64 // no longer updated; where the channel's first tower starts, see registerTower
 
 	MyAmt int64 // my channel allocation
 
@@ -65,6 +73,13 @@ type StatCom struct {
 	// Delta for when the channel is in a collision state which needs to be resolved
 	Collision int32
 
+	// An HTLC takes its amount out of whoever offered it.  HasHTLC says
+	// if it's in this state; HTLCOp is an HTLC op in transit, in place
+	// of a push.
+	HTLC    HTLC
+	HasHTLC bool
+	HTLCOp  uint8
+
 	// Elkrem point from counterparty, used to make
 	// Homomorphic Adversarial Key Derivation public keys (HAKD)
 	ElkPoint     [33]byte // saved to disk, current revealable point
@@ -97,30 +112,35 @@ type QCloseData struct {
 // ChannelInfo prints info about a channel.
 func (nd *LitNode) QchanInfo(q *Qchan) error {
 	// display txid instead of outpoint because easier to copy/paste
-	log.Printf("CHANNEL %s h:%d %s cap: %d\n",
+	log.Debugf("CHANNEL %s h:%d %s cap: %d\n",
 		q.Op.String(), q.Height, q.KeyGen.String(), q.Value)
-	log.Printf("\tPUB mine:%x them:%x REFBASE mine:%x them:%x BASE mine:%x them:%x\n",
+	log.Tracef("\tPUB mine:%x them:%x REFBASE mine:%x them:%x BASE mine:%x them:%x\n",
 		q.MyPub[:4], q.TheirPub[:4], q.MyRefundPub[:4], q.TheirRefundPub[:4],
 		q.MyHAKDBase[:4], q.TheirHAKDBase[:4])
 	if q.State == nil || q.ElkRcv == nil {
-		log.Printf("\t no valid state or elkrem\n")
+		log.Tracef("\t no valid state or elkrem\n")
 	} else {
-		log.Printf("\ta %d (them %d) state index %d\n",
-			q.State.MyAmt, q.Value-q.State.MyAmt, q.State.StateIdx)
-
-		log.Printf("\tdelta:%d HAKD:%x elk@ %d\n",
+		log.Tracef("\ta %d (them %d) state index %d\n",
+			q.State.MyAmt, q.TheirAmt(), q.State.StateIdx)
+		if q.State.HasHTLC {
+			log.Tracef("\tHTLC %d hash %x locktime %d incoming %t\n",
+				q.State.HTLC.Amt, q.State.HTLC.RHash[:4],
+				q.State.HTLC.Locktime, q.State.HTLC.Incoming)
+		}
+
+		log.Tracef("\tdelta:%d HAKD:%x elk@ %d\n",
 			q.State.Delta, q.State.ElkPoint[:4], q.ElkRcv.UpTo())
 		elkp, _ := q.ElkPoint(false, q.State.StateIdx)
 		myRefPub := lnutil.AddPubsEZ(q.MyRefundPub, elkp)
 		theirRefPub := lnutil.AddPubsEZ(q.TheirRefundPub, q.State.ElkPoint)
-		log.Printf("\tMy Refund: %x Their Refund %x\n", myRefPub[:4], theirRefPub[:4])
+		log.Tracef("\tMy Refund: %x Their Refund %x\n", myRefPub[:4], theirRefPub[:4])
 	}
 
 	if !q.CloseData.Closed { // still open, finish here
 		return nil
 	}
 
-	log.Printf("\tCLOSED at height %d by tx: %s\n",
+	log.Tracef("\tCLOSED at height %d by tx: %s\n",
 		q.CloseData.CloseHeight, q.CloseData.CloseTxid.String())
 	//	clTx, err := t.GetTx(&q.CloseData.CloseTxid)
 	//	if err != nil {
@@ -132,16 +152,16 @@ func (nd *LitNode) QchanInfo(q *Qchan) error {
 	//	}
 
 	//	if len(ctxos) == 0 {
-	//		log.Printf("\tcooperative close.\n")
+	//		log.Tracef("\tcooperative close.\n")
 	//		return nil
 	//	}
 
-	//	log.Printf("\tClose resulted in %d spendable txos\n", len(ctxos))
+	//	log.Tracef("\tClose resulted in %d spendable txos\n", len(ctxos))
 	//	if len(ctxos) == 2 {
-	//		log.Printf("\t\tINVALID CLOSE!!!11\n")
+	//		log.Errorf("\t\tINVALID CLOSE!!!11\n")
 	//	}
 	//	for i, u := range ctxos {
-	//		log.Printf("\t\t%d) amt: %d spendable: %d\n", i, u.Value, u.Seq)
+	//		log.Tracef("\t\t%d) amt: %d spendable: %d\n", i, u.Value, u.Seq)
 	//	}
 	return nil
 }
@@ -170,6 +190,46 @@ func (q *Qchan) Coin() uint32 {
 	return q.KeyGen.Step[1] & 0x7fffffff
 }
 
+// SigType returns the kind of signature (ECDSA or schnorr) used to spend
+// the channel's fund output.  Determined by the fund output script; channels
+// without one stored are all segwit v0, so ECDSA.
+func (q *Qchan) SigType() uint8 {
+	if q == nil {
+		return lnutil.SigTypeECDSA
+	}
+	return lnutil.SigTypeForScript(q.PkScript)
+}
+
+// TheirAmt is their allocation in the channel; what isn't mine or in an
+// HTLC.
+func (q *Qchan) TheirAmt() int64 {
+	amt := q.Value - q.State.MyAmt
+	if q.State.HasHTLC {
+		amt -= q.State.HTLC.Amt
+	}
+	return amt
+}
+
+// how often acquire looks for the channel to be clear to send
+const ctsPoll = 10 * time.Millisecond
+
+// acquire waits until the channel's clear to send, and returns with ChanMtx
+// held and ClearToSend taken.  The token has to be taken with ChanMtx held,
+// or a handler could see it gone and think there's a collision, so this
+// polls rather than blocking on ClearToSend.  Only this channel waits.
+func (q *Qchan) acquire() {
+	for {
+		q.ChanMtx.Lock()
+		select {
+		case <-q.ClearToSend:
+			return
+		default:
+		}
+		q.ChanMtx.Unlock()
+		time.Sleep(ctsPoll)
+	}
+}
+
 // ImFirst decides who goes first when it's unclear.  Smaller pubkey goes first.
 func (q *Qchan) ImFirst() bool {
 	return bytes.Compare(q.MyRefundPub[:], q.TheirRefundPub[:]) == -1
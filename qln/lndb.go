@@ -2,18 +2,20 @@ package qln
 
 import (
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil"
-	"github.com/boltdb/bolt"
 	"github.com/mit-dci/lit/dlc"
 	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lndc"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/mdns"
+	"github.com/mit-dci/lit/nat"
+	"github.com/mit-dci/lit/tor"
 	"github.com/mit-dci/lit/watchtower"
 )
 
@@ -84,14 +86,24 @@ You can't remove wallets once they're attached; just restart instead.
 // LnNode is the main struct for the node, keeping track of all channel state and
 // communicating with the underlying UWallet
 type LitNode struct {
-	LitDB *bolt.DB // place to write all this down
+	// counts for metrics.  First so they're 64-bit aligned for atomic
+	// access.
+	counters nodeCounters
+
+	LitDB kvdb.DB // place to write all this down
 
 	LitFolder string // path to save stuff
 
 	IdentityKey *btcec.PrivateKey
 
+	// append-only record of what happened, in the lit folder
+	auditLog *auditLog
+
 	// all nodes have a watchtower.  but could have a tower without a node
 	Tower watchtower.Watcher
+	// TowerOnly is set when the node is just a watchtower, with no wallets
+	// or channels
+	TowerOnly bool
 
 	// discreet log contract manager
 	DlcManager *dlc.DlcManager
@@ -105,12 +117,55 @@ type LitNode struct {
 	// cointype of the first (possibly only) wallet connected
 	DefaultCoin uint32
 
+	// RemoteMtx guards RemoteCons and reconnecting, nothing else; each
+	// peer and channel has its own lock for its own state.  Lock order,
+	// when more than one is needed: ChannelMapMtx, RemoteMtx, a
+	// RemotePeer's mtx, then a Qchan's ChanMtx.  RemoteMtx and peer locks
+	// are only held for map lookups, never while sending on OmniOut,
+	// writing to a peer or using the db; use GetPeer and peerList.
 	RemoteCons map[uint32]*RemotePeer
-	RemoteMtx  sync.Mutex
+	RemoteMtx  sync.RWMutex
+
+	// peers we're trying to reconnect to; also guarded by RemoteMtx
+	reconnecting map[uint32]bool
+
+	// Whitelist is the ln addresses allowed to connect in, if we're only
+	// accepting known peers.  nil means anyone.  Guarded by wlMtx.
+	Whitelist map[string]bool
+	wlMtx     sync.Mutex
+
+	// MaxInbound caps the number of incoming connections; 0 for no cap
+	MaxInbound int
+	inLimiter  *inboundLimiter
+
+	// MaxMsgSize is the longest message we'll take from a peer; 0 for the
+	// lndc max (16MB)
+	MaxMsgSize uint32
+
+	// Features are what we tell peers we have in the init message
+	Features lnutil.FeatureBits
+
+	// bytes and messages exchanged with each peer
+	traffic trafficCounter
 
 	// WatchCon is currently just for the watchtower
 	WatchCon *lndc.LNDConn // merge these later
 
+	// TowerBudget is the most we'll pay any one watchtower, in satoshis
+	TowerBudget int64
+	// what towers have told us they charge
+	towerTerms    map[uint32]lnutil.WatchTermsMsg
+	towerTermsCh  map[uint32]chan bool // closed when the tower answers
+	towerTermsMtx sync.Mutex
+	// AutoWatchTower is the peer index of the tower new channels and states
+	// are sent to automatically; 0 for none
+	AutoWatchTower uint32
+	// only one tower sync at a time, so none get sent the same state twice
+	towerSyncMtx sync.Mutex
+	// WatchRetain is how many blocks to keep watch data for closed
+	// channels, after they're safe, before deleting it from towers and us
+	WatchRetain int32
+
 	// OmniChan is the channel for the OmniHandler
 	OmniIn  chan lnutil.LitMsg
 	OmniOut chan lnutil.LitMsg
@@ -124,12 +179,50 @@ type LitNode struct {
 
 	// queue for async messages to RPC user
 	UserMessageBox chan string
+	// chat messages on their way to peers
+	chatOut chatOutbox
+	// held sending chat, so queued chat goes before new
+	chatSendMtx sync.Mutex
+	// ChatExpiry is how long chat to a peer that isn't connected waits
+	// before it's given up on
+	ChatExpiry time.Duration
+
+	// RPC clients' subscriptions to blocks, txs and outpoints
+	chainSubs chainSubs
+	// subscriptions to apps' custom messages
+	customSubs customSubs
+
+	// timings of recent pushes
+	paymentTraces paymentTraces
+
+	// urls to post events to; nil if none
+	webhooks *webhooks
+
+	// programs lit runs to add rpc methods, hear events and hook in
+	plugins plugins
+
+	// commands to run on events; nil if none
+	eventCmds *eventCmds
+
+	// RemoteRPC runs an rpc call from a node paired for remote control,
+	// if its scope allows; set by the rpc.  args and the reply are json.
+	RemoteRPC func(scope, method string, args []byte) ([]byte, error)
+	// our remote control calls waiting on replies
+	remoteCalls remoteCalls
+
+	// called with every event, for programs lit's built into
+	eventFuncMtx sync.Mutex
+	eventFuncs   []func(WebhookEvent)
 
 	// The port(s) in which it listens for incoming connections
 	LisIpPorts []string
+	listeners  map[string]*litListener // by LisIpPort
+	lisMtx     sync.Mutex              // guards LisIpPorts and listeners
 
 	// The URL from which lit attempts to resolve the LN address
 	TrackerURL string
+	// DNSSeeds are asked for addresses when the tracker can't help
+	DNSSeeds []string
 
 	ChannelMap    map[[20]byte][]lnutil.LinkMsg
 	ChannelMapMtx sync.Mutex
@@ -137,14 +230,60 @@ type LitNode struct {
 
 	// Contains the URL string to connect to a SOCKS5 proxy, if provided
 	ProxyURL string
+
+	// TorControl is the tor control port (host:port or unix:path) to make
+	// onion services with; empty if not using tor.
+	TorControl string
+	// TorPassword is the control port password, if tor wants one
+	TorPassword string
+	TorCtl      *tor.Controller
+	TorMtx      sync.Mutex
+
+	// NatMap says to have the router forward ports to our listeners, with
+	// UPnP or NAT-PMP.
+	NatMap      bool
+	NatMapper   nat.Mapper
+	NatMappings []*portMapping
+	NatMtx      sync.Mutex
+
+	// MDNS says to advertise our listeners on the local network
+	MDNS    bool
+	MDNSAd  *mdns.Advertiser
+	MDNSMtx sync.Mutex
+
+	// backups of the channel db; nil if not making them
+	backups   *backups
+	BackupMtx sync.Mutex
 }
 
 type RemotePeer struct {
+	// unix nanos of the last message from them.  First so it's 64-bit
+	// aligned for atomic access.
+	lastRecv int64
+
 	Idx      uint32 // the peer index
+	Inbound  bool   // they connected to us
 	Nickname string
 	Con      *lndc.LNDConn
-	QCs      map[uint32]*Qchan   // keep map of all peer's channels in ram
-	OpMap    map[[36]byte]uint32 // quick lookup for channels
+
+	// mtx guards QCs, OpMap, Version, Features and, once the peer's
+	// started, Nickname.  Hold it just to read or change them; GetQchan,
+	// qchanByOp, addQchan, qchans, GetNickname and SetNickname do.
+	mtx   sync.Mutex
+	QCs   map[uint32]*Qchan   // keep map of all peer's channels in ram
+	OpMap map[[36]byte]uint32 // quick lookup for channels
+
+	// from their init message; Features are the ones we both have
+	Version  uint16
+	Features lnutil.FeatureBits
+	// closed when their init message comes in
+	initDone chan bool
+	initOnce sync.Once
+
+	// messages waiting for the peer's writer, by priority
+	outbox   [numPrios]chan lnutil.LitMsg
+	quit     chan bool
+	stopOnce sync.Once
 }
 
 // InFlightFund is a funding transaction that has not yet been broadcast
@@ -159,6 +298,12 @@ type InFlightFund struct {
 	mtx sync.Mutex
 
 	Data [32]byte
+
+	CoinSelect string // coin selection for the funding tx; empty for default
+	// manual coin control for the funding tx: inputs to spend, and not to
+	UseInputs, AvoidInputs []wire.OutPoint
+	// where the funding tx's change goes; nil for the wallet's default
+	Change *lnutil.ChangeSpec
 }
 
 func (inff *InFlightFund) Clear() {
@@ -167,6 +312,10 @@ func (inff *InFlightFund) Clear() {
 
 	inff.Amt = 0
 	inff.InitSend = 0
+	inff.CoinSelect = ""
+	inff.UseInputs = nil
+	inff.AvoidInputs = nil
+	inff.Change = nil
 }
 
 // GetPubHostFromPeerIdx gets the pubkey and internet host name for a peer
@@ -174,7 +323,7 @@ func (nd *LitNode) GetPubHostFromPeerIdx(idx uint32) ([33]byte, string) {
 	var pub [33]byte
 	var host string
 	// look up peer in db
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
 		mp := btx.Bucket(BKTPeerMap)
 		if mp == nil {
 			return nil
@@ -196,7 +345,7 @@ func (nd *LitNode) GetPubHostFromPeerIdx(idx uint32) ([33]byte, string) {
 		return nil
 	})
 	if err != nil {
-		log.Printf(err.Error())
+		log.Errorf("%s\n", err.Error())
 	}
 	return pub, host
 }
@@ -205,7 +354,7 @@ func (nd *LitNode) GetPubHostFromPeerIdx(idx uint32) ([33]byte, string) {
 func (nd *LitNode) GetNicknameFromPeerIdx(idx uint32) string {
 	var nickname string
 	// look up peer in db
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
 		mp := btx.Bucket(BKTPeerMap)
 		if mp == nil {
 			return nil
@@ -225,7 +374,7 @@ func (nd *LitNode) GetNicknameFromPeerIdx(idx uint32) string {
 		return nil
 	})
 	if err != nil {
-		log.Printf(err.Error())
+		log.Errorf("%s\n", err.Error())
 	}
 	return nickname
 }
@@ -233,7 +382,7 @@ func (nd *LitNode) GetNicknameFromPeerIdx(idx uint32) string {
 // NextIdx returns the next channel index to use.
 func (nd *LitNode) NextChannelIdx() (uint32, error) {
 	var cIdx uint32
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
 		cmp := btx.Bucket(BKTChanMap)
 		if cmp == nil {
 			return fmt.Errorf("NextIdxForPeer: no ChanMap")
@@ -253,7 +402,7 @@ func (nd *LitNode) NextChannelIdx() (uint32, error) {
 // yet!  Also return a bool for new..?  not needed?
 func (nd *LitNode) GetPeerIdx(pub *btcec.PublicKey, host string) (uint32, error) {
 	var idx uint32
-	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
 		prs := btx.Bucket(BKTPeers) // only errs on name
 		thisPeerBkt := prs.Bucket(pub.SerializeCompressed())
 		// peer is already registered, return index without altering db.
@@ -300,7 +449,7 @@ func (nd *LitNode) SaveNicknameForPeerIdx(nickname string, idx uint32) error {
 	var err error
 
 	// look up peer in db
-	err = nd.LitDB.Update(func(btx *bolt.Tx) error {
+	err = nd.LitDB.Update(func(btx kvdb.Tx) error {
 		mp := btx.Bucket(BKTPeerMap)
 		if mp == nil {
 			return nil
@@ -325,9 +474,31 @@ func (nd *LitNode) SaveNicknameForPeerIdx(nickname string, idx uint32) error {
 	return err
 }
 
+// SavePeerHost overwrites the host:port saved for a given peer idx
+func (nd *LitNode) SavePeerHost(host string, idx uint32) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		mp := btx.Bucket(BKTPeerMap)
+		if mp == nil {
+			return nil
+		}
+		pubBytes := mp.Get(lnutil.U32tB(idx))
+		peerBkt := btx.Bucket(BKTPeers)
+		if peerBkt == nil {
+			return fmt.Errorf("no Peers")
+		}
+		prBkt := peerBkt.Bucket(pubBytes)
+		if prBkt == nil {
+			return fmt.Errorf("no peer %x", pubBytes)
+		}
+
+		return prBkt.Put(KEYhost, []byte(host))
+	})
+}
+
 // SaveQchanUtxoData saves utxo data such as outpoint and close tx / status
 func (nd *LitNode) SaveQchanUtxoData(q *Qchan) error {
-	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+	var newlyClosed bool
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
 		cbk := btx.Bucket(BKTChannel)
 		if cbk == nil {
 			return fmt.Errorf("no peers")
@@ -341,6 +512,9 @@ func (nd *LitNode) SaveQchanUtxoData(q *Qchan) error {
 		}
 
 		if q.CloseData.Closed {
+			// only the first save as closed is the close
+			old, err := QCloseFromBytes(qcBucket.Get(KEYqclose))
+			newlyClosed = err != nil || !old.Closed
 			closeBytes, err := q.CloseData.ToBytes()
 			if err != nil {
 				return err
@@ -360,6 +534,16 @@ func (nd *LitNode) SaveQchanUtxoData(q *Qchan) error {
 		// save qchannel
 		return qcBucket.Put(KEYutxo, qcBytes)
 	})
+	if err == nil && q.CloseData.Closed {
+		nd.requestBackup()
+	}
+	if err == nil && newlyClosed {
+		nd.audit(AuditEvent{Type: AuditChanClose, Peer: q.Peer(),
+			ChanIdx: q.Idx(), Txid: q.CloseData.CloseTxid.String()})
+		nd.notify(WebhookEvent{Type: WebhookChannelClosed, Peer: q.Peer(),
+			ChanIdx: q.Idx(), Txid: q.CloseData.CloseTxid.String()})
+	}
+	return err
 }
 
 // register a new Qchan in the db
@@ -369,7 +553,7 @@ func (nd *LitNode) SaveQChan(q *Qchan) error {
 	}
 
 	// save channel to db.  It has no state, and has no outpoint yet
-	err := nd.LitDB.Update(func(btx *bolt.Tx) error {
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
 
 		qOPArr := lnutil.OutPointToBytes(q.Op)
 
@@ -384,7 +568,7 @@ func (nd *LitNode) SaveQChan(q *Qchan) error {
 		if err != nil {
 			return err
 		}
-		log.Printf("saved %d : %s mapping in db\n", q.Idx(), q.Op.String())
+		log.Debugf("saved %d : %s mapping in db\n", q.Idx(), q.Op.String())
 
 		cbk := btx.Bucket(BKTChannel) // go into bucket for all peers
 		if cbk == nil {
@@ -414,7 +598,7 @@ func (nd *LitNode) SaveQChan(q *Qchan) error {
 		// serialize elkrem receiver if it exists
 
 		if q.ElkRcv != nil {
-			log.Printf("--- elk rcv exists, saving\n")
+			log.Debugf("--- elk rcv exists, saving\n")
 
 			eb, err := q.ElkRcv.ToBytes()
 			if err != nil {
@@ -433,13 +617,20 @@ func (nd *LitNode) SaveQChan(q *Qchan) error {
 			return err
 		}
 		// save state
-		log.Printf("writing %d byte state to bucket\n", len(b))
+		log.Debugf("writing %d byte state to bucket\n", len(b))
 		return qcBucket.Put(KEYState, b)
 	})
 	if err != nil {
 		return err
 	}
 
+	// back up with the new channel in it
+	nd.requestBackup()
+	nd.audit(AuditEvent{Type: AuditChanOpen, Peer: q.Peer(), ChanIdx: q.Idx(),
+		Amt: q.Value, Txid: q.Op.Hash.String(),
+		Detail: fmt.Sprintf("coin %d", q.Coin())})
+	nd.notify(WebhookEvent{Type: WebhookChannelOpened, Peer: q.Peer(),
+		ChanIdx: q.Idx(), Amt: q.Value, Txid: q.Op.Hash.String()})
 	return nil
 }
 
@@ -451,7 +642,7 @@ func (nd *LitNode) SaveQChan(q *Qchan) error {
 // This should populate everything int he Qchan struct: the elkrems and the states.
 // Elkrem sender always works; is derived from local key data.
 // Elkrem receiver can be "empty" with nothing in it (no data in db)
-func (nd *LitNode) RestoreQchanFromBucket(bkt *bolt.Bucket) (*Qchan, error) {
+func (nd *LitNode) RestoreQchanFromBucket(bkt kvdb.Bucket) (*Qchan, error) {
 	if bkt == nil { // can't do anything without a bucket
 		return nil, fmt.Errorf("empty qchan bucket ")
 	}
@@ -489,6 +680,10 @@ func (nd *LitNode) RestoreQchanFromBucket(bkt *bolt.Bucket) (*Qchan, error) {
 			return nil, err
 		}
 	}
+	qc.OldHTLCs, err = oldHTLCsFromBytes(bkt.Get(KEYHTLCs))
+	if err != nil {
+		return nil, err
+	}
 
 	// load elkrem from elkrem bucket.
 	// shouldn't error even if nil.  So shouldn't error, ever.  Right?
@@ -498,7 +693,7 @@ func (nd *LitNode) RestoreQchanFromBucket(bkt *bolt.Bucket) (*Qchan, error) {
 		return nil, err
 	}
 	if qc.ElkRcv != nil {
-		// log.Printf("loaded elkrem receiver at state %d\n", qc.ElkRcv.UpTo())
+		// log.Debugf("loaded elkrem receiver at state %d\n", qc.ElkRcv.UpTo())
 	}
 
 	// derive elkrem sender root from HD keychain
@@ -522,7 +717,7 @@ func (nd *LitNode) ReloadQchanState(q *Qchan) error {
 	var err error
 	opArr := lnutil.OutPointToBytes(q.Op)
 
-	return nd.LitDB.View(func(btx *bolt.Tx) error {
+	return nd.LitDB.View(func(btx kvdb.Tx) error {
 		cbk := btx.Bucket(BKTChannel)
 		if cbk == nil {
 			return fmt.Errorf("no channels")
@@ -543,6 +738,10 @@ func (nd *LitNode) ReloadQchanState(q *Qchan) error {
 		if err != nil {
 			return err
 		}
+		q.OldHTLCs, err = oldHTLCsFromBytes(qcBucket.Get(KEYHTLCs))
+		if err != nil {
+			return err
+		}
 
 		q.CloseData, err = QCloseFromBytes(qcBucket.Get(KEYqclose))
 		if err != nil {
@@ -561,7 +760,7 @@ func (nd *LitNode) ReloadQchanState(q *Qchan) error {
 // SetQchanRefund overwrites "theirrefund" and "theirHAKDbase" in a qchan.
 //   This is needed after getting a chanACK.
 func (nd *LitNode) SetQchanRefund(q *Qchan, refund, hakdBase [33]byte) error {
-	return nd.LitDB.Update(func(btx *bolt.Tx) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
 		cbk := btx.Bucket(BKTChannel)
 		if cbk == nil {
 			return fmt.Errorf("no channels")
@@ -597,42 +796,52 @@ func (nd *LitNode) SetQchanRefund(q *Qchan, refund, hakdBase [33]byte) error {
 // if we can make that it's own function.  Get channel bucket maybe?  But then
 // you have to close it...
 func (nd *LitNode) SaveQchanState(q *Qchan) error {
-	return nd.LitDB.Update(func(btx *bolt.Tx) error {
-		cbk := btx.Bucket(BKTChannel)
-		if cbk == nil {
-			return fmt.Errorf("no channels")
-		}
-
-		opArr := lnutil.OutPointToBytes(q.Op)
-		qcBucket := cbk.Bucket(opArr[:])
-		if qcBucket == nil {
-			return fmt.Errorf("outpoint %s not in db ", q.Op.String())
-		}
-		// serialize elkrem receiver
-		eb, err := q.ElkRcv.ToBytes()
-		if err != nil {
-			return err
-		}
-		// save elkrem
-		err = qcBucket.Put(KEYElkRecv, eb)
-		if err != nil {
-			return err
-		}
-		// serialize state
-		b, err := q.State.ToBytes()
-		if err != nil {
-			return err
-		}
-		// save state
-		log.Printf("writing %d byte state to bucket\n", len(b))
-		return qcBucket.Put(KEYState, b)
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		return putQchanState(btx, q)
 	})
 }
 
+// putQchanState writes the elkrem receiver and state in a transaction.
+func putQchanState(btx kvdb.Tx, q *Qchan) error {
+	cbk := btx.Bucket(BKTChannel)
+	if cbk == nil {
+		return fmt.Errorf("no channels")
+	}
+
+	opArr := lnutil.OutPointToBytes(q.Op)
+	qcBucket := cbk.Bucket(opArr[:])
+	if qcBucket == nil {
+		return fmt.Errorf("outpoint %s not in db ", q.Op.String())
+	}
+	// serialize elkrem receiver
+	eb, err := q.ElkRcv.ToBytes()
+	if err != nil {
+		return err
+	}
+	// save elkrem
+	err = qcBucket.Put(KEYElkRecv, eb)
+	if err != nil {
+		return err
+	}
+	// keep any new HTLC, so it can be taken if this state's revoked
+	err = putOldHTLC(qcBucket, q)
+	if err != nil {
+		return err
+	}
+	// serialize state
+	b, err := q.State.ToBytes()
+	if err != nil {
+		return err
+	}
+	// save state
+	log.Debugf("writing %d byte state to bucket\n", len(b))
+	return qcBucket.Put(KEYState, b)
+}
+
 // GetAllQchans returns a slice of all channels. empty slice is OK.
 func (nd *LitNode) GetAllQchans() ([]*Qchan, error) {
 	var qChans []*Qchan
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
 		cbk := btx.Bucket(BKTChannel)
 		if cbk == nil {
 			return fmt.Errorf("no channels")
@@ -669,7 +878,7 @@ func (nd *LitNode) GetQchan(opArr [36]byte) (*Qchan, error) {
 	qc := new(Qchan)
 	var err error
 	op := lnutil.OutPointFromBytes(opArr)
-	err = nd.LitDB.View(func(btx *bolt.Tx) error {
+	err = nd.LitDB.View(func(btx kvdb.Tx) error {
 		cbk := btx.Bucket(BKTChannel)
 		if cbk == nil {
 			return fmt.Errorf("no channels")
@@ -695,7 +904,7 @@ func (nd *LitNode) GetQchan(opArr [36]byte) (*Qchan, error) {
 
 func (nd *LitNode) GetQchanOPfromIdx(cIdx uint32) ([36]byte, error) {
 	var rOp [36]byte
-	err := nd.LitDB.View(func(btx *bolt.Tx) error {
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
 		cmp := btx.Bucket(BKTChanMap)
 		if cmp == nil {
 			return fmt.Errorf("no channel map")
@@ -717,7 +926,7 @@ func (nd *LitNode) GetQchanByIdx(cIdx uint32) (*Qchan, error) {
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("got op %x\n", op)
+	log.Debugf("got op %x\n", op)
 	qc, err := nd.GetQchan(op)
 	if err != nil {
 		return nil, err
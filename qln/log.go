@@ -0,0 +1,7 @@
+package qln
+
+import "github.com/mit-dci/lit/logs"
+
+// log is the QLN subsystem's logger; its level can be set with --loglevel
+// or the SetLogLevel rpc.
+var log = logs.New("QLN")
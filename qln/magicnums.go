@@ -22,6 +22,9 @@ const (
 	// pays TO)
 	UseContractPayoutPKH = 52 | hdkeychain.HardenedKeyStart
 
+	// key derivation path for submarine swap claim and refund keys
+	UseSubSwap = 60 | hdkeychain.HardenedKeyStart
+
 	// links Id and channel. replaces UseChannelFund
 	UseIdKey = 111 | hdkeychain.HardenedKeyStart
 
@@ -36,13 +39,37 @@ var (
 	BKTPeerMap = []byte("pmp") // map of peer index to pubkey
 	BKTChanMap = []byte("cmp") // map of channel index to outpoint
 	BKTWatch   = []byte("wch") // txids & signatures for export to watchtowers
+	BKTBlobs   = []byte("wbl") // encrypted justice data for export to watchtowers
+	BKTBans    = []byte("ban") // banned peer pubkeys : ban expiry time
+	BKTPending = []byte("pnd") // messages to send peers when they reconnect
+	BKTTwrPaid = []byte("twp") // how much we've paid each watchtower
+	BKTTowers  = []byte("twr") // channel : tower : next state to send it
+	BKTTwrSeen = []byte("tws") // unix time we last dealt with each tower
+	BKTTwrSync = []byte("tsy") // block height a tower-only node has watched to
+	BKTAutoWch = []byte("awt") // channel : whether to send states to towers automatically
+	BKTContact = []byte("cts") // address book; name : on-chain address, lit address
+	BKTSwaps   = []byte("swp") // atomic swaps; hash : swap
+	BKTSubSwps = []byte("ssw") // submarine swaps; hash : swap
+	BKTHints   = []byte("hnt") // channel : heights it confirmed and was scanned to
+	BKTIntents = []byte("itn") // channel index : what's left to do after its last state save
+	BKTRemotes = []byte("rmt") // pubkeys paired for remote control : scope
+	BKTCustoms = []byte("cst") // pubkeys we swap custom messages with : 1
+	BKTChats   = []byte("cht") // chat history; peer index : id : chat
+	BKTChtSeen = []byte("chs") // peer index : highest chat id and time they've sent
+	BKTAliases = []byte("als") // node pubkey : its signed alias announcement
+	BKTPayReqs = []byte("prq") // payment requests, ours and peers'; id : request
+	BKTAudit   = []byte("aud") // the audit log's last event; KEYAudHead : seq, MAC
 
 	KEYIdx      = []byte("idx")  // index for key derivation
 	KEYhost     = []byte("hst")  // hostname where peer lives
 	KEYnickname = []byte("nick") // nickname where peer lives
+	KEYlastSeen = []byte("seen") // unix time we last connected with peer
 
 	KEYutxo    = []byte("utx") // serialized utxo for the channel
 	KEYState   = []byte("now") // channel state
 	KEYElkRecv = []byte("elk") // elkrem receiver
 	KEYqclose  = []byte("cls") // channel close outpoint & height
+	KEYHTLCs   = []byte("htl") // every HTLC the channel's had, for revoked states
+
+	KEYAudHead = []byte("head") // audit log's last sequence number and MAC
 )
@@ -0,0 +1,54 @@
+package qln
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/mdns"
+)
+
+// how long DiscoverLocal waits for answers
+const mdnsDiscoverTime = 2 * time.Second
+
+// mdnsAdvertise tells the local network we're listening at lisAdr.
+func (nd *LitNode) mdnsAdvertise(lisAdr net.Addr) error {
+	tcpAdr, ok := lisAdr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("mdnsAdvertise: %s isn't a TCP address", lisAdr)
+	}
+
+	nd.MDNSMtx.Lock()
+	defer nd.MDNSMtx.Unlock()
+	if nd.MDNSAd == nil {
+		var idPub [33]byte
+		copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+		ad, err := mdns.Advertise(lnutil.LitAdrFromPubkey(idPub))
+		if err != nil {
+			return err
+		}
+		nd.MDNSAd = ad
+	}
+	nd.MDNSAd.AddPort(uint16(tcpAdr.Port))
+	return nil
+}
+
+// DiscoverLocal finds lit nodes on the local network, other than us.
+func (nd *LitNode) DiscoverLocal() ([]mdns.Node, error) {
+	nodes, err := mdns.Discover(mdnsDiscoverTime)
+	if err != nil {
+		return nil, err
+	}
+	var idPub [33]byte
+	copy(idPub[:], nd.IdKey().PubKey().SerializeCompressed())
+	me := lnutil.LitAdrFromPubkey(idPub)
+
+	var others []mdns.Node
+	for _, n := range nodes {
+		if n.LitAdr != me {
+			others = append(others, n)
+		}
+	}
+	return others, nil
+}
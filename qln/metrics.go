@@ -0,0 +1,141 @@
+package qln
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/mit-dci/lit/chainhook"
+	"github.com/mit-dci/lit/kvdb"
+)
+
+// nodeCounters count things that happen, for metrics.  All 64-bit, and
+// only used atomically.
+type nodeCounters struct {
+	pushesSent     int64
+	pushesReceived int64
+	htlcs          [4]int64 // committed HTLC ops, by op
+	justiceSent    int64    // our own justice sweeps
+}
+
+// CoinMetrics is how a wallet's doing.
+type CoinMetrics struct {
+	CoinType     uint32
+	SyncHeight   int32
+	TipHeight    int32 // of the chain, as far as the chainhook knows; 0 if it can't say
+	OpenChannels int
+}
+
+// Metrics is a snapshot of the node's health, for the metrics endpoint.
+type Metrics struct {
+	PeersInbound, PeersOutbound int
+
+	PushesSent, PushesReceived int64
+	// HTLCs committed, by op: add, settle, fail.  lit doesn't forward
+	// payments between channels; HTLCs are for swaps.
+	HTLCsAdded, HTLCsSettled, HTLCsFailed int64
+	// justice txs we sent for our channels, and the tower for others'
+	JusticeSent, TowerJusticeSent int64
+
+	OmniOut, OmniIn, UserMessages int
+	PeerOutbox                    int // messages waiting for all peers' writers
+
+	Coins []CoinMetrics
+	// bytes on disk, by db file, relative to the lit folder
+	DBSizes map[string]int64
+}
+
+// Metrics gathers a snapshot of counters and gauges.
+func (nd *LitNode) Metrics() Metrics {
+	c := &nd.counters
+	m := Metrics{
+		PushesSent:     atomic.LoadInt64(&c.pushesSent),
+		PushesReceived: atomic.LoadInt64(&c.pushesReceived),
+		HTLCsAdded:     atomic.LoadInt64(&c.htlcs[1]),
+		HTLCsSettled:   atomic.LoadInt64(&c.htlcs[2]),
+		HTLCsFailed:    atomic.LoadInt64(&c.htlcs[3]),
+		JusticeSent:    atomic.LoadInt64(&c.justiceSent),
+		OmniOut:        len(nd.OmniOut),
+		OmniIn:         len(nd.OmniIn),
+		UserMessages:   len(nd.UserMessageBox),
+		DBSizes:        dbSizes(nd.LitFolder),
+	}
+	if nd.Tower != nil {
+		m.TowerJusticeSent = nd.Tower.JusticeSent()
+	}
+
+	for _, peer := range nd.peerList() {
+		if peer.Inbound {
+			m.PeersInbound++
+		} else {
+			m.PeersOutbound++
+		}
+		for i := range peer.outbox {
+			m.PeerOutbox += len(peer.outbox[i])
+		}
+	}
+
+	open, _ := nd.openChannelCounts()
+	for coin, wal := range nd.SubWallet {
+		cm := CoinMetrics{CoinType: coin, SyncHeight: wal.CurrentHeight(),
+			OpenChannels: open[coin]}
+		if th, ok := wal.ExportHook().(chainhook.TipHook); ok {
+			cm.TipHeight = th.TipHeight()
+		}
+		m.Coins = append(m.Coins, cm)
+	}
+	return m
+}
+
+// openChannelCounts counts channels that aren't closed, by coin, just from
+// what's stored; it doesn't load them.
+func (nd *LitNode) openChannelCounts() (map[uint32]int, error) {
+	counts := make(map[uint32]int)
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		cbk := btx.Bucket(BKTChannel)
+		if cbk == nil {
+			return nil
+		}
+		return cbk.ForEach(func(op, v []byte) error {
+			qcBucket := cbk.Bucket(op)
+			if v != nil || qcBucket == nil {
+				return nil
+			}
+			qc, err := QchanFromBytes(qcBucket.Get(KEYutxo))
+			if err != nil {
+				return nil
+			}
+			cd, err := QCloseFromBytes(qcBucket.Get(KEYqclose))
+			if err == nil && cd.Closed {
+				return nil
+			}
+			counts[qc.Coin()]++
+			return nil
+		})
+	})
+	return counts, err
+}
+
+// dbSizes gives the size of each db in the lit folder and the folders in
+// it.  Badger dbs are folders; their size is everything in them.
+func dbSizes(folder string) map[string]int64 {
+	sizes := make(map[string]int64)
+	for _, pattern := range []string{"*.db", "*/*.db"} {
+		matches, _ := filepath.Glob(filepath.Join(folder, pattern))
+		for _, path := range matches {
+			var size int64
+			filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					size += info.Size()
+				}
+				return nil
+			})
+			rel, err := filepath.Rel(folder, path)
+			if err != nil {
+				rel = path
+			}
+			sizes[rel] = size
+		}
+	}
+	return sizes
+}
@@ -3,22 +3,49 @@ package qln
 import (
 	"bytes"
 	"fmt"
-	"log"
+	"sync/atomic"
 
 	"github.com/adiabat/btcd/txscript"
 	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lndc"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 )
 
 // handles stuff that comes in over the wire.  Not user-initiated.
 func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) error {
+	if nd.TowerOnly && !towerOnlyMsg(msg.MsgType()) {
+		return fmt.Errorf("message type %x from peer %d but we're only a tower",
+			msg.MsgType(), msg.Peer())
+	}
 	switch msg.MsgType() & 0xf0 {
 	case 0x00: // TEXT MESSAGE.  SIMPLE
+		if msg.MsgType() == lnutil.MSGID_INIT {
+			return nd.InitHandler(msg.(lnutil.InitMsg), peer)
+		}
+		if msg.MsgType() == lnutil.MSGID_NODEADDR {
+			return nd.NodeAddrHandler(msg.(lnutil.NodeAddrMsg), peer)
+		}
+		if msg.MsgType() == lnutil.MSGID_NODEALIAS {
+			return nd.NodeAliasHandler(msg.(lnutil.NodeAliasMsg), peer)
+		}
+		if msg.MsgType() == lnutil.MSGID_PING || msg.MsgType() == lnutil.MSGID_PONG {
+			nd.PingHandler(msg.(lnutil.PingMsg))
+			return nil
+		}
+		if msg.MsgType() == lnutil.MSGID_CHAT_ID {
+			nd.ChatIDHandler(msg.(lnutil.ChatIDMsg))
+			return nil
+		}
+		if msg.MsgType() == lnutil.MSGID_CHAT_ACK {
+			nd.ChatAckHandler(msg.(lnutil.ChatAckMsg))
+			return nil
+		}
 		chat, ok := msg.(lnutil.ChatMsg)
 		if !ok {
 			return fmt.Errorf("can't cast to chat message")
 		}
+		nd.recordChat(msg.Peer(), chat.Text, ChatReceived)
 		nd.UserMessageBox <- fmt.Sprintf(
 			"\nmsg from %s: %s", lnutil.White(msg.Peer()), lnutil.Green(chat.Text))
 		return nil // no error
@@ -49,15 +76,7 @@ func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) er
 		//	return fmt.Errorf("Error: Got tower msg from %x but tower disabled\n",
 		//		msg.Peer())
 		//}
-		if msg.MsgType() == lnutil.MSGID_WATCH_DESC {
-			nd.Tower.NewChannel(msg.(lnutil.WatchDescMsg))
-		}
-		if msg.MsgType() == lnutil.MSGID_WATCH_STATEMSG {
-			nd.Tower.UpdateChannel(msg.(lnutil.WatchStateMsg))
-		}
-		if msg.MsgType() == lnutil.MSGID_WATCH_DELETE {
-			nd.Tower.DeleteChannel(msg.(lnutil.WatchDelMsg))
-		}
+		nd.TowerMsgHandler(msg)
 
 	case 0x70: // Routing messages
 		if msg.MsgType() == lnutil.MSGID_LINK_DESC {
@@ -84,6 +103,48 @@ func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) er
 		if msg.MsgType() == lnutil.MSGID_DLC_SIGPROOF {
 			nd.DlcSigProofHandler(msg.(lnutil.DlcContractSigProofMsg), peer)
 		}
+
+	case 0xA0: // Atomic swap messages
+		switch msg := msg.(type) {
+		case lnutil.SwapOfferMsg:
+			return nd.SwapOfferHandler(msg, peer)
+		case lnutil.SwapReplyMsg:
+			return nd.SwapReplyHandler(msg, peer)
+		case lnutil.SubSwapOfferMsg:
+			return nd.SubSwapOfferHandler(msg, peer)
+		case lnutil.SubSwapReplyMsg:
+			return nd.SubSwapReplyHandler(msg, peer)
+		case lnutil.SubSwapFundMsg:
+			return nd.SubSwapFundHandler(msg, peer)
+		}
+		return fmt.Errorf("Unknown swap message id %x", msg.MsgType())
+
+	case 0xB0: // Remote control messages
+		switch msg := msg.(type) {
+		case lnutil.RemoteRPCMsg:
+			nd.RemoteRPCHandler(msg, peer)
+			return nil
+		case lnutil.RemoteRPCReplyMsg:
+			nd.RemoteRPCReplyHandler(msg)
+			return nil
+		}
+		return fmt.Errorf("Unknown remote control message id %x", msg.MsgType())
+
+	case 0xC0: // Apps' custom messages
+		if msg.MsgType() == lnutil.MSGID_CUSTOM {
+			nd.CustomMsgHandler(msg.(lnutil.CustomMsg), peer)
+			return nil
+		}
+		return fmt.Errorf("Unknown custom message id %x", msg.MsgType())
+
+	case 0xD0: // Payment requests
+		switch msg := msg.(type) {
+		case lnutil.PayReqMsg:
+			return nd.PayReqHandler(msg)
+		case lnutil.PayReqDeclineMsg:
+			return nd.PayReqDeclineHandler(msg)
+		}
+		return fmt.Errorf("Unknown payment request message id %x", msg.MsgType())
 	default:
 		return fmt.Errorf("Unknown message id byte %x &f0", msg.MsgType())
 
@@ -97,67 +158,74 @@ func (nd *LitNode) PeerHandler(msg lnutil.LitMsg, q *Qchan, peer *RemotePeer) er
 func (nd *LitNode) LNDCReader(peer *RemotePeer) error {
 	// this is a new peer connection; load all channels for this peer
 
-	// no concurrency risk since we just got this map
 	// have this as a separate func to drop extra channels from mem
 	err := nd.PopulateQchanMap(peer)
 	if err != nil {
+		nd.peerDropped(peer)
+		peer.Con.Close()
 		return err
 	}
+	// anything they missed while they were gone, now that we know the
+	// channels it's about
+	go nd.sendPending(peer)
 	var opArr [36]byte
-	// make a local map of outpoints to channel indexes
-	peer.OpMap = make(map[[36]byte]uint32)
-	// iterate through all this peer's channels to extract outpoints
-	for _, q := range peer.QCs {
-		opArr = lnutil.OutPointToBytes(q.Op)
-		peer.OpMap[opArr] = q.Idx()
-	}
+	plog := log.With("peer", peer.Idx)
+
+	peer.heardFrom()
+	go nd.keepAlive(peer)
 
 	for {
-		msg := make([]byte, 1<<24)
-		//	log.Printf("read message from %x\n", l.RemoteLNId)
-		n, err := peer.Con.Read(msg)
+		//	log.Debugf("read message from %x\n", l.RemoteLNId)
+		msg, err := peer.Con.ReadMsg()
 		if err != nil {
-			log.Printf("read error with %d: %s\n", peer.Idx, err.Error())
-			nd.RemoteMtx.Lock()
-			delete(nd.RemoteCons, peer.Idx)
-			nd.RemoteMtx.Unlock()
+			plog.Errorf("read error: %s\n", err.Error())
+			if _, ok := err.(*lndc.FramingError); ok {
+				nd.framingPenalty(peer)
+			}
+			nd.peerDropped(peer)
 			return peer.Con.Close()
 		}
-		msg = msg[:n]
+		peer.heardFrom()
+		wireLen := len(msg)
 
-		log.Printf("decrypted message is %x\n", msg)
+		plog.Tracef("decrypted message is %x\n", msg)
+
+		// held to the same size limit as any other message
+		if len(msg) > 0 && msg[0] == lnutil.MSGID_COMPRESSED {
+			msg, err = lnutil.DecompressMsg(msg, peer.Con.MaxMsgSize)
+			if err != nil {
+				plog.Errorf("bad compressed message, skipping: %s\n", err.Error())
+				continue
+			}
+		}
 
 		var routedMsg lnutil.LitMsg
 		routedMsg, err = lnutil.LitMsgFromBytes(msg, peer.Idx)
 		if err != nil {
-			return err
+			// the framing was fine, so this is a message we can't parse,
+			// or one a newer peer has and we don't; skip it
+			plog.Errorf("bad message, skipping: %s\n", err.Error())
+			continue
 		}
+		nd.countIn(peer, routedMsg, wireLen)
 
-		log.Printf("peerIdx is %d\n", routedMsg.Peer())
-		log.Printf("routed bytes %x\n", routedMsg.Bytes())
-
-		log.Printf("message type %x\n", routedMsg.MsgType())
-
-		var chanIdx uint32
-		chanIdx = 0
+		var qc *Qchan
 		if len(msg) > 38 {
 			copy(opArr[:], msg[1:37])
-			chanCheck, ok := peer.OpMap[opArr]
-			if ok {
-				chanIdx = chanCheck
-			}
+			qc, _ = peer.qchanByOp(opArr)
 		}
 
-		log.Printf("chanIdx is %x\n", chanIdx)
-
-		if chanIdx != 0 {
-			err = nd.PeerHandler(routedMsg, peer.QCs[chanIdx], peer)
-		} else {
-			err = nd.PeerHandler(routedMsg, nil, peer)
+		mlog := plog
+		if qc != nil {
+			mlog = plog.With("chanIdx", qc.Idx())
 		}
+		mlog.Debugf("message type %x\n", routedMsg.MsgType())
+		mlog.Tracef("routed bytes %x\n", routedMsg.Bytes())
+
+		err = nd.PeerHandler(routedMsg, qc, peer)
 
 		if err != nil {
-			log.Printf("PeerHandler error with %d: %s\n", peer.Idx, err.Error())
+			mlog.Errorf("PeerHandler error: %s\n", err.Error())
 		}
 	}
 }
@@ -167,12 +235,15 @@ func (nd *LitNode) PopulateQchanMap(peer *RemotePeer) error {
 	if err != nil {
 		return err
 	}
-	// initialize map
+	// initialize maps
+	peer.mtx.Lock()
 	peer.QCs = make(map[uint32]*Qchan)
+	peer.OpMap = make(map[[36]byte]uint32)
+	peer.mtx.Unlock()
 	// populate from all channels (inefficient)
-	for i, q := range allQs {
+	for _, q := range allQs {
 		if q.Peer() == peer.Idx {
-			peer.QCs[q.Idx()] = allQs[i]
+			peer.addQchan(q)
 		}
 	}
 	return nil
@@ -181,28 +252,28 @@ func (nd *LitNode) PopulateQchanMap(peer *RemotePeer) error {
 func (nd *LitNode) ChannelHandler(msg lnutil.LitMsg, peer *RemotePeer) error {
 	switch message := msg.(type) {
 	case lnutil.PointReqMsg: // POINT REQUEST
-		log.Printf("Got point request from %x\n", message.Peer())
+		log.Debugf("Got point request from %x\n", message.Peer())
 		nd.PointReqHandler(message)
 		return nil
 
 	case lnutil.PointRespMsg: // POINT RESPONSE
-		log.Printf("Got point response from %x\n", msg.Peer())
+		log.Debugf("Got point response from %x\n", msg.Peer())
 		return nd.PointRespHandler(message)
 
 	case lnutil.ChanDescMsg: // CHANNEL DESCRIPTION
-		log.Printf("Got channel description from %x\n", msg.Peer())
+		log.Debugf("Got channel description from %x\n", msg.Peer())
 
 		nd.QChanDescHandler(message)
 		return nil
 
 	case lnutil.ChanAckMsg: // CHANNEL ACKNOWLEDGE
-		log.Printf("Got channel acknowledgement from %x\n", msg.Peer())
+		log.Debugf("Got channel acknowledgement from %x\n", msg.Peer())
 
 		nd.QChanAckHandler(message, peer)
 		return nil
 
 	case lnutil.SigProofMsg: // HERE'S YOUR CHANNEL
-		log.Printf("Got channel proof from %x\n", msg.Peer())
+		log.Debugf("Got channel proof from %x\n", msg.Peer())
 		nd.SigProofHandler(message, peer)
 		return nil
 
@@ -216,17 +287,15 @@ func (nd *LitNode) CloseHandler(msg lnutil.LitMsg) error {
 	switch message := msg.(type) { // CLOSE REQ
 
 	case lnutil.CloseReqMsg:
-		log.Printf("Got close request from %x\n", msg.Peer())
+		log.Debugf("Got close request from %x\n", msg.Peer())
 		nd.CloseReqHandler(message)
 		return nil
 
-	/* - not yet implemented
-	case lnutil.MSGID_CLOSERESP: // CLOSE RESP
-		log.Printf("Got close response from %x\n", from)
-		nd.CloseRespHandler(from, msg[1:])
-		continue
+	case lnutil.CloseRespMsg:
+		log.Debugf("Got close response from %x\n", msg.Peer())
+		nd.CloseRespHandler(message)
 		return nil
-	*/
+
 	default:
 		return fmt.Errorf("Unknown message type %x", msg.MsgType())
 	}
@@ -240,21 +309,29 @@ func (nd *LitNode) PushPullHandler(routedMsg lnutil.LitMsg, q *Qchan) error {
 	defer q.ChanMtx.Unlock()
 	switch message := routedMsg.(type) {
 	case lnutil.DeltaSigMsg:
-		log.Printf("Got DELTASIG from %x\n", routedMsg.Peer())
+		log.Debugf("Got DELTASIG from %x\n", routedMsg.Peer())
 		return nd.DeltaSigHandler(message, q)
 
 	case lnutil.SigRevMsg: // SIGNATURE AND REVOCATION
-		log.Printf("Got SIGREV from %x\n", routedMsg.Peer())
+		log.Debugf("Got SIGREV from %x\n", routedMsg.Peer())
 		return nd.SigRevHandler(message, q)
 
 	case lnutil.GapSigRevMsg: // GAP SIGNATURE AND REVOCATION
-		log.Printf("Got GapSigRev from %x\n", routedMsg.Peer())
+		log.Debugf("Got GapSigRev from %x\n", routedMsg.Peer())
 		return nd.GapSigRevHandler(message, q)
 
 	case lnutil.RevMsg: // REVOCATION
-		log.Printf("Got REV from %x\n", routedMsg.Peer())
+		log.Debugf("Got REV from %x\n", routedMsg.Peer())
 		return nd.RevHandler(message, q)
 
+	case lnutil.HTLCSigMsg: // HTLC OP AND SIGNATURE
+		log.Debugf("Got HTLCSIG from %x\n", routedMsg.Peer())
+		return nd.HTLCSigHandler(message, q)
+
+	case lnutil.DeltaDeclineMsg: // PUSH TURNED DOWN
+		log.Debugf("Got DELTADECLINE from %x\n", routedMsg.Peer())
+		return nd.DeltaDeclineHandler(message, q)
+
 	default:
 		return fmt.Errorf("Unknown message type %x", routedMsg.MsgType())
 
@@ -282,10 +359,11 @@ func (nd *LitNode) SelfPushHandler(msg lnutil.LitMsg) error { // not yet impleme
 func (nd *LitNode) OPEventHandler(OPEventChan chan lnutil.OutPointEvent) {
 	for {
 		curOPEvent := <-OPEventChan
+		nd.notifyOPEvent(curOPEvent)
 		// get all channels each time.  This is very inefficient!
 		qcs, err := nd.GetAllQchans()
 		if err != nil {
-			log.Printf("ln db error: %s", err.Error())
+			log.Errorf("ln db error: %s", err.Error())
 			continue
 		}
 		var theQ *Qchan
@@ -295,13 +373,25 @@ func (nd *LitNode) OPEventHandler(OPEventChan chan lnutil.OutPointEvent) {
 			}
 		}
 
+		// reorgs only matter for channels, which might not be confirmed
+		// anymore
+		if curOPEvent.Reorg {
+			if theQ != nil {
+				err = nd.reorgQchan(theQ, curOPEvent.Height)
+				if err != nil {
+					log.Errorf("reorgQchan error: %s\n", err.Error())
+				}
+			}
+			continue
+		}
+
 		var theC *lnutil.DlcContract
 
 		if theQ == nil {
 			// Check if this is a contract output
 			contracts, err := nd.DlcManager.ListContracts()
 			if err != nil {
-				log.Printf("contract db error: %s\n", err.Error())
+				log.Errorf("contract db error: %s\n", err.Error())
 				continue
 			}
 			for _, c := range contracts {
@@ -315,46 +405,71 @@ func (nd *LitNode) OPEventHandler(OPEventChan chan lnutil.OutPointEvent) {
 		if theC != nil {
 			err := nd.HandleContractOPEvent(theC, &curOPEvent)
 			if err != nil {
-				log.Printf("HandleContractOPEvent error: %s\n", err.Error())
+				log.Errorf("HandleContractOPEvent error: %s\n", err.Error())
 			}
 			continue
 		}
 
+		if theQ == nil {
+			// or a submarine swap's on-chain HTLC
+			ss, err := nd.subSwapByFundOp(curOPEvent.Op)
+			if err != nil {
+				log.Errorf("subswap db error: %s\n", err.Error())
+				continue
+			}
+			if ss != nil {
+				err = nd.SubSwapOPEvent(ss, &curOPEvent)
+				if err != nil {
+					log.Errorf("SubSwapOPEvent error: %s\n", err.Error())
+				}
+				continue
+			}
+		}
+
 		// end if no associated channel
 		if theQ == nil {
-			log.Printf("OPEvent %s doesn't match any channel\n",
+			log.Debugf("OPEvent %s doesn't match any channel\n",
 				curOPEvent.Op.String())
 			continue
 		}
 
 		// confirmation event
 		if curOPEvent.Tx == nil {
-			log.Printf("OP %s Confirmation event\n", curOPEvent.Op.String())
+			log.Debugf("OP %s Confirmation event\n", curOPEvent.Op.String())
 			theQ.Height = curOPEvent.Height
 			err = nd.SaveQchanUtxoData(theQ)
 			if err != nil {
-				log.Printf("SaveQchanUtxoData error: %s", err.Error())
+				log.Errorf("SaveQchanUtxoData error: %s", err.Error())
 				continue
 			}
+			if curOPEvent.Height != 0 {
+				err = nd.saveFundHeight(theQ, curOPEvent.Height)
+				if err != nil {
+					log.Errorf("saveFundHeight error: %s", err.Error())
+				}
+			}
 			// spend event (note: happens twice!)
 		} else {
-			log.Printf("OP %s Spend event\n", curOPEvent.Op.String())
+			log.Debugf("OP %s Spend event\n", curOPEvent.Op.String())
+			// the first time we see the close, maybe still in the mempool
+			firstSeen := !theQ.CloseData.Closed
 			// mark channel as closed
 			theQ.CloseData.Closed = true
 			theQ.CloseData.CloseTxid = curOPEvent.Tx.TxHash()
 			theQ.CloseData.CloseHeight = curOPEvent.Height
 			err = nd.SaveQchanUtxoData(theQ)
 			if err != nil {
-				log.Printf("SaveQchanUtxoData error: %s", err.Error())
+				log.Errorf("SaveQchanUtxoData error: %s", err.Error())
 				continue
 			}
 
 			// detect close tx outs.
 			txos, err := theQ.GetCloseTxos(curOPEvent.Tx)
 			if err != nil {
-				log.Printf("GetCloseTxos error: %s", err.Error())
+				log.Errorf("GetCloseTxos error: %s", err.Error())
 				continue
 			}
+			breach := false
 			// if you have seq=1 txos, modify the privkey...
 			// pretty ugly as we need the private key to do that.
 			for _, portxo := range txos {
@@ -377,16 +492,81 @@ func (nd *LitNode) OPEventHandler(OPEventChan chan lnutil.OutPointEvent) {
 						privBase, elkScalar[:])
 				}
 				// make this concurrent to avoid circular locking
-				go nd.SubWallet[theQ.Coin()].ExportUtxo(&portxo)
+				if portxo.Seq == 1 && firstSeen {
+					breach = true
+					go nd.sweepJustice(theQ.Coin(), portxo)
+				} else {
+					go nd.SubWallet[theQ.Coin()].ExportUtxo(&portxo)
+				}
+			}
+			if breach {
+				nd.notify(WebhookEvent{Type: WebhookBreachDetected,
+					Peer: theQ.Peer(), ChanIdx: theQ.Idx(),
+					Txid: theQ.CloseData.CloseTxid.String()})
 			}
 		}
 	}
 }
 
+// sweepJustice gives the wallet a revoked output from a bad close and
+// sweeps it right away, at a fee to get in the next block.  The bad tx may
+// still be in the mempool; the sweep can go in the same block as it.  The
+// sweep goes out every way the wallet can send it.
+func (nd *LitNode) sweepJustice(coin uint32, txo portxo.PorTxo) {
+	jlog := log.With("outpoint", txo.Op.String())
+	wal := nd.SubWallet[coin]
+	wal.ExportUtxo(&txo)
+	txid, err := wal.SweepUtxo(txo.Op, wal.FeeTarget(1))
+	if err != nil {
+		jlog.Errorf("sweepJustice error: %s\n", err.Error())
+		return
+	}
+	jlog = jlog.With("txid", txid.String())
+	tx, err := wal.GetSavedTx(txid)
+	if err == nil {
+		err = wal.PushImportant(tx)
+	}
+	if err != nil {
+		jlog.Errorf("sweepJustice error: %s\n", err.Error())
+		return
+	}
+	atomic.AddInt64(&nd.counters.justiceSent, 1)
+	jlog.Infof("sent justice tx\n")
+}
+
+// reorgQchan takes back a channel's confirmations above height, after the
+// chain reorged back to it.  A channel whose funding tx is gone can't be
+// used till it confirms again.  One whose close tx is gone keeps its watch
+// data, and its towers keep watching, till the close is back and buried.
+func (nd *LitNode) reorgQchan(q *Qchan, height int32) error {
+	var changed bool
+	if q.Height > height {
+		log.Infof("channel %d funding at height %d reorged out; inactive till it confirms\n",
+			q.Idx(), q.Height)
+		q.Height = 0
+		changed = true
+	}
+	if q.CloseData.Closed && q.CloseData.CloseHeight > height {
+		log.Infof("channel %d close at height %d reorged out; still watching\n",
+			q.Idx(), q.CloseData.CloseHeight)
+		q.CloseData.CloseHeight = 0
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return nd.SaveQchanUtxoData(q)
+}
+
 func (nd *LitNode) HandleContractOPEvent(c *lnutil.DlcContract,
 	opEvent *lnutil.OutPointEvent) error {
 
-	log.Printf("Received OPEvent for contract %d!\n", c.Idx)
+	log.Debugf("Received OPEvent for contract %d!\n", c.Idx)
+	if opEvent.Tx != nil && opEvent.Tx.TxHash() == lnutil.DlcRefundTx(c).TxHash() {
+		// refunded; the outputs pay to our wallet's change address
+		c.Status = lnutil.ContractStatusRefunded
+		return nd.DlcManager.SaveContract(c)
+	}
 	if opEvent.Tx != nil {
 		wal, ok := nd.SubWallet[c.CoinType]
 		if !ok {
@@ -410,7 +590,7 @@ func (nd *LitNode) HandleContractOPEvent(c *lnutil.DlcContract,
 			c.Status = lnutil.ContractStatusSettling
 			err := nd.DlcManager.SaveContract(c)
 			if err != nil {
-				log.Printf("HandleContractOPEvent SaveContract err %s\n", err.Error())
+				log.Errorf("HandleContractOPEvent SaveContract err %s\n", err.Error())
 				return err
 			}
 
@@ -0,0 +1,126 @@
+package qln
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/lit/nat"
+)
+
+// how long to ask the router to keep a mapping; renewed at half that
+const natLeaseTime = time.Hour
+
+// portMapping is a port we've had the router forward to us.
+type portMapping struct {
+	intPort, extPort uint16
+	quit             chan bool
+}
+
+// MapPort has the NAT router forward a port to our listener at lisAdr, and
+// keeps the mapping alive until UnmapPorts is called.
+func (nd *LitNode) MapPort(lisAdr net.Addr) error {
+	tcpAdr, ok := lisAdr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("MapPort: %s isn't a TCP address", lisAdr)
+	}
+	port := uint16(tcpAdr.Port)
+
+	nd.NatMtx.Lock()
+	defer nd.NatMtx.Unlock()
+
+	if nd.NatMapper == nil {
+		mapper, err := nat.Discover()
+		if err != nil {
+			return err
+		}
+		log.Infof("found %s router\n", mapper.Name())
+		nd.NatMapper = mapper
+	}
+
+	extPort, err := nd.NatMapper.AddPortMapping(port, port, "lit", natLeaseTime)
+	if err != nil {
+		return err
+	}
+	extIP, err := nd.NatMapper.ExternalIP()
+	if err == nil {
+		log.Debugf("%s: %s:%d forwards to port %d\n",
+			nd.NatMapper.Name(), extIP.String(), extPort, port)
+	}
+
+	pm := &portMapping{intPort: port, extPort: extPort, quit: make(chan bool)}
+	nd.NatMappings = append(nd.NatMappings, pm)
+
+	go nd.renewPortMapping(nd.NatMapper, pm)
+	return nil
+}
+
+// renewPortMapping re-adds a mapping before its lease runs out.
+func (nd *LitNode) renewPortMapping(mapper nat.Mapper, pm *portMapping) {
+	ticker := time.NewTicker(natLeaseTime / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.quit:
+			return
+		case <-ticker.C:
+		}
+		_, err := mapper.AddPortMapping(pm.intPort, pm.extPort, "lit", natLeaseTime)
+		if err != nil {
+			log.Errorf("renew %s port %d error %s\n",
+				mapper.Name(), pm.extPort, err.Error())
+		}
+	}
+}
+
+// UnmapPort removes the router port mapping for a local port, if there is
+// one.
+func (nd *LitNode) UnmapPort(port uint16) {
+	nd.NatMtx.Lock()
+	defer nd.NatMtx.Unlock()
+
+	for i, pm := range nd.NatMappings {
+		if pm.intPort != port {
+			continue
+		}
+		close(pm.quit)
+		err := nd.NatMapper.DeletePortMapping(pm.intPort, pm.extPort)
+		if err != nil {
+			log.Errorf("unmap %s port %d error %s\n",
+				nd.NatMapper.Name(), pm.extPort, err.Error())
+		}
+		nd.NatMappings = append(nd.NatMappings[:i], nd.NatMappings[i+1:]...)
+		return
+	}
+}
+
+// UnmapPorts removes all the router port mappings we made.  Called when
+// shutting down.
+func (nd *LitNode) UnmapPorts() {
+	nd.NatMtx.Lock()
+	defer nd.NatMtx.Unlock()
+
+	for _, pm := range nd.NatMappings {
+		close(pm.quit)
+		err := nd.NatMapper.DeletePortMapping(pm.intPort, pm.extPort)
+		if err != nil {
+			log.Errorf("unmap %s port %d error %s\n",
+				nd.NatMapper.Name(), pm.extPort, err.Error())
+		}
+	}
+	nd.NatMappings = nil
+}
+
+// externalPort is the port the router forwards to a local listening port,
+// which is what others have to connect to.  Without a mapping, it's the
+// same port.
+func (nd *LitNode) externalPort(port string) string {
+	nd.NatMtx.Lock()
+	defer nd.NatMtx.Unlock()
+	for _, pm := range nd.NatMappings {
+		if fmt.Sprintf("%d", pm.intPort) == port {
+			return fmt.Sprintf("%d", pm.extPort)
+		}
+	}
+	return port
+}
@@ -1,8 +1,10 @@
 package qln
 
 import (
+	"encoding/hex"
 	"fmt"
-	"log"
+	"net"
+	"strings"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/mit-dci/lit/lndc"
@@ -20,19 +22,39 @@ func (nd *LitNode) GetLisAddressAndPorts() (
 
 	lisAdr := lnutil.LitAdrFromPubkey(idPub)
 
-	nd.RemoteMtx.Lock()
+	nd.lisMtx.Lock()
 	ports := nd.LisIpPorts
-	nd.RemoteMtx.Unlock()
+	nd.lisMtx.Unlock()
 
 	return lisAdr, ports
 }
 
-// TCPListener starts a litNode listening for incoming LNDC connections
+// TCPListener starts a litNode listening for incoming LNDC connections.
+// "onion:port" makes a listener that's only reachable through its onion
+// service; it's bound to localhost and its IP isn't announced.
 func (nd *LitNode) TCPListener(
 	lisIpPort string) (string, error) {
 	idPriv := nd.IdKey()
-	listener, err := lndc.NewListener(nd.IdKey(), lisIpPort)
+
+	bindAdr := lisIpPort
+	onionOnly := strings.HasPrefix(lisIpPort, "onion:")
+	if onionOnly {
+		if nd.TorControl == "" {
+			return "", fmt.Errorf("%s needs a tor control port", lisIpPort)
+		}
+		bindAdr = "127.0.0.1:" + strings.TrimPrefix(lisIpPort, "onion:")
+	}
+
+	listener, err := lndc.NewListener(nd.IdKey(), bindAdr)
+	if err != nil {
+		return "", err
+	}
+	listener.AllowConn = nd.allowInbound
+
+	ll := &litListener{lisIpPort: lisIpPort, lis: listener, onionOnly: onionOnly}
+	err = nd.addListener(ll)
 	if err != nil {
+		listener.Close()
 		return "", err
 	}
 
@@ -41,154 +63,244 @@ func (nd *LitNode) TCPListener(
 
 	adr := lnutil.LitAdrFromPubkey(idPub)
 
-	// Don't announce on the tracker if we are communicating via SOCKS proxy
-	if nd.ProxyURL == "" {
-		err = Announce(idPriv, lisIpPort, adr, nd.TrackerURL)
+	// With a tor control port, also make an onion service for the listener.
+	// The onion address is safe to announce even when using a proxy.
+	if nd.TorControl != "" {
+		onionAdr, err := nd.MakeOnion(listener.Addr())
 		if err != nil {
-			log.Printf("Announcement error %s", err.Error())
+			nd.RemoveListener(lisIpPort)
+			return "", err
 		}
+		nd.lisMtx.Lock()
+		ll.onion = onionAdr
+		nd.lisMtx.Unlock()
+		log.Infof("Listening on onion %s\n", onionAdr)
+	}
+
+	// the local network is fine to tell even with a proxy; they can see us
+	if nd.MDNS && !onionOnly {
+		err = nd.mdnsAdvertise(listener.Addr())
+		if err != nil {
+			log.Errorf("mDNS error %s", err.Error())
+		}
+	}
+
+	// Get the router to forward the port, so people can reach what we
+	// announce.  Not fatal; maybe forwarding is already set up.
+	if nd.ProxyURL == "" && nd.NatMap && !onionOnly {
+		err = nd.MapPort(listener.Addr())
+		if err != nil {
+			log.Errorf("Port mapping error %s", err.Error())
+		}
+	}
+
+	err = nd.announce("")
+	if err != nil {
+		log.Errorf("Announcement error %s", err.Error())
 	}
 
-	log.Printf("Listening on %s\n", listener.Addr().String())
-	log.Printf("Listening with ln address: %s \n", adr)
+	log.Infof("Listening on %s\n", listener.Addr().String())
+	log.Infof("Listening with ln address: %s \n", adr)
 
 	go func() {
 		for {
-			netConn, err := listener.Accept() // this blocks
+			netConn, err := listener.AcceptTCP() // this blocks
 			if err != nil {
-				log.Printf("Listener error: %s\n", err.Error())
+				if !nd.listening(ll) {
+					return
+				}
+				log.Errorf("Listener error: %s\n", err.Error())
 				continue
 			}
-			newConn, ok := netConn.(*lndc.LNDConn)
-			if !ok {
-				log.Printf("Got something that wasn't a LNDC")
-				continue
-			}
-			log.Printf("Incoming connection from %x on %s\n",
-				newConn.RemotePub.SerializeCompressed(), newConn.RemoteAddr().String())
+			go nd.acceptPeer(listener, netConn)
+		}
+	}()
+	return adr, nil
+}
 
-			// don't save host/port for incoming connections
-			peerIdx, err := nd.GetPeerIdx(newConn.RemotePub, "")
-			if err != nil {
-				log.Printf("Listener error: %s\n", err.Error())
-				continue
-			}
+// acceptPeer does the handshake on an incoming connection and, if we'll
+// take the peer, starts it.  Runs on its own so one slow peer doesn't hold
+// up the listener; the conn is closed if anything fails.
+func (nd *LitNode) acceptPeer(listener *lndc.Listener, netConn net.Conn) {
+	newConn, err := listener.Handshake(netConn)
+	if err != nil {
+		log.Errorf("Handshake with %s failed: %s\n",
+			netConn.RemoteAddr().String(), err.Error())
+		return
+	}
+	newConn.MaxMsgSize = nd.MaxMsgSize
+	log.Infof("Incoming connection from %x on %s\n",
+		newConn.RemotePub.SerializeCompressed(), newConn.RemoteAddr().String())
+
+	var remotePub [33]byte
+	copy(remotePub[:], newConn.RemotePub.SerializeCompressed())
+	if nd.PeerBanned(remotePub) {
+		log.Infof("Rejecting banned peer %x\n", remotePub)
+		newConn.Close()
+		return
+	}
+	if !nd.inboundAllowed(remotePub) {
+		log.Infof("Rejecting peer %x, not on whitelist\n", remotePub)
+		newConn.Close()
+		return
+	}
+	err = nd.pluginHook(HookPeerConnect, PeerConnectHook{
+		PubKey: fmt.Sprintf("%x", remotePub),
+		LitAdr: lnutil.LitAdrFromPubkey(remotePub),
+		Addr:   newConn.RemoteAddr().String()})
+	if err != nil {
+		log.Infof("Rejecting peer %x: %s\n", remotePub, err.Error())
+		newConn.Close()
+		return
+	}
 
-			nickname := nd.GetNicknameFromPeerIdx(peerIdx)
+	// don't save host/port for incoming connections
+	peerIdx, err := nd.GetPeerIdx(newConn.RemotePub, "")
+	if err != nil {
+		log.Errorf("Listener error: %s\n", err.Error())
+		newConn.Close()
+		return
+	}
+	nd.touchPeer(peerIdx, "")
 
-			nd.RemoteMtx.Lock()
-			var peer RemotePeer
-			peer.Idx = peerIdx
-			peer.Con = newConn
-			peer.Nickname = nickname
-			nd.RemoteCons[peerIdx] = &peer
-			nd.RemoteMtx.Unlock()
+	nickname := nd.GetNicknameFromPeerIdx(peerIdx)
 
-			// each connection to a peer gets its own LNDCReader
-			go nd.LNDCReader(&peer)
-		}
-	}()
-	nd.RemoteMtx.Lock()
-	nd.LisIpPorts = append(nd.LisIpPorts, lisIpPort)
-	nd.RemoteMtx.Unlock()
-	return adr, nil
+	peer := new(RemotePeer)
+	peer.Idx = peerIdx
+	peer.Inbound = true
+	peer.Con = newConn
+	peer.Nickname = nickname
+	nd.startPeer(peer)
 }
 
-// DialPeer makes an outgoing connection to another node.
+// DialPeer makes an outgoing connection to another node.  The node can be
+// given by lit address, or by hex pubkey to use the BOLT #8 transport.
 func (nd *LitNode) DialPeer(connectAdr string) error {
 	var err error
 
 	// parse address and get pkh / host / port
 	who, where := lndc.SplitAdrString(connectAdr)
 
+	// a whole pubkey means noise; the lit address is only for the tracker
+	var noisePub *btcec.PublicKey
+	if len(who) == 66 {
+		noisePub, err = parseHexPub(who)
+		if err != nil {
+			return err
+		}
+		var pub [33]byte
+		copy(pub[:], noisePub.SerializeCompressed())
+		who = lnutil.LitAdrFromPubkey(pub)
+	}
+
 	// sanity check the "who" pkh string
 	if !lnutil.LitAdrOK(who) {
 		return fmt.Errorf("ln address %s invalid", who)
 	}
 
-	// If we couldn't deduce a URL, look it up on the tracker
+	// If we couldn't deduce a URL, look it up on the tracker.
+	// Try IPv4 first, falling back to IPv6 if they have it.
+	var wheres []string
 	if where == "" {
-		where, _, err = Lookup(who, nd.TrackerURL, nd.ProxyURL)
+		ipv4, ipv6, onion, err := Lookup(who, nd.TrackerURL, nd.ProxyURL)
 		if err != nil {
-			return err
+			log.Errorf("tracker lookup %s: %s\n", who, err.Error())
+		}
+		for _, w := range endpointOrder(ipv4, ipv6, onion, nd.ProxyURL != "") {
+			if w != "" {
+				wheres = append(wheres, w)
+			}
+		}
+		if len(wheres) == 0 && len(nd.DNSSeeds) > 0 {
+			wheres, err = nd.seedLookup(who)
 		}
+		if len(wheres) == 0 {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("tracker has no address for %s", who)
+		}
+	} else {
+		wheres = []string{where}
 	}
 
 	// get my private ID key
 	idPriv := nd.IdKey()
 
-	// Assign remote connection
-	newConn := new(lndc.LNDConn)
+	var newConn *lndc.LNDConn
+	for _, where = range wheres {
+		// Assign remote connection
+		newConn = new(lndc.LNDConn)
 
-	// TODO: handle IPv6 connections
-	err = newConn.Dial(idPriv, where, who, nd.ProxyURL)
+		// own tor circuits for each peer, so that no relay can link them
+		newConn.ProxyAuth = streamIsolation(who)
+		newConn.MaxMsgSize = nd.MaxMsgSize
+
+		if noisePub != nil {
+			err = newConn.DialNoise(idPriv, where, noisePub, nd.ProxyURL)
+		} else {
+			err = newConn.Dial(idPriv, where, who, nd.ProxyURL)
+		}
+		if err == nil {
+			break
+		}
+		log.Errorf("DialPeer %s@%s error %s\n", who, where, err.Error())
+	}
 	if err != nil {
 		return err
 	}
 
+	var remotePub [33]byte
+	copy(remotePub[:], newConn.RemotePub.SerializeCompressed())
+	if nd.PeerBanned(remotePub) {
+		newConn.Close()
+		return fmt.Errorf("peer %s is banned", who)
+	}
+
 	// if connect is successful, either query for already existing peer index, or
 	// if the peer is new, make a new index, and save the hostname&port
 
 	// figure out peer index, or assign new one for new peer.  Since
-	// we're connecting out, also specify the hostname&port
-	peerIdx, err := nd.GetPeerIdx(newConn.RemotePub, newConn.RemoteAddr().String())
+	// we're connecting out, also specify the hostname&port.  That's where,
+	// not the RemoteAddr, which would be the proxy if we're using one.
+	peerIdx, err := nd.GetPeerIdx(newConn.RemotePub, where)
 	if err != nil {
 		return err
 	}
+	// existing peers may have moved; remember where we found them
+	nd.touchPeer(peerIdx, where)
 
 	// also retrieve their nickname, if they have one
 	nickname := nd.GetNicknameFromPeerIdx(uint32(peerIdx))
 
-	nd.RemoteMtx.Lock()
-	var p RemotePeer
+	p := new(RemotePeer)
 	p.Con = newConn
 	p.Idx = peerIdx
 	p.Nickname = nickname
-	nd.RemoteCons[peerIdx] = &p
-	nd.RemoteMtx.Unlock()
-
-	// each connection to a peer gets its own LNDCReader
-	go nd.LNDCReader(&p)
+	nd.startPeer(p)
 
 	return nil
 }
 
-// OutMessager takes messages from the outbox and sends them to the ether. net.
-func (nd *LitNode) OutMessager() {
-	for {
-		msg := <-nd.OmniOut
-		if !nd.ConnectedToPeer(msg.Peer()) {
-			log.Printf("message type %x to peer %d but not connected\n",
-				msg.MsgType(), msg.Peer())
-			continue
-		}
-
-		//rawmsg := append([]byte{msg.MsgType()}, msg.Data...)
-		rawmsg := msg.Bytes() // automatically includes messageType
-		nd.RemoteMtx.Lock()   // not sure this is needed...
-		n, err := nd.RemoteCons[msg.Peer()].Con.Write(rawmsg)
-		if err != nil {
-			log.Printf("error writing to peer %d: %s\n", msg.Peer(), err.Error())
-		} else {
-			log.Printf("type %x %d bytes to peer %d\n", msg.MsgType(), n, msg.Peer())
-		}
-		nd.RemoteMtx.Unlock()
-	}
-}
-
 type PeerInfo struct {
 	PeerNumber uint32
 	RemoteHost string
 	Nickname   string
+	Alias      string
 }
 
 func (nd *LitNode) GetConnectedPeerList() []PeerInfo {
 	var peers []PeerInfo
-	for k, v := range nd.RemoteCons {
+	for _, v := range nd.peerList() {
 		var newPeer PeerInfo
-		newPeer.PeerNumber = k
+		newPeer.PeerNumber = v.Idx
 		newPeer.RemoteHost = v.Con.RemoteAddr().String()
-		newPeer.Nickname = v.Nickname
+		newPeer.Nickname = v.GetNickname()
+		if v.Con.RemotePub != nil {
+			var pub [33]byte
+			copy(pub[:], v.Con.RemotePub.SerializeCompressed())
+			newPeer.Alias = nd.GetAlias(pub)
+		}
 		peers = append(peers, newPeer)
 	}
 	return peers
@@ -196,9 +308,7 @@ func (nd *LitNode) GetConnectedPeerList() []PeerInfo {
 
 // ConnectedToPeer checks whether you're connected to a specific peer
 func (nd *LitNode) ConnectedToPeer(peer uint32) bool {
-	nd.RemoteMtx.Lock()
-	_, ok := nd.RemoteCons[peer]
-	nd.RemoteMtx.Unlock()
+	_, ok := nd.GetPeer(peer)
 	return ok
 }
 
@@ -207,15 +317,33 @@ func (nd *LitNode) IdKey() *btcec.PrivateKey {
 	return nd.IdentityKey
 }
 
-// SendChat sends a text string to a peer
+// SendChat sends a text string to a peer.  If they aren't connected, it's
+// kept and sent when they next are.
 func (nd *LitNode) SendChat(peer uint32, chat string) error {
-	if !nd.ConnectedToPeer(peer) {
-		return fmt.Errorf("Not connected to peer %d", peer)
+	var empty [33]byte
+	pub, _ := nd.GetPubHostFromPeerIdx(peer)
+	if pub == empty {
+		return fmt.Errorf("no peer %d", peer)
 	}
 
-	outMsg := lnutil.NewChatMsg(peer, chat)
-
-	nd.OmniOut <- outMsg
-
+	// it goes in line behind any that's queued already
+	if nd.recordChat(peer, chat, ChatQueued) == 0 {
+		return fmt.Errorf("couldn't queue chat for peer %d", peer)
+	}
+	// if they're not ready, it goes once they are
+	if !nd.chatReady(peer) {
+		log.Infof("peer %d not connected; chat queued\n", peer)
+		return nil
+	}
+	nd.sendQueuedChats(peer)
 	return nil
 }
+
+// parseHexPub parses a hex encoded compressed pubkey.
+func parseHexPub(s string) (*btcec.PublicKey, error) {
+	pubBytes, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("pubkey %s invalid: %s", s, err.Error())
+	}
+	return btcec.ParsePubKey(pubBytes, btcec.S256())
+}
@@ -0,0 +1,163 @@
+package qln
+
+import (
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// how many messages of each priority can be waiting to go to one peer.  If
+// they aren't taking messages fast enough to stay under this, they're
+// probably wedged.
+const peerOutboxSize = 64
+
+// Outgoing messages are sent in priority order, so a pile of chat or
+// gossip can't hold up a signature or revocation.  Everything that changes
+// a channel's state is in the one class, so those go out in the order they
+// were sent and a close or revocation can't overtake an earlier deltasig.
+const (
+	prioControl = iota // init, pings, and all channel state messages
+	prioPayment        // swaps, payment requests, forwards, dlc offers
+	prioBulk           // chat, gossip, watchtower data
+	numPrios
+)
+
+// msgPriority says which outbox a message goes in.
+func msgPriority(msgType uint8) int {
+	switch msgType {
+	case lnutil.MSGID_INIT, lnutil.MSGID_PING, lnutil.MSGID_PONG,
+		lnutil.MSGID_POINTREQ, lnutil.MSGID_POINTRESP, lnutil.MSGID_CHANDESC,
+		lnutil.MSGID_CHANACK, lnutil.MSGID_SIGPROOF,
+		lnutil.MSGID_CLOSEREQ, lnutil.MSGID_CLOSERESP,
+		lnutil.MSGID_DELTASIG, lnutil.MSGID_SIGREV, lnutil.MSGID_GAPSIGREV,
+		lnutil.MSGID_REV, lnutil.MSGID_HTLCSIG, lnutil.MSGID_DELTADECLINE,
+		lnutil.MSGID_DLC_CONTRACTACK, lnutil.MSGID_DLC_CONTRACTFUNDINGSIGS,
+		lnutil.MSGID_DLC_SIGPROOF:
+		return prioControl
+	case lnutil.MSGID_TEXTCHAT, lnutil.MSGID_CHAT_ID, lnutil.MSGID_CHAT_ACK,
+		lnutil.MSGID_NODEADDR, lnutil.MSGID_NODEALIAS,
+		lnutil.MSGID_LINK_DESC,
+		lnutil.MSGID_WATCH_DESC, lnutil.MSGID_WATCH_STATEMSG,
+		lnutil.MSGID_WATCH_DELETE, lnutil.MSGID_WATCH_BLOB,
+		lnutil.MSGID_WATCH_BLOBDEL, lnutil.MSGID_WATCH_ACK:
+		return prioBulk
+	}
+	// everything else; swaps, payment requests, forwards, dlc offers
+	return prioPayment
+}
+
+// startPeer puts a newly connected peer in the RemoteCons map and starts
+// its reader and writer.
+func (nd *LitNode) startPeer(peer *RemotePeer) {
+	for i := range peer.outbox {
+		peer.outbox[i] = make(chan lnutil.LitMsg, peerOutboxSize)
+	}
+	peer.quit = make(chan bool)
+	peer.initDone = make(chan bool)
+	nd.sendInit(peer)
+
+	nd.RemoteMtx.Lock()
+	nd.RemoteCons[peer.Idx] = peer
+	nd.RemoteMtx.Unlock()
+
+	go nd.peerWriter(peer)
+	// each connection to a peer gets its own LNDCReader
+	go nd.LNDCReader(peer)
+	// and if they're a tower, any states they didn't ack
+	go nd.resendWatch(peer.Idx)
+}
+
+// stop ends the peer's writer.  Ok to call more than once.
+func (p *RemotePeer) stop() {
+	p.stopOnce.Do(func() { close(p.quit) })
+}
+
+// nextMsg waits for the next message to send to the peer, highest priority
+// first.  Returns nil when the peer is stopped.
+func (p *RemotePeer) nextMsg() lnutil.LitMsg {
+	// anything waiting, in order
+	for _, box := range p.outbox {
+		select {
+		case msg := <-box:
+			return msg
+		default:
+		}
+	}
+	// nothing; wait for whatever comes first
+	select {
+	case <-p.quit:
+		return nil
+	case msg := <-p.outbox[prioControl]:
+		return msg
+	case msg := <-p.outbox[prioPayment]:
+		return msg
+	case msg := <-p.outbox[prioBulk]:
+		return msg
+	}
+}
+
+// peerWriter sends the messages in a peer's outboxes, one at a time.  Each
+// peer has its own, so a slow peer only holds up messages to itself.
+func (nd *LitNode) peerWriter(peer *RemotePeer) {
+	for {
+		msg := peer.nextMsg()
+		if msg == nil {
+			nd.savePeerOutbox(peer)
+			return
+		}
+
+		rawmsg := msg.Bytes() // automatically includes messageType
+		if len(rawmsg) >= lnutil.CompressThreshold &&
+			msgPriority(msg.MsgType()) == prioBulk && nd.willCompress(peer) {
+			rawmsg = lnutil.CompressMsg(rawmsg)
+		}
+		n, err := peer.Con.Write(rawmsg)
+		if err != nil {
+			log.Errorf("error writing to peer %d: %s\n", peer.Idx, err.Error())
+			peer.Con.Close()
+			nd.savePending(msg)
+			nd.peerDropped(peer)
+			nd.savePeerOutbox(peer)
+			return
+		}
+		nd.countOut(peer, msg, len(rawmsg))
+		switch msg.MsgType() {
+		case lnutil.MSGID_TEXTCHAT:
+			nd.chatDone(peer.Idx, ChatSent)
+		case lnutil.MSGID_CHAT_ID:
+			nd.chatDone(peer.Idx, ChatUnacked)
+		}
+		log.Debugf("type %x %d bytes to peer %d\n", msg.MsgType(), n, peer.Idx)
+	}
+}
+
+// OutMessager takes messages from the outbox and hands them to the right
+// peer's writer.  It never blocks on a peer; if a peer's outbox is full
+// they get disconnected.
+func (nd *LitNode) OutMessager() {
+	for {
+		msg := <-nd.OmniOut
+
+		peer, ok := nd.GetPeer(msg.Peer())
+		if !ok {
+			log.Warnf("message type %x to peer %d but not connected\n",
+				msg.MsgType(), msg.Peer())
+			nd.savePending(msg)
+			continue
+		}
+
+		bit, gated := msgFeature(msg.MsgType())
+		if gated && !peer.HasFeature(bit) {
+			log.Debugf("not sending message type %x to peer %d; no feature %d\n",
+				msg.MsgType(), peer.Idx, bit)
+			continue
+		}
+
+		select {
+		case peer.outbox[msgPriority(msg.MsgType())] <- msg:
+		default:
+			log.Infof("peer %d outbox full, disconnecting\n", peer.Idx)
+			nd.savePending(msg)
+			// reader sees the close and cleans up
+			peer.Con.Close()
+		}
+	}
+}
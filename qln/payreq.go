@@ -0,0 +1,421 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A payment request asks a peer we have a channel with to push us an amount,
+with a memo saying what for, like a till asking a customer to pay for a
+coffee.  There's no invoice: the request has a ref, the hash it was given
+or random, and paying it is a plain push with the ref as its data, which
+is how the asker knows which request it's for.  The peer's told about the
+request and pays or declines it; nothing happens until they do.
+*/
+
+// Payment request statuses
+const (
+	PayReqPending = iota
+	PayReqPaid
+	PayReqDeclined
+)
+
+// maxPayReqs is how many payment requests, ours and peers', we keep.
+const maxPayReqs = 1000
+
+// PayRequest is a payment request we made or got.
+type PayRequest struct {
+	ID       uint64 // ours, for picking it out; the peer's is different
+	Peer     uint32
+	Outgoing bool // we asked them to pay
+	CoinType uint32
+	Amt      int64
+	Ref      [32]byte // the pushes paying it have this as their data
+	Memo     string
+	Time     int64 // unix
+	Status   uint8
+}
+
+var payReqStatusNames = []string{"pending", "paid", "declined"}
+
+// StatusString gives the request's status as a word.
+func (p *PayRequest) StatusString() string {
+	if int(p.Status) < len(payReqStatusNames) {
+		return payReqStatusNames[p.Status]
+	}
+	return fmt.Sprintf("unknown status %d", p.Status)
+}
+
+/* payment request serialization, the value in BKTPayReqs; the key is the
+ID:
+8	time
+4	peer index
+1	outgoing
+4	coin type
+8	amount
+32	ref
+1	status
+	memo (the rest)
+*/
+
+// Bytes serializes a PayRequest, without its ID.
+func (p *PayRequest) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(lnutil.I64tB(p.Time))
+	buf.Write(lnutil.U32tB(p.Peer))
+	if p.Outgoing {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.Write(lnutil.U32tB(p.CoinType))
+	buf.Write(lnutil.I64tB(p.Amt))
+	buf.Write(p.Ref[:])
+	buf.WriteByte(p.Status)
+	buf.WriteString(p.Memo)
+	return buf.Bytes()
+}
+
+// PayRequestFromBytes deserializes a PayRequest from its key and value.
+func PayRequestFromBytes(k, v []byte) (*PayRequest, error) {
+	if len(k) != 8 || len(v) < 58 {
+		return nil, fmt.Errorf("payment request %x: %d bytes, expect 58 or more",
+			k, len(v))
+	}
+	p := new(PayRequest)
+	p.ID = lnutil.BtU64(k)
+	p.Time = lnutil.BtI64(v[:8])
+	p.Peer = lnutil.BtU32(v[8:12])
+	p.Outgoing = v[12] != 0
+	p.CoinType = lnutil.BtU32(v[13:17])
+	p.Amt = lnutil.BtI64(v[17:25])
+	copy(p.Ref[:], v[25:57])
+	p.Status = v[57]
+	p.Memo = string(v[58:])
+	return p, nil
+}
+
+// savePayRequest saves a new payment request, giving it its ID, and drops
+// the oldest once there are too many.
+func (nd *LitNode) savePayRequest(p *PayRequest) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTPayReqs)
+		if bkt == nil {
+			return fmt.Errorf("no payment requests bucket")
+		}
+		var err error
+		p.ID, err = bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		if p.ID > maxPayReqs {
+			err = bkt.Delete(lnutil.U64tB(p.ID - maxPayReqs))
+			if err != nil {
+				return err
+			}
+		}
+		return bkt.Put(lnutil.U64tB(p.ID), p.Bytes())
+	})
+}
+
+// GetPayRequest gets a payment request by ID.
+func (nd *LitNode) GetPayRequest(id uint64) (*PayRequest, error) {
+	var p *PayRequest
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTPayReqs)
+		if bkt == nil {
+			return fmt.Errorf("no payment requests bucket")
+		}
+		k := lnutil.U64tB(id)
+		v := bkt.Get(k)
+		if v == nil {
+			return fmt.Errorf("no payment request %d", id)
+		}
+		var err error
+		p, err = PayRequestFromBytes(k, v)
+		return err
+	})
+	return p, err
+}
+
+// PayRequests gives all the payment requests we have, oldest first.
+func (nd *LitNode) PayRequests() ([]*PayRequest, error) {
+	var reqs []*PayRequest
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTPayReqs)
+		if bkt == nil {
+			return fmt.Errorf("no payment requests bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			p, err := PayRequestFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			reqs = append(reqs, p)
+			return nil
+		})
+	})
+	return reqs, err
+}
+
+// setPayReqStatus changes a pending payment request's status, and errors
+// if it isn't pending, so only one thing can settle it.
+func (nd *LitNode) setPayReqStatus(id uint64, from, to uint8) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTPayReqs)
+		if bkt == nil {
+			return fmt.Errorf("no payment requests bucket")
+		}
+		k := lnutil.U64tB(id)
+		v := bkt.Get(k)
+		if v == nil {
+			return fmt.Errorf("no payment request %d", id)
+		}
+		p, err := PayRequestFromBytes(k, v)
+		if err != nil {
+			return err
+		}
+		if p.Status != from {
+			return fmt.Errorf("payment request %d is %s", id, p.StatusString())
+		}
+		p.Status = to
+		return bkt.Put(k, p.Bytes())
+	})
+}
+
+// findPayRequest finds a pending request with a peer by its ref.
+func (nd *LitNode) findPayRequest(
+	peer uint32, outgoing bool, ref [32]byte) (*PayRequest, bool) {
+	reqs, err := nd.PayRequests()
+	if err != nil {
+		log.Errorf("payment requests: %s\n", err.Error())
+		return nil, false
+	}
+	for _, p := range reqs {
+		if p.Peer == peer && p.Outgoing == outgoing && p.Ref == ref &&
+			p.Status == PayReqPending {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// payReqChan picks the channel with the peer to pay a request in: an open
+// one in the request's coin, with the most of ours in it.  It's the one in
+// ram, ready to push on.
+func (nd *LitNode) payReqChan(p *PayRequest) (*Qchan, error) {
+	peer, ok := nd.GetPeer(p.Peer)
+	if !ok {
+		return nil, fmt.Errorf("not connected to peer %d", p.Peer)
+	}
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return nil, err
+	}
+	var best *Qchan
+	for _, q := range qcs {
+		if q.Peer() != p.Peer || q.Coin() != p.CoinType || q.CloseData.Closed {
+			continue
+		}
+		if best == nil || q.State.MyAmt > best.State.MyAmt {
+			best = q
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no open coin %d channel with peer %d",
+			p.CoinType, p.Peer)
+	}
+	qc, ok := peer.GetQchan(best.Idx())
+	if !ok {
+		return nil, fmt.Errorf("peer %d doesn't have channel %d",
+			p.Peer, best.Idx())
+	}
+	// the one in ram doesn't keep its height
+	qc.Height = best.Height
+	return qc, nil
+}
+
+// RequestPayment asks a connected peer to push amt in a coin's channel,
+// with a memo.  The hash, if not empty, goes with the push paying it;
+// otherwise it's random.
+func (nd *LitNode) RequestPayment(peerIdx, coin uint32, amt int64,
+	memo string, hash [32]byte) (*PayRequest, error) {
+	if amt < 1 || amt > consts.MaxChanCapacity {
+		return nil, fmt.Errorf("amount %d; expect 1 to %d",
+			amt, consts.MaxChanCapacity)
+	}
+	if len(memo) > lnutil.MaxPayReqMemo {
+		return nil, fmt.Errorf("memo %d bytes, max %d",
+			len(memo), lnutil.MaxPayReqMemo)
+	}
+	err := lnutil.CheckPrintable(memo)
+	if err != nil {
+		return nil, err
+	}
+	peer, ok := nd.GetPeer(peerIdx)
+	if !ok {
+		return nil, fmt.Errorf("not connected to peer %d", peerIdx)
+	}
+	if !peer.HasFeature(lnutil.FeaturePayReqOptional) {
+		return nil, fmt.Errorf("peer %d doesn't do payment requests", peerIdx)
+	}
+
+	p := &PayRequest{Peer: peerIdx, Outgoing: true, CoinType: coin, Amt: amt,
+		Ref: hash, Memo: memo, Time: time.Now().Unix(), Status: PayReqPending}
+	var empty [32]byte
+	if p.Ref == empty {
+		_, err = rand.Read(p.Ref[:])
+		if err != nil {
+			return nil, err
+		}
+	} else if _, ok := nd.findPayRequest(peerIdx, true, p.Ref); ok {
+		return nil, fmt.Errorf("already asked peer %d to pay %x", peerIdx, p.Ref)
+	}
+	_, err = nd.payReqChan(p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = nd.savePayRequest(p)
+	if err != nil {
+		return nil, err
+	}
+	nd.OmniOut <- lnutil.NewPayReqMsg(peerIdx, coin, amt, p.Ref, memo)
+	return p, nil
+}
+
+// PayReqHandler saves a payment request from a peer and tells the user.
+// Ones we can't pay anyway, with no channel in the coin, are declined.
+func (nd *LitNode) PayReqHandler(msg lnutil.PayReqMsg) error {
+	p := &PayRequest{Peer: msg.Peer(), CoinType: msg.CoinType, Amt: msg.Amt,
+		Ref: msg.Ref, Memo: msg.Memo, Time: time.Now().Unix(),
+		Status: PayReqPending}
+	if p.Amt < 1 || p.Amt > consts.MaxChanCapacity {
+		return fmt.Errorf("payment request from peer %d for %d", p.Peer, p.Amt)
+	}
+	err := lnutil.CheckPrintable(p.Memo)
+	if err != nil {
+		return fmt.Errorf("payment request from peer %d: %s", p.Peer, err.Error())
+	}
+	if _, ok := nd.findPayRequest(p.Peer, false, p.Ref); ok {
+		return fmt.Errorf("payment request %x from peer %d again", p.Ref, p.Peer)
+	}
+	_, err = nd.payReqChan(p)
+	if err != nil {
+		nd.OmniOut <- lnutil.NewPayReqDeclineMsg(p.Peer, p.Ref)
+		return fmt.Errorf("declined payment request from peer %d: %s",
+			p.Peer, err.Error())
+	}
+
+	err = nd.savePayRequest(p)
+	if err != nil {
+		return err
+	}
+	nd.UserMessageBox <- fmt.Sprintf(
+		"\npayment request %d from %s: %s on coin %d for %q; payreqpay %d to pay it",
+		p.ID, lnutil.White(p.Peer), lnutil.SatoshiColor(p.Amt), p.CoinType,
+		p.Memo, p.ID)
+	return nil
+}
+
+// PayPayRequest pays a payment request from a peer, pushing in our channel
+// with them that has the most in it.  Gives the push's trace id.
+func (nd *LitNode) PayPayRequest(id uint64) (uint64, error) {
+	p, err := nd.GetPayRequest(id)
+	if err != nil {
+		return 0, err
+	}
+	if p.Outgoing {
+		return 0, fmt.Errorf("payment request %d is ours", id)
+	}
+	qc, err := nd.payReqChan(p)
+	if err != nil {
+		return 0, err
+	}
+	if p.Amt >= 1<<30 {
+		return 0, fmt.Errorf("payment request %d for %d; can only push %d",
+			id, p.Amt, 1<<30-1)
+	}
+
+	// claim it first, so it's not paid twice
+	err = nd.setPayReqStatus(id, PayReqPending, PayReqPaid)
+	if err != nil {
+		return 0, err
+	}
+	traceID, err := nd.TracedPush(qc, uint32(p.Amt), p.Ref)
+	if err != nil {
+		serr := nd.setPayReqStatus(id, PayReqPaid, PayReqPending)
+		if serr != nil {
+			log.Errorf("payment request %d: %s\n", id, serr.Error())
+		}
+		return traceID, err
+	}
+	return traceID, nil
+}
+
+// DeclinePayRequest won't pay a payment request from a peer, and tells
+// them so if they're connected.
+func (nd *LitNode) DeclinePayRequest(id uint64) error {
+	p, err := nd.GetPayRequest(id)
+	if err != nil {
+		return err
+	}
+	if p.Outgoing {
+		return fmt.Errorf("payment request %d is ours", id)
+	}
+	err = nd.setPayReqStatus(id, PayReqPending, PayReqDeclined)
+	if err != nil {
+		return err
+	}
+	if nd.ConnectedToPeer(p.Peer) {
+		nd.OmniOut <- lnutil.NewPayReqDeclineMsg(p.Peer, p.Ref)
+	}
+	return nil
+}
+
+// PayReqDeclineHandler marks our request declined.
+func (nd *LitNode) PayReqDeclineHandler(msg lnutil.PayReqDeclineMsg) error {
+	p, ok := nd.findPayRequest(msg.Peer(), true, msg.Ref)
+	if !ok {
+		return fmt.Errorf("peer %d declined payment request %x we didn't make",
+			msg.Peer(), msg.Ref)
+	}
+	err := nd.setPayReqStatus(p.ID, PayReqPending, PayReqDeclined)
+	if err != nil {
+		return err
+	}
+	nd.UserMessageBox <- fmt.Sprintf(
+		"\npeer %s declined payment request %d", lnutil.White(p.Peer), p.ID)
+	return nil
+}
+
+// payReqPushed marks our request paid if a push from the peer is for it.
+func (nd *LitNode) payReqPushed(peer, coin uint32, data [32]byte, amt int64) {
+	var empty [32]byte
+	if data == empty {
+		return
+	}
+	p, ok := nd.findPayRequest(peer, true, data)
+	if !ok {
+		return
+	}
+	if coin != p.CoinType || amt < p.Amt {
+		log.Warnf("peer %d pushed %d on coin %d for payment request %d; "+
+			"expect %d on coin %d\n", peer, amt, coin, p.ID, p.Amt, p.CoinType)
+		return
+	}
+	err := nd.setPayReqStatus(p.ID, PayReqPending, PayReqPaid)
+	if err != nil {
+		log.Errorf("payment request %d: %s\n", p.ID, err.Error())
+		return
+	}
+	nd.UserMessageBox <- fmt.Sprintf(
+		"\npeer %s paid payment request %d", lnutil.White(peer), p.ID)
+}
@@ -0,0 +1,222 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// PeerRecord is what we have saved about a peer we've connected with.
+type PeerRecord struct {
+	PeerIdx  uint32
+	LitAdr   string
+	Host     string // last host:port we reached them at; empty if unknown
+	Nickname string
+	Alias    string   // what they call themselves; empty if they haven't said
+	LastSeen int64    // unix time of the last connection, 0 if unknown
+	Channels []uint32 // indexes of open channels with the peer
+}
+
+// GetKnownPeers returns every peer in the db.
+func (nd *LitNode) GetKnownPeers() ([]PeerRecord, error) {
+	var peers []PeerRecord
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		mp := btx.Bucket(BKTPeerMap)
+		if mp == nil {
+			return nil
+		}
+		peerBkt := btx.Bucket(BKTPeers)
+		if peerBkt == nil {
+			return fmt.Errorf("no Peers")
+		}
+		aliasBkt := btx.Bucket(BKTAliases)
+		return mp.ForEach(func(idxBytes, pubBytes []byte) error {
+			var pr PeerRecord
+			pr.PeerIdx = lnutil.BtU32(idxBytes)
+
+			var pub [33]byte
+			copy(pub[:], pubBytes)
+			pr.LitAdr = lnutil.LitAdrFromPubkey(pub)
+
+			prBkt := peerBkt.Bucket(pubBytes)
+			if prBkt != nil {
+				pr.Host = string(prBkt.Get(KEYhost))
+				pr.Nickname = string(prBkt.Get(KEYnickname))
+				if aliasBkt != nil {
+					pr.Alias = aliasFromBytes(aliasBkt.Get(pubBytes))
+				}
+				seen := prBkt.Get(KEYlastSeen)
+				if len(seen) == 8 {
+					pr.LastSeen = lnutil.BtI64(seen)
+				}
+			}
+			peers = append(peers, pr)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		// no channels yet is fine
+		return peers, nil
+	}
+	for i := range peers {
+		for _, q := range qcs {
+			if q.Peer() == peers[i].PeerIdx && !q.CloseData.Closed {
+				peers[i].Channels = append(peers[i].Channels, q.Idx())
+			}
+		}
+	}
+	return peers, nil
+}
+
+// touchPeer records that we just connected with a peer, and if host is
+// given, that they can be reached there.
+func (nd *LitNode) touchPeer(idx uint32, host string) {
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		mp := btx.Bucket(BKTPeerMap)
+		if mp == nil {
+			return nil
+		}
+		pubBytes := mp.Get(lnutil.U32tB(idx))
+		peerBkt := btx.Bucket(BKTPeers)
+		if peerBkt == nil {
+			return fmt.Errorf("no Peers")
+		}
+		prBkt := peerBkt.Bucket(pubBytes)
+		if prBkt == nil {
+			return fmt.Errorf("no peer %x", pubBytes)
+		}
+
+		err := prBkt.Put(KEYlastSeen, lnutil.I64tB(time.Now().Unix()))
+		if err != nil {
+			return err
+		}
+		if host != "" {
+			return prBkt.Put(KEYhost, []byte(host))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("touchPeer %d: %s\n", idx, err.Error())
+	}
+}
+
+// DialKnownPeer connects to a peer from the db; first at the host we last
+// reached them at, then wherever the tracker says.
+func (nd *LitNode) DialKnownPeer(idx uint32) error {
+	var empty [33]byte
+	pub, host := nd.GetPubHostFromPeerIdx(idx)
+	if pub == empty {
+		return fmt.Errorf("no peer %d", idx)
+	}
+	adr := lnutil.LitAdrFromPubkey(pub)
+
+	if host != "" {
+		err := nd.DialPeer(adr + "@" + host)
+		if err == nil {
+			return nil
+		}
+		log.Errorf("dial %s@%s: %s\n", adr, host, err.Error())
+	}
+	// maybe they moved; see what the tracker says
+	return nd.DialPeer(adr)
+}
+
+// ConnectChannelPeers dials every peer we have an open channel with, so
+// that after a restart channels work without having to "con" each peer.
+func (nd *LitNode) ConnectChannelPeers() {
+	peers, err := nd.GetKnownPeers()
+	if err != nil {
+		log.Errorf("ConnectChannelPeers: %s\n", err.Error())
+		return
+	}
+	for _, pr := range peers {
+		if len(pr.Channels) == 0 || nd.ConnectedToPeer(pr.PeerIdx) {
+			continue
+		}
+		log.Debugf("connecting to channel peer %d %s\n", pr.PeerIdx, pr.LitAdr)
+		err = nd.DialKnownPeer(pr.PeerIdx)
+		if err != nil {
+			log.Warnf("couldn't connect to peer %d: %s\n", pr.PeerIdx, err.Error())
+		}
+	}
+}
+
+// GetPeer gets a connected peer.
+func (nd *LitNode) GetPeer(idx uint32) (*RemotePeer, bool) {
+	nd.RemoteMtx.RLock()
+	defer nd.RemoteMtx.RUnlock()
+	peer, ok := nd.RemoteCons[idx]
+	return peer, ok
+}
+
+// GetNickname gives the name we call the peer.
+func (p *RemotePeer) GetNickname() string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.Nickname
+}
+
+// SetNickname changes the name we call the peer.  It's saved separately.
+func (p *RemotePeer) SetNickname(nickname string) {
+	p.mtx.Lock()
+	p.Nickname = nickname
+	p.mtx.Unlock()
+}
+
+// peerList is every connected peer right now, so they can be gone through
+// without holding RemoteMtx.
+func (nd *LitNode) peerList() []*RemotePeer {
+	nd.RemoteMtx.RLock()
+	defer nd.RemoteMtx.RUnlock()
+	peers := make([]*RemotePeer, 0, len(nd.RemoteCons))
+	for _, peer := range nd.RemoteCons {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// GetQchan gets one of the peer's channels by index.
+func (p *RemotePeer) GetQchan(idx uint32) (*Qchan, bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	qc, ok := p.QCs[idx]
+	return qc, ok
+}
+
+// qchanByOp gets one of the peer's channels by outpoint.
+func (p *RemotePeer) qchanByOp(op [36]byte) (*Qchan, bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	idx, ok := p.OpMap[op]
+	if !ok {
+		return nil, false
+	}
+	qc, ok := p.QCs[idx]
+	return qc, ok
+}
+
+// addQchan adds a channel to the peer's maps.
+func (p *RemotePeer) addQchan(qc *Qchan) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.QCs[qc.Idx()] = qc
+	p.OpMap[lnutil.OutPointToBytes(qc.Op)] = qc.Idx()
+}
+
+// qchans is all the peer's channels right now.
+func (p *RemotePeer) qchans() []*Qchan {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	qcs := make([]*Qchan, 0, len(p.QCs))
+	for _, qc := range p.QCs {
+		qcs = append(qcs, qc)
+	}
+	return qcs
+}
@@ -0,0 +1,174 @@
+package qln
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// mustDeliver says whether a message changes channel state in a way that
+// the peer has to see.  If one of these can't be sent, it's saved to
+// the db and sent when the peer comes back, instead of getting dropped and
+// leaving the channel stuck halfway through an update.
+func mustDeliver(msgType uint8) bool {
+	switch msgType {
+	case lnutil.MSGID_DELTASIG, lnutil.MSGID_SIGREV,
+		lnutil.MSGID_GAPSIGREV, lnutil.MSGID_REV, lnutil.MSGID_HTLCSIG,
+		lnutil.MSGID_CLOSEREQ, lnutil.MSGID_CLOSERESP:
+		return true
+	}
+	return false
+}
+
+// pendingOp is the channel outpoint a saved message is about.  All the
+// messages mustDeliver keeps start with it.
+func pendingOp(msg lnutil.LitMsg) [36]byte {
+	var opArr [36]byte
+	copy(opArr[:], msg.Bytes()[1:37])
+	return opArr
+}
+
+// savePending saves an undeliverable message, if it's one we can't lose.
+// Chat isn't; it's queued in the chat history, to go when the peer's back.
+func (nd *LitNode) savePending(msg lnutil.LitMsg) {
+	if msg.MsgType() == lnutil.MSGID_TEXTCHAT ||
+		msg.MsgType() == lnutil.MSGID_CHAT_ID {
+		nd.chatDone(msg.Peer(), ChatQueued)
+	}
+	if !mustDeliver(msg.MsgType()) {
+		log.Warnf("dropping message type %x to peer %d\n",
+			msg.MsgType(), msg.Peer())
+		return
+	}
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		pb := btx.Bucket(BKTPending)
+		if pb == nil {
+			return fmt.Errorf("no pending bucket")
+		}
+		peerBkt, err := pb.CreateBucketIfNotExists(lnutil.U32tB(msg.Peer()))
+		if err != nil {
+			return err
+		}
+		// sequence keeps them in the order they were sent
+		seq, err := peerBkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		return peerBkt.Put(lnutil.U64tB(seq), msg.Bytes())
+	})
+	if err != nil {
+		log.Errorf("savePending: %s\n", err.Error())
+		return
+	}
+	log.Debugf("saved message type %x for peer %d\n", msg.MsgType(), msg.Peer())
+}
+
+// savePeerOutbox saves whatever is still in a stopped peer's outboxes.
+// Everything savePending keeps is in the control outbox, so they're saved
+// in the order they were sent.
+func (nd *LitNode) savePeerOutbox(peer *RemotePeer) {
+	for _, box := range peer.outbox {
+		for len(box) > 0 {
+			nd.savePending(<-box)
+		}
+	}
+}
+
+// takePending removes and returns the saved messages for a peer, in the
+// order they were sent.
+func (nd *LitNode) takePending(peerIdx uint32) ([]lnutil.LitMsg, error) {
+	var msgs []lnutil.LitMsg
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		pb := btx.Bucket(BKTPending)
+		if pb == nil {
+			return fmt.Errorf("no pending bucket")
+		}
+		peerBkt := pb.Bucket(lnutil.U32tB(peerIdx))
+		if peerBkt == nil {
+			return nil
+		}
+		err := peerBkt.ForEach(func(_, v []byte) error {
+			msg, err := lnutil.LitMsgFromBytes(v, peerIdx)
+			if err != nil {
+				// don't keep the rest from going out
+				log.Errorf("bad saved message for peer %d: %s\n",
+					peerIdx, err.Error())
+				return nil
+			}
+			msgs = append(msgs, msg)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return pb.DeleteBucket(lnutil.U32tB(peerIdx))
+	})
+	return msgs, err
+}
+
+// sendPending sends a reconnected peer the messages they missed, in the
+// order they were sent.  If they drop again before they get them, they're
+// saved again.
+//
+// A saved push/pull message is for the state the channel was in when it
+// was sent, which may not be the state it's in now.  So those aren't
+// replayed; where a channel's last one was, it gets whatever its state
+// says is owed now, or nothing if it's closed.  A close request is dropped
+// once the channel is gone or closed on chain.
+func (nd *LitNode) sendPending(peer *RemotePeer) {
+	msgs, err := nd.takePending(peer.Idx)
+	if err != nil {
+		log.Errorf("sendPending: %s\n", err.Error())
+		return
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	log.Debugf("resending %d saved messages to peer %d\n", len(msgs), peer.Idx)
+
+	last := make(map[[36]byte]int)
+	for i, msg := range msgs {
+		if msg.MsgType()&0xf0 == 0x30 {
+			last[pendingOp(msg)] = i
+		}
+	}
+	for i, msg := range msgs {
+		opArr := pendingOp(msg)
+		qc, ok := peer.qchanByOp(opArr)
+		if !ok {
+			log.Warnf("dropping saved message type %x to peer %d, no channel\n",
+				msg.MsgType(), peer.Idx)
+			continue
+		}
+		if msg.MsgType()&0xf0 == 0x30 {
+			if last[opArr] == i {
+				nd.resendState(qc)
+			}
+			continue
+		}
+		if qc.CloseData.CloseHeight != 0 {
+			continue
+		}
+		nd.OmniOut <- msg
+	}
+}
+
+// resendState sends the push/pull message a channel's state says its
+// peer is waiting for.
+func (nd *LitNode) resendState(qc *Qchan) {
+	qc.ChanMtx.Lock()
+	defer qc.ChanMtx.Unlock()
+	err := nd.ReloadQchanState(qc)
+	if err != nil {
+		log.Errorf("resendState: %s\n", err.Error())
+		return
+	}
+	if qc.CloseData.Closed {
+		return
+	}
+	err = nd.ReSendMsg(qc)
+	if err != nil {
+		log.Errorf("resendState: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,554 @@
+package qln
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/logs"
+)
+
+/*
+Plugins are programs lit starts and talks to over their stdin and stdout,
+so features only some people want can live outside lit.  They speak json-rpc
+2.0, one message a line.
+
+When lit starts a plugin it asks it for its manifest with getmanifest; the
+plugin answers with what it does:
+
+{"RPCMethods": [{"Name": "hello", "Description": "says hello"}],
+ "Subscriptions": ["payment_received"],
+ "Hooks": ["channel_accept"]}
+
+then lit sends init, with the lit folder and rpc port, so the plugin can use
+lit's own rpc.  After that:
+
+RPCMethods can be called through lit's rpc with LitRPC.PluginCall; lit
+sends the plugin a request with the method's name and the caller's params,
+and passes back the result or error.
+
+Subscriptions are event types, the same as webhooks'; lit sends each one
+as a notification (no id) with the event as params.
+
+Hooks let a plugin turn things down.  lit sends a request and waits for
+{"Result": "continue"} or {"Result": "reject", "Message": "why"}.  A plugin
+that doesn't answer within pluginHookTimeout, or pluginPushHookTimeout for
+push_accept, rejects, as does one that exits while lit waits.  push_accept
+holds up the channel's state update, so it gets less time; a push it
+rejects is declined back to the peer, which takes it back.  A plugin that's
+exited is dropped, and has no say after that.
+
+A plugin can send lit a log notification, with params {"Level", "Message"},
+to log through lit; what it writes to stderr gets logged too.  A plugin
+that exits is dropped; it isn't restarted.
+*/
+
+// plugin hooks
+const (
+	// HookPeerConnect is a peer connecting in, before it's let in.
+	HookPeerConnect = "peer_connect"
+	// HookChannelAccept is a peer funding a channel with us.
+	HookChannelAccept = "channel_accept"
+	// HookPushAccept is a push coming in.  lit has no invoices; a push,
+	// and its data, is what a payment is.
+	HookPushAccept = "push_accept"
+)
+
+const (
+	pluginStartTimeout = 30 * time.Second
+	pluginHookTimeout  = 10 * time.Second
+	// pluginPushHookTimeout is for push_accept, which holds up a state
+	// update the peer is waiting on
+	pluginPushHookTimeout = 2 * time.Second
+	pluginCallTimeout     = time.Minute
+	// pluginQueue is how many messages can wait to go to a plugin; past
+	// that, notifications are dropped.
+	pluginQueue = 1000
+)
+
+// PluginMethod is an rpc method a plugin adds.
+type PluginMethod struct {
+	Name        string
+	Description string `json:",omitempty"`
+}
+
+// PluginManifest is what a plugin says it does.
+type PluginManifest struct {
+	RPCMethods    []PluginMethod
+	Subscriptions []string
+	Hooks         []string
+}
+
+// PluginInfo is a running plugin.
+type PluginInfo struct {
+	Name string
+	Path string
+	PluginManifest
+}
+
+// pluginMsg is a json-rpc 2.0 request, notification or response.
+type pluginMsg struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *uint64         `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *pluginError    `json:"error,omitempty"`
+}
+
+type pluginError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// PeerConnectHook is peer_connect's params.
+type PeerConnectHook struct {
+	PubKey string // hex
+	LitAdr string
+	Addr   string // host:port it's connecting from
+}
+
+// ChannelAcceptHook is channel_accept's params.
+type ChannelAcceptHook struct {
+	Peer        uint32
+	CoinType    uint32
+	Capacity    int64
+	InitPayment int64 // what they're giving us to start
+	OutPoint    string
+}
+
+// PushAcceptHook is push_accept's params.
+type PushAcceptHook struct {
+	Peer    uint32
+	ChanIdx uint32
+	Amt     int64
+	Data    string // hex
+}
+
+// pluginHookReply is a plugin's answer to a hook.
+type pluginHookReply struct {
+	Result  string
+	Message string
+}
+
+// plugin is a running plugin program.
+type plugin struct {
+	name     string
+	path     string
+	manifest PluginManifest
+	cmd      *exec.Cmd
+	out      chan []byte // to its stdin
+	stopOnce sync.Once
+	done     chan struct{} // closed when it's exited
+
+	mtx     sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan pluginMsg
+}
+
+// plugins are the running plugins.
+type plugins struct {
+	mtx  sync.Mutex
+	list []*plugin
+}
+
+// pluginInit is init's params.
+type pluginInit struct {
+	LitFolder string
+	RPCPort   uint16
+}
+
+// StartPlugins starts each plugin program and gets its manifest.  If any
+// won't start, none are left running.
+func (nd *LitNode) StartPlugins(paths []string, rpcPort uint16) error {
+	for _, path := range paths {
+		p, err := nd.startPlugin(path, rpcPort)
+		if err != nil {
+			nd.StopPlugins()
+			return fmt.Errorf("plugin %s: %s", path, err.Error())
+		}
+		log.Infof("started plugin %s: %d rpc methods, subscribed to %v, hooks %v\n",
+			p.name, len(p.manifest.RPCMethods), p.manifest.Subscriptions,
+			p.manifest.Hooks)
+	}
+	return nil
+}
+
+func (nd *LitNode) startPlugin(path string, rpcPort uint16) (*plugin, error) {
+	p := &plugin{
+		name:    filepath.Base(path),
+		path:    path,
+		cmd:     exec.Command(path),
+		out:     make(chan []byte, pluginQueue),
+		done:    make(chan struct{}),
+		pending: make(map[uint64]chan pluginMsg),
+	}
+	p.cmd.Dir = nd.LitFolder
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = p.cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	plog := log.With("plugin", p.name)
+	// Wait closes the pipes, so only once they've been read to the end
+	var reading sync.WaitGroup
+	reading.Add(2)
+	go p.writeLoop(stdin)
+	go func() {
+		p.readLoop(stdout, plog)
+		reading.Done()
+	}()
+	go func() {
+		s := bufio.NewScanner(stderr)
+		for s.Scan() {
+			plog.Infof("%s\n", s.Text())
+		}
+		reading.Done()
+	}()
+	go func() {
+		reading.Wait()
+		err := p.cmd.Wait()
+		close(p.done)
+		nd.dropPlugin(p)
+		if err != nil {
+			plog.Errorf("plugin exited: %s\n", err.Error())
+		} else {
+			plog.Warnf("plugin exited\n")
+		}
+	}()
+
+	res, err := p.call("getmanifest", struct{}{}, pluginStartTimeout)
+	if err == nil {
+		err = json.Unmarshal(res, &p.manifest)
+	}
+	if err == nil {
+		err = nd.checkManifest(p.manifest)
+	}
+	if err == nil {
+		_, err = p.call("init", pluginInit{LitFolder: nd.LitFolder,
+			RPCPort: rpcPort}, pluginStartTimeout)
+	}
+	if err != nil {
+		p.stop()
+		return nil, err
+	}
+
+	nd.plugins.mtx.Lock()
+	defer nd.plugins.mtx.Unlock()
+	select {
+	case <-p.done:
+		return nil, fmt.Errorf("exited on start")
+	default:
+	}
+	nd.plugins.list = append(nd.plugins.list, p)
+	return p, nil
+}
+
+// checkManifest makes sure a plugin's manifest is something lit can do,
+// and its rpc methods aren't another plugin's.
+func (nd *LitNode) checkManifest(m PluginManifest) error {
+	for _, s := range m.Subscriptions {
+		switch s {
+		case WebhookPaymentReceived, WebhookChannelOpened, WebhookChannelClosed,
+			WebhookBreachDetected, WebhookPeerDisconnected:
+		default:
+			return fmt.Errorf("no event %s to subscribe to", s)
+		}
+	}
+	for _, h := range m.Hooks {
+		switch h {
+		case HookPeerConnect, HookChannelAccept, HookPushAccept:
+		default:
+			return fmt.Errorf("no hook %s", h)
+		}
+	}
+	nd.plugins.mtx.Lock()
+	defer nd.plugins.mtx.Unlock()
+	names := make(map[string]bool)
+	for _, p := range nd.plugins.list {
+		for _, m := range p.manifest.RPCMethods {
+			names[m.Name] = true
+		}
+	}
+	for _, m := range m.RPCMethods {
+		if m.Name == "" {
+			return fmt.Errorf("rpc method with no name")
+		}
+		if names[m.Name] {
+			return fmt.Errorf("rpc method %s is already another plugin's", m.Name)
+		}
+		names[m.Name] = true
+	}
+	return nil
+}
+
+// dropPlugin takes a plugin off the list.
+func (nd *LitNode) dropPlugin(p *plugin) {
+	nd.plugins.mtx.Lock()
+	defer nd.plugins.mtx.Unlock()
+	for i, q := range nd.plugins.list {
+		if q == p {
+			nd.plugins.list = append(nd.plugins.list[:i], nd.plugins.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// StopPlugins stops all the plugins.
+func (nd *LitNode) StopPlugins() {
+	nd.plugins.mtx.Lock()
+	list := nd.plugins.list
+	nd.plugins.list = nil
+	nd.plugins.mtx.Unlock()
+	for _, p := range list {
+		p.stop()
+	}
+}
+
+// stop closes the plugin's stdin, and kills it if that doesn't stop it.
+func (p *plugin) stop() {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.stopOnce.Do(func() { close(p.out) })
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+	}
+}
+
+// Plugins gives the running plugins.
+func (nd *LitNode) Plugins() []PluginInfo {
+	nd.plugins.mtx.Lock()
+	defer nd.plugins.mtx.Unlock()
+	infos := make([]PluginInfo, len(nd.plugins.list))
+	for i, p := range nd.plugins.list {
+		infos[i] = PluginInfo{Name: p.name, Path: p.path, PluginManifest: p.manifest}
+	}
+	return infos
+}
+
+// pluginsFor gives the plugins with a hook or subscription.
+func (nd *LitNode) pluginsFor(hook, event string) []*plugin {
+	nd.plugins.mtx.Lock()
+	defer nd.plugins.mtx.Unlock()
+	var ps []*plugin
+	for _, p := range nd.plugins.list {
+		if hook != "" && hasString(p.manifest.Hooks, hook) ||
+			event != "" && hasString(p.manifest.Subscriptions, event) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+func hasString(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CallPlugin calls a plugin's rpc method, giving its result.
+func (nd *LitNode) CallPlugin(method string, params json.RawMessage) (
+	json.RawMessage, error) {
+	nd.plugins.mtx.Lock()
+	var found *plugin
+	for _, p := range nd.plugins.list {
+		for _, m := range p.manifest.RPCMethods {
+			if m.Name == method {
+				found = p
+			}
+		}
+	}
+	nd.plugins.mtx.Unlock()
+	if found == nil {
+		return nil, fmt.Errorf("no plugin has rpc method %s", method)
+	}
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	return found.call(method, params, pluginCallTimeout)
+}
+
+// pluginHook asks each plugin with a hook whether to go ahead, giving an
+// error saying why if one says no.
+func (nd *LitNode) pluginHook(hook string, params interface{}) error {
+	timeout := pluginHookTimeout
+	if hook == HookPushAccept {
+		timeout = pluginPushHookTimeout
+	}
+	for _, p := range nd.pluginsFor(hook, "") {
+		res, err := p.call(hook, params, timeout)
+		if err != nil {
+			return fmt.Errorf("plugin %s %s: %s", p.name, hook, err.Error())
+		}
+		var reply pluginHookReply
+		err = json.Unmarshal(res, &reply)
+		if err != nil {
+			return fmt.Errorf("plugin %s %s: %s", p.name, hook, err.Error())
+		}
+		switch reply.Result {
+		case "continue":
+		case "reject":
+			return fmt.Errorf("plugin %s rejected %s: %s",
+				p.name, hook, reply.Message)
+		default:
+			return fmt.Errorf("plugin %s %s: result %q; expect continue or reject",
+				p.name, hook, reply.Result)
+		}
+	}
+	return nil
+}
+
+// notifyPlugins sends an event to the plugins subscribed to it.
+func (nd *LitNode) notifyPlugins(e WebhookEvent) {
+	for _, p := range nd.pluginsFor("", e.Type) {
+		err := p.send(pluginMsg{Method: e.Type}, e, false)
+		if err != nil {
+			log.Warnf("plugin %s %s: %s\n", p.name, e.Type, err.Error())
+		}
+	}
+}
+
+// call sends a plugin a request and waits for its result.
+func (p *plugin) call(method string, params interface{},
+	timeout time.Duration) (json.RawMessage, error) {
+	reply := make(chan pluginMsg, 1)
+	p.mtx.Lock()
+	p.nextID++
+	id := p.nextID
+	p.pending[id] = reply
+	p.mtx.Unlock()
+	defer func() {
+		p.mtx.Lock()
+		delete(p.pending, id)
+		p.mtx.Unlock()
+	}()
+
+	err := p.send(pluginMsg{ID: &id, Method: method}, params, true)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case msg := <-reply:
+		if msg.Error != nil {
+			return nil, fmt.Errorf("%s", msg.Error.Message)
+		}
+		return msg.Result, nil
+	case <-p.done:
+		return nil, fmt.Errorf("plugin exited")
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no answer in %s", timeout)
+	}
+}
+
+// send queues a message to a plugin; if wait, for as long as it takes
+// to get in the queue, otherwise not at all.
+func (p *plugin) send(msg pluginMsg, params interface{}, wait bool) (err error) {
+	msg.JSONRPC = "2.0"
+	msg.Params, err = json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// out gets closed when stopping
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("plugin stopped")
+		}
+	}()
+	if !wait {
+		select {
+		case p.out <- b:
+			return nil
+		default:
+			return fmt.Errorf("plugin backed up; dropped")
+		}
+	}
+	select {
+	case p.out <- b:
+		return nil
+	case <-p.done:
+		return fmt.Errorf("plugin exited")
+	}
+}
+
+func (p *plugin) writeLoop(w io.WriteCloser) {
+	defer w.Close()
+	for b := range p.out {
+		_, err := w.Write(append(b, '\n'))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readLoop hands responses to the calls waiting for them, and logs what
+// the plugin asks to.
+func (p *plugin) readLoop(r io.Reader, plog *logs.Logger) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<24)
+	for s.Scan() {
+		var msg pluginMsg
+		err := json.Unmarshal(s.Bytes(), &msg)
+		if err != nil {
+			plog.Warnf("bad message from plugin: %s\n", err.Error())
+			continue
+		}
+		if msg.Method == "log" {
+			var l struct{ Level, Message string }
+			json.Unmarshal(msg.Params, &l)
+			switch l.Level {
+			case "error":
+				plog.Errorf("%s\n", l.Message)
+			case "warn":
+				plog.Warnf("%s\n", l.Message)
+			case "debug":
+				plog.Debugf("%s\n", l.Message)
+			default:
+				plog.Infof("%s\n", l.Message)
+			}
+			continue
+		}
+		if msg.Method != "" || msg.ID == nil {
+			plog.Warnf("plugin sent %s; lit only takes responses and log\n",
+				s.Text())
+			continue
+		}
+		p.mtx.Lock()
+		reply, ok := p.pending[*msg.ID]
+		p.mtx.Unlock()
+		if ok {
+			// a second answer to the same call is dropped
+			select {
+			case reply <- msg:
+			default:
+			}
+		}
+	}
+}
@@ -3,7 +3,7 @@ package qln
 import (
 	"errors"
 	"fmt"
-	"log"
+	"sync/atomic"
 
 	"github.com/adiabat/btcd/wire"
 	"github.com/mit-dci/lit/consts"
@@ -131,15 +131,19 @@ func SigRevFromBytes(b []byte) (*SigRevMsg, error) {
 // based on the channel state.  It then calls the appropriate function.
 func (nd *LitNode) ReSendMsg(qc *Qchan) error {
 
-	// DeltaSig
+	// DeltaSig, or HTLCSig if it's an HTLC op
 	if qc.State.Delta < 0 {
-		log.Printf("Sending previously sent DeltaSig\n")
+		if qc.State.HTLCOp != 0 {
+			log.Debugf("Sending previously sent HTLCSig\n")
+			return nd.SendHTLCSig(qc)
+		}
+		log.Debugf("Sending previously sent DeltaSig\n")
 		return nd.SendDeltaSig(qc)
 	}
 
 	// SigRev
 	if qc.State.Delta > 0 {
-		log.Printf("Sending previously sent SigRev\n")
+		log.Debugf("Sending previously sent SigRev\n")
 		return nd.SendSigRev(qc)
 	}
 
@@ -149,6 +153,23 @@ func (nd *LitNode) ReSendMsg(qc *Qchan) error {
 
 // PushChannel initiates a state update by sending a DeltaSig
 func (nd *LitNode) PushChannel(qc *Qchan, amt uint32, data [32]byte) error {
+	_, err := nd.TracedPush(qc, amt, data)
+	return err
+}
+
+// TracedPush is PushChannel, also giving the push's trace id; 0 if it
+// didn't get far enough to have one.
+func (nd *LitNode) TracedPush(qc *Qchan, amt uint32, data [32]byte) (uint64, error) {
+	var traceID uint64
+	err := nd.pushChannel(qc, amt, data, &traceID)
+	if err != nil && traceID != 0 {
+		nd.traceFail(qc.Idx(), true, err)
+	}
+	return traceID, err
+}
+
+func (nd *LitNode) pushChannel(
+	qc *Qchan, amt uint32, data [32]byte, traceID *uint64) error {
 	// sanity checks
 	if amt >= 1<<30 {
 		return fmt.Errorf("max send 1G sat (1073741823)")
@@ -159,17 +180,11 @@ func (nd *LitNode) PushChannel(qc *Qchan, amt uint32, data [32]byte) error {
 
 	// see if channel is busy
 	// lock this channel
-	cts := false
-	for !cts {
-		qc.ChanMtx.Lock()
-		select {
-		case <-qc.ClearToSend:
-			cts = true
-		default:
-			qc.ChanMtx.Unlock()
-		}
-	}
+	qc.acquire()
 	// ClearToSend is now empty
+	*traceID = nd.traceStart(qc, int64(amt), true)
+	// from a push resent after a restart, which nothing was waiting on
+	qc.pushDeclined = ""
 
 	// reload from disk here, after unlock
 	err := nd.ReloadQchanState(qc)
@@ -196,7 +211,7 @@ func (nd *LitNode) PushChannel(qc *Qchan, amt uint32, data [32]byte) error {
 
 	// perform minOutput checks after reload
 	myNewOutputSize := (qc.State.MyAmt - int64(amt)) - qc.State.Fee
-	theirNewOutputSize := qc.Value - (qc.State.MyAmt - int64(amt)) - qc.State.Fee
+	theirNewOutputSize := qc.TheirAmt() + int64(amt) - qc.State.Fee
 
 	// check if this push would lower my balance below minBal
 	if myNewOutputSize < consts.MinOutput {
@@ -215,7 +230,7 @@ func (nd *LitNode) PushChannel(qc *Qchan, amt uint32, data [32]byte) error {
 		return fmt.Errorf(
 			"pushing %s insufficient; counterparty bal %s fee %s consts.MinOutput %s",
 			lnutil.SatoshiColor(int64(amt)),
-			lnutil.SatoshiColor(qc.Value-qc.State.MyAmt),
+			lnutil.SatoshiColor(qc.TheirAmt()),
 			lnutil.SatoshiColor(qc.State.Fee),
 			lnutil.SatoshiColor(consts.MinOutput))
 	}
@@ -232,8 +247,9 @@ func (nd *LitNode) PushChannel(qc *Qchan, amt uint32, data [32]byte) error {
 		return fmt.Errorf("Didn't send.  Recovered though, so try again!")
 	}
 
+	nd.traceStage(qc.Idx(), true, StageValidated, "")
 	qc.State.Data = data
-	log.Printf("Sending message %x", data)
+	log.Tracef("Sending message %x", data)
 
 	qc.State.Delta = int32(-amt)
 
@@ -250,8 +266,10 @@ func (nd *LitNode) PushChannel(qc *Qchan, amt uint32, data [32]byte) error {
 		return err
 	}
 	// move unlock to here so that delta is saved before
+	nd.traceStage(qc.Idx(), true, StageSaved, "")
 
-	log.Printf("PushChannel: Sending DeltaSig")
+	log.With("peer", qc.Peer(), "chanIdx", qc.Idx(), "trace", *traceID).Debugf(
+		"PushChannel: pushing %d, sending DeltaSig", amt)
 
 	err = nd.SendDeltaSig(qc)
 	if err != nil {
@@ -259,29 +277,34 @@ func (nd *LitNode) PushChannel(qc *Qchan, amt uint32, data [32]byte) error {
 		// don't clear; something is wrong with the network
 		return err
 	}
+	nd.traceStage(qc.Idx(), true, StageSigSent, "")
 
-	log.Printf("PushChannel: Done: sent DeltaSig")
+	log.Debugf("PushChannel: Done: sent DeltaSig")
 
-	log.Printf("got pre CTS... \n")
+	log.Debugf("got pre CTS... \n")
 	// block until clear to send is full again
 	qc.ChanMtx.Unlock()
 
-	cts = false
-	for !cts {
-		qc.ChanMtx.Lock()
-		select {
-		case <-qc.ClearToSend:
-			cts = true
-		default:
-			qc.ChanMtx.Unlock()
-		}
+	qc.acquire()
+
+	// the peer may have turned it down, in which case it didn't happen
+	declined := qc.pushDeclined
+	qc.pushDeclined = ""
+	if declined != "" {
+		qc.ClearToSend <- true
+		qc.ChanMtx.Unlock()
+		return fmt.Errorf("peer declined the push: %s", declined)
 	}
+	nd.traceStage(qc.Idx(), true, StageSettled, "")
 
-	log.Printf("got post CTS... \n")
+	log.Debugf("got post CTS... \n")
 	// since we cleared with that statement, fill it again before returning
 	qc.ClearToSend <- true
 	qc.ChanMtx.Unlock()
 
+	atomic.AddInt64(&nd.counters.pushesSent, 1)
+	nd.audit(AuditEvent{Type: AuditPushSent, Peer: qc.Peer(), ChanIdx: qc.Idx(),
+		Amt: int64(amt)})
 	return nil
 }
 
@@ -306,18 +329,71 @@ func (nd *LitNode) SendDeltaSig(q *Qchan) error {
 
 	outMsg := lnutil.NewDeltaSigMsg(q.Peer(), q.Op, -q.State.Delta, sig, q.State.Data)
 
-	log.Printf("Sending DeltaSig: %v", outMsg)
+	log.Debugf("Sending DeltaSig: %v", outMsg)
 
 	nd.OmniOut <- outMsg
 
 	return nil
 }
 
+// DeltaDeclineHandler takes back a push the peer turned down.  Only the
+// delta was saved when the DeltaSig went out, so reloading and clearing it
+// leaves the channel as it was, and the push sees it didn't happen.
+func (nd *LitNode) DeltaDeclineHandler(msg lnutil.DeltaDeclineMsg, qc *Qchan) error {
+	err := nd.ReloadQchanState(qc)
+	if err != nil {
+		return fmt.Errorf("DeltaDeclineHandler ReloadQchan err %s", err.Error())
+	}
+	if qc.State.Delta >= 0 || qc.State.HTLCOp != 0 || qc.State.Collision != 0 {
+		return fmt.Errorf("DeltaDeclineHandler err: chan %d has no push to take back",
+			qc.Idx())
+	}
+
+	log.Warnf("peer %d declined push of %d on chan %d: %s\n",
+		qc.Peer(), -qc.State.Delta, qc.Idx(), msg.Reason)
+	qc.State.Delta = 0
+	err = nd.SaveQchanState(qc)
+	if err != nil {
+		return fmt.Errorf("DeltaDeclineHandler SaveQchanState err %s", err.Error())
+	}
+
+	qc.pushDeclined = msg.Reason
+	if qc.pushDeclined == "" {
+		qc.pushDeclined = "no reason given"
+	}
+	// after a restart, nothing's waiting for it
+	select {
+	case qc.ClearToSend <- true:
+	default:
+	}
+	return nil
+}
+
 // DeltaSigHandler takes in a DeltaSig and responds with a SigRev (normally)
 // or a GapSigRev (if there's a collision)
 // Leaves the channel either expecting a Rev (normally) or a GapSigRev (collision)
 func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
-	log.Printf("Got DeltaSig: %v", msg)
+	log.Debugf("Got DeltaSig: %v", msg)
+
+	nd.traceStart(qc, int64(msg.Delta), false)
+	nd.traceStage(qc.Idx(), false, StageSigReceived, "")
+	err := nd.deltaSigHandler(msg, qc)
+	if err != nil {
+		nd.traceFail(qc.Idx(), false, err)
+	}
+	return err
+}
+
+func (nd *LitNode) deltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
+
+	// before anything changes, so turning it down leaves the channel as is.
+	// The peer's waiting on the push, so tell it; it puts its side back.
+	err := nd.pluginHook(HookPushAccept, PushAcceptHook{Peer: qc.Peer(),
+		ChanIdx: qc.Idx(), Amt: int64(msg.Delta), Data: fmt.Sprintf("%x", msg.Data)})
+	if err != nil {
+		nd.OmniOut <- lnutil.NewDeltaDeclineMsg(qc.Peer(), qc.Op, err.Error())
+		return fmt.Errorf("DeltaSigHandler err %s", err.Error())
+	}
 
 	var collision bool
 	//incomingDelta := uint32(math.Abs(float64(msg.Delta))) //int32 (may be negative, but should not be)
@@ -332,10 +408,10 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 		collision = true
 	}
 
-	log.Printf("COLLISION is (%t)\n", collision)
+	log.Debugf("COLLISION is (%t)\n", collision)
 
 	// load state from disk
-	err := nd.ReloadQchanState(qc)
+	err = nd.ReloadQchanState(qc)
 	if err != nil {
 		return fmt.Errorf("DeltaSigHandler ReloadQchan err %s", err.Error())
 	}
@@ -351,11 +427,20 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 			qc.Peer(), qc.Idx())
 	}
 
+	// a push beats an HTLC op; drop ours and take the push as if there
+	// were no collision.  Whoever started our op sees it didn't happen.
+	if collision && qc.State.HTLCOp != 0 {
+		log.Warnf("push collided with our HTLC op %d; dropping it\n",
+			qc.State.HTLCOp)
+		qc.State.abortHTLCOp()
+		collision = false
+	}
+
 	if collision {
 		// incoming delta saved as collision value,
 		// existing (negative) delta value retained.
 		qc.State.Collision = int32(incomingDelta)
-		log.Printf("delta sig COLLISION (%d)\n", qc.State.Collision)
+		log.Warnf("delta sig COLLISION (%d)\n", qc.State.Collision)
 	}
 
 	// detect if channel is already locked, and lock if not
@@ -373,7 +458,7 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 	//	}
 
 	if qc.State.Delta > 0 {
-		log.Printf(
+		log.Errorf(
 			"DeltaSigHandler err: chan %d delta %d, expect rev, send empty rev",
 			qc.Idx(), qc.State.Delta)
 
@@ -392,7 +477,7 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 
 	// perform consts.MinOutput check
 	theirNewOutputSize :=
-		qc.Value - (qc.State.MyAmt + int64(incomingDelta)) - qc.State.Fee
+		qc.TheirAmt() - int64(incomingDelta) - qc.State.Fee
 
 	// check if this push is takes them below minimum output size
 	if theirNewOutputSize < consts.MinOutput {
@@ -400,7 +485,7 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 		return fmt.Errorf(
 			"pushing %s reduces them too low; counterparty bal %s fee %s consts.MinOutput %s",
 			lnutil.SatoshiColor(int64(incomingDelta)),
-			lnutil.SatoshiColor(qc.Value-qc.State.MyAmt),
+			lnutil.SatoshiColor(qc.TheirAmt()),
 			lnutil.SatoshiColor(qc.State.Fee),
 			lnutil.SatoshiColor(consts.MinOutput))
 	}
@@ -410,7 +495,7 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 	// regardless of collision, raise amt
 	qc.State.MyAmt += int64(incomingDelta)
 
-	log.Printf("Got message %x", msg.Data)
+	log.Tracef("Got message %x", msg.Data)
 	qc.State.Data = msg.Data
 
 	// verify sig for the next state. only save if this works
@@ -418,6 +503,7 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 	if err != nil {
 		return fmt.Errorf("DeltaSigHandler err %s", err.Error())
 	}
+	nd.traceStage(qc.Idx(), false, StageValidated, "")
 
 	// (seems odd, but everything so far we still do in case of collision, so
 	// only check here.  If it's a collision, set, save, send gapSigRev
@@ -428,8 +514,10 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 	if err != nil {
 		return fmt.Errorf("DeltaSigHandler SaveQchanState err %s", err.Error())
 	}
+	nd.traceStage(qc.Idx(), false, StageSaved, "")
 
 	if qc.State.Collision != 0 {
+		nd.traceStage(qc.Idx(), false, StageCollision, "")
 		err = nd.SendGapSigRev(qc)
 		if err != nil {
 			return fmt.Errorf("DeltaSigHandler SendGapSigRev err %s", err.Error())
@@ -440,6 +528,13 @@ func (nd *LitNode) DeltaSigHandler(msg lnutil.DeltaSigMsg, qc *Qchan) error {
 			return fmt.Errorf("DeltaSigHandler SendSigRev err %s", err.Error())
 		}
 	}
+	nd.traceStage(qc.Idx(), false, StageSigSent, "")
+	atomic.AddInt64(&nd.counters.pushesReceived, 1)
+	nd.audit(AuditEvent{Type: AuditPushRecv, Peer: qc.Peer(), ChanIdx: qc.Idx(),
+		Amt: int64(incomingDelta)})
+	nd.notify(WebhookEvent{Type: WebhookPaymentReceived, Peer: qc.Peer(),
+		ChanIdx: qc.Idx(), Amt: int64(incomingDelta)})
+	nd.payReqPushed(qc.Peer(), qc.Coin(), msg.Data, int64(incomingDelta))
 	return nil
 }
 
@@ -482,7 +577,7 @@ func (nd *LitNode) SendGapSigRev(q *Qchan) error {
 
 	outMsg := lnutil.NewGapSigRev(q.KeyGen.Step[3]&0x7fffffff, q.Op, sig, *elk, n2ElkPoint)
 
-	log.Printf("Sending GapSigRev: %v", outMsg)
+	log.Debugf("Sending GapSigRev: %v", outMsg)
 
 	nd.OmniOut <- outMsg
 
@@ -518,7 +613,7 @@ func (nd *LitNode) SendSigRev(q *Qchan) error {
 
 	outMsg := lnutil.NewSigRev(q.KeyGen.Step[3]&0x7fffffff, q.Op, sig, *elk, n2ElkPoint)
 
-	log.Printf("Sending SigRev: %v", outMsg)
+	log.Debugf("Sending SigRev: %v", outMsg)
 
 	nd.OmniOut <- outMsg
 	return nil
@@ -527,7 +622,7 @@ func (nd *LitNode) SendSigRev(q *Qchan) error {
 // GapSigRevHandler takes in a GapSigRev, responds with a Rev, and
 // leaves the channel in a state expecting a Rev.
 func (nd *LitNode) GapSigRevHandler(msg lnutil.GapSigRevMsg, q *Qchan) error {
-	log.Printf("Got GapSigRev: %v", msg)
+	log.Debugf("Got GapSigRev: %v", msg)
 
 	// load qchan & state from DB
 	err := nd.ReloadQchanState(q)
@@ -542,6 +637,8 @@ func (nd *LitNode) GapSigRevHandler(msg lnutil.GapSigRevMsg, q *Qchan) error {
 			q.Idx(), q.State.Delta)
 	}
 
+	nd.traceStage(q.Idx(), true, StageSigReceived, "gapsigrev")
+
 	// stash for justice tx
 	prevAmt := q.State.MyAmt - int64(q.State.Collision) // myAmt before collision
 
@@ -572,7 +669,11 @@ func (nd *LitNode) GapSigRevHandler(msg lnutil.GapSigRevMsg, q *Qchan) error {
 	// go back to sequential elkpoints
 	q.State.ElkPoint = stashElkPoint
 
-	err = nd.SaveQchanState(q)
+	// for justice, have to create signature for n-2.  Remember the n-2 amount
+	si := stateIntent{StateIdx: q.State.StateIdx,
+		JusticeIdx: q.State.StateIdx - 2, JusticeAmt: prevAmt,
+		JusticeHTLC: q.State.HasHTLC}
+	err = nd.SaveQchanStateIntent(q, si)
 	if err != nil {
 		return fmt.Errorf("GapSigRevHandler err %s", err.Error())
 	}
@@ -581,15 +682,7 @@ func (nd *LitNode) GapSigRevHandler(msg lnutil.GapSigRevMsg, q *Qchan) error {
 		return fmt.Errorf("GapSigRevHandler err %s", err.Error())
 	}
 
-	// for justice, have to create signature for n-2.  Remember the n-2 amount
-
-	q.State.StateIdx -= 2
-	q.State.MyAmt = prevAmt
-
-	err = nd.BuildJusticeSig(q)
-	if err != nil {
-		log.Printf("GapSigRevHandler BuildJusticeSig err %s", err.Error())
-	}
+	nd.finishState(q, si)
 
 	return nil
 }
@@ -597,7 +690,7 @@ func (nd *LitNode) GapSigRevHandler(msg lnutil.GapSigRevMsg, q *Qchan) error {
 // SIGREVHandler takes in a SIGREV and responds with a REV (if everything goes OK)
 // Leaves the channel in a clear / rest state.
 func (nd *LitNode) SigRevHandler(msg lnutil.SigRevMsg, qc *Qchan) error {
-	log.Printf("Got SigRev: %v", msg)
+	log.Debugf("Got SigRev: %v", msg)
 
 	// load qchan & state from DB
 	err := nd.ReloadQchanState(qc)
@@ -623,10 +716,16 @@ func (nd *LitNode) SigRevHandler(msg lnutil.SigRevMsg, qc *Qchan) error {
 
 	// stash previous amount here for watchtower sig creation
 	prevAmt := qc.State.MyAmt
+	prevHasHTLC := qc.State.HasHTLC
+	htlcOp := qc.State.HTLCOp
+	if htlcOp == 0 {
+		nd.traceStage(qc.Idx(), true, StageSigReceived, "")
+	}
 
 	qc.State.StateIdx++
-	qc.State.MyAmt += int64(qc.State.Delta)
+	qc.State.stepAmt(qc.State.Delta)
 	qc.State.Delta = 0
+	qc.State.HTLCOp = 0
 
 	// first verify sig.
 	// (if elkrem ingest fails later, at least we close out with a bit more money)
@@ -646,29 +745,30 @@ func (nd *LitNode) SigRevHandler(msg lnutil.SigRevMsg, qc *Qchan) error {
 	// TODO Implement that later though.
 
 	// all verified; Save finished state to DB, puller is pretty much done.
-	err = nd.SaveQchanState(qc)
+	// After sending the rev, we go BACK to create a txid/sig pair for
+	// watchtower, with the stashed previous amount.
+	si := stateIntent{StateIdx: qc.State.StateIdx,
+		JusticeIdx: qc.State.StateIdx - 1, JusticeAmt: prevAmt,
+		JusticeHTLC: prevHasHTLC, HTLCOp: htlcOp, Ours: true}
+	err = nd.SaveQchanStateIntent(qc, si)
 	if err != nil {
 		return fmt.Errorf("SIGREVHandler err %s", err.Error())
 	}
+	if htlcOp == 0 {
+		nd.traceStage(qc.Idx(), true, StageSaved, "")
+	}
 
-	log.Printf("SIGREV OK, state %d, will send REV\n", qc.State.StateIdx)
+	log.Debugf("SIGREV OK, state %d, will send REV\n", qc.State.StateIdx)
 	err = nd.SendREV(qc)
 	if err != nil {
 		return fmt.Errorf("SIGREVHandler err %s", err.Error())
 	}
-
-	// now that we've saved & sent everything, before ending the function, we
-	// go BACK to create a txid/sig pair for watchtower.  This feels like a kindof
-	// weird way to do it.  Maybe there's a better way.
-
-	qc.State.StateIdx--
-	qc.State.MyAmt = prevAmt
-
-	err = nd.BuildJusticeSig(qc)
-	if err != nil {
-		log.Printf("SigRevHandler BuildJusticeSig err %s", err.Error())
+	if htlcOp == 0 {
+		nd.traceStage(qc.Idx(), true, StageRevoked, "")
 	}
 
+	nd.finishState(qc, si)
+
 	// done updating channel, no new messages expected.  Set clear to send
 	qc.ClearToSend <- true
 
@@ -690,7 +790,7 @@ func (nd *LitNode) SendREV(q *Qchan) error {
 
 	outMsg := lnutil.NewRevMsg(q.Peer(), q.Op, *elk, n2ElkPoint)
 
-	log.Printf("Sending Rev: %v", outMsg)
+	log.Debugf("Sending Rev: %v", outMsg)
 
 	nd.OmniOut <- outMsg
 
@@ -701,7 +801,7 @@ func (nd *LitNode) SendREV(q *Qchan) error {
 // final message in the state update process and there is no response.
 // Leaves the channel in a clear / rest state.
 func (nd *LitNode) RevHandler(msg lnutil.RevMsg, qc *Qchan) error {
-	log.Printf("Got Rev: %v", msg)
+	log.Debugf("Got Rev: %v", msg)
 
 	// load qchan & state from DB
 	err := nd.ReloadQchanState(qc)
@@ -715,37 +815,55 @@ func (nd *LitNode) RevHandler(msg lnutil.RevMsg, qc *Qchan) error {
 	}
 	// maybe this is an unexpected rev, asking us for a rev repeat
 	if qc.State.Delta < 0 {
-		log.Printf("got Rev, expected SigRev.  Re-sending last REV.\n")
+		log.Debugf("got Rev, expected SigRev.  Re-sending last REV.\n")
 		return nd.SendREV(qc)
 	}
 
 	// verify elkrem
+	push := qc.State.HTLCOp == 0
 	err = qc.AdvanceElkrem(&msg.Elk, msg.N2ElkPoint)
 	if err != nil {
-		log.Printf(" ! non-recoverable error, need to close the channel here.\n")
+		log.Errorf(" ! non-recoverable error, need to close the channel here.\n")
+		if push {
+			nd.traceFail(qc.Idx(), false, err)
+		}
 		return fmt.Errorf("REVHandler err %s", err.Error())
 	}
-	prevAmt := qc.State.MyAmt - int64(qc.State.Delta)
+	if push {
+		nd.traceStage(qc.Idx(), false, StageRevoked, "")
+	}
+	// after saving cleared updated state, go back to previous state and build
+	// the justice signature, with the stashed previous state amount
+	prevAmt, prevHasHTLC := qc.State.prevAmt(qc.State.Delta)
+	si := stateIntent{StateIdx: qc.State.StateIdx,
+		JusticeIdx: qc.State.StateIdx - 1, JusticeAmt: prevAmt,
+		JusticeHTLC: prevHasHTLC, HTLCOp: qc.State.HTLCOp}
+	if si.HTLCOp == 0 {
+		si.TowerFee = int64(qc.State.Delta)
+	}
 	qc.State.Delta = 0
+	qc.State.HTLCOp = 0
 
 	// save to DB (new elkrem & point, delta zeroed)
-	err = nd.SaveQchanState(qc)
+	err = nd.SaveQchanStateIntent(qc, si)
 	if err != nil {
+		if push {
+			nd.traceFail(qc.Idx(), false, err)
+		}
 		return fmt.Errorf("REVHandler err %s", err.Error())
 	}
-
-	// after saving cleared updated state, go back to previous state and build
-	// the justice signature
-	qc.State.StateIdx--      // back one state
-	qc.State.MyAmt = prevAmt // use stashed previous state amount
-	err = nd.BuildJusticeSig(qc)
-	if err != nil {
-		log.Printf("RevHandler BuildJusticeSig err %s", err.Error())
+	if push {
+		nd.traceStage(qc.Idx(), false, StageSaved, "")
 	}
 
+	nd.finishState(qc, si)
+
 	// got rev, assert clear to send
 	qc.ClearToSend <- true
+	if push {
+		nd.traceStage(qc.Idx(), false, StageSettled, "")
+	}
 
-	log.Printf("REV OK, state %d all clear.\n", qc.State.StateIdx)
+	log.Debugf("REV OK, state %d all clear.\n", qc.State.StateIdx)
 	return nil
 }
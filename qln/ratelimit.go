@@ -0,0 +1,131 @@
+package qln
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxInbound is how many incoming connections we allow at once
+	DefaultMaxInbound = 100
+	// DefaultInboundRate is how many new connections a source prefix can
+	// make per minute
+	DefaultInboundRate = 10
+)
+
+// inboundLimiter rate limits new connections per source prefix (a /24 for
+// IPv4, /48 for IPv6, so one host can't get around it by using its
+// neighbors' addresses).  Each prefix gets a bucket of rate tokens which
+// refills over a minute.
+type inboundLimiter struct {
+	rate    int
+	mtx     sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newInboundLimiter(rate int) *inboundLimiter {
+	il := new(inboundLimiter)
+	il.rate = rate
+	il.buckets = make(map[string]*tokenBucket)
+	return il
+}
+
+// sourcePrefix is the prefix of the IP a connection comes from.
+func sourcePrefix(adr net.Addr) string {
+	tcpAdr, ok := adr.(*net.TCPAddr)
+	if !ok {
+		return adr.String()
+	}
+	if ip4 := tcpAdr.IP.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return tcpAdr.IP.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// allow takes a token for the prefix adr is in, if there is one.
+func (il *inboundLimiter) allow(adr net.Addr) bool {
+	if il.rate <= 0 {
+		return true
+	}
+	now := time.Now()
+	prefix := sourcePrefix(adr)
+
+	il.mtx.Lock()
+	defer il.mtx.Unlock()
+
+	b, ok := il.buckets[prefix]
+	if !ok {
+		b = &tokenBucket{tokens: float64(il.rate), last: now}
+		il.buckets[prefix] = b
+	}
+	// refill
+	b.tokens += now.Sub(b.last).Minutes() * float64(il.rate)
+	if b.tokens > float64(il.rate) {
+		b.tokens = float64(il.rate)
+	}
+	b.last = now
+
+	// forget full buckets now and then, so the map doesn't grow forever
+	if len(il.buckets) > 1000 {
+		for k, ob := range il.buckets {
+			if now.Sub(ob.last) > time.Minute {
+				delete(il.buckets, k)
+			}
+		}
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SetInboundRate changes how many connections per minute we accept from
+// each source prefix; 0 for no limit.
+func (nd *LitNode) SetInboundRate(rate int) {
+	nd.inLimiter = newInboundLimiter(rate)
+}
+
+// allowInbound is the listener's check on new TCP connections, before the
+// handshake: rate limited per prefix, and capped in total.  If we're at the
+// cap, an idle inbound peer with no channels is kicked to make room.
+func (nd *LitNode) allowInbound(adr net.Addr) bool {
+	if !nd.inLimiter.allow(adr) {
+		log.Warnf("too many connections from %s, dropping\n", sourcePrefix(adr))
+		return false
+	}
+	if nd.MaxInbound <= 0 {
+		return true
+	}
+
+	inbound := 0
+	var evict *RemotePeer
+	for _, peer := range nd.peerList() {
+		if !peer.Inbound {
+			continue
+		}
+		inbound++
+		if !peer.hasOpenChannel() && (evict == nil || peer.idle() > evict.idle()) {
+			evict = peer
+		}
+	}
+
+	if inbound < nd.MaxInbound {
+		return true
+	}
+	if evict == nil {
+		log.Warnf("at %d inbound connections, dropping %s\n", inbound, adr)
+		return false
+	}
+	log.Debugf("at %d inbound connections, evicting idle peer %d\n",
+		inbound, evict.Idx)
+	evict.Con.Close()
+	return true
+}
@@ -0,0 +1,111 @@
+package qln
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// first retry after a second, doubling up to 10 minutes between tries
+	reconnectMinWait = time.Second
+	reconnectMaxWait = 10 * time.Minute
+)
+
+// reconnectWait is how long to wait before reconnect attempt number
+// attempt (starting at 0).  It doubles each time up to reconnectMaxWait,
+// then gets +/- 25% jitter so that two nodes which dropped at the same time
+// don't keep dialing each other in lockstep.
+func reconnectWait(attempt int) time.Duration {
+	wait := reconnectMaxWait
+	if attempt < 20 { // past that the shift overflows; we're at max anyway
+		wait = reconnectMinWait << uint(attempt)
+		if wait > reconnectMaxWait {
+			wait = reconnectMaxWait
+		}
+	}
+	jitter := time.Duration(rand.Int63n(int64(wait)/2)) - wait/4
+	return wait + jitter
+}
+
+// hasOpenChannel says whether any of a peer's channels aren't closed.
+func (p *RemotePeer) hasOpenChannel() bool {
+	for _, q := range p.qchans() {
+		if !q.CloseData.Closed {
+			return true
+		}
+	}
+	return false
+}
+
+// peerHasOpenChannel says whether the db has any channel with a peer that
+// isn't closed.  Unlike hasOpenChannel it sees channels that closed on
+// chain while the peer was gone.
+func (nd *LitNode) peerHasOpenChannel(peerIdx uint32) bool {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return false
+	}
+	for _, q := range qcs {
+		if q.Peer() == peerIdx && !q.CloseData.Closed {
+			return true
+		}
+	}
+	return false
+}
+
+// peerDropped is called when a connection to a peer goes away.  If we
+// have open channels with them, keep trying to dial them back.
+func (nd *LitNode) peerDropped(peer *RemotePeer) {
+	peer.stop()
+
+	nd.RemoteMtx.Lock()
+	// they may have already reconnected to us on a new connection
+	if nd.RemoteCons[peer.Idx] == peer {
+		delete(nd.RemoteCons, peer.Idx)
+		nd.notify(WebhookEvent{Type: WebhookPeerDisconnected, Peer: peer.Idx})
+	}
+	// chat they didn't ack might not have got there
+	go nd.requeueUnacked(peer.Idx)
+	if !peer.hasOpenChannel() || nd.reconnecting[peer.Idx] {
+		nd.RemoteMtx.Unlock()
+		return
+	}
+	nd.reconnecting[peer.Idx] = true
+	nd.RemoteMtx.Unlock()
+
+	go nd.reconnect(peer.Idx)
+}
+
+// reconnect dials a peer, with backoff, until we're connected to them
+// again (by our dial or theirs), or we no longer have a channel open with
+// them.
+func (nd *LitNode) reconnect(peerIdx uint32) {
+	defer func() {
+		nd.RemoteMtx.Lock()
+		delete(nd.reconnecting, peerIdx)
+		nd.RemoteMtx.Unlock()
+	}()
+
+	for attempt := 0; ; attempt++ {
+		wait := reconnectWait(attempt)
+		log.Infof("reconnecting to peer %d in %s\n", peerIdx, wait)
+		time.Sleep(wait)
+
+		if nd.ConnectedToPeer(peerIdx) {
+			return
+		}
+		if !nd.peerHasOpenChannel(peerIdx) {
+			log.Infof("no open channels with peer %d, not reconnecting\n", peerIdx)
+			return
+		}
+		pub, _ := nd.GetPubHostFromPeerIdx(peerIdx)
+		if nd.PeerBanned(pub) {
+			return
+		}
+		err := nd.DialKnownPeer(peerIdx)
+		if err == nil {
+			return
+		}
+		log.Errorf("reconnect to peer %d: %s\n", peerIdx, err.Error())
+	}
+}
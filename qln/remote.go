@@ -0,0 +1,210 @@
+package qln
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Remote control lets a paired node, like an app on a phone running its own
+lit, run rpc commands on this one over the lndc connection between them, so
+there's no rpc port to open up and secure.  A node is paired by its pubkey,
+with a scope:
+
+read   looking, like balances, channels and txs
+pay    read, and paying: pushes, sends and new addresses
+admin  anything the rpc does
+
+Which rpc methods are in which scope is up to litrpc, which runs the calls
+through RemoteRPC; qln keeps the pairings.  Every call, let through or not,
+goes in the audit log.
+*/
+
+// remote control scopes
+const (
+	RemoteScopeRead  = "read"
+	RemoteScopePay   = "pay"
+	RemoteScopeAdmin = "admin"
+)
+
+// remoteCallTimeout is how long RemoteCall waits for a reply.  Pushes and
+// funding wait on the node's own peers, so it's long.
+const remoteCallTimeout = 2 * time.Minute
+
+// RemotePairing is a pubkey paired for remote control, and what it's
+// allowed.
+type RemotePairing struct {
+	PubKey [33]byte
+	Scope  string
+}
+
+// remoteCalls are our calls to other nodes waiting on replies, by id.
+type remoteCalls struct {
+	mtx     sync.Mutex
+	nextID  uint64
+	waiting map[uint64]remoteWait
+}
+
+type remoteWait struct {
+	peer  uint32
+	reply chan lnutil.RemoteRPCReplyMsg
+}
+
+// PairRemote lets a pubkey remote control us, with scope; pairing one
+// that's paired already changes its scope.
+func (nd *LitNode) PairRemote(pub [33]byte, scope string) error {
+	switch scope {
+	case RemoteScopeRead, RemoteScopePay, RemoteScopeAdmin:
+	default:
+		return fmt.Errorf("scope %s; expect read, pay or admin", scope)
+	}
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTRemotes)
+		if bkt == nil {
+			return fmt.Errorf("no remotes bucket")
+		}
+		return bkt.Put(pub[:], []byte(scope))
+	})
+}
+
+// UnpairRemote stops a pubkey remote controlling us.
+func (nd *LitNode) UnpairRemote(pub [33]byte) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTRemotes)
+		if bkt == nil {
+			return fmt.Errorf("no remotes bucket")
+		}
+		if bkt.Get(pub[:]) == nil {
+			return fmt.Errorf("%x isn't paired", pub)
+		}
+		return bkt.Delete(pub[:])
+	})
+}
+
+// RemotePairings lists the pubkeys paired for remote control.
+func (nd *LitNode) RemotePairings() ([]RemotePairing, error) {
+	var pairings []RemotePairing
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTRemotes)
+		if bkt == nil {
+			return fmt.Errorf("no remotes bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			var rp RemotePairing
+			copy(rp.PubKey[:], k)
+			rp.Scope = string(v)
+			pairings = append(pairings, rp)
+			return nil
+		})
+	})
+	return pairings, err
+}
+
+// remoteScope is what a pubkey's paired for; empty if it isn't.
+func (nd *LitNode) remoteScope(pub [33]byte) string {
+	var scope string
+	nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTRemotes)
+		if bkt == nil {
+			return nil
+		}
+		scope = string(bkt.Get(pub[:]))
+		return nil
+	})
+	return scope
+}
+
+// RemoteRPCHandler runs an rpc call from a peer, if it's paired and the
+// scope allows it, and sends back the reply.  Calls can take a while, like
+// pushes, so they don't hold up the peer's other messages.
+func (nd *LitNode) RemoteRPCHandler(msg lnutil.RemoteRPCMsg, peer *RemotePeer) {
+	if peer.Con.RemotePub == nil {
+		return // already closed
+	}
+	var pub [33]byte
+	copy(pub[:], peer.Con.RemotePub.SerializeCompressed())
+	scope := nd.remoteScope(pub)
+
+	go func() {
+		reply, err := nd.remoteRPC(scope, msg)
+		errStr := ""
+		if err != nil {
+			errStr = err.Error()
+		}
+		nd.OmniOut <- lnutil.NewRemoteRPCReplyMsg(msg.Peer(), msg.ID, reply, errStr)
+	}()
+}
+
+func (nd *LitNode) remoteRPC(scope string, msg lnutil.RemoteRPCMsg) ([]byte, error) {
+	if scope == "" {
+		nd.audit(AuditEvent{Type: AuditRemoteRPC, Peer: msg.Peer(),
+			Detail: msg.Method + " refused; not paired"})
+		return nil, fmt.Errorf("not paired for remote control")
+	}
+	if nd.RemoteRPC == nil {
+		return nil, fmt.Errorf("remote control isn't running")
+	}
+	nd.audit(AuditEvent{Type: AuditRemoteRPC, Peer: msg.Peer(),
+		Detail: msg.Method + " as " + scope})
+	return nd.RemoteRPC(scope, msg.Method, msg.Args)
+}
+
+// RemoteCall calls an rpc method on a connected node that's paired with
+// us, with args as json, giving back its reply.
+func (nd *LitNode) RemoteCall(peerIdx uint32, method string,
+	args []byte) ([]byte, error) {
+	if !nd.ConnectedToPeer(peerIdx) {
+		return nil, fmt.Errorf("not connected to peer %d", peerIdx)
+	}
+
+	rc := &nd.remoteCalls
+	reply := make(chan lnutil.RemoteRPCReplyMsg, 1)
+	rc.mtx.Lock()
+	if rc.waiting == nil {
+		rc.waiting = make(map[uint64]remoteWait)
+	}
+	rc.nextID++
+	id := rc.nextID
+	rc.waiting[id] = remoteWait{peer: peerIdx, reply: reply}
+	rc.mtx.Unlock()
+	defer func() {
+		rc.mtx.Lock()
+		delete(rc.waiting, id)
+		rc.mtx.Unlock()
+	}()
+
+	nd.OmniOut <- lnutil.NewRemoteRPCMsg(peerIdx, id, method, args)
+
+	select {
+	case msg := <-reply:
+		if msg.Error != "" {
+			return nil, fmt.Errorf("peer %d: %s", peerIdx, msg.Error)
+		}
+		return msg.Reply, nil
+	case <-time.After(remoteCallTimeout):
+		return nil, fmt.Errorf("no reply from peer %d in %s",
+			peerIdx, remoteCallTimeout)
+	}
+}
+
+// RemoteRPCReplyHandler hands a reply to the call waiting on it.
+func (nd *LitNode) RemoteRPCReplyHandler(msg lnutil.RemoteRPCReplyMsg) {
+	rc := &nd.remoteCalls
+	rc.mtx.Lock()
+	w, ok := rc.waiting[msg.ID]
+	rc.mtx.Unlock()
+	// only the peer we called gets to answer
+	if !ok || w.peer != msg.Peer() {
+		log.Warnf("remote rpc reply %d from peer %d we didn't ask for\n",
+			msg.ID, msg.Peer())
+		return
+	}
+	select {
+	case w.reply <- msg:
+	default:
+	}
+}
@@ -88,12 +88,10 @@ func (nd *LitNode) cleanStaleChannels() {
 }
 
 func (nd *LitNode) advertiseLinks(seq uint32) {
-	nd.RemoteMtx.Lock()
-
 	var msgs []lnutil.LinkMsg
 
-	for _, peer := range nd.RemoteCons {
-		for _, q := range peer.QCs {
+	for _, peer := range nd.peerList() {
+		for _, q := range peer.qchans() {
 			if !q.CloseData.Closed && q.State.MyAmt > 0 {
 				var outmsg lnutil.LinkMsg
 				outmsg.CoinType = q.Coin()
@@ -121,18 +119,33 @@ func (nd *LitNode) advertiseLinks(seq uint32) {
 		}
 	}
 
-	nd.RemoteMtx.Unlock()
-
 	for _, msg := range msgs {
 		nd.LinkMsgHandler(msg)
 	}
 }
 
 func (nd *LitNode) LinkMsgHandler(msg lnutil.LinkMsg) {
+	if !nd.updateChannelMap(msg) {
+		return
+	}
+
+	// Rebroadcast, with no locks held; OmniOut can fill up
+	origIdx := msg.PeerIdx
+
+	for _, peer := range nd.peerList() {
+		// only to peers that want it
+		if peer.Idx != origIdx && peer.HasFeature(lnutil.FeatureGossipOptional) {
+			msg.PeerIdx = peer.Idx
+			nd.OmniOut <- msg
+		}
+	}
+}
+
+// updateChannelMap puts a link in the channel map, and says whether it's
+// news.
+func (nd *LitNode) updateChannelMap(msg lnutil.LinkMsg) bool {
 	nd.ChannelMapMtx.Lock()
 	defer nd.ChannelMapMtx.Unlock()
-	nd.RemoteMtx.Lock()
-	defer nd.RemoteMtx.Unlock()
 
 	msg.Timestamp = time.Now().Unix()
 	newChan := true
@@ -145,7 +158,7 @@ func (nd *LitNode) LinkMsgHandler(msg lnutil.LinkMsg) {
 				// This is the link we've been looking for
 				if msg.Seq <= v.Seq {
 					// Old advert
-					return
+					return false
 				}
 
 				// Update channel map
@@ -161,14 +174,5 @@ func (nd *LitNode) LinkMsgHandler(msg lnutil.LinkMsg) {
 		// New peer or new channel
 		nd.ChannelMap[msg.APKH] = append(nd.ChannelMap[msg.APKH], msg)
 	}
-
-	// Rebroadcast
-	origIdx := msg.PeerIdx
-
-	for peerIdx, _ := range nd.RemoteCons {
-		if peerIdx != origIdx {
-			msg.PeerIdx = peerIdx
-			nd.OmniOut <- msg
-		}
-	}
+	return true
 }
@@ -21,6 +21,16 @@ bytes   desc   ends at
 33	N2ElkPoint
 1	Collision
 64	Sig
+32	Data
+1	HasHTLC
+1	HTLCOp
+8	HTLC Amt
+32	HTLC RHash
+32	HTLC R
+4	HTLC Locktime
+1	HTLC Incoming
+
+States from before HTLCs stop after Data.
 
 
 note that sigs are truncated and don't have the sighash type byte at the end.
@@ -96,14 +106,44 @@ func (s *StatCom) ToBytes() ([]byte, error) {
 		return nil, err
 	}
 
+	// write the HTLC, whether or not there is one
+	err = binary.Write(&buf, binary.BigEndian, s.HasHTLC)
+	if err != nil {
+		return nil, err
+	}
+	err = buf.WriteByte(s.HTLCOp)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&buf, binary.BigEndian, s.HTLC.Amt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = buf.Write(s.HTLC.RHash[:])
+	if err != nil {
+		return nil, err
+	}
+	_, err = buf.Write(s.HTLC.R[:])
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&buf, binary.BigEndian, s.HTLC.Locktime)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&buf, binary.BigEndian, s.HTLC.Incoming)
+	if err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
 // StatComFromBytes turns 192 bytes into a StatCom
 func StatComFromBytes(b []byte) (*StatCom, error) {
 	var s StatCom
-	if len(b) < 235 || len(b) > 235 {
-		return nil, fmt.Errorf("StatComFromBytes got %d bytes, expect 203",
+	if len(b) != 235 && len(b) != 314 {
+		return nil, fmt.Errorf("StatComFromBytes got %d bytes, expect 314",
 			len(b))
 	}
 	buf := bytes.NewBuffer(b)
@@ -151,6 +191,33 @@ func StatComFromBytes(b []byte) (*StatCom, error) {
 	// copy data
 	copy(s.Data[:], buf.Next(32))
 
+	// older states stop here, with no HTLC
+	if buf.Len() == 0 {
+		return &s, nil
+	}
+	err = binary.Read(buf, binary.BigEndian, &s.HasHTLC)
+	if err != nil {
+		return nil, err
+	}
+	s.HTLCOp, err = buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(buf, binary.BigEndian, &s.HTLC.Amt)
+	if err != nil {
+		return nil, err
+	}
+	copy(s.HTLC.RHash[:], buf.Next(32))
+	copy(s.HTLC.R[:], buf.Next(32))
+	err = binary.Read(buf, binary.BigEndian, &s.HTLC.Locktime)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(buf, binary.BigEndian, &s.HTLC.Incoming)
+	if err != nil {
+		return nil, err
+	}
+
 	return &s, nil
 }
 
@@ -2,7 +2,6 @@ package qln
 
 import (
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/txscript"
@@ -13,6 +12,12 @@ import (
 
 // SignBreak signs YOUR tx, which you already have a sig for
 func (nd *LitNode) SignBreakTx(q *Qchan) (*wire.MsgTx, error) {
+	// the 2-of-2 multisig witness stack below only works for ECDSA
+	if q.SigType() != lnutil.SigTypeECDSA {
+		return nil, fmt.Errorf("SignBreakTx: sig type %d not supported yet",
+			q.SigType())
+	}
+
 	tx, err := q.BuildStateTx(true)
 	if err != nil {
 		return nil, err
@@ -40,7 +45,7 @@ func (nd *LitNode) SignBreakTx(q *Qchan) (*wire.MsgTx, error) {
 	// put the sighash all byte on the end of their signature
 	theirSig = append(theirSig, byte(txscript.SigHashAll))
 
-	log.Printf("made mysig: %x theirsig: %x\n", mySig, theirSig)
+	log.Tracef("made mysig: %x theirsig: %x\n", mySig, theirSig)
 	// add sigs to the witness stack
 	if swap {
 		tx.TxIn[0].Witness = SpendMultiSigWitStack(pre, theirSig, mySig)
@@ -77,15 +82,12 @@ func (nd *LitNode) SignSimpleClose(q *Qchan, tx *wire.MsgTx) ([64]byte, error) {
 	if err != nil {
 		return sig, err
 	}
-	// generate sig
-	mySig, err := txscript.RawTxInWitnessSignature(
-		tx, hCache, 0, q.Value, pre, txscript.SigHashAll, priv)
+	hash, err := witnessSigHash(tx, hCache, 0, q.Value, pre)
 	if err != nil {
 		return sig, err
 	}
-	// truncate sig (last byte is sighash type, always sighashAll)
-	mySig = mySig[:len(mySig)-1]
-	return sig64.SigCompress(mySig)
+	// generate sig
+	return lnutil.SignHash(q.SigType(), priv, hash)
 }
 
 // SignSettlementTx signs the given settlement tx based on the passed contract
@@ -117,6 +119,80 @@ func (nd *LitNode) SignSettlementTx(c *lnutil.DlcContract, tx *wire.MsgTx,
 	return sig64.SigCompress(mySig)
 }
 
+// VerifySettlementSigs checks the counterparty's settlement sigs, one for
+// each of the contract's divisions, as one batch.  It sets the contract's
+// funding outpoint, which the settlement txs spend.
+func (nd *LitNode) VerifySettlementSigs(c *lnutil.DlcContract,
+	sigs []lnutil.DlcContractSettlementSignature) error {
+
+	if len(sigs) != len(c.Division) {
+		return fmt.Errorf("got %d settlement sigs for %d divisions",
+			len(sigs), len(c.Division))
+	}
+
+	fundingTx, err := nd.BuildDlcFundingTransaction(c)
+	if err != nil {
+		return err
+	}
+	c.FundingOutpoint = wire.OutPoint{Hash: fundingTx.TxHash(), Index: 0}
+
+	pre, _, err := lnutil.FundTxScript(c.OurFundMultisigPub,
+		c.TheirFundMultisigPub)
+	if err != nil {
+		return err
+	}
+
+	checks := make([]lnutil.SigCheck, len(sigs))
+	for i, s := range sigs {
+		d, err := c.GetDivision(s.Outcome)
+		if err != nil {
+			return err
+		}
+		// their settlement tx is the one that isn't ours
+		tx, err := lnutil.SettlementTx(c, *d, false)
+		if err != nil {
+			return err
+		}
+		hash, err := witnessSigHash(tx, txscript.NewTxSigHashes(tx), 0,
+			c.TheirFundingAmount+c.OurFundingAmount, pre)
+		if err != nil {
+			return err
+		}
+		checks[i] = lnutil.SigCheck{SigType: lnutil.SigTypeECDSA,
+			Pub: c.TheirFundMultisigPub, Hash: hash, Sig: s.Signature}
+	}
+
+	err = lnutil.VerifyBatch(checks)
+	if err != nil {
+		return fmt.Errorf("contract %d settlement %s", c.Idx, err.Error())
+	}
+	return nil
+}
+
+// VerifyRefundSig checks the counterparty's signature for the contract's
+// refund tx. The funding outpoint has to be set already.
+func (nd *LitNode) VerifyRefundSig(c *lnutil.DlcContract, sig [64]byte) error {
+	pre, _, err := lnutil.FundTxScript(c.OurFundMultisigPub,
+		c.TheirFundMultisigPub)
+	if err != nil {
+		return err
+	}
+
+	tx := lnutil.DlcRefundTx(c)
+	hash, err := witnessSigHash(tx, txscript.NewTxSigHashes(tx), 0,
+		c.TheirFundingAmount+c.OurFundingAmount, pre)
+	if err != nil {
+		return err
+	}
+
+	err = lnutil.VerifyBatch([]lnutil.SigCheck{{SigType: lnutil.SigTypeECDSA,
+		Pub: c.TheirFundMultisigPub, Hash: hash, Sig: sig}})
+	if err != nil {
+		return fmt.Errorf("contract %d refund %s", c.Idx, err.Error())
+	}
+	return nil
+}
+
 // SignClaimTx signs the given claim tx based on the passed preimage and value
 // using the passed private key. Tx is modified in place. timeout=false means
 // it's a regular claim, timeout=true means we're claiming an output that has
@@ -181,23 +257,23 @@ func (nd *LitNode) SignState(q *Qchan) ([64]byte, error) {
 		return sig, err
 	}
 
-	// generate sig.
-	bigSig, err := txscript.RawTxInWitnessSignature(
-		tx, hCache, 0, q.Value, pre, txscript.SigHashAll, priv)
-	// truncate sig (last byte is sighash type, always sighashAll)
-	bigSig = bigSig[:len(bigSig)-1]
+	hash, err := witnessSigHash(tx, hCache, 0, q.Value, pre)
+	if err != nil {
+		return sig, err
+	}
 
-	sig, err = sig64.SigCompress(bigSig)
+	// generate sig; ECDSA or schnorr depending on the fund output
+	sig, err = lnutil.SignHash(q.SigType(), priv, hash)
 	if err != nil {
 		return sig, err
 	}
 
-	log.Printf("____ sig creation for channel (%d,%d):\n", q.Peer(), q.Idx())
-	log.Printf("\tinput %s\n", tx.TxIn[0].PreviousOutPoint.String())
+	log.Tracef("____ sig creation for channel (%d,%d):\n", q.Peer(), q.Idx())
+	log.Tracef("\tinput %s\n", tx.TxIn[0].PreviousOutPoint.String())
 	for i, txout := range tx.TxOut {
-		log.Printf("\toutput %d: %x %d\n", i, txout.PkScript, txout.Value)
+		log.Tracef("\toutput %d: %x %d\n", i, txout.PkScript, txout.Value)
 	}
-	log.Printf("\tstate %d myamt: %d theiramt: %d\n", q.State.StateIdx, q.State.MyAmt, q.Value-q.State.MyAmt)
+	log.Tracef("\tstate %d myamt: %d theiramt: %d\n", q.State.StateIdx, q.State.MyAmt, q.Value-q.State.MyAmt)
 
 	return sig, nil
 }
@@ -209,7 +285,6 @@ func (nd *LitNode) SignState(q *Qchan) ([64]byte, error) {
 // this function.
 func (q *Qchan) VerifySig(sig [64]byte) error {
 
-	bigSig := sig64.SigDecompress(sig)
 	// my tx when I'm verifying.
 	tx, err := q.BuildStateTx(true)
 	if err != nil {
@@ -224,35 +299,23 @@ func (q *Qchan) VerifySig(sig [64]byte) error {
 
 	hCache := txscript.NewTxSigHashes(tx)
 
-	parsed, err := txscript.ParseScript(pre)
-	if err != nil {
-		return err
-	}
-	// always sighash all
-	hash := txscript.CalcWitnessSignatureHash(
-		parsed, hCache, txscript.SigHashAll, tx, 0, q.Value)
-
-	// sig is pre-truncated; last byte for sighashtype is always sighashAll
-	pSig, err := btcec.ParseDERSignature(bigSig, btcec.S256())
+	hash, err := witnessSigHash(tx, hCache, 0, q.Value, pre)
 	if err != nil {
 		return err
 	}
-	theirPubKey, err := btcec.ParsePubKey(q.TheirPub[:], btcec.S256())
-	if err != nil {
-		return err
-	}
-	log.Printf("____ sig verification for channel (%d,%d):\n", q.Peer(), q.Idx())
-	log.Printf("\tinput %s\n", tx.TxIn[0].PreviousOutPoint.String())
+	log.Tracef("____ sig verification for channel (%d,%d):\n", q.Peer(), q.Idx())
+	log.Tracef("\tinput %s\n", tx.TxIn[0].PreviousOutPoint.String())
 	for i, txout := range tx.TxOut {
-		log.Printf("\toutput %d: %x %d\n", i, txout.PkScript, txout.Value)
+		log.Tracef("\toutput %d: %x %d\n", i, txout.PkScript, txout.Value)
 	}
-	log.Printf("\tstate %d myamt: %d theiramt: %d\n", q.State.StateIdx, q.State.MyAmt, q.Value-q.State.MyAmt)
-	log.Printf("\tsig: %x\n", sig)
+	log.Tracef("\tstate %d myamt: %d theiramt: %d\n", q.State.StateIdx, q.State.MyAmt, q.Value-q.State.MyAmt)
+	log.Tracef("\tsig: %x\n", sig)
 
-	worked := pSig.Verify(hash, theirPubKey)
-	if !worked {
-		return fmt.Errorf("Invalid signature on chan %d state %d",
-			q.Idx(), q.State.StateIdx)
+	// sig is pre-truncated; last byte for sighashtype is always sighashAll
+	err = lnutil.VerifyHash(q.SigType(), q.TheirPub, hash, sig)
+	if err != nil {
+		return fmt.Errorf("Invalid signature on chan %d state %d: %s",
+			q.Idx(), q.State.StateIdx, err.Error())
 	}
 
 	// copy signature, overwriting old signature.
@@ -260,3 +323,18 @@ func (q *Qchan) VerifySig(sig [64]byte) error {
 
 	return nil
 }
+
+// witnessSigHash returns the sighash-all hash to sign for input idx of tx,
+// which spends a witness script pre worth amt.
+// Only segwit v0 sighashes for now; BIP341 sighashes for taproot spends
+// will go here as well, picked by sig type.
+func witnessSigHash(tx *wire.MsgTx, hCache *txscript.TxSigHashes,
+	idx int, amt int64, pre []byte) ([]byte, error) {
+
+	parsed, err := txscript.ParseScript(pre)
+	if err != nil {
+		return nil, err
+	}
+	return txscript.CalcWitnessSignatureHash(
+		parsed, hCache, txscript.SigHashAll, tx, idx, amt), nil
+}
@@ -0,0 +1,329 @@
+package qln
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Chain event subscriptions let things outside lit, like a swap server or
+an accounting daemon, hear about new blocks and about the txids and
+outpoints they care about, without polling the whole wallet.  A client
+subscribes once, then keeps asking for events; each ask waits until there's
+something, or it's waited long enough.
+
+Txids and outpoints get watched by the wallet like channel outpoints are;
+a txid is watched by its first output, which every tx has.  Only things
+that happen after subscribing come up.  With compact filters, an outpoint's
+spend is found by its script, so outpoints need theirs, and txids, which
+have none, can't be watched.  Watches the wallet didn't have before are
+dropped once no subscription wants them.
+*/
+
+// chain event types
+const (
+	// ChainEventBlock is a new block; a lower height than the last means
+	// a reorg back to there.
+	ChainEventBlock = "block"
+	// ChainEventTx is a watched tx showing up, or the tx making a watched
+	// outpoint.  Height 0 means it's unconfirmed.
+	ChainEventTx = "tx"
+	// ChainEventSpend is a watched outpoint getting spent.
+	ChainEventSpend = "spend"
+	// ChainEventReorg means the chain went back to Height, so a tx or spend
+	// seen above that isn't confirmed anymore.
+	ChainEventReorg = "reorg"
+)
+
+const (
+	// maxSubEvents is the most events a subscription holds on to; past
+	// that, the oldest are dropped.
+	maxSubEvents = 1000
+	// subExpiry is how long a subscription lasts without being asked
+	// for its events.
+	subExpiry = time.Hour
+	// MaxEventWait is the longest an ask for events waits.
+	MaxEventWait = 10 * time.Minute
+)
+
+// ChainEvent is something on a chain a subscription asked about.
+type ChainEvent struct {
+	Type     string
+	CoinType uint32
+	Height   int32
+	// BlockHash is for block events
+	BlockHash string `json:",omitempty"`
+	// Txid is the watched tx, or the one making the watched outpoint
+	Txid     string `json:",omitempty"`
+	OutPoint string `json:",omitempty"`
+	// SpendTxid is the tx spending the outpoint, for spend events
+	SpendTxid string `json:",omitempty"`
+}
+
+// chainSub is one subscription's interests, and the events it hasn't
+// picked up yet.
+type chainSub struct {
+	coin   uint32
+	blocks bool
+	txids  map[chainhash.Hash]bool
+	ops    map[wire.OutPoint]bool
+	// watched are the watches it holds; see chainSubs.watch
+	watched []wire.OutPoint
+
+	events   []ChainEvent
+	wake     chan struct{} // something in events
+	lastPoll time.Time
+}
+
+// chainSubs are all the subscriptions, by id.
+type chainSubs struct {
+	mtx    sync.Mutex
+	subs   map[uint32]*chainSub
+	nextID uint32
+
+	// watchMtx is for watches, the number of subscriptions holding each
+	// wallet watch they made.  It's apart from mtx since the wallet can
+	// wait on that while it sends an event.
+	watchMtx sync.Mutex
+	watches  map[wire.OutPoint]int
+}
+
+// SubscribeChain starts a subscription to a coin's blocks, if blocks is
+// set, and to txids and outpoints on it.  pkScripts, if there are any, are
+// the scripts of ops, in order; nil for ones not known.  Returns the
+// subscription's id.
+func (nd *LitNode) SubscribeChain(coin uint32, blocks bool,
+	txids []chainhash.Hash, ops []wire.OutPoint, pkScripts [][]byte) (uint32, error) {
+
+	wal, ok := nd.SubWallet[coin]
+	if !ok {
+		return 0, fmt.Errorf("not connected to coin type %d", coin)
+	}
+	if !blocks && len(txids) == 0 && len(ops) == 0 {
+		return 0, fmt.Errorf("nothing to subscribe to")
+	}
+	if len(pkScripts) != 0 && len(pkScripts) != len(ops) {
+		return 0, fmt.Errorf("%d outpoints but %d scripts", len(ops), len(pkScripts))
+	}
+
+	sub := &chainSub{
+		coin:     coin,
+		blocks:   blocks,
+		txids:    make(map[chainhash.Hash]bool),
+		ops:      make(map[wire.OutPoint]bool),
+		wake:     make(chan struct{}, 1),
+		lastPoll: time.Now(),
+	}
+	for _, txid := range txids {
+		err := nd.chainSubs.watch(wal, sub, wire.OutPoint{Hash: txid}, nil)
+		if err != nil {
+			nd.chainSubs.unwatch(wal, sub.watched)
+			return 0, fmt.Errorf("txid %s: %s", txid.String(), err.Error())
+		}
+		sub.txids[txid] = true
+	}
+	for i, op := range ops {
+		var pkScript []byte
+		if len(pkScripts) != 0 {
+			pkScript = pkScripts[i]
+		}
+		err := nd.chainSubs.watch(wal, sub, op, pkScript)
+		if err != nil {
+			nd.chainSubs.unwatch(wal, sub.watched)
+			return 0, err
+		}
+		sub.ops[op] = true
+	}
+
+	nd.chainSubs.mtx.Lock()
+	defer nd.chainSubs.mtx.Unlock()
+	if nd.chainSubs.subs == nil {
+		nd.chainSubs.subs = make(map[uint32]*chainSub)
+	}
+	nd.chainSubs.nextID++
+	nd.chainSubs.subs[nd.chainSubs.nextID] = sub
+	log.Infof("chain subscription %d: coin %d, blocks %t, %d txids, %d outpoints\n",
+		nd.chainSubs.nextID, coin, blocks, len(txids), len(ops))
+	return nd.chainSubs.nextID, nil
+}
+
+// UnsubscribeChain ends a subscription, and drops its watches if nothing
+// else wants them.
+func (nd *LitNode) UnsubscribeChain(id uint32) error {
+	nd.chainSubs.mtx.Lock()
+	sub := nd.chainSubs.subs[id]
+	delete(nd.chainSubs.subs, id)
+	nd.chainSubs.mtx.Unlock()
+	if sub == nil {
+		return fmt.Errorf("no chain subscription %d", id)
+	}
+	nd.dropSub(sub)
+	return nil
+}
+
+// dropSub lets go of the watches of a subscription that's ended.
+func (nd *LitNode) dropSub(sub *chainSub) {
+	wal, ok := nd.SubWallet[sub.coin]
+	if !ok {
+		return
+	}
+	nd.chainSubs.unwatch(wal, sub.watched)
+}
+
+// watch has the wallet watch an outpoint for a subscription.  If it already
+// did, for something else, that's left alone; otherwise the subscription
+// holds the watch till it ends.
+func (cs *chainSubs) watch(wal UWallet, sub *chainSub, op wire.OutPoint,
+	pkScript []byte) error {
+
+	cs.watchMtx.Lock()
+	defer cs.watchMtx.Unlock()
+	if cs.watches[op] == 0 {
+		added, err := wal.WatchScript(op, pkScript)
+		if err != nil || !added {
+			return err
+		}
+		if cs.watches == nil {
+			cs.watches = make(map[wire.OutPoint]int)
+		}
+	}
+	cs.watches[op]++
+	sub.watched = append(sub.watched, op)
+	return nil
+}
+
+// unwatch lets go of watches a subscription held.  The wallet stops
+// watching the ones no other subscription holds.
+func (cs *chainSubs) unwatch(wal UWallet, ops []wire.OutPoint) {
+	cs.watchMtx.Lock()
+	defer cs.watchMtx.Unlock()
+	for _, op := range ops {
+		cs.watches[op]--
+		if cs.watches[op] > 0 {
+			continue
+		}
+		delete(cs.watches, op)
+		err := wal.Unwatch(op)
+		if err != nil {
+			log.Errorf("unwatch %s: %s\n", op.String(), err.Error())
+		}
+	}
+}
+
+// ChainEvents gives a subscription's events since last time, waiting up
+// to wait for some if there aren't any yet.
+func (nd *LitNode) ChainEvents(id uint32, wait time.Duration) ([]ChainEvent, error) {
+	if wait > MaxEventWait {
+		wait = MaxEventWait
+	}
+
+	nd.chainSubs.mtx.Lock()
+	sub := nd.chainSubs.subs[id]
+	if sub == nil {
+		nd.chainSubs.mtx.Unlock()
+		return nil, fmt.Errorf("no chain subscription %d", id)
+	}
+	sub.lastPoll = time.Now()
+	if len(sub.events) == 0 && wait > 0 {
+		nd.chainSubs.mtx.Unlock()
+		select {
+		case <-sub.wake:
+		case <-time.After(wait):
+		}
+		nd.chainSubs.mtx.Lock()
+	}
+	events := sub.events
+	sub.events = nil
+	// it may have been woken for these; don't wake for them again
+	select {
+	case <-sub.wake:
+	default:
+	}
+	nd.chainSubs.mtx.Unlock()
+	return events, nil
+}
+
+// addEvent queues an event for a subscription.  Call with the mutex held.
+func (sub *chainSub) addEvent(ev ChainEvent) {
+	ev.CoinType = sub.coin
+	sub.events = append(sub.events, ev)
+	if len(sub.events) > maxSubEvents {
+		sub.events = sub.events[len(sub.events)-maxSubEvents:]
+	}
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// HeightEventHandler gets new blocks from a wallet, moves the channels'
+// height hints along, and tells the subscriptions that want them.
+func (nd *LitNode) HeightEventHandler(heightEventChan chan lnutil.HeightEvent) {
+	for ev := range heightEventChan {
+		err := nd.saveScanHeights(ev.CoinType, ev.Height)
+		if err != nil {
+			log.Errorf("saveScanHeights error: %s\n", err.Error())
+		}
+
+		var expired []*chainSub
+		nd.chainSubs.mtx.Lock()
+		for id, sub := range nd.chainSubs.subs {
+			// nobody's asked in a long time; probably nobody will
+			if time.Since(sub.lastPoll) > subExpiry {
+				log.Infof("chain subscription %d expired\n", id)
+				delete(nd.chainSubs.subs, id)
+				expired = append(expired, sub)
+				continue
+			}
+			if !sub.blocks || sub.coin != ev.CoinType {
+				continue
+			}
+			cev := ChainEvent{Type: ChainEventBlock, Height: ev.Height}
+			if ev.Hash != (chainhash.Hash{}) {
+				cev.BlockHash = ev.Hash.String()
+			}
+			sub.addEvent(cev)
+		}
+		nd.chainSubs.mtx.Unlock()
+
+		for _, sub := range expired {
+			nd.dropSub(sub)
+		}
+	}
+}
+
+// notifyOPEvent tells subscriptions about an outpoint event from a wallet.
+func (nd *LitNode) notifyOPEvent(ev lnutil.OutPointEvent) {
+	nd.chainSubs.mtx.Lock()
+	defer nd.chainSubs.mtx.Unlock()
+	for _, sub := range nd.chainSubs.subs {
+		byOP := sub.ops[ev.Op]
+		// txids are watched by their first output
+		byTxid := ev.Op.Index == 0 && sub.txids[ev.Op.Hash]
+		if !byOP && !byTxid {
+			continue
+		}
+		cev := ChainEvent{Height: ev.Height, Txid: ev.Op.Hash.String()}
+		if byOP {
+			cev.OutPoint = ev.Op.String()
+		}
+		switch {
+		case ev.Reorg:
+			cev.Type = ChainEventReorg
+		case ev.Tx == nil:
+			cev.Type = ChainEventTx
+		case byOP:
+			cev.Type = ChainEventSpend
+			cev.SpendTxid = ev.Tx.TxHash().String()
+		default:
+			// a txid's first output getting spent isn't news
+			continue
+		}
+		sub.addEvent(cev)
+	}
+}
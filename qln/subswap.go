@@ -0,0 +1,839 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+A submarine swap trades channel balance for coins on chain, with the peer of
+the channel.  The offerer picks R and sends its hash.  One side locks coins
+in an on-chain HTLC, the other an HTLC in the channel, for the same hash;
+whoever gets R first takes theirs and so shows R to the other.
+
+Loop in: the offerer pays on chain and gets channel balance.
+
+offerer:  sends the on-chain HTLC, locked for Timeout blocks
+accepter: once it confirms, adds an HTLC in the channel, locked for half
+          the time the on-chain one has left
+offerer:  settles the channel HTLC, which shows the accepter R
+accepter: claims the on-chain HTLC with R
+
+Loop out: the offerer pays channel balance and gets coins on chain.
+
+offerer:  adds an HTLC in the channel, locked for Timeout blocks
+accepter: sends the on-chain HTLC, locked for half the time the channel one
+          has left
+offerer:  once it confirms, claims it with R
+accepter: sees the claim, so gets R, and settles the channel HTLC
+
+If the second HTLC never comes, the first times out: channel HTLCs get
+failed, and on-chain HTLCs are refunded once past their locktime.
+*/
+
+// DefaultSubSwapTimeout is how many blocks the first HTLC of a submarine
+// swap locks for, if the offer doesn't say.
+const DefaultSubSwapTimeout = 144
+
+// SubSwap is a submarine swap we offered or were offered.
+type SubSwap struct {
+	RHash    [32]byte
+	R        [32]byte // the offerer's from the start; the accepter's once shown
+	PeerIdx  uint32
+	CoinType uint32
+	Offerer  bool // we made the offer
+	LoopIn   bool // the offerer pays on chain and gets channel balance
+
+	ChanOp   wire.OutPoint
+	ChanAmt  int64
+	ChainAmt int64
+	Timeout  uint32 // blocks the first HTLC locks for
+
+	TheirPub   [33]byte      // their claim or refund key in the on-chain HTLC
+	FundOp     wire.OutPoint // the on-chain HTLC
+	Locktime   uint32        // the on-chain HTLC's
+	FundHeight int32         // when the on-chain HTLC confirmed
+	Status     uint8         // same as for Swaps
+}
+
+// StatusString gives the swap's status as a word.
+func (s *SubSwap) StatusString() string {
+	if int(s.Status) < len(swapStatusNames) {
+		return swapStatusNames[s.Status]
+	}
+	return fmt.Sprintf("unknown status %d", s.Status)
+}
+
+// funder says if we send the on-chain HTLC.
+func (s *SubSwap) funder() bool {
+	return s.LoopIn == s.Offerer
+}
+
+// script is the on-chain HTLC's script, given our key in it.
+func (s *SubSwap) script(ourPub [33]byte) []byte {
+	if s.funder() {
+		return lnutil.SubSwapScript(s.TheirPub, ourPub, s.RHash, s.Locktime)
+	}
+	return lnutil.SubSwapScript(ourPub, s.TheirPub, s.RHash, s.Locktime)
+}
+
+// keyGen is the path for our key in the on-chain HTLC.
+func (s *SubSwap) keyGen() portxo.KeyGen {
+	var kg portxo.KeyGen
+	kg.Depth = 5
+	kg.Step[0] = 44 | 1<<31
+	kg.Step[1] = s.CoinType | 1<<31
+	kg.Step[2] = UseSubSwap
+	kg.Step[3] = s.PeerIdx | 1<<31
+	kg.Step[4] = lnutil.BtU32(s.RHash[:4]) | 1<<31
+	return kg
+}
+
+/* submarine swap serialization, the value in BKTSubSwps; the key is the RHash:
+32	R
+4	peer index
+4	coin type
+1	offerer
+1	loop in
+36	channel outpoint
+8	channel amount
+8	chain amount
+4	timeout
+33	their pubkey
+36	on-chain HTLC outpoint
+4	locktime
+4	on-chain HTLC height
+1	status
+*/
+
+// Bytes serializes a SubSwap, without its RHash.
+func (s *SubSwap) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(s.R[:])
+	buf.Write(lnutil.U32tB(s.PeerIdx))
+	buf.Write(lnutil.U32tB(s.CoinType))
+	for _, b := range []bool{s.Offerer, s.LoopIn} {
+		if b {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+	opArr := lnutil.OutPointToBytes(s.ChanOp)
+	buf.Write(opArr[:])
+	buf.Write(lnutil.I64tB(s.ChanAmt))
+	buf.Write(lnutil.I64tB(s.ChainAmt))
+	buf.Write(lnutil.U32tB(s.Timeout))
+	buf.Write(s.TheirPub[:])
+	opArr = lnutil.OutPointToBytes(s.FundOp)
+	buf.Write(opArr[:])
+	buf.Write(lnutil.U32tB(s.Locktime))
+	buf.Write(lnutil.I32tB(s.FundHeight))
+	buf.WriteByte(s.Status)
+	return buf.Bytes()
+}
+
+// SubSwapFromBytes deserializes a SubSwap from its key and value.
+func SubSwapFromBytes(k, v []byte) (*SubSwap, error) {
+	if len(k) != 32 || len(v) != 176 {
+		return nil, fmt.Errorf("subswap %x: %d bytes, expect 176", k, len(v))
+	}
+	s := new(SubSwap)
+	copy(s.RHash[:], k)
+	buf := bytes.NewBuffer(v)
+	var opArr [36]byte
+	copy(s.R[:], buf.Next(32))
+	s.PeerIdx = lnutil.BtU32(buf.Next(4))
+	s.CoinType = lnutil.BtU32(buf.Next(4))
+	s.Offerer = buf.Next(1)[0] != 0
+	s.LoopIn = buf.Next(1)[0] != 0
+	copy(opArr[:], buf.Next(36))
+	s.ChanOp = *lnutil.OutPointFromBytes(opArr)
+	s.ChanAmt = lnutil.BtI64(buf.Next(8))
+	s.ChainAmt = lnutil.BtI64(buf.Next(8))
+	s.Timeout = lnutil.BtU32(buf.Next(4))
+	copy(s.TheirPub[:], buf.Next(33))
+	copy(opArr[:], buf.Next(36))
+	s.FundOp = *lnutil.OutPointFromBytes(opArr)
+	s.Locktime = lnutil.BtU32(buf.Next(4))
+	s.FundHeight = lnutil.BtI32(buf.Next(4))
+	s.Status = buf.Next(1)[0]
+	return s, nil
+}
+
+// SaveSubSwap saves a submarine swap, replacing any with the same hash.
+func (nd *LitNode) SaveSubSwap(s *SubSwap) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTSubSwps).Put(s.RHash[:], s.Bytes())
+	})
+}
+
+// GetSubSwap looks a submarine swap up by its hash.
+func (nd *LitNode) GetSubSwap(rHash [32]byte) (*SubSwap, error) {
+	var s *SubSwap
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		v := btx.Bucket(BKTSubSwps).Get(rHash[:])
+		if v == nil {
+			return fmt.Errorf("no subswap %x", rHash)
+		}
+		var err error
+		s, err = SubSwapFromBytes(rHash[:], v)
+		return err
+	})
+	return s, err
+}
+
+// ListSubSwaps gives all the submarine swaps we've offered or been offered.
+func (nd *LitNode) ListSubSwaps() ([]*SubSwap, error) {
+	var swaps []*SubSwap
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTSubSwps).ForEach(func(k, v []byte) error {
+			s, err := SubSwapFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			swaps = append(swaps, s)
+			return nil
+		})
+	})
+	return swaps, err
+}
+
+// subSwapByFundOp finds the submarine swap with an on-chain HTLC; nil if
+// there isn't one.
+func (nd *LitNode) subSwapByFundOp(op wire.OutPoint) (*SubSwap, error) {
+	swaps, err := nd.ListSubSwaps()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range swaps {
+		if lnutil.OutPointsEqual(s.FundOp, op) {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkSubSwap checks that a submarine swap can happen in the channel: it's
+// open, with a coin we have a wallet for, whoever pays in the channel can,
+// and both HTLCs lock long enough.
+func (nd *LitNode) checkSubSwap(qc *Qchan, s *SubSwap) error {
+	if qc.Peer() != s.PeerIdx {
+		return fmt.Errorf("channel %d isn't with peer %d", qc.Idx(), s.PeerIdx)
+	}
+	if qc.CloseData.Closed {
+		return fmt.Errorf("channel %d is closed", qc.Idx())
+	}
+	if _, ok := nd.SubWallet[qc.Coin()]; !ok {
+		return fmt.Errorf("not connected to coin type %d", qc.Coin())
+	}
+
+	for _, amt := range []int64{s.ChanAmt, s.ChainAmt} {
+		if amt < consts.MinOutput || amt >= 1<<30 {
+			return fmt.Errorf("swap amount %d out of range", amt)
+		}
+	}
+	// the funder gets the channel balance
+	payerAmt := qc.State.MyAmt
+	if s.funder() {
+		payerAmt = qc.TheirAmt()
+	}
+	if payerAmt-s.ChanAmt-qc.State.Fee < consts.MinOutput {
+		return fmt.Errorf("channel %d can't pay %s", qc.Idx(),
+			lnutil.SatoshiColor(s.ChanAmt))
+	}
+
+	// the first HTLC locks for Timeout, the second for about half that
+	chanLock := s.Timeout
+	if s.LoopIn {
+		chanLock = s.Timeout / 2
+	}
+	if int64(chanLock) < int64(qc.Delay)+htlcMinBlocks {
+		return fmt.Errorf("timeout %d blocks too short for channel delay %d",
+			s.Timeout, qc.Delay)
+	}
+	if s.Timeout/2 < htlcMinBlocks {
+		return fmt.Errorf("timeout %d blocks too short", s.Timeout)
+	}
+	return nil
+}
+
+// subSwapPub is our key in the swap's on-chain HTLC.
+func (nd *LitNode) subSwapPub(s *SubSwap) ([33]byte, error) {
+	return nd.GetUsePub(s.keyGen(), UseSubSwap)
+}
+
+// OfferSubSwap offers the peer of a channel a submarine swap: for loop in,
+// chainAmt on chain for chanAmt in the channel; for loop out, chanAmt in
+// the channel for chainAmt on chain.  timeout is in blocks; 0 for the
+// default.
+func (nd *LitNode) OfferSubSwap(chanIdx uint32, loopIn bool,
+	chanAmt, chainAmt int64, timeout uint32) (*SubSwap, error) {
+
+	qc, err := nd.GetQchanByIdx(chanIdx)
+	if err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = DefaultSubSwapTimeout
+	}
+	s := &SubSwap{
+		PeerIdx:  qc.Peer(),
+		CoinType: qc.Coin(),
+		Offerer:  true,
+		LoopIn:   loopIn,
+		ChanOp:   qc.Op,
+		ChanAmt:  chanAmt,
+		ChainAmt: chainAmt,
+		Timeout:  timeout,
+		Status:   SwapOffered,
+	}
+	err = nd.checkSubSwap(qc, s)
+	if err != nil {
+		return nil, err
+	}
+	if !nd.peerHasHTLCs(s.PeerIdx) {
+		return nil, fmt.Errorf("peer %d isn't connected or doesn't do HTLCs",
+			s.PeerIdx)
+	}
+
+	_, err = rand.Read(s.R[:])
+	if err != nil {
+		return nil, err
+	}
+	s.RHash = sha256.Sum256(s.R[:])
+
+	// for loop out we claim on chain, so they need our key now
+	var pub [33]byte
+	if !loopIn {
+		pub, err = nd.subSwapPub(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = nd.SaveSubSwap(s)
+	if err != nil {
+		return nil, err
+	}
+
+	nd.OmniOut <- lnutil.SubSwapOfferMsg{
+		PeerIdx:  s.PeerIdx,
+		RHash:    s.RHash,
+		Outpoint: s.ChanOp,
+		LoopIn:   s.LoopIn,
+		ChanAmt:  s.ChanAmt,
+		ChainAmt: s.ChainAmt,
+		Timeout:  s.Timeout,
+		Pub:      pub,
+	}
+	return s, nil
+}
+
+// SubSwapOfferHandler saves a submarine swap offer for the user to accept
+// or decline.  Offers that can't work are declined right away.
+func (nd *LitNode) SubSwapOfferHandler(msg lnutil.SubSwapOfferMsg, peer *RemotePeer) error {
+	s := &SubSwap{
+		RHash:    msg.RHash,
+		PeerIdx:  peer.Idx,
+		LoopIn:   msg.LoopIn,
+		ChanOp:   msg.Outpoint,
+		ChanAmt:  msg.ChanAmt,
+		ChainAmt: msg.ChainAmt,
+		Timeout:  msg.Timeout,
+		TheirPub: msg.Pub,
+		Status:   SwapOffered,
+	}
+
+	_, err := nd.GetSubSwap(s.RHash)
+	if err == nil {
+		return fmt.Errorf("SubSwapOfferHandler: already have subswap %x", s.RHash)
+	}
+
+	qc, err := nd.GetQchan(lnutil.OutPointToBytes(s.ChanOp))
+	if err == nil {
+		s.CoinType = qc.Coin()
+		err = nd.checkSubSwap(qc, s)
+	}
+	if err != nil {
+		nd.OmniOut <- lnutil.NewSubSwapReplyMsg(peer.Idx, s.RHash, false, [33]byte{})
+		return fmt.Errorf("SubSwapOfferHandler: declined %x: %s",
+			s.RHash, err.Error())
+	}
+
+	err = nd.SaveSubSwap(s)
+	if err != nil {
+		return err
+	}
+
+	dir := "out"
+	if s.LoopIn {
+		dir = "in"
+	}
+	nd.UserMessageBox <- fmt.Sprintf(
+		"\nloop %s offer from %d: %s in channel %d, %s on chain; subswap %x",
+		dir, peer.Idx, lnutil.SatoshiColor(s.ChanAmt), qc.Idx(),
+		lnutil.SatoshiColor(s.ChainAmt), s.RHash)
+	return nil
+}
+
+// ReplySubSwap accepts or declines a submarine swap we were offered.
+func (nd *LitNode) ReplySubSwap(rHash [32]byte, accept bool) error {
+	s, err := nd.GetSubSwap(rHash)
+	if err != nil {
+		return err
+	}
+	if s.Offerer || s.Status != SwapOffered {
+		return fmt.Errorf("subswap %x is %s; can't reply", rHash, s.StatusString())
+	}
+
+	var pub [33]byte
+	if accept {
+		qc, err := nd.GetQchan(lnutil.OutPointToBytes(s.ChanOp))
+		if err != nil {
+			return err
+		}
+		// balances may have moved since the offer
+		err = nd.checkSubSwap(qc, s)
+		if err != nil {
+			return err
+		}
+		// for loop in we claim on chain
+		if s.LoopIn {
+			pub, err = nd.subSwapPub(s)
+			if err != nil {
+				return err
+			}
+		}
+		s.Status = SwapAccepted
+	} else {
+		s.Status = SwapDeclined
+	}
+
+	err = nd.SaveSubSwap(s)
+	if err != nil {
+		return err
+	}
+	nd.OmniOut <- lnutil.NewSubSwapReplyMsg(s.PeerIdx, rHash, accept, pub)
+	return nil
+}
+
+// SubSwapReplyHandler handles the peer accepting or declining our offer.
+// If they accept, the first HTLC goes out: on chain for loop in, in the
+// channel for loop out.
+func (nd *LitNode) SubSwapReplyHandler(msg lnutil.SubSwapReplyMsg, peer *RemotePeer) error {
+	s, err := nd.GetSubSwap(msg.RHash)
+	if err != nil {
+		return fmt.Errorf("SubSwapReplyHandler: %s", err.Error())
+	}
+	if !s.Offerer || s.PeerIdx != peer.Idx || s.Status != SwapOffered {
+		return fmt.Errorf("SubSwapReplyHandler: unexpected reply for subswap %x",
+			msg.RHash)
+	}
+
+	if !msg.Accept {
+		s.Status = SwapDeclined
+		nd.UserMessageBox <- fmt.Sprintf(
+			"\npeer %d declined subswap %x", peer.Idx, s.RHash)
+		return nd.SaveSubSwap(s)
+	}
+
+	s.Status = SwapAccepted
+	if s.LoopIn {
+		s.TheirPub = msg.Pub
+	}
+	err = nd.SaveSubSwap(s)
+	if err != nil {
+		return err
+	}
+
+	wal, ok := nd.SubWallet[s.CoinType]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", s.CoinType)
+	}
+	locktime := uint32(wal.CurrentHeight()) + s.Timeout
+	go func() {
+		var err error
+		if s.LoopIn {
+			err = nd.fundSubSwap(s, locktime)
+		} else {
+			err = nd.swapHTLC(s.PeerIdx, s.ChanOp, lnutil.HTLCOpAdd,
+				HTLC{Amt: s.ChanAmt, RHash: s.RHash, Locktime: locktime})
+		}
+		if err != nil {
+			log.Errorf("subswap %x: %s\n", s.RHash, err.Error())
+		}
+	}()
+	return nil
+}
+
+// fundSubSwap sends the on-chain HTLC, and tells the peer where it is.
+func (nd *LitNode) fundSubSwap(s *SubSwap, locktime uint32) error {
+	wal, ok := nd.SubWallet[s.CoinType]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", s.CoinType)
+	}
+	ourPub, err := nd.subSwapPub(s)
+	if err != nil {
+		return err
+	}
+	s.Locktime = locktime
+
+	txo := wire.NewTxOut(s.ChainAmt, lnutil.P2WSHify(s.script(ourPub)))
+	ops, err := wal.MaybeSend([]*wire.TxOut{txo}, true, "", nil, nil)
+	if err != nil {
+		return err
+	}
+	// watch before sending, to hear about the confirmation and any spend
+	err = wal.WatchThis(*ops[0])
+	if err != nil {
+		wal.NahDontSend(&ops[0].Hash)
+		return err
+	}
+	err = wal.ReallySend(&ops[0].Hash)
+	if err != nil {
+		return err
+	}
+
+	s.FundOp = *ops[0]
+	s.Status = SwapFunded
+	err = nd.SaveSubSwap(s)
+	if err != nil {
+		return err
+	}
+	log.Infof("subswap %x: on-chain HTLC %s, locktime %d\n",
+		s.RHash, s.FundOp.String(), s.Locktime)
+
+	nd.OmniOut <- lnutil.NewSubSwapFundMsg(
+		s.PeerIdx, s.RHash, s.FundOp, ourPub, s.Locktime)
+	return nil
+}
+
+// SubSwapFundHandler takes note of the peer's on-chain HTLC, and watches
+// for it to confirm.  It's checked then, once the wallet has the tx.
+func (nd *LitNode) SubSwapFundHandler(msg lnutil.SubSwapFundMsg, peer *RemotePeer) error {
+	s, err := nd.GetSubSwap(msg.RHash)
+	if err != nil {
+		return fmt.Errorf("SubSwapFundHandler: %s", err.Error())
+	}
+	if s.PeerIdx != peer.Idx || s.funder() || s.Status != SwapAccepted {
+		return fmt.Errorf("SubSwapFundHandler: unexpected funding for subswap %x",
+			msg.RHash)
+	}
+	wal, ok := nd.SubWallet[s.CoinType]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", s.CoinType)
+	}
+
+	// we need time to claim it; for loop in, after the channel HTLC, which
+	// needs its own time before
+	need := int64(htlcMinBlocks)
+	if s.LoopIn {
+		qc, err := nd.GetQchan(lnutil.OutPointToBytes(s.ChanOp))
+		if err != nil {
+			return err
+		}
+		need = 2 * (int64(qc.Delay) + htlcMinBlocks)
+	}
+	if int64(msg.Locktime) < int64(wal.CurrentHeight())+need {
+		return fmt.Errorf("SubSwapFundHandler: locktime %d too soon; need %d",
+			msg.Locktime, int64(wal.CurrentHeight())+need)
+	}
+
+	s.TheirPub = msg.Pub
+	s.FundOp = msg.Outpoint
+	s.Locktime = msg.Locktime
+	s.Status = SwapFunded
+	err = nd.SaveSubSwap(s)
+	if err != nil {
+		return err
+	}
+	return wal.WatchThis(s.FundOp)
+}
+
+// SubSwapOPEvent handles the on-chain HTLC confirming or being spent.
+func (nd *LitNode) SubSwapOPEvent(s *SubSwap, ev *lnutil.OutPointEvent) error {
+	wal, ok := nd.SubWallet[s.CoinType]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", s.CoinType)
+	}
+
+	if ev.Tx != nil {
+		return nd.subSwapSpent(s, ev.Tx)
+	}
+
+	// confirmation; only once, and not for the mempool
+	if ev.Height == 0 || s.FundHeight != 0 {
+		return nil
+	}
+	if !s.funder() {
+		// make sure it's what they said
+		ourPub, err := nd.subSwapPub(s)
+		if err != nil {
+			return err
+		}
+		tx, err := wal.GetSavedTx(&ev.Op.Hash)
+		if err != nil {
+			return err
+		}
+		if int(ev.Op.Index) >= len(tx.TxOut) ||
+			!bytes.Equal(tx.TxOut[ev.Op.Index].PkScript,
+				lnutil.P2WSHify(s.script(ourPub))) ||
+			tx.TxOut[ev.Op.Index].Value < s.ChainAmt {
+			return fmt.Errorf("subswap %x: %s isn't the on-chain HTLC",
+				s.RHash, ev.Op.String())
+		}
+	}
+	s.FundHeight = ev.Height
+	err := nd.SaveSubSwap(s)
+	if err != nil {
+		return err
+	}
+	if s.funder() {
+		return nil
+	}
+
+	// this is the wallet's event loop, so the rest goes on elsewhere
+	if s.LoopIn {
+		// their coins are locked on chain, so lock ours in the channel
+		cur := uint32(wal.CurrentHeight())
+		lt := cur + (s.Locktime-cur)/2
+		if s.Locktime-lt < htlcMinBlocks {
+			return fmt.Errorf("subswap %x: locktime %d too soon now",
+				s.RHash, s.Locktime)
+		}
+		go func() {
+			err := nd.swapHTLC(s.PeerIdx, s.ChanOp, lnutil.HTLCOpAdd,
+				HTLC{Amt: s.ChanAmt, RHash: s.RHash, Locktime: lt})
+			if err != nil {
+				log.Errorf("subswap %x: %s\n", s.RHash, err.Error())
+			}
+		}()
+		return nil
+	}
+	// loop out: take it, which shows them R
+	go func() {
+		err := nd.sweepSubSwap(s, true)
+		if err != nil {
+			log.Errorf("subswap %x: claim: %s\n", s.RHash, err.Error())
+		}
+	}()
+	return nil
+}
+
+// subSwapSpent handles a tx spending the on-chain HTLC.  A claim shows R,
+// which for loop out lets us settle the channel HTLC.
+func (nd *LitNode) subSwapSpent(s *SubSwap, tx *wire.MsgTx) error {
+	for _, in := range tx.TxIn {
+		if !lnutil.OutPointsEqual(in.PreviousOutPoint, s.FundOp) {
+			continue
+		}
+		// claims are [sig, R, 1, script]; refunds [sig, 0, script]
+		if len(in.Witness) == 4 && sha256.Sum256(in.Witness[1]) == s.RHash {
+			if sha256.Sum256(s.R[:]) == s.RHash {
+				// already knew it
+				return nil
+			}
+			copy(s.R[:], in.Witness[1])
+			err := nd.SaveSubSwap(s)
+			if err != nil {
+				return err
+			}
+			log.Infof("subswap %x claimed on chain; got R\n", s.RHash)
+			if s.funder() && !s.LoopIn {
+				go func() {
+					err := nd.swapHTLC(s.PeerIdx, s.ChanOp,
+						lnutil.HTLCOpSettle, HTLC{R: s.R})
+					if err != nil {
+						log.Errorf("subswap %x: settle: %s\n",
+							s.RHash, err.Error())
+					}
+				}()
+			}
+			return nil
+		}
+		if s.Status != SwapDone {
+			s.Status = SwapRefunded
+			return nd.SaveSubSwap(s)
+		}
+	}
+	return nil
+}
+
+// sweepSubSwap gives the wallet the on-chain HTLC, to claim with R or
+// refund, and has it spend it right away.
+func (nd *LitNode) sweepSubSwap(s *SubSwap, claim bool) error {
+	wal, ok := nd.SubWallet[s.CoinType]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", s.CoinType)
+	}
+	ourPub, err := nd.subSwapPub(s)
+	if err != nil {
+		return err
+	}
+	tx, err := wal.GetSavedTx(&s.FundOp.Hash)
+	if err != nil {
+		return err
+	}
+	if int(s.FundOp.Index) >= len(tx.TxOut) {
+		return fmt.Errorf("no output %s", s.FundOp.String())
+	}
+
+	var txo portxo.PorTxo
+	txo.Op = s.FundOp
+	txo.Value = tx.TxOut[s.FundOp.Index].Value
+	txo.Height = s.FundHeight
+	txo.KeyGen = s.keyGen()
+	txo.Mode = portxo.TxoP2WSHComp
+	txo.PkScript = s.script(ourPub)
+	if claim {
+		txo.PreSigStack = [][]byte{s.R[:], {0x01}}
+	} else {
+		// no sequence, but the wallet's locktime is the current height,
+		// which is enough for the refund path
+		txo.PreSigStack = [][]byte{nil}
+	}
+	wal.ExportUtxo(&txo)
+
+	txid, err := wal.SweepUtxo(s.FundOp, 0)
+	if err != nil {
+		return err
+	}
+	log.Infof("subswap %x: swept %s in %s\n",
+		s.RHash, s.FundOp.String(), txid.String())
+	return nil
+}
+
+// subSwapStep moves a submarine swap along after an HTLC op goes through
+// in its channel.
+func (nd *LitNode) subSwapStep(s *SubSwap, chanOp wire.OutPoint,
+	op uint8, h HTLC) {
+
+	var err error
+	failBack := func(why string) {
+		log.Errorf("subswap %x: %s; failing HTLC\n", s.RHash, why)
+		err = nd.swapHTLC(s.PeerIdx, chanOp, lnutil.HTLCOpFail, h)
+	}
+
+	switch {
+	case !lnutil.OutPointsEqual(chanOp, s.ChanOp):
+		if op == lnutil.HTLCOpAdd && h.Incoming {
+			failBack("HTLC on the wrong channel")
+		}
+
+	// loop in offerer: they locked the channel balance, so take it, which
+	// shows them R for the coins on chain
+	case op == lnutil.HTLCOpAdd && h.Incoming && s.Offerer && s.LoopIn:
+		if s.Status != SwapFunded || s.FundHeight == 0 || h.Amt < s.ChanAmt {
+			failBack(fmt.Sprintf("%s, HTLC %d of %d",
+				s.StatusString(), h.Amt, s.ChanAmt))
+			break
+		}
+		s.Status = SwapLocked
+		err = nd.SaveSubSwap(s)
+		if err == nil {
+			h.R = s.R
+			err = nd.swapHTLC(s.PeerIdx, chanOp, lnutil.HTLCOpSettle, h)
+		}
+
+	// loop out accepter: they locked the channel balance, so put the coins
+	// on chain, locked for half the time theirs has left
+	case op == lnutil.HTLCOpAdd && h.Incoming && !s.Offerer && !s.LoopIn:
+		if s.Status != SwapAccepted || h.Amt < s.ChanAmt {
+			failBack(fmt.Sprintf("%s, HTLC %d of %d",
+				s.StatusString(), h.Amt, s.ChanAmt))
+			break
+		}
+		wal, ok := nd.SubWallet[s.CoinType]
+		if !ok {
+			failBack("no wallet")
+			break
+		}
+		cur := uint32(wal.CurrentHeight())
+		lt := cur + (h.Locktime-cur)/2
+		if lt < cur+htlcMinBlocks || h.Locktime-lt < htlcMinBlocks {
+			failBack(fmt.Sprintf("HTLC locktime %d too soon", h.Locktime))
+			break
+		}
+		err = nd.fundSubSwap(s, lt)
+		if err != nil {
+			failBack(err.Error())
+		}
+
+	// loop in accepter: our HTLC is in
+	case op == lnutil.HTLCOpAdd && !h.Incoming && !s.Offerer && s.LoopIn:
+		s.Status = SwapLocked
+		err = nd.SaveSubSwap(s)
+
+	// loop in accepter: they took it, so now we know R; take the coins
+	case op == lnutil.HTLCOpSettle && !h.Incoming && !s.Offerer && s.LoopIn:
+		s.R = h.R
+		s.Status = SwapDone
+		err = nd.SaveSubSwap(s)
+		if err == nil {
+			err = nd.sweepSubSwap(s, true)
+		}
+		nd.UserMessageBox <- fmt.Sprintf("\nsubswap %x done", s.RHash)
+
+	case op == lnutil.HTLCOpSettle:
+		s.Status = SwapDone
+		err = nd.SaveSubSwap(s)
+		nd.UserMessageBox <- fmt.Sprintf("\nsubswap %x done", s.RHash)
+
+	case op == lnutil.HTLCOpFail:
+		// funders still have coins on chain to get back after the locktime
+		if s.Status == SwapDone || (s.funder() && s.FundOp != (wire.OutPoint{})) {
+			break
+		}
+		s.Status = SwapRefunded
+		err = nd.SaveSubSwap(s)
+		nd.UserMessageBox <- fmt.Sprintf("\nsubswap %x refunded", s.RHash)
+
+	case op == lnutil.HTLCOpAdd && h.Incoming:
+		failBack("HTLC from the wrong side")
+	}
+
+	if err != nil {
+		log.Errorf("subswap %x: %s\n", s.RHash, err.Error())
+	}
+}
+
+// RefundExpiredSubSwaps takes back the on-chain HTLCs we sent for swaps
+// that didn't go through, once past their locktime.
+func (nd *LitNode) RefundExpiredSubSwaps() error {
+	swaps, err := nd.ListSubSwaps()
+	if err != nil {
+		return err
+	}
+	for _, s := range swaps {
+		if !s.funder() || s.FundHeight == 0 ||
+			s.Status == SwapDone || s.Status == SwapRefunded {
+			continue
+		}
+		wal, ok := nd.SubWallet[s.CoinType]
+		if !ok || wal.CurrentHeight() < int32(s.Locktime) {
+			continue
+		}
+		log.Warnf("subswap %x timed out; refunding %s\n",
+			s.RHash, s.FundOp.String())
+		err = nd.sweepSubSwap(s, false)
+		if err != nil {
+			log.Errorf("refund subswap %x: %s\n", s.RHash, err.Error())
+			continue
+		}
+		s.Status = SwapRefunded
+		err = nd.SaveSubSwap(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,567 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+An atomic swap trades an amount in one channel for an amount in another
+channel, on a different coin, with the same peer.  The offerer picks R and
+sends its hash; if the peer accepts:
+
+offerer: adds an HTLC for GiveAmt on the give channel, locked for Timeout
+peer:    adds an HTLC for WantAmt on the want channel, locked for half the
+         time the offerer's has left
+offerer: settles the peer's HTLC, which shows the peer R
+peer:    settles the offerer's HTLC with R
+
+Either HTLC failing, or timing out, ends the swap.  The peer's HTLC times out
+first, so the offerer can't take it after its own has gone back.
+*/
+
+// Swap statuses
+const (
+	SwapOffered  = iota // waiting on the peer to accept
+	SwapAccepted        // waiting on the HTLCs
+	SwapDeclined
+	SwapLocked   // both HTLCs are in
+	SwapDone     // both HTLCs settled
+	SwapRefunded // an HTLC failed, so the swap is off
+	SwapFunded   // submarine swaps: the on-chain HTLC is out
+)
+
+// DefaultSwapTimeout is how many seconds the offerer's HTLC locks for, if
+// the offer doesn't say.
+const DefaultSwapTimeout = 24 * 60 * 60
+
+// how many times to try an HTLC op for a swap, if it collides
+const swapTries = 3
+
+// Swap is an atomic swap we offered or were offered.  Give and Want are
+// from the offerer's side, whichever we are.
+type Swap struct {
+	RHash   [32]byte
+	R       [32]byte // the offerer's from the start; the peer's once settled
+	PeerIdx uint32
+	Offerer bool // we made the offer
+
+	GiveOp  wire.OutPoint // channel the offerer pays in
+	GiveAmt int64
+	WantOp  wire.OutPoint // channel the peer pays in
+	WantAmt int64
+	Timeout uint32 // seconds the offerer's HTLC locks for
+	Status  uint8
+}
+
+var swapStatusNames = []string{
+	"offered", "accepted", "declined", "locked", "done", "refunded", "funded"}
+
+// StatusString gives the swap's status as a word.
+func (s *Swap) StatusString() string {
+	if int(s.Status) < len(swapStatusNames) {
+		return swapStatusNames[s.Status]
+	}
+	return fmt.Sprintf("unknown status %d", s.Status)
+}
+
+/* swap serialization, the value in BKTSwaps; the key is the RHash:
+32	R
+4	peer index
+1	offerer
+36	give outpoint
+8	give amount
+36	want outpoint
+8	want amount
+4	timeout
+1	status
+*/
+
+// Bytes serializes a Swap, without its RHash.
+func (s *Swap) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Write(s.R[:])
+	buf.Write(lnutil.U32tB(s.PeerIdx))
+	if s.Offerer {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	opArr := lnutil.OutPointToBytes(s.GiveOp)
+	buf.Write(opArr[:])
+	buf.Write(lnutil.I64tB(s.GiveAmt))
+	opArr = lnutil.OutPointToBytes(s.WantOp)
+	buf.Write(opArr[:])
+	buf.Write(lnutil.I64tB(s.WantAmt))
+	buf.Write(lnutil.U32tB(s.Timeout))
+	buf.WriteByte(s.Status)
+	return buf.Bytes()
+}
+
+// SwapFromBytes deserializes a Swap from its key and value.
+func SwapFromBytes(k, v []byte) (*Swap, error) {
+	if len(k) != 32 || len(v) != 130 {
+		return nil, fmt.Errorf("swap %x: %d bytes, expect 130", k, len(v))
+	}
+	s := new(Swap)
+	copy(s.RHash[:], k)
+	buf := bytes.NewBuffer(v)
+	var opArr [36]byte
+	copy(s.R[:], buf.Next(32))
+	s.PeerIdx = lnutil.BtU32(buf.Next(4))
+	s.Offerer = buf.Next(1)[0] != 0
+	copy(opArr[:], buf.Next(36))
+	s.GiveOp = *lnutil.OutPointFromBytes(opArr)
+	s.GiveAmt = lnutil.BtI64(buf.Next(8))
+	copy(opArr[:], buf.Next(36))
+	s.WantOp = *lnutil.OutPointFromBytes(opArr)
+	s.WantAmt = lnutil.BtI64(buf.Next(8))
+	s.Timeout = lnutil.BtU32(buf.Next(4))
+	s.Status = buf.Next(1)[0]
+	return s, nil
+}
+
+// SaveSwap saves a swap, replacing any with the same hash.
+func (nd *LitNode) SaveSwap(s *Swap) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTSwaps).Put(s.RHash[:], s.Bytes())
+	})
+}
+
+// GetSwap looks a swap up by its hash.
+func (nd *LitNode) GetSwap(rHash [32]byte) (*Swap, error) {
+	var s *Swap
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		v := btx.Bucket(BKTSwaps).Get(rHash[:])
+		if v == nil {
+			return fmt.Errorf("no swap %x", rHash)
+		}
+		var err error
+		s, err = SwapFromBytes(rHash[:], v)
+		return err
+	})
+	return s, err
+}
+
+// ListSwaps gives all the swaps we've offered or been offered.
+func (nd *LitNode) ListSwaps() ([]*Swap, error) {
+	var swaps []*Swap
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTSwaps).ForEach(func(k, v []byte) error {
+			s, err := SwapFromBytes(k, v)
+			if err != nil {
+				return err
+			}
+			swaps = append(swaps, s)
+			return nil
+		})
+	})
+	return swaps, err
+}
+
+// swapBlocks is about how many of the coin's blocks come in secs seconds.
+func swapBlocks(p *coinparam.Params, secs int64) int64 {
+	return secs * int64(time.Second) / int64(p.TargetTimePerBlock)
+}
+
+// checkSwap checks that a swap can happen between the two channels:
+// they're open, on different coins we have wallets for, the side that pays
+// now can, and both HTLCs lock long enough.  offerer is for when we'd be
+// the one giving.
+func (nd *LitNode) checkSwap(give, want *Qchan, s *Swap, offerer bool) error {
+	if give.Peer() != want.Peer() {
+		return fmt.Errorf("channels %d and %d are with different peers",
+			give.Idx(), want.Idx())
+	}
+	if give.CloseData.Closed || want.CloseData.Closed {
+		return fmt.Errorf("channel %d or %d is closed", give.Idx(), want.Idx())
+	}
+	if give.Coin() == want.Coin() {
+		return fmt.Errorf("channels %d and %d are both coin type %d",
+			give.Idx(), want.Idx(), give.Coin())
+	}
+	giveWal, ok := nd.SubWallet[give.Coin()]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", give.Coin())
+	}
+	wantWal, ok := nd.SubWallet[want.Coin()]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", want.Coin())
+	}
+
+	for _, amt := range []int64{s.GiveAmt, s.WantAmt} {
+		if amt < consts.MinOutput || amt >= 1<<30 {
+			return fmt.Errorf("swap amount %d out of range", amt)
+		}
+	}
+	payer, amt := want, s.WantAmt
+	if offerer {
+		payer, amt = give, s.GiveAmt
+	}
+	if payer.State.MyAmt-amt-payer.State.Fee < consts.MinOutput {
+		return fmt.Errorf("channel %d has %s, can't pay %s",
+			payer.Idx(), lnutil.SatoshiColor(payer.State.MyAmt),
+			lnutil.SatoshiColor(amt))
+	}
+
+	giveBlocks := swapBlocks(giveWal.Params(), int64(s.Timeout))
+	if giveBlocks < int64(give.Delay)+htlcMinBlocks {
+		return fmt.Errorf("%d seconds is only %d %s blocks; need %d",
+			s.Timeout, giveBlocks, giveWal.Params().Name,
+			int64(give.Delay)+htlcMinBlocks)
+	}
+	wantBlocks := swapBlocks(wantWal.Params(), int64(s.Timeout/2))
+	if wantBlocks < int64(want.Delay)+htlcMinBlocks {
+		return fmt.Errorf("%d seconds is only %d %s blocks; need %d",
+			s.Timeout/2, wantBlocks, wantWal.Params().Name,
+			int64(want.Delay)+htlcMinBlocks)
+	}
+	return nil
+}
+
+// swapChans gets a swap's give and want channels from the db.
+func (nd *LitNode) swapChans(s *Swap) (*Qchan, *Qchan, error) {
+	give, err := nd.GetQchan(lnutil.OutPointToBytes(s.GiveOp))
+	if err != nil {
+		return nil, nil, err
+	}
+	want, err := nd.GetQchan(lnutil.OutPointToBytes(s.WantOp))
+	if err != nil {
+		return nil, nil, err
+	}
+	if give.Peer() != s.PeerIdx || want.Peer() != s.PeerIdx {
+		return nil, nil, fmt.Errorf("swap channels aren't with peer %d",
+			s.PeerIdx)
+	}
+	return give, want, nil
+}
+
+// OfferSwap offers to give giveAmt in one channel for wantAmt in another,
+// with the same peer.  Timeout is in seconds; 0 for the default.
+func (nd *LitNode) OfferSwap(giveIdx uint32, giveAmt int64,
+	wantIdx uint32, wantAmt int64, timeout uint32) (*Swap, error) {
+
+	give, err := nd.GetQchanByIdx(giveIdx)
+	if err != nil {
+		return nil, err
+	}
+	want, err := nd.GetQchanByIdx(wantIdx)
+	if err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = DefaultSwapTimeout
+	}
+	s := &Swap{
+		PeerIdx: give.Peer(),
+		Offerer: true,
+		GiveOp:  give.Op,
+		GiveAmt: giveAmt,
+		WantOp:  want.Op,
+		WantAmt: wantAmt,
+		Timeout: timeout,
+		Status:  SwapOffered,
+	}
+	err = nd.checkSwap(give, want, s, true)
+	if err != nil {
+		return nil, err
+	}
+	if !nd.peerHasHTLCs(s.PeerIdx) {
+		return nil, fmt.Errorf("peer %d isn't connected or doesn't do HTLCs",
+			s.PeerIdx)
+	}
+
+	_, err = rand.Read(s.R[:])
+	if err != nil {
+		return nil, err
+	}
+	s.RHash = sha256.Sum256(s.R[:])
+
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return nil, err
+	}
+
+	nd.OmniOut <- lnutil.SwapOfferMsg{
+		PeerIdx:      s.PeerIdx,
+		RHash:        s.RHash,
+		GiveOutpoint: s.GiveOp,
+		GiveAmt:      s.GiveAmt,
+		WantOutpoint: s.WantOp,
+		WantAmt:      s.WantAmt,
+		Timeout:      s.Timeout,
+	}
+	return s, nil
+}
+
+// SwapOfferHandler saves a swap offer for the user to accept or decline.
+// Offers that can't work are declined right away.
+func (nd *LitNode) SwapOfferHandler(msg lnutil.SwapOfferMsg, peer *RemotePeer) error {
+	s := &Swap{
+		RHash:   msg.RHash,
+		PeerIdx: peer.Idx,
+		GiveOp:  msg.GiveOutpoint,
+		GiveAmt: msg.GiveAmt,
+		WantOp:  msg.WantOutpoint,
+		WantAmt: msg.WantAmt,
+		Timeout: msg.Timeout,
+		Status:  SwapOffered,
+	}
+
+	_, err := nd.GetSwap(s.RHash)
+	if err == nil {
+		return fmt.Errorf("SwapOfferHandler: already have swap %x", s.RHash)
+	}
+
+	give, want, err := nd.swapChans(s)
+	if err == nil {
+		err = nd.checkSwap(give, want, s, false)
+	}
+	if err != nil {
+		nd.OmniOut <- lnutil.NewSwapReplyMsg(peer.Idx, s.RHash, false)
+		return fmt.Errorf("SwapOfferHandler: declined %x: %s",
+			s.RHash, err.Error())
+	}
+
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return err
+	}
+
+	nd.UserMessageBox <- fmt.Sprintf(
+		"\nswap offer from %d: %s in channel %d for %s in channel %d; swap %x",
+		peer.Idx, lnutil.SatoshiColor(s.GiveAmt), give.Idx(),
+		lnutil.SatoshiColor(s.WantAmt), want.Idx(), s.RHash)
+	return nil
+}
+
+// ReplySwap accepts or declines a swap we were offered.
+func (nd *LitNode) ReplySwap(rHash [32]byte, accept bool) error {
+	s, err := nd.GetSwap(rHash)
+	if err != nil {
+		return err
+	}
+	if s.Offerer || s.Status != SwapOffered {
+		return fmt.Errorf("swap %x is %s; can't reply", rHash, s.StatusString())
+	}
+
+	if accept {
+		give, want, err := nd.swapChans(s)
+		if err != nil {
+			return err
+		}
+		// balances may have moved since the offer
+		err = nd.checkSwap(give, want, s, false)
+		if err != nil {
+			return err
+		}
+		s.Status = SwapAccepted
+	} else {
+		s.Status = SwapDeclined
+	}
+
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return err
+	}
+	nd.OmniOut <- lnutil.NewSwapReplyMsg(s.PeerIdx, rHash, accept)
+	return nil
+}
+
+// SwapReplyHandler handles the peer accepting or declining our offer.  If
+// they accept, our HTLC goes in.
+func (nd *LitNode) SwapReplyHandler(msg lnutil.SwapReplyMsg, peer *RemotePeer) error {
+	s, err := nd.GetSwap(msg.RHash)
+	if err != nil {
+		return fmt.Errorf("SwapReplyHandler: %s", err.Error())
+	}
+	if !s.Offerer || s.PeerIdx != peer.Idx || s.Status != SwapOffered {
+		return fmt.Errorf("SwapReplyHandler: unexpected reply for swap %x",
+			msg.RHash)
+	}
+
+	if !msg.Accept {
+		s.Status = SwapDeclined
+		nd.UserMessageBox <- fmt.Sprintf(
+			"\npeer %d declined swap %x", peer.Idx, s.RHash)
+		return nd.SaveSwap(s)
+	}
+
+	s.Status = SwapAccepted
+	err = nd.SaveSwap(s)
+	if err != nil {
+		return err
+	}
+
+	give, err := nd.GetQchan(lnutil.OutPointToBytes(s.GiveOp))
+	if err != nil {
+		return err
+	}
+	wal, ok := nd.SubWallet[give.Coin()]
+	if !ok {
+		return fmt.Errorf("not connected to coin type %d", give.Coin())
+	}
+	h := HTLC{
+		Amt:   s.GiveAmt,
+		RHash: s.RHash,
+		Locktime: uint32(int64(wal.CurrentHeight()) +
+			swapBlocks(wal.Params(), int64(s.Timeout))),
+	}
+	go func() {
+		err := nd.swapHTLC(s.PeerIdx, s.GiveOp, lnutil.HTLCOpAdd, h)
+		if err != nil {
+			log.Errorf("swap %x: add HTLC: %s\n", s.RHash, err.Error())
+		}
+	}()
+	return nil
+}
+
+// swapHTLC does an HTLC op for a swap, trying again a few times if it
+// collides with something else on the channel.
+func (nd *LitNode) swapHTLC(peerIdx uint32, chanOp wire.OutPoint,
+	op uint8, h HTLC) error {
+
+	qc, err := nd.liveQchan(peerIdx, chanOp)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < swapTries; i++ {
+		err = nd.HTLCChannel(qc, op, h)
+		if err == nil {
+			return nil
+		}
+		log.Errorf("HTLC op %d on channel %d: %s\n", op, qc.Idx(), err.Error())
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+// peerLocktime is the locktime for the peer's HTLC in a swap: half the time
+// the offerer's HTLC has left, in the want coin's blocks.
+func (nd *LitNode) peerLocktime(s *Swap, offererLocktime uint32) (uint32, error) {
+	give, want, err := nd.swapChans(s)
+	if err != nil {
+		return 0, err
+	}
+	giveWal, ok := nd.SubWallet[give.Coin()]
+	if !ok {
+		return 0, fmt.Errorf("not connected to coin type %d", give.Coin())
+	}
+	wantWal, ok := nd.SubWallet[want.Coin()]
+	if !ok {
+		return 0, fmt.Errorf("not connected to coin type %d", want.Coin())
+	}
+	left := int64(offererLocktime) - int64(giveWal.CurrentHeight())
+	secs := left * int64(giveWal.Params().TargetTimePerBlock/time.Second)
+	blocks := swapBlocks(wantWal.Params(), secs/2)
+	return uint32(int64(wantWal.CurrentHeight()) + blocks), nil
+}
+
+// swapStep moves a swap along after an HTLC op goes through on one of its
+// channels.  HTLCs we're offered that aren't for a swap we're in get
+// failed back.
+func (nd *LitNode) swapStep(peerIdx uint32, chanOp wire.OutPoint,
+	op uint8, h HTLC) {
+
+	ss, err := nd.GetSubSwap(h.RHash)
+	if err == nil && ss.PeerIdx == peerIdx {
+		nd.subSwapStep(ss, chanOp, op, h)
+		return
+	}
+
+	s, err := nd.GetSwap(h.RHash)
+	if err != nil || s.PeerIdx != peerIdx {
+		if op == lnutil.HTLCOpAdd && h.Incoming {
+			log.Errorf("HTLC %x isn't for a swap; failing it\n", h.RHash)
+			err = nd.swapHTLC(peerIdx, chanOp, lnutil.HTLCOpFail, h)
+			if err != nil {
+				log.Errorf("fail HTLC %x: %s\n", h.RHash, err.Error())
+			}
+		}
+		return
+	}
+
+	onGive := lnutil.OutPointsEqual(chanOp, s.GiveOp)
+	onWant := lnutil.OutPointsEqual(chanOp, s.WantOp)
+
+	switch {
+	// peer: the offerer's HTLC is in, so add ours
+	case op == lnutil.HTLCOpAdd && h.Incoming && onGive && !s.Offerer:
+		if s.Status != SwapAccepted || h.Amt < s.GiveAmt {
+			log.Errorf("swap %x is %s, HTLC %d of %d; failing it\n",
+				s.RHash, s.StatusString(), h.Amt, s.GiveAmt)
+			err = nd.swapHTLC(peerIdx, chanOp, lnutil.HTLCOpFail, h)
+			break
+		}
+		var lt uint32
+		lt, err = nd.peerLocktime(s, h.Locktime)
+		if err == nil {
+			err = nd.swapHTLC(peerIdx, s.WantOp, lnutil.HTLCOpAdd,
+				HTLC{Amt: s.WantAmt, RHash: s.RHash, Locktime: lt})
+		}
+		if err != nil {
+			// can't add ours, so give theirs back
+			log.Errorf("swap %x: add HTLC: %s\n", s.RHash, err.Error())
+			err = nd.swapHTLC(peerIdx, chanOp, lnutil.HTLCOpFail, h)
+		}
+
+	case op == lnutil.HTLCOpAdd && !h.Incoming && onWant && !s.Offerer:
+		s.Status = SwapLocked
+		err = nd.SaveSwap(s)
+
+	// offerer: the peer's HTLC is in, so take it, which shows them R
+	case op == lnutil.HTLCOpAdd && h.Incoming && onWant && s.Offerer:
+		if s.Status != SwapAccepted || h.Amt < s.WantAmt {
+			log.Errorf("swap %x is %s, HTLC %d of %d; failing it\n",
+				s.RHash, s.StatusString(), h.Amt, s.WantAmt)
+			err = nd.swapHTLC(peerIdx, chanOp, lnutil.HTLCOpFail, h)
+			break
+		}
+		s.Status = SwapLocked
+		err = nd.SaveSwap(s)
+		if err == nil {
+			h.R = s.R
+			err = nd.swapHTLC(peerIdx, chanOp, lnutil.HTLCOpSettle, h)
+		}
+
+	// peer: the offerer took ours, so now we know R and take theirs
+	case op == lnutil.HTLCOpSettle && !h.Incoming && onWant && !s.Offerer:
+		s.R = h.R
+		err = nd.SaveSwap(s)
+		if err == nil {
+			err = nd.swapHTLC(peerIdx, s.GiveOp, lnutil.HTLCOpSettle,
+				HTLC{R: h.R})
+		}
+
+	case op == lnutil.HTLCOpSettle && h.Incoming && (onGive || onWant):
+		s.Status = SwapDone
+		err = nd.SaveSwap(s)
+		nd.UserMessageBox <- fmt.Sprintf("\nswap %x done", s.RHash)
+
+	case op == lnutil.HTLCOpFail && (onGive || onWant):
+		if s.Status == SwapDone {
+			break
+		}
+		s.Status = SwapRefunded
+		err = nd.SaveSwap(s)
+		nd.UserMessageBox <- fmt.Sprintf("\nswap %x refunded", s.RHash)
+
+	case op == lnutil.HTLCOpAdd && h.Incoming:
+		// for the swap, but not on the channel we'd take it in
+		log.Errorf("swap %x HTLC on the wrong channel; failing it\n", s.RHash)
+		err = nd.swapHTLC(peerIdx, chanOp, lnutil.HTLCOpFail, h)
+	}
+
+	if err != nil {
+		log.Errorf("swap %x: %s\n", s.RHash, err.Error())
+	}
+}
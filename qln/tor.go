@@ -0,0 +1,121 @@
+package qln
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/mit-dci/lit/tor"
+	"golang.org/x/net/proxy"
+)
+
+// streamIsolation returns the SOCKS5 credentials to use for connections about
+// "who" (a peer's ln address, or "tracker").  Tor ignores what they say, but
+// with its default IsolateSOCKSAuth it won't share circuits between streams
+// with different credentials, so a guard or exit can't tie all our peer
+// connections together.  Other SOCKS5 proxies that don't need auth ignore it.
+func streamIsolation(who string) *proxy.Auth {
+	return &proxy.Auth{User: "lit-" + who, Password: "lit"}
+}
+
+// torController returns the node's tor control connection, connecting and
+// authenticating first if needed.
+func (nd *LitNode) torController() (*tor.Controller, error) {
+	nd.TorMtx.Lock()
+	defer nd.TorMtx.Unlock()
+
+	if nd.TorCtl != nil {
+		return nd.TorCtl, nil
+	}
+	if nd.TorControl == "" {
+		return nil, fmt.Errorf("no tor control port configured")
+	}
+
+	ctl, err := tor.Dial(nd.TorControl)
+	if err != nil {
+		return nil, err
+	}
+	err = ctl.Authenticate(nd.TorPassword)
+	if err != nil {
+		ctl.Close()
+		return nil, err
+	}
+	log.Debugf("connected to tor control port %s\n", nd.TorControl)
+
+	nd.TorCtl = ctl
+	return ctl, nil
+}
+
+// MakeOnion puts a v3 onion service in front of a local LNDC listener and
+// returns the onion host:port it can be reached at.  The onion key is saved
+// in the lit folder (one per port) so the address survives restarts.
+func (nd *LitNode) MakeOnion(lisAdr net.Addr) (string, error) {
+	tcpAdr, ok := lisAdr.(*net.TCPAddr)
+	if !ok {
+		return "", fmt.Errorf("MakeOnion: %s isn't a TCP address", lisAdr)
+	}
+
+	ctl, err := nd.torController()
+	if err != nil {
+		return "", err
+	}
+
+	port := uint16(tcpAdr.Port)
+	keyFileName := filepath.Join(nd.LitFolder, fmt.Sprintf("onion%d.key", port))
+
+	// not there the first time, which is fine; tor makes a new key
+	keyBytes, _ := ioutil.ReadFile(keyFileName)
+	oldKey := strings.TrimSpace(string(keyBytes))
+
+	// tor connects to us locally, wherever the listener is bound
+	target := fmt.Sprintf("127.0.0.1:%d", port)
+	if tcpAdr.IP != nil && !tcpAdr.IP.IsUnspecified() {
+		target = net.JoinHostPort(tcpAdr.IP.String(), fmt.Sprintf("%d", port))
+	}
+
+	onion, err := ctl.AddOnion(oldKey, port, target)
+	if err != nil {
+		return "", err
+	}
+
+	if oldKey == "" {
+		err = ioutil.WriteFile(keyFileName, []byte(onion.PrivateKey), 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	log.Infof("onion service %s -> %s\n", onion.Adr(), target)
+	return onion.Adr(), nil
+}
+
+// removeOnion takes down an onion service made with MakeOnion.  The key
+// stays saved, so the same address comes back if we listen there again.
+func (nd *LitNode) removeOnion(serviceID string) error {
+	ctl, err := nd.torController()
+	if err != nil {
+		return err
+	}
+	return ctl.DelOnion(serviceID)
+}
+
+// TorCircuits returns the tor daemon's current circuits.
+func (nd *LitNode) TorCircuits() ([]tor.Circuit, error) {
+	ctl, err := nd.torController()
+	if err != nil {
+		return nil, err
+	}
+	return ctl.Circuits()
+}
+
+// TorNewIdentity has tor switch to new circuits, so connections made from
+// now on can't be linked with earlier ones.
+func (nd *LitNode) TorNewIdentity() error {
+	ctl, err := nd.torController()
+	if err != nil {
+		return err
+	}
+	return ctl.NewIdentity()
+}
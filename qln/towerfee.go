@@ -0,0 +1,360 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+const (
+	// DefaultTowerBudget is the most we pay any one tower, in satoshis,
+	// unless set with --towerbudget.
+	DefaultTowerBudget = 10000
+	// towerTermsWait is how long to wait for a tower to say what it
+	// charges.  Without an answer we don't know if it's been paid, so
+	// nothing gets sent to it.
+	towerTermsWait = 10 * time.Second
+	// maxTowerTerm is the most a tower can charge per channel or state
+	maxTowerTerm = 1 << 24
+)
+
+// pushes with this in the data field are paying for a watchtower
+var towerFeeData = [32]byte{
+	'w', 'a', 't', 'c', 'h', 't', 'o', 'w', 'e', 'r', ' ', 'f', 'e', 'e'}
+
+// TowerTermsHandler answers clients asking what our tower charges, and
+// remembers what towers we asked told us.
+func (nd *LitNode) TowerTermsHandler(msg lnutil.WatchTermsMsg) {
+	if !msg.Request {
+		if msg.PerChannel < 0 || msg.PerChannel > maxTowerTerm ||
+			msg.PerState < 0 || msg.PerState > maxTowerTerm {
+			log.Errorf("tower %d says it charges %d per channel, %d per state\n",
+				msg.Peer(), msg.PerChannel, msg.PerState)
+			return
+		}
+		nd.towerTermsMtx.Lock()
+		nd.towerTerms[msg.Peer()] = msg
+		// anyone waiting on them can go
+		ch, ok := nd.towerTermsCh[msg.Peer()]
+		if ok {
+			close(ch)
+			delete(nd.towerTermsCh, msg.Peer())
+		}
+		nd.towerTermsMtx.Unlock()
+		nd.towerContact(msg.Peer())
+		log.Infof("tower %d charges %d per channel, %d per state; %d credit\n",
+			msg.Peer(), msg.PerChannel, msg.PerState, msg.Balance)
+		return
+	}
+	nd.sendTowerTerms(msg.Peer())
+}
+
+// sendTowerTerms tells a client what we charge and what they have left.
+func (nd *LitNode) sendTowerTerms(peerIdx uint32) {
+	acct, err := nd.Tower.GetAccount(peerIdx)
+	if err != nil {
+		log.Errorf("tower terms for peer %d: %s\n", peerIdx, err.Error())
+		return
+	}
+	terms := nd.Tower.GetTerms()
+	nd.OmniOut <- lnutil.NewWatchTermsMsg(peerIdx, false,
+		terms.PerChannel, terms.PerState, acct.Balance())
+}
+
+// TowerMsgHandler gives watch messages to the tower, and acks the states it
+// saves.  If the tower won't take them, say, because the client is out of
+// credit, it tells the client where they stand.
+func (nd *LitNode) TowerMsgHandler(msg lnutil.LitMsg) {
+	var err error
+	var ack *lnutil.WatchAckMsg
+	switch msg.MsgType() {
+	case lnutil.MSGID_WATCH_DESC:
+		err = nd.Tower.NewChannel(msg.(lnutil.WatchDescMsg))
+	case lnutil.MSGID_WATCH_STATEMSG:
+		sm := msg.(lnutil.WatchStateMsg)
+		err = nd.Tower.UpdateChannel(sm)
+		a := lnutil.NewWatchAckMsg(sm.Peer(), sm.ParTxid)
+		ack = &a
+	case lnutil.MSGID_WATCH_DELETE:
+		err = nd.Tower.DeleteChannel(msg.(lnutil.WatchDelMsg))
+	case lnutil.MSGID_WATCH_BLOB:
+		bm := msg.(lnutil.WatchBlobMsg)
+		err = nd.Tower.AddBlob(bm)
+		a := lnutil.NewWatchAckMsg(bm.Peer(), bm.Hint)
+		ack = &a
+	case lnutil.MSGID_WATCH_BLOBDEL:
+		err = nd.Tower.DeleteBlobs(msg.(lnutil.WatchBlobDelMsg))
+	case lnutil.MSGID_WATCH_ACK:
+		nd.WatchAckHandler(msg.(lnutil.WatchAckMsg))
+		return
+	case lnutil.MSGID_WATCH_TERMSREQ, lnutil.MSGID_WATCH_TERMS:
+		nd.TowerTermsHandler(msg.(lnutil.WatchTermsMsg))
+		return
+	}
+	if err != nil {
+		log.Errorf("tower msg %x from peer %d: %s\n",
+			msg.MsgType(), msg.Peer(), err.Error())
+		nd.sendTowerTerms(msg.Peer())
+		return
+	}
+	if ack != nil {
+		nd.OmniOut <- *ack
+	}
+}
+
+// towerPaid credits a client's tower account when a push to us finishes,
+// if it was tagged as a tower fee.
+func (nd *LitNode) towerPaid(qc *Qchan, amt int64) {
+	if amt <= 0 || qc.State.Data != towerFeeData {
+		return
+	}
+	err := nd.Tower.Credit(qc.Peer(), amt,
+		lnutil.OutPointToBytes(qc.Op), qc.State.StateIdx)
+	if err != nil {
+		log.Errorf("tower fee from peer %d: %s\n", qc.Peer(), err.Error())
+		return
+	}
+	nd.sendTowerTerms(qc.Peer())
+}
+
+// AskTowerTerms asks a tower what it charges.  The answer comes back later.
+func (nd *LitNode) AskTowerTerms(towerPeer uint32) error {
+	if !nd.ConnectedToPeer(towerPeer) {
+		return fmt.Errorf("not connected to peer %d", towerPeer)
+	}
+	nd.OmniOut <- lnutil.NewWatchTermsMsg(towerPeer, true, 0, 0, 0)
+	return nil
+}
+
+// TowerPaid returns how much we've paid a tower in total.
+func (nd *LitNode) TowerPaid(towerPeer uint32) (int64, error) {
+	var paid int64
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTTwrPaid)
+		if bkt == nil {
+			return fmt.Errorf("no tower fee bucket")
+		}
+		v := bkt.Get(lnutil.U32tB(towerPeer))
+		if v != nil {
+			paid = lnutil.BtI64(v)
+		}
+		return nil
+	})
+	return paid, err
+}
+
+func (nd *LitNode) addTowerPaid(towerPeer uint32, amt int64) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTTwrPaid)
+		if bkt == nil {
+			return fmt.Errorf("no tower fee bucket")
+		}
+		var paid int64
+		v := bkt.Get(lnutil.U32tB(towerPeer))
+		if v != nil {
+			paid = lnutil.BtI64(v)
+		}
+		return bkt.Put(lnutil.U32tB(towerPeer), lnutil.I64tB(paid+amt))
+	})
+}
+
+// PayTower pushes amt to a tower in a channel we have with it, as long as
+// that keeps us under TowerBudget.
+func (nd *LitNode) PayTower(towerPeer uint32, amt int64) error {
+	if amt <= 0 {
+		return fmt.Errorf("have to pay a non-zero amount")
+	}
+	if amt >= 1<<30 {
+		return fmt.Errorf("paying tower %d %d; max push 1G sat", towerPeer, amt)
+	}
+	paid, err := nd.TowerPaid(towerPeer)
+	if err != nil {
+		return err
+	}
+	if paid+amt > nd.TowerBudget {
+		return fmt.Errorf("paying tower %d %d would go over budget (%d of %d spent)",
+			towerPeer, amt, paid, nd.TowerBudget)
+	}
+
+	peer, ok := nd.GetPeer(towerPeer)
+	var qc *Qchan
+	if ok {
+		for _, q := range peer.qchans() {
+			if !q.CloseData.Closed &&
+				q.State.MyAmt-q.State.Fee-consts.MinOutput >= amt {
+				qc = q
+				break
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("not connected to tower %d", towerPeer)
+	}
+	if qc == nil {
+		return fmt.Errorf("no channel with tower %d can pay %d", towerPeer, amt)
+	}
+
+	// height isn't kept up to date in ram; same as the push command
+	dummyqc, err := nd.GetQchanByIdx(qc.Idx())
+	if err != nil {
+		return err
+	}
+	qc.Height = dummyqc.Height
+
+	err = nd.PushChannel(qc, uint32(amt), towerFeeData)
+	if err != nil {
+		return err
+	}
+	err = nd.addTowerPaid(towerPeer, amt)
+	if err != nil {
+		return err
+	}
+
+	nd.towerTermsMtx.Lock()
+	terms, ok := nd.towerTerms[towerPeer]
+	if ok {
+		terms.Balance += amt
+		nd.towerTerms[towerPeer] = terms
+	}
+	nd.towerTermsMtx.Unlock()
+	return nil
+}
+
+// payForWatch makes sure a tower has been paid for a new channel (if
+// newChan) plus some states before we send them, topping it up if it needs
+// more.  If we don't know the tower's terms yet, we ask and wait for them.
+func (nd *LitNode) payForWatch(towerPeer uint32, newChan bool, states uint64) error {
+	terms, err := nd.getTowerTerms(towerPeer)
+	if err != nil {
+		return err
+	}
+
+	if states > maxTowerTerm {
+		return fmt.Errorf("paying tower %d for %d states at once", towerPeer, states)
+	}
+	cost := terms.PerState * int64(states)
+	if newChan {
+		cost += terms.PerChannel
+	}
+	if cost == 0 {
+		return nil
+	}
+	if cost > terms.Balance {
+		err := nd.PayTower(towerPeer, cost-terms.Balance)
+		if err != nil {
+			return err
+		}
+	}
+
+	nd.towerTermsMtx.Lock()
+	terms = nd.towerTerms[towerPeer]
+	terms.Balance -= cost
+	nd.towerTerms[towerPeer] = terms
+	nd.towerTermsMtx.Unlock()
+	return nil
+}
+
+// getTowerTerms gives what a tower charges, asking it if we don't know.
+// A tower that doesn't answer in towerTermsWait is an error; we can't tell
+// if it's been paid.
+func (nd *LitNode) getTowerTerms(towerPeer uint32) (lnutil.WatchTermsMsg, error) {
+	nd.towerTermsMtx.Lock()
+	terms, ok := nd.towerTerms[towerPeer]
+	nd.towerTermsMtx.Unlock()
+	if ok {
+		return terms, nil
+	}
+
+	// towers from before fees don't know to answer, and are free
+	if !nd.peerHasFeature(towerPeer, lnutil.FeatureTowerFeeOptional) {
+		return lnutil.NewWatchTermsMsg(towerPeer, false, 0, 0, 0), nil
+	}
+
+	nd.towerTermsMtx.Lock()
+	terms, ok = nd.towerTerms[towerPeer]
+	ch, waiting := nd.towerTermsCh[towerPeer]
+	if !ok && !waiting {
+		ch = make(chan bool)
+		nd.towerTermsCh[towerPeer] = ch
+	}
+	nd.towerTermsMtx.Unlock()
+	if ok {
+		return terms, nil
+	}
+	// the next to ask asks again
+	giveUp := func() {
+		nd.towerTermsMtx.Lock()
+		if nd.towerTermsCh[towerPeer] == ch {
+			delete(nd.towerTermsCh, towerPeer)
+		}
+		nd.towerTermsMtx.Unlock()
+	}
+	if !waiting {
+		err := nd.AskTowerTerms(towerPeer)
+		if err != nil {
+			giveUp()
+			return terms, err
+		}
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(towerTermsWait):
+		giveUp()
+		return terms, fmt.Errorf("tower %d didn't say what it charges", towerPeer)
+	}
+	nd.towerTermsMtx.Lock()
+	terms = nd.towerTerms[towerPeer]
+	nd.towerTermsMtx.Unlock()
+	return terms, nil
+}
+
+// TowerFeeInfo is what we know about our spending on one tower.
+type TowerFeeInfo struct {
+	PeerIdx    uint32
+	Paid       int64 // total, ever
+	PerChannel int64
+	PerState   int64
+	Balance    int64 // credit the tower last said we have, less what we've used
+}
+
+// TowerFees lists the towers we've paid or asked about.
+func (nd *LitNode) TowerFees() ([]TowerFeeInfo, error) {
+	infos := make(map[uint32]*TowerFeeInfo)
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTTwrPaid)
+		if bkt == nil {
+			return fmt.Errorf("no tower fee bucket")
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			infos[lnutil.BtU32(k)] = &TowerFeeInfo{
+				PeerIdx: lnutil.BtU32(k), Paid: lnutil.BtI64(v)}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nd.towerTermsMtx.Lock()
+	for idx, terms := range nd.towerTerms {
+		info, ok := infos[idx]
+		if !ok {
+			info = &TowerFeeInfo{PeerIdx: idx}
+			infos[idx] = info
+		}
+		info.PerChannel = terms.PerChannel
+		info.PerState = terms.PerState
+		info.Balance = terms.Balance
+	}
+	nd.towerTermsMtx.Unlock()
+
+	var list []TowerFeeInfo
+	for _, info := range infos {
+		list = append(list, *info)
+	}
+	return list, nil
+}
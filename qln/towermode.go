@@ -0,0 +1,108 @@
+package qln
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mit-dci/lit/chainhook"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// towerOnlyMsg says whether a tower-only node deals with a message type.
+// It has no wallets or channels, so it only chats, watches, and is
+// remote controlled.
+func towerOnlyMsg(msgType uint8) bool {
+	return msgType&0xf0 == 0x00 || msgType&0xf0 == 0x60 ||
+		msgType&0xf0 == 0xB0
+}
+
+// LinkTowerHook connects the watchtower to a blockchain without a wallet,
+// for nodes that are only towers.  Blocks come in and justice txs go out;
+// there are no keys to watch for, so txs the hook finds are ignored.
+func (nd *LitNode) LinkTowerHook(
+	birthHeight int32, resync bool, host string, param *coinparam.Params) error {
+
+	if birthHeight < param.StartHeight {
+		return fmt.Errorf("%s birth height give as %d, but parameters start at %d",
+			param.Name, birthHeight, param.StartHeight)
+	}
+
+	hookpath := filepath.Join(nd.LitFolder, param.Name)
+	_, err := os.Stat(hookpath)
+	if os.IsNotExist(err) {
+		os.Mkdir(hookpath, 0700)
+	}
+
+	// same kind of chainhook as the wallit would use, but it has to give
+	// whole blocks
+	if !chainhook.GivesBlocks(host) {
+		return fmt.Errorf("the tower needs blocks, which %s chainhooks don't give",
+			chainhook.BackendFor(host))
+	}
+	hook := chainhook.New(host, 0)
+
+	// start where we left off, unless this is the first time or a resync
+	height, err := nd.towerSyncHeight(param.HDCoinType)
+	if err != nil {
+		return err
+	}
+	if height < birthHeight || resync {
+		height = birthHeight
+	}
+
+	// link before starting, so the tower gets blocks from the start height
+	err = nd.Tower.HookLink(nd.LitFolder, param, hook)
+	if err != nil {
+		return err
+	}
+	incomingTx, incomingHeight, err := hook.Start(height, host, hookpath, param)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for range incomingTx {
+		}
+	}()
+	go func() {
+		for h := range incomingHeight {
+			err := nd.saveTowerSyncHeight(param.HDCoinType, h)
+			if err != nil {
+				log.Errorf("tower sync height: %s\n", err.Error())
+			}
+		}
+	}()
+
+	nd.TowerOnly = true
+	return nil
+}
+
+// towerSyncHeight is how far a tower-only node has watched a coin's chain.
+func (nd *LitNode) towerSyncHeight(cointype uint32) (int32, error) {
+	var height int32
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTTwrSync)
+		if bkt == nil {
+			return fmt.Errorf("no tower sync bucket")
+		}
+		v := bkt.Get(lnutil.U32tB(cointype))
+		if v != nil {
+			height = lnutil.BtI32(v)
+		}
+		return nil
+	})
+	return height, err
+}
+
+func (nd *LitNode) saveTowerSyncHeight(cointype uint32, height int32) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTTwrSync)
+		if bkt == nil {
+			return fmt.Errorf("no tower sync bucket")
+		}
+		return bkt.Put(lnutil.U32tB(cointype), lnutil.I32tB(height))
+	})
+}
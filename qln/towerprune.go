@@ -0,0 +1,150 @@
+package qln
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// DefaultWatchRetain is how many blocks we keep watch data for a closed
+// channel, after its outputs are safe, in case of a reorg.
+const DefaultWatchRetain = 144
+
+// how often to look for closed channels whose watch data can go
+const watchPruneInterval = 10 * time.Minute
+
+// most hints to put in one blob delete message
+const maxDelHints = 4096
+
+// PruneWatchLoop keeps deleting watch data for closed channels, ours and
+// the towers'.  Doesn't return.
+func (nd *LitNode) PruneWatchLoop() {
+	ticker := time.NewTicker(watchPruneInterval)
+	for range ticker.C {
+		err := nd.PruneClosedWatch()
+		if err != nil {
+			log.Errorf("PruneClosedWatch: %s\n", err.Error())
+		}
+	}
+}
+
+// PruneClosedWatch tells towers to delete channels that closed long enough
+// ago, then deletes our own justice data for them.  Once the close tx is
+// buried, no revoked state can show up, so nobody needs to watch.  Towers
+// we aren't connected to are tried again next time, and we keep our data
+// until all of them have been told.
+func (nd *LitNode) PruneClosedWatch() error {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		return err
+	}
+	for _, qc := range qcs {
+		if !qc.CloseData.Closed || qc.CloseData.CloseHeight == 0 {
+			continue
+		}
+		wal, ok := nd.SubWallet[qc.Coin()]
+		if !ok {
+			continue
+		}
+		// a break isn't safe until its timeout outputs are spendable
+		safe := qc.CloseData.CloseHeight + int32(qc.Delay) + nd.WatchRetain
+		if wal.CurrentHeight() < safe {
+			continue
+		}
+
+		towers, err := nd.ChanTowers(qc.Idx())
+		if err != nil {
+			return err
+		}
+		left := len(towers)
+		for towerPeer, tp := range towers {
+			if !nd.ConnectedToPeer(towerPeer) {
+				continue
+			}
+			err = nd.sendWatchDelete(qc, towerPeer, tp.Sent)
+			if err != nil {
+				log.Errorf("delete channel %d from tower %d: %s\n",
+					qc.Idx(), towerPeer, err.Error())
+				continue
+			}
+			err = nd.RemoveChanTower(qc.Idx(), towerPeer)
+			if err != nil {
+				return err
+			}
+			left--
+		}
+		if left != 0 {
+			continue
+		}
+		err = nd.pruneJustice(qc.Idx(), qc.WatchRefundAdr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendWatchDelete tells a tower it can delete the states it has for a
+// channel: the ones before next.
+func (nd *LitNode) sendWatchDelete(qc *Qchan, towerPeer uint32, next uint64) error {
+	// if state 0 has no blob, the channel started before blobs, and the
+	// tower was told about it with a description.  Reveal the refund pubkey
+	// to show the channel's ours.
+	_, _, err := nd.LoadWatchBlob(0, qc.WatchRefundAdr)
+	if err != nil {
+		revealPK, err := nd.GetUsePub(qc.KeyGen, UseChannelWatchRefund)
+		if err != nil {
+			return err
+		}
+		nd.OmniOut <- lnutil.NewWatchDelMsg(towerPeer, qc.WatchRefundAdr, revealPK)
+	}
+
+	var hints [][16]byte
+	for idx := uint64(0); idx < next; idx++ {
+		hint, _, err := nd.LoadWatchBlob(idx, qc.WatchRefundAdr)
+		if err != nil {
+			continue // sent the old way
+		}
+		hints = append(hints, hint)
+		if len(hints) == maxDelHints {
+			nd.OmniOut <- lnutil.NewWatchBlobDelMsg(towerPeer, hints)
+			hints = nil
+		}
+	}
+	if len(hints) != 0 {
+		nd.OmniOut <- lnutil.NewWatchBlobDelMsg(towerPeer, hints)
+	}
+	nd.towerContact(towerPeer)
+	return nil
+}
+
+// pruneJustice deletes the justice sigs and blobs we kept for towers, and
+// the channel's tower list.
+func (nd *LitNode) pruneJustice(cIdx uint32, pkh [20]byte) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		for _, name := range [][]byte{BKTWatch, BKTBlobs} {
+			bkt := btx.Bucket(name)
+			if bkt == nil {
+				return fmt.Errorf("no %s bucket", name)
+			}
+			if bkt.Bucket(pkh[:]) == nil {
+				continue
+			}
+			log.Infof("pruning %s data for channel %d\n", name, cIdx)
+			err := bkt.DeleteBucket(pkh[:])
+			if err != nil {
+				return err
+			}
+		}
+		twrs := btx.Bucket(BKTTowers)
+		if twrs == nil {
+			return fmt.Errorf("no tower bucket")
+		}
+		if twrs.Bucket(lnutil.U32tB(cIdx)) == nil {
+			return nil
+		}
+		return twrs.DeleteBucket(lnutil.U32tB(cIdx))
+	})
+}
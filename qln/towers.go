@@ -0,0 +1,393 @@
+package qln
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A channel can be watched by several towers at once, so that one of them
+going offline doesn't leave the channel undefended.  For each channel we
+keep the towers it's registered with, the next state each tower needs,
+and how many of the states we sent it has said it got.  A tower that's
+just been added needs state 0, so it gets everything.
+
+BKTTowers
+ChanIdx (lots)
+  |
+  |-TowerPeerIdx : TowerProgress (16 bytes)
+*/
+
+// TowerProgress is how far along a tower is with one channel.
+type TowerProgress struct {
+	Sent  uint64 // we've sent every state below this
+	Acked uint64 // the tower has acked every state below this
+}
+
+func (tp TowerProgress) Bytes() []byte {
+	return append(lnutil.U64tB(tp.Sent), lnutil.U64tB(tp.Acked)...)
+}
+
+func towerProgressFromBytes(b []byte) TowerProgress {
+	var tp TowerProgress
+	tp.Sent = lnutil.BtU64(b)
+	if len(b) >= 16 {
+		tp.Acked = lnutil.BtU64(b[8:])
+	} else {
+		// from before acks; nothing to go on but what we sent
+		tp.Acked = tp.Sent
+	}
+	return tp
+}
+
+// ChanTowers returns the towers a channel is registered with, and how far
+// along each of them is.
+func (nd *LitNode) ChanTowers(cIdx uint32) (map[uint32]TowerProgress, error) {
+	towers := make(map[uint32]TowerProgress)
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		twrs := btx.Bucket(BKTTowers)
+		if twrs == nil {
+			return fmt.Errorf("no tower bucket")
+		}
+		chanBkt := twrs.Bucket(lnutil.U32tB(cIdx))
+		if chanBkt == nil {
+			return nil
+		}
+		return chanBkt.ForEach(func(k, v []byte) error {
+			towers[lnutil.BtU32(k)] = towerProgressFromBytes(v)
+			return nil
+		})
+	})
+	return towers, err
+}
+
+// updateTowerProgress changes how far along a tower is with a channel, in
+// one db transaction so that acks and sends don't step on each other.  If
+// change returns false, nothing is saved.
+func (nd *LitNode) updateTowerProgress(cIdx, towerPeer uint32,
+	change func(*TowerProgress) bool) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		twrs := btx.Bucket(BKTTowers)
+		if twrs == nil {
+			return fmt.Errorf("no tower bucket")
+		}
+		chanBkt := twrs.Bucket(lnutil.U32tB(cIdx))
+		if chanBkt == nil {
+			return fmt.Errorf("channel %d has no towers", cIdx)
+		}
+		v := chanBkt.Get(lnutil.U32tB(towerPeer))
+		if v == nil {
+			return fmt.Errorf("channel %d not registered with tower %d",
+				cIdx, towerPeer)
+		}
+		tp := towerProgressFromBytes(v)
+		if !change(&tp) {
+			return nil
+		}
+		return chanBkt.Put(lnutil.U32tB(towerPeer), tp.Bytes())
+	})
+}
+
+// registerTower registers a channel with a tower, if it isn't already.
+// Channels from before towers were kept track of one by one sent their
+// states to one tower, and only kept how far they'd got, in WatchUpTo.
+// The first tower such a channel is registered with is taken to be that
+// one, and starts from there instead of from the beginning.
+func (nd *LitNode) registerTower(qc *Qchan, towerPeer uint32) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		twrs := btx.Bucket(BKTTowers)
+		if twrs == nil {
+			return fmt.Errorf("no tower bucket")
+		}
+		var tp TowerProgress
+		chanBkt := twrs.Bucket(lnutil.U32tB(qc.Idx()))
+		if chanBkt == nil && qc.State.WatchUpTo != 0 {
+			// WatchUpTo was the last state sent
+			tp.Sent = qc.State.WatchUpTo + 1
+			tp.Acked = tp.Sent
+			log.Infof("channel %d already sent tower %d up to state %d\n",
+				qc.Idx(), towerPeer, qc.State.WatchUpTo)
+		}
+		chanBkt, err := twrs.CreateBucketIfNotExists(lnutil.U32tB(qc.Idx()))
+		if err != nil {
+			return err
+		}
+		if chanBkt.Get(lnutil.U32tB(towerPeer)) != nil {
+			return nil
+		}
+		return chanBkt.Put(lnutil.U32tB(towerPeer), tp.Bytes())
+	})
+}
+
+// AddChanTower registers a channel with a tower and sends it every state
+// it doesn't have yet.  Registering again just catches the tower up.
+func (nd *LitNode) AddChanTower(qc *Qchan, towerPeer uint32) error {
+	if qc.Peer() == towerPeer {
+		return fmt.Errorf("channel %d is with tower %d; it can't watch itself",
+			qc.Idx(), towerPeer)
+	}
+	err := nd.registerTower(qc, towerPeer)
+	if err != nil {
+		return err
+	}
+	return nd.SyncWatch(qc, towerPeer)
+}
+
+// RemoveChanTower stops sending a channel's states to a tower.  The tower
+// keeps whatever it already has.
+func (nd *LitNode) RemoveChanTower(cIdx, towerPeer uint32) error {
+	return nd.LitDB.Update(func(btx kvdb.Tx) error {
+		twrs := btx.Bucket(BKTTowers)
+		if twrs == nil {
+			return fmt.Errorf("no tower bucket")
+		}
+		chanBkt := twrs.Bucket(lnutil.U32tB(cIdx))
+		if chanBkt == nil || chanBkt.Get(lnutil.U32tB(towerPeer)) == nil {
+			return fmt.Errorf("channel %d not registered with tower %d",
+				cIdx, towerPeer)
+		}
+		return chanBkt.Delete(lnutil.U32tB(towerPeer))
+	})
+}
+
+// SyncTowers catches up every tower a channel is registered with.  Towers
+// we aren't connected to are skipped; they'll get caught up next time.
+func (nd *LitNode) SyncTowers(qc *Qchan) error {
+	towers, err := nd.ChanTowers(qc.Idx())
+	if err != nil {
+		return err
+	}
+	var failed int
+	for towerPeer, tp := range towers {
+		if tp.Sent >= qc.State.StateIdx || !nd.ConnectedToPeer(towerPeer) {
+			continue
+		}
+		err = nd.SyncWatch(qc, towerPeer)
+		if err != nil {
+			log.Errorf("sync channel %d to tower %d: %s\n",
+				qc.Idx(), towerPeer, err.Error())
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("couldn't sync channel %d to %d of %d towers",
+			qc.Idx(), failed, len(towers))
+	}
+	return nil
+}
+
+// stateHint is the first 16 bytes of the txid of a channel's revoked state,
+// which is how towers ack it.
+func (nd *LitNode) stateHint(pkh [20]byte, idx uint64) ([16]byte, error) {
+	hint, _, err := nd.LoadWatchBlob(idx, pkh)
+	if err == nil {
+		return hint, nil
+	}
+	// sent the old way
+	jtx, err := nd.LoadJusticeSig(idx, pkh)
+	if err != nil {
+		return hint, err
+	}
+	return jtx.Txid, nil
+}
+
+// WatchAckHandler marks a state as saved by the tower that acked it.
+// Towers get states in order and ack them in order, so for each channel
+// the ack should be for the first unacked state; anything else means
+// something got lost, and gets sorted out by resending next time.
+func (nd *LitNode) WatchAckHandler(msg lnutil.WatchAckMsg) {
+	nd.towerContact(msg.Peer())
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		log.Errorf("WatchAckHandler: %s\n", err.Error())
+		return
+	}
+	for _, qc := range qcs {
+		towers, err := nd.ChanTowers(qc.Idx())
+		if err != nil {
+			log.Errorf("WatchAckHandler: %s\n", err.Error())
+			return
+		}
+		tp, ok := towers[msg.Peer()]
+		if !ok || tp.Acked >= tp.Sent {
+			continue
+		}
+		hint, err := nd.stateHint(qc.WatchRefundAdr, tp.Acked)
+		if err != nil || hint != msg.Hint {
+			continue
+		}
+		acked := tp.Acked
+		err = nd.updateTowerProgress(qc.Idx(), msg.Peer(),
+			func(tp *TowerProgress) bool {
+				if tp.Acked != acked || tp.Acked >= tp.Sent {
+					return false // resent since we looked
+				}
+				tp.Acked++
+				return true
+			})
+		if err != nil {
+			log.Errorf("WatchAckHandler: %s\n", err.Error())
+		}
+		return
+	}
+	log.Infof("tower %d acked %x, which we weren't waiting for\n",
+		msg.Peer(), msg.Hint)
+}
+
+// resendWatch sends a tower that just connected everything it hasn't acked,
+// and anything it missed while it was gone.  Does nothing if the peer isn't
+// a tower of ours.
+func (nd *LitNode) resendWatch(towerPeer uint32) {
+	qcs, err := nd.GetAllQchans()
+	if err != nil {
+		log.Errorf("resendWatch: %s\n", err.Error())
+		return
+	}
+	for _, qc := range qcs {
+		if qc.CloseData.Closed {
+			continue
+		}
+		towers, err := nd.ChanTowers(qc.Idx())
+		if err != nil {
+			log.Errorf("resendWatch: %s\n", err.Error())
+			return
+		}
+		if _, ok := towers[towerPeer]; !ok {
+			continue
+		}
+		var sent uint64
+		err = nd.updateTowerProgress(qc.Idx(), towerPeer,
+			func(tp *TowerProgress) bool {
+				sent = tp.Sent
+				if tp.Acked >= tp.Sent {
+					return false
+				}
+				log.Debugf("resending channel %d states %d to %d to tower %d\n",
+					qc.Idx(), tp.Acked, tp.Sent-1, towerPeer)
+				tp.Sent = tp.Acked
+				sent = tp.Sent
+				return true
+			})
+		if err != nil {
+			log.Errorf("resendWatch: %s\n", err.Error())
+			continue
+		}
+		if sent >= qc.State.StateIdx {
+			continue
+		}
+		err = nd.SyncWatch(qc, towerPeer)
+		if err != nil {
+			log.Errorf("resendWatch channel %d: %s\n", qc.Idx(), err.Error())
+		}
+	}
+}
+
+// towerContact records that we just heard from or sent to a tower.
+func (nd *LitNode) towerContact(towerPeer uint32) {
+	err := nd.LitDB.Update(func(btx kvdb.Tx) error {
+		bkt := btx.Bucket(BKTTwrSeen)
+		if bkt == nil {
+			return fmt.Errorf("no tower contact bucket")
+		}
+		return bkt.Put(lnutil.U32tB(towerPeer), lnutil.I64tB(time.Now().Unix()))
+	})
+	if err != nil {
+		log.Errorf("towerContact: %s\n", err.Error())
+	}
+}
+
+// TowerChanStatus is how far along a tower is with one channel.
+type TowerChanStatus struct {
+	ChanIdx  uint32
+	StateIdx uint64 // the channel's current state
+	Sent     uint64 // we've sent the tower every state below this
+	Acked    uint64 // the tower has acked every state below this
+	Unacked  uint64 // states sent that the tower hasn't acked
+	Backlog  uint64 // revoked states we haven't sent the tower yet
+}
+
+// TowerStatus is what one tower has of our channels.
+type TowerStatus struct {
+	PeerIdx     uint32
+	Connected   bool
+	LastContact int64 // unix time, 0 if never
+	Channels    []TowerChanStatus
+}
+
+// TowerStatus lists every tower we've registered channels with, and what
+// each of them has.
+func (nd *LitNode) TowerStatus() ([]TowerStatus, error) {
+	byTower := make(map[uint32]*TowerStatus)
+	var chans []uint32
+	err := nd.LitDB.View(func(btx kvdb.Tx) error {
+		twrs := btx.Bucket(BKTTowers)
+		if twrs == nil {
+			return fmt.Errorf("no tower bucket")
+		}
+		seen := btx.Bucket(BKTTwrSeen)
+		if seen == nil {
+			return fmt.Errorf("no tower contact bucket")
+		}
+		return twrs.ForEach(func(k, _ []byte) error {
+			chanBkt := twrs.Bucket(k)
+			if chanBkt == nil {
+				return nil
+			}
+			cIdx := lnutil.BtU32(k)
+			chans = append(chans, cIdx)
+			return chanBkt.ForEach(func(tk, tv []byte) error {
+				towerPeer := lnutil.BtU32(tk)
+				ts, ok := byTower[towerPeer]
+				if !ok {
+					ts = &TowerStatus{PeerIdx: towerPeer}
+					if v := seen.Get(tk); v != nil {
+						ts.LastContact = lnutil.BtI64(v)
+					}
+					byTower[towerPeer] = ts
+				}
+				tp := towerProgressFromBytes(tv)
+				ts.Channels = append(ts.Channels, TowerChanStatus{
+					ChanIdx: cIdx, Sent: tp.Sent, Acked: tp.Acked})
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// fill in where each channel is now
+	states := make(map[uint32]uint64)
+	for _, cIdx := range chans {
+		qc, err := nd.GetQchanByIdx(cIdx)
+		if err != nil {
+			return nil, err
+		}
+		states[cIdx] = qc.State.StateIdx
+	}
+
+	towers := make([]TowerStatus, 0, len(byTower))
+	for _, ts := range byTower {
+		ts.Connected = nd.ConnectedToPeer(ts.PeerIdx)
+		for i, cs := range ts.Channels {
+			cs.StateIdx = states[cs.ChanIdx]
+			// the current state isn't revoked yet, so isn't backlog
+			if cs.StateIdx > cs.Sent {
+				cs.Backlog = cs.StateIdx - cs.Sent
+			}
+			if cs.Sent > cs.Acked {
+				cs.Unacked = cs.Sent - cs.Acked
+			}
+			ts.Channels[i] = cs
+		}
+		towers = append(towers, *ts)
+	}
+	sort.Slice(towers, func(i, j int) bool {
+		return towers[i].PeerIdx < towers[j].PeerIdx
+	})
+	return towers, nil
+}
@@ -0,0 +1,167 @@
+package qln
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Payment traces time each push through the state update, so a slow one can
+be put down to the peer, the network or the disk.  Every push, sent or
+received, gets a trace id, and each stage it gets through is recorded with
+when it happened:
+
+sent:     validated, saved, sigsent, sigreceived, saved, revoked, settled
+received: sigreceived, validated, saved, sigsent, revoked, saved, settled
+
+sigsent to sigreceived is the peer and the network; saved is after a db
+write.  A push that fails gets a failed stage with the error.  Traces are
+kept in memory, the newest maxTraces of them.
+*/
+
+// trace stages
+const (
+	StageValidated   = "validated"
+	StageSaved       = "saved"
+	StageSigSent     = "sigsent"
+	StageSigReceived = "sigreceived"
+	StageCollision   = "collision"
+	StageRevoked     = "revoked"
+	StageSettled     = "settled"
+	StageFailed      = "failed"
+)
+
+// maxTraces is how many traces are kept.
+const maxTraces = 1000
+
+// TraceStage is a stage a push got to.
+type TraceStage struct {
+	Stage string
+	At    int64 // unix nanoseconds
+	// Since is how long since the stage before, or the start
+	Since  time.Duration
+	Detail string `json:",omitempty"`
+}
+
+// PaymentTrace is a push's way through the state update.
+type PaymentTrace struct {
+	ID       uint64
+	Peer     uint32
+	ChanIdx  uint32
+	Amt      int64
+	Sent     bool // we pushed; otherwise they did
+	Start    int64
+	Done     bool // settled or failed
+	Stages   []TraceStage
+	lastTime time.Time
+}
+
+// paymentTraces are the traces kept, and the one in flight for each
+// channel, each way.
+type paymentTraces struct {
+	mtx    sync.Mutex
+	traces map[uint64]*PaymentTrace
+	order  []uint64 // oldest first
+	out    map[uint32]uint64
+	in     map[uint32]uint64
+	nextID uint64
+}
+
+// traceStart starts a trace for a push on a channel, giving its id.
+func (nd *LitNode) traceStart(qc *Qchan, amt int64, sent bool) uint64 {
+	t := &nd.paymentTraces
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.traces == nil {
+		t.traces = make(map[uint64]*PaymentTrace)
+		t.out = make(map[uint32]uint64)
+		t.in = make(map[uint32]uint64)
+	}
+	t.nextID++
+	now := time.Now()
+	t.traces[t.nextID] = &PaymentTrace{ID: t.nextID, Peer: qc.Peer(),
+		ChanIdx: qc.Idx(), Amt: amt, Sent: sent, Start: now.UnixNano(),
+		lastTime: now}
+	t.order = append(t.order, t.nextID)
+	if len(t.order) > maxTraces {
+		old := t.order[0]
+		t.order = t.order[1:]
+		delete(t.traces, old)
+	}
+	if sent {
+		t.out[qc.Idx()] = t.nextID
+	} else {
+		t.in[qc.Idx()] = t.nextID
+	}
+	return t.nextID
+}
+
+// traceStage records a stage for the push in flight on a channel, if
+// there is one.  Settled and failed end it.
+func (nd *LitNode) traceStage(chanIdx uint32, sent bool, stage, detail string) {
+	t := &nd.paymentTraces
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	inFlight := t.in
+	if sent {
+		inFlight = t.out
+	}
+	id, ok := inFlight[chanIdx]
+	if !ok {
+		// an HTLC op, or a push from before a restart
+		return
+	}
+	pt, ok := t.traces[id]
+	if !ok {
+		delete(inFlight, chanIdx)
+		return
+	}
+	now := time.Now()
+	pt.Stages = append(pt.Stages, TraceStage{Stage: stage, At: now.UnixNano(),
+		Since: now.Sub(pt.lastTime), Detail: detail})
+	pt.lastTime = now
+	if stage == StageSettled || stage == StageFailed {
+		pt.Done = true
+		delete(inFlight, chanIdx)
+	}
+}
+
+// traceFail ends the push in flight on a channel with an error.
+func (nd *LitNode) traceFail(chanIdx uint32, sent bool, err error) {
+	nd.traceStage(chanIdx, sent, StageFailed, err.Error())
+}
+
+// TracePayment gives the trace for a push.
+func (nd *LitNode) TracePayment(id uint64) (PaymentTrace, error) {
+	t := &nd.paymentTraces
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	pt, ok := t.traces[id]
+	if !ok {
+		return PaymentTrace{}, fmt.Errorf("no trace %d", id)
+	}
+	return copyTrace(pt), nil
+}
+
+// RecentTraces gives up to n of the newest traces, newest first; just a
+// channel's if chanIdx isn't 0.
+func (nd *LitNode) RecentTraces(chanIdx uint32, n int) []PaymentTrace {
+	t := &nd.paymentTraces
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	var pts []PaymentTrace
+	for i := len(t.order) - 1; i >= 0 && len(pts) < n; i-- {
+		pt := t.traces[t.order[i]]
+		if chanIdx == 0 || pt.ChanIdx == chanIdx {
+			pts = append(pts, copyTrace(pt))
+		}
+	}
+	return pts
+}
+
+func copyTrace(pt *PaymentTrace) PaymentTrace {
+	c := *pt
+	c.Stages = append([]TraceStage(nil), pt.Stages...)
+	return c
+}
@@ -3,111 +3,200 @@ package qln
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/adiabat/btcd/btcec"
-	"golang.org/x/net/proxy"
+	"github.com/mit-dci/lit/lndc"
+	"github.com/mit-dci/lit/lnutil"
 )
 
 type announcement struct {
-	ipv4 string
-	ipv6 string
-	addr string
-	sig  string
-	pbk  string
+	ipv4  string
+	ipv6  string
+	onion string
+	addr  string
+	sig   string
+	pbk   string
 }
 
 type nodeinfo struct {
 	Success bool
 	Node    struct {
-		IPv4 string
-		IPv6 string
-		Addr string
+		IPv4  string
+		IPv6  string
+		Onion string
+		Addr  string
 	}
 }
 
-func Announce(priv *btcec.PrivateKey, litport string, litadr string, trackerURL string) error {
-	resp, err := http.Get("https://ipv4.myexternalip.com/raw")
+const (
+	defaultIPv4Resolver = "https://ipv4.myexternalip.com/raw"
+	/* TODO: Find a better way to get this information. Their
+	 * SSL cert doesn't work for IPv6.
+	 */
+	defaultIPv6Resolver = "http://ipv6.myexternalip.com/raw"
+)
+
+// ExternalIP asks a web service (which replies with just the IP as text)
+// what our IP address is.
+func ExternalIP(resolverURL string) (string, error) {
+	resp, err := http.Get(resolverURL)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(resp.Body)
 
-	liturlIPv4 := strings.TrimSpace(buf.String()) + litport
-
-	var liturlIPv6 string
+	ip := strings.TrimSpace(buf.String())
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("%s says our IP is %q", resolverURL, ip)
+	}
+	return ip, nil
+}
 
-	/* TODO: Find a better way to get this information. Their
-	 * SSL cert doesn't work for IPv6.
-	 */
-	resp, err = http.Get("http://ipv6.myexternalip.com/raw")
+func Announce(priv *btcec.PrivateKey, litport string, litadr string, trackerURL string) error {
+	ipv4, err := ExternalIP(defaultIPv4Resolver)
 	if err != nil {
-		log.Printf("%v", err)
-	} else {
-		defer resp.Body.Close()
+		return err
+	}
 
-		buf = new(bytes.Buffer)
-		buf.ReadFrom(resp.Body)
-		liturlIPv6 = strings.TrimSpace(buf.String()) + litport
+	ipv6, err := ExternalIP(defaultIPv6Resolver)
+	if err != nil {
+		log.Errorf("%v", err)
 	}
 
-	urlBytes := []byte(liturlIPv4 + liturlIPv6)
+	return AnnounceAt(priv, ipv4, ipv6, litport, litadr, trackerURL)
+}
 
-	urlHash := sha256.Sum256(urlBytes)
+// AnnounceAt tells the tracker we're at the given IPs (ipv6 can be empty).
+func AnnounceAt(priv *btcec.PrivateKey, ipv4, ipv6 string,
+	litport string, litadr string, trackerURL string) error {
 
-	urlSig, err := priv.Sign(urlHash[:])
+	// litport is whatever we're listening on; could be ":2448" or
+	// "0.0.0.0:2448" or "[::]:2448".  We just want the port.
+	_, port, err := net.SplitHostPort(litport)
 	if err != nil {
 		return err
 	}
 
+	liturlIPv4 := net.JoinHostPort(ipv4, port)
+
+	var liturlIPv6 string
+	if ipv6 != "" {
+		// bracketed, so the port can be told apart
+		liturlIPv6 = net.JoinHostPort(ipv6, port)
+	}
+
+	return AnnounceEndpoints(priv, liturlIPv4, liturlIPv6, "",
+		litadr, trackerURL, "")
+}
+
+// AnnounceOnion tells the tracker about an onion host:port we can be reached
+// at.  Goes through the SOCKS proxy if there is one, so that the tracker
+// doesn't learn our IP.
+func AnnounceOnion(priv *btcec.PrivateKey, onionAdr string, litadr string,
+	trackerURL string, proxyURL string) error {
+
+	return AnnounceEndpoints(priv, "", "", onionAdr, litadr, trackerURL, proxyURL)
+}
+
+// AnnounceEndpoints tells the tracker every host:port we can be reached at
+// in one announcement, so announcing one doesn't wipe out the others.  Any
+// of them can be empty.  Peers pick whichever they can (or prefer to) use.
+func AnnounceEndpoints(priv *btcec.PrivateKey, ipv4, ipv6, onion string,
+	litadr string, trackerURL string, proxyURL string) error {
+
 	var ann announcement
 
-	ann.ipv4 = liturlIPv4
-	ann.ipv6 = liturlIPv6
+	ann.ipv4 = ipv4
+	ann.ipv6 = ipv6
+	ann.onion = onion
 	ann.addr = litadr
+
+	return ann.post(priv, []byte(ipv4+ipv6+onion), trackerURL, proxyURL)
+}
+
+// post signs the given url bytes and sends the announcement to the tracker.
+func (ann *announcement) post(priv *btcec.PrivateKey, urlBytes []byte,
+	trackerURL string, proxyURL string) error {
+
+	urlHash := sha256.Sum256(urlBytes)
+
+	urlSig, err := priv.Sign(urlHash[:])
+	if err != nil {
+		return err
+	}
+
 	ann.sig = hex.EncodeToString(urlSig.Serialize())
 	ann.pbk = hex.EncodeToString(priv.PubKey().SerializeCompressed())
 
-	_, err = http.PostForm(trackerURL+"/announce",
+	client, err := trackerClient(proxyURL)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.PostForm(trackerURL+"/announce",
 		url.Values{"ipv4": {ann.ipv4},
-			"ipv6": {ann.ipv6},
-			"addr": {ann.addr},
-			"sig":  {ann.sig},
-			"pbk":  {ann.pbk}})
+			"ipv6":  {ann.ipv6},
+			"onion": {ann.onion},
+			"addr":  {ann.addr},
+			"sig":   {ann.sig},
+			"pbk":   {ann.pbk}})
 
 	if err != nil {
 		return err
 	}
+	resp.Body.Close()
 
 	return nil
 }
 
-func Lookup(litadr string, trackerURL string, proxyURL string) (string, string, error) {
-	var client http.Client
+// trackerClient returns an http client for talking to the tracker, which
+// dials through the SOCKS5 proxy if one is given.
+func trackerClient(proxyURL string) (*http.Client, error) {
+	return proxyClient(proxyURL, "tracker")
+}
+
+// proxyClient returns an http client that dials through the SOCKS5 proxy if
+// one is given, on circuits of its own for who.
+func proxyClient(proxyURL, who string) (*http.Client, error) {
+	client := new(http.Client)
 
 	if proxyURL != "" {
-		dialer, err := proxy.SOCKS5("tcp", proxyURL, nil, proxy.Direct)
+		dialer, err := lndc.ProxyDialer(proxyURL, streamIsolation(who))
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
 
 		client.Transport = &http.Transport{
 			Dial: dialer.Dial,
 		}
 	}
+	return client, nil
+}
+
+// Lookup asks the tracker where a node is.  Returns its IPv4, IPv6 and
+// onion host:ports, any of which can be empty.
+func Lookup(litadr string, trackerURL string, proxyURL string) (string, string, string, error) {
+	client, err := trackerClient(proxyURL)
+	if err != nil {
+		return "", "", "", err
+	}
 
 	resp, err := client.Get(trackerURL + "/" + litadr)
 	if err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 	defer resp.Body.Close()
 
@@ -115,12 +204,160 @@ func Lookup(litadr string, trackerURL string, proxyURL string) (string, string,
 	var node nodeinfo
 	err = decoder.Decode(&node)
 	if err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 
 	if !node.Success {
-		return "", "", errors.New("Node not found")
+		return "", "", "", errors.New("Node not found")
+	}
+
+	return node.Node.IPv4, node.Node.IPv6, node.Node.Onion, nil
+}
+
+// TowerAd is what a watchtower tells the tracker about itself, so clients
+// can find it.
+type TowerAd struct {
+	Addr       string   // ln address to connect to
+	CoinTypes  []uint32 // chains it watches
+	Blobs      bool     // takes encrypted states
+	PerChannel int64    // satoshis per new channel
+	PerState   int64    // satoshis per state
+
+	// the tower's signature over the rest, and its pubkey; hex
+	Sig    string `json:"sig"`
+	PubKey string `json:"pbk"`
+}
+
+// towerAdHash is what a watchtower signs when it tells the tracker about
+// itself.  Each field goes in with its length in front, so where one ends
+// and the next starts can't be moved: fees 1 and 23 don't sign the same as
+// 12 and 3.
+func towerAdHash(fields ...string) [32]byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		binary.Write(&buf, binary.BigEndian, uint32(len(f)))
+		buf.WriteString(f)
+	}
+	return sha256.Sum256(buf.Bytes())
+}
+
+// form is the ad as it's posted to the tracker, unsigned.
+func (ad TowerAd) form() url.Values {
+	coins := make([]string, len(ad.CoinTypes))
+	for i, c := range ad.CoinTypes {
+		coins[i] = strconv.FormatUint(uint64(c), 10)
+	}
+	return url.Values{
+		"addr":     {ad.Addr},
+		"coins":    {strings.Join(coins, ",")},
+		"blobs":    {strconv.FormatBool(ad.Blobs)},
+		"chanfee":  {strconv.FormatInt(ad.PerChannel, 10)},
+		"statefee": {strconv.FormatInt(ad.PerState, 10)},
 	}
+}
+
+// hash is what the tower signs.
+func (ad TowerAd) hash() [32]byte {
+	form := ad.form()
+	return towerAdHash(ad.Addr, form.Get("coins"),
+		form.Get("blobs"), form.Get("chanfee"), form.Get("statefee"))
+}
+
+// verify checks that an ad is signed by the key its ln address is for, so
+// the tracker can't make up or change what a tower says.
+func (ad TowerAd) verify() error {
+	pubBytes, err := hex.DecodeString(ad.PubKey)
+	if err != nil {
+		return err
+	}
+	pub, err := btcec.ParsePubKey(pubBytes, btcec.S256())
+	if err != nil {
+		return err
+	}
+	var pubArr [33]byte
+	copy(pubArr[:], pub.SerializeCompressed())
+	if lnutil.LitAdrFromPubkey(pubArr) != ad.Addr {
+		return fmt.Errorf("tower %s ad signed by key for %s",
+			ad.Addr, lnutil.LitAdrFromPubkey(pubArr))
+	}
+	sigBytes, err := hex.DecodeString(ad.Sig)
+	if err != nil {
+		return err
+	}
+	sig, err := btcec.ParseSignature(sigBytes, btcec.S256())
+	if err != nil {
+		return err
+	}
+	hash := ad.hash()
+	if !sig.Verify(hash[:], pub) {
+		return fmt.Errorf("tower %s ad has a bad signature", ad.Addr)
+	}
+	return nil
+}
+
+type towerlist struct {
+	Success bool
+	Towers  []TowerAd
+}
 
-	return node.Node.IPv4, node.Node.IPv6, nil
+// AnnounceTower tells the tracker we're a watchtower, what we watch, and
+// what we charge.  Signed like endpoint announcements, so nobody else can
+// advertise a tower at our address.
+func AnnounceTower(priv *btcec.PrivateKey, ad TowerAd,
+	trackerURL string, proxyURL string) error {
+
+	form := ad.form()
+	adHash := ad.hash()
+	adSig, err := priv.Sign(adHash[:])
+	if err != nil {
+		return err
+	}
+	form.Set("sig", hex.EncodeToString(adSig.Serialize()))
+	form.Set("pbk", hex.EncodeToString(priv.PubKey().SerializeCompressed()))
+
+	client, err := trackerClient(proxyURL)
+	if err != nil {
+		return err
+	}
+	resp, err := client.PostForm(trackerURL+"/towers/announce", form)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// FindTowers asks the tracker for watchtowers that have announced
+// themselves.  Ads whose signature doesn't check out are left out.
+func FindTowers(trackerURL string, proxyURL string) ([]TowerAd, error) {
+	client, err := trackerClient(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(trackerURL + "/towers")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list towerlist
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+	if !list.Success {
+		return nil, errors.New("tracker has no towers")
+	}
+	var towers []TowerAd
+	for _, ad := range list.Towers {
+		err = ad.verify()
+		if err != nil {
+			log.Warnf("FindTowers: %s\n", err.Error())
+			continue
+		}
+		towers = append(towers, ad)
+	}
+	return towers, nil
 }
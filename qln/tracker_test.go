@@ -0,0 +1,63 @@
+package qln
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/adiabat/btcd/btcec"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+func TestTowerAdHash(t *testing.T) {
+	h := towerAdHash("ln1abc", "1,257", "true", "1", "23")
+	if h != towerAdHash("ln1abc", "1,257", "true", "1", "23") {
+		t.Fatalf("same ad hashed differently")
+	}
+	// the fee split has to be part of what's signed
+	if h == towerAdHash("ln1abc", "1,257", "true", "12", "3") {
+		t.Fatalf("ads with different fees hash the same")
+	}
+	if h == towerAdHash("ln1abc", "1,257", "true1", "", "23") {
+		t.Fatalf("ads with different fields hash the same")
+	}
+}
+
+// signedAd is a tower ad signed the way AnnounceTower does it.
+func signedAd(t *testing.T, priv *btcec.PrivateKey) TowerAd {
+	var pub [33]byte
+	copy(pub[:], priv.PubKey().SerializeCompressed())
+	ad := TowerAd{Addr: lnutil.LitAdrFromPubkey(pub),
+		CoinTypes: []uint32{1, 257}, Blobs: true, PerChannel: 1, PerState: 23}
+	hash := ad.hash()
+	sig, err := priv.Sign(hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad.Sig = hex.EncodeToString(sig.Serialize())
+	ad.PubKey = hex.EncodeToString(pub[:])
+	return ad
+}
+
+func TestTowerAdVerify(t *testing.T) {
+	priv, _ := btcec.NewPrivateKey(btcec.S256())
+	other, _ := btcec.NewPrivateKey(btcec.S256())
+
+	ad := signedAd(t, priv)
+	if err := ad.verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tracker changing the fee breaks the sig
+	changed := ad
+	changed.PerState = 1
+	if changed.verify() == nil {
+		t.Fatalf("changed fee verified")
+	}
+
+	// someone else's key can't sign for the tower's address
+	forged := signedAd(t, other)
+	forged.Addr = ad.Addr
+	if forged.verify() == nil {
+		t.Fatalf("ad signed by another key verified")
+	}
+}
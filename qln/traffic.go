@@ -0,0 +1,112 @@
+package qln
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// MsgTraffic is how much of one kind of message has gone each way.
+type MsgTraffic struct {
+	MsgType           uint8
+	MsgsIn, MsgsOut   uint64
+	BytesIn, BytesOut uint64 // message bytes, before encryption
+}
+
+// PeerTraffic is how much we've exchanged with a peer since startup or the
+// last reset.  The wire counts are for the current connection, and
+// include encryption and framing.
+type PeerTraffic struct {
+	PeerIdx           uint32
+	Connected         bool
+	BytesIn, BytesOut uint64
+	WireIn, WireOut   uint64
+	ByType            []MsgTraffic
+}
+
+// trafficCounter keeps per peer, per message type counts.
+type trafficCounter struct {
+	mtx   sync.Mutex
+	peers map[uint32]map[uint8]*MsgTraffic
+}
+
+func (tc *trafficCounter) count(peerIdx uint32, msgType uint8, n int, in bool) {
+	tc.mtx.Lock()
+	defer tc.mtx.Unlock()
+	if tc.peers == nil {
+		tc.peers = make(map[uint32]map[uint8]*MsgTraffic)
+	}
+	types, ok := tc.peers[peerIdx]
+	if !ok {
+		types = make(map[uint8]*MsgTraffic)
+		tc.peers[peerIdx] = types
+	}
+	mt, ok := types[msgType]
+	if !ok {
+		mt = &MsgTraffic{MsgType: msgType}
+		types[msgType] = mt
+	}
+	if in {
+		mt.MsgsIn++
+		mt.BytesIn += uint64(n)
+	} else {
+		mt.MsgsOut++
+		mt.BytesOut += uint64(n)
+	}
+}
+
+// countIn notes a message from a peer.
+func (nd *LitNode) countIn(peer *RemotePeer, msg lnutil.LitMsg, n int) {
+	nd.traffic.count(peer.Idx, msg.MsgType(), n, true)
+}
+
+// countOut notes a message sent to a peer.
+func (nd *LitNode) countOut(peer *RemotePeer, msg lnutil.LitMsg, n int) {
+	nd.traffic.count(peer.Idx, msg.MsgType(), n, false)
+}
+
+// GetTraffic returns the traffic with every peer we've exchanged messages
+// with, biggest first.
+func (nd *LitNode) GetTraffic() []PeerTraffic {
+	nd.traffic.mtx.Lock()
+	var pts []PeerTraffic
+	for idx, types := range nd.traffic.peers {
+		pt := PeerTraffic{PeerIdx: idx}
+		for _, mt := range types {
+			pt.BytesIn += mt.BytesIn
+			pt.BytesOut += mt.BytesOut
+			pt.ByType = append(pt.ByType, *mt)
+		}
+		sort.Slice(pt.ByType, func(i, j int) bool {
+			return pt.ByType[i].MsgType < pt.ByType[j].MsgType
+		})
+		pts = append(pts, pt)
+	}
+	nd.traffic.mtx.Unlock()
+
+	for i := range pts {
+		peer, ok := nd.GetPeer(pts[i].PeerIdx)
+		if ok {
+			pts[i].Connected = true
+			pts[i].WireIn = peer.Con.BytesRead()
+			pts[i].WireOut = peer.Con.BytesWritten()
+		}
+	}
+
+	sort.Slice(pts, func(i, j int) bool {
+		return pts[i].BytesIn+pts[i].BytesOut > pts[j].BytesIn+pts[j].BytesOut
+	})
+	return pts
+}
+
+// ResetTraffic zeroes all the traffic counters.
+func (nd *LitNode) ResetTraffic() {
+	nd.traffic.mtx.Lock()
+	nd.traffic.peers = nil
+	nd.traffic.mtx.Unlock()
+
+	for _, peer := range nd.peerList() {
+		peer.Con.ResetCounters()
+	}
+}
@@ -0,0 +1,267 @@
+package qln
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Webhooks tell web services, like a shop's backend, what happens on the
+node without them holding an rpc connection open.  Each event is POSTed as
+json to every webhook url, signed with HMAC-SHA256 over the body with the
+webhook secret, hex in the X-Lit-Signature header as sha256=<hex>.  The
+receiver checks it with the same secret.  If no secret is given, lit makes
+one and keeps it in webhook.secret in the lit folder.
+
+Each url gets events in order.  A delivery that fails, or gets anything
+but a 2xx back, is tried again with backoff, up to webhookMaxTries times,
+before it's dropped and logged; later events wait behind it.  The event's
+ID lets a receiver spot one it's seen.
+*/
+
+// webhook event types
+const (
+	WebhookPaymentReceived  = "payment_received"
+	WebhookChannelOpened    = "channel_opened"
+	WebhookChannelClosed    = "channel_closed"
+	WebhookBreachDetected   = "breach_detected"
+	WebhookPeerDisconnected = "peer_disconnected"
+)
+
+const (
+	webhookSecretFile = "webhook.secret"
+	// webhookQueueLen is how many events a url can have waiting; past
+	// that, new ones are dropped.
+	webhookQueueLen = 1000
+	webhookTimeout  = 30 * time.Second
+	webhookMinWait  = time.Second
+	webhookMaxWait  = 5 * time.Minute
+	webhookMaxTries = 12
+)
+
+// WebhookEvent is what gets POSTed to webhooks.
+type WebhookEvent struct {
+	// ID is unique for the node; a retried delivery has the same one.
+	// Plugins' events don't have one.
+	ID      string `json:",omitempty"`
+	Type    string
+	Time    int64  // unix
+	Peer    uint32 `json:",omitempty"`
+	ChanIdx uint32 `json:",omitempty"`
+	Amt     int64  `json:",omitempty"`
+	Txid    string `json:",omitempty"`
+	Detail  string `json:",omitempty"`
+}
+
+// webhooks are the urls to post events to.  nil if there aren't any.
+type webhooks struct {
+	secret []byte
+	client *http.Client
+	queues []*webhookQueue
+
+	mtx    sync.Mutex
+	nextID uint64
+	// makes IDs unique across restarts
+	runID string
+}
+
+// webhookQueue is a url's events waiting to go.
+type webhookQueue struct {
+	url string
+	// host is what logs name it by; urls can have tokens in them
+	host   string
+	events chan []byte
+}
+
+// StartWebhooks starts posting events to urls, signed with secret; if
+// secret is empty, with the one in the lit folder, made if it's not there.
+func (nd *LitNode) StartWebhooks(urls []string, secret string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	key := []byte(secret)
+	if secret == "" {
+		var err error
+		key, err = loadWebhookSecret(filepath.Join(nd.LitFolder, webhookSecretFile))
+		if err != nil {
+			return err
+		}
+	}
+
+	var run [8]byte
+	_, err := rand.Read(run[:])
+	if err != nil {
+		return err
+	}
+	client, err := proxyClient(nd.ProxyURL, "webhook")
+	if err != nil {
+		return err
+	}
+	client.Timeout = webhookTimeout
+	wh := &webhooks{
+		secret: key,
+		client: client,
+		runID:  hex.EncodeToString(run[:]),
+	}
+	for _, u := range urls {
+		pu, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		if pu.Scheme != "http" && pu.Scheme != "https" {
+			return fmt.Errorf("webhook %s; expect an http or https url", u)
+		}
+		q := &webhookQueue{url: u, host: pu.Host,
+			events: make(chan []byte, webhookQueueLen)}
+		wh.queues = append(wh.queues, q)
+		go wh.deliverLoop(q)
+	}
+	nd.webhooks = wh
+	return nil
+}
+
+// loadWebhookSecret reads the secret from path, making a random one there
+// if it's not there yet.
+func loadWebhookSecret(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		return []byte(strings.TrimSpace(string(b))), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var r [32]byte
+	_, err = rand.Read(r[:])
+	if err != nil {
+		return nil, err
+	}
+	secret := hex.EncodeToString(r[:])
+	err = ioutil.WriteFile(path, []byte(secret+"\n"), 0600)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("made webhook secret in %s\n", path)
+	return []byte(secret), nil
+}
+
+// OnEvent has f called with every event, for a program lit's built into.
+// f is called as the event happens, so it shouldn't block.
+func (nd *LitNode) OnEvent(f func(WebhookEvent)) {
+	nd.eventFuncMtx.Lock()
+	nd.eventFuncs = append(nd.eventFuncs, f)
+	nd.eventFuncMtx.Unlock()
+}
+
+// notify queues an event for every webhook, the plugins subscribed to it,
+// and its event command.  Doesn't wait.
+func (nd *LitNode) notify(e WebhookEvent) {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	nd.eventFuncMtx.Lock()
+	funcs := nd.eventFuncs
+	nd.eventFuncMtx.Unlock()
+	for _, f := range funcs {
+		f(e)
+	}
+	nd.notifyPlugins(e)
+	nd.runEventCommand(e)
+	wh := nd.webhooks
+	if wh == nil {
+		return
+	}
+	wh.mtx.Lock()
+	wh.nextID++
+	e.ID = fmt.Sprintf("%s-%d", wh.runID, wh.nextID)
+	wh.mtx.Unlock()
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("webhook %s: %s\n", e.Type, err.Error())
+		return
+	}
+	for _, q := range wh.queues {
+		select {
+		case q.events <- body:
+		default:
+			log.Warnf("webhook %s backed up; dropping %s event %s\n",
+				q.host, e.Type, e.ID)
+		}
+	}
+}
+
+// deliverLoop posts a url's events one at a time, in order.
+func (wh *webhooks) deliverLoop(q *webhookQueue) {
+	for body := range q.events {
+		var err error
+		for try := 0; try < webhookMaxTries; try++ {
+			if try != 0 {
+				time.Sleep(webhookWait(try - 1))
+			}
+			err = wh.post(q.url, body)
+			if err == nil {
+				break
+			}
+			log.Warnf("webhook %s try %d: %s\n", q.host, try+1, err.Error())
+		}
+		if err != nil {
+			log.Errorf("webhook %s: giving up on %s\n", q.host, body)
+		}
+	}
+}
+
+// webhookWait is how long to wait before retry number attempt (from 0);
+// it doubles each time, up to webhookMaxWait.
+func webhookWait(attempt int) time.Duration {
+	if attempt >= 20 {
+		return webhookMaxWait
+	}
+	wait := webhookMinWait << uint(attempt)
+	if wait > webhookMaxWait {
+		wait = webhookMaxWait
+	}
+	return wait
+}
+
+// webhookSignature is the X-Lit-Signature header for a body.
+func webhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *webhooks) post(u string, body []byte) error {
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Lit-Signature", webhookSignature(wh.secret, body))
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		// without the url
+		if uerr, ok := err.(*url.Error); ok {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("got %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package tor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Circuit is one line of tor's circuit-status.
+type Circuit struct {
+	ID     string
+	Status string // LAUNCHED, BUILT, EXTENDED, FAILED, CLOSED
+	// Path is the relays, "$fingerprint~nickname", from guard to exit.
+	// Empty until the first hop is built.
+	Path    []string
+	Purpose string
+}
+
+// GetInfo asks tor for one GETINFO key and returns the value.  Multi-line
+// values (sent as a data block) come back joined with newlines.
+func (c *Controller) GetInfo(key string) (string, error) {
+	reply, err := c.Command("GETINFO " + key)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range reply.Lines {
+		if !strings.HasPrefix(line, key+"=") {
+			continue
+		}
+		val := strings.TrimPrefix(line, key+"=")
+		// data block values start after the newline
+		return strings.TrimPrefix(val, "\n"), nil
+	}
+	return "", fmt.Errorf("tor: GETINFO %s: key not in reply", key)
+}
+
+// Circuits returns tor's current circuits.
+func (c *Controller) Circuits() ([]Circuit, error) {
+	status, err := c.GetInfo("circuit-status")
+	if err != nil {
+		return nil, err
+	}
+
+	var circs []Circuit
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		var circ Circuit
+		circ.ID = fields[0]
+		circ.Status = fields[1]
+		rest := fields[2:]
+		// path is the only field without an "=", and it's optional
+		if len(rest) > 0 && !strings.Contains(rest[0], "=") {
+			circ.Path = strings.Split(rest[0], ",")
+			rest = rest[1:]
+		}
+		kv := parseKeyValues(strings.Join(rest, " "))
+		circ.Purpose = kv["PURPOSE"]
+		circs = append(circs, circ)
+	}
+	return circs, nil
+}
+
+// NewIdentity tells tor to use new circuits for new connections, so they
+// can't be linked to earlier ones.  Tor rate limits this (~10 seconds);
+// extra requests in that time are put off, not refused.
+func (c *Controller) NewIdentity() error {
+	_, err := c.Command("SIGNAL NEWNYM")
+	return err
+}
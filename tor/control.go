@@ -0,0 +1,314 @@
+package tor
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Tor control port client.
+
+This speaks just enough of the tor control protocol (control-spec.txt) for
+lit to run onion services.  Commands are lines of text; replies are one or
+more lines starting with a 3 digit status code.  "250-" means more lines
+follow, "250 " is the last line, and "250+" starts a data block which ends
+with a line holding a single ".".
+
+The control connection has to stay open for as long as we want our onion
+services to exist; tor removes them when the controller goes away.
+*/
+
+// Controller is an open connection to a tor daemon's control port.
+type Controller struct {
+	conn net.Conn
+	rd   *textproto.Reader
+
+	// one command at a time
+	mtx sync.Mutex
+}
+
+// Reply is a control port reply; the status code and the text of each line
+// (without the code).
+type Reply struct {
+	Code  int
+	Lines []string
+}
+
+// Dial connects to a tor control port.  adr is host:port, or "unix:" followed
+// by the path to a control socket.
+func Dial(adr string) (*Controller, error) {
+	var conn net.Conn
+	var err error
+	if strings.HasPrefix(adr, "unix:") {
+		conn, err = net.Dial("unix", strings.TrimPrefix(adr, "unix:"))
+	} else {
+		conn, err = net.Dial("tcp", adr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewController(conn), nil
+}
+
+// NewController makes a controller using an already open connection.
+func NewController(conn net.Conn) *Controller {
+	c := new(Controller)
+	c.conn = conn
+	c.rd = textproto.NewReader(bufio.NewReader(conn))
+	return c
+}
+
+// Close closes the control connection.  Any onion services made on it that
+// weren't detached will go away.
+func (c *Controller) Close() error {
+	return c.conn.Close()
+}
+
+// Command sends a command line and waits for the reply.  Any non-250 reply
+// is returned as an error.
+func (c *Controller) Command(cmd string) (*Reply, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	_, err := fmt.Fprintf(c.conn, "%s\r\n", cmd)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if reply.Code != 250 {
+		return reply, fmt.Errorf("tor: %s: %d %s",
+			strings.Fields(cmd)[0], reply.Code, strings.Join(reply.Lines, " "))
+	}
+	return reply, nil
+}
+
+// readReply reads lines until the final line of a reply.
+func (c *Controller) readReply() (*Reply, error) {
+	reply := new(Reply)
+	for {
+		line, err := c.rd.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, fmt.Errorf("tor: short reply line %q", line)
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return nil, fmt.Errorf("tor: bad reply line %q", line)
+		}
+		reply.Code = code
+
+		switch line[3] {
+		case ' ': // last line
+			reply.Lines = append(reply.Lines, line[4:])
+			return reply, nil
+		case '-': // more to come
+			reply.Lines = append(reply.Lines, line[4:])
+		case '+': // data block, ends with "."
+			data, err := c.rd.ReadDotLines()
+			if err != nil {
+				return nil, err
+			}
+			reply.Lines = append(reply.Lines,
+				line[4:]+"\n"+strings.Join(data, "\n"))
+		default:
+			return nil, fmt.Errorf("tor: bad reply line %q", line)
+		}
+	}
+}
+
+// authInfo is what we care about from PROTOCOLINFO
+type authInfo struct {
+	methods    []string
+	cookieFile string
+}
+
+func (a authInfo) has(method string) bool {
+	for _, m := range a.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// protocolInfo asks tor which auth methods it accepts.
+func (c *Controller) protocolInfo() (authInfo, error) {
+	var info authInfo
+	reply, err := c.Command("PROTOCOLINFO 1")
+	if err != nil {
+		return info, err
+	}
+	for _, line := range reply.Lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+		kv := parseKeyValues(strings.TrimPrefix(line, "AUTH "))
+		info.methods = strings.Split(kv["METHODS"], ",")
+		info.cookieFile = kv["COOKIEFILE"]
+	}
+	return info, nil
+}
+
+// Authenticate logs in to the control port, using whichever method tor says
+// it allows: none, the password (if one is given), or the cookie file.
+// SAFECOOKIE is preferred over plain COOKIE since it doesn't hand the cookie
+// to whatever is listening on the control port.
+func (c *Controller) Authenticate(password string) error {
+	info, err := c.protocolInfo()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.has("NULL"):
+		_, err = c.Command("AUTHENTICATE")
+		return err
+
+	case info.has("HASHEDPASSWORD") && password != "":
+		_, err = c.Command("AUTHENTICATE " + quoteString(password))
+		return err
+
+	case info.has("SAFECOOKIE") && info.cookieFile != "":
+		return c.safeCookieAuth(info.cookieFile)
+
+	case info.has("COOKIE") && info.cookieFile != "":
+		cookie, err := ioutil.ReadFile(info.cookieFile)
+		if err != nil {
+			return err
+		}
+		_, err = c.Command("AUTHENTICATE " + hex.EncodeToString(cookie))
+		return err
+	}
+
+	if info.has("HASHEDPASSWORD") {
+		return fmt.Errorf("tor: control port needs a password")
+	}
+	return fmt.Errorf("tor: no supported auth method (have %s)",
+		strings.Join(info.methods, ","))
+}
+
+const (
+	safeCookieServerKey = "Tor safe cookie authentication server-to-controller hash"
+	safeCookieClientKey = "Tor safe cookie authentication controller-to-server hash"
+)
+
+// safeCookieAuth does the AUTHCHALLENGE exchange: both sides prove they
+// know the cookie by HMACing it with both nonces.
+func (c *Controller) safeCookieAuth(cookieFile string) error {
+	cookie, err := ioutil.ReadFile(cookieFile)
+	if err != nil {
+		return err
+	}
+
+	clientNonce := make([]byte, 32)
+	_, err = rand.Read(clientNonce)
+	if err != nil {
+		return err
+	}
+
+	reply, err := c.Command(
+		"AUTHCHALLENGE SAFECOOKIE " + hex.EncodeToString(clientNonce))
+	if err != nil {
+		return err
+	}
+	kv := parseKeyValues(strings.TrimPrefix(reply.Lines[0], "AUTHCHALLENGE "))
+	serverHash, err := hex.DecodeString(kv["SERVERHASH"])
+	if err != nil {
+		return fmt.Errorf("tor: bad SERVERHASH %q", kv["SERVERHASH"])
+	}
+	serverNonce, err := hex.DecodeString(kv["SERVERNONCE"])
+	if err != nil {
+		return fmt.Errorf("tor: bad SERVERNONCE %q", kv["SERVERNONCE"])
+	}
+
+	msg := append(append(append([]byte{}, cookie...), clientNonce...), serverNonce...)
+	if !hmac.Equal(serverHash, safeCookieHMAC(safeCookieServerKey, msg)) {
+		return fmt.Errorf("tor: SAFECOOKIE server hash mismatch")
+	}
+
+	_, err = c.Command("AUTHENTICATE " +
+		hex.EncodeToString(safeCookieHMAC(safeCookieClientKey, msg)))
+	return err
+}
+
+// quoteString makes a control protocol QuotedString.
+func quoteString(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+func safeCookieHMAC(key string, msg []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// parseKeyValues splits up KEY=value KEY2="quoted value" pairs from a reply
+// line.  Quoted values are unquoted.  Bare keywords map to "".
+func parseKeyValues(s string) map[string]string {
+	kv := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return kv
+		}
+		end := strings.IndexAny(s, " =")
+		if end < 0 {
+			kv[s] = ""
+			return kv
+		}
+		if s[end] == ' ' {
+			kv[s[:end]] = ""
+			s = s[end:]
+			continue
+		}
+		key := s[:end]
+		s = s[end+1:]
+
+		if !strings.HasPrefix(s, "\"") {
+			end = strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			kv[key] = s[:end]
+			s = s[end:]
+			continue
+		}
+
+		// quoted string; find the closing quote, skipping escapes
+		i := 1
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(s) {
+			// unterminated, take what's there
+			kv[key] = s[1:]
+			return kv
+		}
+		val, err := strconv.Unquote(s[:i+1])
+		if err != nil {
+			val = s[1:i]
+		}
+		kv[key] = val
+		s = s[i+1:]
+	}
+}
@@ -0,0 +1,134 @@
+package tor
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeTor answers control commands from a script of command prefix -> reply.
+func fakeTor(t *testing.T, conn net.Conn, script map[string]string) {
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		reply := "510 Unrecognized command\r\n"
+		for prefix, r := range script {
+			if strings.HasPrefix(line, prefix) {
+				reply = r
+			}
+		}
+		conn.Write([]byte(reply))
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	kv := parseKeyValues(
+		`METHODS=COOKIE,SAFECOOKIE COOKIEFILE="/var/run/tor/con trol.authcookie" FLAG`)
+	if kv["METHODS"] != "COOKIE,SAFECOOKIE" {
+		t.Fatalf("METHODS is %q", kv["METHODS"])
+	}
+	if kv["COOKIEFILE"] != "/var/run/tor/con trol.authcookie" {
+		t.Fatalf("COOKIEFILE is %q", kv["COOKIEFILE"])
+	}
+	if _, ok := kv["FLAG"]; !ok {
+		t.Fatalf("bare keyword missing")
+	}
+}
+
+func TestAuthAndOnion(t *testing.T) {
+	us, them := net.Pipe()
+	defer us.Close()
+
+	go fakeTor(t, them, map[string]string{
+		"PROTOCOLINFO": "250-PROTOCOLINFO 1\r\n" +
+			"250-AUTH METHODS=NULL\r\n" +
+			"250-VERSION Tor=\"0.4.8.9\"\r\n" +
+			"250 OK\r\n",
+		"AUTHENTICATE": "250 OK\r\n",
+		"ADD_ONION NEW:ED25519-V3 Port=2448,127.0.0.1:2448": "250-ServiceID=abcdefg\r\n" +
+			"250-PrivateKey=ED25519-V3:c2VjcmV0\r\n" +
+			"250 OK\r\n",
+		"DEL_ONION abcdefg": "250 OK\r\n",
+		"GETINFO circuit-status": "250+circuit-status=\r\n" +
+			"1 BUILT $AA~a,$BB~b,$CC~c BUILD_FLAGS=NEED_CAPACITY PURPOSE=GENERAL\r\n" +
+			"2 LAUNCHED PURPOSE=GENERAL\r\n" +
+			".\r\n" +
+			"250 OK\r\n",
+		"SIGNAL NEWNYM": "250 OK\r\n",
+	})
+
+	ctl := NewController(us)
+	err := ctl.Authenticate("")
+	if err != nil {
+		t.Fatalf("Authenticate: %s", err.Error())
+	}
+
+	onion, err := ctl.AddOnion("", 2448, "127.0.0.1:2448")
+	if err != nil {
+		t.Fatalf("AddOnion: %s", err.Error())
+	}
+	if onion.Adr() != "abcdefg.onion:2448" {
+		t.Fatalf("onion adr %s", onion.Adr())
+	}
+	if onion.PrivateKey != "ED25519-V3:c2VjcmV0" {
+		t.Fatalf("onion key %s", onion.PrivateKey)
+	}
+
+	err = ctl.DelOnion(onion.ServiceID)
+	if err != nil {
+		t.Fatalf("DelOnion: %s", err.Error())
+	}
+
+	circs, err := ctl.Circuits()
+	if err != nil {
+		t.Fatalf("Circuits: %s", err.Error())
+	}
+	if len(circs) != 2 {
+		t.Fatalf("got %d circuits, expect 2", len(circs))
+	}
+	if circs[0].Status != "BUILT" || len(circs[0].Path) != 3 ||
+		circs[0].Purpose != "GENERAL" {
+		t.Fatalf("bad circuit %v", circs[0])
+	}
+	if circs[1].Status != "LAUNCHED" || len(circs[1].Path) != 0 {
+		t.Fatalf("bad circuit %v", circs[1])
+	}
+
+	err = ctl.NewIdentity()
+	if err != nil {
+		t.Fatalf("NewIdentity: %s", err.Error())
+	}
+
+	// unknown commands come back as errors
+	_, err = ctl.Command("NOPE")
+	if err == nil {
+		t.Fatalf("expected error for 510 reply")
+	}
+}
+
+func TestPasswordAuth(t *testing.T) {
+	us, them := net.Pipe()
+	defer us.Close()
+
+	go fakeTor(t, them, map[string]string{
+		"PROTOCOLINFO": "250-PROTOCOLINFO 1\r\n" +
+			"250-AUTH METHODS=HASHEDPASSWORD\r\n" +
+			"250 OK\r\n",
+		`AUTHENTICATE "pass\"word"`: "250 OK\r\n",
+	})
+
+	ctl := NewController(us)
+	err := ctl.Authenticate("")
+	if err == nil {
+		t.Fatalf("expected error with no password")
+	}
+	err = ctl.Authenticate(`pass"word`)
+	if err != nil {
+		t.Fatalf("Authenticate: %s", err.Error())
+	}
+}
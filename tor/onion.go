@@ -0,0 +1,63 @@
+package tor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Onion is an onion service running on a controller.
+type Onion struct {
+	// ServiceID is the onion address without the ".onion"
+	ServiceID string
+	// PrivateKey is the service key in tor's "ED25519-V3:base64" form.
+	// Give it back to AddOnion to get the same address next time.
+	PrivateKey string
+	// VirtPort is the port the service is reachable on
+	VirtPort uint16
+}
+
+// Adr returns the host:port to reach the onion service at.
+func (o *Onion) Adr() string {
+	return fmt.Sprintf("%s.onion:%d", o.ServiceID, o.VirtPort)
+}
+
+// AddOnion makes a v3 onion service which forwards connections on virtPort
+// to target (a local host:port).  If privKey is empty tor makes a new key,
+// otherwise the given key (from a previous Onion.PrivateKey) is used so the
+// address stays the same.
+func (c *Controller) AddOnion(
+	privKey string, virtPort uint16, target string) (*Onion, error) {
+
+	keyArg := "NEW:ED25519-V3"
+	if privKey != "" {
+		keyArg = privKey
+	}
+
+	reply, err := c.Command(fmt.Sprintf(
+		"ADD_ONION %s Port=%d,%s", keyArg, virtPort, target))
+	if err != nil {
+		return nil, err
+	}
+
+	o := new(Onion)
+	o.VirtPort = virtPort
+	o.PrivateKey = privKey
+	for _, line := range reply.Lines {
+		switch {
+		case strings.HasPrefix(line, "ServiceID="):
+			o.ServiceID = strings.TrimPrefix(line, "ServiceID=")
+		case strings.HasPrefix(line, "PrivateKey="):
+			o.PrivateKey = strings.TrimPrefix(line, "PrivateKey=")
+		}
+	}
+	if o.ServiceID == "" {
+		return nil, fmt.Errorf("tor: ADD_ONION reply has no ServiceID")
+	}
+	return o, nil
+}
+
+// DelOnion removes an onion service made on this controller.
+func (c *Controller) DelOnion(serviceID string) error {
+	_, err := c.Command("DEL_ONION " + serviceID)
+	return err
+}
@@ -0,0 +1,621 @@
+package uspv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Compact filter mode (BIP157 / BIP158) gets a small filter for each block
+from the peer, instead of giving the peer a bloom filter of our addresses.
+The filters have every script in the block, so we match our own scripts
+against them here, and only get the blocks that match.  The peer never
+learns which addresses are ours.
+
+Filters match scripts, not outpoints, so spends of outpoints we're watching
+are found by the script of the output they spend.  For our addresses that's
+easy, but for things like channel outputs we have to have seen the tx that
+made them.  Those scripts get saved to a file as blocks go by.  If there's an
+outpoint we don't know the script of, every block gets downloaded, like hard
+mode, until we see it.
+
+Each batch of filters comes after a cfheaders message with the hashes of the
+filters, and the filter header before them.  Filters have to match those
+hashes, and the headers they make get kept in cfheaders.bin, so the next
+batch has to carry on the same chain.  The first filter header, with
+nothing saved below it, is taken from the peer as it is; from there on the
+peer can't swap in other filters for blocks we've already been through.
+
+Turn it on with a cf:// host, like cf://yes or cf://127.0.0.1
+*/
+
+const (
+	// cfHostPrefix on the host string means use compact filters
+	cfHostPrefix = "cf://"
+
+	// sfNodeCompactFilters is the service bit for peers that serve filters
+	sfNodeCompactFilters wire.ServiceFlag = 1 << 6
+
+	// filterTypeBasic is the BIP158 basic filter
+	filterTypeBasic = 0
+
+	// cfBatch is the most filters to ask for at once; peers won't give
+	// more than 1000
+	cfBatch = 1000
+
+	// cfTimeout is how long to wait for a filter or block before giving up
+	cfTimeout = 2 * time.Minute
+
+	// cfHeaderBatch is the most filter hashes a cfheaders message has
+	cfHeaderBatch = 2000
+
+	cmdGetCFilters  = "getcfilters"
+	cmdCFilter      = "cfilter"
+	cmdGetCFHeaders = "getcfheaders"
+	cmdCFHeaders    = "cfheaders"
+
+	// cfScriptFileName is where scripts of watched outpoints are kept
+	cfScriptFileName = "cfscripts.bin"
+	// cfHeaderFileName is where filter headers are kept, 32 bytes per
+	// height, like header.bin
+	cfHeaderFileName = "cfheaders.bin"
+)
+
+// msgGetCFilters asks for the filters of a range of blocks.
+type msgGetCFilters struct {
+	FilterType  uint8
+	StartHeight uint32
+	StopHash    chainhash.Hash
+}
+
+func (m *msgGetCFilters) BtcDecode(
+	r io.Reader, pver uint32, enc wire.MessageEncoding) error {
+	var b [37]byte
+	_, err := io.ReadFull(r, b[:])
+	if err != nil {
+		return err
+	}
+	m.FilterType = b[0]
+	m.StartHeight = binary.LittleEndian.Uint32(b[1:5])
+	copy(m.StopHash[:], b[5:])
+	return nil
+}
+
+func (m *msgGetCFilters) BtcEncode(
+	w io.Writer, pver uint32, enc wire.MessageEncoding) error {
+	var b [37]byte
+	b[0] = m.FilterType
+	binary.LittleEndian.PutUint32(b[1:5], m.StartHeight)
+	copy(b[5:], m.StopHash[:])
+	_, err := w.Write(b[:])
+	return err
+}
+
+func (m *msgGetCFilters) Command() string {
+	return cmdGetCFilters
+}
+
+func (m *msgGetCFilters) MaxPayloadLength(pver uint32) uint32 {
+	return 37
+}
+
+// msgGetCFHeaders asks for the filter hashes of a range of blocks, and the
+// filter header before them.  Same fields as getcfilters.
+type msgGetCFHeaders struct {
+	msgGetCFilters
+}
+
+func (m *msgGetCFHeaders) Command() string {
+	return cmdGetCFHeaders
+}
+
+// msgCFHeaders has the filter hashes of a range of blocks ending at
+// StopHash, and the filter header of the block before the first.
+type msgCFHeaders struct {
+	FilterType       uint8
+	StopHash         chainhash.Hash
+	PrevFilterHeader chainhash.Hash
+	FilterHashes     []chainhash.Hash
+}
+
+func (m *msgCFHeaders) BtcDecode(
+	r io.Reader, pver uint32, enc wire.MessageEncoding) error {
+	var b [65]byte
+	_, err := io.ReadFull(r, b[:])
+	if err != nil {
+		return err
+	}
+	m.FilterType = b[0]
+	copy(m.StopHash[:], b[1:33])
+	copy(m.PrevFilterHeader[:], b[33:])
+	count, err := wire.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > cfHeaderBatch {
+		return fmt.Errorf("%d filter hashes, max %d", count, cfHeaderBatch)
+	}
+	m.FilterHashes = make([]chainhash.Hash, count)
+	for i := range m.FilterHashes {
+		_, err = io.ReadFull(r, m.FilterHashes[i][:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *msgCFHeaders) BtcEncode(
+	w io.Writer, pver uint32, enc wire.MessageEncoding) error {
+	var b [65]byte
+	b[0] = m.FilterType
+	copy(b[1:33], m.StopHash[:])
+	copy(b[33:], m.PrevFilterHeader[:])
+	_, err := w.Write(b[:])
+	if err != nil {
+		return err
+	}
+	err = wire.WriteVarInt(w, pver, uint64(len(m.FilterHashes)))
+	if err != nil {
+		return err
+	}
+	for _, h := range m.FilterHashes {
+		_, err = w.Write(h[:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *msgCFHeaders) Command() string {
+	return cmdCFHeaders
+}
+
+func (m *msgCFHeaders) MaxPayloadLength(pver uint32) uint32 {
+	return 65 + wire.MaxVarIntPayload + cfHeaderBatch*32
+}
+
+// filterHeaders gives the filter headers of blocks with filterHashes, after
+// the block with filter header prev.  Each is the double sha256 of the
+// block's filter hash and the header before it.
+func filterHeaders(
+	prev chainhash.Hash, filterHashes []chainhash.Hash) []chainhash.Hash {
+	hdrs := make([]chainhash.Hash, len(filterHashes))
+	for i, fh := range filterHashes {
+		hdrs[i] = chainhash.DoubleHashH(append(fh[:], prev[:]...))
+		prev = hdrs[i]
+	}
+	return hdrs
+}
+
+// msgCFilter is the filter of one block.
+type msgCFilter struct {
+	FilterType uint8
+	BlockHash  chainhash.Hash
+	Filter     []byte
+}
+
+func (m *msgCFilter) BtcDecode(
+	r io.Reader, pver uint32, enc wire.MessageEncoding) error {
+	var b [33]byte
+	_, err := io.ReadFull(r, b[:])
+	if err != nil {
+		return err
+	}
+	m.FilterType = b[0]
+	copy(m.BlockHash[:], b[1:])
+	m.Filter, err = wire.ReadVarBytes(r, pver, wire.MaxBlockPayload, "filter")
+	return err
+}
+
+func (m *msgCFilter) BtcEncode(
+	w io.Writer, pver uint32, enc wire.MessageEncoding) error {
+	var b [33]byte
+	b[0] = m.FilterType
+	copy(b[1:], m.BlockHash[:])
+	_, err := w.Write(b[:])
+	if err != nil {
+		return err
+	}
+	return wire.WriteVarBytes(w, pver, m.Filter)
+}
+
+func (m *msgCFilter) Command() string {
+	return cmdCFilter
+}
+
+func (m *msgCFilter) MaxPayloadLength(pver uint32) uint32 {
+	return 33 + wire.MaxVarIntPayload + wire.MaxBlockPayload
+}
+
+// readMessage reads the next message from the peer.  The wire package
+// doesn't know about filter messages, so those get read here, and
+// everything else gets handed to it.
+func (s *SPVCon) readMessage() (int, wire.Message, error) {
+	var hdr [wire.MessageHeaderSize]byte
+	n, err := io.ReadFull(s.con, hdr[:])
+	if err != nil {
+		return n, nil, err
+	}
+
+	// magic 4, command 12, length 4, checksum 4
+	var m wire.Message
+	command := string(bytes.TrimRight(hdr[4:16], "\x00"))
+	switch command {
+	case cmdCFilter:
+		m = new(msgCFilter)
+	case cmdCFHeaders:
+		m = new(msgCFHeaders)
+	default:
+		n, m, _, err := wire.ReadMessageWithEncodingN(
+			io.MultiReader(bytes.NewReader(hdr[:]), s.con), s.localVersion,
+			wire.BitcoinNet(s.Param.NetMagicBytes), wire.LatestEncoding)
+		return n, m, err
+	}
+
+	if binary.LittleEndian.Uint32(hdr[0:4]) != s.Param.NetMagicBytes {
+		return n, nil, fmt.Errorf("%s message from other network %x",
+			command, hdr[0:4])
+	}
+	length := binary.LittleEndian.Uint32(hdr[16:20])
+	if length > m.MaxPayloadLength(s.localVersion) {
+		return n, nil, fmt.Errorf("%d byte %s message too long", length, command)
+	}
+	payload := make([]byte, length)
+	pn, err := io.ReadFull(s.con, payload)
+	n += pn
+	if err != nil {
+		return n, nil, err
+	}
+	if !bytes.Equal(chainhash.DoubleHashB(payload)[:4], hdr[20:24]) {
+		return n, nil, fmt.Errorf("%s message checksum mismatch", command)
+	}
+	err = m.BtcDecode(bytes.NewReader(payload), s.localVersion, wire.LatestEncoding)
+	if err != nil {
+		return n, nil, err
+	}
+	return n, m, nil
+}
+
+// IsCFHost says if a host string is for compact filter mode.
+func IsCFHost(host string) bool {
+	return strings.HasPrefix(host, cfHostPrefix)
+}
+
+// cfMode says if blocks are found with compact filters.  Anyone getting
+// raw blocks, like the watchtower, needs all of them, so then it's hard mode.
+func (s *SPVCon) cfMode() bool {
+	return s.CompactFilters && !s.RawBlockActive
+}
+
+// RegisterOutPointScript tells the SPVCon about an outpoint to watch along
+// with the script it's locked with, so compact filters can find its spend.
+func (s *SPVCon) RegisterOutPointScript(op wire.OutPoint, pkScript []byte) error {
+	err := s.RegisterOutPoint(op)
+	if err != nil {
+		return err
+	}
+	s.TrackingOPsMtx.Lock()
+	defer s.TrackingOPsMtx.Unlock()
+	return s.saveOPScript(op, pkScript)
+}
+
+// NeedScripts says if outpoints are found with compact filters, which need
+// their scripts.
+func (s *SPVCon) NeedScripts() bool {
+	return s.CompactFilters
+}
+
+// UnregisterOutPoint stops watching an outpoint.  Its script stays on disk;
+// it's only looked for while the outpoint is watched.
+func (s *SPVCon) UnregisterOutPoint(op wire.OutPoint) error {
+	s.TrackingOPsMtx.Lock()
+	delete(s.TrackingOPs, op)
+	delete(s.opScripts, op)
+	s.TrackingOPsMtx.Unlock()
+	return nil
+}
+
+// saveOPScript keeps the script of an outpoint, in RAM and on disk.  Call
+// with TrackingOPsMtx held.
+func (s *SPVCon) saveOPScript(op wire.OutPoint, pkScript []byte) error {
+	if !s.CompactFilters || len(pkScript) > 255 {
+		return nil
+	}
+	if _, ok := s.opScripts[op]; ok {
+		return nil
+	}
+	s.opScripts[op] = pkScript
+
+	// outpoint 36, script length 1, script
+	var buf bytes.Buffer
+	opArr := lnutil.OutPointToBytes(op)
+	buf.Write(opArr[:])
+	buf.WriteByte(uint8(len(pkScript)))
+	buf.Write(pkScript)
+	_, err := s.cfScriptFile.Write(buf.Bytes())
+	return err
+}
+
+// openCFScriptFile loads the outpoint scripts saved before, and opens the
+// file to add more.
+func (s *SPVCon) openCFScriptFile(fileName string) error {
+	s.opScripts = make(map[wire.OutPoint][]byte)
+
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for len(b) >= 37 && len(b) >= 37+int(b[36]) {
+		var opArr [36]byte
+		copy(opArr[:], b[:36])
+		op := lnutil.OutPointFromBytes(opArr)
+		s.opScripts[*op] = b[37 : 37+int(b[36])]
+		b = b[37+int(b[36]):]
+	}
+	log.Printf("loaded %d outpoint scripts from %s\n", len(s.opScripts), fileName)
+
+	s.cfScriptFile, err = os.OpenFile(
+		fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+// openCFHeaderFile opens the file filter headers are kept in.  Only
+// filterBlocks() uses it.
+func (s *SPVCon) openCFHeaderFile(fileName string) error {
+	var err error
+	s.cfHeaderFile, err = os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0600)
+	return err
+}
+
+// filterHeaderAt gives the saved filter header of the block at height, or
+// all zeros if there isn't one.
+func (s *SPVCon) filterHeaderAt(height int32) (chainhash.Hash, error) {
+	var fh chainhash.Hash
+	if height < s.Param.StartHeight {
+		return fh, nil
+	}
+	_, err := s.cfHeaderFile.ReadAt(
+		fh[:], int64(height-s.Param.StartHeight)*chainhash.HashSize)
+	if err == io.EOF {
+		return chainhash.Hash{}, nil
+	}
+	return fh, err
+}
+
+// getFilterHashes asks for the filter hashes of blocks start to stop, and
+// checks that they go on from the filter header saved for the block before
+// start.  Their headers get saved.
+func (s *SPVCon) getFilterHashes(
+	start, stop int32, stopHash chainhash.Hash) ([]chainhash.Hash, error) {
+	s.outMsgQueue <- &msgGetCFHeaders{msgGetCFilters{
+		FilterType:  filterTypeBasic,
+		StartHeight: uint32(start),
+		StopHash:    stopHash,
+	}}
+
+	var m *msgCFHeaders
+	select {
+	case m = <-s.cfheadersChan:
+	case <-time.After(cfTimeout):
+		return nil, fmt.Errorf("no filter headers for blocks %d to %d",
+			start, stop)
+	}
+	if m.FilterType != filterTypeBasic || !m.StopHash.IsEqual(&stopHash) ||
+		len(m.FilterHashes) != int(stop-start+1) {
+		return nil, fmt.Errorf("got %d type %d filter hashes to %s, "+
+			"expect %d to %s", len(m.FilterHashes), m.FilterType,
+			m.StopHash.String(), stop-start+1, stopHash.String())
+	}
+
+	prev, err := s.filterHeaderAt(start - 1)
+	if err != nil {
+		return nil, err
+	}
+	if prev == (chainhash.Hash{}) {
+		log.Printf("no filter header saved for block %d; taking peer's %s\n",
+			start-1, m.PrevFilterHeader.String())
+	} else if !prev.IsEqual(&m.PrevFilterHeader) {
+		return nil, fmt.Errorf("filter header for block %d is %s, peer says %s",
+			start-1, prev.String(), m.PrevFilterHeader.String())
+	}
+
+	var buf bytes.Buffer
+	for _, fh := range filterHeaders(m.PrevFilterHeader, m.FilterHashes) {
+		buf.Write(fh[:])
+	}
+	_, err = s.cfHeaderFile.WriteAt(
+		buf.Bytes(), int64(start-s.Param.StartHeight)*chainhash.HashSize)
+	if err != nil {
+		return nil, err
+	}
+	return m.FilterHashes, nil
+}
+
+// filterQuery gives the scripts to look for in the filter of the block at
+// height.  If there are outpoints we don't know the scripts of, all is true,
+// and every block needs to be looked at.  During a targeted rescan, it's
+// just the rescan's scripts.
+func (s *SPVCon) filterQuery(height int32) (query [][]byte, all bool, err error) {
+	s.TrackingAdrsMtx.Lock()
+	defer s.TrackingAdrsMtx.Unlock()
+	s.TrackingOPsMtx.Lock()
+	defer s.TrackingOPsMtx.Unlock()
+
+	// a targeted rescan only looks for its own scripts
+	if s.rescanTo != 0 {
+		if height <= s.rescanTo {
+			return append([][]byte(nil), s.rescanScripts...), false, nil
+		}
+		s.rescanScripts, s.rescanTo = nil, 0
+	}
+
+	for adr160 := range s.TrackingAdrs {
+		scripts, err := lnutil.AdrScripts(adr160)
+		if err != nil {
+			return nil, false, err
+		}
+		query = append(query, scripts...)
+	}
+	for tapKey := range s.TrackingTapKeys {
+		query = append(query, lnutil.P2TRScript(tapKey))
+	}
+	for op := range s.TrackingOPs {
+		pkScript, ok := s.opScripts[op]
+		if !ok {
+			all = true
+			continue
+		}
+		query = append(query, pkScript)
+	}
+	return query, all, nil
+}
+
+// filterBlocks gets filters for the blocks after the sync height up to
+// headerTip, and the blocks that match them.  Then it asks for headers
+// again, the same as getting the last block in the other modes.
+func (s *SPVCon) filterBlocks(headerTip int32) error {
+	// any filters left over from before aren't wanted
+	for len(s.cfilterChan) > 0 {
+		<-s.cfilterChan
+	}
+	for len(s.cfheadersChan) > 0 {
+		<-s.cfheadersChan
+	}
+
+	var warned bool
+	for start := s.syncHeight + 1; start <= headerTip; start += cfBatch {
+		stop := start + cfBatch - 1
+		if stop > headerTip {
+			stop = headerTip
+		}
+		stopHdr, err := s.GetHeaderAtHeight(stop)
+		if err != nil {
+			return err
+		}
+		filterHashes, err := s.getFilterHashes(start, stop, stopHdr.BlockHash())
+		if err != nil {
+			return err
+		}
+		log.Printf("asking for filters %d to %d\n", start, stop)
+		s.outMsgQueue <- &msgGetCFilters{
+			FilterType:  filterTypeBasic,
+			StartHeight: uint32(start),
+			StopHash:    stopHdr.BlockHash(),
+		}
+
+		for height := start; height <= stop; height++ {
+			hdr, err := s.GetHeaderAtHeight(height)
+			if err != nil {
+				return err
+			}
+			hash := hdr.BlockHash()
+
+			var cf *msgCFilter
+			select {
+			case cf = <-s.cfilterChan:
+			case <-time.After(cfTimeout):
+				return fmt.Errorf("no filter for block %d", height)
+			}
+			if cf.FilterType != filterTypeBasic || !cf.BlockHash.IsEqual(&hash) {
+				return fmt.Errorf("got filter type %d for block %s, expect %s",
+					cf.FilterType, cf.BlockHash.String(), hash.String())
+			}
+			if chainhash.DoubleHashH(cf.Filter) != filterHashes[height-start] {
+				return fmt.Errorf("block %d filter doesn't match its filter hash",
+					height)
+			}
+
+			// addresses can get added as blocks come in, so make the
+			// query each time
+			query, all, err := s.filterQuery(height)
+			if err != nil {
+				return err
+			}
+			match := all
+			if !all {
+				match, err = MatchFilter(cf.Filter, hash, query)
+				if err != nil {
+					return fmt.Errorf("block %d filter: %s", height, err.Error())
+				}
+			} else if !warned {
+				log.Printf("watching outpoints with unknown scripts; " +
+					"getting every block\n")
+				warned = true
+			}
+			if match {
+				err = s.filterBlock(hash, height)
+				if err != nil {
+					return err
+				}
+			}
+
+			s.syncHeight = height
+			s.CurrentHeightChan <- height
+		}
+	}
+	return s.AskForHeaders()
+}
+
+// filterBlock gets a block that matched its filter, and sends up the txs
+// in it that match.
+func (s *SPVCon) filterBlock(hash chainhash.Hash, height int32) error {
+	gdataMsg := wire.NewMsgGetData()
+	err := gdataMsg.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, &hash))
+	if err != nil {
+		return err
+	}
+	s.outMsgQueue <- gdataMsg
+
+	var m *wire.MsgBlock
+	select {
+	case m = <-s.cfBlockChan:
+	case <-time.After(cfTimeout):
+		return fmt.Errorf("no block %s at height %d", hash.String(), height)
+	}
+	newBlockHash := m.BlockHash()
+	if !newBlockHash.IsEqual(&hash) {
+		return fmt.Errorf("got block %s, expect %s",
+			newBlockHash.String(), hash.String())
+	}
+
+	var hits int
+	for _, tx := range m.Transactions {
+		if !s.MatchTx(tx) {
+			continue
+		}
+		hits++
+		log.Printf("found matching tx %s\n", tx.TxHash().String())
+
+		// keep the scripts of outpoints to watch, for their spends
+		txid := tx.TxHash()
+		s.TrackingOPsMtx.Lock()
+		for i, out := range tx.TxOut {
+			op := wire.OutPoint{Hash: txid, Index: uint32(i)}
+			if s.TrackingOPs[op] {
+				err = s.saveOPScript(op, out.PkScript)
+				if err != nil {
+					s.TrackingOPsMtx.Unlock()
+					return err
+				}
+			}
+		}
+		s.TrackingOPsMtx.Unlock()
+
+		s.TxUpToWallit <- lnutil.TxAndHeight{Tx: tx, Height: height}
+	}
+	log.Printf("block %d matched filter; %d matching txs\n", height, hits)
+	return nil
+}
@@ -0,0 +1,67 @@
+package uspv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+)
+
+// the BIP158 vector for the testnet genesis block, filter 019dfca8
+func TestFilterHeaders(t *testing.T) {
+	fh := chainhash.DoubleHashH([]byte{0x01, 0x9d, 0xfc, 0xa8})
+	want, err := chainhash.NewHashFromStr(
+		"21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hdrs := filterHeaders(chainhash.Hash{}, []chainhash.Hash{fh, fh})
+	if !hdrs[0].IsEqual(want) {
+		t.Fatalf("filter header %s, want %s", hdrs[0].String(), want.String())
+	}
+	// each goes on from the one before
+	next := filterHeaders(hdrs[0], []chainhash.Hash{fh})
+	if !hdrs[1].IsEqual(&next[0]) {
+		t.Fatalf("second filter header %s, want %s",
+			hdrs[1].String(), next[0].String())
+	}
+}
+
+func TestCFHeadersMsg(t *testing.T) {
+	m := &msgCFHeaders{FilterType: filterTypeBasic}
+	m.StopHash[0] = 0x11
+	m.PrevFilterHeader[31] = 0x22
+	m.FilterHashes = make([]chainhash.Hash, 3)
+	m.FilterHashes[2][5] = 0x33
+
+	var buf bytes.Buffer
+	err := m.BtcEncode(&buf, 0, wire.LatestEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	m2 := new(msgCFHeaders)
+	err = m2.BtcDecode(bytes.NewReader(b), 0, wire.LatestEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.StopHash != m.StopHash || m2.PrevFilterHeader != m.PrevFilterHeader ||
+		len(m2.FilterHashes) != 3 || m2.FilterHashes[2] != m.FilterHashes[2] {
+		t.Fatalf("cfheaders came back %+v", m2)
+	}
+
+	// more hashes than a peer can send
+	m.FilterHashes = make([]chainhash.Hash, cfHeaderBatch+1)
+	buf.Reset()
+	err = m.BtcEncode(&buf, 0, wire.LatestEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m2.BtcDecode(bytes.NewReader(buf.Bytes()), 0, wire.LatestEncoding)
+	if err == nil {
+		t.Fatalf("%d filter hashes decoded", cfHeaderBatch+1)
+	}
+}
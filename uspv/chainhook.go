@@ -1,8 +1,10 @@
 package uspv
 
 import (
+	"fmt"
 	"log"
 	"path/filepath"
+	"strings"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
@@ -10,79 +12,7 @@ import (
 	"github.com/mit-dci/lit/lnutil"
 )
 
-// ChainHook is an interface which provides access to a blockchain for the
-// wallit.  Right now the USPV package conforms to this interface, but it
-// should also be possible to plug in things like fullnode-rpc clients, and
-// (yuck) trusted block explorers.
-
-/*  The model here is that the wallit has lots of state on disk about
-what it's seen.  The ChainHook is ephemeral, and has some state in RAM but
-shouldn't have to keep track of too much; or at least what it keeps track of
-is internal and not exported out to the wallit (eg a fullnode keeps track
-of a lot, and SPV node, somewhat less, and a block explorer shim basically nothing)
-*/
-
-type ChainHook interface {
-
-	// Start turns on the ChainHook.  Later on, pass more parameters here.
-	// Also gets the txChan where txs come in from the ChainHook to the wallit.
-	// The TxChannel should never give txs that the wallit doesn't care about.  In the
-	// case of bloom filters, false positives should be handled and stopped at the
-	// ChainHook layer; wallit should not have to handle ingesting irrelevant txs.
-	// You get back an error and 2 channels: one for txs with height attached, and
-	// one with block heights.  Subject to change; maybe this is redundant.
-
-	// Note that for reorgs, the height chan just sends a lower height than you
-	// already have, and that means "reorg back!"
-	Start(height int32, host, path string, params *coinparam.Params) (
-		chan lnutil.TxAndHeight, chan int32, error)
-
-	// The Register functions send information to the ChainHook about what txs to
-	// return.  Txs matching either the addresses or outpoints will be returned
-	// via the TxChannel
-
-	// RegisterAddress tells the ChainHook about an address of interest.
-	// Give it an array; Currently needs to be 20 bytes.  Only p2pkh / p2wpkh
-	// are supported.
-	// Later could add a separate function for script hashes (20/32)
-	RegisterAddress(address [20]byte) error
-
-	// RegisterOutPoint tells the ChainHook about an outpoint of interest.
-	RegisterOutPoint(wire.OutPoint) error
-
-	// SetHeight sets the height ChainHook needs to look above.
-	// Returns a channel which tells the wallit what height the ChainHook has
-	// sync'd up to.  This chan should push int32s *after* the TxAndHeights
-	// have come in; so getting a "54" here means block 54 is done and fully parsed.
-	// Removed, put into Start().
-	//	SetHeight(startHeight int32) chan int32
-
-	// PushTx sends a tx out to the network via the ChainHook.
-	// Note that this does NOT register anything in the tx, so by just using this,
-	// nothing will come back about confirmation.  It WILL come back with errors
-	// though, so this takes some time.
-	PushTx(tx *wire.MsgTx) error
-
-	// Request all incoming blocks over this channel.  If RawBlocks isn't called,
-	// then the undelying hook package doesn't need to get full blocks.
-	// Currently you always call it with uspv...
-	RawBlocks() chan *wire.MsgBlock
-	// TODO -- reorgs.  Oh and doublespends and stuff.
-}
-
-/*
-type ChainHook interface {
-
-	Start() chan lnutil.TxAndHeight
-	RegisterAddress(address [20]byte) error
-	RegisterOutPoint(wire.OutPoint) error
-	SetHeight(startHeight int32) chan int32
-	PushTx(tx *wire.MsgTx) error
-	RawBlocks() chan *wire.MsgBlock
-}
-*/
-
-// --- implementation of ChainHook interface ----
+// --- implementation of chainhook.ChainHook interface ----
 
 func (s *SPVCon) Start(
 	startHeight int32, host, path string, params *coinparam.Params) (
@@ -95,6 +25,7 @@ func (s *SPVCon) Start(
 	s.Param = params
 
 	s.TrackingAdrs = make(map[[20]byte]bool)
+	s.TrackingTapKeys = make(map[[32]byte]bool)
 	s.TrackingOPs = make(map[wire.OutPoint]bool)
 
 	s.TxMap = make(map[chainhash.Hash]*wire.MsgTx)
@@ -106,6 +37,20 @@ func (s *SPVCon) Start(
 
 	s.syncHeight = startHeight
 
+	// cf:// in front of the host means use compact filters
+	if IsCFHost(host) {
+		s.CompactFilters = true
+		host = strings.TrimPrefix(host, cfHostPrefix)
+		err := s.openCFScriptFile(filepath.Join(path, cfScriptFileName))
+		if err != nil {
+			return nil, nil, err
+		}
+		err = s.openCFHeaderFile(filepath.Join(path, cfHeaderFileName))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	headerFilePath := filepath.Join(path, "header.bin")
 	// open header file
 	err := s.openHeaderFile(headerFilePath)
@@ -120,7 +65,7 @@ func (s *SPVCon) Start(
 		return nil, nil, err
 	}
 
-	err = s.AskForHeaders()
+	err = s.startHeaderSync()
 	if err != nil {
 		log.Printf("AskForHeaders error\n")
 		return nil, nil, err
@@ -131,7 +76,26 @@ func (s *SPVCon) Start(
 
 func (s *SPVCon) RegisterAddress(adr160 [20]byte) error {
 	s.TrackingAdrsMtx.Lock()
+	defer s.TrackingAdrsMtx.Unlock()
 	s.TrackingAdrs[adr160] = true
+	// addresses added during a targeted rescan, like past the gap of an
+	// account being rescanned, get looked for too
+	if s.rescanTo != 0 {
+		scripts, err := lnutil.AdrScripts(adr160)
+		if err != nil {
+			return err
+		}
+		s.rescanScripts = append(s.rescanScripts, scripts...)
+	}
+	return nil
+}
+
+func (s *SPVCon) RegisterTaprootKey(outKey [32]byte) error {
+	s.TrackingAdrsMtx.Lock()
+	s.TrackingTapKeys[outKey] = true
+	if s.rescanTo != 0 {
+		s.rescanScripts = append(s.rescanScripts, lnutil.P2TRScript(outKey))
+	}
 	s.TrackingAdrsMtx.Unlock()
 	return nil
 }
@@ -143,6 +107,87 @@ func (s *SPVCon) RegisterOutPoint(op wire.OutPoint) error {
 	return nil
 }
 
+// Rescan starts getting blocks again from fromHeight.  It only works once
+// synced up; while blocks are coming in, the heights would get mixed up.
+func (s *SPVCon) Rescan(fromHeight int32) error {
+	if fromHeight < s.headerStartHeight || fromHeight > s.syncHeight {
+		return fmt.Errorf("can't rescan from %d; have blocks %d to %d",
+			fromHeight, s.headerStartHeight, s.syncHeight)
+	}
+
+	select {
+	case <-s.inWaitState:
+	default:
+		return fmt.Errorf("still syncing; rescan once synced up")
+	}
+
+	log.Printf("rescanning from height %d\n", fromHeight)
+	s.syncHeight = fromHeight - 1
+	// asked for these blocks; don't skip any for the birthday
+	s.Birthday = 0
+
+	// any addresses registered since the last filter need to be in it
+	if !s.HardMode {
+		filt, err := s.GimmeFilter()
+		if err != nil {
+			return err
+		}
+		s.SendFilter(filt)
+	}
+
+	// this waits on the block queue, so don't hold up the caller
+	go func() {
+		err := s.AskForBlocks()
+		if err != nil {
+			log.Printf("Rescan AskForBlocks error: %s", err.Error())
+		}
+	}()
+	return nil
+}
+
+// RescanScripts rescans from fromHeight, only looking for scripts up to
+// toHeight.  With compact filters, that's just the blocks whose filters
+// match them; otherwise it's the same as Rescan.
+func (s *SPVCon) RescanScripts(fromHeight, toHeight int32, scripts [][]byte) error {
+	if !s.cfMode() {
+		return s.Rescan(fromHeight)
+	}
+	s.TrackingAdrsMtx.Lock()
+	s.rescanScripts, s.rescanTo = append([][]byte(nil), scripts...), toHeight
+	s.TrackingAdrsMtx.Unlock()
+
+	err := s.Rescan(fromHeight)
+	if err != nil {
+		s.TrackingAdrsMtx.Lock()
+		s.rescanScripts, s.rescanTo = nil, 0
+		s.TrackingAdrsMtx.Unlock()
+		return err
+	}
+	log.Printf("matching filters %d to %d against %d scripts\n",
+		fromHeight, toHeight, len(scripts))
+	return nil
+}
+
+// BlockHash gives the hash of the header at a height.
+func (s *SPVCon) BlockHash(height int32) (*chainhash.Hash, error) {
+	hdr, err := s.GetHeaderAtHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	hash := hdr.BlockHash()
+	return &hash, nil
+}
+
+// TipHeight is the higher of our header tip and the height the node we're
+// connected to said it had.
+func (s *SPVCon) TipHeight() int32 {
+	tip := s.GetHeaderTipHeight()
+	if s.remoteHeight > tip {
+		tip = s.remoteHeight
+	}
+	return tip
+}
+
 // PushTx sends a tx out to the global network
 func (s *SPVCon) PushTx(tx *wire.MsgTx) error {
 	// store tx in the RAM map for when other nodes ask for it
@@ -152,6 +197,19 @@ func (s *SPVCon) PushTx(tx *wire.MsgTx) error {
 	// since we never delete txs, this will eventually run out of RAM.
 	// But might take years... might be nice to fix.
 
+	// for compact filters, remember the scripts of outputs to watch
+	s.TrackingOPsMtx.Lock()
+	for i, out := range tx.TxOut {
+		op := wire.OutPoint{Hash: txid, Index: uint32(i)}
+		if s.TrackingOPs[op] {
+			err := s.saveOPScript(op, out.PkScript)
+			if err != nil {
+				log.Printf("PushTx saveOPScript error: %s\n", err.Error())
+			}
+		}
+	}
+	s.TrackingOPsMtx.Unlock()
+
 	// send out an inv message telling nodes we have this new tx
 	iv1 := wire.NewInvVect(wire.InvTypeWitnessTx, &txid)
 	invMsg := wire.NewMsgInv()
@@ -166,9 +224,36 @@ func (s *SPVCon) PushTx(tx *wire.MsgTx) error {
 	return nil
 }
 
+// RebroadcastTx announces the tx to our node again, and if we found that
+// node from the DNS seeds, sends it straight to a few others too; the one
+// we're connected to might have dropped it, or not be passing it on.
+func (s *SPVCon) RebroadcastTx(tx *wire.MsgTx) error {
+	err := s.PushTx(tx)
+	if err != nil {
+		return err
+	}
+	if !s.randomNodesOK {
+		return nil
+	}
+	go func() {
+		err := s.sendToFreshPeers(tx)
+		if err != nil {
+			log.Printf("rebroadcast %s: %s\n", tx.TxHash().String(), err.Error())
+		}
+	}()
+	return nil
+}
+
 // RawBlocks returns a channel where all the blocks appear.
 func (s *SPVCon) RawBlocks() chan *wire.MsgBlock {
 	s.RawBlockActive = true
 	s.RawBlockSender = make(chan *wire.MsgBlock, 8) // I dunno, 8?
 	return s.RawBlockSender
 }
+
+// MempoolTxs returns a channel where all the unconfirmed txs nodes tell us
+// about appear.  Not in ironman mode, where we don't ask for them.
+func (s *SPVCon) MempoolTxs() chan *wire.MsgTx {
+	s.MempoolSender = make(chan *wire.MsgTx, mempoolBuffer)
+	return s.MempoolSender
+}
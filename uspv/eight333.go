@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
@@ -19,6 +20,10 @@ const (
 	// version hardcoded for now, probably ok...?
 	// 70012 is for segnet... make this an init var?
 	VERSION = 70012
+
+	// mempoolBuffer is how many unconfirmed txs can wait for the watchtower
+	// to look at them before they're dropped
+	mempoolBuffer = 100
 )
 
 // GimmeFilter ... or I'm gonna fade away
@@ -29,7 +34,8 @@ func (s *SPVCon) GimmeFilter() (*bloom.Filter, error) {
 	s.TrackingOPsMtx.Lock()
 	defer s.TrackingOPsMtx.Unlock()
 
-	filterElements := uint32(len(s.TrackingAdrs) + (len(s.TrackingOPs)))
+	filterElements := uint32(len(s.TrackingAdrs) + len(s.TrackingTapKeys) +
+		2*len(s.TrackingOPs))
 
 	f := bloom.NewFilter(filterElements, 0, 0.000001, wire.BloomUpdateAll)
 
@@ -39,6 +45,9 @@ func (s *SPVCon) GimmeFilter() (*bloom.Filter, error) {
 		//		log.Printf("adding address hash %x\n", a160)
 		f.Add(a160[:])
 	}
+	for tapKey, _ := range s.TrackingTapKeys { // add 32-byte taproot key
+		f.Add(tapKey[:])
+	}
 	//	for _, u := range allUtxos {
 	//		f.AddOutPoint(&u.Op)
 	//	}
@@ -46,8 +55,9 @@ func (s *SPVCon) GimmeFilter() (*bloom.Filter, error) {
 	// actually... we should monitor addresses, not txids, right?
 	// or no...?
 	for wop, _ := range s.TrackingOPs {
-		// try just outpoints, not the txids as well
 		f.AddOutPoint(&wop)
+		// and the txid, for the tx making it, which may not pay us
+		f.AddHash(&wop.Hash)
 	}
 	// still some problem with filter?  When they broadcast a close which doesn't
 	// send any to us, sometimes we don't see it and think the channel is still open.
@@ -76,11 +86,14 @@ func (s *SPVCon) MatchTx(tx *wire.MsgTx) bool {
 		// 20 byte pubkey hash of this txout (if any)
 		var adr20 [20]byte
 		copy(adr20[:], lnutil.KeyHashFromPkScript(out.PkScript))
+		// or the 32 byte output key, if it's taproot
+		var tapKey [32]byte
+		copy(tapKey[:], lnutil.TaprootKeyFromPkScript(out.PkScript))
 		// when we gain utxo, set as gain so we can return a match, but
 		// also go through all gained utxos and register to track them
 
 		//		log.Printf("got output key %x ", adr20)
-		if s.TrackingAdrs[adr20] {
+		if s.TrackingAdrs[adr20] || s.TrackingTapKeys[tapKey] {
 			gain = true
 			s.TrackingOPs[*op] = true
 		} else {
@@ -238,6 +251,17 @@ func (s *SPVCon) IngestHeaders(m *wire.MsgHeaders) (bool, error) {
 	defer s.headerMutex.Unlock()
 
 	reorgHeight, err := CheckHeaderChain(s.headerFile, m.Headers, s.Param)
+	if cpErr, ok := err.(checkpointError); ok {
+		// headers under the last checkpoint don't get their proof of work
+		// checked, so everything since the checkpoint before could be made
+		// up.  Cut back to it and ask again.
+		log.Printf("Header error: %s\n", err.Error())
+		err = s.truncateHeaders(s.Param.CheckpointBefore(cpErr.height))
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
 	if err != nil {
 		// insufficient depth reorg means we're still trying to sync up?
 		// really, the re-org hasn't been proven; if the remote node
@@ -276,6 +300,24 @@ func (s *SPVCon) IngestHeaders(m *wire.MsgHeaders) (bool, error) {
 	return true, nil
 }
 
+// truncateHeaders cuts the header file back to end at a height, telling the
+// wallit if that's under the sync height.  Call with headerMutex held.
+func (s *SPVCon) truncateHeaders(height int32) error {
+	if height < s.headerStartHeight {
+		height = s.headerStartHeight
+	}
+	err := s.headerFile.Truncate(int64(height-s.headerStartHeight+1) * 80)
+	if err != nil {
+		return err
+	}
+	log.Printf("cut headers back to height %d\n", height)
+	if s.syncHeight > height {
+		s.CurrentHeightChan <- height
+		s.syncHeight = height
+	}
+	return nil
+}
+
 func (s *SPVCon) AskForHeaders() error {
 	ghdr := wire.NewMsgGetHeaders()
 	ghdr.ProtocolVersion = s.localVersion
@@ -348,6 +390,18 @@ func (s *SPVCon) AskForBlocks() error {
 	if s.syncHeight > headerTip {
 		return fmt.Errorf("error- db longer than headers! shouldn't happen.")
 	}
+	// headers under the checkpoint had no proof of work checked, so until
+	// they've met its hash they could be anything
+	if cp := s.Param.PoWCheckpoint(); headerTip < cp {
+		return fmt.Errorf("headers stop at %d, under checkpoint %d; "+
+			"not getting blocks till they reach it", headerTip, cp)
+	}
+	if s.Birthday != 0 {
+		err = s.skipToBirthday(headerTip)
+		if err != nil {
+			return err
+		}
+	}
 	if s.syncHeight == headerTip {
 		// nothing to ask for; set wait state and return
 		log.Printf("no blocks to request, entering wait state\n")
@@ -370,6 +424,10 @@ func (s *SPVCon) AskForBlocks() error {
 		return nil
 	}
 
+	if s.cfMode() {
+		return s.filterBlocks(headerTip)
+	}
+
 	log.Printf("will request blocks %d to %d\n", s.syncHeight+1, headerTip)
 	reqHeight := s.syncHeight
 
@@ -419,3 +477,40 @@ func (s *SPVCon) AskForBlocks() error {
 	}
 	return nil
 }
+
+// birthdayMargin is how long before the birthday to start getting blocks;
+// block timestamps can be a couple hours off, and so can clocks.
+const birthdayMargin = 24 * time.Hour
+
+// skipToBirthday moves the sync height up past blocks from before the
+// birthday, without asking for them.  Once the birthday is in the headers,
+// it's cleared, so new blocks come in as usual.
+func (s *SPVCon) skipToBirthday(headerTip int32) error {
+	cutoff := time.Unix(s.Birthday, 0).Add(-birthdayMargin)
+
+	// find the first header from after the cutoff.  Timestamps only
+	// roughly go up, but the margin covers that.
+	lo, hi := s.syncHeight+1, headerTip+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		hdr, err := s.GetHeaderAtHeight(mid)
+		if err != nil {
+			return err
+		}
+		if hdr.Timestamp.Before(cutoff) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo <= headerTip {
+		s.Birthday = 0
+	}
+	if lo-1 > s.syncHeight {
+		log.Printf("skipping blocks %d to %d, from before wallet birthday\n",
+			s.syncHeight+1, lo-1)
+		s.syncHeight = lo - 1
+		s.CurrentHeightChan <- s.syncHeight
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package uspv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+)
+
+/*
+BIP158 basic block filters are Golomb-coded sets of every output script in
+a block, and every script its inputs spend.  Each script is siphashed with
+the first 16 bytes of the block hash as the key, and mapped to a number
+under N*M.  Those are sorted, and the differences between them written out
+Golomb-Rice coded: the difference >> P in unary (that many 1s, then a 0),
+then the low P bits.
+
+filter serialization:
+varint	N, the number of scripts
+	the coded differences, MSB first, padded to a byte
+*/
+
+const (
+	gcsP = 19     // bits of each difference written out straight
+	gcsM = 784931 // 1 / false positive rate
+)
+
+// sipHash is SipHash-2-4 of p, with key k0, k1.
+func sipHash(k0, k1 uint64, p []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	last := uint64(len(p)) << 56
+	for ; len(p) >= 8; p = p[8:] {
+		m := binary.LittleEndian.Uint64(p)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	for i, b := range p {
+		last |= uint64(b) << (8 * uint(i))
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// gcsValues hashes items into a block's filter range, sorted.
+func gcsValues(blockHash chainhash.Hash, n uint64, items [][]byte) []uint64 {
+	k0 := binary.LittleEndian.Uint64(blockHash[0:8])
+	k1 := binary.LittleEndian.Uint64(blockHash[8:16])
+	f := n * gcsM
+
+	values := make([]uint64, len(items))
+	for i, item := range items {
+		// top 64 bits of hash * f
+		values[i], _ = bits.Mul64(sipHash(k0, k1, item), f)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+// bitReader reads a byte slice a bit at a time, MSB first.
+type bitReader struct {
+	b   []byte
+	pos uint // bits read
+}
+
+func (r *bitReader) readBit() (uint64, error) {
+	if r.pos/8 >= uint(len(r.b)) {
+		return 0, fmt.Errorf("filter ends early")
+	}
+	bit := uint64(r.b[r.pos/8]>>(7-r.pos%8)) & 1
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n uint) (uint64, error) {
+	var v uint64
+	for i := uint(0); i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	return v, nil
+}
+
+// MatchFilter says if any of items, output scripts, are in a BIP158 basic
+// filter for the block with blockHash.
+func MatchFilter(filter []byte, blockHash chainhash.Hash, items [][]byte) (bool, error) {
+	fr := bytes.NewReader(filter)
+	n, err := wire.ReadVarInt(fr, 0)
+	if err != nil {
+		return false, err
+	}
+	if n == 0 || len(items) == 0 {
+		return false, nil
+	}
+	want := gcsValues(blockHash, n, items)
+
+	r := &bitReader{b: filter[len(filter)-fr.Len():]}
+	var value uint64
+	for i := uint64(0); i < n; i++ {
+		// quotient in unary
+		var q uint64
+		for {
+			bit, err := r.readBit()
+			if err != nil {
+				return false, err
+			}
+			if bit == 0 {
+				break
+			}
+			q++
+		}
+		rem, err := r.readBits(gcsP)
+		if err != nil {
+			return false, err
+		}
+		value += q<<gcsP | rem
+
+		for len(want) > 0 && want[0] < value {
+			want = want[1:]
+		}
+		if len(want) == 0 {
+			return false, nil
+		}
+		if want[0] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
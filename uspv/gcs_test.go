@@ -0,0 +1,127 @@
+package uspv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+)
+
+// the reference vectors: key 00..0f, messages 00, 00 01, ...
+func TestSipHash(t *testing.T) {
+	var key [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k0 := uint64(0x0706050403020100)
+	k1 := uint64(0x0f0e0d0c0b0a0908)
+
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	tests := []struct {
+		n    int
+		want uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{15, 0xa129ca6149be45e5},
+	}
+	for _, tc := range tests {
+		got := sipHash(k0, k1, msg[:tc.n])
+		if got != tc.want {
+			t.Errorf("siphash of %d bytes: got %x, want %x", tc.n, got, tc.want)
+		}
+	}
+}
+
+// buildFilter makes a BIP158 filter of items, the way a full node does.
+func buildFilter(blockHash chainhash.Hash, items [][]byte) []byte {
+	var buf bytes.Buffer
+	wire.WriteVarInt(&buf, 0, uint64(len(items)))
+	if len(items) == 0 {
+		return buf.Bytes()
+	}
+	values := gcsValues(blockHash, uint64(len(items)), items)
+
+	var out []byte
+	var cur byte
+	var nbits uint
+	writeBit := func(bit uint64) {
+		cur = cur<<1 | byte(bit)
+		nbits++
+		if nbits == 8 {
+			out = append(out, cur)
+			cur, nbits = 0, 0
+		}
+	}
+	var last uint64
+	for _, v := range values {
+		delta := v - last
+		last = v
+		for q := delta >> gcsP; q > 0; q-- {
+			writeBit(1)
+		}
+		writeBit(0)
+		for i := int(gcsP) - 1; i >= 0; i-- {
+			writeBit((delta >> uint(i)) & 1)
+		}
+	}
+	if nbits > 0 {
+		out = append(out, cur<<(8-nbits))
+	}
+	return append(buf.Bytes(), out...)
+}
+
+func TestMatchFilter(t *testing.T) {
+	blockHash := chainhash.DoubleHashH([]byte("block"))
+
+	var items [][]byte
+	for i := 0; i < 100; i++ {
+		items = append(items, chainhash.DoubleHashB([]byte{byte(i), 1}))
+	}
+	filter := buildFilter(blockHash, items)
+
+	for _, i := range []int{0, 37, 99} {
+		match, err := MatchFilter(filter, blockHash, [][]byte{items[i]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !match {
+			t.Errorf("item %d not matched", i)
+		}
+	}
+
+	// things not in it shouldn't match, except at the false positive rate
+	var others [][]byte
+	for i := 0; i < 100; i++ {
+		others = append(others, chainhash.DoubleHashB([]byte{byte(i), 2}))
+	}
+	match, err := MatchFilter(filter, blockHash, others)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match {
+		t.Errorf("matched items not in the filter")
+	}
+
+	// one in among others
+	match, err = MatchFilter(filter, blockHash, append(others, items[50]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Errorf("item 50 not matched among others")
+	}
+
+	// empty filter matches nothing
+	match, err = MatchFilter(buildFilter(blockHash, nil), blockHash, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match {
+		t.Errorf("empty filter matched")
+	}
+}
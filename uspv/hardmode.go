@@ -157,6 +157,17 @@ func (s *SPVCon) IngestBlock(m *wire.MsgBlock) {
 		return
 	}
 
+	// with compact filters, blocks are asked for one at a time by
+	// filterBlocks(), so give it to that
+	if s.cfMode() {
+		select {
+		case s.cfBlockChan <- m:
+		default:
+			log.Printf("Unrequested full block")
+		}
+		return
+	}
+
 	var hah HashAndHeight
 	select { // select here so we don't block on an unrequested mblock
 	case hah = <-s.blockQueue: // pop height off mblock queue
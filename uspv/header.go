@@ -183,6 +183,16 @@ func FindHeader(r io.ReadSeeker, hdr wire.BlockHeader) (int32, error) {
 	return 0, nil
 }
 
+// checkpointError is a header at a checkpoint's height that isn't the
+// checkpoint.
+type checkpointError struct {
+	height int32
+}
+
+func (e checkpointError) Error() string {
+	return fmt.Sprintf("header %d doesn't match checkpoint", e.height)
+}
+
 // CheckHeaderChain takes in the headers message and sees if they all validate.
 // This function also needs read access to the previous headers.
 // Does not deal with re-orgs; assumes new headers link to tip
@@ -314,6 +324,14 @@ func CheckHeaderChain(
 				attachHeight+int32(len(inHeaders)), height-1)
 		}
 
+		// a reorg that deep is more likely an attack than a real fork
+		if height-1-attachHeight > p.ReorgDepth() ||
+			height-attachHeight >= numheaders {
+			return 0, fmt.Errorf(
+				"reorg takes off %d blocks back to height %d; max %d",
+				height-1-attachHeight, attachHeight, p.ReorgDepth())
+		}
+
 		log.Printf("reorg from height %d to %d",
 			height-1, attachHeight+int32(len(inHeaders)))
 
@@ -327,13 +345,23 @@ func CheckHeaderChain(
 	// check difficulty adjustments in the new headers
 	// since we call this many times, append each time
 	for i, hdr := range inHeaders {
-		if height+int32(i) > p.AssumeDiffBefore {
+		// build slice of "previous" headers
+		prevHeaders = append(prevHeaders, inHeaders[i])
+
+		// headers at checkpoints have to match them
+		cpHash := p.CheckpointHash(height + int32(i))
+		if cpHash != nil {
+			hash := hdr.BlockHash()
+			if !hash.IsEqual(cpHash) {
+				return 0, checkpointError{height + int32(i)}
+			}
+		}
+
+		if height+int32(i) > p.AssumeDiffBefore && !p.SkipPoW(height+int32(i)) {
 			// check if there's a valid proof of work.  That whole "Bitcoin" thing.
 			if !checkProofOfWork(*hdr, p, height+int32(i)) {
 				return 0, fmt.Errorf("header %d in message has bad proof of work", i)
 			}
-			// build slice of "previous" headers
-			prevHeaders = append(prevHeaders, inHeaders[i])
 			rightBits, err := p.DiffCalcFunction(prevHeaders, height+int32(i), p)
 			if err != nil {
 				return 0, fmt.Errorf("Error calculating Block %d %s difficuly. %s",
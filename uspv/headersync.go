@@ -0,0 +1,441 @@
+package uspv
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/coinparam"
+)
+
+/*
+Parallel header sync
+
+Getting every header from the one node, a 2000 header message at a time,
+each checked and written before asking for the next, takes hours on a long
+chain.  When the coin has checkpoints past our tip and we're finding nodes
+from the DNS seeds, the headers up to the last checkpoint are split into
+segments that end at checkpoints, and several more nodes get a segment each
+at once.  A node asks for its next batch as soon as one comes in, while the
+segments already done are checked for difficulty and written in order.
+
+A segment has to link up header by header from where it starts to exactly
+the checkpoint hash it ends at, with valid proof of work if VerifyAllPoW is
+set.  If it doesn't, it goes to another node, and the node that gave it is
+dropped; so each segment is checked against the checkpoints, and the
+segments against each other where they meet.  Past the last checkpoint, the
+main node takes over as before.
+*/
+
+const (
+	// headerPeers is how many extra nodes to get headers from at once.
+	headerPeers = 4
+	// minParallelHeaders is how many headers have to be left before the
+	// last checkpoint to bother with extra nodes.
+	minParallelHeaders = 10000
+	// headerTimeout is how long a node gets to answer a getheaders.
+	headerTimeout = 30 * time.Second
+	// segmentTries is how many nodes get a go at a segment before giving
+	// up on parallel sync.
+	segmentTries = 3
+	// rebroadcastPeers is how many nodes besides the main one an
+	// unconfirmed tx gets sent to when it's rebroadcast.
+	rebroadcastPeers = 3
+)
+
+// headerSegment is a stretch of headers: the ones after startHash, up to
+// and including endHash, which is a checkpoint.
+type headerSegment struct {
+	idx         int
+	startHash   chainhash.Hash
+	startHeight int32
+	endHash     chainhash.Hash
+	endHeight   int32
+	tries       int
+}
+
+// segmentResult is a segment's headers, or nil headers if a node's gone.
+type segmentResult struct {
+	seg  *headerSegment
+	msgs []*wire.MsgHeaders
+}
+
+// headerSegments splits the headers from our tip to the last checkpoint
+// into segments.  nil if there aren't enough to bother.
+func (s *SPVCon) headerSegments() ([]*headerSegment, error) {
+	tipHeight := s.GetHeaderTipHeight()
+	cps := s.Param.Checkpoints
+	if len(cps) == 0 || cps[len(cps)-1].Height-tipHeight < minParallelHeaders {
+		return nil, nil
+	}
+	tip, err := s.GetHeaderAtHeight(tipHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []*headerSegment
+	startHash, startHeight := tip.BlockHash(), tipHeight
+	for _, cp := range cps {
+		if cp.Height <= startHeight {
+			continue
+		}
+		segs = append(segs, &headerSegment{
+			idx:         len(segs),
+			startHash:   startHash,
+			startHeight: startHeight,
+			endHash:     *cp.Hash,
+			endHeight:   cp.Height,
+		})
+		startHash, startHeight = *cp.Hash, cp.Height
+	}
+	return segs, nil
+}
+
+// startHeaderSync starts getting headers: from extra nodes in parallel, up
+// to the last checkpoint, if that's worth doing, and then from the main
+// node.
+func (s *SPVCon) startHeaderSync() error {
+	if !s.randomNodesOK {
+		return s.AskForHeaders()
+	}
+	segs, err := s.headerSegments()
+	if err != nil {
+		return err
+	}
+	if segs == nil {
+		return s.AskForHeaders()
+	}
+
+	go func() {
+		err := s.parallelHeaders(segs)
+		if err != nil {
+			// whatever's written is fine; the main node gets the rest
+			log.Printf("parallel header sync: %s\n", err.Error())
+		}
+		err = s.AskForHeaders()
+		if err != nil {
+			log.Printf("AskForHeaders error: %s", err.Error())
+		}
+	}()
+	return nil
+}
+
+// parallelHeaders gets the segments from DNS seed nodes other than the main
+// one, and writes them to the header file in order.
+func (s *SPVCon) parallelHeaders(segs []*headerSegment) error {
+	nodes, err := s.GetListOfNodes()
+	if err != nil {
+		return err
+	}
+	mainHost, _, _ := net.SplitHostPort(s.con.RemoteAddr().String())
+	addrs := make(chan string, len(nodes))
+	for _, node := range nodes {
+		if node != mainHost {
+			addrs <- node
+		}
+	}
+	close(addrs)
+
+	log.Printf("getting headers %d to %d in %d segments from up to %d nodes\n",
+		segs[0].startHeight+1, segs[len(segs)-1].endHeight, len(segs), headerPeers)
+
+	queue := make(chan *headerSegment, len(segs))
+	for _, seg := range segs {
+		queue <- seg
+	}
+	results := make(chan segmentResult)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	workers := headerPeers
+	if len(segs) < workers {
+		workers = len(segs)
+	}
+	for i := 0; i < workers; i++ {
+		go s.segmentWorker(addrs, queue, results, quit)
+	}
+
+	done := make(map[int][]*wire.MsgHeaders)
+	next := 0
+	for next < len(segs) {
+		res := <-results
+		if res.msgs == nil {
+			if res.seg != nil {
+				// give it to another node
+				res.seg.tries++
+				if res.seg.tries >= segmentTries {
+					return fmt.Errorf("no node gave good headers %d to %d",
+						res.seg.startHeight+1, res.seg.endHeight)
+				}
+				queue <- res.seg
+				continue
+			}
+			// out of nodes
+			workers--
+			if workers == 0 {
+				return fmt.Errorf("ran out of nodes at segment %d of %d",
+					next, len(segs))
+			}
+			continue
+		}
+
+		done[res.seg.idx] = res.msgs
+		for ; done[next] != nil; next++ {
+			for _, m := range done[next] {
+				_, err = s.IngestHeaders(m)
+				if err != nil {
+					return err
+				}
+			}
+			delete(done, next)
+		}
+	}
+	log.Printf("parallel header sync up to %d done\n", segs[len(segs)-1].endHeight)
+	return nil
+}
+
+// segmentWorker connects to a node from addrs, and gets segments from it
+// till there are none left.  A segment the node gets wrong goes back in the
+// queue, and the worker moves on to another node.  When addrs runs out, it
+// sends a nil result and quits.
+func (s *SPVCon) segmentWorker(addrs <-chan string,
+	queue <-chan *headerSegment, results chan<- segmentResult,
+	quit <-chan struct{}) {
+
+	var hp *headerPeer
+	for {
+		for hp == nil {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			addr, ok := <-addrs
+			if !ok {
+				select {
+				case results <- segmentResult{}:
+				case <-quit:
+				}
+				return
+			}
+			var err error
+			hp, err = s.dialHeaderPeer(addr)
+			if err != nil {
+				log.Printf("header node %s: %s\n", addr, err.Error())
+				hp = nil
+			}
+		}
+
+		var seg *headerSegment
+		select {
+		case seg = <-queue:
+		case <-quit:
+			hp.con.Close()
+			return
+		}
+
+		msgs, err := hp.getSegment(seg, s.Param)
+		if err != nil {
+			log.Printf("header node %s: %s\n", hp.addr, err.Error())
+			hp.con.Close()
+			hp = nil
+		}
+		select {
+		case results <- segmentResult{seg: seg, msgs: msgs}:
+		case <-quit:
+			if hp != nil {
+				hp.con.Close()
+			}
+			return
+		}
+	}
+}
+
+// headerPeer is a connection to a node just for getting headers.
+type headerPeer struct {
+	addr    string
+	con     net.Conn
+	version uint32
+	net     wire.BitcoinNet
+}
+
+// dialHeaderPeer connects and does the version handshake.
+func (s *SPVCon) dialHeaderPeer(addr string) (*headerPeer, error) {
+	conString, conMode, err := s.parseRemoteNode(addr)
+	if err != nil {
+		return nil, err
+	}
+	con, err := net.DialTimeout(conMode, conString, headerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	hp := &headerPeer{addr: addr, con: con, version: VERSION,
+		net: wire.BitcoinNet(s.Param.NetMagicBytes)}
+
+	myMsgVer, err := wire.NewMsgVersionFromConn(con, 0, 0)
+	if err == nil {
+		err = myMsgVer.AddUserAgent("lit", "v0.1")
+	}
+	if err == nil {
+		err = hp.send(myMsgVer)
+	}
+	if err != nil {
+		con.Close()
+		return nil, err
+	}
+
+	// wait for their version and verack, in either order
+	var gotVersion, gotVerAck bool
+	for !gotVersion || !gotVerAck {
+		m, err := hp.read()
+		if err != nil {
+			con.Close()
+			return nil, err
+		}
+		switch m := m.(type) {
+		case *wire.MsgVersion:
+			if m.ProtocolVersion < 70013 {
+				con.Close()
+				return nil, fmt.Errorf("version %d too old", m.ProtocolVersion)
+			}
+			gotVersion = true
+			err = hp.send(wire.NewMsgVerAck())
+			if err != nil {
+				con.Close()
+				return nil, err
+			}
+		case *wire.MsgVerAck:
+			gotVerAck = true
+		}
+	}
+	return hp, nil
+}
+
+func (hp *headerPeer) send(m wire.Message) error {
+	hp.con.SetDeadline(time.Now().Add(headerTimeout))
+	_, err := wire.WriteMessageWithEncodingN(
+		hp.con, m, hp.version, hp.net, wire.LatestEncoding)
+	return err
+}
+
+// read gets the next message we can decode, answering pings on the way.
+func (hp *headerPeer) read() (wire.Message, error) {
+	for {
+		hp.con.SetDeadline(time.Now().Add(headerTimeout))
+		_, m, _, err := wire.ReadMessageWithEncodingN(
+			hp.con, hp.version, hp.net, wire.LatestEncoding)
+		if err != nil {
+			// messages we don't know are skipped over; keep going
+			if _, ok := err.(*wire.MessageError); ok {
+				continue
+			}
+			return nil, err
+		}
+		if ping, ok := m.(*wire.MsgPing); ok {
+			err = hp.send(wire.NewMsgPong(ping.Nonce))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return m, nil
+	}
+}
+
+// sendToFreshPeers connects to up to rebroadcastPeers nodes from the DNS
+// seeds, other than the main one, and sends each the tx.
+func (s *SPVCon) sendToFreshPeers(tx *wire.MsgTx) error {
+	nodes, err := s.GetListOfNodes()
+	if err != nil {
+		return err
+	}
+	mainHost, _, _ := net.SplitHostPort(s.con.RemoteAddr().String())
+	var sent int
+	for _, i := range rand.Perm(len(nodes)) {
+		if sent >= rebroadcastPeers {
+			break
+		}
+		if nodes[i] == mainHost {
+			continue
+		}
+		hp, err := s.dialHeaderPeer(nodes[i])
+		if err != nil {
+			log.Printf("rebroadcast node %s: %s\n", nodes[i], err.Error())
+			continue
+		}
+		err = hp.send(tx)
+		hp.con.Close()
+		if err != nil {
+			log.Printf("rebroadcast node %s: %s\n", nodes[i], err.Error())
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return fmt.Errorf("couldn't get the tx to any other node")
+	}
+	log.Printf("sent tx %s to %d other nodes\n", tx.TxHash().String(), sent)
+	return nil
+}
+
+// getSegment gets a segment's headers, asking for each batch from the end
+// of the last one, and checks that they link up to the checkpoint, with
+// valid proof of work if the coin checks it under checkpoints.  Difficulty
+// is checked when they're ingested.
+func (hp *headerPeer) getSegment(
+	seg *headerSegment, p *coinparam.Params) ([]*wire.MsgHeaders, error) {
+
+	var msgs []*wire.MsgHeaders
+	prev, height := seg.startHash, seg.startHeight
+	for height < seg.endHeight {
+		ghdr := wire.NewMsgGetHeaders()
+		ghdr.ProtocolVersion = hp.version
+		err := ghdr.AddBlockLocatorHash(&prev)
+		if err != nil {
+			return nil, err
+		}
+		ghdr.HashStop = seg.endHash
+		err = hp.send(ghdr)
+		if err != nil {
+			return nil, err
+		}
+
+		var m *wire.MsgHeaders
+		for m == nil {
+			msg, err := hp.read()
+			if err != nil {
+				return nil, err
+			}
+			m, _ = msg.(*wire.MsgHeaders)
+		}
+		if len(m.Headers) == 0 {
+			return nil, fmt.Errorf("no headers after %d, expect up to %d",
+				height, seg.endHeight)
+		}
+
+		for _, hdr := range m.Headers {
+			if !hdr.PrevBlock.IsEqual(&prev) {
+				return nil, fmt.Errorf("header %d doesn't link", height+1)
+			}
+			height++
+			if height > seg.endHeight {
+				return nil, fmt.Errorf("headers past checkpoint %d", seg.endHeight)
+			}
+			if height > p.AssumeDiffBefore && !p.SkipPoW(height) &&
+				!checkProofOfWork(*hdr, p, height) {
+				return nil, fmt.Errorf("header %d has bad proof of work", height)
+			}
+			prev = hdr.BlockHash()
+		}
+		msgs = append(msgs, m)
+	}
+	if !prev.IsEqual(&seg.endHash) {
+		return nil, fmt.Errorf("header %d is %s, not checkpoint %s",
+			seg.endHeight, prev.String(), seg.endHash.String())
+	}
+	return msgs, nil
+}
@@ -148,6 +148,10 @@ func (s *SPVCon) Handshake(listOfNodes []string) error {
 		return fmt.Errorf("Couldn't connect to this node. Returning!")
 	}
 
+	if s.CompactFilters && mv.Services&sfNodeCompactFilters == 0 {
+		return fmt.Errorf("%s doesn't serve compact filters", mv.UserAgent)
+	}
+
 	log.Printf("remote reports version %x (dec %d)\n",
 		mv.ProtocolVersion, mv.ProtocolVersion)
 
@@ -243,6 +247,9 @@ func (s *SPVCon) Connect(remoteNode string) error {
 		s.blockQueue = make(chan HashAndHeight, 1) // queue depth 1 for spv
 	}
 	s.fPositives = make(chan int32, 4000) // a block full, approx
+	s.cfilterChan = make(chan *msgCFilter, cfBatch)
+	s.cfheadersChan = make(chan *msgCFHeaders, 1)
+	s.cfBlockChan = make(chan *wire.MsgBlock, 1)
 	s.inWaitState = make(chan bool, 1)
 	go s.fPositiveHandler()
 
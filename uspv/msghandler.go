@@ -10,10 +10,9 @@ import (
 
 func (s *SPVCon) incomingMessageHandler() {
 	for {
-		n, xm, _, err := wire.ReadMessageWithEncodingN(s.con, s.localVersion,
-			wire.BitcoinNet(s.Param.NetMagicBytes), wire.LatestEncoding)
+		n, xm, err := s.readMessage()
 		if err != nil {
-			log.Printf("ReadMessageWithEncodingN error.  Disconnecting from given peer. %s\n", err.Error())
+			log.Printf("readMessage error.  Disconnecting from given peer. %s\n", err.Error())
 			if s.randomNodesOK { // if user wants to connect to localhost, let him do so
 				s.Connect("yes") // really any YupString here
 			} else {
@@ -39,6 +38,18 @@ func (s *SPVCon) incomingMessageHandler() {
 			log.Printf("Got a pong response. OK.\n")
 		case *wire.MsgBlock:
 			s.IngestBlock(m)
+		case *msgCFilter:
+			select {
+			case s.cfilterChan <- m:
+			default:
+				log.Printf("unrequested filter for %s\n", m.BlockHash.String())
+			}
+		case *msgCFHeaders:
+			select {
+			case s.cfheadersChan <- m:
+			default:
+				log.Printf("unrequested filter headers to %s\n", m.StopHash.String())
+			}
 		case *wire.MsgMerkleBlock:
 			s.IngestMerkleBlock(m)
 		case *wire.MsgHeaders: // concurrent because we keep asking for blocks
@@ -189,6 +200,15 @@ func (s *SPVCon) TxHandler(tx *wire.MsgTx) {
 	//		}
 	//	}
 
+	// everything unconfirmed goes to whoever's watching the mempool
+	if height == 0 && cap(s.MempoolSender) != 0 {
+		select {
+		case s.MempoolSender <- tx:
+		default:
+			log.Printf("mempool chan full; dropped tx %s\n", tx.TxHash().String())
+		}
+	}
+
 	// send txs up to wallit
 	if s.MatchTx(tx) {
 		s.TxUpToWallit <- lnutil.TxAndHeight{tx, height}
@@ -18,6 +18,14 @@ type SPVCon struct {
 	// but have not yet graduated to full nodes.
 	HardMode bool // hard mode doesn't use filters.
 	Ironman  bool // ironman only gets blocks, never requests txs.
+	// CompactFilters gets BIP158 filters and only the blocks that match them.
+	// Set by Start() for a cf:// host.
+	CompactFilters bool
+
+	// Birthday is when the wallet's keys were made, in unix time.  Blocks
+	// from well before then can't have anything of ours, so they aren't
+	// asked for.  0 to get them all.  Set before calling Start().
+	Birthday int64
 
 	headerMutex       sync.Mutex
 	headerFile        *os.File // file for SPV headers
@@ -32,6 +40,8 @@ type SPVCon struct {
 	// Using struct{} saves a byte of RAM but is ugly so I'll use bool.
 	TrackingAdrs    map[[20]byte]bool
 	TrackingAdrsMtx sync.Mutex
+	// taproot output keys; TrackingAdrsMtx covers these too
+	TrackingTapKeys map[[32]byte]bool
 
 	TrackingOPs    map[wire.OutPoint]bool
 	TrackingOPsMtx sync.Mutex
@@ -66,10 +76,32 @@ type SPVCon struct {
 	// If the above RawBlockSender chan isn't being pulled from, don't send to it
 	RawBlockActive bool
 
+	// MempoolSender gets every unconfirmed tx we hear about, once asked for
+	// with MempoolTxs.  If it's full, txs are dropped rather than hold up
+	// the wallit.
+	MempoolSender chan *wire.MsgTx
+
 	// for internal use -------------------------
 
 	// mBlockQueue is for keeping track of what height we've requested.
 	blockQueue chan HashAndHeight
+	// cfilterChan, cfheadersChan and cfBlockChan hand filters, their hashes,
+	// and the blocks that match them, from the message handler to
+	// filterBlocks().
+	cfilterChan   chan *msgCFilter
+	cfheadersChan chan *msgCFHeaders
+	cfBlockChan   chan *wire.MsgBlock
+	// filter headers of the blocks filters were checked for
+	cfHeaderFile *os.File
+	// scripts of watched outpoints, for matching filters.  Covered by
+	// TrackingOPsMtx, and kept in cfScriptFile.
+	opScripts    map[wire.OutPoint][]byte
+	cfScriptFile *os.File
+	// a targeted rescan matches filters against just rescanScripts, up to
+	// rescanTo; 0 when there's none.  Covered by TrackingAdrsMtx.
+	rescanScripts [][]byte
+	rescanTo      int32
+
 	// fPositives is a channel to keep track of bloom filter false positives.
 	fPositives chan int32
 
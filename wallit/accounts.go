@@ -0,0 +1,121 @@
+package wallit
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Accounts split up the wallit's own coins, like a routing float apart from
+savings.  Each has its own branch of keys (see GetAcctKeygen), and a send
+from an account only spends that account's utxos, with change back to it.
+Account 0 is the default, which is everything from before accounts, and
+channel funding.  Utxos not from a wallet key, like channel close outputs,
+are in account 0 too.
+
+account serialization, the value in BKTAccts:
+4	number of keys made
+	the rest is the name
+*/
+
+// DefaultAcctName is what account 0 is called.
+const DefaultAcctName = "default"
+
+// NewAccount makes a named account, and its first address.  Returns the
+// account number.
+func (w *Wallit) NewAccount(name string) (uint32, error) {
+	if name == "" || name == DefaultAcctName {
+		return 0, fmt.Errorf("can't make an account called %q", name)
+	}
+
+	var acct uint32
+	err := w.StateDB.Update(func(btx kvdb.Tx) error {
+		actb := btx.Bucket(BKTAccts)
+		err := actb.ForEach(func(k, v []byte) error {
+			if string(v[4:]) == name {
+				return fmt.Errorf("already have account %s", name)
+			}
+			acct = lnutil.BtU32(k)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		acct++
+		if acct >= 1<<31 {
+			return fmt.Errorf("out of accounts")
+		}
+		return actb.Put(lnutil.U32tB(acct), append(lnutil.U32tB(0), name...))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.NewAcctAdr160(acct)
+	if err != nil {
+		return 0, err
+	}
+	log.Tracef("made account %d, %s\n", acct, name)
+	return acct, nil
+}
+
+// Accounts gives the names of the accounts; the account number is the
+// index, so the first is DefaultAcctName.
+func (w *Wallit) Accounts() ([]string, error) {
+	names := []string{DefaultAcctName}
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTAccts).ForEach(func(k, v []byte) error {
+			names = append(names, string(v[4:]))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// UtxoAcct says which account a utxo is in.
+func UtxoAcct(u *portxo.PorTxo) uint32 {
+	if u.KeyGen.Depth != 5 || u.KeyGen.Step[2] != 0|1<<31 {
+		return 0
+	}
+	return u.KeyGen.Step[3] &^ (1 << 31)
+}
+
+// acctNumKeys gives how many addresses an account has made.  Account 0's
+// count is in the state bucket, where it always was.
+func acctNumKeys(btx kvdb.Tx, acct uint32) (uint32, error) {
+	if acct == 0 {
+		sta := btx.Bucket(BKTState)
+		if sta == nil {
+			return 0, fmt.Errorf("no state bucket")
+		}
+		return lnutil.BtU32(sta.Get(KEYNumKeys)), nil
+	}
+	v := btx.Bucket(BKTAccts).Get(lnutil.U32tB(acct))
+	if len(v) < 4 {
+		return 0, fmt.Errorf("no account %d", acct)
+	}
+	return lnutil.BtU32(v[:4]), nil
+}
+
+// setAcctNumKeys writes how many addresses an account has made.
+func setAcctNumKeys(btx kvdb.Tx, acct, n uint32) error {
+	if acct == 0 {
+		sta := btx.Bucket(BKTState)
+		if sta == nil {
+			return fmt.Errorf("no state bucket")
+		}
+		return sta.Put(KEYNumKeys, lnutil.U32tB(n))
+	}
+	actb := btx.Bucket(BKTAccts)
+	v := actb.Get(lnutil.U32tB(acct))
+	if len(v) < 4 {
+		return fmt.Errorf("no account %d", acct)
+	}
+	return actb.Put(lnutil.U32tB(acct), append(lnutil.U32tB(n), v[4:]...))
+}
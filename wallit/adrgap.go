@@ -0,0 +1,144 @@
+package wallit
+
+import (
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Addresses are handed out in order, and never twice.  Past the last one
+handed out in each account, the wallit also watches adrGap more, so that
+when a key is restored, coins sent to addresses it hasn't made yet still
+show up.  When one of those is paid, everything up to it counts as handed
+out, and the window moves up past it.  That keeps going, so a restore finds
+coins as long as no adrGap addresses in a row went unpaid.
+
+Paid addresses are kept in BKTUsedAdr, so they can be told apart from ones
+it's still fine to give out.
+
+used address serialization, in BKTUsedAdr:
+key: 4 account, 4 index
+value: 4 height first paid at (0 if unconfirmed)
+*/
+
+// adrGap is how many addresses past the last handed out to watch.
+const adrGap = 20
+
+// extendAdrs watches an account's addresses from index from up to (not
+// including) to.  Ones already in the adr bucket just get registered with
+// the chainhook again.
+func (w *Wallit) extendAdrs(btx kvdb.Tx, acct, from, to uint32) error {
+	adrb := btx.Bucket(BKTadr)
+	for i := from; i < to; i++ {
+		kg := GetAcctKeygen(acct, i, w.Param.HDCoinType)
+		adr160 := w.PathPubHash160(kg)
+		tapKey := w.PathTaprootKey(kg)
+		if adrb.Get(adr160[:]) == nil {
+			err := adrb.Put(adr160[:], kg.Bytes())
+			if err != nil {
+				return err
+			}
+			err = adrb.Put(tapKey[:], kg.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+		err := w.Hook.RegisterAddress(adr160)
+		if err != nil {
+			return err
+		}
+		err = w.Hook.RegisterTaprootKey(tapKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchAdrGaps watches the adrGap addresses past the last handed out, in
+// every account.  Run at startup.
+func (w *Wallit) watchAdrGaps() error {
+	accts, err := w.Accounts()
+	if err != nil {
+		return err
+	}
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
+		for acct := uint32(0); acct < uint32(len(accts)); acct++ {
+			n, err := acctNumKeys(btx, acct)
+			if err != nil {
+				return err
+			}
+			err = w.extendAdrs(btx, acct, n, n+adrGap)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// useAdr marks the address with keygen kgBytes as paid, at height.  If
+// it's past the last one handed out, that moves up to it, and the window
+// of watched addresses with it.  Keys that aren't account addresses, like
+// ones from AddPorTxoAdr, are left alone.
+func (w *Wallit) useAdr(btx kvdb.Tx, kgBytes []byte, height int32) error {
+	if len(kgBytes) != 53 {
+		return nil
+	}
+	var kgArr [53]byte
+	copy(kgArr[:], kgBytes)
+	kg := portxo.KeyGenFromBytes(kgArr)
+	if kg.Depth != 5 || kg.Step[2] != 0|1<<31 {
+		return nil
+	}
+	acct := kg.Step[3] &^ (1 << 31)
+	idx := kg.Step[4] &^ (1 << 31)
+
+	usedb := btx.Bucket(BKTUsedAdr)
+	key := append(lnutil.U32tB(acct), lnutil.U32tB(idx)...)
+	old := usedb.Get(key)
+	if old == nil || lnutil.BtI32(old) == 0 {
+		err := usedb.Put(key, lnutil.I32tB(height))
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := acctNumKeys(btx, acct)
+	if err != nil {
+		// account from a restored key that hasn't been made again here
+		return nil
+	}
+	if idx < n {
+		return nil
+	}
+	log.Infof("account %d adr %d paid; handing out from %d\n", acct, idx, idx+1)
+	err = setAcctNumKeys(btx, acct, idx+1)
+	if err != nil {
+		return err
+	}
+	return w.extendAdrs(btx, acct, n+adrGap, idx+1+adrGap)
+}
+
+// AdrUsed says if one of the wallit's addresses has been paid.
+func (w *Wallit) AdrUsed(adr160 [20]byte) bool {
+	var used bool
+	_ = w.StateDB.View(func(btx kvdb.Tx) error {
+		kgBytes := btx.Bucket(BKTadr).Get(adr160[:])
+		if len(kgBytes) != 53 {
+			return nil
+		}
+		var kgArr [53]byte
+		copy(kgArr[:], kgBytes)
+		kg := portxo.KeyGenFromBytes(kgArr)
+		if kg.Depth != 5 || kg.Step[2] != 0|1<<31 {
+			return nil
+		}
+		key := append(lnutil.U32tB(kg.Step[3]&^(1<<31)),
+			lnutil.U32tB(kg.Step[4]&^(1<<31))...)
+		used = btx.Bucket(BKTUsedAdr).Get(key) != nil
+		return nil
+	})
+	return used
+}
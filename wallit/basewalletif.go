@@ -2,16 +2,16 @@ package wallit
 
 import (
 	"fmt"
-	"log"
 	"sort"
 
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/chainhook"
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
-	"github.com/mit-dci/lit/uspv"
 )
 
 /*
@@ -48,7 +48,7 @@ func (w *Wallit) GetPub(k portxo.KeyGen) *btcec.PublicKey {
 }
 
 func (w *Wallit) PushTx(tx *wire.MsgTx) error {
-	return w.Hook.PushTx(tx)
+	return w.broadcast(tx)
 }
 
 func (w *Wallit) Params() *coinparam.Params {
@@ -60,10 +60,15 @@ func (w *Wallit) LetMeKnow() chan lnutil.OutPointEvent {
 	return w.OPEventChan
 }
 
+func (w *Wallit) LetMeKnowHeight() chan lnutil.HeightEvent {
+	w.HeightEventChan = make(chan lnutil.HeightEvent, 1)
+	return w.HeightEventChan
+}
+
 func (w *Wallit) CurrentHeight() int32 {
 	h, err := w.GetDBSyncHeight()
 	if err != nil {
-		log.Printf("can't get height from db...")
+		log.Warnf("can't get height from db...")
 		return -99
 	}
 	return h
@@ -73,10 +78,14 @@ func (w *Wallit) NewAdr() ([20]byte, error) {
 	return w.NewAdr160()
 }
 
-func (w *Wallit) ExportHook() uspv.ChainHook {
+func (w *Wallit) ExportHook() chainhook.ChainHook {
 	return w.Hook
 }
 
+func (w *Wallit) WalletDB() kvdb.DB {
+	return w.StateDB
+}
+
 // ExportUtxo is really *IM*port utxo on this side.
 // Not implemented yet.  Fix "ingest many" at the same time eh?
 func (w *Wallit) ExportUtxo(u *portxo.PorTxo) {
@@ -85,12 +94,12 @@ func (w *Wallit) ExportUtxo(u *portxo.PorTxo) {
 	if u.Value == 0 {
 		err := w.AddPorTxoAdr(u.KeyGen)
 		if err != nil {
-			log.Printf(err.Error())
+			log.Errorf("%s\n", err.Error())
 		}
 	} else {
 		err := w.GainUtxo(*u)
 		if err != nil {
-			log.Printf(err.Error())
+			log.Errorf("%s\n", err.Error())
 		}
 	}
 
@@ -98,7 +107,11 @@ func (w *Wallit) ExportUtxo(u *portxo.PorTxo) {
 	adr160 := w.PathPubHash160(u.KeyGen)
 	err := w.Hook.RegisterAddress(adr160)
 	if err != nil {
-		log.Printf(err.Error())
+		log.Errorf("%s\n", err.Error())
+	}
+	err = w.Hook.RegisterTaprootKey(w.PathTaprootKey(u.KeyGen))
+	if err != nil {
+		log.Errorf("%s\n", err.Error())
 	}
 }
 
@@ -121,13 +134,93 @@ func (w *Wallit) WatchThis(op wire.OutPoint) error {
 	return nil
 }
 
+// WatchScript watches an outpoint like WatchThis, with the script it's locked
+// with, if known, for hooks that need it.  Those won't take an outpoint
+// without one.  Says if it wasn't watched already; if it was, by the LN
+// layer or as a utxo, it's left as it was.
+func (w *Wallit) WatchScript(op wire.OutPoint, pkScript []byte) (bool, error) {
+	sh, ok := w.Hook.(chainhook.ScriptHook)
+	if ok && len(pkScript) == 0 && sh.NeedScripts() {
+		return false, fmt.Errorf("can't watch %s without its script", op.String())
+	}
+
+	opArr := lnutil.OutPointToBytes(op)
+	var added bool
+	err := w.StateDB.Update(func(btx kvdb.Tx) error {
+		dufb := btx.Bucket(BKToutpoint)
+		if dufb == nil {
+			return fmt.Errorf("watch bucket not in db")
+		}
+		if dufb.Get(opArr[:]) != nil {
+			return nil
+		}
+		added = true
+		return dufb.Put(opArr[:], nil)
+	})
+	if err != nil || !added {
+		return false, err
+	}
+
+	if ok && len(pkScript) != 0 {
+		err = sh.RegisterOutPointScript(op, pkScript)
+	} else {
+		err = w.Hook.RegisterOutPoint(op)
+	}
+	return true, err
+}
+
+// Unwatch stops watching an outpoint from WatchScript.  Utxos stay.
+func (w *Wallit) Unwatch(op wire.OutPoint) error {
+	opArr := lnutil.OutPointToBytes(op)
+	var watched bool
+	err := w.StateDB.Update(func(btx kvdb.Tx) error {
+		dufb := btx.Bucket(BKToutpoint)
+		if dufb == nil {
+			return fmt.Errorf("watch bucket not in db")
+		}
+		v := dufb.Get(opArr[:])
+		if v == nil || len(v) != 0 {
+			return nil
+		}
+		watched = true
+		return dufb.Delete(opArr[:])
+	})
+	if err != nil || !watched {
+		return err
+	}
+
+	uh, ok := w.Hook.(chainhook.UnwatchHook)
+	if !ok {
+		return nil
+	}
+	return uh.UnregisterOutPoint(op)
+}
+
 func (w *Wallit) Fee() int64 {
-	return w.FeeRate
+	return w.FeeTarget(FeeTargetNormal)
 }
 
+// SetFee pins the fee rate.  0 goes back to estimates.
 func (w *Wallit) SetFee(set int64) int64 {
+	w.feeMtx.Lock()
 	w.FeeRate = set
-	return set
+	w.feeMtx.Unlock()
+	return w.Fee()
+}
+
+func (w *Wallit) SetFeeRPC(url string) error {
+	if url == "" {
+		w.SetFeeEstimator(nil)
+		return nil
+	}
+	e := NewRPCFeeEstimator(url)
+	_, err := e.EstimateFee(FeeTargetNormal)
+	if err != nil {
+		// not enough data yet is fine; it'll use the table till there is
+		log.Errorf("fee rpc %s: %s\n", w.Param.Name, err.Error())
+	}
+	w.SetFeeEstimator(e)
+	return nil
 }
 
 // ********* sweep is for testing / spamming, remove for real use
@@ -0,0 +1,193 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/adiabat/btcd/blockchain"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// BumpFee replaces an unconfirmed tx we sent with one paying feeRate (sat
+// per byte), taking the extra fee out of its change.  The original has to
+// signal BIP125 and spend only our utxos.  Returns the replacement's txid.
+func (w *Wallit) BumpFee(txid chainhash.Hash, feeRate int64) (*chainhash.Hash, error) {
+	feeRate, err := w.checkFeeRate(feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep sends from grabbing utxos while we move things around
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	tx := wire.NewMsgTx()
+	var ins []*portxo.PorTxo
+	changeIdx := -1
+	err = w.StateDB.View(func(btx kvdb.Tx) error {
+		txns := btx.Bucket(BKTTxns)
+		old := btx.Bucket(BKTStxos)
+		dufb := btx.Bucket(BKToutpoint)
+		adrb := btx.Bucket(BKTadr)
+
+		txBytes := txns.Get(txid[:])
+		if txBytes == nil {
+			return fmt.Errorf("no tx %s in wallet", txid.String())
+		}
+		err := tx.Deserialize(bytes.NewReader(txBytes))
+		if err != nil {
+			return err
+		}
+
+		rbf := false
+		for _, in := range tx.TxIn {
+			if in.Sequence < wire.MaxTxInSequenceNum-1 {
+				rbf = true
+			}
+			opBytes := lnutil.OutPointToBytes(in.PreviousOutPoint)
+			v := old.Get(opBytes[:])
+			if v == nil {
+				return fmt.Errorf("input %s isn't ours, can't re-sign it",
+					in.PreviousOutPoint.String())
+			}
+			st, err := StxoFromBytes(append(opBytes[:], v...))
+			if err != nil {
+				return err
+			}
+			if !st.SpendTxid.IsEqual(&txid) {
+				return fmt.Errorf("input %s spent by %s, not %s",
+					in.PreviousOutPoint.String(), st.SpendTxid.String(),
+					txid.String())
+			}
+			if st.SpendHeight != 0 {
+				return fmt.Errorf("%s confirmed at height %d",
+					txid.String(), st.SpendHeight)
+			}
+			ins = append(ins, &st.PorTxo)
+		}
+		if !rbf {
+			return fmt.Errorf("%s doesn't signal replaceability", txid.String())
+		}
+
+		var changeAmt int64
+		for i, out := range tx.TxOut {
+			// a watched outpoint with no portxo is a channel's; a new txid
+			// would leave the channel pointing at nothing
+			op := wire.OutPoint{Hash: txid, Index: uint32(i)}
+			opBytes := lnutil.OutPointToBytes(op)
+			v := dufb.Get(opBytes[:])
+			if v != nil && len(v) == 0 {
+				return fmt.Errorf("%s funds a channel, can't replace it",
+					txid.String())
+			}
+			// the biggest output to us is the change
+			if adrb.Get(lnutil.KeyHashFromPkScript(out.PkScript)) != nil &&
+				out.Value > changeAmt {
+				changeIdx = i
+				changeAmt = out.Value
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changeIdx == -1 {
+		return nil, fmt.Errorf("%s has no change output to take the fee from",
+			txid.String())
+	}
+
+	var inSum, outSum int64
+	for _, u := range ins {
+		inSum += u.Value
+	}
+	for _, out := range tx.TxOut {
+		outSum += out.Value
+	}
+	oldFee := inSum - outSum
+	vsize := blockchain.GetTxVirtualSize(btcutil.NewTx(tx))
+	newFee := feeRate * vsize
+	// the replacement has to pay for its own relay on top of the old fee
+	if newFee < oldFee+vsize {
+		return nil, fmt.Errorf("%s pays %d (%d sat/byte); %d sat/byte isn't enough more",
+			txid.String(), oldFee, oldFee/vsize, feeRate)
+	}
+
+	outs := make([]*wire.TxOut, len(tx.TxOut))
+	for i, out := range tx.TxOut {
+		outs[i] = wire.NewTxOut(out.Value, out.PkScript)
+	}
+	outs[changeIdx].Value -= newFee - oldFee
+	if outs[changeIdx].Value < w.Param.DustLimit {
+		return nil, fmt.Errorf("change %d too small to pay %d more fee",
+			tx.TxOut[changeIdx].Value, newFee-oldFee)
+	}
+
+	newTx, err := w.BuildAndSign(ins, outs, tx.LockTime)
+	if err != nil {
+		return nil, err
+	}
+	newTxid := newTx.TxHash()
+	log.Debugf("replacing %s (fee %d) with %s (fee %d)\n",
+		txid.String(), oldFee, newTxid.String(), newFee)
+
+	_, err = w.Ingest(newTx, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = w.forgetReplaced(tx, newTxid)
+	if err != nil {
+		return nil, err
+	}
+	err = w.broadcast(newTx)
+	if err != nil {
+		return nil, err
+	}
+	return &newTxid, nil
+}
+
+// forgetReplaced updates the DB for a tx that's been replaced: its outputs
+// won't exist, and its inputs are spent by the replacement instead.
+func (w *Wallit) forgetReplaced(tx *wire.MsgTx, newTxid chainhash.Hash) error {
+	txid := tx.TxHash()
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
+		txns := btx.Bucket(BKTTxns)
+		old := btx.Bucket(BKTStxos)
+		dufb := btx.Bucket(BKToutpoint)
+
+		for i := range tx.TxOut {
+			opBytes := lnutil.OutPointToBytes(
+				wire.OutPoint{Hash: txid, Index: uint32(i)})
+			err := dufb.Delete(opBytes[:])
+			if err != nil {
+				return err
+			}
+		}
+		for _, in := range tx.TxIn {
+			opBytes := lnutil.OutPointToBytes(in.PreviousOutPoint)
+			v := old.Get(opBytes[:])
+			if v == nil {
+				continue
+			}
+			st, err := StxoFromBytes(append(opBytes[:], v...))
+			if err != nil {
+				return err
+			}
+			st.SpendTxid = newTxid
+			stxb, err := st.ToBytes()
+			if err != nil {
+				return err
+			}
+			err = old.Put(stxb[:36], stxb[36:])
+			if err != nil {
+				return err
+			}
+		}
+		return txns.Delete(txid[:])
+	})
+}
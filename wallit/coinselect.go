@@ -0,0 +1,312 @@
+package wallit
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/consts"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// Coin selection strategies.  Set per wallet with SetCoinSelect, and can be
+// overridden for a single send.
+const (
+	// smallest utxos that are enough, avoiding big ones when we can; tends
+	// to make 2 in 2 out.  What lit's always done.
+	CoinSelectDefault = "default"
+	// biggest utxos first, for the fewest inputs
+	CoinSelectLargest = "largest"
+	// branch and bound search for utxos adding up to the amount and fee
+	// closely enough that there's no change output.  Falls back to default.
+	CoinSelectBnB = "bnb"
+	// utxos in random order, so spends don't show the wallet's habits
+	CoinSelectRandom = "random"
+)
+
+// CoinSelectStrategies lists the coin selection strategies.
+var CoinSelectStrategies = []string{
+	CoinSelectDefault, CoinSelectLargest, CoinSelectBnB, CoinSelectRandom}
+
+// most branches the bnb search goes down before giving up
+const maxBnBTries = 100000
+
+// vsize of a change output, and of spending it later.  A changeless tx can
+// overshoot by what those would have cost.
+const changeOutSize = 30
+const changeSpendSize = 68
+
+// CoinSelect returns the wallet's coin selection strategy.
+func (w *Wallit) CoinSelect() string {
+	if w.CoinSelectMode == "" {
+		return CoinSelectDefault
+	}
+	return w.CoinSelectMode
+}
+
+// SetCoinSelect changes the wallet's coin selection strategy.
+func (w *Wallit) SetCoinSelect(strategy string) error {
+	for _, s := range CoinSelectStrategies {
+		if s == strategy {
+			w.CoinSelectMode = strategy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown coin selection %q; options are %v",
+		strategy, CoinSelectStrategies)
+}
+
+// spendableUtxos filters out utxos we can't spend now: immature, empty, and
+// non-witness if ow is set.  Keeps the order.
+func spendableUtxos(
+	utxos portxo.TxoSliceByAmt, curHeight int32, ow bool) portxo.TxoSliceByAmt {
+	var spendable portxo.TxoSliceByAmt
+	for _, utxo := range utxos {
+		// skip unconfirmed.  Or de-prioritize? Some option for this...
+		//		if utxo.AtHeight == 0 {
+		//			continue
+		//		}
+		if !utxo.Mature(curHeight) {
+			continue // skip immature or unconfirmed time-locked sh outputs
+		}
+		if ow && utxo.Mode&portxo.FlagTxoWitness == 0 {
+			continue // skip non-witness
+		}
+		// why are 0-value outputs a thing..?
+		if utxo.Value < 1 {
+			continue
+		}
+		spendable = append(spendable, utxo)
+	}
+	return spendable
+}
+
+// checkOurs errors if any of ops isn't in utxos.
+func checkOurs(utxos portxo.TxoSliceByAmt, ops []wire.OutPoint) error {
+	have := make(map[wire.OutPoint]bool)
+	for _, u := range utxos {
+		have[u.Op] = true
+	}
+	for _, op := range ops {
+		if !have[op] {
+			return fmt.Errorf("%s isn't a utxo of ours", op.String())
+		}
+	}
+	return nil
+}
+
+// pickManual spends exactly the utxos in use, for manual coin control.
+// They have to be in utxos (so not frozen, locked or avoided), spendable
+// now, and enough for amtWanted and the fee.  Returns the overshoot.
+func pickManual(utxos portxo.TxoSliceByAmt, curHeight int32,
+	use []wire.OutPoint, amtWanted, outputByteSize, feePerByte int64,
+	ow bool) (portxo.TxoSliceByBip69, int64, error) {
+
+	byOp := make(map[wire.OutPoint]*portxo.PorTxo)
+	for _, u := range spendableUtxos(utxos, curHeight, ow) {
+		byOp[u.Op] = u
+	}
+
+	var rSlice portxo.TxoSliceByBip69
+	var inSum int64
+	for _, op := range use {
+		u, ok := byOp[op]
+		if !ok {
+			return nil, 0, fmt.Errorf(
+				"%s is frozen, locked, avoided or can't be spent now", op.String())
+		}
+		// so the same one can't be added twice
+		delete(byOp, op)
+		rSlice = append(rSlice, u)
+		inSum += u.Value
+	}
+
+	overshoot := inSum - amtWanted - EstFee(rSlice, outputByteSize, feePerByte)
+	if overshoot < 0 {
+		return nil, 0, fmt.Errorf("inputs given have %d, %d short of amount and fee",
+			inSum, -overshoot)
+	}
+	sort.Sort(rSlice)
+	return rSlice, overshoot, nil
+}
+
+// accumulate adds utxos in order until there's enough for amtWanted and the
+// fee.  Returns the utxos and what's still needed, which is negative (the
+// overshoot) if there was enough.
+func accumulate(utxos portxo.TxoSliceByAmt,
+	amtWanted, outputByteSize, feePerByte int64) (portxo.TxoSliceByBip69, int64) {
+
+	// rSlice is the return slice of the utxos which are going into the tx
+	var rSlice portxo.TxoSliceByBip69
+	// add utxos until we've had enough
+	remaining := amtWanted // remaining is how much is needed on input side
+	for _, utxo := range utxos {
+		// yeah, lets add this utxo!
+		rSlice = append(rSlice, utxo)
+		remaining -= utxo.Value
+		// if remaining is positive, don't bother checking fee yet.
+		// if remaining is negative, calculate needed fee
+		if remaining <= 0 {
+			fee := EstFee(rSlice, outputByteSize, feePerByte)
+			// subtract fee from returned overshoot.
+			// (remaining is negative here)
+			remaining += fee
+
+			// done adding utxos if remaining below negative est fee
+			if remaining < -fee {
+				break
+			}
+		}
+	}
+	return rSlice, remaining
+}
+
+// pickSmallEnough is the default coin selection.
+func pickSmallEnough(allUtxos portxo.TxoSliceByAmt, curHeight int32,
+	amtWanted, outputByteSize, feePerByte int64,
+	ow bool) (portxo.TxoSliceByBip69, int64) {
+
+	// start with utxos sorted by value and pop off utxos which are greater
+	// than the send amount... as long as the next 2 are greater.
+	// simple / straightforward coin selection optimization, which tends to make
+	// 2 in 2 out
+
+	// smallest and unconfirmed last (because it's reversed)
+	sort.Sort(sort.Reverse(allUtxos))
+
+	// guessing that txs won't be more than 10K here...
+	maxFeeGuess := feePerByte * consts.MaxTxCount
+
+	// first pass of removing candidate utxos; if the next one is bigger than
+	// we need, remove the top one.
+	for len(allUtxos) > 1 &&
+		allUtxos[1].Value > amtWanted+maxFeeGuess &&
+		allUtxos[1].Height > 100 &&
+		!(ow && allUtxos[1].Mode&portxo.FlagTxoWitness == 0) {
+		allUtxos = allUtxos[1:]
+	}
+
+	// if we've got 2 or more confirmed utxos, and the next one is
+	// more than enough, pop off the first one.
+	// Note that there are probably all sorts of edge cases where this will
+	// result in not being able to send money when you should be able to.
+	// Thus the handwavey "maxFeeGuess"
+	for len(allUtxos) > 2 &&
+		allUtxos[2].Height > 100 && // since sorted, don't need to check [1]
+		allUtxos[1].Mature(curHeight) &&
+		allUtxos[2].Mature(curHeight) &&
+		allUtxos[1].Value+allUtxos[2].Value > amtWanted+maxFeeGuess &&
+		!(ow && allUtxos[2].Mode&portxo.FlagTxoWitness == 0) &&
+		!(ow && allUtxos[1].Mode&portxo.FlagTxoWitness == 0) {
+		log.Debugf("remaining utxo list, in order:\n")
+		for _, u := range allUtxos {
+			log.Tracef("\t h: %d amt: %d\n", u.Height, u.Value)
+		}
+		allUtxos = allUtxos[1:]
+	}
+
+	return accumulate(spendableUtxos(allUtxos, curHeight, ow),
+		amtWanted, outputByteSize, feePerByte)
+}
+
+// pickChangeless looks for utxos which, after paying for themselves, add up
+// to between the amount plus the tx's base fee, and that plus what a change
+// output would cost.  Depth first, biggest first, keeping the closest set
+// found.  Returns nil if there's no such set (or it took too long to find).
+func pickChangeless(utxos portxo.TxoSliceByAmt,
+	amtWanted, outputByteSize, feePerByte, dust int64) (portxo.TxoSliceByBip69, int64) {
+
+	// effective value is what a utxo's worth once its input is paid for
+	effVal := func(u *portxo.PorTxo) int64 {
+		return u.Value - u.EstSize()*feePerByte
+	}
+	var cands portxo.TxoSliceByAmt
+	for _, u := range utxos {
+		if effVal(u) > 0 {
+			cands = append(cands, u)
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		return effVal(cands[i]) > effVal(cands[j])
+	})
+
+	// same base size EstFee uses; has room for the change output we
+	// won't have, so there's a bit of slack
+	target := amtWanted + (40+outputByteSize)*feePerByte
+	window := (changeOutSize + changeSpendSize) * feePerByte
+	// past this, MaybeSend would make change anyway
+	if window > dust+changeOutSize*feePerByte {
+		window = dust + changeOutSize*feePerByte
+	}
+
+	// what's left to add from each position on, to prune hopeless branches
+	avail := make([]int64, len(cands)+1)
+	for i := len(cands) - 1; i >= 0; i-- {
+		avail[i] = avail[i+1] + effVal(cands[i])
+	}
+
+	picked := make([]bool, len(cands))
+	var best []bool
+	bestExcess := window + 1
+	tries := 0
+
+	// search returns true when we should stop: found an exact match or
+	// tried too many times
+	var search func(i int, sum int64) bool
+	search = func(i int, sum int64) bool {
+		tries++
+		if tries > maxBnBTries {
+			return true
+		}
+		if sum > target+window {
+			return false
+		}
+		if sum >= target {
+			if sum-target < bestExcess {
+				bestExcess = sum - target
+				best = append([]bool(nil), picked...)
+			}
+			return bestExcess == 0
+		}
+		if i == len(cands) || sum+avail[i] < target {
+			return false
+		}
+		picked[i] = true
+		if search(i+1, sum+effVal(cands[i])) {
+			return true
+		}
+		picked[i] = false
+		return search(i+1, sum)
+	}
+	search(0, 0)
+
+	if best == nil {
+		return nil, 0
+	}
+	var rSlice portxo.TxoSliceByBip69
+	for i, in := range best {
+		if in {
+			rSlice = append(rSlice, cands[i])
+		}
+	}
+	remaining := amtWanted - portxo.TxoSliceByAmt(rSlice).Sum() +
+		EstFee(rSlice, outputByteSize, feePerByte)
+	log.Debugf("bnb picked %d utxos, overshoot %d after %d tries\n",
+		len(rSlice), -remaining, tries)
+	return rSlice, remaining
+}
+
+// shuffleUtxos puts utxos in random order, with randomness nobody watching
+// the chain can guess.
+func shuffleUtxos(utxos portxo.TxoSliceByAmt) error {
+	for i := len(utxos) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return err
+		}
+		utxos[i], utxos[j.Int64()] = utxos[j.Int64()], utxos[i]
+	}
+	return nil
+}
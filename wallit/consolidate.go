@@ -0,0 +1,107 @@
+package wallit
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// autoConsolidateMin is how many small utxos there have to be before
+// they're consolidated automatically; not worth a tx every block otherwise.
+const autoConsolidateMin = 10
+
+// Consolidate spends every confirmed utxo in the default account worth less
+// than below to one new address of ours, at the wallet's fee rate.  If
+// maxFeeRate isn't 0 and the fee rate is higher, it doesn't.  Utxos worth
+// less than what it costs to spend them stay put, as do frozen and locked
+// ones.  Returns the txid.
+func (w *Wallit) Consolidate(below, maxFeeRate int64) (*chainhash.Hash, error) {
+	return w.consolidate(below, maxFeeRate, 2)
+}
+
+// consolidate is Consolidate, if there are at least minUtxos to spend.
+func (w *Wallit) consolidate(
+	below, maxFeeRate int64, minUtxos int) (*chainhash.Hash, error) {
+
+	feeRate := w.Fee()
+	if maxFeeRate != 0 && feeRate > maxFeeRate {
+		return nil, fmt.Errorf("fee rate %d over consolidation ceiling %d",
+			feeRate, maxFeeRate)
+	}
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	curHeight, err := w.GetDBSyncHeight()
+	if err != nil {
+		return nil, err
+	}
+	allUtxos, err := w.GetAllUtxos()
+	if err != nil {
+		return nil, err
+	}
+	locked, err := w.lockedOps()
+	if err != nil {
+		return nil, err
+	}
+
+	var utxos []*portxo.PorTxo
+	for _, u := range spendableUtxos(allUtxos, curHeight, false) {
+		_, frozen := w.FreezeSet[u.Op]
+		if u.Height < 1 || frozen || locked[u.Op] || UtxoAcct(u) != 0 ||
+			u.Value >= below || u.Value <= u.EstSize()*feeRate {
+			continue
+		}
+		utxos = append(utxos, u)
+	}
+	if len(utxos) < minUtxos {
+		return nil, fmt.Errorf("%d confirmed utxos under %d, need %d to consolidate",
+			len(utxos), below, minUtxos)
+	}
+
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("consolidating %d utxos under %d\n", len(utxos), below)
+	return w.sendAllTo(utxos, lnutil.DirectWPKHScriptFromPKH(adr160), feeRate)
+}
+
+// SetAutoConsolidate has the wallet consolidate utxos worth less than below
+// on its own, when a block comes in and the fee rate is at most maxFeeRate.
+// below of 0 turns it off.
+func (w *Wallit) SetAutoConsolidate(below, maxFeeRate int64) error {
+	if below < 0 || maxFeeRate < 0 {
+		return fmt.Errorf("can't consolidate under %d at fee rate %d",
+			below, maxFeeRate)
+	}
+	w.consolidateMtx.Lock()
+	w.consolidateBelow, w.consolidateMaxFee = below, maxFeeRate
+	w.consolidateMtx.Unlock()
+	return nil
+}
+
+// AutoConsolidate gives what SetAutoConsolidate set.
+func (w *Wallit) AutoConsolidate() (below, maxFeeRate int64) {
+	w.consolidateMtx.Lock()
+	defer w.consolidateMtx.Unlock()
+	return w.consolidateBelow, w.consolidateMaxFee
+}
+
+// maybeConsolidate runs on new blocks, consolidating if it's been set to
+// and there are enough small utxos.
+func (w *Wallit) maybeConsolidate() {
+	below, maxFeeRate := w.AutoConsolidate()
+	if below == 0 || (maxFeeRate != 0 && w.Fee() > maxFeeRate) {
+		return
+	}
+	txid, err := w.consolidate(below, maxFeeRate, autoConsolidateMin)
+	if err != nil {
+		// not enough small utxos yet, most of the time
+		log.Errorf("auto consolidate: %s\n", err.Error())
+		return
+	}
+	log.Infof("auto consolidated in tx %s\n", txid.String())
+}
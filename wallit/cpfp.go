@@ -0,0 +1,133 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/adiabat/btcd/blockchain"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// CpfpSweep spends one of our unconfirmed outputs to a new address of ours,
+// with enough fee that the parent and child together pay feeRate (sat per
+// byte), so miners take the parent to get the child.  Returns the child's
+// txid.
+func (w *Wallit) CpfpSweep(op wire.OutPoint, feeRate int64) (*chainhash.Hash, error) {
+	feeRate, err := w.checkFeeRate(feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+	_, frozen := w.FreezeSet[op]
+	if frozen {
+		return nil, fmt.Errorf("%s is frozen, can't spend", op.String())
+	}
+	locked, err := w.isLocked(op)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return nil, fmt.Errorf("%s is locked, can't spend", op.String())
+	}
+
+	var u *portxo.PorTxo
+	parent := wire.NewMsgTx()
+	// what the parent pays, if we know all its inputs
+	var parentFee int64
+	err = w.StateDB.View(func(btx kvdb.Tx) error {
+		dufb := btx.Bucket(BKToutpoint)
+		old := btx.Bucket(BKTStxos)
+		txns := btx.Bucket(BKTTxns)
+
+		opBytes := lnutil.OutPointToBytes(op)
+		v := dufb.Get(opBytes[:])
+		if len(v) == 0 {
+			return fmt.Errorf("%s isn't ours to spend", op.String())
+		}
+		var err error
+		u, err = portxo.PorTxoFromBytes(append(opBytes[:], v...))
+		if err != nil {
+			return err
+		}
+
+		txBytes := txns.Get(op.Hash[:])
+		if txBytes == nil {
+			return fmt.Errorf("no tx %s in wallet", op.Hash.String())
+		}
+		err = parent.Deserialize(bytes.NewReader(txBytes))
+		if err != nil {
+			return err
+		}
+
+		for _, in := range parent.TxIn {
+			inBytes := lnutil.OutPointToBytes(in.PreviousOutPoint)
+			sv := old.Get(inBytes[:])
+			if sv == nil {
+				// somebody else's input; assume they paid nothing
+				parentFee = 0
+				return nil
+			}
+			st, err := StxoFromBytes(append(inBytes[:], sv...))
+			if err != nil {
+				return err
+			}
+			parentFee += st.Value
+		}
+		for _, out := range parent.TxOut {
+			parentFee -= out.Value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Height != 0 {
+		return nil, fmt.Errorf("%s already confirmed at height %d",
+			op.String(), u.Height)
+	}
+	if u.Seq > 1 {
+		return nil, fmt.Errorf("%s is time-locked, can't spend it unconfirmed",
+			op.String())
+	}
+
+	// the child's own fee, then enough more to bring the parent up
+	childFee := EstFee([]*portxo.PorTxo{u}, 0, feeRate)
+	parentSize := blockchain.GetTxVirtualSize(btcutil.NewTx(parent))
+	if parentFee < feeRate*parentSize {
+		childFee += feeRate*parentSize - parentFee
+	}
+	if u.Value-childFee < w.Param.DustLimit {
+		return nil, fmt.Errorf("%s has %d, not enough to pay %d fee",
+			op.String(), u.Value, childFee)
+	}
+
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return nil, err
+	}
+	txout := wire.NewTxOut(
+		u.Value-childFee, lnutil.DirectWPKHScriptFromPKH(adr160))
+
+	child, err := w.BuildAndSign(
+		[]*portxo.PorTxo{u}, []*wire.TxOut{txout}, uint32(w.CurrentHeight()))
+	if err != nil {
+		return nil, err
+	}
+	childTxid := child.TxHash()
+	log.Debugf("cpfp %s: parent pays %d, child %s pays %d\n",
+		op.String(), parentFee, childTxid.String(), childFee)
+
+	err = w.NewOutgoingTx(child)
+	if err != nil {
+		return nil, err
+	}
+	return &childTxid, nil
+}
@@ -4,13 +4,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 
 	"github.com/adiabat/btcd/blockchain"
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil"
-	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 	"github.com/mit-dci/lit/consts"
@@ -26,6 +25,29 @@ var (
 	BKTStxos = []byte("SpentTxs")  // for bookkeeping / not sure
 	BKTTxns  = []byte("Txns")      // all txs we care about, for replays
 	BKTState = []byte("MiscState") // misc states of DB
+	// utxos locked so they won't be picked for spending. k:op, v:reason
+	BKTLocks = []byte("Locks")
+	// notes on txs and outputs. k:txid or op, v:label
+	BKTLabels = []byte("Labels")
+	// named accounts, past the default one. k:account number, v:account
+	BKTAccts = []byte("Accounts")
+	// account addresses that have been paid. k:account, index, v:height
+	BKTUsedAdr = []byte("UsedAdr")
+
+	// xpubs watched without their keys. k:account number, v:account
+	BKTWatchAccts = []byte("WatchAccts")
+	// addresses of the watched xpubs. k:key hash, v:account, branch, index
+	BKTWatchAdr = []byte("WatchAdr")
+	// utxos of the watched xpubs. k:op, v:account, then the rest of the portxo
+	BKTWatchTxos = []byte("WatchTxos")
+	// spent utxos of the watched xpubs. k:op, v:spending txid
+	BKTWatchStxos = []byte("WatchStxos")
+
+	// txs we sent out, till they're settled. k:txid, v:status, then the tx
+	BKTBroadcast = []byte("Broadcast")
+
+	// outpoints spent by txs a reorg took out. k:op, v:txid of the reorged tx
+	BKTReorged = []byte("Reorged")
 
 	//	BKTWatch = []byte("watch") // outpoints we're watching for someone else
 	// these are in the state bucket
@@ -37,51 +59,102 @@ var (
 // make a new change output.  I guess this is supposed to be on a different
 // branch than regular addresses...
 func (w *Wallit) NewChangeOut(amt int64) (*wire.TxOut, error) {
-	change160, err := w.NewAdr160() // change is always witnessy
+	return w.NewAcctChangeOut(0, amt)
+}
+
+// NewAcctChangeOut makes a change output to an account.
+func (w *Wallit) NewAcctChangeOut(acct uint32, amt int64) (*wire.TxOut, error) {
+	return w.newSpecChangeOut(&lnutil.ChangeSpec{Acct: acct}, amt)
+}
+
+// newSpecChangeOut makes a change output where a ChangeSpec says.
+func (w *Wallit) newSpecChangeOut(
+	spec *lnutil.ChangeSpec, amt int64) (*wire.TxOut, error) {
+	if spec.Script != nil {
+		return wire.NewTxOut(amt, spec.Script), nil
+	}
+
+	change160, err := w.NewAcctAdr160(spec.Acct)
 	if err != nil {
 		return nil, err
 	}
 
-	changeScript := lnutil.DirectWPKHScriptFromPKH(change160)
+	var changeScript []byte
+	switch spec.AdrType {
+	case "", lnutil.AdrTypeBech32:
+		changeScript = lnutil.DirectWPKHScriptFromPKH(change160)
+	case lnutil.AdrTypeTaproot:
+		tapKey, err := w.TaprootKeyForAdr(change160)
+		if err != nil {
+			return nil, err
+		}
+		changeScript = lnutil.P2TRScript(tapKey)
+	case lnutil.AdrTypeLegacy:
+		changeScript, err = lnutil.PayToPubKeyHashScript(change160[:])
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("change type %s; expect %s, %s or %s",
+			spec.AdrType, lnutil.AdrTypeBech32, lnutil.AdrTypeLegacy,
+			lnutil.AdrTypeTaproot)
+	}
+	return wire.NewTxOut(amt, changeScript), nil
+}
 
-	changeOut := wire.NewTxOut(amt, changeScript)
-	return changeOut, nil
+// specChangeSize is how many bytes a ChangeSpec's output adds to a tx.
+func specChangeSize(spec *lnutil.ChangeSpec) int64 {
+	if spec.Script != nil {
+		return 9 + int64(len(spec.Script))
+	}
+	switch spec.AdrType {
+	case lnutil.AdrTypeTaproot:
+		return 43
+	case lnutil.AdrTypeLegacy:
+		return 34
+	}
+	return 31
 }
 
 // AddPorTxoAdr adds an externally sourced address to the db.  Looks at the keygen
 // to derive hash160.
 func (w *Wallit) AddPorTxoAdr(kg portxo.KeyGen) error {
 	// write to db file
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
 		adrb := btx.Bucket(BKTadr)
 		if adrb == nil {
 			return fmt.Errorf("no adr bucket")
 		}
 
 		adr160 := w.PathPubHash160(kg)
-		log.Printf("adding addr %x\n", adr160)
+		log.Debugf("adding addr %x\n", adr160)
 		// add the 20-byte key-hash into the db
-		return adrb.Put(adr160[:], kg.Bytes())
+		err := adrb.Put(adr160[:], kg.Bytes())
+		if err != nil {
+			return err
+		}
+		// and the taproot output key for the same pubkey
+		tapKey := w.PathTaprootKey(kg)
+		return adrb.Put(tapKey[:], kg.Bytes())
 	})
 }
 
-// AdrDump returns all the addresses in the wallit.
+// AdrDump returns all the addresses in the wallit's default account.
 // currently returns 20 byte arrays, which
 // can then be converted somewhere else into bech32 addresses (or old base58)
 func (w *Wallit) AdrDump() ([][20]byte, error) {
+	return w.AcctAdrDump(0)
+}
+
+// AcctAdrDump returns all the addresses in an account.
+func (w *Wallit) AcctAdrDump(acct uint32) ([][20]byte, error) {
 	var i, last uint32 // number of addresses made so far
 	var adrSlice [][20]byte
 
-	err := w.StateDB.View(func(btx *bolt.Tx) error {
-		sta := btx.Bucket(BKTState)
-		if sta == nil {
-			return fmt.Errorf("no state bucket")
-		}
-
-		oldNBytes := sta.Get(KEYNumKeys)
-		last = lnutil.BtU32(oldNBytes)
-		// update the db with number of created keys
-		return nil
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		var err error
+		last, err = acctNumKeys(btx, acct)
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -94,7 +167,7 @@ func (w *Wallit) AdrDump() ([][20]byte, error) {
 	// TODO: maybe store address hashes instead of recomputing them
 	// can speed things up a lot here, at a pretty small disk cost
 	for i = 0; i < last; i++ {
-		nKg := GetWalletKeygen(i, w.Param.HDCoinType)
+		nKg := GetAcctKeygen(acct, i, w.Param.HDCoinType)
 		nAdr160 := w.PathPubHash160(nKg)
 
 		adrSlice = append(adrSlice, nAdr160)
@@ -102,9 +175,80 @@ func (w *Wallit) AdrDump() ([][20]byte, error) {
 	return adrSlice, nil
 }
 
+// TaprootAdrDump returns the taproot output keys for all the addresses in
+// the wallit, in the same order as AdrDump.  Any that aren't in the adr
+// bucket yet (keys made before the wallit knew about taproot) get put in,
+// so that ingest picks up outputs paying them.
+func (w *Wallit) TaprootAdrDump() ([][32]byte, error) {
+	var tapSlice [][32]byte
+
+	err := w.StateDB.Update(func(btx kvdb.Tx) error {
+		sta := btx.Bucket(BKTState)
+		if sta == nil {
+			return fmt.Errorf("no state bucket")
+		}
+		adrb := btx.Bucket(BKTadr)
+		if adrb == nil {
+			return fmt.Errorf("no adr bucket")
+		}
+
+		last := lnutil.BtU32(sta.Get(KEYNumKeys))
+		if last > consts.MaxKeys {
+			return fmt.Errorf("Got %d keys stored, expect something reasonable", last)
+		}
+
+		for i := uint32(0); i < last; i++ {
+			nKg := GetWalletKeygen(i, w.Param.HDCoinType)
+			tapKey := w.PathTaprootKey(nKg)
+			if adrb.Get(tapKey[:]) == nil {
+				err := adrb.Put(tapKey[:], nKg.Bytes())
+				if err != nil {
+					return err
+				}
+			}
+			tapSlice = append(tapSlice, tapKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tapSlice, nil
+}
+
+// TaprootKeyForAdr gives the taproot output key for the same pubkey as one
+// of the wallit's 20 byte addresses.
+func (w *Wallit) TaprootKeyForAdr(adr160 [20]byte) ([32]byte, error) {
+	var tapKey [32]byte
+	var kgBytes []byte
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		adrb := btx.Bucket(BKTadr)
+		if adrb == nil {
+			return fmt.Errorf("no adr bucket")
+		}
+		kgBytes = adrb.Get(adr160[:])
+		if len(kgBytes) != 53 {
+			return fmt.Errorf("address %x not in wallit", adr160)
+		}
+		return nil
+	})
+	if err != nil {
+		return tapKey, err
+	}
+
+	var kgArr [53]byte
+	copy(kgArr[:], kgBytes)
+	return w.PathTaprootKey(portxo.KeyGenFromBytes(kgArr)), nil
+}
+
 // NewAdr creates a new, never before seen address, and increments the
 // DB counter, and returns the hash160 of the pubkey.
 func (w *Wallit) NewAdr160() ([20]byte, error) {
+	return w.NewAcctAdr160(0)
+}
+
+// NewAcctAdr160 is NewAdr160 for an account.
+func (w *Wallit) NewAcctAdr160(acct uint32) ([20]byte, error) {
 	var err error
 	var empty160 [20]byte
 	if w.Param == nil {
@@ -113,53 +257,54 @@ func (w *Wallit) NewAdr160() ([20]byte, error) {
 
 	var n uint32 // number of addresses made so far
 
-	err = w.StateDB.View(func(btx *bolt.Tx) error {
-		sta := btx.Bucket(BKTState)
-		if sta == nil {
-			return fmt.Errorf("no state bucket")
-		}
-
-		oldNBytes := sta.Get(KEYNumKeys)
-		n = lnutil.BtU32(oldNBytes)
-		// update the db with number of created keys
-		return nil
+	err = w.StateDB.View(func(btx kvdb.Tx) error {
+		var err error
+		n, err = acctNumKeys(btx, acct)
+		return err
 	})
+	if err != nil {
+		return empty160, err
+	}
 	if n > 1<<30 {
 		return empty160, fmt.Errorf("Got %d keys stored, expect something reasonable", n)
 	}
 
-	nKg := GetWalletKeygen(n, w.Param.HDCoinType)
+	nKg := GetAcctKeygen(acct, n, w.Param.HDCoinType)
 	nAdr160 := w.PathPubHash160(nKg)
+	nTapKey := w.PathTaprootKey(nKg)
 
 	if nAdr160 == empty160 {
 		return empty160, fmt.Errorf("NewAdr error: got nil h160")
 	}
-	log.Printf("adr %d hash is %x\n", n, nAdr160)
+	log.Tracef("account %d adr %d hash is %x\n", acct, n, nAdr160)
 
 	kgBytes := nKg.Bytes()
 
-	// total number of keys (now +1) into 4 bytes
-	nKeyNumBytes := lnutil.U32tB(n + 1)
-
 	// write to db file
-	err = w.StateDB.Update(func(btx *bolt.Tx) error {
+	err = w.StateDB.Update(func(btx kvdb.Tx) error {
 		adrb := btx.Bucket(BKTadr)
 		if adrb == nil {
 			return fmt.Errorf("no adr bucket")
 		}
-		sta := btx.Bucket(BKTState)
-		if sta == nil {
-			return fmt.Errorf("no state bucket")
-		}
 
 		// add the 20-byte key-hash into the db
 		err = adrb.Put(nAdr160[:], kgBytes)
 		if err != nil {
 			return err
 		}
+		// and the 32-byte taproot key; same pubkey, so same keygen
+		err = adrb.Put(nTapKey[:], kgBytes)
+		if err != nil {
+			return err
+		}
 
 		// update the db with number of created keys
-		return sta.Put(KEYNumKeys, nKeyNumBytes)
+		err = setAcctNumKeys(btx, acct, n+1)
+		if err != nil {
+			return err
+		}
+		// and keep watching adrGap past it
+		return w.extendAdrs(btx, acct, n+1, n+1+adrGap)
 	})
 	if err != nil {
 		return empty160, err
@@ -169,6 +314,10 @@ func (w *Wallit) NewAdr160() ([20]byte, error) {
 	if err != nil {
 		return empty160, err
 	}
+	err = w.Hook.RegisterTaprootKey(nTapKey)
+	if err != nil {
+		return empty160, err
+	}
 
 	return nAdr160, nil
 }
@@ -179,7 +328,7 @@ func (w *Wallit) SetDBSyncHeight(n int32) error {
 	var buf bytes.Buffer
 	_ = binary.Write(&buf, binary.BigEndian, n)
 
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
 		sta := btx.Bucket(BKTState)
 		return sta.Put(KEYTipHeight, buf.Bytes())
 	})
@@ -188,7 +337,7 @@ func (w *Wallit) SetDBSyncHeight(n int32) error {
 // SyncHeight returns the chain height to which the db has synced
 func (w *Wallit) GetDBSyncHeight() (int32, error) {
 	var n int32
-	err := w.StateDB.View(func(btx *bolt.Tx) error {
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
 		sta := btx.Bucket(BKTState)
 		if sta == nil {
 			return fmt.Errorf("no state")
@@ -215,7 +364,7 @@ func (w *Wallit) GetDBSyncHeight() (int32, error) {
 // SaveTx unconditionally saves a tx in the DB, usually for sending out to nodes
 func (w *Wallit) SaveTx(tx *wire.MsgTx) error {
 	// open db
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
 		// get the outpoint watch bucket
 		txbkt := btx.Bucket(BKTTxns)
 		if txbkt == nil {
@@ -228,6 +377,23 @@ func (w *Wallit) SaveTx(tx *wire.MsgTx) error {
 	})
 }
 
+// GetSavedTx gets a tx out of the tx bucket: ours, or one with an outpoint
+// we watch.
+func (w *Wallit) GetSavedTx(txid *chainhash.Hash) (*wire.MsgTx, error) {
+	tx := wire.NewMsgTx()
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		txBytes := btx.Bucket(BKTTxns).Get(txid[:])
+		if txBytes == nil {
+			return fmt.Errorf("no tx %s in wallet", txid.String())
+		}
+		return tx.Deserialize(bytes.NewReader(txBytes))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
 func (w *Wallit) UtxoDump() ([]*portxo.PorTxo, error) {
 	return w.GetAllUtxos()
 }
@@ -236,7 +402,7 @@ func (w *Wallit) UtxoDump() ([]*portxo.PorTxo, error) {
 // Doesn't return watch only outpoints
 func (w *Wallit) GetAllUtxos() ([]*portxo.PorTxo, error) {
 	var utxos []*portxo.PorTxo
-	err := w.StateDB.View(func(btx *bolt.Tx) error {
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
 		dufb := btx.Bucket(BKToutpoint)
 		if dufb == nil {
 			return fmt.Errorf("no duffel bag")
@@ -245,7 +411,7 @@ func (w *Wallit) GetAllUtxos() ([]*portxo.PorTxo, error) {
 
 			// 0 len v means it's a watch-only utxo, not spendable
 			if len(v) == 0 {
-				// log.Printf("not nil, 0 len slice\n")
+				// log.Debugf("not nil, 0 len slice\n")
 				return nil
 			}
 
@@ -274,7 +440,7 @@ func (w *Wallit) GetAllUtxos() ([]*portxo.PorTxo, error) {
 func (w *Wallit) RegisterWatchOP(op wire.OutPoint) error {
 	opArr := lnutil.OutPointToBytes(op)
 	// open db
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
 		// get the outpoint watch bucket
 		dufb := btx.Bucket(BKToutpoint)
 		if dufb == nil {
@@ -287,7 +453,7 @@ func (w *Wallit) RegisterWatchOP(op wire.OutPoint) error {
 // GainUtxo registers the utxo in the duffel bag
 // don't register address; they shouldn't be re-used ever anyway.
 func (w *Wallit) GainUtxo(u portxo.PorTxo) error {
-	log.Printf("gaining exported utxo %s at height %d\n",
+	log.Infof("gaining exported utxo %s at height %d\n",
 		u.Op.String(), u.Height)
 	// serialize porTxo
 	utxoBytes, err := u.Bytes()
@@ -296,7 +462,7 @@ func (w *Wallit) GainUtxo(u portxo.PorTxo) error {
 	}
 
 	// open db
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
 		// get the outpoint watch bucket
 		dufb := btx.Bucket(BKToutpoint)
 		if dufb == nil {
@@ -343,76 +509,311 @@ func NewPorTxoBytesFromKGBytes(
 	return ptxo.Bytes()
 }
 
-// Rollback rewinds the wallet state to a previous height.  It removes new UTXOs
+// Rollback rewinds the wallet state to a previous height, after a reorg.
+// Utxos and spends confirmed above it go back to unconfirmed; they'll most
+// likely be mined again, and get their new heights then.  If a tx spending
+// the same thing as one of theirs confirms instead, they're dropped; see
+// markReorged.  Channel outpoints get a reorg event sent up to the LN layer,
+// to check their confirmations.
 func (w *Wallit) RollBack(rollHeight int32) error {
 	// Assume this is an actual reord / rewind.  If you supply a height *greater*
 	// than the current height, all bets are off.  ( probably nothing will
 	// happen; but don't do it)
+	log.Infof("Rollback height %d\n", rollHeight)
 
-	// I still don't 100% get how these bolt tx things get encapsulated.
-	return w.StateDB.Update(func(btx *bolt.Tx) error {
-		// range through utxos and remove all above target height
-		log.Printf("Rollback height %d\n", rollHeight)
-
+	var unconfirmed int
+	var chanOPs []wire.OutPoint
+	// txs that made or spent txos that are now unconfirmed
+	reorged := make(map[chainhash.Hash]bool)
+	err := w.StateDB.Update(func(btx kvdb.Tx) error {
 		dufb := btx.Bucket(BKToutpoint)
-
 		if dufb == nil {
 			return fmt.Errorf("no duffel bag")
 		}
 
-		// build slice of stuff to delete
-		var killOPs [][]byte
-
+		// can't change the buckets while going through them, so collect
+		// the new values first
+		puts := make(map[string][]byte)
 		err := dufb.ForEach(func(k, v []byte) error {
-			var txHeight int32
-			// 0 len v means it's a watch-only utxo, not spendable
-			// we have no way of getting rid of these.  Maybe should!
+			// 0 len v means it's a watch-only outpoint, like a channel's
 			if len(v) == 0 {
+				var opArr [36]byte
+				copy(opArr[:], k)
+				chanOPs = append(chanOPs, *lnutil.OutPointFromBytes(opArr))
 				return nil
 			}
+			// height is right after the 8 byte amount
+			if len(v) < 12 || lnutil.BtI32(v[8:12]) <= rollHeight {
+				return nil
+			}
+			nv := append([]byte(nil), v...)
+			copy(nv[8:12], lnutil.I32tB(0))
+			puts[string(k)] = nv
+			var txid chainhash.Hash
+			copy(txid[:], k[:32])
+			reorged[txid] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		unconfirmed = len(puts)
+		err = putAll(dufb, puts)
+		if err != nil {
+			return err
+		}
 
-			// all we care about is the height, which starts 8 btyes in to the v
-			buf := bytes.NewBuffer(v)
-
-			// drop first 8 bytes (amt)
-			_ = buf.Next(8)
-
-			err := binary.Read(buf, binary.BigEndian, &txHeight)
+		// watch account utxos go the same way; their height is after the
+		// 4 byte account number and the amount
+		wtxb := btx.Bucket(BKTWatchTxos)
+		puts = make(map[string][]byte)
+		err = wtxb.ForEach(func(k, v []byte) error {
+			u, err := watchTxoFromKV(k, v)
 			if err != nil {
 				return err
 			}
+			if u.Height > rollHeight {
+				nv := append([]byte(nil), v...)
+				copy(nv[12:16], lnutil.I32tB(0))
+				puts[string(k)] = nv
+				reorged[u.Op.Hash] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		unconfirmed += len(puts)
+		err = putAll(wtxb, puts)
+		if err != nil {
+			return err
+		}
 
-			log.Printf("tx height %d\n", txHeight)
-			if txHeight > rollHeight {
-				// need to kill this TX.  we could save it somewhere else?
-				// just mark to get rid of it for now.
-				killOPs = append(killOPs, k)
+		// spends above the height are back to unconfirmed too.  Don't
+		// re-animate the txos; the spending txs will probably get back in.
+		old := btx.Bucket(BKTStxos)
+		puts = make(map[string][]byte)
+		err = old.ForEach(func(k, v []byte) error {
+			st, err := StxoFromBytes(append(append([]byte(nil), k...), v...))
+			if err != nil {
+				return err
+			}
+			if st.SpendHeight <= rollHeight {
+				return nil
+			}
+			st.SpendHeight = 0
+			stxb, err := st.ToBytes()
+			if err != nil {
+				return err
 			}
+			puts[string(k)] = stxb[36:]
+			reorged[st.SpendTxid] = true
 			return nil
 		})
 		if err != nil {
 			return err
 		}
+		unconfirmed += len(puts)
+		err = putAll(old, puts)
+		if err != nil {
+			return err
+		}
+		return w.markReorged(btx, reorged)
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Rollback db.  %d txos back to unconfirmed\n", unconfirmed)
 
-		// now delete em all
-		for _, op := range killOPs {
-			err = dufb.Delete(op)
+	err = w.unsettleBroadcasts(rollHeight)
+	if err != nil {
+		return err
+	}
+
+	// let the LN layer know, outside the db tx, since it may call back in
+	if cap(w.OPEventChan) != 0 {
+		for _, op := range chanOPs {
+			w.OPEventChan <- lnutil.OutPointEvent{
+				Op: op, Height: rollHeight, Reorg: true}
+		}
+	}
+	return nil
+}
+
+// markReorged keeps the outpoints that txs a reorg took out spend, and has
+// the chainhook watch them.  If some other tx spending one confirms, the
+// reorged tx isn't coming back; see settleReorged.
+func (w *Wallit) markReorged(btx kvdb.Tx, txids map[chainhash.Hash]bool) error {
+	rogb := btx.Bucket(BKTReorged)
+	txns := btx.Bucket(BKTTxns)
+	for txid := range txids {
+		txb := txns.Get(txid[:])
+		if txb == nil {
+			continue
+		}
+		tx := wire.NewMsgTx()
+		err := tx.Deserialize(bytes.NewReader(txb))
+		if err != nil {
+			return err
+		}
+		for _, in := range tx.TxIn {
+			opArr := lnutil.OutPointToBytes(in.PreviousOutPoint)
+			err = rogb.Put(opArr[:], txid[:])
+			if err != nil {
+				return err
+			}
+			err = w.Hook.RegisterOutPoint(in.PreviousOutPoint)
 			if err != nil {
 				return err
 			}
 		}
+	}
+	return nil
+}
+
+// settleReorged looks at a tx spending op, confirmed at height, for a
+// reorged tx that spent it too.  If it's the same tx, it's back in the chain;
+// if it's another, the reorged one conflicts and gets dropped.
+func settleReorged(
+	btx kvdb.Tx, op [36]byte, txid chainhash.Hash, height int32) error {
+	if height == 0 {
+		// could still go either way
+		return nil
+	}
+	v := btx.Bucket(BKTReorged).Get(op[:])
+	if v == nil {
+		return nil
+	}
+	var rogTxid chainhash.Hash
+	copy(rogTxid[:], v)
+	if rogTxid == txid {
+		return forgetReorged(btx, txid)
+	}
+	return dropConflicted(btx, rogTxid)
+}
 
-		// Don't re-animate old txos at all; just hope that they get back into
-		// blocks, which they probably will.
+// forgetReorged stops looking out for conflicts with a reorged tx.
+func forgetReorged(btx kvdb.Tx, txid chainhash.Hash) error {
+	rogb := btx.Bucket(BKTReorged)
+	var dels [][]byte
+	err := rogb.ForEach(func(k, v []byte) error {
+		if bytes.Equal(v, txid[:]) {
+			dels = append(dels, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range dels {
+		err = rogb.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		// The right way to do this (TODO) would be to make a rebroadcast pool
-		// where if the stored txs above the reorg height aren't re-confirmed,
-		// then it will attempt to rebroadcast them.
+// dropConflicted removes a tx that can't confirm, since a tx spending the
+// same thing has.  Its txos were never real, and nor are txs spending them;
+// what it spent is unspent again.
+func dropConflicted(btx kvdb.Tx, txid chainhash.Hash) error {
+	log.Infof("tx %s conflicts with a confirmed tx; dropping it\n", txid.String())
+	err := forgetReorged(btx, txid)
+	if err != nil {
+		return err
+	}
 
-		log.Printf("Rollback db.  %d utxos lost\n", len(killOPs))
+	dufb := btx.Bucket(BKToutpoint)
+	old := btx.Bucket(BKTStxos)
+	lockb := btx.Bucket(BKTLocks)
+	wtxb := btx.Bucket(BKTWatchTxos)
+
+	// its txos, spent or not, and watch accounts' too.  Not watch-only
+	// outpoints; those are the LN layer's.
+	var dels [][]byte
+	var spenders []chainhash.Hash
+	for _, bkt := range []kvdb.Bucket{dufb, wtxb} {
+		cur := bkt.Cursor()
+		for k, v := cur.Seek(txid[:]); bytes.HasPrefix(k, txid[:]); k, v = cur.Next() {
+			if len(v) != 0 {
+				dels = append(dels, append([]byte(nil), k...))
+			}
+		}
+	}
+	cur := old.Cursor()
+	for k, v := cur.Seek(txid[:]); bytes.HasPrefix(k, txid[:]); k, v = cur.Next() {
+		st, err := StxoFromBytes(append(append([]byte(nil), k...), v...))
+		if err != nil {
+			return err
+		}
+		dels = append(dels, append([]byte(nil), k...))
+		spenders = append(spenders, st.SpendTxid)
+	}
 
+	// and what it spent
+	restore := make(map[string][]byte)
+	err = old.ForEach(func(k, v []byte) error {
+		st, err := StxoFromBytes(append(append([]byte(nil), k...), v...))
+		if err != nil {
+			return err
+		}
+		if st.SpendTxid != txid {
+			return nil
+		}
+		b, err := st.PorTxo.Bytes()
+		if err != nil {
+			return err
+		}
+		restore[string(k)] = b[36:]
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range dels {
+		for _, bkt := range []kvdb.Bucket{dufb, old, lockb, wtxb} {
+			err = bkt.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for k, v := range restore {
+		err = old.Delete([]byte(k))
+		if err != nil {
+			return err
+		}
+		err = dufb.Put([]byte(k), v)
+		if err != nil {
+			return err
+		}
+	}
+	err = btx.Bucket(BKTTxns).Delete(txid[:])
+	if err != nil {
+		return err
+	}
+
+	for _, spender := range spenders {
+		err = dropConflicted(btx, spender)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putAll puts keys and values, collected while going through a bucket,
+// into it.
+func putAll(bkt kvdb.Bucket, kvs map[string][]byte) error {
+	for k, v := range kvs {
+		err := bkt.Put([]byte(k), v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Ingest -- take in a tx from the ChainHook
@@ -467,12 +868,13 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 	}
 
 	// now do the db write (this is the expensive / slow part)
-	err = w.StateDB.Update(func(btx *bolt.Tx) error {
-		// get all 4 buckets
+	err = w.StateDB.Update(func(btx kvdb.Tx) error {
+		// get all 5 buckets
 		dufb := btx.Bucket(BKToutpoint)
 		adrb := btx.Bucket(BKTadr)
 		old := btx.Bucket(BKTStxos)
 		txns := btx.Bucket(BKTTxns)
+		lockb := btx.Bucket(BKTLocks)
 
 		// first gain utxos.
 		// for each txout, see if the pkscript matches something we're watching.
@@ -482,7 +884,12 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 				keygenBytes := adrb.Get(lnutil.KeyHashFromPkScript(out.PkScript))
 				if keygenBytes != nil {
 					// address matches something we're watching, cool.
-					// log.Printf("txout script:%x matched kg: %x\n", out.PkScript, keygenBytes)
+					// log.Tracef("txout script:%x matched kg: %x\n", out.PkScript, keygenBytes)
+
+					err := w.useAdr(btx, keygenBytes, height)
+					if err != nil {
+						return err
+					}
 
 					// build new portxo
 					txob, err := NewPorTxoBytesFromKGBytes(
@@ -517,6 +924,16 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 					if err != nil {
 						return err
 					}
+					continue
+				}
+				// not ours, but maybe a watch account's
+				watched, err := w.ingestWatchTxo(btx, tx, uint32(j), height)
+				if err != nil {
+					return err
+				}
+				if watched {
+					hits++
+					hitTxs[i] = true
 				}
 			}
 		}
@@ -537,7 +954,7 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 				if len(v) == 0 && cap(w.OPEventChan) != 0 {
 					// confirmation of unknown / watch only outpoint, send up to ln
 					// confirmation match detected; return OP event with nil tx
-					// log.Printf("|||| zomg match  ")
+					// log.Debugf("|||| zomg match  ")
 					hitTxs[i] = true // flag to save tx in db
 
 					var opArr [36]byte
@@ -554,13 +971,32 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 			}
 		}
 
+		// a confirmed tx spending what a reorged-out tx did means that one's
+		// not coming back.  Do this first, so what it spent is unspent again
+		// for the loop below.
+		for i, curOP := range spentOPs {
+			err = settleReorged(btx, curOP, *cachedShas[spentTxIdx[i]], height)
+			if err != nil {
+				return err
+			}
+		}
+
 		// iterate through spent outpoints, then outpoint bucket and look for matches
 		// this makes us lose money, which is regrettable, but we need to know.
 		// could lose stuff we just gained, that's OK.
 		for i, curOP := range spentOPs {
+			watched, err := spendWatchTxo(
+				btx, curOP, cachedShas[spentTxIdx[i]].CloneBytes())
+			if err != nil {
+				return err
+			}
+			if watched {
+				hitTxs[spentTxIdx[i]] = true
+				continue
+			}
 			v := dufb.Get(curOP[:])
 			if v != nil && len(v) == 0 && cap(w.OPEventChan) != 0 {
-				// log.Printf("|||watch only here zomg\n")
+				// log.Debugf("|||watch only here zomg\n")
 				hitTxs[spentTxIdx[i]] = true // just save everything
 				op := lnutil.OutPointFromBytes(curOP)
 				// build new outpoint event
@@ -581,7 +1017,7 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 					return err
 				}
 				// print lost portxo
-				log.Printf(lostTxo.String())
+				log.Debugf("%s\n", lostTxo.String())
 
 				// after marking for deletion, save stxo to old bucket
 				var st Stxo                               // generate spent txo
@@ -601,6 +1037,11 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 				if err != nil {
 					return err
 				}
+				// a spent utxo doesn't need a lock anymore
+				err = lockb.Delete(curOP[:])
+				if err != nil {
+					return err
+				}
 			}
 		}
 
@@ -619,6 +1060,6 @@ func (w *Wallit) IngestMany(txs []*wire.MsgTx, height int32) (uint32, error) {
 		return nil
 	})
 
-	log.Printf("ingest %d txs, %d hits\n", len(txs), hits)
+	log.Debugf("ingest %d txs, %d hits\n", len(txs), hits)
 	return hits, err
 }
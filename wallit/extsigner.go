@@ -0,0 +1,127 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/txscript"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// ExtSigner signs psbts with keys that aren't on this host, like a hardware
+// wallet's.  The wallit uses one to spend from watch accounts.
+type ExtSigner interface {
+	SignPsbt(p *lnutil.Psbt) (*lnutil.Psbt, error)
+}
+
+// CmdSigner is an ExtSigner that runs a command: the psbt goes in on stdin in
+// base64, and the command gives it back signed on stdout.  A small script
+// around HWI, or anything else that can sign psbts, does the job.
+type CmdSigner struct {
+	Args []string
+}
+
+// SignPsbt runs the signer command on a psbt.
+func (s *CmdSigner) SignPsbt(p *lnutil.Psbt) (*lnutil.Psbt, error) {
+	b64, err := p.B64()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(s.Args[0], s.Args[1:]...)
+	cmd.Stdin = strings.NewReader(b64 + "\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("signer %s: %s %s",
+			s.Args[0], err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	return lnutil.PsbtFromB64(stdout.String())
+}
+
+// SetExtSigner sets the command that signs for watch accounts, split on
+// spaces.  Empty means no signer.
+func (w *Wallit) SetExtSigner(command string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		w.Signer = nil
+		return nil
+	}
+	_, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	w.Signer = &CmdSigner{Args: args}
+	return nil
+}
+
+// SpendWatch sends from a watch account: builds a psbt, has the external
+// signer sign it, and sends the result.
+func (w *Wallit) SpendWatch(
+	acct uint32, txos []*wire.TxOut, feeRate int64) (*chainhash.Hash, error) {
+
+	if w.Signer == nil {
+		return nil, fmt.Errorf("no external signer; build a psbt, sign it " +
+			"elsewhere, and push it")
+	}
+	p, err := w.BuildPsbt(acct, txos, feeRate)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := w.Signer.SignPsbt(p)
+	if err != nil {
+		return nil, err
+	}
+	// the signer can only add sigs, not change what's spent or paid
+	if signed.Tx.TxHash() != p.Tx.TxHash() {
+		return nil, fmt.Errorf("signer gave back tx %s, asked to sign %s",
+			signed.Tx.TxHash().String(), p.Tx.TxHash().String())
+	}
+	return w.PushPsbt(signed)
+}
+
+// PushPsbt finalizes a signed psbt, checks the signatures, and sends the tx.
+// It's ingested first, so spends from watch accounts show up right away.
+func (w *Wallit) PushPsbt(p *lnutil.Psbt) (*chainhash.Hash, error) {
+	err := p.Finalize()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := p.Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	hashCache := txscript.NewTxSigHashes(tx)
+	for i := range tx.TxIn {
+		prevOut, err := p.PrevOut(i)
+		if err != nil {
+			return nil, err
+		}
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i,
+			txscript.StandardVerifyFlags, nil, hashCache, prevOut.Value)
+		if err != nil {
+			return nil, err
+		}
+		err = vm.Execute()
+		if err != nil {
+			return nil, fmt.Errorf("psbt input %d doesn't verify: %s",
+				i, err.Error())
+		}
+	}
+
+	txid := tx.TxHash()
+	log.Debugf("pushing signed psbt tx %s\n", txid.String())
+	err = w.NewOutgoingTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return &txid, nil
+}
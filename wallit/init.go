@@ -1,30 +1,31 @@
 package wallit
 
 import (
-	"log"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil/hdkeychain"
-	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/chainhook"
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
-	"github.com/mit-dci/lit/powless"
-	"github.com/mit-dci/lit/uspv"
+	"github.com/mit-dci/lit/portxo"
 )
 
+// NewWallit makes a wallit and starts it syncing from birthHeight.  birthday
+// is when the keys were made, in unix time; blocks from before then are
+// skipped.  0 if not known.
 func NewWallit(
-	rootkey *hdkeychain.ExtendedKey, birthHeight int32, resync bool,
-	spvhost, path string, p *coinparam.Params) *Wallit {
+	rootkey *hdkeychain.ExtendedKey, birthHeight int32, birthday int64,
+	resync bool, chanHeight int32, spvhost, path string,
+	p *coinparam.Params) *Wallit {
 
 	var w Wallit
 	w.rootPrivKey = rootkey
 	w.Param = p
 	w.FreezeSet = make(map[wire.OutPoint]*FrozenTx)
-
-	w.FeeRate = w.Param.FeePerByte
+	w.feeCache = make(map[uint32]cachedFee)
 
 	wallitpath := filepath.Join(path, p.Name)
 
@@ -38,47 +39,49 @@ func NewWallit(
 	// so we have to open the db first, then turn on the chainhook, THEN tell
 	// chainhook about all our addresses.
 
-	// use powless for chainhook if the host string has https in it
-	// this is a bit hacky for now
-
-	if strings.Contains(spvhost, "https") {
-		w.Hook = new(powless.APILink)
-	} else {
-		// no https; use uSPV for chainhook
-		w.Hook = new(uspv.SPVCon)
-	}
+	// the host string says what kind of chainhook to use
+	w.Hook = chainhook.New(spvhost, birthday)
+	log.Debugf("%s using %s chainhook\n", p.Name, chainhook.BackendFor(spvhost))
 
 	wallitdbname := filepath.Join(wallitpath, "utxo.db")
 	err = w.OpenDB(wallitdbname)
 	if err != nil {
-		log.Printf("NewWallit crash  %s ", err.Error())
+		log.Errorf("NewWallit crash  %s ", err.Error())
 	}
 	// get height
 	height := w.CurrentHeight()
-	log.Printf("DB height %d\n", height)
+	log.Debugf("DB height %d\n", height)
 
 	// bring height up to birthheight, or back down in case of resync
 	if height < birthHeight || resync {
 		height = birthHeight
 		w.SetDBSyncHeight(height)
 	}
+	// channels that haven't been scanned as far as the wallet need those
+	// blocks again; chanHeight is 0 if there aren't any
+	if chanHeight != 0 && chanHeight < height && chanHeight >= birthHeight {
+		log.Infof("going back to height %d for channels\n", chanHeight)
+		height = chanHeight
+	}
 
-	log.Printf("DB height %d\n", height)
+	log.Debugf("DB height %d\n", height)
 	incomingTx, incomingBlockheight, err := w.Hook.Start(height, spvhost, wallitpath, p)
 	if err != nil {
-		log.Printf("NewWallit Hook.Start crash  %s ", err.Error())
+		log.Errorf("NewWallit Hook.Start crash  %s ", err.Error())
 	}
+	// fee estimates from the chainhook, if it has them
+	w.SetFeeEstimator(nil)
 
 	// check if there are any addresses.  If there aren't (initial wallet setup)
 	// then make an address.
 	adrs, err := w.AdrDump()
 	if err != nil {
-		log.Printf("NewWallit crash  %s ", err.Error())
+		log.Errorf("NewWallit crash  %s ", err.Error())
 	}
 	if len(adrs) == 0 {
 		_, err := w.NewAdr()
 		if err != nil {
-			log.Printf("NewWallit crash  %s ", err.Error())
+			log.Errorf("NewWallit crash  %s ", err.Error())
 		}
 	}
 
@@ -86,19 +89,86 @@ func NewWallit(
 	for _, a := range adrs {
 		err = w.Hook.RegisterAddress(a)
 		if err != nil {
-			log.Printf("NewWallit RegisterAddress crash %s ", err.Error())
+			log.Errorf("NewWallit RegisterAddress crash %s ", err.Error())
+		}
+	}
+
+	// and the taproot keys for the same addresses
+	tapKeys, err := w.TaprootAdrDump()
+	if err != nil {
+		log.Errorf("NewWallit crash  %s ", err.Error())
+	}
+	for _, k := range tapKeys {
+		err = w.Hook.RegisterTaprootKey(k)
+		if err != nil {
+			log.Errorf("NewWallit RegisterTaprootKey crash %s ", err.Error())
+		}
+	}
+
+	// and the addresses of the other accounts
+	accts, err := w.Accounts()
+	if err != nil {
+		log.Errorf("NewWallit crash  %s ", err.Error())
+	}
+	for acct := uint32(1); acct < uint32(len(accts)); acct++ {
+		acctAdrs, err := w.AcctAdrDump(acct)
+		if err != nil {
+			log.Errorf("NewWallit crash  %s ", err.Error())
+		}
+		for _, a := range acctAdrs {
+			err = w.Hook.RegisterAddress(a)
+			if err != nil {
+				log.Errorf("NewWallit RegisterAddress crash %s ", err.Error())
+			}
+			tapKey, err := w.TaprootKeyForAdr(a)
+			if err != nil {
+				log.Errorf("NewWallit crash  %s ", err.Error())
+				continue
+			}
+			err = w.Hook.RegisterTaprootKey(tapKey)
+			if err != nil {
+				log.Errorf("NewWallit RegisterTaprootKey crash %s ", err.Error())
+			}
 		}
 	}
 
+	// and the addresses past the last handed out, for restored keys
+	err = w.watchAdrGaps()
+	if err != nil {
+		log.Errorf("NewWallit crash  %s ", err.Error())
+	}
+
 	// send outpoints (if any) to the hook
 	utxos, err := w.UtxoDump()
 	if err != nil {
-		log.Printf("NewWallit crash  %s ", err.Error())
+		log.Errorf("NewWallit crash  %s ", err.Error())
 	}
 	for _, utxo := range utxos {
-		err = w.Hook.RegisterOutPoint(utxo.Op)
+		err = w.registerUtxo(utxo)
 		if err != nil {
-			log.Printf("NewWallit crash  %s ", err.Error())
+			log.Errorf("NewWallit crash  %s ", err.Error())
+		}
+	}
+
+	// and the addresses and utxos of watch accounts
+	watchAdrs, err := w.WatchAdrDump()
+	if err != nil {
+		log.Errorf("NewWallit crash  %s ", err.Error())
+	}
+	for _, a := range watchAdrs {
+		err = w.Hook.RegisterAddress(a)
+		if err != nil {
+			log.Errorf("NewWallit RegisterAddress crash %s ", err.Error())
+		}
+	}
+	watchUtxos, err := w.WatchUtxos(0)
+	if err != nil {
+		log.Errorf("NewWallit crash  %s ", err.Error())
+	}
+	for _, utxo := range watchUtxos {
+		err = w.registerUtxo(utxo)
+		if err != nil {
+			log.Errorf("NewWallit crash  %s ", err.Error())
 		}
 	}
 
@@ -108,15 +178,33 @@ func NewWallit(
 	// deal with incoming height
 	go w.HeightHandler(incomingBlockheight)
 
+	// and keep sending out txs till they confirm
+	go w.Rebroadcaster()
+
 	return &w
 }
 
+// registerUtxo tells the hook about a utxo, with its script if it can use it.
+func (w *Wallit) registerUtxo(u *portxo.PorTxo) error {
+	sh, ok := w.Hook.(chainhook.ScriptHook)
+	if ok && len(u.PkScript) != 0 {
+		return sh.RegisterOutPointScript(u.Op, u.PkScript)
+	}
+	return w.Hook.RegisterOutPoint(u.Op)
+}
+
 // TxHandler is the goroutine that receives & ingests new txs for the wallit.
 func (w *Wallit) TxHandler(incomingTxAndHeight chan lnutil.TxAndHeight) {
 	for {
 		txah := <-incomingTxAndHeight
 		w.Ingest(txah.Tx, txah.Height)
-		log.Printf("got tx %s at height %d\n",
+		if txah.Height != 0 {
+			err := w.confirmBroadcasts(txah.Tx, txah.Height)
+			if err != nil {
+				log.Errorf("confirmBroadcasts error: %s\n", err.Error())
+			}
+		}
+		log.Debugf("got tx %s at height %d\n",
 			txah.Tx.TxHash().String(), txah.Height)
 	}
 }
@@ -125,33 +213,56 @@ func (w *Wallit) HeightHandler(incomingHeight chan int32) {
 	var prevHeight int32
 	for {
 		h := <-incomingHeight
+		// going back over old blocks isn't a reorg
+		if w.rescanProgress(h) {
+			continue
+		}
 		// detect reorg
 		if h < prevHeight {
-			log.Printf("HeightHandler: oh no, reorg!\n")
+			log.Infof("HeightHandler: oh no, reorg!\n")
 			err := w.RollBack(h)
 			if err != nil {
-				log.Printf("Rollback crash  %s ", err.Error())
+				log.Errorf("Rollback crash  %s ", err.Error())
 			}
 		}
 
 		err := w.SetDBSyncHeight(h)
 		if err != nil {
-			log.Printf("HeightHandler crash  %s ", err.Error())
+			log.Errorf("HeightHandler crash  %s ", err.Error())
+		}
+		if h > prevHeight && prevHeight != 0 {
+			w.maybeConsolidate()
 		}
+		w.sendHeightEvent(h)
 		prevHeight = h
 	}
 }
 
+// sendHeightEvent tells the LN layer about a block, if it's asked to know.
+func (w *Wallit) sendHeightEvent(h int32) {
+	if cap(w.HeightEventChan) == 0 {
+		return
+	}
+	ev := lnutil.HeightEvent{CoinType: w.Param.HDCoinType, Height: h}
+	hash, err := w.Hook.BlockHash(h)
+	if err != nil {
+		log.Errorf("no hash for block %d: %s\n", h, err.Error())
+	} else {
+		ev.Hash = *hash
+	}
+	w.HeightEventChan <- ev
+}
+
 // OpenDB starts up the database.  Creates the file if it doesn't exist.
 func (w *Wallit) OpenDB(filename string) error {
 	var err error
 	var numKeys uint32
-	w.StateDB, err = bolt.Open(filename, 0644, nil)
+	w.StateDB, err = kvdb.Open(filename, 0644)
 	if err != nil {
 		return err
 	}
 	// create buckets if they're not already there
-	err = w.StateDB.Update(func(btx *bolt.Tx) error {
+	err = w.StateDB.Update(func(btx kvdb.Tx) error {
 		_, err = btx.CreateBucketIfNotExists(BKToutpoint)
 		if err != nil {
 			return err
@@ -168,6 +279,46 @@ func (w *Wallit) OpenDB(filename string) error {
 		if err != nil {
 			return err
 		}
+		_, err = btx.CreateBucketIfNotExists(BKTLocks)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTLabels)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTAccts)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTUsedAdr)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTWatchAccts)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTWatchAdr)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTWatchTxos)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTWatchStxos)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTBroadcast)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BKTReorged)
+		if err != nil {
+			return err
+		}
 
 		sta, err := btx.CreateBucketIfNotExists(BKTState)
 		if err != nil {
@@ -177,9 +328,9 @@ func (w *Wallit) OpenDB(filename string) error {
 		numKeysBytes := sta.Get(KEYNumKeys)
 		if numKeysBytes != nil { // NumKeys exists, read into uint32
 			numKeys = lnutil.BtU32(numKeysBytes)
-			log.Printf("db says %d keys\n", numKeys)
+			log.Debugf("db says %d keys\n", numKeys)
 		} else { // no adrs yet, make it 0.  Then make an address.
-			log.Printf("NumKeys not in DB, must be new DB. 0 Keys\n")
+			log.Infof("NumKeys not in DB, must be new DB. 0 Keys\n")
 			numKeys = 0
 			b0 := lnutil.U32tB(numKeys)
 			err = sta.Put(KEYNumKeys, b0)
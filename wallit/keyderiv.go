@@ -1,10 +1,9 @@
 package wallit
 
 import (
-	"log"
-
 	"github.com/adiabat/btcd/btcec"
 	"github.com/adiabat/btcutil"
+	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
 )
 
@@ -28,7 +27,7 @@ func (w *Wallit) PathPrivkey(kg portxo.KeyGen) *btcec.PrivateKey {
 	}
 	priv, err := kg.DerivePrivateKey(w.rootPrivKey)
 	if err != nil {
-		log.Printf("PathPrivkey err %s", err.Error())
+		log.Errorf("PathPrivkey err %s", err.Error())
 		return nil
 	}
 	return priv
@@ -57,6 +56,22 @@ func (w *Wallit) PathPubHash160(kg portxo.KeyGen) [20]byte {
 	return pkh
 }
 
+// PathTaprootKey returns the 32 byte taproot output key for the given path.
+// It's the same key as PathPubHash160, tweaked for a key path spend.
+// Gives all zeros if there's an error.
+func (w *Wallit) PathTaprootKey(kg portxo.KeyGen) [32]byte {
+	var outKey [32]byte
+	pub := w.PathPubkey(kg)
+	if pub == nil {
+		return outKey
+	}
+	outKey, err := lnutil.TaprootOutputKey(pub)
+	if err != nil {
+		log.Errorf("PathTaprootKey err %s", err.Error())
+	}
+	return outKey
+}
+
 // ------------- end of 2 main key deriv functions
 
 // get a private key from the regular wallet
@@ -73,12 +88,19 @@ func (w *Wallit) GetWalletPrivkey(idx uint32) *btcec.PrivateKey {
 
 // GetWalletKeygen returns the keygen for a standard wallet address
 func GetWalletKeygen(idx, cointype uint32) portxo.KeyGen {
+	return GetAcctKeygen(0, idx, cointype)
+}
+
+// GetAcctKeygen returns the keygen for an address in an account.  The
+// account goes where the peer index does for channel keys; the default
+// account is 0, so its keys are the standard wallet ones.
+func GetAcctKeygen(acct, idx, cointype uint32) portxo.KeyGen {
 	var kg portxo.KeyGen
 	kg.Depth = 5
 	kg.Step[0] = 44 | 1<<31
 	kg.Step[1] = cointype | 1<<31
 	kg.Step[2] = 0 | 1<<31
-	kg.Step[3] = 0 | 1<<31
+	kg.Step[3] = acct | 1<<31
 	kg.Step[4] = idx | 1<<31
 	return kg
 }
@@ -0,0 +1,82 @@
+package wallit
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// Labels are notes about where coins came from and went, for accounting.
+// A label goes on a whole tx (keyed by txid) or one output (keyed by
+// outpoint).  Only txs the wallet has saved can be labeled, and labels stay
+// after outputs are spent.
+
+// LabelTx puts a label on a wallet tx, or on output index of it; index -1
+// for the whole tx.  An empty label takes it off.
+func (w *Wallit) LabelTx(txid chainhash.Hash, index int32, label string) error {
+	tx, err := w.GetSavedTx(&txid)
+	if err != nil {
+		return err
+	}
+	if index < -1 || index >= int32(len(tx.TxOut)) {
+		return fmt.Errorf("tx %s has %d outputs, no %d",
+			txid.String(), len(tx.TxOut), index)
+	}
+
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
+		lblb := btx.Bucket(BKTLabels)
+		key := labelKey(txid, index)
+		if label == "" {
+			return lblb.Delete(key)
+		}
+		return lblb.Put(key, []byte(label))
+	})
+}
+
+// ListTxLabels gives all the labels, by txid then output.
+func (w *Wallit) ListTxLabels() ([]lnutil.TxLabel, error) {
+	var labels []lnutil.TxLabel
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTLabels).ForEach(func(k, v []byte) error {
+			l := lnutil.TxLabel{Index: -1, Label: string(v)}
+			copy(l.Txid[:], k[:32])
+			if len(k) == 36 {
+				l.Index = int32(lnutil.BtU32(k[32:]))
+			}
+			labels = append(labels, l)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// TxoLabel gives the label for an output: its own if it has one, or else
+// its tx's.  Empty if neither.
+func (w *Wallit) TxoLabel(op wire.OutPoint) string {
+	var label string
+	_ = w.StateDB.View(func(btx kvdb.Tx) error {
+		lblb := btx.Bucket(BKTLabels)
+		v := lblb.Get(labelKey(op.Hash, int32(op.Index)))
+		if v == nil {
+			v = lblb.Get(labelKey(op.Hash, -1))
+		}
+		label = string(v)
+		return nil
+	})
+	return label
+}
+
+// labelKey is the txid for a tx label, or the outpoint for an output's.
+func labelKey(txid chainhash.Hash, index int32) []byte {
+	if index < 0 {
+		return txid[:]
+	}
+	opBytes := lnutil.OutPointToBytes(wire.OutPoint{Hash: txid, Index: uint32(index)})
+	return opBytes[:]
+}
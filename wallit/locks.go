@@ -0,0 +1,166 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// Locks keep utxos out of coin selection.  Unlike the freeze set, which only
+// lasts from MaybeSend to ReallySend, they're in the DB and survive restarts.
+// A lock goes away by itself once the utxo is spent.
+
+// LockUtxo stops a utxo of ours from being spent until it's unlocked.
+func (w *Wallit) LockUtxo(op wire.OutPoint, reason string) error {
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+	_, frozen := w.FreezeSet[op]
+	if frozen {
+		return fmt.Errorf("%s is already reserved for tx %s",
+			op.String(), w.FreezeSet[op].Txid.String())
+	}
+
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
+		return lockOps(btx, []wire.OutPoint{op}, reason)
+	})
+}
+
+// UnlockUtxo lets a locked utxo be spent again.  If it's frozen for a
+// tx from MaybeSend, that whole tx is called off.
+func (w *Wallit) UnlockUtxo(op wire.OutPoint) error {
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+	fTx, frozen := w.FreezeSet[op]
+	if frozen {
+		for _, txin := range fTx.Ins {
+			log.Tracef("\t remove %s from frozen outpoints\n", txin.Op.String())
+			delete(w.FreezeSet, txin.Op)
+		}
+		return nil
+	}
+
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
+		lockb := btx.Bucket(BKTLocks)
+		opBytes := lnutil.OutPointToBytes(op)
+		if lockb.Get(opBytes[:]) == nil {
+			return fmt.Errorf("%s isn't locked", op.String())
+		}
+		return lockb.Delete(opBytes[:])
+	})
+}
+
+// ListLocks returns all the locked utxos, along with the ones frozen for a
+// tx that hasn't been sent yet.
+func (w *Wallit) ListLocks() ([]lnutil.UtxoLock, error) {
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	var locks []lnutil.UtxoLock
+	for op, fTx := range w.FreezeSet {
+		locks = append(locks, lnutil.UtxoLock{
+			Op:     op,
+			Reason: fmt.Sprintf("reserved for tx %s", fTx.Txid.String()),
+		})
+	}
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTLocks).ForEach(func(k, v []byte) error {
+			var opArr [36]byte
+			copy(opArr[:], k)
+			locks = append(locks, lnutil.UtxoLock{
+				Op:     *lnutil.OutPointFromBytes(opArr),
+				Reason: string(v),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(locks, func(i, j int) bool {
+		a := lnutil.OutPointToBytes(locks[i].Op)
+		b := lnutil.OutPointToBytes(locks[j].Op)
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	return locks, nil
+}
+
+// ReserveUtxos picks utxos like PickUtxos, and locks them in the same step
+// so nothing else can pick them in between.  For spends built outside
+// MaybeSend, like contract funding, which aren't signed right away.
+func (w *Wallit) ReserveUtxos(
+	amtWanted, outputByteSize, feePerByte int64, ow bool,
+	strategy, reason string) (portxo.TxoSliceByBip69, int64, error) {
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	utxos, overshoot, err :=
+		w.PickUtxos(amtWanted, outputByteSize, feePerByte, ow, strategy, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ops := make([]wire.OutPoint, len(utxos))
+	for i, u := range utxos {
+		ops[i] = u.Op
+	}
+	err = w.StateDB.Update(func(btx kvdb.Tx) error {
+		return lockOps(btx, ops, reason)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return utxos, overshoot, nil
+}
+
+// lockedOps returns the set of outpoints locked in the DB.
+func (w *Wallit) lockedOps() (map[wire.OutPoint]bool, error) {
+	locked := make(map[wire.OutPoint]bool)
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTLocks).ForEach(func(k, v []byte) error {
+			var opArr [36]byte
+			copy(opArr[:], k)
+			locked[*lnutil.OutPointFromBytes(opArr)] = true
+			return nil
+		})
+	})
+	return locked, err
+}
+
+// isLocked says if an outpoint is locked in the DB.
+func (w *Wallit) isLocked(op wire.OutPoint) (bool, error) {
+	var locked bool
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		opBytes := lnutil.OutPointToBytes(op)
+		locked = btx.Bucket(BKTLocks).Get(opBytes[:]) != nil
+		return nil
+	})
+	return locked, err
+}
+
+// lockOps writes locks for outpoints, which have to be our own unlocked
+// utxos.  All or none get locked.
+func lockOps(btx kvdb.Tx, ops []wire.OutPoint, reason string) error {
+	dufb := btx.Bucket(BKToutpoint)
+	lockb := btx.Bucket(BKTLocks)
+	for _, op := range ops {
+		opBytes := lnutil.OutPointToBytes(op)
+		if len(dufb.Get(opBytes[:])) == 0 {
+			return fmt.Errorf("%s isn't a utxo of ours", op.String())
+		}
+		if lockb.Get(opBytes[:]) != nil {
+			return fmt.Errorf("%s is already locked", op.String())
+		}
+		err := lockb.Put(opBytes[:], []byte(reason))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
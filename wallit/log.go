@@ -0,0 +1,7 @@
+package wallit
+
+import "github.com/mit-dci/lit/logs"
+
+// log is the WALLIT subsystem's logger; its level can be set with --loglevel
+// or the SetLogLevel rpc.
+var log = logs.New("WALLIT")
@@ -0,0 +1,137 @@
+package wallit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/adiabat/btcd/wire"
+)
+
+/*
+Most txs go out the one way the chainhook sends them.  Justice and
+force-close txs can't wait, though, and one node that won't pass them on
+shouldn't be able to hold them up till a timeout runs out.  So those go out
+every way there is, all at once: through the chainhook, and straight to a
+few fresh peers if it's an SPV connection; to the full node used for fee
+estimates, if there is one; and to any push-tx services, which get the tx's
+hex POSTed to them, like esplora's /api/tx.  Push-tx services get reached
+through the proxy if lit has one, so over tor they can't tell who sent it.
+*/
+
+// pushService is a web service that sends out txs posted to it.
+type pushService struct {
+	url    string
+	client *http.Client
+}
+
+// AddPushService has important txs posted to a push-tx url too, with client.
+func (w *Wallit) AddPushService(url string, client *http.Client) {
+	w.pushMtx.Lock()
+	w.pushServices = append(w.pushServices, pushService{url: url, client: client})
+	w.pushMtx.Unlock()
+}
+
+// post sends a tx's hex to a push-tx service.
+func (p pushService) post(tx *wire.MsgTx) error {
+	var buf bytes.Buffer
+	err := tx.Serialize(&buf)
+	if err != nil {
+		return err
+	}
+	response, err := p.client.Post(p.url, "text/plain",
+		strings.NewReader(hex.EncodeToString(buf.Bytes())))
+	if err != nil {
+		return urlFree(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		// services say what's wrong in the body; keep it short for the log
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 200))
+		return fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// urlHost gives just a url's host, for naming it in logs and errors.  RPC
+// urls have the user and password in them, and services can have keys in
+// the path or query.
+func urlHost(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return "unparseable url"
+	}
+	return u.Host
+}
+
+// urlFree takes the url out of an http client error, leaving what went
+// wrong.
+func urlFree(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		return uerr.Err
+	}
+	return err
+}
+
+// PushImportant sends a tx out every way the wallet can, and keeps it to
+// send again till it's settled.  It's only an error if none of the ways
+// worked.
+func (w *Wallit) PushImportant(tx *wire.MsgTx) error {
+	err := w.watchBroadcast(tx)
+	if err != nil {
+		return err
+	}
+
+	type pushPath struct {
+		name string
+		push func(*wire.MsgTx) error
+	}
+	// the chainhook's rebroadcast goes to fresh peers too, if it has them
+	paths := []pushPath{{"chainhook", w.Hook.RebroadcastTx}}
+	w.feeMtx.Lock()
+	rpc, ok := w.feeEst.(*RPCFeeEstimator)
+	w.feeMtx.Unlock()
+	if ok {
+		paths = append(paths, pushPath{"full node " + urlHost(rpc.URL), rpc.SendRawTx})
+	}
+	w.pushMtx.Lock()
+	for _, p := range w.pushServices {
+		paths = append(paths, pushPath{urlHost(p.url), p.post})
+	}
+	w.pushMtx.Unlock()
+
+	errs := make(chan error, len(paths))
+	for _, p := range paths {
+		go func(p pushPath) {
+			err := p.push(tx)
+			if err != nil {
+				err = fmt.Errorf("%s: %s", p.name, err.Error())
+			}
+			errs <- err
+		}(p)
+	}
+
+	txid := tx.TxHash()
+	var sent int
+	var lastErr error
+	for range paths {
+		err := <-errs
+		if err != nil {
+			log.Warnf("PushImportant %s via %s\n", txid.String(), err.Error())
+			lastErr = err
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return fmt.Errorf("couldn't send tx %s any way; last error %s",
+			txid.String(), lastErr.Error())
+	}
+	log.Debugf("sent tx %s %d of %d ways\n", txid.String(), sent, len(paths))
+	return w.keepBroadcast(tx)
+}
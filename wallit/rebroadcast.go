@@ -0,0 +1,378 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/chainhook"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Txs we send out can get lost: the node we told might not pass them on, or
+might drop them from its mempool.  So every tx the wallet pushes, and every
+one pushed through its broadcast hook, like justice txs from the watchtower,
+is kept, and sent out again every so often till it confirms, or another tx
+spending its inputs does.
+
+To hear about either, the tx's first output and its inputs are watched.  A
+tx that's confirmed or conflicted is kept around till it's deeper than a
+reorg could undo, in case it needs to be sent again.
+
+broadcast value:
+1	status
+4	height it, or the tx it conflicts with, confirmed at
+8	unix time first sent
+8	unix time last sent
+4	times sent
+	the rest is the tx
+*/
+
+// rebroadcastInterval is how long a tx goes unconfirmed before it's sent
+// out again.
+const rebroadcastInterval = 10 * time.Minute
+
+// broadcastStatuses are the statuses, by the byte they're saved as.
+var broadcastStatuses = []string{lnutil.BroadcastPending,
+	lnutil.BroadcastConfirmed, lnutil.BroadcastConflicted,
+	lnutil.BroadcastReplaced}
+
+func broadcastBytes(b lnutil.BroadcastTx) ([]byte, error) {
+	var status int
+	for status < len(broadcastStatuses) && broadcastStatuses[status] != b.Status {
+		status++
+	}
+	if status == len(broadcastStatuses) {
+		return nil, fmt.Errorf("unknown broadcast status %s", b.Status)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(status))
+	buf.Write(lnutil.I32tB(b.Height))
+	buf.Write(lnutil.I64tB(b.FirstSent))
+	buf.Write(lnutil.I64tB(b.LastSent))
+	buf.Write(lnutil.U32tB(b.Sends))
+	err := b.Tx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func broadcastFromBytes(v []byte) (lnutil.BroadcastTx, error) {
+	var b lnutil.BroadcastTx
+	if len(v) < 25 {
+		return b, fmt.Errorf("broadcast tx %d bytes, expect at least 25", len(v))
+	}
+	if int(v[0]) >= len(broadcastStatuses) {
+		return b, fmt.Errorf("unknown broadcast status %d", v[0])
+	}
+	b.Status = broadcastStatuses[v[0]]
+	b.Height = lnutil.BtI32(v[1:5])
+	b.FirstSent = lnutil.BtI64(v[5:13])
+	b.LastSent = lnutil.BtI64(v[13:21])
+	b.Sends = lnutil.BtU32(v[21:25])
+	b.Tx = wire.NewMsgTx()
+	err := b.Tx.Deserialize(bytes.NewReader(v[25:]))
+	return b, err
+}
+
+// broadcast sends a tx out, and keeps it to send again till it's settled.
+// Any tx of ours it replaces, like with a fee bump, doesn't need sending
+// anymore.
+func (w *Wallit) broadcast(tx *wire.MsgTx) error {
+	// watch before pushing, so the hook keeps the scripts it needs
+	err := w.watchBroadcast(tx)
+	if err != nil {
+		return err
+	}
+	err = w.Hook.PushTx(tx)
+	if err != nil {
+		return err
+	}
+	return w.keepBroadcast(tx)
+}
+
+// keepBroadcast saves a tx that's been sent out, to send again till it's
+// settled.
+func (w *Wallit) keepBroadcast(tx *wire.MsgTx) error {
+	txid := tx.TxHash()
+	now := time.Now().Unix()
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		b := lnutil.BroadcastTx{Tx: tx, Status: lnutil.BroadcastPending,
+			FirstSent: now, LastSent: now, Sends: 1}
+		v := bcb.Get(txid[:])
+		if v != nil {
+			old, err := broadcastFromBytes(v)
+			if err != nil {
+				return err
+			}
+			b.FirstSent, b.Sends = old.FirstSent, old.Sends+1
+		}
+		err := settleBroadcasts(bcb, tx, lnutil.BroadcastReplaced, 0)
+		if err != nil {
+			return err
+		}
+		bv, err := broadcastBytes(b)
+		if err != nil {
+			return err
+		}
+		return bcb.Put(txid[:], bv)
+	})
+}
+
+// watchBroadcast has the hook look for a tx's first output, which shows up
+// when it confirms, and for its inputs, which show up if something else
+// spends them.
+func (w *Wallit) watchBroadcast(tx *wire.MsgTx) error {
+	err := w.Hook.RegisterOutPoint(wire.OutPoint{Hash: tx.TxHash(), Index: 0})
+	if err != nil {
+		return err
+	}
+	for _, in := range tx.TxIn {
+		err = w.Hook.RegisterOutPoint(in.PreviousOutPoint)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleBroadcasts gives the pending txs in the broadcast bucket that spend
+// any of tx's inputs, other than tx itself, a new status.  Replaced ones get
+// the height, if there is one, but stay replaced.
+func settleBroadcasts(bcb kvdb.Bucket, tx *wire.MsgTx,
+	status string, height int32) error {
+
+	spends := make(map[wire.OutPoint]bool, len(tx.TxIn))
+	for _, in := range tx.TxIn {
+		spends[in.PreviousOutPoint] = true
+	}
+	txid := tx.TxHash()
+
+	puts := make(map[string][]byte)
+	err := bcb.ForEach(func(k, v []byte) error {
+		if bytes.Equal(k, txid[:]) {
+			return nil
+		}
+		b, err := broadcastFromBytes(v)
+		if err != nil {
+			return err
+		}
+		newStatus := status
+		switch {
+		case b.Status == lnutil.BroadcastPending:
+		case b.Status == lnutil.BroadcastReplaced && b.Height == 0 && height != 0:
+			// stays replaced, but gets a height to be forgotten after
+			newStatus = lnutil.BroadcastReplaced
+		default:
+			return nil
+		}
+		for _, in := range b.Tx.TxIn {
+			if spends[in.PreviousOutPoint] {
+				log.Infof("broadcast tx %s %s by %s\n",
+					b.Tx.TxHash().String(), newStatus, txid.String())
+				b.Status, b.Height = newStatus, height
+				puts[string(k)], err = broadcastBytes(b)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return putAll(bcb, puts)
+}
+
+// confirmBroadcasts deals with a tx confirming: if it's one we sent, it's
+// confirmed, and ones we sent spending the same inputs are conflicted.
+func (w *Wallit) confirmBroadcasts(tx *wire.MsgTx, height int32) error {
+	txid := tx.TxHash()
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		v := bcb.Get(txid[:])
+		if v != nil {
+			b, err := broadcastFromBytes(v)
+			if err != nil {
+				return err
+			}
+			b.Status, b.Height = lnutil.BroadcastConfirmed, height
+			bv, err := broadcastBytes(b)
+			if err != nil {
+				return err
+			}
+			err = bcb.Put(txid[:], bv)
+			if err != nil {
+				return err
+			}
+		}
+		return settleBroadcasts(bcb, tx, lnutil.BroadcastConflicted, height)
+	})
+}
+
+// unsettleBroadcasts puts txs that were settled above rollHeight back to
+// pending, since what settled them isn't in the chain anymore.  If it comes
+// back, they'll be settled again.
+func (w *Wallit) unsettleBroadcasts(rollHeight int32) error {
+	return w.StateDB.Update(func(btx kvdb.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		puts := make(map[string][]byte)
+		err := bcb.ForEach(func(k, v []byte) error {
+			b, err := broadcastFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if b.Height <= rollHeight {
+				return nil
+			}
+			if b.Status != lnutil.BroadcastReplaced {
+				b.Status = lnutil.BroadcastPending
+			}
+			b.Height = 0
+			puts[string(k)], err = broadcastBytes(b)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return putAll(bcb, puts)
+	})
+}
+
+// Rebroadcaster sends out pending txs that haven't been sent in a while,
+// and forgets settled ones that are deep enough.  It runs as long as the
+// wallet does.
+func (w *Wallit) Rebroadcaster() {
+	// the hook forgets what it was watching on restart
+	pend, err := w.Broadcasts()
+	if err != nil {
+		log.Errorf("Rebroadcaster error: %s\n", err.Error())
+	}
+	for _, b := range pend {
+		if b.Status != lnutil.BroadcastPending {
+			continue
+		}
+		err = w.watchBroadcast(b.Tx)
+		if err != nil {
+			log.Errorf("Rebroadcaster error: %s\n", err.Error())
+		}
+	}
+
+	for range time.Tick(time.Minute) {
+		err = w.rebroadcast()
+		if err != nil {
+			log.Errorf("Rebroadcaster error: %s\n", err.Error())
+		}
+	}
+}
+
+func (w *Wallit) rebroadcast() error {
+	now := time.Now()
+	buried := w.CurrentHeight() - w.Param.ReorgDepth()
+
+	var resend []*wire.MsgTx
+	err := w.StateDB.Update(func(btx kvdb.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		var dels [][]byte
+		puts := make(map[string][]byte)
+		err := bcb.ForEach(func(k, v []byte) error {
+			b, err := broadcastFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if b.Status != lnutil.BroadcastPending {
+				// replaced txs get a height once something spending
+				// their inputs confirms
+				if b.Height != 0 && b.Height <= buried {
+					dels = append(dels, append([]byte(nil), k...))
+				}
+				return nil
+			}
+			if now.Sub(time.Unix(b.LastSent, 0)) < rebroadcastInterval {
+				return nil
+			}
+			b.LastSent = now.Unix()
+			b.Sends++
+			resend = append(resend, b.Tx)
+			puts[string(k)], err = broadcastBytes(b)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range dels {
+			err = bcb.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return putAll(bcb, puts)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range resend {
+		log.Warnf("tx %s still unconfirmed; sending it again\n",
+			tx.TxHash().String())
+		err = w.Hook.RebroadcastTx(tx)
+		if err != nil {
+			log.Errorf("RebroadcastTx error: %s\n", err.Error())
+		}
+	}
+	return nil
+}
+
+// Broadcasts gives the txs being rebroadcast, and the ones recently
+// settled, oldest first.
+func (w *Wallit) Broadcasts() ([]lnutil.BroadcastTx, error) {
+	var bs []lnutil.BroadcastTx
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		bcb := btx.Bucket(BKTBroadcast)
+		return bcb.ForEach(func(k, v []byte) error {
+			b, err := broadcastFromBytes(v)
+			if err != nil {
+				return err
+			}
+			bs = append(bs, b)
+			return nil
+		})
+	})
+	sort.Slice(bs, func(i, j int) bool { return bs[i].FirstSent < bs[j].FirstSent })
+	return bs, err
+}
+
+// broadcastHook is the wallet's chainhook, but txs pushed through it go out
+// every way the wallet can, and are kept and rebroadcast like the wallet's
+// own.  They're the watchtower's justice txs.
+type broadcastHook struct {
+	chainhook.ChainHook
+	w *Wallit
+}
+
+func (h *broadcastHook) PushTx(tx *wire.MsgTx) error {
+	return h.w.PushImportant(tx)
+}
+
+// MempoolTxs passes along the hook's unconfirmed txs, if it gives them;
+// nil if not.
+func (h *broadcastHook) MempoolTxs() chan *wire.MsgTx {
+	mh, ok := h.ChainHook.(chainhook.MempoolHook)
+	if !ok {
+		return nil
+	}
+	return mh.MempoolTxs()
+}
+
+// BroadcastHook gives the wallet's chainhook, for things like the
+// watchtower that push txs straight to it, with pushed txs rebroadcast till
+// they're settled.
+func (w *Wallit) BroadcastHook() chainhook.ChainHook {
+	return &broadcastHook{ChainHook: w.Hook, w: w}
+}
@@ -0,0 +1,128 @@
+package wallit
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// Rescan has the chainhook go over the chain again from fromHeight, to find
+// txs for keys the wallit didn't know about when it first went by, like
+// after restoring from seed.  It only looks for the wallit's own addresses,
+// so with compact filters, just the blocks with those get downloaded.  It
+// runs in the background; RescanStatus tells how far it's got.
+func (w *Wallit) Rescan(fromHeight int32) error {
+	var scripts [][]byte
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		// adr has both 20 byte pubkey hashes and 32 byte taproot keys
+		err := btx.Bucket(BKTadr).ForEach(func(k, v []byte) error {
+			return appendKeyScripts(&scripts, k)
+		})
+		if err != nil {
+			return err
+		}
+		return btx.Bucket(BKTWatchAdr).ForEach(func(k, v []byte) error {
+			return appendKeyScripts(&scripts, k)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return w.rescanScripts(fromHeight, scripts)
+}
+
+// RescanWatchAcct is a Rescan for just the addresses of a watch account,
+// for finding its old txs after ImportXpub.
+func (w *Wallit) RescanWatchAcct(acct uint32, fromHeight int32) error {
+	var scripts [][]byte
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTWatchAdr).ForEach(func(k, v []byte) error {
+			if len(v) < 4 || lnutil.BtU32(v[:4]) != acct {
+				return nil
+			}
+			return appendKeyScripts(&scripts, k)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if len(scripts) == 0 {
+		return fmt.Errorf("no watch account %d", acct)
+	}
+	return w.rescanScripts(fromHeight, scripts)
+}
+
+// rescanScripts has the chainhook rescan from fromHeight up to the tip,
+// looking for scripts, and keeps track of how it's going.
+func (w *Wallit) rescanScripts(fromHeight int32, scripts [][]byte) error {
+	tip := w.CurrentHeight()
+	if fromHeight < 1 || fromHeight > tip {
+		return fmt.Errorf("can't rescan from %d; synced to %d", fromHeight, tip)
+	}
+
+	w.rescanMtx.Lock()
+	defer w.rescanMtx.Unlock()
+	if w.rescanTo != 0 {
+		return fmt.Errorf("already rescanning from %d, at %d of %d",
+			w.rescanFrom, w.rescanAt, w.rescanTo)
+	}
+	w.rescanFrom, w.rescanAt, w.rescanTo = fromHeight, fromHeight-1, tip
+
+	err := w.Hook.RescanScripts(fromHeight, tip, scripts)
+	if err != nil {
+		w.rescanTo = 0
+		return err
+	}
+	log.Infof("rescanning %d to %d for %d scripts\n", fromHeight, tip, len(scripts))
+	return nil
+}
+
+// appendKeyScripts adds the scripts for a key from the adr buckets: a 20
+// byte pubkey hash or a 32 byte taproot output key.
+func appendKeyScripts(scripts *[][]byte, k []byte) error {
+	switch len(k) {
+	case 20:
+		var pkh [20]byte
+		copy(pkh[:], k)
+		adrScripts, err := lnutil.AdrScripts(pkh)
+		if err != nil {
+			return err
+		}
+		*scripts = append(*scripts, adrScripts...)
+	case 32:
+		var outKey [32]byte
+		copy(outKey[:], k)
+		*scripts = append(*scripts, lnutil.P2TRScript(outKey))
+	}
+	return nil
+}
+
+// RescanStatus gives where a rescan started, how far it's got, and where it
+// ends.  All 0 if there's no rescan going.
+func (w *Wallit) RescanStatus() (from, at, to int32) {
+	w.rescanMtx.Lock()
+	defer w.rescanMtx.Unlock()
+	if w.rescanTo == 0 {
+		return 0, 0, 0
+	}
+	return w.rescanFrom, w.rescanAt, w.rescanTo
+}
+
+// rescanProgress takes a height from the chainhook, and if it's part of a
+// rescan, notes it and returns true.  Getting back up to the tip the rescan
+// started at ends it, and that height gets handled like any other.
+func (w *Wallit) rescanProgress(h int32) bool {
+	w.rescanMtx.Lock()
+	defer w.rescanMtx.Unlock()
+	if w.rescanTo == 0 {
+		return false
+	}
+	if h < w.rescanTo {
+		w.rescanAt = h
+		return true
+	}
+	log.Infof("rescan from %d done\n", w.rescanFrom)
+	w.rescanTo = 0
+	return false
+}
@@ -3,7 +3,6 @@ package wallit
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"sort"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
@@ -12,22 +11,41 @@ import (
 	"github.com/adiabat/btcutil/txsort"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
-	"github.com/mit-dci/lit/consts"
 )
 
 // Build a tx, kindof like with SendCoins, but don't sign or broadcast.
 // Segwit inputs only.  Freeze the utxos used so the tx can be signed and broadcast
 // later.  Use only segwit utxos.  Return the txid, and indexes of where the txouts
-// in the argument slice ended up in the final tx.
+// in the argument slice ended up in the final tx.  strategy is the coin
+// selection to use; empty means the wallet's.  use and avoid are for manual
+// coin control, see PickUtxos.
 // Bunch of redundancy with SendMany, maybe move that to a shared function...
 //NOTE this does not support multiple txouts with identical pkscripts in one tx.
 // The code would be trivial; it's not supported on purpose.  Use unique pkscripts.
-func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error) {
+func (w *Wallit) MaybeSend(
+	txos []*wire.TxOut, ow bool, strategy string,
+	use, avoid []wire.OutPoint) ([]*wire.OutPoint, error) {
+	return w.MaybeSendFrom(0, txos, ow, strategy, use, avoid, nil, 0)
+}
+
+// MaybeSendFrom is MaybeSend spending only an account's utxos.  Change goes
+// where change says, or if that's nil, to a new witness address in acct.
+// feeRate is in sat per byte, 0 for the wallet's.
+func (w *Wallit) MaybeSendFrom(acct uint32,
+	txos []*wire.TxOut, ow bool, strategy string,
+	use, avoid []wire.OutPoint,
+	change *lnutil.ChangeSpec, feeRate int64) ([]*wire.OutPoint, error) {
 	var err error
+	if change == nil {
+		change = &lnutil.ChangeSpec{Acct: acct}
+	}
 	var totalSend int64
-	dustCutoff := consts.DustCutoff // below this amount, just give to miners
+	dustCutoff := w.Param.DustLimit // below this amount, just give to miners
 
-	feePerByte := w.FeeRate
+	feePerByte, err := w.checkFeeRate(feeRate)
+	if err != nil {
+		return nil, err
+	}
 
 	// make an initial txo copy so we can find where the outputs end up in final tx
 
@@ -52,19 +70,20 @@ func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error
 
 	// get inputs for this tx.  Only segwit if needed
 	utxos, overshoot, err :=
-		w.PickUtxos(totalSend, outputByteSize, feePerByte, ow)
+		w.pickUtxosFrom(acct, totalSend, outputByteSize, feePerByte, ow,
+			strategy, use, avoid)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("MaybeSend has overshoot %d, %d inputs\n", overshoot, len(utxos))
+	log.Debugf("MaybeSend has overshoot %d, %d inputs\n", overshoot, len(utxos))
 
-	// changeOutSize is the extra vsize that a change output would add
-	changeOutFee := 30 * feePerByte
+	// the extra fee that a change output would add
+	changeOutFee := specChangeSize(change) * feePerByte
 
 	// add a change output if we have enough extra to do so
 	if overshoot > dustCutoff+changeOutFee {
-		changeOut, err = w.NewChangeOut(overshoot - changeOutFee)
+		changeOut, err = w.newSpecChangeOut(change, overshoot-changeOutFee)
 		if err != nil {
 			return nil, err
 		}
@@ -112,7 +131,7 @@ func (w *Wallit) MaybeSend(txos []*wire.TxOut, ow bool) ([]*wire.OutPoint, error
 // Sign and broadcast a tx previously built with MaybeSend.  This clears the freeze
 // on the utxos but they're not utxos anymore anyway.
 func (w *Wallit) ReallySend(txid *chainhash.Hash) error {
-	log.Printf("Reallysend %s\n", txid.String())
+	log.Debugf("Reallysend %s\n", txid.String())
 	// start frozen set access
 	w.FreezeMutex.Lock()
 	defer w.FreezeMutex.Unlock()
@@ -123,7 +142,7 @@ func (w *Wallit) ReallySend(txid *chainhash.Hash) error {
 	}
 	// delete inputs from frozen set (they're gone anyway, but just to clean it up)
 	for _, txin := range frozenTx.Ins {
-		log.Printf("\t remove %s from frozen outpoints\n", txin.Op.String())
+		log.Tracef("\t remove %s from frozen outpoints\n", txin.Op.String())
 		delete(w.FreezeSet, txin.Op)
 	}
 
@@ -144,7 +163,7 @@ func (w *Wallit) ReallySend(txid *chainhash.Hash) error {
 // Cancel the hold on a tx previously built with MaybeSend.  Clears freeze on
 // utxos so they can be used somewhere else.
 func (w *Wallit) NahDontSend(txid *chainhash.Hash) error {
-	log.Printf("Nahdontsend %s\n", txid.String())
+	log.Debugf("Nahdontsend %s\n", txid.String())
 	// start frozen set access
 	w.FreezeMutex.Lock()
 	defer w.FreezeMutex.Unlock()
@@ -155,7 +174,7 @@ func (w *Wallit) NahDontSend(txid *chainhash.Hash) error {
 	}
 	// go through all its inputs, and remove those outpoints from the frozen set
 	for _, txin := range frozenTx.Ins {
-		log.Printf("\t remove %s from frozen outpoints\n", txin.Op.String())
+		log.Tracef("\t remove %s from frozen outpoints\n", txin.Op.String())
 		delete(w.FreezeSet, txin.Op)
 	}
 	return nil
@@ -184,7 +203,7 @@ func (w *Wallit) GrabAll() error {
 	nothin := true
 	for _, u := range utxos {
 		if u.Seq == 1 && u.Height > 0 { // grabbable
-			log.Printf("found %s to grab!\n", u.String())
+			log.Infof("found %s to grab!\n", u.String())
 			adr160, err := w.NewAdr160()
 			if err != nil {
 				return err
@@ -204,7 +223,7 @@ func (w *Wallit) GrabAll() error {
 		}
 	}
 	if nothin {
-		log.Printf("Nothing to grab\n")
+		log.Infof("Nothing to grab\n")
 	}
 	return nil
 }
@@ -212,7 +231,7 @@ func (w *Wallit) GrabAll() error {
 // Directly send out a tx.  For things that plug in to the uspv wallet.
 func (w *Wallit) DirectSendTx(tx *wire.MsgTx) error {
 	// don't ingest, just push out
-	return w.Hook.PushTx(tx)
+	return w.broadcast(tx)
 }
 
 // NewOutgoingTx runs a tx though the db first, then sends it out to the network.
@@ -221,7 +240,7 @@ func (w *Wallit) NewOutgoingTx(tx *wire.MsgTx) error {
 	if err != nil {
 		return err
 	}
-	return w.Hook.PushTx(tx)
+	return w.broadcast(tx)
 }
 
 // PickUtxos Picks Utxos for spending.  Tell it how much money you want.
@@ -229,9 +248,21 @@ func (w *Wallit) NewOutgoingTx(tx *wire.MsgTx) error {
 // if "ow" is true, only gives witness utxos (for channel funding)
 // The overshoot amount is *after* fees, so can be used directly for a
 // change output.
+// strategy is the coin selection to use; empty means the wallet's.
+// If use isn't empty, it's spent as is instead, and has to be enough.
+// Nothing in avoid gets picked.  Both have to be utxos of ours.
+// Only utxos in the default account get picked.
 func (w *Wallit) PickUtxos(
-	amtWanted, outputByteSize, feePerByte int64,
-	ow bool) (portxo.TxoSliceByBip69, int64, error) {
+	amtWanted, outputByteSize, feePerByte int64, ow bool, strategy string,
+	use, avoid []wire.OutPoint) (portxo.TxoSliceByBip69, int64, error) {
+	return w.pickUtxosFrom(
+		0, amtWanted, outputByteSize, feePerByte, ow, strategy, use, avoid)
+}
+
+// pickUtxosFrom is PickUtxos for any account.
+func (w *Wallit) pickUtxosFrom(acct uint32,
+	amtWanted, outputByteSize, feePerByte int64, ow bool, strategy string,
+	use, avoid []wire.OutPoint) (portxo.TxoSliceByBip69, int64, error) {
 
 	curHeight, err := w.GetDBSyncHeight()
 	if err != nil {
@@ -244,94 +275,80 @@ func (w *Wallit) PickUtxos(
 		return nil, 0, err
 	}
 
-	// remove frozen utxos from allUtxo slice.  Iterate backwards / trailing delete
+	// coin control can only name utxos we have
+	err = checkOurs(allUtxos, use)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = checkOurs(allUtxos, avoid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	locked, err := w.lockedOps()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, op := range avoid {
+		locked[op] = true
+	}
+
+	// remove frozen, locked and avoided utxos, and other accounts' utxos,
+	// from allUtxo slice.  Iterate backwards / trailing delete
 	for i := len(allUtxos) - 1; i >= 0; i-- {
 		_, frozen := w.FreezeSet[allUtxos[i].Op]
-		if frozen {
+		if frozen || locked[allUtxos[i].Op] || UtxoAcct(allUtxos[i]) != acct {
 			// faster than append, and we're sorting a few lines later anyway
 			allUtxos[i] = allUtxos[len(allUtxos)-1] // redundant if at last index
 			allUtxos = allUtxos[:len(allUtxos)-1]   // trim last element
 		}
 	}
 
-	// start with utxos sorted by value and pop off utxos which are greater
-	// than the send amount... as long as the next 2 are greater.
-	// simple / straightforward coin selection optimization, which tends to make
-	// 2 in 2 out
-
-	// smallest and unconfirmed last (because it's reversed)
-	sort.Sort(sort.Reverse(allUtxos))
-
-	// guessing that txs won't be more than 10K here...
-	maxFeeGuess := feePerByte * consts.MaxTxCount
-
-	// first pass of removing candidate utxos; if the next one is bigger than
-	// we need, remove the top one.
-	for len(allUtxos) > 1 &&
-		allUtxos[1].Value > amtWanted+maxFeeGuess &&
-		allUtxos[1].Height > 100 &&
-		!(ow && allUtxos[1].Mode&portxo.FlagTxoWitness == 0) {
-		allUtxos = allUtxos[1:]
-	}
-
-	// if we've got 2 or more confirmed utxos, and the next one is
-	// more than enough, pop off the first one.
-	// Note that there are probably all sorts of edge cases where this will
-	// result in not being able to send money when you should be able to.
-	// Thus the handwavey "maxFeeGuess"
-	for len(allUtxos) > 2 &&
-		allUtxos[2].Height > 100 && // since sorted, don't need to check [1]
-		allUtxos[1].Mature(curHeight) &&
-		allUtxos[2].Mature(curHeight) &&
-		allUtxos[1].Value+allUtxos[2].Value > amtWanted+maxFeeGuess &&
-		!(ow && allUtxos[2].Mode&portxo.FlagTxoWitness == 0) &&
-		!(ow && allUtxos[1].Mode&portxo.FlagTxoWitness == 0) {
-		log.Printf("remaining utxo list, in order:\n")
-		for _, u := range allUtxos {
-			log.Printf("\t h: %d amt: %d\n", u.Height, u.Value)
-		}
-		allUtxos = allUtxos[1:]
+	if len(use) > 0 {
+		return pickManual(allUtxos, curHeight, use,
+			amtWanted, outputByteSize, feePerByte, ow)
 	}
 
-	// coin selection is super complex, and we can definitely do a lot better
-	// here!
-	// TODO: anyone who wants to: implement more advanced coin selection algo
+	if strategy == "" {
+		strategy = w.CoinSelect()
+	}
 
-	// rSlice is the return slice of the utxos which are going into the tx
 	var rSlice portxo.TxoSliceByBip69
-	// add utxos until we've had enough
-	remaining := amtWanted // remaining is how much is needed on input side
-	for _, utxo := range allUtxos {
-		// skip unconfirmed.  Or de-prioritize? Some option for this...
-		//		if utxo.AtHeight == 0 {
-		//			continue
-		//		}
-		if !utxo.Mature(curHeight) {
-			continue // skip immature or unconfirmed time-locked sh outputs
+	var remaining int64
+	switch strategy {
+	case CoinSelectDefault:
+		rSlice, remaining = pickSmallEnough(
+			allUtxos, curHeight, amtWanted, outputByteSize, feePerByte, ow)
+
+	case CoinSelectLargest:
+		spendable := spendableUtxos(allUtxos, curHeight, ow)
+		sort.Sort(sort.Reverse(spendable))
+		rSlice, remaining = accumulate(
+			spendable, amtWanted, outputByteSize, feePerByte)
+
+	case CoinSelectBnB:
+		spendable := spendableUtxos(allUtxos, curHeight, ow)
+		rSlice, remaining = pickChangeless(
+			spendable, amtWanted, outputByteSize, feePerByte, w.Param.DustLimit)
+		if rSlice == nil {
+			log.Debugf("no changeless set of %d utxos, picking with change\n",
+				len(spendable))
+			rSlice, remaining = pickSmallEnough(
+				allUtxos, curHeight, amtWanted, outputByteSize, feePerByte, ow)
 		}
-		if ow && utxo.Mode&portxo.FlagTxoWitness == 0 {
-			continue // skip non-witness
-		}
-		// why are 0-value outputs a thing..?
-		if utxo.Value < 1 {
-			continue
-		}
-		// yeah, lets add this utxo!
-		rSlice = append(rSlice, utxo)
-		remaining -= utxo.Value
-		// if remaining is positive, don't bother checking fee yet.
-		// if remaining is negative, calculate needed fee
-		if remaining <= 0 {
-			fee := EstFee(rSlice, outputByteSize, feePerByte)
-			// subtract fee from returned overshoot.
-			// (remaining is negative here)
-			remaining += fee
-
-			// done adding utxos if remaining below negative est fee
-			if remaining < -fee {
-				break
-			}
+
+	case CoinSelectRandom:
+		spendable := spendableUtxos(allUtxos, curHeight, ow)
+		err = shuffleUtxos(spendable)
+		if err != nil {
+			return nil, 0, err
 		}
+		rSlice, remaining = accumulate(
+			spendable, amtWanted, outputByteSize, feePerByte)
+
+	default:
+		return nil, 0, fmt.Errorf("unknown coin selection %q; options are %v",
+			strategy, CoinSelectStrategies)
 	}
 
 	if remaining > 0 {
@@ -353,6 +370,13 @@ func (w *Wallit) SendOne(u portxo.PorTxo, outScript []byte) (*wire.MsgTx, error)
 	if frozen {
 		return nil, fmt.Errorf("%s is frozen, can't spend", u.Op.String())
 	}
+	locked, err := w.isLocked(u.Op)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		return nil, fmt.Errorf("%s is locked, can't spend", u.Op.String())
+	}
 
 	curHeight, err := w.GetDBSyncHeight()
 	if err != nil {
@@ -365,7 +389,7 @@ func (w *Wallit) SendOne(u portxo.PorTxo, outScript []byte) (*wire.MsgTx, error)
 		return nil, fmt.Errorf("Can't spend, immature")
 	}
 	// fixed fee
-	fee := w.FeeRate * 200
+	fee := w.Fee() * 200
 
 	sendAmt := u.Value - fee
 
@@ -394,19 +418,36 @@ func (w *Wallit) BuildDontSign(
 	// add all the txins
 	for i, u := range utxos {
 		tx.AddTxIn(wire.NewTxIn(&u.Op, nil, nil))
-		// set sequence field if it's in the portxo
-		if u.Seq > 1 {
-			tx.TxIn[i].Sequence = u.Seq
-		}
+		tx.TxIn[i].Sequence = txinSequence(u)
 	}
 	// sort in place before signing
 	txsort.InPlaceSort(tx)
 	return tx, nil
 }
 
+// txinSequence is the sequence field for spending a utxo: its own if it's
+// time-locked, otherwise one that signals BIP125 replaceability, so the fee
+// can be bumped later.
+func txinSequence(u *portxo.PorTxo) uint32 {
+	if u.Seq > 1 {
+		return u.Seq
+	}
+	return wire.MaxTxInSequenceNum - 2
+}
+
 // SignMyInputs finds the inputs in a transaction that came from our own wallet, and signs them with our private keys.
 // Will modify the transaction in place, but will ignore inputs that we can't sign and leave them unsigned.
 func (w *Wallit) SignMyInputs(tx *wire.MsgTx) error {
+	allUtxos, err := w.GetAllUtxos()
+	if err != nil {
+		return err
+	}
+	return w.signInputs(tx, allUtxos)
+}
+
+// signInputs signs the inputs of tx spending any of allUtxos.
+func (w *Wallit) signInputs(tx *wire.MsgTx, allUtxos []*portxo.PorTxo) error {
+	var err error
 
 	// generate tx-wide hashCache for segwit stuff
 	// might not be needed (non-witness) but make it anyway
@@ -414,9 +455,8 @@ func (w *Wallit) SignMyInputs(tx *wire.MsgTx) error {
 	// make the stashes for signatures / witnesses
 	sigStash := make([][]byte, len(tx.TxIn))
 	witStash := make([][][]byte, len(tx.TxIn))
-
-	var allUtxos portxo.TxoSliceByAmt
-	allUtxos, err := w.GetAllUtxos()
+	// taproot sighashes cover every input's prevout; filled in if needed
+	var tapPrevOuts []*wire.TxOut
 
 	for i := range tx.TxIn {
 		var utxo *portxo.PorTxo
@@ -434,13 +474,13 @@ func (w *Wallit) SignMyInputs(tx *wire.MsgTx) error {
 
 		// get key
 		priv := w.PathPrivkey(utxo.KeyGen)
-		log.Printf("signing with privkey pub %x\n", priv.PubKey().SerializeCompressed())
+		log.Tracef("signing with privkey pub %x\n", priv.PubKey().SerializeCompressed())
 
 		if priv == nil {
 			return fmt.Errorf("SignMyInputs: nil privkey")
 		}
 
-		// sign into stash.  3 possibilities:  legacy PKH, WPKH, WSH
+		// sign into stash.  4 possibilities:  legacy PKH, WPKH, WSH, TR
 		if utxo.Mode == portxo.TxoP2PKHComp { // legacy PKH
 			sigStash[i], err = txscript.SignatureScript(tx, i,
 				utxo.PkScript, txscript.SigHashAll, priv, true)
@@ -475,6 +515,28 @@ func (w *Wallit) SignMyInputs(tx *wire.MsgTx) error {
 			// last stack item is the pkscript
 			witStash[i][len(witStash[i])-1] = utxo.PkScript
 		}
+		if utxo.Mode == portxo.TxoP2TR { // taproot key path
+			if tapPrevOuts == nil {
+				tapPrevOuts, err = prevOutsFor(tx, allUtxos)
+				if err != nil {
+					return err
+				}
+			}
+			hash, err := lnutil.TaprootSigHash(tx, i, tapPrevOuts)
+			if err != nil {
+				return err
+			}
+			tapPriv, err := lnutil.TaprootTweakPriv(priv)
+			if err != nil {
+				return err
+			}
+			sig, err := lnutil.SignHash(lnutil.SigTypeSchnorr, tapPriv, hash[:])
+			if err != nil {
+				return err
+			}
+			// SIGHASH_DEFAULT, so no sighash byte; the sig is the whole witness
+			witStash[i] = [][]byte{sig[:]}
+		}
 
 	}
 	// swap sigs into sigScripts in txins
@@ -491,6 +553,28 @@ func (w *Wallit) SignMyInputs(tx *wire.MsgTx) error {
 	return nil
 }
 
+// prevOutsFor gives the outputs each input of tx spends, which taproot
+// signing needs.  Errors if any of them isn't in utxos.
+func prevOutsFor(
+	tx *wire.MsgTx, utxos []*portxo.PorTxo) ([]*wire.TxOut, error) {
+
+	prevOuts := make([]*wire.TxOut, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		for _, u := range utxos {
+			if u.Op == in.PreviousOutPoint {
+				prevOuts[i] = wire.NewTxOut(u.Value, u.PkScript)
+				break
+			}
+		}
+		if prevOuts[i] == nil {
+			return nil, fmt.Errorf("can't sign taproot input: "+
+				"don't know the amount and script of input %s",
+				in.PreviousOutPoint.String())
+		}
+	}
+	return prevOuts, nil
+}
+
 // Build and sign builds a tx from a slice of utxos and txOuts.
 // It then signs all the inputs and returns the tx.  Should
 // pretty much always work for any inputs.
@@ -519,17 +603,16 @@ func (w *Wallit) BuildAndSign(
 	// add all the txins, first refenecing the prev outPoints
 	for i, u := range utxos {
 		tx.AddTxIn(wire.NewTxIn(&u.Op, nil, nil))
-		// set sequence field if it's in the portxo
-		if u.Seq > 1 {
-			tx.TxIn[i].Sequence = u.Seq
-		}
+		tx.TxIn[i].Sequence = txinSequence(u)
 	}
 	// sort txouts in place before signing.  txins are already sorted from above
 	txsort.InPlaceSort(tx)
 
-	w.SignMyInputs(tx)
+	// sign with the utxos we were given, which might not be in the utxo
+	// bucket anymore (like when replacing a tx)
+	w.signInputs(tx, utxos)
 
-	log.Printf("tx: %s", TxToString(tx))
+	log.Tracef("tx: %s", TxToString(tx))
 	return tx, nil
 }
 
@@ -549,6 +632,6 @@ func EstFee(txins []*portxo.PorTxo, outputByteSize, spB int64) int64 {
 		size += txin.EstSize()
 	}
 
-	log.Printf("%d spB, est vsize %d, fee %d\n", spB, size, size*spB)
+	log.Debugf("%d spB, est vsize %d, fee %d\n", spB, size, size*spB)
 	return size * spB
 }
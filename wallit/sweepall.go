@@ -0,0 +1,144 @@
+package wallit
+
+import (
+	"fmt"
+
+	"github.com/adiabat/btcd/blockchain"
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+// SweepAll spends every confirmed, spendable utxo in the wallet to outScript
+// in one tx, with no change.  The fee comes out of the amount sent, at
+// feeRate sat per byte; 0 means the wallet's fee rate.  Frozen and locked
+// utxos stay where they are.  Returns the txid.
+func (w *Wallit) SweepAll(outScript []byte, feeRate int64) (*chainhash.Hash, error) {
+	feeRate, err := w.checkFeeRate(feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	curHeight, err := w.GetDBSyncHeight()
+	if err != nil {
+		return nil, err
+	}
+	allUtxos, err := w.GetAllUtxos()
+	if err != nil {
+		return nil, err
+	}
+	locked, err := w.lockedOps()
+	if err != nil {
+		return nil, err
+	}
+
+	var utxos []*portxo.PorTxo
+	for _, u := range spendableUtxos(allUtxos, curHeight, false) {
+		_, frozen := w.FreezeSet[u.Op]
+		if u.Height < 1 || frozen || locked[u.Op] {
+			continue
+		}
+		utxos = append(utxos, u)
+	}
+	if len(utxos) == 0 {
+		return nil, fmt.Errorf("no confirmed utxos to sweep")
+	}
+
+	return w.sendAllTo(utxos, outScript, feeRate)
+}
+
+// SweepUtxo spends one utxo to a new address of ours, for ones that have to
+// be spent soon, like swap claims and refunds, rather than whenever coin
+// selection gets to them.  feeRate of 0 is the wallet's.  Returns the txid.
+func (w *Wallit) SweepUtxo(op wire.OutPoint, feeRate int64) (*chainhash.Hash, error) {
+	feeRate, err := w.checkFeeRate(feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	w.FreezeMutex.Lock()
+	defer w.FreezeMutex.Unlock()
+
+	curHeight, err := w.GetDBSyncHeight()
+	if err != nil {
+		return nil, err
+	}
+	allUtxos, err := w.GetAllUtxos()
+	if err != nil {
+		return nil, err
+	}
+	var u *portxo.PorTxo
+	for _, a := range allUtxos {
+		if a.Op == op {
+			u = a
+		}
+	}
+	if u == nil {
+		return nil, fmt.Errorf("%s isn't ours to spend", op.String())
+	}
+	if _, frozen := w.FreezeSet[op]; frozen {
+		return nil, fmt.Errorf("%s is frozen, can't spend", op.String())
+	}
+	if len(spendableUtxos([]*portxo.PorTxo{u}, curHeight, false)) == 0 {
+		return nil, fmt.Errorf("%s can't be spent yet", op.String())
+	}
+
+	adr160, err := w.NewAdr160()
+	if err != nil {
+		return nil, err
+	}
+	return w.sendAllTo([]*portxo.PorTxo{u},
+		lnutil.DirectWPKHScriptFromPKH(adr160), feeRate)
+}
+
+// sendAllTo spends utxos to outScript in one tx with no change, paying
+// feeRate out of the amount, and sends it.  Call with FreezeMutex held.
+func (w *Wallit) sendAllTo(utxos []*portxo.PorTxo,
+	outScript []byte, feeRate int64) (*chainhash.Hash, error) {
+	var err error
+	var inSum int64
+	for _, u := range utxos {
+		inSum += u.Value
+	}
+
+	// build with the estimated fee to see how big the signed tx is, then
+	// again paying for that size.  ECDSA sigs can come out a byte longer,
+	// so it might take another go.
+	fee := EstFee(utxos, 8+int64(len(outScript)), feeRate)
+	var tx *wire.MsgTx
+	for try := 0; ; try++ {
+		if inSum-fee < w.Param.DustLimit {
+			return nil, fmt.Errorf("%d in %d utxos, not enough to pay %d fee",
+				inSum, len(utxos), fee)
+		}
+		txout := wire.NewTxOut(inSum-fee, outScript)
+		tx, err = w.BuildAndSign(
+			utxos, []*wire.TxOut{txout}, uint32(w.CurrentHeight()))
+		if err != nil {
+			return nil, err
+		}
+		needFee := feeRate * blockchain.GetTxVirtualSize(btcutil.NewTx(tx))
+		if try > 0 && needFee <= fee {
+			break
+		}
+		if try > 3 {
+			return nil, fmt.Errorf("sweep fee didn't settle, last %d", needFee)
+		}
+		fee = needFee
+	}
+
+	txid := tx.TxHash()
+	log.Debugf("send all of %d utxos, %d sat, to %x: fee %d, tx %s\n",
+		len(utxos), inSum, outScript, fee, txid.String())
+
+	err = w.NewOutgoingTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return &txid, nil
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"sync"
 
 	"github.com/adiabat/btcd/blockchain"
@@ -12,18 +11,18 @@ import (
 	"github.com/adiabat/btcd/wire"
 	"github.com/adiabat/btcutil"
 	"github.com/adiabat/btcutil/hdkeychain"
-	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/chainhook"
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/portxo"
-	"github.com/mit-dci/lit/uspv"
 )
 
 // The Wallit is lit's main wallet struct.  It's got the root key, the dbs, and
 // contains the SPVhooks into the network.
 type Wallit struct {
 	// could get rid of adr slice, it's just an in-ram cache...
-	StateDB *bolt.DB // place to write all this down
+	StateDB kvdb.DB // place to write all this down
 
 	// Set of frozen utxos not to use... they point to the tx using em
 	FreezeSet   map[wire.OutPoint]*FrozenTx
@@ -32,6 +31,8 @@ type Wallit struct {
 	// OPEventChan sends events to the LN wallet.
 	// Gets initialized and activates when called by qln
 	OPEventChan chan lnutil.OutPointEvent
+	// HeightEventChan sends new blocks up the same way
+	HeightEventChan chan lnutil.HeightEvent
 
 	// Params live here...
 	Param *coinparam.Params // network parameters (testnet3, segnet, etc)
@@ -39,11 +40,36 @@ type Wallit struct {
 	// Hook is the connection to a blockchain.
 	// imports the uspv interface.  Could put that somewhere else.
 	// like an interfaces library, ... lnutil?
-	Hook uspv.ChainHook
+	Hook chainhook.ChainHook
 
-	// current fee per byte
+	// Signer signs for watch accounts, whose keys aren't here.  Can be nil.
+	Signer ExtSigner
+
+	// fee per byte set by the user; 0 means estimate it.  See FeeTarget.
 	FeeRate int64
 
+	// where fee estimates come from, and the ones gotten so far, by target
+	feeEst   chainhook.FeeEstimator
+	feeCache map[uint32]cachedFee
+	feeMtx   sync.Mutex
+
+	// push-tx services that important txs go out through too
+	pushServices []pushService
+	pushMtx      sync.Mutex
+
+	// coin selection strategy; empty means CoinSelectDefault
+	CoinSelectMode string
+
+	// consolidate utxos under consolidateBelow when blocks come in, if the
+	// fee rate is at most consolidateMaxFee.  0 below means don't.
+	consolidateBelow, consolidateMaxFee int64
+	consolidateMtx                      sync.Mutex
+
+	// rescan progress: going from rescanFrom back up to rescanTo, and got
+	// to rescanAt.  rescanTo is 0 when not rescanning.
+	rescanFrom, rescanAt, rescanTo int32
+	rescanMtx                      sync.Mutex
+
 	// From here, comes everything. It's a secret to everybody.
 	rootPrivKey *hdkeychain.ExtendedKey
 }
@@ -142,7 +168,7 @@ func StxoFromBytes(b []byte) (Stxo, error) {
 	// last 36 bytes are height & spend txid.
 	u, err := portxo.PorTxoFromBytes(b[:l-36])
 	if err != nil {
-		log.Printf(" eof? ")
+		log.Debugf(" eof? ")
 		return s, err
 	}
 
@@ -0,0 +1,494 @@
+package wallit
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
+	"github.com/adiabat/btcutil/base58"
+	"github.com/adiabat/btcutil/hdkeychain"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/lit/portxo"
+)
+
+/*
+Watch accounts are xpubs from some other wallet, like cold storage.  The
+wallit finds their txs and keeps their utxos, but has no keys for them, so
+they're kept apart from the wallit's own: never counted in its balance, never
+picked to spend.  Spending them means building a psbt for the wallet with
+the keys to sign.
+
+Keys come from the xpub the usual way, branch 0 for receiving and 1 for
+change, and there are always watchGap addresses past the last one used.
+Watch utxos are portxos with a keygen of depth 2: the branch and the index.
+
+watch txo serialization, the value in BKTWatchTxos:
+4	account number
+the rest of the portxo after the outpoint
+*/
+
+// watchGap is how many unused addresses to look out for on each branch.
+const watchGap = 20
+
+// ImportXpub starts watching an xpub.  fingerprint and path are where the
+// xpub is in the key tree of the wallet that'll sign for it; they go in the
+// psbts.  A zero fingerprint means the xpub is the root, and takes its own.
+// Returns the new account number.  Txs from before the import won't show up
+// without a rescan.
+func (w *Wallit) ImportXpub(
+	xpub string, fingerprint [4]byte, path []uint32) (uint32, error) {
+
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return 0, err
+	}
+	if len(path) > 255 {
+		return 0, fmt.Errorf("path has %d steps, max 255", len(path))
+	}
+	if key.IsPrivate() {
+		return 0, fmt.Errorf("that's a private key; watch accounts only take xpubs")
+	}
+	if !bytes.Equal(base58.Decode(xpub)[:4], w.Param.HDPublicKeyID[:]) {
+		return 0, fmt.Errorf("xpub isn't for %s", w.Param.Name)
+	}
+
+	if fingerprint == [4]byte{} {
+		pub, err := key.ECPubKey()
+		if err != nil {
+			return 0, err
+		}
+		copy(fingerprint[:], btcutil.Hash160(pub.SerializeCompressed()))
+		path = nil
+	}
+
+	a := lnutil.WatchAcct{
+		Xpub:        xpub,
+		Fingerprint: fingerprint,
+		Path:        path,
+	}
+	err = w.StateDB.Update(func(btx kvdb.Tx) error {
+		actb := btx.Bucket(BKTWatchAccts)
+		err := actb.ForEach(func(k, v []byte) error {
+			old, err := watchAcctFromBytes(lnutil.BtU32(k), v)
+			if err != nil {
+				return err
+			}
+			if old.Xpub == xpub {
+				return fmt.Errorf("already watching that xpub as account %d", old.Idx)
+			}
+			a.Idx = old.Idx
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// accounts count from 1
+		a.Idx++
+
+		err = actb.Put(lnutil.U32tB(a.Idx), watchAcctBytes(a))
+		if err != nil {
+			return err
+		}
+		for branch := uint32(0); branch < 2; branch++ {
+			err = w.extendWatchAdrs(btx, a, branch, 0, watchGap)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Debugf("watching xpub %s as account %d\n", xpub, a.Idx)
+	return a.Idx, nil
+}
+
+// WatchAccts returns all the watch accounts.
+func (w *Wallit) WatchAccts() ([]lnutil.WatchAcct, error) {
+	var accts []lnutil.WatchAcct
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTWatchAccts).ForEach(func(k, v []byte) error {
+			a, err := watchAcctFromBytes(lnutil.BtU32(k), v)
+			if err != nil {
+				return err
+			}
+			accts = append(accts, a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accts, nil
+}
+
+// WatchUtxos returns the utxos of a watch account.  0 means all of them.
+func (w *Wallit) WatchUtxos(acct uint32) ([]*portxo.PorTxo, error) {
+	var utxos []*portxo.PorTxo
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTWatchTxos).ForEach(func(k, v []byte) error {
+			if acct != 0 && lnutil.BtU32(v[:4]) != acct {
+				return nil
+			}
+			u, err := watchTxoFromKV(k, v)
+			if err != nil {
+				return err
+			}
+			utxos = append(utxos, u)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+// BuildPsbt makes an unsigned tx sending txos from a watch account's
+// confirmed utxos, with change back to the account, at feeRate sat per byte
+// (0 for the wallit's).  Nothing's frozen, so two psbts made before either
+// is sent may spend the same utxos.
+func (w *Wallit) BuildPsbt(
+	acct uint32, txos []*wire.TxOut, feeRate int64) (*lnutil.Psbt, error) {
+
+	feeRate, err := w.checkFeeRate(feeRate)
+	if err != nil {
+		return nil, err
+	}
+	var a lnutil.WatchAcct
+	err = w.StateDB.View(func(btx kvdb.Tx) error {
+		v := btx.Bucket(BKTWatchAccts).Get(lnutil.U32tB(acct))
+		if v == nil {
+			return fmt.Errorf("no watch account %d", acct)
+		}
+		var err error
+		a, err = watchAcctFromBytes(acct, v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var totalSend, outputByteSize int64
+	for _, txo := range txos {
+		totalSend += txo.Value
+		outputByteSize += 8 + int64(len(txo.PkScript))
+	}
+
+	var utxos portxo.TxoSliceByAmt
+	allUtxos, err := w.WatchUtxos(acct)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range allUtxos {
+		if u.Height > 0 {
+			utxos = append(utxos, u)
+		}
+	}
+	// sort biggest first, like the wallit's own "largest"
+	sort.Sort(sort.Reverse(utxos))
+	ins, remaining := accumulate(utxos, totalSend, outputByteSize, feeRate)
+	if remaining > 0 {
+		return nil, fmt.Errorf("wanted %d but account %d has %d confirmed",
+			totalSend, acct, totalSend-remaining)
+	}
+
+	// change goes to the next unused change address, which is then used
+	var changeBip32 *lnutil.PsbtBip32
+	if -remaining > w.Param.DustLimit {
+		var pub [33]byte
+		err = w.StateDB.Update(func(btx kvdb.Tx) error {
+			changeIdx := a.NextInt
+			err := w.useWatchAdr(btx, &a, 1, changeIdx)
+			if err != nil {
+				return err
+			}
+			pub, err = watchPub(a.Xpub, 1, changeIdx)
+			if err != nil {
+				return err
+			}
+			changeBip32 = &lnutil.PsbtBip32{
+				PubKey:      pub,
+				Fingerprint: a.Fingerprint,
+				Path:        append(append([]uint32{}, a.Path...), 1, changeIdx),
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		var changePKH [20]byte
+		copy(changePKH[:], btcutil.Hash160(pub[:]))
+		txos = append(txos, wire.NewTxOut(-remaining,
+			lnutil.DirectWPKHScriptFromPKH(changePKH)))
+	}
+
+	tx, err := w.BuildDontSign(ins, txos)
+	if err != nil {
+		return nil, err
+	}
+	p, err := lnutil.NewPsbt(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	byOp := make(map[wire.OutPoint]*portxo.PorTxo)
+	for _, u := range ins {
+		byOp[u.Op] = u
+	}
+	for i, txin := range tx.TxIn {
+		u := byOp[txin.PreviousOutPoint]
+		if u.Mode&portxo.FlagTxoWitness != 0 {
+			p.Inputs[i].WitnessUtxo = wire.NewTxOut(u.Value, u.PkScript)
+		} else {
+			// non-witness signers need the whole tx to know the amount
+			p.Inputs[i].NonWitnessUtxo, err = w.GetSavedTx(&u.Op.Hash)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pub, err := watchPub(a.Xpub, u.KeyGen.Step[0], u.KeyGen.Step[1])
+		if err != nil {
+			return nil, err
+		}
+		p.Inputs[i].Bip32 = []lnutil.PsbtBip32{{
+			PubKey:      pub,
+			Fingerprint: a.Fingerprint,
+			Path: append(append([]uint32{}, a.Path...),
+				u.KeyGen.Step[0], u.KeyGen.Step[1]),
+		}}
+	}
+	if changeBip32 != nil {
+		for i, txo := range tx.TxOut {
+			if bytes.Equal(txo.PkScript, txos[len(txos)-1].PkScript) {
+				p.Outputs[i].Bip32 = []lnutil.PsbtBip32{*changeBip32}
+			}
+		}
+	}
+
+	log.Debugf("psbt for account %d: %s", acct, TxToString(tx))
+	return p, nil
+}
+
+// WatchAdrDump returns all the addresses of all the watch accounts, to
+// give to the chainhook.
+func (w *Wallit) WatchAdrDump() ([][20]byte, error) {
+	var adrs [][20]byte
+	err := w.StateDB.View(func(btx kvdb.Tx) error {
+		return btx.Bucket(BKTWatchAdr).ForEach(func(k, v []byte) error {
+			var adr [20]byte
+			copy(adr[:], k)
+			adrs = append(adrs, adr)
+			return nil
+		})
+	})
+	return adrs, err
+}
+
+// extendWatchAdrs puts the addresses from index from up to to on a branch
+// of an account in the watch adr bucket, and tells the chainhook.
+func (w *Wallit) extendWatchAdrs(btx kvdb.Tx, a lnutil.WatchAcct,
+	branch, from, to uint32) error {
+
+	key, err := hdkeychain.NewKeyFromString(a.Xpub)
+	if err != nil {
+		return err
+	}
+	branchKey, err := key.Child(branch)
+	if err != nil {
+		return err
+	}
+	wadrb := btx.Bucket(BKTWatchAdr)
+	for i := from; i < to; i++ {
+		child, err := branchKey.Child(i)
+		if err == hdkeychain.ErrInvalidChild {
+			continue // bip32 says skip it; about 1 in 2^127
+		}
+		if err != nil {
+			return err
+		}
+		pub, err := child.ECPubKey()
+		if err != nil {
+			return err
+		}
+		var adr [20]byte
+		copy(adr[:], btcutil.Hash160(pub.SerializeCompressed()))
+
+		var v []byte
+		v = append(v, lnutil.U32tB(a.Idx)...)
+		v = append(v, lnutil.U32tB(branch)...)
+		v = append(v, lnutil.U32tB(i)...)
+		err = wadrb.Put(adr[:], v)
+		if err != nil {
+			return err
+		}
+		err = w.Hook.RegisterAddress(adr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// useWatchAdr notes that an index on a branch of an account has been used,
+// and if it's past the last used one, watches more addresses so there's
+// still a gap of watchGap.
+func (w *Wallit) useWatchAdr(btx kvdb.Tx, a *lnutil.WatchAcct,
+	branch, idx uint32) error {
+
+	next := &a.NextExt
+	if branch == 1 {
+		next = &a.NextInt
+	}
+	if idx < *next {
+		return nil
+	}
+	err := w.extendWatchAdrs(btx, *a, branch, *next+watchGap, idx+1+watchGap)
+	if err != nil {
+		return err
+	}
+	*next = idx + 1
+	return btx.Bucket(BKTWatchAccts).Put(lnutil.U32tB(a.Idx), watchAcctBytes(*a))
+}
+
+// ingestWatchTxo checks if a txout pays a watch account, and if so saves it
+// in the watch txo bucket.  Returns true if it did.
+func (w *Wallit) ingestWatchTxo(btx kvdb.Tx,
+	tx *wire.MsgTx, idx uint32, height int32) (bool, error) {
+
+	adrInfo := btx.Bucket(BKTWatchAdr).Get(
+		lnutil.KeyHashFromPkScript(tx.TxOut[idx].PkScript))
+	if len(adrInfo) != 12 {
+		return false, nil
+	}
+	acct := lnutil.BtU32(adrInfo[:4])
+	branch := lnutil.BtU32(adrInfo[4:8])
+	adrIdx := lnutil.BtU32(adrInfo[8:])
+
+	u, err := portxo.ExtractFromTx(tx, idx)
+	if err != nil {
+		return false, err
+	}
+	u.Height = height
+	u.KeyGen.Depth = 2
+	u.KeyGen.Step[0] = branch
+	u.KeyGen.Step[1] = adrIdx
+	txob, err := u.Bytes()
+	if err != nil {
+		return false, err
+	}
+
+	if btx.Bucket(BKTWatchStxos).Get(txob[:36]) != nil {
+		return false, nil // already spent
+	}
+	wtxb := btx.Bucket(BKTWatchTxos)
+	if wtxb.Get(txob[:36]) == nil {
+		err = w.Hook.RegisterOutPoint(u.Op)
+		if err != nil {
+			return false, err
+		}
+	}
+	err = wtxb.Put(txob[:36], append(lnutil.U32tB(acct), txob[36:]...))
+	if err != nil {
+		return false, err
+	}
+
+	v := btx.Bucket(BKTWatchAccts).Get(lnutil.U32tB(acct))
+	a, err := watchAcctFromBytes(acct, v)
+	if err != nil {
+		return false, err
+	}
+	return true, w.useWatchAdr(btx, &a, branch, adrIdx)
+}
+
+// spendWatchTxo moves a watch utxo to the spent bucket, if op is one.
+// Returns true if it was.
+func spendWatchTxo(btx kvdb.Tx, op [36]byte, spendTxid []byte) (bool, error) {
+	wtxb := btx.Bucket(BKTWatchTxos)
+	if wtxb.Get(op[:]) == nil {
+		return false, nil
+	}
+	err := btx.Bucket(BKTWatchStxos).Put(op[:], spendTxid)
+	if err != nil {
+		return false, err
+	}
+	return true, wtxb.Delete(op[:])
+}
+
+// watchPub derives the pubkey at branch / idx under an xpub.
+func watchPub(xpub string, branch, idx uint32) ([33]byte, error) {
+	var pubArr [33]byte
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return pubArr, err
+	}
+	key, err = key.Child(branch)
+	if err != nil {
+		return pubArr, err
+	}
+	key, err = key.Child(idx)
+	if err != nil {
+		return pubArr, err
+	}
+	pub, err := key.ECPubKey()
+	if err != nil {
+		return pubArr, err
+	}
+	copy(pubArr[:], pub.SerializeCompressed())
+	return pubArr, nil
+}
+
+// watchTxoFromKV turns a key and value from the watch txo bucket into a
+// portxo, dropping the account number.
+func watchTxoFromKV(k, v []byte) (*portxo.PorTxo, error) {
+	if len(v) < 4 {
+		return nil, fmt.Errorf("watch txo %x is %d bytes", k, len(v))
+	}
+	x := make([]byte, len(k)+len(v)-4)
+	copy(x, k)
+	copy(x[len(k):], v[4:])
+	return portxo.PorTxoFromBytes(x)
+}
+
+/* watch account serialization, the value in BKTWatchAccts:
+4	fingerprint
+4	next receive index
+4	next change index
+1	path length n
+4n	path
+	the rest is the xpub string
+*/
+
+func watchAcctBytes(a lnutil.WatchAcct) []byte {
+	var buf bytes.Buffer
+	buf.Write(a.Fingerprint[:])
+	buf.Write(lnutil.U32tB(a.NextExt))
+	buf.Write(lnutil.U32tB(a.NextInt))
+	buf.WriteByte(uint8(len(a.Path)))
+	for _, step := range a.Path {
+		buf.Write(lnutil.U32tB(step))
+	}
+	buf.WriteString(a.Xpub)
+	return buf.Bytes()
+}
+
+func watchAcctFromBytes(idx uint32, b []byte) (lnutil.WatchAcct, error) {
+	var a lnutil.WatchAcct
+	if len(b) < 13 || len(b) < 13+4*int(b[12]) {
+		return a, fmt.Errorf("watch account %d is %d bytes", idx, len(b))
+	}
+	a.Idx = idx
+	copy(a.Fingerprint[:], b[:4])
+	a.NextExt = lnutil.BtU32(b[4:8])
+	a.NextInt = lnutil.BtU32(b[8:12])
+	pathLen := int(b[12])
+	for i := 0; i < pathLen; i++ {
+		a.Path = append(a.Path, lnutil.BtU32(b[13+4*i:17+4*i]))
+	}
+	a.Xpub = string(b[13+4*pathLen:])
+	return a, nil
+}
@@ -0,0 +1,237 @@
+package watchtower
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+// DefaultBumpBlocks is how many blocks a justice tx gets to confirm before
+// it's replaced with the next higher fee version.
+const DefaultBumpBlocks = 6
+
+// pendingJustice is a justice tx we've broadcast and are waiting on.
+// Txs are every version we can send, lowest fee first; Level is the one
+// that's out now.
+// CoinType 4
+// Level 1
+// Waited 4
+// Txs (length 4, tx) each (rest)
+type pendingJustice struct {
+	CoinType uint32
+	Level    uint8
+	Waited   int32 // blocks since Txs[Level] went out
+	Txs      []*wire.MsgTx
+}
+
+func (pj *pendingJustice) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, pj.CoinType)
+	buf.WriteByte(pj.Level)
+	binary.Write(&buf, binary.BigEndian, pj.Waited)
+	for _, tx := range pj.Txs {
+		var txBuf bytes.Buffer
+		err := tx.Serialize(&txBuf)
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(txBuf.Len()))
+		buf.Write(txBuf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+func pendingJusticeFromBytes(b []byte) (pendingJustice, error) {
+	var pj pendingJustice
+	if len(b) < 9 {
+		return pj, fmt.Errorf("pendingJustice %d bytes, expect at least 9", len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	_ = binary.Read(buf, binary.BigEndian, &pj.CoinType)
+	pj.Level, _ = buf.ReadByte()
+	_ = binary.Read(buf, binary.BigEndian, &pj.Waited)
+	for buf.Len() > 0 {
+		var txLen uint32
+		err := binary.Read(buf, binary.BigEndian, &txLen)
+		if err != nil {
+			return pj, err
+		}
+		if int(txLen) > buf.Len() {
+			return pj, fmt.Errorf("pendingJustice tx %d bytes, only %d left",
+				txLen, buf.Len())
+		}
+		tx := wire.NewMsgTx()
+		err = tx.Deserialize(bytes.NewReader(buf.Next(int(txLen))))
+		if err != nil {
+			return pj, err
+		}
+		pj.Txs = append(pj.Txs, tx)
+	}
+	if int(pj.Level) >= len(pj.Txs) {
+		return pj, fmt.Errorf("pendingJustice at level %d, only %d txs",
+			pj.Level, len(pj.Txs))
+	}
+	return pj, nil
+}
+
+// SetBumpBlocks changes how long justice txs get to confirm before their
+// fee is bumped.  0 or less means the default.
+func (w *WatchTower) SetBumpBlocks(blocks int32) {
+	w.BumpBlocks = blocks
+}
+
+func (w *WatchTower) bumpBlocks() int32 {
+	if w.BumpBlocks <= 0 {
+		return DefaultBumpBlocks
+	}
+	return w.BumpBlocks
+}
+
+// sendJustice broadcasts the lowest fee version of a justice tx, and keeps
+// the rest around in case it doesn't confirm.
+func (w *WatchTower) sendJustice(cointype uint32, txs []*wire.MsgTx) error {
+	if len(txs) == 0 {
+		return fmt.Errorf("no justice txs to send")
+	}
+	opBytes := lnutil.OutPointToBytes(txs[0].TxIn[0].PreviousOutPoint)
+	err := w.WatchDB.Update(func(btx kvdb.Tx) error {
+		pend := btx.Bucket(BUCKETPending)
+		if pend == nil {
+			return fmt.Errorf("no pending justice bucket")
+		}
+		// seen the bad tx before, like in a reorg; keep the fee we're at
+		if pend.Get(opBytes[:]) != nil {
+			return nil
+		}
+		pj := pendingJustice{CoinType: cointype, Txs: txs}
+		pjBytes, err := pj.Bytes()
+		if err != nil {
+			return err
+		}
+		return pend.Put(opBytes[:], pjBytes)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("made & sent out justice tx %s, %d fee bumps ready\n",
+		txs[0].TxHash().String(), len(txs)-1)
+	atomic.AddInt64(&w.justiceSent, 1)
+	return w.Hooks[cointype].PushTx(txs[0])
+}
+
+// JusticeSent is how many justice txs the tower has sent since startup,
+// not counting fee bumps.
+func (w *WatchTower) JusticeSent() int64 {
+	return atomic.LoadInt64(&w.justiceSent)
+}
+
+// bumpJustice goes through the justice txs we're waiting on when a block
+// comes in.  Ones whose bad output the block spends are done, whether it
+// was us or not, and get settled till the block is depth deep.  Ones that
+// have waited long enough get replaced by the next higher fee version, or
+// rebroadcast if there isn't one.
+func (w *WatchTower) bumpJustice(
+	cointype uint32, block *wire.MsgBlock, depth int32) error {
+
+	blockHash := block.BlockHash()
+	spent := make(map[wire.OutPoint]bool)
+	for _, tx := range block.Transactions {
+		for _, in := range tx.TxIn {
+			spent[in.PreviousOutPoint] = true
+		}
+	}
+
+	var push []*wire.MsgTx
+	err := w.WatchDB.Update(func(btx kvdb.Tx) error {
+		pend := btx.Bucket(BUCKETPending)
+		if pend == nil {
+			return fmt.Errorf("no pending justice bucket")
+		}
+		err := buryJustice(btx, cointype, depth)
+		if err != nil {
+			return err
+		}
+
+		// can't change the bucket while going through it, so collect changes
+		var dels [][]byte
+		puts := make(map[string][]byte)
+		settles := make(map[string][]byte)
+		err = pend.ForEach(func(k, v []byte) error {
+			pj, err := pendingJusticeFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if pj.CoinType != cointype {
+				return nil
+			}
+			badOP := pj.Txs[0].TxIn[0].PreviousOutPoint
+			if spent[badOP] {
+				log.Printf("revoked output %s spent, done with its justice\n",
+					badOP.String())
+				dels = append(dels, append([]byte(nil), k...))
+				settles[string(k)] = settledJusticeBytes(blockHash, 0, v)
+				return nil
+			}
+
+			pj.Waited++
+			if pj.Waited >= w.bumpBlocks() {
+				pj.Waited = 0
+				if int(pj.Level)+1 < len(pj.Txs) {
+					pj.Level++
+					log.Printf("justice for %s not in after %d blocks, bumping to level %d\n",
+						badOP.String(), w.bumpBlocks(), pj.Level)
+				}
+				push = append(push, pj.Txs[pj.Level])
+			}
+			pjBytes, err := pj.Bytes()
+			if err != nil {
+				return err
+			}
+			puts[string(k)] = pjBytes
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range dels {
+			err = pend.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		for k, v := range puts {
+			err = pend.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		settled := btx.Bucket(BUCKETSettled)
+		for k, v := range settles {
+			err = settled.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range push {
+		log.Printf("sending justice tx %s\n", tx.TxHash().String())
+		err = w.Hooks[cointype].PushTx(tx)
+		if err != nil {
+			log.Printf("bumpJustice PushTx error: %s\n", err.Error())
+		}
+	}
+	return nil
+}
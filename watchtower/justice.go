@@ -7,8 +7,8 @@ import (
 
 	"github.com/adiabat/btcd/txscript"
 	"github.com/adiabat/btcd/wire"
-	"github.com/boltdb/bolt"
 	"github.com/mit-dci/lit/elkrem"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
 	"github.com/mit-dci/lit/sig64"
 )
@@ -28,7 +28,7 @@ func (w *WatchTower) BuildJusticeTx(
 	var iSig *IdxSig
 
 	// open DB and get static channel info
-	err = w.WatchDB.View(func(btx *bolt.Tx) error {
+	err = w.WatchDB.View(func(btx kvdb.Tx) error {
 		// get
 		// open the big bucket
 		txidbkt := btx.Bucket(BUCKETTxid)
@@ -116,8 +116,78 @@ func (w *WatchTower) BuildJusticeTx(
 	Revkey := lnutil.CombinePubs(wd.CustomerBasePoint, elkPoint)
 
 	log.Printf("tower build revpub %x \ntimeoutpub %x\n", Revkey, TimeoutKey)
+
+	return buildJustice(badTx, Revkey, TimeoutKey, wd.Delay, wd.Fee,
+		wd.DestPKHScript, iSig.Sig)
+}
+
+// BuildBlobJusticeTxs builds the justice txs for badTx from an encrypted
+// blob filed under its hint: one at the blob's fee, then one for each fee
+// bump the client signed.  Any client could have put a blob under the
+// hint, so try them all until one decrypts.
+func (w *WatchTower) BuildBlobJusticeTxs(badTx *wire.MsgTx) ([]*wire.MsgTx, error) {
+	txid := badTx.TxHash()
+	var blobs [][]byte
+	err := w.WatchDB.View(func(btx kvdb.Tx) error {
+		blobbkt := btx.Bucket(BUCKETBlob)
+		if blobbkt == nil {
+			return fmt.Errorf("no blob bucket")
+		}
+		hintBkt := blobbkt.Bucket(txid[:16])
+		if hintBkt == nil {
+			return fmt.Errorf("no blobs for txid %s", txid.String())
+		}
+		return hintBkt.ForEach(func(_, blob []byte) error {
+			blobs = append(blobs, append([]byte(nil), blob...))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blob := range blobs {
+		jb, err := lnutil.DecryptJusticeBlob(txid, blob)
+		if err != nil {
+			log.Printf("%s\n", err.Error())
+			continue
+		}
+		var txs []*wire.MsgTx
+		for level := 0; level <= len(jb.BumpSigs); level++ {
+			justice, err := buildJustice(badTx, jb.RevPub, jb.TimeoutPub,
+				jb.Delay, jb.BumpFee(level), jb.DestPKH, jb.LevelSig(level))
+			if err != nil {
+				return nil, err
+			}
+			txs = append(txs, justice)
+		}
+		return txs, nil
+	}
+	return nil, fmt.Errorf("no blob for %s decrypts", txid.String())
+}
+
+// justiceFor builds the justice txs for badTx, from whichever kind of data
+// we have for it, lowest fee first.  States sent the old way only have one.
+func (w *WatchTower) justiceFor(
+	cointype uint32, badTx *wire.MsgTx) ([]*wire.MsgTx, error) {
+	txs, err := w.BuildBlobJusticeTxs(badTx)
+	if err == nil {
+		return txs, nil
+	}
+	justice, err := w.BuildJusticeTx(cointype, badTx)
+	if err != nil {
+		return nil, err
+	}
+	return []*wire.MsgTx{justice}, nil
+}
+
+// buildJustice makes the tx grabbing the revocable output of badTx, once
+// we know the keys in the commitment script.
+func buildJustice(badTx *wire.MsgTx, Revkey, TimeoutKey [33]byte,
+	delay uint16, fee int64, destPKH [20]byte, sig [64]byte) (*wire.MsgTx, error) {
+
 	// build script from the two combined pubkeys and the channel delay
-	script := lnutil.CommitScript(Revkey, TimeoutKey, wd.Delay)
+	script := lnutil.CommitScript(Revkey, TimeoutKey, delay)
 
 	// get P2WSH output script
 	shOutputScript := lnutil.P2WSHify(script)
@@ -140,8 +210,8 @@ func (w *WatchTower) BuildJusticeTx(
 		return nil, fmt.Errorf("couldn't match generated script with detected txout")
 	}
 
-	justiceAmt := badTx.TxOut[txoutNum].Value - wd.Fee
-	justicePkScript := lnutil.DirectWPKHScriptFromPKH(wd.DestPKHScript)
+	justiceAmt := badTx.TxOut[txoutNum].Value - fee
+	justicePkScript := lnutil.DirectWPKHScriptFromPKH(destPKH)
 	// build the JusticeTX.  First the output
 	justiceOut := wire.NewTxOut(justiceAmt, justicePkScript)
 	// now the input
@@ -149,7 +219,7 @@ func (w *WatchTower) BuildJusticeTx(
 	badOP := wire.NewOutPoint(&badtxid, uint32(txoutNum))
 	justiceIn := wire.NewTxIn(badOP, nil, nil)
 	// expand the sig back to 71 bytes
-	bigSig := sig64.SigDecompress(iSig.Sig)
+	bigSig := sig64.SigDecompress(sig)
 	bigSig = append(bigSig, byte(txscript.SigHashAll)) // put sighash_all byte on at the end
 
 	justiceIn.Sequence = 1                // sequence 1 means grab immediately
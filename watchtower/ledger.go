@@ -0,0 +1,210 @@
+package watchtower
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+Towers can charge for watching.  Clients pay by pushing to the tower in a
+channel they have with it, and the tower keeps a running account for each
+client in the ledger bucket:
+
+LedgerBucket is k:v
+peerIdx : Account (28 bytes)
+
+CreditedBucket is k:v
+channel outpoint (36 bytes) : last state a payment was credited for (8)
+
+Each new channel costs PerChannel and each state PerState, taken from
+the account as the messages come in.  A client without enough credit left
+gets its messages dropped.  A payment is only credited once for the state
+it came in, so crediting again after a crash doesn't count it twice.
+*/
+
+var (
+	BUCKETLedger   = []byte("ldg") // bucket for client accounts
+	BUCKETCredited = []byte("crd") // last state credited, by channel
+)
+
+// FeeTerms are what the tower charges, in satoshis.  Zero is free.
+type FeeTerms struct {
+	PerChannel int64
+	PerState   int64
+}
+
+// Account is how much a client has paid the tower and used up.
+type Account struct {
+	PeerIdx  uint32
+	Paid     int64
+	Spent    int64
+	Channels uint32
+	States   uint64
+}
+
+// Balance is how much credit the client has left.
+func (a *Account) Balance() int64 {
+	return a.Paid - a.Spent
+}
+
+// Accounts are 28 bytes
+// Paid 8
+// Spent 8
+// Channels 4
+// States 8
+func (a *Account) Bytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, a.Paid)
+	binary.Write(&buf, binary.BigEndian, a.Spent)
+	binary.Write(&buf, binary.BigEndian, a.Channels)
+	binary.Write(&buf, binary.BigEndian, a.States)
+	return buf.Bytes()
+}
+
+func AccountFromBytes(b []byte, peerIdx uint32) (Account, error) {
+	var a Account
+	a.PeerIdx = peerIdx
+	if len(b) != 28 {
+		return a, fmt.Errorf("AccountFromBytes got %d bytes, expect 28", len(b))
+	}
+	buf := bytes.NewBuffer(b)
+	_ = binary.Read(buf, binary.BigEndian, &a.Paid)
+	_ = binary.Read(buf, binary.BigEndian, &a.Spent)
+	_ = binary.Read(buf, binary.BigEndian, &a.Channels)
+	_ = binary.Read(buf, binary.BigEndian, &a.States)
+	return a, nil
+}
+
+// SetTerms changes what the tower charges from now on.
+func (w *WatchTower) SetTerms(t FeeTerms) {
+	w.termsMtx.Lock()
+	w.terms = t
+	w.termsMtx.Unlock()
+}
+
+// GetTerms returns what the tower charges.
+func (w *WatchTower) GetTerms() FeeTerms {
+	w.termsMtx.Lock()
+	defer w.termsMtx.Unlock()
+	return w.terms
+}
+
+// loadAccount reads a client's account; a client we haven't seen has an
+// empty one.
+func loadAccount(btx kvdb.Tx, peerIdx uint32) (Account, error) {
+	ldg := btx.Bucket(BUCKETLedger)
+	if ldg == nil {
+		return Account{PeerIdx: peerIdx}, fmt.Errorf("no ledger bucket")
+	}
+	b := ldg.Get(lnutil.U32tB(peerIdx))
+	if b == nil {
+		return Account{PeerIdx: peerIdx}, nil
+	}
+	return AccountFromBytes(b, peerIdx)
+}
+
+func saveAccount(btx kvdb.Tx, a Account) error {
+	ldg := btx.Bucket(BUCKETLedger)
+	if ldg == nil {
+		return fmt.Errorf("no ledger bucket")
+	}
+	return ldg.Put(lnutil.U32tB(a.PeerIdx), a.Bytes())
+}
+
+// charge takes the fee for a new channel or a new state out of the
+// client's account, or errors if there's not enough left.
+func (w *WatchTower) charge(btx kvdb.Tx, peerIdx uint32, newChan bool) error {
+	terms := w.GetTerms()
+	fee := terms.PerState
+	if newChan {
+		fee = terms.PerChannel
+	}
+	a, err := loadAccount(btx, peerIdx)
+	if err != nil {
+		return err
+	}
+	if a.Balance() < fee {
+		return fmt.Errorf("peer %d has %d credit, need %d", peerIdx, a.Balance(), fee)
+	}
+	a.Spent += fee
+	if newChan {
+		a.Channels++
+	} else {
+		a.States++
+	}
+	return saveAccount(btx, a)
+}
+
+// Credit adds a payment from a client to their account, made in state
+// stateIdx of the channel at chanOp.  Payments from a state that's already
+// been credited are ignored.
+func (w *WatchTower) Credit(
+	peerIdx uint32, amt int64, chanOp [36]byte, stateIdx uint64) error {
+	if w.WatchDB == nil {
+		return fmt.Errorf("tower not running")
+	}
+	return w.WatchDB.Update(func(btx kvdb.Tx) error {
+		crd := btx.Bucket(BUCKETCredited)
+		if crd == nil {
+			return fmt.Errorf("no credited bucket")
+		}
+		last := crd.Get(chanOp[:])
+		if last != nil && lnutil.BtU64(last) >= stateIdx {
+			log.Printf("peer %d already credited for state %d\n", peerIdx, stateIdx)
+			return nil
+		}
+		a, err := loadAccount(btx, peerIdx)
+		if err != nil {
+			return err
+		}
+		a.Paid += amt
+		log.Printf("peer %d paid tower %d, balance %d\n", peerIdx, amt, a.Balance())
+		err = saveAccount(btx, a)
+		if err != nil {
+			return err
+		}
+		return crd.Put(chanOp[:], lnutil.U64tB(stateIdx))
+	})
+}
+
+// GetAccount returns one client's account.
+func (w *WatchTower) GetAccount(peerIdx uint32) (Account, error) {
+	var a Account
+	if w.WatchDB == nil {
+		return a, fmt.Errorf("tower not running")
+	}
+	err := w.WatchDB.View(func(btx kvdb.Tx) error {
+		var err error
+		a, err = loadAccount(btx, peerIdx)
+		return err
+	})
+	return a, err
+}
+
+// Ledger returns every client's account.
+func (w *WatchTower) Ledger() ([]Account, error) {
+	var accts []Account
+	if w.WatchDB == nil {
+		return nil, fmt.Errorf("tower not running")
+	}
+	err := w.WatchDB.View(func(btx kvdb.Tx) error {
+		ldg := btx.Bucket(BUCKETLedger)
+		if ldg == nil {
+			return fmt.Errorf("no ledger bucket")
+		}
+		return ldg.ForEach(func(k, v []byte) error {
+			a, err := AccountFromBytes(v, lnutil.BtU32(k))
+			if err != nil {
+				return err
+			}
+			accts = append(accts, a)
+			return nil
+		})
+	})
+	return accts, err
+}
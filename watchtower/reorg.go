@@ -0,0 +1,193 @@
+package watchtower
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/adiabat/btcd/chaincfg/chainhash"
+	"github.com/adiabat/btcd/wire"
+	"github.com/mit-dci/lit/kvdb"
+	"github.com/mit-dci/lit/lnutil"
+)
+
+/*
+A justice tx is done with once a block spends the revoked output it goes
+after.  But that block can get reorged out, and the bad tx or the justice
+tx with it, so the justice isn't forgotten right away; it goes to the
+settled bucket along with the hash of the block that spent the output.  Once
+that block is as deep as the coin's deepest reorg, it's forgotten.
+
+If a block comes in that doesn't build on the last one, the blocks after its
+parent are gone.  Justice settled in them goes back to pending, and is sent
+out again.  If the bad tx shows up again in the new chain, it's already
+pending, so nothing new gets built.
+
+settled value:
+32	hash of the block the revoked output got spent in
+4	blocks on top of that block
+	the rest is the pending justice
+*/
+
+func settledJusticeBytes(hash chainhash.Hash, buried int32, pjBytes []byte) []byte {
+	b := make([]byte, 0, 36+len(pjBytes))
+	b = append(b, hash[:]...)
+	b = append(b, lnutil.I32tB(buried)...)
+	return append(b, pjBytes...)
+}
+
+func settledJusticeFromBytes(b []byte) (
+	chainhash.Hash, int32, pendingJustice, error) {
+
+	var hash chainhash.Hash
+	if len(b) < 36 {
+		return hash, 0, pendingJustice{},
+			fmt.Errorf("settled justice %d bytes, expect at least 36", len(b))
+	}
+	copy(hash[:], b[:32])
+	pj, err := pendingJusticeFromBytes(b[36:])
+	return hash, lnutil.BtI32(b[32:36]), pj, err
+}
+
+// followChain adds a block to recent, the hashes of the last depth blocks.
+// If the block doesn't build on the last one, the hashes after its parent
+// are taken off and returned.  If its parent isn't in recent at all,
+// there's no telling, so recent starts over.
+func followChain(recent []chainhash.Hash, hdr wire.BlockHeader,
+	depth int32) ([]chainhash.Hash, []chainhash.Hash) {
+
+	var gone []chainhash.Hash
+	if len(recent) != 0 && !recent[len(recent)-1].IsEqual(&hdr.PrevBlock) {
+		i := len(recent) - 1
+		for i >= 0 && !recent[i].IsEqual(&hdr.PrevBlock) {
+			i--
+		}
+		if i < 0 {
+			log.Printf("block %s doesn't build on the last %d; starting over\n",
+				hdr.BlockHash().String(), len(recent))
+			recent = nil
+		} else {
+			gone = append(gone, recent[i+1:]...)
+			recent = recent[:i+1]
+			log.Printf("reorg; %d blocks gone\n", len(gone))
+		}
+	}
+	recent = append(recent, hdr.BlockHash())
+	if int32(len(recent)) > depth {
+		recent = recent[int32(len(recent))-depth:]
+	}
+	return recent, gone
+}
+
+// buryJustice counts another block on top of the settled justice of a
+// coin, and forgets what's deep enough that no reorg we follow can undo it.
+func buryJustice(btx kvdb.Tx, cointype uint32, depth int32) error {
+	settled := btx.Bucket(BUCKETSettled)
+	if settled == nil {
+		return fmt.Errorf("no settled justice bucket")
+	}
+
+	var dels [][]byte
+	puts := make(map[string][]byte)
+	err := settled.ForEach(func(k, v []byte) error {
+		hash, buried, pj, err := settledJusticeFromBytes(v)
+		if err != nil {
+			return err
+		}
+		if pj.CoinType != cointype {
+			return nil
+		}
+		buried++
+		if buried >= depth {
+			dels = append(dels, append([]byte(nil), k...))
+			return nil
+		}
+		puts[string(k)] = settledJusticeBytes(hash, buried, v[36:])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range dels {
+		err = settled.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range puts {
+		err = settled.Put([]byte(k), v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rearmJustice puts justice settled in blocks that got reorged out back in
+// pending, and sends it out again.
+func (w *WatchTower) rearmJustice(cointype uint32, gone []chainhash.Hash) error {
+	goneSet := make(map[chainhash.Hash]bool)
+	for _, hash := range gone {
+		goneSet[hash] = true
+	}
+
+	var push []*wire.MsgTx
+	err := w.WatchDB.Update(func(btx kvdb.Tx) error {
+		settled := btx.Bucket(BUCKETSettled)
+		if settled == nil {
+			return fmt.Errorf("no settled justice bucket")
+		}
+		pend := btx.Bucket(BUCKETPending)
+		if pend == nil {
+			return fmt.Errorf("no pending justice bucket")
+		}
+
+		rearms := make(map[string][]byte)
+		err := settled.ForEach(func(k, v []byte) error {
+			hash, _, pj, err := settledJusticeFromBytes(v)
+			if err != nil {
+				return err
+			}
+			if pj.CoinType != cointype || !goneSet[hash] {
+				return nil
+			}
+			log.Printf("spend of revoked output %s reorged out; justice is back on\n",
+				pj.Txs[0].TxIn[0].PreviousOutPoint.String())
+			pj.Waited = 0
+			pjBytes, err := pj.Bytes()
+			if err != nil {
+				return err
+			}
+			rearms[string(k)] = pjBytes
+			push = append(push, pj.Txs[pj.Level])
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range rearms {
+			err = settled.Delete([]byte(k))
+			if err != nil {
+				return err
+			}
+			err = pend.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range push {
+		log.Printf("sending justice tx %s again\n", tx.TxHash().String())
+		err = w.Hooks[cointype].PushTx(tx)
+		if err != nil {
+			log.Printf("rearmJustice PushTx error: %s\n", err.Error())
+		}
+	}
+	return nil
+}
@@ -1,15 +1,17 @@
 package watchtower
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 
 	"github.com/adiabat/btcd/chaincfg/chainhash"
 	"github.com/adiabat/btcd/wire"
+	"github.com/adiabat/btcutil"
 	"github.com/mit-dci/lit/elkrem"
 	"github.com/mit-dci/lit/lnutil"
 
-	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/kvdb"
 )
 
 /*
@@ -36,6 +38,23 @@ the big one:
 TxidBucket is k:v
 Txid[:16] : IdxSig (74 bytes)
 
+and for clients who send encrypted blobs instead of channel descriptions and
+states, the other big one:
+
+BlobBucket is full of hint sub-buckets
+Txid[:16] (lots)
+  |
+  |-peerIdx : encrypted JusticeBlob (176 bytes + 64 per fee bump)
+
+Blobs are kept per client so that one client can't overwrite another's by
+sending junk with the same hint (the counterparty knows the txid too).
+
+Justice txs we've broadcast but haven't seen confirm go in a small bucket,
+so their fees can be bumped:
+
+PendingBucket is k:v
+bad outpoint (36 bytes) : pendingJustice
+
 TODO: both ComMsgs and IdxSigs need to support multiple signatures for HTLCs.
 What's nice is that this is the *only* thing needed to support HTLCs.
 
@@ -67,6 +86,9 @@ var (
 	BUCKETPKHMap   = []byte("pkm") // bucket for idx:pkh mapping
 	BUCKETChandata = []byte("cda") // bucket for channel data (elks, points)
 	BUCKETTxid     = []byte("txi") // big bucket with every txid
+	BUCKETBlob     = []byte("blb") // big bucket with every blob
+	BUCKETPending  = []byte("pjt") // justice txs waiting to confirm
+	BUCKETSettled  = []byte("sjt") // justice done with, till it's buried
 
 	KEYStatic = []byte("sta") // static per channel data as value
 	KEYElkRcv = []byte("elk") // elkrem receiver
@@ -77,12 +99,12 @@ var (
 func (w *WatchTower) OpenDB(filepath string) error {
 	var err error
 
-	w.WatchDB, err = bolt.Open(filepath, 0644, nil)
+	w.WatchDB, err = kvdb.Open(filepath, 0644)
 	if err != nil {
 		return err
 	}
 	// create buckets if they're not already there
-	err = w.WatchDB.Update(func(btx *bolt.Tx) error {
+	err = w.WatchDB.Update(func(btx kvdb.Tx) error {
 		_, err := btx.CreateBucketIfNotExists(BUCKETPKHMap)
 		if err != nil {
 			return err
@@ -91,12 +113,32 @@ func (w *WatchTower) OpenDB(filepath string) error {
 		if err != nil {
 			return err
 		}
+		_, err = btx.CreateBucketIfNotExists(BUCKETLedger)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BUCKETCredited)
+		if err != nil {
+			return err
+		}
 		txidBkt, err := btx.CreateBucketIfNotExists(BUCKETTxid)
 		if err != nil {
 			return err
 		}
+		blobBkt, err := btx.CreateBucketIfNotExists(BUCKETBlob)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BUCKETPending)
+		if err != nil {
+			return err
+		}
+		_, err = btx.CreateBucketIfNotExists(BUCKETSettled)
+		if err != nil {
+			return err
+		}
 		// if there are txids in the bucket, set watching to true
-		if txidBkt.Stats().KeyN != 0 {
+		if txidBkt.Stats().KeyN != 0 || blobBkt.Stats().BucketN > 1 {
 			w.Watching = true
 		}
 		return nil
@@ -120,7 +162,7 @@ func (w *WatchTower) NewChannel(m lnutil.WatchDescMsg) error {
 	// TODO change it so the user first requests supported cointypes,
 	// then sends the DescMsg without indicating cointype
 
-	return w.WatchDB.Update(func(btx *bolt.Tx) error {
+	return w.WatchDB.Update(func(btx kvdb.Tx) error {
 		// open index : pkh mapping bucket
 		mapBucket := btx.Bucket(BUCKETPKHMap)
 		if mapBucket == nil {
@@ -141,11 +183,21 @@ func (w *WatchTower) NewChannel(m lnutil.WatchDescMsg) error {
 		if allChanbkt == nil {
 			return fmt.Errorf("no Chandata bucket")
 		}
+		// clients resend descriptions we didn't ack; already having it is ok
+		old := allChanbkt.Bucket(m.DestPKHScript[:])
+		if old != nil && bytes.Equal(old.Get(KEYStatic), m.Bytes()[:96]) {
+			return nil
+		}
 		// make new channel bucket
 		chanBucket, err := allChanbkt.CreateBucket(m.DestPKHScript[:])
 		if err != nil {
 			return err
 		}
+		// take the fee; if they can't pay, the whole thing is rolled back
+		err = w.charge(btx, m.PeerIdx, true)
+		if err != nil {
+			return err
+		}
 		// save truncated descriptor for static info (drop elk0)
 		wdBytes := m.Bytes()
 		if len(wdBytes) < 96 {
@@ -173,7 +225,17 @@ func (w *WatchTower) NewChannel(m lnutil.WatchDescMsg) error {
 // optimization would be to add a bunch of messages at once.  Not a huge speedup though.
 func (w *WatchTower) UpdateChannel(m lnutil.WatchStateMsg) error {
 
-	return w.WatchDB.Update(func(btx *bolt.Tx) error {
+	return w.WatchDB.Update(func(btx kvdb.Tx) error {
+
+		txidbkt := btx.Bucket(BUCKETTxid)
+		if txidbkt == nil {
+			return fmt.Errorf("no txid bucket")
+		}
+		// clients resend states we didn't ack.  If we already have it, the
+		// elkrem's already in there too, so there's nothing to do.
+		if txidbkt.Get(m.ParTxid[:16]) != nil {
+			return nil
+		}
 
 		// first get the channel bucket, update the elkrem and read the idx
 		allChanbkt := btx.Bucket(BUCKETChandata)
@@ -217,13 +279,14 @@ func (w *WatchTower) UpdateChannel(m lnutil.WatchStateMsg) error {
 			return fmt.Errorf("channel %x has no index", m.DestPKH)
 		}
 
+		err = w.charge(btx, m.PeerIdx, false)
+		if err != nil {
+			return err
+		}
+
 		// we've updated the elkrem and saved it, so done with channel bucket.
 		// next go to txid bucket to save
 
-		txidbkt := btx.Bucket(BUCKETTxid)
-		if txidbkt == nil {
-			return fmt.Errorf("no txid bucket")
-		}
 		// create the sigIdx 74 bytes.  A little ugly but only called here and
 		// pretty quick.  Maybe make a function for this.
 		sigIdxBytes := make([]byte, 74)
@@ -239,9 +302,124 @@ func (w *WatchTower) UpdateChannel(m lnutil.WatchStateMsg) error {
 	})
 }
 
-// TODO implement DeleteChannel.  Would be nice to delete old channels.
+// AddBlob saves an encrypted state from a client under its hint.
+func (w *WatchTower) AddBlob(m lnutil.WatchBlobMsg) error {
+	_, ok := w.Hooks[m.CoinType]
+	if !ok {
+		return fmt.Errorf("Cointype %d not supported", m.CoinType)
+	}
+
+	return w.WatchDB.Update(func(btx kvdb.Tx) error {
+		blobBkt := btx.Bucket(BUCKETBlob)
+		if blobBkt == nil {
+			return fmt.Errorf("no blob bucket")
+		}
+		hintBkt, err := blobBkt.CreateBucketIfNotExists(m.Hint[:])
+		if err != nil {
+			return err
+		}
+		// a resend of one we have doesn't cost anything
+		if bytes.Equal(hintBkt.Get(lnutil.U32tB(m.PeerIdx)), m.Blob) {
+			return nil
+		}
+		err = w.charge(btx, m.PeerIdx, false)
+		if err != nil {
+			return err
+		}
+		w.Watching = true
+		return hintBkt.Put(lnutil.U32tB(m.PeerIdx), m.Blob)
+	})
+}
+
+// DeleteChannel removes a closed channel and every state saved for it.
+// Only the client knows the pubkey behind the channel's PKH, so revealing
+// it shows the request is really from them.
 func (w *WatchTower) DeleteChannel(m lnutil.WatchDelMsg) error {
-	return nil
+	if !bytes.Equal(btcutil.Hash160(m.RevealPK[:]), m.DestPKH[:]) {
+		return fmt.Errorf("pubkey %x doesn't match pkh %x", m.RevealPK, m.DestPKH)
+	}
+
+	return w.WatchDB.Update(func(btx kvdb.Tx) error {
+		mapBucket := btx.Bucket(BUCKETPKHMap)
+		if mapBucket == nil {
+			return fmt.Errorf("no PKHmap bucket")
+		}
+		allChanbkt := btx.Bucket(BUCKETChandata)
+		if allChanbkt == nil {
+			return fmt.Errorf("no Chandata bucket")
+		}
+		txidbkt := btx.Bucket(BUCKETTxid)
+		if txidbkt == nil {
+			return fmt.Errorf("no txid bucket")
+		}
+		chanBucket := allChanbkt.Bucket(m.DestPKH[:])
+		if chanBucket == nil {
+			return fmt.Errorf("no bucket for channel %x", m.DestPKH)
+		}
+		cIdxBytes := chanBucket.Get(KEYIdx)
+		if cIdxBytes == nil {
+			return fmt.Errorf("channel %x has no index", m.DestPKH)
+		}
+		cIdxBytes = append([]byte(nil), cIdxBytes...)
+
+		// txids aren't indexed by channel, so go through all of them.
+		// Slow, but channels don't close that often.
+		var dels [][]byte
+		err := txidbkt.ForEach(func(k, v []byte) error {
+			if len(v) >= 4 && bytes.Equal(v[:4], cIdxBytes) {
+				dels = append(dels, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range dels {
+			err = txidbkt.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("deleted channel %x (pkh %x), %d states\n",
+			cIdxBytes, m.DestPKH, len(dels))
+
+		err = allChanbkt.DeleteBucket(m.DestPKH[:])
+		if err != nil {
+			return err
+		}
+		return mapBucket.Delete(cIdxBytes)
+	})
+}
+
+// DeleteBlobs removes blobs a client sent.  Blobs are kept per client, so
+// a client can only delete its own.
+func (w *WatchTower) DeleteBlobs(m lnutil.WatchBlobDelMsg) error {
+	return w.WatchDB.Update(func(btx kvdb.Tx) error {
+		blobBkt := btx.Bucket(BUCKETBlob)
+		if blobBkt == nil {
+			return fmt.Errorf("no blob bucket")
+		}
+		for _, hint := range m.Hints {
+			hintBkt := blobBkt.Bucket(hint[:])
+			if hintBkt == nil {
+				continue
+			}
+			err := hintBkt.Delete(lnutil.U32tB(m.PeerIdx))
+			if err != nil {
+				return err
+			}
+			// if nobody else has a blob here, stop watching for it
+			k, _ := hintBkt.Cursor().First()
+			if k == nil {
+				err = blobBkt.DeleteBucket(hint[:])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		log.Printf("deleted %d blobs from peer %d\n", len(m.Hints), m.PeerIdx)
+		return nil
+	})
 }
 
 // MatchTxid takes in a txid, checks against the DB, and if there's a hit, returns a
@@ -252,20 +430,17 @@ func (w *WatchTower) MatchTxids(
 	var err error
 	var hits []chainhash.Hash
 
-	err = w.WatchDB.View(func(btx *bolt.Tx) error {
-		// open the big bucket
-		txidbkt := btx.Bucket(BUCKETTxid)
-		if txidbkt == nil {
-			return fmt.Errorf("no txid bucket")
-		}
-
+	err = w.WatchDB.View(func(btx kvdb.Tx) error {
 		for i, txid := range txids {
 			if i == 0 {
 				// coinbase tx cannot be a bad tx
 				continue
 			}
-			b := txidbkt.Get(txid[:16])
-			if b != nil {
+			hit, err := txidWatched(btx, txid)
+			if err != nil {
+				return err
+			}
+			if hit {
 				log.Printf("zomg hit %s\n", txid.String())
 				hits = append(hits, txid)
 			}
@@ -275,12 +450,31 @@ func (w *WatchTower) MatchTxids(
 	return hits, err
 }
 
+// txidWatched says if a txid is one we're watching for, by signature or
+// by blob.
+func txidWatched(btx kvdb.Tx, txid chainhash.Hash) (bool, error) {
+	// open the big buckets
+	txidbkt := btx.Bucket(BUCKETTxid)
+	if txidbkt == nil {
+		return false, fmt.Errorf("no txid bucket")
+	}
+	blobbkt := btx.Bucket(BUCKETBlob)
+	if blobbkt == nil {
+		return false, fmt.Errorf("no blob bucket")
+	}
+	return txidbkt.Get(txid[:16]) != nil || blobbkt.Bucket(txid[:16]) != nil, nil
+}
+
+// BlockHandler checks each block for bad txs to send justice for.  depth is
+// the deepest reorg of the coin's chain to look out for.
 func (w *WatchTower) BlockHandler(
-	cointype uint32, bchan chan *wire.MsgBlock) {
+	cointype uint32, depth int32, bchan chan *wire.MsgBlock) {
 
 	log.Printf("-- started BlockHandler type %d, block channel cap %d\n",
 		cointype, cap(bchan))
 
+	// hashes of the last depth blocks, to see reorgs by
+	var recent []chainhash.Hash
 	for {
 		// block here, take in blocks
 		block := <-bchan
@@ -288,6 +482,22 @@ func (w *WatchTower) BlockHandler(
 		log.Printf("tower check block %s %d txs\n",
 			block.BlockHash().String(), len(block.Transactions))
 
+		// justice done with in blocks that got reorged out isn't anymore
+		var gone []chainhash.Hash
+		recent, gone = followChain(recent, block.Header, depth)
+		if len(gone) > 0 {
+			err := w.rearmJustice(cointype, gone)
+			if err != nil {
+				log.Printf("BlockHandler/rearmJustice error: %s", err.Error())
+			}
+		}
+
+		// see if justice txs we've sent are in, or need more fee
+		err := w.bumpJustice(cointype, block, depth)
+		if err != nil {
+			log.Printf("BlockHandler/bumpJustice error: %s", err.Error())
+		}
+
 		// get all txids from the blocks
 		txids, err := block.TxHashes()
 		if err != nil {
@@ -310,16 +520,7 @@ func (w *WatchTower) BlockHandler(
 					// probably OK because this rarely hapens
 					curTxid := tx.TxHash()
 					if curTxid.IsEqual(&hitTxid) {
-						justice, err := w.BuildJusticeTx(cointype, tx)
-						if err != nil {
-							log.Printf("BuildJusticeTx error: %s", err.Error())
-						}
-						log.Printf("made & sent out justice tx %s\n",
-							justice.TxHash().String())
-						err = w.Hooks[cointype].PushTx(justice)
-						if err != nil {
-							log.Printf("BuildJusticeTx error: %s", err.Error())
-						}
+						w.doJustice(cointype, tx)
 					}
 				}
 			}
@@ -329,13 +530,51 @@ func (w *WatchTower) BlockHandler(
 	// never returns
 }
 
+// MempoolHandler looks at unconfirmed txs as the hook hears about them, so
+// justice goes out as soon as a bad tx does, not a block later.  When the
+// bad tx gets in a block, its justice is already pending, so nothing new
+// gets built.
+func (w *WatchTower) MempoolHandler(cointype uint32, mchan chan *wire.MsgTx) {
+	log.Printf("-- started MempoolHandler type %d\n", cointype)
+	for tx := range mchan {
+		txid := tx.TxHash()
+		var hit bool
+		err := w.WatchDB.View(func(btx kvdb.Tx) error {
+			var err error
+			hit, err = txidWatched(btx, txid)
+			return err
+		})
+		if err != nil {
+			log.Printf("MempoolHandler error: %s", err.Error())
+			continue
+		}
+		if hit {
+			log.Printf("zomg unconfirmed tx %s matched db\n", txid.String())
+			w.doJustice(cointype, tx)
+		}
+	}
+}
+
+// doJustice builds the justice txs for a bad tx and sends them out.
+func (w *WatchTower) doJustice(cointype uint32, badTx *wire.MsgTx) {
+	justices, err := w.justiceFor(cointype, badTx)
+	if err != nil {
+		log.Printf("BuildJusticeTx error: %s", err.Error())
+		return
+	}
+	err = w.sendJustice(cointype, justices)
+	if err != nil {
+		log.Printf("BuildJusticeTx error: %s", err.Error())
+	}
+}
+
 // Status returns a string describing what's in the watchtower.
 /*
 func (w *WatchTower) Status() (string, error) {
 	var err error
 	var s string
 
-	err = w.WatchDB.View(func(btx *bolt.Tx) error {
+	err = w.WatchDB.View(func(btx kvdb.Tx) error {
 		// open the big bucket
 		txidbkt := btx.Bucket(BUCKETTxid)
 		if txidbkt == nil {
@@ -3,11 +3,13 @@ package watchtower
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
+	"sync"
 
-	"github.com/boltdb/bolt"
+	"github.com/mit-dci/lit/chainhook"
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/kvdb"
 	"github.com/mit-dci/lit/lnutil"
-	"github.com/mit-dci/lit/uspv"
 )
 
 type Watcher interface {
@@ -16,7 +18,10 @@ type Watcher interface {
 	// 2 of the functions: PushTx() and RawBlocks()
 	// Blocks come in from the chainhook, and justice transactions come out.
 	// The uint32 is the cointype, the string is the folder to put all db files.
-	HookLink(string, *coinparam.Params, uspv.ChainHook) error
+	HookLink(string, *coinparam.Params, chainhook.ChainHook) error
+
+	// The cointypes of the chains being watched; none if the tower's off
+	CoinTypes() []uint32
 
 	// New Channel to watch
 	NewChannel(lnutil.WatchDescMsg) error
@@ -27,6 +32,29 @@ type Watcher interface {
 	// Delete a channel being watched
 	DeleteChannel(lnutil.WatchDelMsg) error
 
+	// Add an encrypted state to watch for, without knowing what channel
+	AddBlob(lnutil.WatchBlobMsg) error
+
+	// Delete encrypted states a client doesn't need watched anymore
+	DeleteBlobs(lnutil.WatchBlobDelMsg) error
+
+	// How many blocks a justice tx gets to confirm before the tower sends
+	// a higher fee version
+	SetBumpBlocks(int32)
+
+	// What the tower charges, and the accounts of who's paid it
+	SetTerms(FeeTerms)
+	GetTerms() FeeTerms
+	Credit(uint32, int64, [36]byte, uint64) error
+	GetAccount(uint32) (Account, error)
+	Ledger() ([]Account, error)
+
+	// The db everything's kept in; nil till a chain's linked
+	DB() kvdb.DB
+
+	// How many justice txs the tower has sent since startup
+	JusticeSent() int64
+
 	// Later on, allow users to recover channel state from
 	// the data in a watcher.  Like if they wipe their ln.db files but
 	// still have their keys.
@@ -34,9 +62,13 @@ type Watcher interface {
 
 // The main watchtower struct
 type WatchTower struct {
+	// justice txs sent, for metrics.  First so it's 64-bit aligned for
+	// atomic access.
+	justiceSent int64
+
 	Path string // where the DB goes?  needed?
 
-	WatchDB *bolt.DB // single DB with everything in it
+	WatchDB kvdb.DB // single DB with everything in it
 	// much more efficient to have a separate DB for each cointype
 	// ... but that's less anonymous.  To get that efficiency; make a bunch of
 	// towers, I guess.
@@ -46,21 +78,26 @@ type WatchTower struct {
 
 	SyncHeight int32 // last block we've sync'd to.  Not needed?
 
+	terms    FeeTerms // what clients pay for channels and states
+	termsMtx sync.Mutex
+
+	BumpBlocks int32 // blocks a justice tx gets to confirm before a fee bump
+
 	// map of cointypes to chainhooks
-	Hooks map[uint32]uspv.ChainHook
+	Hooks map[uint32]chainhook.ChainHook
 }
 
 // Chainlink is the connection between the watchtower and the blockchain
 // Takes in a channel of blocks, and the cointype.  Immediately returns
 // a channel which it will send justice transactions to.
 func (w *WatchTower) HookLink(dbPath string, param *coinparam.Params,
-	hook uspv.ChainHook) error {
+	hook chainhook.ChainHook) error {
 
 	cointype := param.HDCoinType
 
 	// if the hooks map hasn't been initialized, make it. also open DB
 	if len(w.Hooks) == 0 {
-		w.Hooks = make(map[uint32]uspv.ChainHook)
+		w.Hooks = make(map[uint32]chainhook.ChainHook)
 
 		towerDBName := filepath.Join(dbPath, "watch.db")
 		err := w.OpenDB(towerDBName)
@@ -79,11 +116,35 @@ func (w *WatchTower) HookLink(dbPath string, param *coinparam.Params,
 	// only need this for the pushTx() method
 	w.Hooks[cointype] = hook
 
-	go w.BlockHandler(cointype, hook.RawBlocks())
+	go w.BlockHandler(cointype, param.ReorgDepth(), hook.RawBlocks())
+
+	// and unconfirmed txs, if the hook can see them
+	mh, ok := hook.(chainhook.MempoolHook)
+	if ok {
+		mchan := mh.MempoolTxs()
+		if mchan != nil {
+			go w.MempoolHandler(cointype, mchan)
+		}
+	}
 
 	return nil
 }
 
+// CoinTypes returns the cointypes the tower is linked to, in order.
+func (w *WatchTower) CoinTypes() []uint32 {
+	coins := make([]uint32, 0, len(w.Hooks))
+	for c := range w.Hooks {
+		coins = append(coins, c)
+	}
+	sort.Slice(coins, func(i, j int) bool { return coins[i] < coins[j] })
+	return coins
+}
+
+// DB gives the tower's db, if it's open.
+func (w *WatchTower) DB() kvdb.DB {
+	return w.WatchDB
+}
+
 // 2 structs used in the DB: IdxSigs and ChanStatic
 
 // IdxSig is what we save in the DB for each txid